@@ -0,0 +1,451 @@
+// Package adminclient is a typed Go client for the chaos-proxy admin API
+// described in api/openapi.json. It is kept in sync with the spec by hand;
+// TestSpecCoverage fails when the two drift apart.
+package adminclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventConnectionOpen  EventType = "connection_open"
+	EventConnectionClose EventType = "connection_close"
+	EventConnectionError EventType = "connection_error"
+	EventFault           EventType = "fault"
+	EventTLSClientHello  EventType = "tls_client_hello"
+)
+
+// Event mirrors the Event schema in the OpenAPI spec.
+type Event struct {
+	Time          time.Time `json:"time"`
+	Type          EventType `json:"type"`
+	Port          int       `json:"port"`
+	Route         string    `json:"route,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Client        string    `json:"client,omitempty"`
+	Conn          uint64    `json:"conn,omitempty"`
+	Upstream      string    `json:"upstream,omitempty"`
+	Fault         string    `json:"fault,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+	BytesToClient int64     `json:"bytesToClient,omitempty"`
+	BytesToServer int64     `json:"bytesToServer,omitempty"`
+	TLS           *TLSInfo  `json:"tls,omitempty"`
+}
+
+// TLSInfo mirrors the TLSInfo schema in the OpenAPI spec.
+type TLSInfo struct {
+	ServerName   string   `json:"serverName,omitempty"`
+	ALPN         []string `json:"alpn,omitempty"`
+	Version      string   `json:"version"`
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+}
+
+// Client talks to one chaos-proxy admin listener.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token is sent as a bearer token, for a proxy whose config sets
+	// adminToken or tenants: the admin token, or a tenant's, which only
+	// reaches the tenant's routes.
+	Token string
+}
+
+// New returns a client for the admin API at baseURL (e.g. "http://127.0.0.1:9900").
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// APIError is returned when the admin API answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// send sends req with the client's token, if it has one.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// doJSON sends body (when non-nil) as JSON and decodes the response into out.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("cannot decode response: %w", err)
+	}
+	return nil
+}
+
+// ChaosScale mirrors the ChaosScale schema in the OpenAPI spec.
+type ChaosScale struct {
+	Scale float64 `json:"scale"`
+}
+
+// GetChaosScale returns the global chaos intensity multiplier (operation
+// getChaosScale).
+func (c *Client) GetChaosScale(ctx context.Context) (float64, error) {
+	var out ChaosScale
+	if err := c.doJSON(ctx, http.MethodGet, "/chaos-scale", nil, &out); err != nil {
+		return 0, err
+	}
+	return out.Scale, nil
+}
+
+// SetChaosScale changes the global chaos intensity multiplier (operation
+// setChaosScale). 0 turns drops and latency off; 2 doubles them.
+func (c *Client) SetChaosScale(ctx context.Context, scale float64) error {
+	var out ChaosScale
+	return c.doJSON(ctx, http.MethodPut, "/chaos-scale", ChaosScale{Scale: scale}, &out)
+}
+
+// Route mirrors the Route schema in the OpenAPI spec.
+type Route struct {
+	Port     int      `json:"port"`
+	Name     string   `json:"name,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Upstream string   `json:"upstream"`
+	Enabled  bool     `json:"enabled"`
+	Chaos    bool     `json:"chaos"`
+
+	Connections     int64 `json:"connections"`
+	OpenConnections int64 `json:"openConnections"`
+	BytesToClient   int64 `json:"bytesToClient"`
+	BytesToServer   int64 `json:"bytesToServer"`
+}
+
+// RouteUpdate mirrors the RouteUpdate schema in the OpenAPI spec. Nil fields
+// are left as they are.
+type RouteUpdate struct {
+	Enabled *bool `json:"enabled,omitempty"`
+	Chaos   *bool `json:"chaos,omitempty"`
+}
+
+// ListRoutes returns every configured route (operation listRoutes).
+func (c *Client) ListRoutes(ctx context.Context) ([]Route, error) {
+	var out []Route
+	if err := c.doJSON(ctx, http.MethodGet, "/routes", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateRoute switches the route on port, or just its chaos, on or off
+// (operation updateRoute). Open connections are not affected.
+func (c *Client) UpdateRoute(ctx context.Context, port int, update RouteUpdate) (Route, error) {
+	var out Route
+	err := c.doJSON(ctx, http.MethodPut, "/routes/"+strconv.Itoa(port), update, &out)
+	return out, err
+}
+
+// GetRoute returns the route on port with its live traffic counters
+// (operation getRoute).
+func (c *Client) GetRoute(ctx context.Context, port int) (Route, error) {
+	var out Route
+	err := c.doJSON(ctx, http.MethodGet, "/routes/"+strconv.Itoa(port), nil, &out)
+	return out, err
+}
+
+// AddRoute starts a new route (operation addRoute). route is marshalled to
+// a route object as written in a config file, flat or with a chaos section,
+// such as a map or a struct with JSON tags. It returns the route's settings
+// as the proxy took them.
+func (c *Client) AddRoute(ctx context.Context, route any) (json.RawMessage, error) {
+	var out json.RawMessage
+	err := c.doJSON(ctx, http.MethodPost, "/routes", route, &out)
+	return out, err
+}
+
+// RemoveRoute stops the route on port (operation removeRoute). Its open
+// connections are left to finish.
+func (c *Client) RemoveRoute(ctx context.Context, port int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+"/routes/"+strconv.Itoa(port), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetRouteConfig returns the settings of the route on port (operation
+// getRouteConfig).
+func (c *Client) GetRouteConfig(ctx context.Context, port int) (json.RawMessage, error) {
+	var out json.RawMessage
+	err := c.doJSON(ctx, http.MethodGet, "/routes/"+strconv.Itoa(port)+"/config", nil, &out)
+	return out, err
+}
+
+// PatchRouteConfig changes settings of the route on port (operation
+// patchRouteConfig). patch is marshalled like AddRoute's route; settings it
+// leaves out are kept. It returns the route's settings after the change.
+func (c *Client) PatchRouteConfig(ctx context.Context, port int, patch any) (json.RawMessage, error) {
+	var out json.RawMessage
+	err := c.doJSON(ctx, http.MethodPatch, "/routes/"+strconv.Itoa(port)+"/config", patch, &out)
+	return out, err
+}
+
+// Connection mirrors the Connection schema in the OpenAPI spec.
+type Connection struct {
+	ID            uint64    `json:"id"`
+	Port          int       `json:"port"`
+	Route         string    `json:"route,omitempty"`
+	Client        string    `json:"client"`
+	Upstream      string    `json:"upstream"`
+	Started       time.Time `json:"started"`
+	AgeMs         int64     `json:"ageMs"`
+	BytesToClient int64     `json:"bytesToClient"`
+	BytesToServer int64     `json:"bytesToServer"`
+	Chaos         []string  `json:"chaos"`
+}
+
+// ListConnections returns the open connections of every route (operation
+// listConnections).
+func (c *Client) ListConnections(ctx context.Context) ([]Connection, error) {
+	var out []Connection
+	if err := c.doJSON(ctx, http.MethodGet, "/connections", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KillConnection resets the open connection with the given ID (operation
+// killConnection).
+func (c *Client) KillConnection(ctx context.Context, id uint64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+"/connections/"+strconv.FormatUint(id, 10), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ListRouteConnections returns the open connections of the route on port
+// (operation listRouteConnections).
+func (c *Client) ListRouteConnections(ctx context.Context, port int) ([]Connection, error) {
+	var out []Connection
+	if err := c.doJSON(ctx, http.MethodGet, "/routes/"+strconv.Itoa(port)+"/connections", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KillRouteConnections resets every open connection of the route on port
+// and returns how many there were (operation killRouteConnections).
+func (c *Client) KillRouteConnections(ctx context.Context, port int) (int, error) {
+	var out struct {
+		Killed int `json:"killed"`
+	}
+	err := c.doJSON(ctx, http.MethodDelete, "/routes/"+strconv.Itoa(port)+"/connections", nil, &out)
+	return out.Killed, err
+}
+
+// Report mirrors the Report schema in the OpenAPI spec.
+type Report struct {
+	Started    time.Time     `json:"started"`
+	DurationMs int64         `json:"durationMs"`
+	Routes     []RouteReport `json:"routes"`
+}
+
+// RouteReport mirrors the RouteReport schema in the OpenAPI spec.
+type RouteReport struct {
+	Port          int                    `json:"port"`
+	Name          string                 `json:"name,omitempty"`
+	Upstream      string                 `json:"upstream"`
+	Connections   int64                  `json:"connections"`
+	Failures      int64                  `json:"failures"`
+	Failovers     int64                  `json:"failovers"`
+	BytesToClient int64                  `json:"bytesToClient"`
+	BytesToServer int64                  `json:"bytesToServer"`
+	Faults        map[string]int64       `json:"faults"`
+	CloseReasons  map[string]int64       `json:"closeReasons"`
+	InjectedDelay map[string]DelayReport `json:"injectedDelay"`
+}
+
+// DelayReport mirrors the DelayReport schema in the OpenAPI spec.
+type DelayReport struct {
+	Count int64   `json:"count"`
+	P50Ms float64 `json:"p50Ms"`
+	P90Ms float64 `json:"p90Ms"`
+	P99Ms float64 `json:"p99Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// GetReport returns the run's summary so far, per route served (operation
+// getReport).
+func (c *Client) GetReport(ctx context.Context) (Report, error) {
+	var out Report
+	err := c.doJSON(ctx, http.MethodGet, "/report", nil, &out)
+	return out, err
+}
+
+// Reload modes, mirroring the mode parameter of reloadConfig.
+const (
+	ReloadAll   = "all"
+	ReloadValid = "valid"
+)
+
+// ReloadResult mirrors the ReloadResult schema in the OpenAPI spec.
+type ReloadResult struct {
+	Time    time.Time     `json:"time"`
+	Mode    string        `json:"mode"`
+	Trigger string        `json:"trigger"`
+	Applied bool          `json:"applied"`
+	Error   string        `json:"error,omitempty"`
+	Routes  []RouteReload `json:"routes"`
+}
+
+// RouteReload mirrors the RouteReload schema in the OpenAPI spec.
+type RouteReload struct {
+	Port    int    `json:"port"`
+	Name    string `json:"name,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Reload reloads the proxy's config files in mode, ReloadAll or
+// ReloadValid (operation reloadConfig). A reload that couldn't be applied
+// returns its result, saying why per route, along with an *APIError.
+func (c *Client) Reload(ctx context.Context, mode string) (ReloadResult, error) {
+	var out ReloadResult
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/reload?mode="+url.QueryEscape(mode), nil)
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.send(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return out, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, fmt.Errorf("cannot decode response: %w", err)
+	}
+	if !out.Applied {
+		return out, &APIError{StatusCode: resp.StatusCode, Message: out.Error}
+	}
+	return out, nil
+}
+
+// LastReload returns the result of the latest config reload (operation
+// getLastReload).
+func (c *Client) LastReload(ctx context.Context) (ReloadResult, error) {
+	var out ReloadResult
+	err := c.doJSON(ctx, http.MethodGet, "/reload/last", nil, &out)
+	return out, err
+}
+
+// EventStream reads events from an open /events stream.
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// StreamEvents opens the live event stream (operation streamEvents). Close
+// the stream, or cancel ctx, when done.
+func (c *Client) StreamEvents(ctx context.Context) (*EventStream, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}, nil
+}
+
+// Next blocks until the next event arrives. It returns io.EOF when the
+// proxy closes the stream.
+func (s *EventStream) Next() (Event, error) {
+	for s.scanner.Scan() {
+		data, ok := strings.CutPrefix(s.scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return Event{}, fmt.Errorf("cannot decode event: %w", err)
+		}
+		return e, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
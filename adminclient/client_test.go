@@ -0,0 +1,403 @@
+package adminclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/admin"
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// operations lists every spec operation the client implements.
+var operations = map[string]string{
+	"streamEvents":         "GET /events",
+	"getChaosScale":        "GET /chaos-scale",
+	"setChaosScale":        "PUT /chaos-scale",
+	"listRoutes":           "GET /routes",
+	"addRoute":             "POST /routes",
+	"getRoute":             "GET /routes/{port}",
+	"updateRoute":          "PUT /routes/{port}",
+	"removeRoute":          "DELETE /routes/{port}",
+	"getRouteConfig":       "GET /routes/{port}/config",
+	"patchRouteConfig":     "PATCH /routes/{port}/config",
+	"listRouteConnections": "GET /routes/{port}/connections",
+	"killRouteConnections": "DELETE /routes/{port}/connections",
+	"listConnections":      "GET /connections",
+	"killConnection":       "DELETE /connections/{id}",
+	"getReport":            "GET /report",
+	"reloadConfig":         "POST /reload",
+	"getLastReload":        "GET /reload/last",
+}
+
+func TestSpecCoverage(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "api", "openapi.json"))
+	if err != nil {
+		t.Fatalf("failed to read spec: %v", err)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("failed to parse spec: %v", err)
+	}
+
+	inSpec := make(map[string]string)
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			inSpec[op.OperationID] = strings.ToUpper(method) + " " + path
+		}
+	}
+
+	for id, route := range inSpec {
+		if got, ok := operations[id]; !ok {
+			t.Errorf("spec operation %s (%s) has no client method", id, route)
+		} else if got != route {
+			t.Errorf("operation %s: client uses %s, spec says %s", id, got, route)
+		}
+	}
+	for id := range operations {
+		if _, ok := inSpec[id]; !ok {
+			t.Errorf("client operation %s is missing from the spec", id)
+		}
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Events: bus}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := New(server.URL).StreamEvents(ctx)
+	if err != nil {
+		t.Fatalf("StreamEvents() error = %v", err)
+	}
+	defer stream.Close()
+
+	bus.Publish(events.Event{Type: events.Fault, Port: 8080, Fault: "drop"})
+
+	e, err := stream.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if e.Type != EventFault || e.Port != 8080 || e.Fault != "drop" {
+		t.Errorf("Next() = %+v, want fault drop on 8080", e)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Events: events.NewBus()}))
+	defer server.Close()
+
+	client := New(server.URL)
+	client.BaseURL += "/missing"
+	_, err := client.StreamEvents(context.Background())
+
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("StreamEvents() error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 404 {
+		t.Errorf("StatusCode = %d, want 404", apiErr.StatusCode)
+	}
+}
+
+func TestChaosScale(t *testing.T) {
+	intensity := chaos.NewIntensity(1)
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Intensity: intensity}))
+	defer server.Close()
+
+	client := New(server.URL)
+	ctx := context.Background()
+
+	if err := client.SetChaosScale(ctx, 2.5); err != nil {
+		t.Fatalf("SetChaosScale() error = %v", err)
+	}
+	if got := intensity.Scale(); got != 2.5 {
+		t.Errorf("proxy scale = %v, want 2.5", got)
+	}
+
+	got, err := client.GetChaosScale(ctx)
+	if err != nil {
+		t.Fatalf("GetChaosScale() error = %v", err)
+	}
+	if got != 2.5 {
+		t.Errorf("GetChaosScale() = %v, want 2.5", got)
+	}
+
+	var apiErr *APIError
+	if err := client.SetChaosScale(ctx, -1); !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Errorf("SetChaosScale(-1) error = %v, want a 400 APIError", err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	control := proxy.NewRouteControl(config.RouteConfig{Name: "orders", Tags: []string{"staging"}, LocalPort: 8080, Upstream: "127.0.0.1:9000"})
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Routes: func() []*proxy.RouteControl { return []*proxy.RouteControl{control} }}))
+	defer server.Close()
+
+	client := New(server.URL)
+	ctx := context.Background()
+
+	off := false
+	got, err := client.UpdateRoute(ctx, 8080, RouteUpdate{Chaos: &off})
+	if err != nil {
+		t.Fatalf("UpdateRoute() error = %v", err)
+	}
+	want := Route{Port: 8080, Name: "orders", Tags: []string{"staging"}, Upstream: "127.0.0.1:9000", Enabled: true, Chaos: false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UpdateRoute() = %+v, want %+v", got, want)
+	}
+	if control.ChaosEnabled() {
+		t.Error("proxy route chaos still on after UpdateRoute()")
+	}
+
+	routes, err := client.ListRoutes(ctx)
+	if err != nil {
+		t.Fatalf("ListRoutes() error = %v", err)
+	}
+	if !reflect.DeepEqual(routes, []Route{want}) {
+		t.Errorf("ListRoutes() = %+v, want [%+v]", routes, want)
+	}
+
+	var apiErr *APIError
+	if _, err := client.UpdateRoute(ctx, 8081, RouteUpdate{Chaos: &off}); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("UpdateRoute(8081) error = %v, want a 404 APIError", err)
+	}
+}
+
+// routeTable is an in-memory set of routes the admin handler lists and
+// changes.
+type routeTable struct {
+	controls []*proxy.RouteControl
+}
+
+func (r *routeTable) AddRoute(route config.RouteConfig) error {
+	r.controls = append(r.controls, proxy.NewRouteControl(route))
+	return nil
+}
+
+func (r *routeTable) ReplaceRoute(port int, route config.RouteConfig) error {
+	for i, control := range r.controls {
+		if control.Route().LocalPort == port {
+			r.controls[i] = proxy.NewRouteControl(route)
+			return nil
+		}
+	}
+	return admin.ErrNoRoute
+}
+
+func (r *routeTable) RemoveRoute(port int) error {
+	for i, control := range r.controls {
+		if control.Route().LocalPort == port {
+			r.controls = append(r.controls[:i], r.controls[i+1:]...)
+			return nil
+		}
+	}
+	return admin.ErrNoRoute
+}
+
+func TestRouteChanges(t *testing.T) {
+	table := &routeTable{}
+	server := httptest.NewServer(admin.NewHandler(admin.Options{
+		Routes:  func() []*proxy.RouteControl { return table.controls },
+		Changes: table,
+	}))
+	defer server.Close()
+
+	client := New(server.URL)
+	ctx := context.Background()
+
+	route := map[string]any{"localPort": 8080, "upstream": "127.0.0.1:9000", "chaos": map[string]any{"drop": map[string]any{"rate": 0.5}}}
+	if _, err := client.AddRoute(ctx, route); err != nil {
+		t.Fatalf("AddRoute() error = %v", err)
+	}
+	got, err := client.GetRoute(ctx, 8080)
+	if err != nil {
+		t.Fatalf("GetRoute() error = %v", err)
+	}
+	want := Route{Port: 8080, Upstream: "127.0.0.1:9000", Enabled: true, Chaos: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRoute() = %+v, want %+v", got, want)
+	}
+
+	raw, err := client.PatchRouteConfig(ctx, 8080, map[string]any{"dropRate": 0.25})
+	if err != nil {
+		t.Fatalf("PatchRouteConfig() error = %v", err)
+	}
+	var patched config.RouteConfig
+	if err := json.Unmarshal(raw, &patched); err != nil {
+		t.Fatalf("PatchRouteConfig() returned %s: %v", raw, err)
+	}
+	if patched.DropRate != 0.25 || patched.Upstream != "127.0.0.1:9000" {
+		t.Errorf("PatchRouteConfig() = %+v, want dropRate 0.25 and the upstream kept", patched)
+	}
+	raw, err = client.GetRouteConfig(ctx, 8080)
+	if err != nil {
+		t.Fatalf("GetRouteConfig() error = %v", err)
+	}
+	var current config.RouteConfig
+	if err := json.Unmarshal(raw, &current); err != nil || !reflect.DeepEqual(current, patched) {
+		t.Errorf("GetRouteConfig() = %s, want %+v", raw, patched)
+	}
+
+	if err := client.RemoveRoute(ctx, 8080); err != nil {
+		t.Fatalf("RemoveRoute() error = %v", err)
+	}
+	var apiErr *APIError
+	if _, err := client.GetRoute(ctx, 8080); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("GetRoute() after RemoveRoute() error = %v, want a 404 APIError", err)
+	}
+	if err := client.RemoveRoute(ctx, 8080); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("RemoveRoute() twice error = %v, want a 404 APIError", err)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	control := proxy.NewRouteControl(config.RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9000"})
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Routes: func() []*proxy.RouteControl { return []*proxy.RouteControl{control} }}))
+	defer server.Close()
+
+	client := New(server.URL)
+	ctx := context.Background()
+
+	conns, err := client.ListConnections(ctx)
+	if err != nil || len(conns) != 0 {
+		t.Errorf("ListConnections() = %+v, %v, want no connections", conns, err)
+	}
+	conns, err = client.ListRouteConnections(ctx, 8080)
+	if err != nil || len(conns) != 0 {
+		t.Errorf("ListRouteConnections() = %+v, %v, want no connections", conns, err)
+	}
+	killed, err := client.KillRouteConnections(ctx, 8080)
+	if err != nil || killed != 0 {
+		t.Errorf("KillRouteConnections() = %d, %v, want 0 killed", killed, err)
+	}
+
+	var apiErr *APIError
+	if err := client.KillConnection(ctx, 1); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("KillConnection(1) error = %v, want a 404 APIError", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := proxy.Report{
+		DurationMs: 2000,
+		Routes: []proxy.RouteReport{{
+			Port:          8080,
+			Upstream:      "127.0.0.1:9090",
+			Connections:   4,
+			Faults:        map[string]int64{"drop": 1},
+			CloseReasons:  map[string]int64{"completed": 3, "drop": 1},
+			InjectedDelay: map[string]proxy.DelayReport{"latency": {Count: 3, P50Ms: 5, P90Ms: 9, P99Ms: 9, MaxMs: 9}},
+		}},
+	}
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Report: func() proxy.Report { return report }}))
+	defer server.Close()
+
+	got, err := New(server.URL).GetReport(context.Background())
+	if err != nil {
+		t.Fatalf("GetReport() error = %v", err)
+	}
+	if len(got.Routes) != 1 || got.Routes[0].CloseReasons["drop"] != 1 || got.Routes[0].InjectedDelay["latency"].P90Ms != 9 {
+		t.Errorf("GetReport() = %+v, want the proxy's report", got)
+	}
+}
+
+// reloader answers every reload with a result failing one route.
+type reloader struct{ last *admin.ReloadResult }
+
+func (r *reloader) Reload(mode, trigger string) (admin.ReloadResult, error) {
+	result := admin.ReloadResult{Mode: mode, Trigger: trigger, Applied: mode == admin.ReloadValid, Routes: []admin.RouteReload{
+		{Port: 8080, Outcome: admin.RouteAdded},
+		{Port: 8081, Outcome: admin.RouteFailed, Error: "bind: address already in use"},
+	}}
+	if !result.Applied {
+		result.Error = "a route failed to listen"
+	}
+	r.last = &result
+	return result, nil
+}
+
+func (r *reloader) LastReload() (admin.ReloadResult, bool) {
+	if r.last == nil {
+		return admin.ReloadResult{}, false
+	}
+	return *r.last, true
+}
+
+func TestReload(t *testing.T) {
+	server := httptest.NewServer(admin.NewHandler(admin.Options{Reloader: &reloader{}}))
+	defer server.Close()
+	client := New(server.URL)
+	ctx := context.Background()
+
+	var apiErr *APIError
+	if _, err := client.LastReload(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+		t.Errorf("LastReload() before any reload error = %v, want a 404 APIError", err)
+	}
+
+	got, err := client.Reload(ctx, ReloadAll)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 422 {
+		t.Errorf("Reload(all) error = %v, want a 422 APIError", err)
+	}
+	if got.Applied || len(got.Routes) != 2 || got.Routes[1].Outcome != "failed" {
+		t.Errorf("Reload(all) = %+v, want the failed reload's result", got)
+	}
+
+	got, err = client.Reload(ctx, ReloadValid)
+	if err != nil || !got.Applied || got.Trigger != "api" {
+		t.Fatalf("Reload(valid) = %+v, %v, want an applied reload", got, err)
+	}
+	last, err := client.LastReload(ctx)
+	if err != nil || !reflect.DeepEqual(last, got) {
+		t.Errorf("LastReload() = %+v, %v, want %+v", last, err, got)
+	}
+}
+
+func TestToken(t *testing.T) {
+	table := &routeTable{}
+	table.AddRoute(config.RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9000", Tenant: "orders"})
+	table.AddRoute(config.RouteConfig{LocalPort: 8081, Upstream: "127.0.0.1:9001", Tenant: "search"})
+	server := httptest.NewServer(admin.NewHandler(admin.Options{
+		Routes:  func() []*proxy.RouteControl { return table.controls },
+		Changes: table,
+		Access:  config.Access{Tenants: map[string]string{"orders": "orders-secret", "search": "search-secret"}},
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	var apiErr *APIError
+	if _, err := New(server.URL).ListRoutes(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Errorf("ListRoutes() without a token error = %v, want a 401 APIError", err)
+	}
+
+	client := New(server.URL)
+	client.Token = "orders-secret"
+	routes, err := client.ListRoutes(ctx)
+	if err != nil || len(routes) != 1 || routes[0].Port != 8080 {
+		t.Errorf("ListRoutes() = %+v, %v, want the orders route only", routes, err)
+	}
+	if err := client.RemoveRoute(ctx, 8081); !errors.As(err, &apiErr) || apiErr.StatusCode != 403 {
+		t.Errorf("RemoveRoute(8081) error = %v, want a 403 APIError", err)
+	}
+	if _, err := client.Reload(ctx, ReloadAll); !errors.As(err, &apiErr) || apiErr.StatusCode != 403 {
+		t.Errorf("Reload() error = %v, want a 403 APIError", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/admin"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// The routeSet makes the route changes of the admin API. A change lasts
+// until the next config reload, which moves the routes back to the config
+// file's.
+var _ admin.RouteChanges = (*routeSet)(nil)
+
+func (s *routeSet) AddRoute(route config.RouteConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.changeable(); err != nil {
+		return err
+	}
+	if err := s.validate(append(s.configs(), route)); err != nil {
+		return err
+	}
+	if err := portAvailable(route); err != nil {
+		return err
+	}
+	s.startTestServers([]config.RouteConfig{route})
+	r := s.serve(len(s.running), route, nil, false)
+	if err := r.listening(); err != nil {
+		r.stop()
+		s.served = slices.DeleteFunc(s.served, func(served *runningRoute) bool { return served == r })
+		return fmt.Errorf("%w: %v", admin.ErrConflict, err)
+	}
+	s.running = append(s.running, r)
+	return nil
+}
+
+func (s *routeSet) ReplaceRoute(port int, route config.RouteConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.changeable(); err != nil {
+		return err
+	}
+	i := s.find(port)
+	if i < 0 {
+		return fmt.Errorf("%w on port %d", admin.ErrNoRoute, port)
+	}
+	routes := s.configs()
+	routes[i] = route
+	if err := s.validate(routes); err != nil {
+		return err
+	}
+
+	r := s.running[i]
+	switch {
+	case reflect.DeepEqual(r.route, route):
+		return nil
+	case !r.needsRestart(route) && r.update(route, "the admin API"):
+		return nil
+	}
+	if route.LocalPort != r.route.LocalPort {
+		if err := portAvailable(route); err != nil {
+			return err
+		}
+	}
+	routeLogger(route).Info("route changed through the admin API, restarting its listener", "upstream", route.Upstream)
+	r.stop()
+	s.startTestServers([]config.RouteConfig{route})
+	next := s.serve(i, route, r, false)
+	if err := next.listening(); err != nil {
+		// The route goes back to the settings and listener it had.
+		next.stop()
+		s.running[i] = s.serveAgain(&routeChange{index: i, prev: r.route}, next)
+		if s.running[i] == nil {
+			s.running = slices.Delete(s.running, i, i+1)
+		}
+		return fmt.Errorf("%w: %v", admin.ErrConflict, err)
+	}
+	s.running[i] = next
+	return nil
+}
+
+func (s *routeSet) RemoveRoute(port int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.changeable(); err != nil {
+		return err
+	}
+	i := s.find(port)
+	if i < 0 {
+		return fmt.Errorf("%w on port %d", admin.ErrNoRoute, port)
+	}
+	r := s.running[i]
+	routeLogger(r.route).Info("route removed through the admin API, closing its listener", "upstream", r.route.Upstream)
+	r.stop()
+	s.running = slices.Delete(s.running, i, i+1)
+	return nil
+}
+
+// changeable reports why the routes can't be changed now, if they can't.
+// s.mu must be held.
+func (s *routeSet) changeable() error {
+	switch {
+	case s.closed:
+		return fmt.Errorf("%w: the proxy is shutting down", admin.ErrConflict)
+	case s.publisher != nil:
+		return fmt.Errorf("%w: the port file of -publish-ports lists the listeners of the config loaded at startup", admin.ErrConflict)
+	}
+	return nil
+}
+
+// find returns the index of the running route on port, or -1. s.mu must be
+// held.
+func (s *routeSet) find(port int) int {
+	return slices.IndexFunc(s.running, func(r *runningRoute) bool { return r.control.Port() == port })
+}
+
+// configs returns the settings of the running routes. s.mu must be held.
+func (s *routeSet) configs() []config.RouteConfig {
+	routes := make([]config.RouteConfig, len(s.running))
+	for i, r := range s.running {
+		routes[i] = r.route
+	}
+	return routes
+}
+
+// validate validates routes, the running routes as a change would leave
+// them, returning the validation messages in the error for the API to
+// answer with.
+func (s *routeSet) validate(routes []config.RouteConfig) error {
+	var messages bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&messages, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	if err := config.ValidateRoutes(routes, s.loadOptions, logger); err != nil {
+		return fmt.Errorf("%w:\n%s", admin.ErrInvalidRoute, strings.TrimSpace(messages.String()))
+	}
+	return nil
+}
+
+// portAvailable checks that route's port is free to listen on, so a route
+// added at runtime fails the request rather than failing to bind after.
+func portAvailable(route config.RouteConfig) error {
+	if route.LocalPort == 0 {
+		return nil
+	}
+	addr := net.JoinHostPort(route.ListenHost(), strconv.Itoa(route.LocalPort))
+	if err := tryListen(route, addr); err != nil {
+		return fmt.Errorf("%w: %v", admin.ErrConflict, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"slices"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// configPaths is the value of a -config flag, which may be repeated to merge
+// the routes of several config files.
+type configPaths []string
+
+// configFlag defines the -config flag on fs.
+func configFlag(fs *flag.FlagSet) *configPaths {
+	paths := new(configPaths)
+	fs.Var(paths, "config", "path to config file, a glob of them, \"-\" for stdin, or an http(s) URL to fetch it from; repeat to merge the routes of several configs")
+	return paths
+}
+
+// String returns the paths as they may be logged, with any password in a
+// URL masked.
+func (p *configPaths) String() string {
+	if p == nil {
+		return ""
+	}
+	redacted := make([]string, len(*p))
+	for i, path := range *p {
+		redacted[i] = config.RedactPath(path)
+	}
+	return strings.Join(redacted, ",")
+}
+
+func (p *configPaths) Set(path string) error {
+	if path == "" {
+		return errors.New("config path must not be empty")
+	}
+	if path == config.Stdin && p.hasStdin() {
+		return errors.New("stdin can only be read once")
+	}
+	*p = append(*p, path)
+	return nil
+}
+
+func (p *configPaths) hasStdin() bool {
+	return slices.Contains(*p, config.Stdin)
+}
+
+// load loads and merges the configs, validating their routes together.
+func (p *configPaths) load(opts config.LoadOptions) ([]config.RouteConfig, error) {
+	return config.LoadConfigsWithOptions(*p, opts)
+}
+
+// loadWithAccess is load that also returns who may use the admin API, from
+// the configs' adminToken and tenants.
+func (p *configPaths) loadWithAccess(opts config.LoadOptions) ([]config.RouteConfig, config.Access, error) {
+	return config.LoadConfigsWithAccess(*p, opts)
+}
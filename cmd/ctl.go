@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/adminclient"
+	"github.com/chasewilson/chaos-proxy/internal/logger"
+)
+
+// ctlTimeout bounds each admin API call of `chaos-proxy ctl`.
+const ctlTimeout = 10 * time.Second
+
+// Errors of ctl commands that are the operator's to fix.
+var (
+	// errUsage marks a ctl command given the wrong arguments.
+	errUsage = errors.New("usage")
+	// errUnknownRoute marks a route name no running route has.
+	errUnknownRoute = errors.New("unknown route")
+)
+
+// ctlCommand is one command of `chaos-proxy ctl`.
+type ctlCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(c *ctl, args []string) error
+}
+
+// ctlCommands lists the commands in the order an operator is likely to
+// reach for them.
+var ctlCommands = []ctlCommand{
+	{"list", "list", "list the running routes with their traffic", (*ctl).list},
+	{"get", "get <route>", "show a route's settings", (*ctl).get},
+	{"pause", "pause <route>", "reset new connections to a route, keeping its port", switcher(adminclient.RouteUpdate{Enabled: boolPtr(false)}, "paused")},
+	{"resume", "resume <route>", "accept connections to a paused route again", switcher(adminclient.RouteUpdate{Enabled: boolPtr(true)}, "resumed")},
+	{"chaos-off", "chaos-off <route>", "proxy new connections to a route without its chaos", switcher(adminclient.RouteUpdate{Chaos: boolPtr(false)}, "chaos switched off")},
+	{"chaos-on", "chaos-on <route>", "give new connections to a route its chaos again", switcher(adminclient.RouteUpdate{Chaos: boolPtr(true)}, "chaos switched on")},
+	{"set-latency", "set-latency <route> <duration>", "delay a route's new connections, such as 500ms; 0 removes the delay", (*ctl).setLatency},
+	{"set-drop", "set-drop <route> <rate>", "drop this fraction (0 to 1) of a route's new connections", (*ctl).setDrop},
+	{"scale", "scale [<factor>]", "show or set the global chaos scale", (*ctl).scale},
+	{"connections", "connections [<route>]", "list open connections, of every route or one", (*ctl).connections},
+	{"kill", "kill <route>", "reset every open connection of a route", (*ctl).kill},
+	{"kill-conn", "kill-conn <id>", "reset one open connection, by the ID connections lists", (*ctl).killConn},
+	{"remove", "remove <route>", "stop a route until the next config reload", (*ctl).remove},
+	{"reload", "reload [all|valid]", "reload the config files, all of them or only the routes that validate and listen", (*ctl).reload},
+	{"report", "report", "summarize the run so far: traffic, faults and injected delay per route", (*ctl).report},
+}
+
+// ctl drives a running proxy through its admin API.
+type ctl struct {
+	ctx    context.Context
+	client *adminclient.Client
+	json   bool
+	out    io.Writer
+}
+
+// runCtl implements `chaos-proxy ctl`: it runs one command against the
+// admin API of a running proxy. It returns the process exit status.
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	defaultAdmin := os.Getenv("CHAOS_PROXY_ADMIN")
+	if defaultAdmin == "" {
+		defaultAdmin = "127.0.0.1:9900"
+	}
+	adminAddr := fs.String("admin", defaultAdmin, "address of the proxy's admin API, as given to -admin (default from $CHAOS_PROXY_ADMIN)")
+	output := fs.String("output", "text", "output format: text or json")
+	token := fs.String("token", os.Getenv("CHAOS_PROXY_TOKEN"), "bearer token for a proxy whose config sets adminToken or tenants: the admin token, or a tenant's (default from $CHAOS_PROXY_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: chaos-proxy ctl [flags] <command> [arguments]\n\nCommands:\n")
+		w := tabwriter.NewWriter(fs.Output(), 0, 0, 2, ' ', 0)
+		for _, command := range ctlCommands {
+			fmt.Fprintf(w, "  %s\t%s\n", command.usage, command.help)
+		}
+		w.Flush()
+		fmt.Fprintf(fs.Output(), "\nA route is given by its name or its local port.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	logger.NewLogger(false, false)
+
+	if *output != "text" && *output != "json" {
+		slog.Error("invalid output format",
+			"output", *output,
+			"hint", "-output must be text or json")
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	i := slices.IndexFunc(ctlCommands, func(c ctlCommand) bool { return c.name == name })
+	if i < 0 {
+		names := make([]string, len(ctlCommands))
+		for i, c := range ctlCommands {
+			names[i] = c.name
+		}
+		slog.Error("unknown ctl command",
+			"command", name,
+			"valid_values", names,
+			"hint", "run chaos-proxy ctl -h for the commands and what they do")
+		return 2
+	}
+	command := ctlCommands[i]
+
+	baseURL := *adminAddr
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctlTimeout)
+	defer cancel()
+	client := adminclient.New(baseURL)
+	client.Token = *token
+	c := &ctl{ctx: ctx, client: client, json: *output == "json", out: os.Stdout}
+
+	err := command.run(c, fs.Args()[1:])
+	var apiErr *adminclient.APIError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		slog.Error("invalid arguments",
+			"command", name,
+			"error", err,
+			"hint", "usage: chaos-proxy ctl "+command.usage)
+		return 2
+	case errors.Is(err, errUnknownRoute):
+		slog.Error("route not found",
+			"command", name,
+			"error", err,
+			"hint", "chaos-proxy ctl list shows the running routes; give a route by its name or local port")
+		return 1
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		slog.Error("admin API refused the token",
+			"command", name,
+			"status", apiErr.StatusCode,
+			"error", apiErr.Message,
+			"hint", "pass -token, or set $CHAOS_PROXY_TOKEN, to the admin token or to the token of the tenant owning the route")
+		return 1
+	case errors.As(err, &apiErr):
+		slog.Error("admin API refused the command",
+			"command", name,
+			"status", apiErr.StatusCode,
+			"error", apiErr.Message,
+			"hint", "the proxy rejected the change; see the error for why")
+		return 1
+	default:
+		slog.Error("admin API call failed",
+			"command", name,
+			"admin", *adminAddr,
+			"error", err,
+			"hint", "check that the proxy is running with -admin on this address, or pass -admin")
+		return 1
+	}
+}
+
+func (c *ctl) list(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: list takes no arguments", errUsage)
+	}
+	routes, err := c.client.ListRoutes(c.ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(routes)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tNAME\tUPSTREAM\tENABLED\tCHAOS\tOPEN\tCONNECTIONS\tTO CLIENT\tTO SERVER")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\t%d\t%d\t%d\t%d\n", r.Port, orDash(r.Name), r.Upstream, r.Enabled, r.Chaos, r.OpenConnections, r.Connections, r.BytesToClient, r.BytesToServer)
+	}
+	return w.Flush()
+}
+
+func (c *ctl) get(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: get takes a route", errUsage)
+	}
+	port, err := c.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	settings, err := c.client.GetRouteConfig(c.ctx, port)
+	if err != nil {
+		return err
+	}
+	// The settings are JSON either way.
+	return c.printJSON(settings)
+}
+
+// switcher returns a command that switches a route, or its chaos, on or
+// off with update, reporting it as done.
+func switcher(update adminclient.RouteUpdate, done string) func(c *ctl, args []string) error {
+	return func(c *ctl, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: takes a route", errUsage)
+		}
+		port, err := c.resolve(args[0])
+		if err != nil {
+			return err
+		}
+		route, err := c.client.UpdateRoute(c.ctx, port, update)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(route)
+		}
+		fmt.Fprintf(c.out, "route %s: %s\n", describeRoute(route.Port, route.Name), done)
+		return nil
+	}
+}
+
+func (c *ctl) setLatency(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: set-latency takes a route and a duration", errUsage)
+	}
+	delay, err := time.ParseDuration(args[1])
+	if err != nil || delay < 0 {
+		return fmt.Errorf("%w: latency %q must be a duration such as 500ms or 2s", errUsage, args[1])
+	}
+	return c.patch(args[0], map[string]any{"latencyMs": delay.Milliseconds()}, fmt.Sprintf("latency set to %s", delay))
+}
+
+func (c *ctl) setDrop(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: set-drop takes a route and a rate", errUsage)
+	}
+	rate, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("%w: drop rate %q must be a number from 0 to 1", errUsage, args[1])
+	}
+	return c.patch(args[0], map[string]any{"dropRate": rate}, fmt.Sprintf("drop rate set to %v", rate))
+}
+
+// patch changes the settings of route, reporting the change as done.
+func (c *ctl) patch(route string, settings map[string]any, done string) error {
+	port, err := c.resolve(route)
+	if err != nil {
+		return err
+	}
+	patched, err := c.client.PatchRouteConfig(c.ctx, port, settings)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(patched)
+	}
+	fmt.Fprintf(c.out, "route %s: %s\n", describeRoute(port, route), done)
+	return nil
+}
+
+func (c *ctl) scale(args []string) error {
+	switch len(args) {
+	case 0:
+		scale, err := c.client.GetChaosScale(c.ctx)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(adminclient.ChaosScale{Scale: scale})
+		}
+		fmt.Fprintf(c.out, "chaos scale %v\n", scale)
+		return nil
+	case 1:
+		scale, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return fmt.Errorf("%w: scale %q must be a number >= 0", errUsage, args[0])
+		}
+		if err := c.client.SetChaosScale(c.ctx, scale); err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(adminclient.ChaosScale{Scale: scale})
+		}
+		fmt.Fprintf(c.out, "chaos scale set to %v\n", scale)
+		return nil
+	}
+	return fmt.Errorf("%w: scale takes at most a factor", errUsage)
+}
+
+func (c *ctl) connections(args []string) error {
+	var conns []adminclient.Connection
+	var err error
+	switch len(args) {
+	case 0:
+		conns, err = c.client.ListConnections(c.ctx)
+	case 1:
+		var port int
+		if port, err = c.resolve(args[0]); err != nil {
+			return err
+		}
+		conns, err = c.client.ListRouteConnections(c.ctx, port)
+	default:
+		return fmt.Errorf("%w: connections takes at most a route", errUsage)
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(conns)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tROUTE\tCLIENT\tUPSTREAM\tAGE\tTO CLIENT\tTO SERVER\tCHAOS")
+	for _, conn := range conns {
+		age := (time.Duration(conn.AgeMs) * time.Millisecond).Round(time.Second)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", conn.ID, describeRoute(conn.Port, conn.Route), conn.Client, conn.Upstream, age, conn.BytesToClient, conn.BytesToServer, orDash(strings.Join(conn.Chaos, ",")))
+	}
+	return w.Flush()
+}
+
+func (c *ctl) kill(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: kill takes a route", errUsage)
+	}
+	port, err := c.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	killed, err := c.client.KillRouteConnections(c.ctx, port)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]int{"killed": killed})
+	}
+	fmt.Fprintf(c.out, "route %s: killed %d connections\n", describeRoute(port, args[0]), killed)
+	return nil
+}
+
+func (c *ctl) killConn(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: kill-conn takes a connection ID", errUsage)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: connection ID %q must be a number, as listed by connections", errUsage, args[0])
+	}
+	if err := c.client.KillConnection(c.ctx, id); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]int{"killed": 1})
+	}
+	fmt.Fprintf(c.out, "killed connection %d\n", id)
+	return nil
+}
+
+func (c *ctl) remove(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: remove takes a route", errUsage)
+	}
+	port, err := c.resolve(args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.client.RemoveRoute(c.ctx, port); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]int{"removed": port})
+	}
+	fmt.Fprintf(c.out, "route %s: removed until the next config reload\n", describeRoute(port, args[0]))
+	return nil
+}
+
+func (c *ctl) reload(args []string) error {
+	mode := adminclient.ReloadAll
+	switch {
+	case len(args) > 1:
+		return fmt.Errorf("%w: reload takes at most a mode", errUsage)
+	case len(args) == 1 && args[0] != adminclient.ReloadAll && args[0] != adminclient.ReloadValid:
+		return fmt.Errorf("%w: reload mode %q must be all or valid", errUsage, args[0])
+	case len(args) == 1:
+		mode = args[0]
+	}
+	result, err := c.client.Reload(c.ctx, mode)
+	if result.Time.IsZero() {
+		// The proxy refused to reload at all.
+		return err
+	}
+	if c.json {
+		if printErr := c.printJSON(result); printErr != nil {
+			return printErr
+		}
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tOUTCOME\tERROR")
+	for _, r := range result.Routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", describeRoute(r.Port, r.Name), r.Outcome, orDash(strings.ReplaceAll(r.Error, "\n", " ")))
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+func (c *ctl) report(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: report takes no arguments", errUsage)
+	}
+	report, err := c.client.GetReport(c.ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(report)
+	}
+	duration := (time.Duration(report.DurationMs) * time.Millisecond).Round(time.Second)
+	fmt.Fprintf(c.out, "run of %s since %s\n\n", duration, report.Started.Format(time.RFC3339))
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tUPSTREAM\tCONNECTIONS\tFAILURES\tTO CLIENT\tTO SERVER\tFAULTS\tCLOSED")
+	for _, r := range report.Routes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", describeRoute(r.Port, r.Name), r.Upstream, r.Connections, r.Failures, r.BytesToClient, r.BytesToServer, formatCounts(r.Faults), formatCounts(r.CloseReasons))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	delayed := slices.ContainsFunc(report.Routes, func(r adminclient.RouteReport) bool { return len(r.InjectedDelay) > 0 })
+	if !delayed {
+		return nil
+	}
+	fmt.Fprintln(c.out)
+	w = tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tFAULT\tDELAYS\tP50\tP90\tP99\tMAX")
+	for _, r := range report.Routes {
+		for _, fault := range slices.Sorted(maps.Keys(r.InjectedDelay)) {
+			d := r.InjectedDelay[fault]
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", describeRoute(r.Port, r.Name), fault, d.Count, formatMs(d.P50Ms), formatMs(d.P90Ms), formatMs(d.P99Ms), formatMs(d.MaxMs))
+		}
+	}
+	return w.Flush()
+}
+
+// formatCounts lists counts as name=count, by name, for a table cell.
+func formatCounts(counts map[string]int64) string {
+	parts := make([]string, 0, len(counts))
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	return orDash(strings.Join(parts, ","))
+}
+
+// formatMs renders milliseconds as a duration.
+func formatMs(ms float64) string {
+	return time.Duration(ms * float64(time.Millisecond)).Round(time.Microsecond).String()
+}
+
+// resolve returns the local port of route, given by its port or its name.
+func (c *ctl) resolve(route string) (int, error) {
+	if port, err := strconv.Atoi(route); err == nil {
+		return port, nil
+	}
+	routes, err := c.client.ListRoutes(c.ctx)
+	if err != nil {
+		return 0, err
+	}
+	port := 0
+	for _, r := range routes {
+		if r.Name != route {
+			continue
+		}
+		if port != 0 {
+			return 0, fmt.Errorf("%w: more than one route is named %q; give its port instead", errUnknownRoute, route)
+		}
+		port = r.Port
+	}
+	if port == 0 {
+		return 0, fmt.Errorf("%w: no route is named %q", errUnknownRoute, route)
+	}
+	return port, nil
+}
+
+func (c *ctl) printJSON(v any) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// describeRoute names a route for output: by port, with its name if it
+// has one that isn't just the port.
+func describeRoute(port int, name string) string {
+	if name == "" || name == strconv.Itoa(port) {
+		return strconv.Itoa(port)
+	}
+	return fmt.Sprintf("%s (%d)", name, port)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// Flags for a one-route proxy run without a config file.
+var (
+	listen   = flag.String("listen", "", "run a single route without a config file, listening on this port or host:port (e.g. 8080 or 0.0.0.0:8080)")
+	upstream = flag.String("upstream", "", "upstream ip:port of the -listen route")
+	latency  = flag.Duration("latency", 0, "delay before forwarding data on the -listen route (e.g. 200ms)")
+	drop     = flag.Float64("drop", 0, "probability (0.0 to 1.0) of dropping connections on the -listen route")
+)
+
+// flagRouteSet reports whether any flag of the one-route proxy was given.
+func flagRouteSet() bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen", "upstream", "latency", "drop":
+			set = true
+		}
+	})
+	return set
+}
+
+// flagRoute builds the route given by -listen and the flags that go with
+// it. The route still needs validating like one from a config file.
+func flagRoute() (config.RouteConfig, error) {
+	if *listen == "" {
+		return config.RouteConfig{}, fmt.Errorf("-listen is required to run a route without a config file")
+	}
+	route := config.RouteConfig{
+		Upstream: *upstream,
+		DropRate: *drop,
+	}
+
+	port := *listen
+	if host, p, err := net.SplitHostPort(*listen); err == nil {
+		route.ListenAddress = host
+		port = p
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return config.RouteConfig{}, fmt.Errorf("-listen must be a port or host:port, got %q", *listen)
+	}
+	route.LocalPort = n
+
+	if *latency%time.Millisecond != 0 {
+		return config.RouteConfig{}, fmt.Errorf("-latency must be a whole number of milliseconds, got %s", *latency)
+	}
+	route.LatencyMs = config.Milliseconds(*latency / time.Millisecond)
+	return route, nil
+}
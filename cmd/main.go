@@ -2,92 +2,354 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/chasewilson/chaos-proxy/internal/admin"
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
 	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+	"github.com/chasewilson/chaos-proxy/internal/export"
 	"github.com/chasewilson/chaos-proxy/internal/logger"
 	"github.com/chasewilson/chaos-proxy/internal/proxy"
-	"github.com/chasewilson/chaos-proxy/internal/testserver"
 )
 
+// exportBuffer is how many events the exporter may lag behind before it
+// starts missing events.
+const exportBuffer = 4096
+
 var (
-	configFile = flag.String("config", "", "path to config file")
-	verbose    = flag.Bool("verbose", false, "enable verbose/debug output")
-	quiet      = flag.Bool("quiet", false, "enable quite output (errors only)")
-	tS         = flag.Bool("test-server", false, "start up test http servers for proxy testing")
+	configFiles = configFlag(flag.CommandLine)
+	webhooks    = webhookFlag(flag.CommandLine)
+	verbose     = flag.Bool("verbose", false, "enable verbose/debug output")
+	quiet       = flag.Bool("quiet", false, "enable quite output (errors only)")
+	tS          = flag.Bool("test-server", false, "start up test http servers for proxy testing")
+	adminAddr   = flag.String("admin", "", "address for the admin HTTP API and web dashboard (e.g. 127.0.0.1:9900); disabled when empty")
+	adminDebug  = flag.Bool("admin-debug", false, "also serve net/http/pprof profiles under /debug/pprof/ and expvar variables at /debug/vars on the -admin listener")
+	osURL       = flag.String("opensearch-url", "", "OpenSearch/Elasticsearch URL to export connection events to (credentials may be given as userinfo)")
+	osIndex     = flag.String("opensearch-index", "chaos-proxy-events", "index name for exported events")
+	chaosScale  = flag.Float64("chaos-scale", 1.0, "multiply every route's drop rates and latencies by this factor (0 disables them); adjustable at runtime through the admin API")
+	dryRun      = flag.Bool("chaos-dry-run", false, "forward every connection cleanly, only logging the chaos decisions that would have been made")
+	publish     = flag.String("publish-ports", "", "write the bound port of every listener as JSON to this file (\"-\" for stdout); allows localPort 0")
+	watchConfig = flag.Bool("watch-config", false, "reload the config file whenever it changes, as on SIGHUP")
+	reloadMode  = flag.String("reload-mode", admin.ReloadAll, "what a reload on SIGHUP or -watch-config does with a config some routes of which fail to validate or listen: \"all\" leaves every route as it was, \"valid\" applies the rest")
+	logFile     = flag.String("log-file", "", "write logs to this file instead of stderr, rotating it per -log-max-size and -log-max-age")
+	eventsFile  = flag.String("events-file", "", "write every connection and chaos event to this file as JSON lines, rotated like -log-file")
+	auditFile   = flag.String("audit-file", "", "write a JSON line recording every finished connection to this file, rotated like -log-file")
+	logMaxSize  = flag.Int("log-max-size", 100, "rotate -log-file, -events-file and -audit-file once they reach this many megabytes (0 for no limit)")
+	logMaxAge   = flag.Duration("log-max-age", 0, "rotate -log-file, -events-file and -audit-file once they have been written to this long, such as 24h (0 for no limit)")
+	logBackups  = flag.Int("log-max-backups", 5, "rotated files of -log-file, -events-file and -audit-file to keep (0 keeps them all)")
+	maxBuffered = flag.Int("max-buffered-memory", 0, "cap the data every route's coalescing, reordering and delayed UDP datagrams hold back, together, at this many megabytes (0 for no limit beyond each route's maxBufferedBytes)")
+	logSample   = flag.Int("log-sample", 1, "log the upstream-connected and bytes-transferred lines of only 1 in this many connections (1 logs every connection's)")
+	reportFile  = flag.String("report-file", "", "write the run's summary, per route, as JSON to this file at shutdown (\"-\" for stdout)")
+	grace       = flag.Duration("shutdown-grace", 10*time.Second, "at shutdown, wait this long for open connections to finish before killing them, so the run summary and traffic assertions count every connection")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "selftest":
+			os.Exit(runSelftest(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 	logger.NewLogger(*verbose, *quiet)
+	if *logFile != "" {
+		file := openLogFile(*logFile)
+		defer file.Close()
+		logger.NewFileLogger(file, *verbose, *quiet)
+	}
+	var eventLog *logger.RotatingFile
+	if *eventsFile != "" {
+		eventLog = openLogFile(*eventsFile)
+		defer eventLog.Close()
+	}
+	if *logSample < 1 {
+		slog.Error("invalid log sample",
+			"flag", "-log-sample",
+			"sample", *logSample,
+			"hint", "-log-sample must be >= 1 (1 logs every connection, 100 one in a hundred)")
+		os.Exit(2)
+	}
+	if *maxBuffered < 0 {
+		slog.Error("invalid buffered memory limit",
+			"flag", "-max-buffered-memory",
+			"megabytes", *maxBuffered,
+			"hint", "-max-buffered-memory must be >= 0 (megabytes, 0 for no limit)")
+		os.Exit(2)
+	}
+	serveOpts := proxy.ServeOptions{DryRun: *dryRun, LogSample: *logSample}
+	if *maxBuffered > 0 {
+		serveOpts.Memory = chaos.NewMemory(int64(*maxBuffered) << 20)
+	}
+	if *auditFile != "" {
+		audit := openLogFile(*auditFile)
+		defer audit.Close()
+		serveOpts.OnConnClose = export.NewAuditLog(audit).Record
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	slog.Info("starting", "app", "chaos-proxy")
-	if *configFile == "" {
+	if len(*configFiles) == 0 && !flagRouteSet() {
 		slog.Error("config file path is required",
 			"flag", "-config",
-			"hint", "usage: chaos-proxy -config <path-to-config.json>",
+			"hint", "usage: chaos-proxy -config <path-to-config.json>, or chaos-proxy -listen <port> -upstream <ip:port> for a single route",
 			"example", "chaos-proxy -config test-config.json")
 		os.Exit(2)
 	}
+	if len(*configFiles) != 0 && flagRouteSet() {
+		slog.Error("conflicting flags",
+			"flags", "-config, -listen",
+			"hint", "-listen, -upstream, -latency and -drop define a single route in place of a config file; use one or the other")
+		os.Exit(2)
+	}
+	if *watchConfig && len(*configFiles) == 0 {
+		slog.Error("conflicting flags",
+			"flags", "-watch-config, -listen",
+			"hint", "there is no config file to watch for a route given on the command line")
+		os.Exit(2)
+	}
+	if *watchConfig && configFiles.hasStdin() {
+		slog.Error("conflicting flags",
+			"flags", "-watch-config, -config -",
+			"hint", "a config read from stdin can't be read again; write it to a file to watch it")
+		os.Exit(2)
+	}
+	if *adminDebug && *adminAddr == "" {
+		slog.Error("conflicting flags",
+			"flags", "-admin-debug, -admin",
+			"hint", "-admin-debug serves its endpoints on the admin listener; give -admin an address too")
+		os.Exit(2)
+	}
 
-	slog.Info("loading config", "file", *configFile)
-	routeConfigs, err := config.LoadConfig(*configFile)
-	if err != nil {
-		slog.Error("config validation failed",
-			"file", *configFile,
-			"error", err,
-			"hint", "check the error messages above for specific issues and fix them in your config file")
+	if *chaosScale < 0 {
+		slog.Error("invalid chaos scale",
+			"flag", "-chaos-scale",
+			"scale", *chaosScale,
+			"hint", "chaos scale must be >= 0 (1 keeps the configured chaos, 0 turns drops and latency off)")
+		os.Exit(2)
+	}
+	intensity := chaos.NewIntensity(*chaosScale)
+	if *watchConfig && *publish != "" {
+		slog.Error("conflicting flags",
+			"flags", "-watch-config, -publish-ports",
+			"hint", "the published port file lists the listeners of the config loaded at startup, so the config can't be reloaded; drop one of the flags")
+		os.Exit(2)
+	}
+	if *grace < 0 {
+		slog.Error("invalid shutdown grace period",
+			"flag", "-shutdown-grace",
+			"grace", *grace,
+			"hint", "the shutdown grace period must be >= 0, such as 30s (0 kills open connections straight away)")
+		os.Exit(2)
+	}
+	if *reloadMode != admin.ReloadAll && *reloadMode != admin.ReloadValid {
+		slog.Error("invalid reload mode",
+			"flag", "-reload-mode",
+			"mode", *reloadMode,
+			"hint", "reload mode must be \"all\" or \"valid\"")
 		os.Exit(2)
 	}
-	slog.Info("config loaded", "file", *configFile, "routes", len(routeConfigs))
+	if *dryRun {
+		slog.Info("chaos dry run: connections are forwarded cleanly and chaos decisions are only logged", "flag", "-chaos-dry-run")
+	}
+
+	loadOptions := config.LoadOptions{AllowAutoPort: *publish != ""}
+	var routeConfigs []config.RouteConfig
+	var access config.Access
+	if len(*configFiles) != 0 {
+		source := configFiles.String()
+		slog.Info("loading config", "file", source)
+		var err error
+		routeConfigs, access, err = configFiles.loadWithAccess(loadOptions)
+		if err != nil {
+			slog.Error("config validation failed",
+				"file", source,
+				"error", err,
+				"hint", "check the error messages above for specific issues and fix them in your config file")
+			os.Exit(2)
+		}
+		slog.Info("config loaded", "file", source, "routes", len(routeConfigs))
+	} else {
+		route, err := flagRoute()
+		if err != nil {
+			slog.Error("invalid route flags",
+				"error", err,
+				"hint", "usage: chaos-proxy -listen <port> -upstream <ip:port> [-latency 200ms] [-drop 0.1]")
+			os.Exit(2)
+		}
+		routeConfigs = []config.RouteConfig{route}
+		if err := config.ValidateRoutes(routeConfigs, loadOptions, slog.Default()); err != nil {
+			slog.Error("route validation failed",
+				"error", err,
+				"hint", "check the error messages above and fix the -listen, -upstream, -latency and -drop flags")
+			os.Exit(2)
+		}
+		slog.Info("route set by flags", "port", route.LocalPort, "upstream", route.Upstream)
+	}
 	for i, route := range routeConfigs {
 		slog.Debug("route loaded",
 			"index", i+1,
 			"port", route.LocalPort,
+			"route", route.Name,
+			"tenant", route.Tenant,
+			"tags", route.Tags,
+			"profile", route.Profile,
 			"upstream", route.Upstream,
+			"mode", route.Mode,
 			"dropRate", route.DropRate*100,
 			"latencyMs", route.LatencyMs,
 		)
 	}
 
-	if *tS {
-		slog.Info("starting test servers")
+	var bus *events.Bus
+	if *adminAddr != "" || *osURL != "" || len(*webhooks) > 0 || eventLog != nil {
+		bus = events.NewBus()
+	}
+	var publisher *portPublisher
+	if *publish != "" {
+		listeners := len(routeConfigs)
 		for _, route := range routeConfigs {
-			go testserver.NewTestServer(route.Upstream)
+			if route.BaselinePort != 0 {
+				listeners++
+			}
+		}
+		publisher = newPortPublisher(*publish, listeners)
+	}
+	serveOpts.Events, serveOpts.Intensity = bus, intensity
+	if len(access.Tenants) > 0 {
+		serveOpts.TenantIntensity = make(map[string]*chaos.Intensity, len(access.Tenants))
+		for tenant := range access.Tenants {
+			serveOpts.TenantIntensity[tenant] = chaos.NewScopedIntensity(intensity)
+		}
+	}
+	routes := newRouteSet(ctx, serveOpts, loadOptions, publisher, *tS)
+	reloads := &reloader{paths: *configFiles, opts: loadOptions, routes: routes, mode: *reloadMode}
+	if *adminAddr != "" {
+		if *adminDebug {
+			expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+			expvar.Publish("report", expvar.Func(func() any { return routes.summary() }))
 		}
-		time.Sleep(100 * time.Millisecond)
+		startAdmin(ctx, *adminAddr, admin.Options{Events: bus, Intensity: intensity, TenantIntensity: serveOpts.TenantIntensity, Access: access, Routes: routes.controls, Changes: routes, Report: routes.summary, Reloader: reloads, Debug: *adminDebug})
+	}
+
+	// Subscribe exporters before any listener starts so no event is missed.
+	var exporters sync.WaitGroup
+	var stopExports []func()
+	startExport := func(run func(<-chan events.Event)) {
+		sub, unsubscribe := bus.Subscribe(exportBuffer)
+		exporters.Go(func() { run(sub) })
+		stopExports = append(stopExports, unsubscribe)
+	}
+	if *osURL != "" {
+		startExport(export.NewOpenSearch(*osURL, *osIndex).Run)
+	}
+	for _, url := range *webhooks {
+		startExport(export.NewWebhook(url).Run)
+	}
+	if eventLog != nil {
+		startExport(export.NewEventLog(eventLog).Run)
 	}
 
+	if *tS {
+		slog.Info("starting test servers")
+	}
 	slog.Info("starting listeners")
-	var wg sync.WaitGroup
-	for _, route := range routeConfigs {
-		slog.Debug("calling ListenAndServeRoute", "port", route.LocalPort)
-		wg.Add(1)
-		go func(r config.RouteConfig) {
-			defer wg.Done()
-			listenerCtx, listenerCancel := context.WithCancel(ctx)
-			defer listenerCancel()
-			err := proxy.ListenAndServeRoute(listenerCtx, r)
-			if err != nil {
-				slog.Error("proxy listener failed",
-					"port", r.LocalPort,
-					"upstream", r.Upstream,
-					"error", err,
-					"hint", "check that the port is not already in use and you have necessary permissions")
-				os.Exit(1)
-			}
-		}(route)
+	routes.start(routeConfigs)
+	go serveReloads(ctx, reloads, *watchConfig)
+
+	routes.wait(*grace)
+	summary, assertionsPassed := routes.report()
+	slog.Info("run finished", "duration", time.Duration(summary.DurationMs)*time.Millisecond, "routes", len(summary.Routes))
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, summary); err != nil {
+			slog.Error("failed to write run summary",
+				"file", *reportFile,
+				"error", err,
+				"hint", "check that the directory exists and is writable; the summary is also in the logs above")
+		}
 	}
 
-	wg.Wait()
+	// Closing the subscriptions makes the exporters ship what they have and
+	// exit.
+	for _, stop := range stopExports {
+		stop()
+	}
+	exporters.Wait()
+	if !assertionsPassed {
+		slog.Error("traffic assertions failed", "hint", "see the assertion failures above")
+		os.Exit(3)
+	}
 	slog.Info("all routes shut down")
 }
+
+// openLogFile opens a file for -log-file, -events-file or -audit-file,
+// rotated per the -log-max flags. Failing to is fatal, so a long run never
+// starts without its logs.
+func openLogFile(path string) *logger.RotatingFile {
+	if *logMaxSize < 0 || *logMaxAge < 0 || *logBackups < 0 {
+		slog.Error("invalid log rotation flags",
+			"log_max_size", *logMaxSize,
+			"log_max_age", *logMaxAge,
+			"log_max_backups", *logBackups,
+			"hint", "-log-max-size, -log-max-age and -log-max-backups must be >= 0; 0 turns the limit off")
+		os.Exit(2)
+	}
+	file, err := logger.OpenRotatingFile(path, int64(*logMaxSize)<<20, *logMaxAge, *logBackups)
+	if err != nil {
+		slog.Error("failed to open log file",
+			"file", path,
+			"error", err,
+			"hint", "check that the directory exists and is writable")
+		os.Exit(2)
+	}
+	return file
+}
+
+// startAdmin serves the admin API on addr until ctx is cancelled. A bind
+// failure is fatal, like a proxy listener failure.
+func startAdmin(ctx context.Context, addr string, opts admin.Options) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to start admin listener",
+			"address", addr,
+			"error", err,
+			"hint", "check that the admin address is not already in use")
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Handler: admin.NewHandler(opts),
+		// Long-lived event streams end when the proxy shuts down.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		slog.Info("admin API listening", "address", listener.Addr(), "tokens", opts.Access.Enabled(), "tenants", len(opts.Access.Tenants))
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("admin API failed", "address", addr, "error", err)
+		}
+	}()
+}
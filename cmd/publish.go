@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// publishedRoute is one entry in the -publish-ports JSON document.
+type publishedRoute struct {
+	Route          int    `json:"route"`
+	Name           string `json:"name,omitempty"`
+	ConfiguredPort int    `json:"configuredPort"`
+	BoundPort      int    `json:"boundPort"`
+	Upstream       string `json:"upstream"`
+	Baseline       bool   `json:"baseline,omitempty"`
+}
+
+// portPublisher collects bound addresses from every listener and writes the
+// full mapping once all of them are up, so harnesses never see a partial file.
+type portPublisher struct {
+	path    string
+	mu      sync.Mutex
+	pending int
+	routes  []publishedRoute
+}
+
+func newPortPublisher(path string, listeners int) *portPublisher {
+	return &portPublisher{
+		path:    path,
+		pending: listeners,
+		routes:  make([]publishedRoute, listeners),
+	}
+}
+
+// onListen returns a proxy.ServeOptions.OnListen hook for the listener at
+// slot, serving the route at routeIndex in the config file.
+func (p *portPublisher) onListen(slot, routeIndex int, route config.RouteConfig, baseline bool) func(net.Addr) {
+	return func(addr net.Addr) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.routes[slot] = publishedRoute{
+			Route:          routeIndex,
+			Name:           route.Name,
+			ConfiguredPort: route.LocalPort,
+			BoundPort:      boundPort(addr, route),
+			Upstream:       route.Upstream,
+			Baseline:       baseline,
+		}
+		p.pending--
+		if p.pending > 0 {
+			return
+		}
+
+		if err := p.write(); err != nil {
+			slog.Error("failed to publish ports", "file", p.path, "error", err, "hint", "check that the directory exists and is writable")
+			return
+		}
+		slog.Info("published ports", "file", p.path, "listeners", len(p.routes))
+	}
+}
+
+// boundPort is the port of a listener's address: a TCP one, or a UDP one
+// for UDP routes.
+func boundPort(addr net.Addr, route config.RouteConfig) int {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+	return route.LocalPort
+}
+
+func (p *portPublisher) write() error {
+	data, err := json.MarshalIndent(p.routes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot encode port mapping: %w", err)
+	}
+	data = append(data, '\n')
+
+	if p.path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	// Write to a temp file and rename so readers never see a partial document.
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), ".chaos-proxy-ports-*")
+	if err != nil {
+		return fmt.Errorf("cannot create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write port mapping: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write port mapping: %w", err)
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
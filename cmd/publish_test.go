@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestPortPublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.json")
+	p := newPortPublisher(path, 2)
+
+	tcp := config.RouteConfig{LocalPort: 0, Upstream: "127.0.0.1:9090"}
+	udp := config.RouteConfig{Name: "dns", LocalPort: 0, Upstream: "127.0.0.1:53", Protocol: config.ProtocolUDP}
+	p.onListen(0, 0, tcp, false)(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 41000})
+	p.onListen(1, 1, udp, false)(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 41001})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ports file not written: %v", err)
+	}
+	var routes []publishedRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		t.Fatalf("ports file is not JSON: %v", err)
+	}
+	want := []publishedRoute{
+		{Route: 0, BoundPort: 41000, Upstream: "127.0.0.1:9090"},
+		{Route: 1, Name: "dns", BoundPort: 41001, Upstream: "127.0.0.1:53"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("published %d routes, want %d", len(routes), len(want))
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("route %d = %+v, want %+v", i, routes[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/admin"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// watchInterval is how often -watch-config checks the config file for
+// changes.
+const watchInterval = time.Second
+
+// serveReloads reloads the config files on SIGHUP and, with watch,
+// whenever their contents change, until ctx is cancelled. Globs are
+// expanded again on every reload, so adding or removing a matching file
+// changes the routes too. Without a config file, or with one read from
+// stdin, SIGHUP is ignored.
+func serveReloads(ctx context.Context, reloads *reloader, watch bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	paths := reloads.paths
+	source := paths.String()
+	var tick <-chan time.Time
+	var last []byte
+	if watch {
+		var err error
+		if last, err = readConfigs(paths); err != nil {
+			slog.Warn("failed to read config file to watch it", "file", source, "error", err)
+		}
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+		slog.Info("watching config file for changes", "file", source)
+	}
+
+	for {
+		var trigger string
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if len(paths) == 0 {
+				slog.Warn("no config file to reload on SIGHUP",
+					"hint", "the route was given with -listen and -upstream; restart the proxy to change it")
+				continue
+			}
+			if paths.hasStdin() {
+				slog.Warn("no config file to reload on SIGHUP",
+					"hint", "the config was read from stdin, which can't be read again; restart the proxy to change it")
+				continue
+			}
+			slog.Info("reloading config on SIGHUP", "file", source)
+			trigger = "signal"
+		case <-tick:
+			data, err := readConfigs(paths)
+			if err != nil || bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			slog.Info("config file changed, reloading it", "file", source)
+			trigger = "watch"
+		}
+
+		if _, err := reloads.Reload(reloads.mode, trigger); err != nil {
+			slog.Error("config reload is not possible",
+				"file", source,
+				"error", err,
+				"hint", "restart the proxy to apply changes to the config")
+		}
+	}
+}
+
+// reloader reloads the config files into the running routes, on SIGHUP,
+// with -watch-config and through the admin API, one reload at a time.
+type reloader struct {
+	paths  configPaths
+	opts   config.LoadOptions
+	routes *routeSet
+	// mode is the -reload-mode of the reloads not asked for through the
+	// admin API, which picks its own.
+	mode string
+
+	mu   sync.Mutex
+	last *admin.ReloadResult
+}
+
+var _ admin.Reloader = (*reloader)(nil)
+
+func (l *reloader) Reload(mode, trigger string) (admin.ReloadResult, error) {
+	switch {
+	case len(l.paths) == 0:
+		return admin.ReloadResult{}, fmt.Errorf("%w: the route was given with -listen and -upstream, not a config file", admin.ErrConflict)
+	case l.paths.hasStdin():
+		return admin.ReloadResult{}, fmt.Errorf("%w: the config was read from stdin, which can't be read again", admin.ErrConflict)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	source := l.paths.String()
+	var result admin.ReloadResult
+	routes, rejected, err := config.LoadConfigsValid(l.paths, l.opts)
+	if err == nil {
+		result, err = l.routes.reload(routes, rejected, mode)
+		if err != nil {
+			return admin.ReloadResult{}, err
+		}
+	} else {
+		result = admin.ReloadResult{Time: time.Now(), Mode: mode, Error: err.Error(), Routes: []admin.RouteReload{}}
+	}
+	result.Trigger = trigger
+	if !result.Applied {
+		slog.Error("config reload failed, keeping the running routes",
+			"file", source,
+			"mode", mode,
+			"error", result.Error,
+			"hint", "check the error messages above for specific issues and fix them in your config file")
+	}
+	l.last = &result
+	return result, nil
+}
+
+func (l *reloader) LastReload() (admin.ReloadResult, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		return admin.ReloadResult{}, false
+	}
+	return *l.last, true
+}
+
+// routeChange is a route a reload is moving to new settings, and what came
+// of it.
+type routeChange struct {
+	// index is the route's position in the reloaded routes.
+	index int
+	route config.RouteConfig
+	// old is the running route being changed, nil for an added route, and
+	// prev its settings before the reload.
+	old  *runningRoute
+	prev config.RouteConfig
+	// started is the route serving route, once it has been started.
+	started *runningRoute
+	// stopped is set once old's listeners have been closed.
+	stopped bool
+	outcome string
+	err     error
+}
+
+// reload moves the running routes to routes, matching them up by local
+// port. rejected are the routes of the config that failed validation, left
+// out of routes. Routes that are unchanged are left alone. Changed routes
+// take their new settings on the listener they have, so open connections
+// and every other route carry on undisturbed, unless the change needs a new
+// listener; then the old one is closed, its connections left to finish,
+// and a new one started. Removed routes stop listening the same way.
+//
+// Every new listener that can bind while the old ones are still open does,
+// before any old one is closed; the rest, on ports the old listeners are
+// giving up, bind once they have. With admin.ReloadAll, a route that fails
+// validation changes nothing, and one that fails to listen puts every route
+// back as it was. With admin.ReloadValid, the route that fails is left as
+// it was running, or out if it is new, and the others applied.
+func (s *routeSet) reload(routes []config.RouteConfig, rejected []config.RejectedRoute, mode string) (admin.ReloadResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.changeable(); err != nil {
+		return admin.ReloadResult{}, err
+	}
+	result := admin.ReloadResult{Time: time.Now(), Mode: mode, Routes: []admin.RouteReload{}}
+
+	current := make(map[int]*runningRoute, len(s.running))
+	for _, r := range s.running {
+		current[r.route.LocalPort] = r
+	}
+	failed := make(map[int]bool, len(rejected))
+	for _, rejection := range rejected {
+		result.Routes = append(result.Routes, admin.RouteReload{
+			Port:    rejection.Route.LocalPort,
+			Name:    rejection.Route.Name,
+			Outcome: admin.RouteFailed,
+			Error:   rejection.Err.Error(),
+		})
+		failed[rejection.Route.LocalPort] = true
+	}
+	if len(rejected) > 0 && mode == admin.ReloadAll {
+		result.Error = fmt.Sprintf("%d of the config's routes failed validation", len(rejected))
+		return result, nil
+	}
+
+	// A running route whose new settings failed validation keeps its old
+	// ones, in the place the config has it.
+	next := routes
+	if len(rejected) > 0 {
+		next = make([]config.RouteConfig, 0, len(routes)+len(rejected))
+		valid := routes
+		for i := 0; i < len(routes)+len(rejected); i++ {
+			j := slices.IndexFunc(rejected, func(rejection config.RejectedRoute) bool { return rejection.Index == i })
+			switch {
+			case j < 0:
+				next, valid = append(next, valid[0]), valid[1:]
+			case current[rejected[j].Route.LocalPort] != nil && !slices.ContainsFunc(routes, func(route config.RouteConfig) bool {
+				return route.LocalPort == rejected[j].Route.LocalPort
+			}):
+				next = append(next, current[rejected[j].Route.LocalPort].route)
+			}
+		}
+		if err := s.validate(next); err != nil {
+			result.Error = err.Error()
+			return result, nil
+		}
+	}
+
+	nextPorts := make(map[int]bool, len(next))
+	for _, route := range next {
+		nextPorts[route.LocalPort] = true
+	}
+	var removed, added, restarted, updated []*routeChange
+	for i, r := range s.running {
+		if !nextPorts[r.route.LocalPort] {
+			removed = append(removed, &routeChange{index: i, route: r.route, old: r, prev: r.route, outcome: admin.RouteRemoved})
+		}
+	}
+	s.startTestServers(next)
+	running := make([]*runningRoute, len(next))
+	for i, route := range next {
+		r, ok := current[route.LocalPort]
+		change := &routeChange{index: i, route: route, old: r}
+		switch {
+		case !ok:
+			change.outcome = admin.RouteAdded
+			added = append(added, change)
+		case reflect.DeepEqual(r.route, route):
+			running[i] = r
+			if !failed[route.LocalPort] {
+				result.Routes = append(result.Routes, admin.RouteReload{Port: route.LocalPort, Name: route.Name, Outcome: admin.RouteUnchanged})
+			}
+		default:
+			change.prev = r.route
+			if !r.needsRestart(route) && r.update(route, "reload") {
+				running[i] = r
+				change.outcome = admin.RouteUpdated
+				updated = append(updated, change)
+				continue
+			}
+			change.outcome = admin.RouteRestarted
+			restarted = append(restarted, change)
+		}
+	}
+
+	// The ports, and handoff sockets, the closing listeners are giving up.
+	released := make(map[int]bool)
+	sockets := make(map[string]bool)
+	for _, change := range slices.Concat(removed, restarted) {
+		released[change.prev.LocalPort] = true
+		released[change.prev.BaselinePort] = true
+		sockets[change.prev.HandoffSocket] = true
+	}
+	delete(released, 0)
+	delete(sockets, "")
+	waiting := func(change *routeChange) bool {
+		route := change.route
+		return released[route.LocalPort] || released[route.BaselinePort] || sockets[route.HandoffSocket]
+	}
+
+	// start serves the changes' routes, waiting for them to listen, and
+	// reports whether they all did.
+	start := func(changes []*routeChange) bool {
+		for _, change := range changes {
+			if change.outcome == admin.RouteAdded {
+				routeLogger(change.route).Info("route added by reload", "upstream", change.route.Upstream)
+			}
+			change.started = s.serve(change.index, change.route, change.old, false)
+		}
+		ok := true
+		for _, change := range changes {
+			if err := change.started.listening(); err != nil {
+				change.err = err
+				ok = false
+			}
+		}
+		return ok
+	}
+
+	var early, late []*routeChange
+	for _, change := range added {
+		if waiting(change) {
+			late = append(late, change)
+		} else {
+			early = append(early, change)
+		}
+	}
+	ok := start(early)
+	if ok || mode == admin.ReloadValid {
+		for _, change := range removed {
+			routeLogger(change.prev).Info("route removed by reload, closing its listener", "upstream", change.prev.Upstream)
+			change.old.stop()
+			change.stopped = true
+		}
+		for _, change := range restarted {
+			routeLogger(change.route).Info("route changed by reload, restarting its listener", "upstream", change.route.Upstream)
+			change.old.stop()
+			change.stopped = true
+		}
+		ok = start(slices.Concat(restarted, late)) && ok
+	}
+
+	changes := slices.Concat(updated, added, restarted, removed)
+	if !ok && mode == admin.ReloadAll {
+		s.rollBack(changes)
+		result.Error = "a route failed to listen, so every route was put back as it was"
+		for _, change := range changes {
+			if change.outcome != admin.RouteFailed {
+				change.outcome = admin.RouteReverted
+			}
+		}
+	} else {
+		for _, change := range slices.Concat(added, restarted) {
+			if change.err == nil {
+				running[change.index] = change.started
+				continue
+			}
+			running[change.index] = s.restore(change)
+			change.outcome = admin.RouteFailed
+		}
+		s.running = slices.DeleteFunc(running, func(r *runningRoute) bool { return r == nil })
+		result.Applied = true
+	}
+
+	outcomes := make(map[string]int)
+	for _, change := range changes {
+		report := admin.RouteReload{Port: change.route.LocalPort, Name: change.route.Name, Outcome: change.outcome}
+		if change.err != nil {
+			report.Error = change.err.Error()
+		}
+		result.Routes = append(result.Routes, report)
+	}
+	for _, report := range result.Routes {
+		outcomes[report.Outcome]++
+	}
+	slices.SortStableFunc(result.Routes, func(a, b admin.RouteReload) int { return a.Port - b.Port })
+
+	if result.Applied {
+		slog.Info("config reloaded",
+			"mode", mode,
+			"routes", len(s.running),
+			"added", outcomes[admin.RouteAdded],
+			"removed", outcomes[admin.RouteRemoved],
+			"updated", outcomes[admin.RouteUpdated],
+			"restarted", outcomes[admin.RouteRestarted],
+			"unchanged", outcomes[admin.RouteUnchanged],
+			"failed", outcomes[admin.RouteFailed])
+	}
+	return result, nil
+}
+
+// restore undoes change after its route failed to listen: a route that was
+// running is served again with its old settings, and returned, and a new
+// route is dropped from the reports at shutdown. s.mu must be held.
+func (s *routeSet) restore(change *routeChange) *runningRoute {
+	routeLogger(change.route).Error("route failed to listen after reload, leaving it as it was",
+		"error", change.err,
+		"hint", "check that the port is not already in use and you have necessary permissions")
+	change.started.stop()
+	if change.old == nil {
+		s.served = slices.DeleteFunc(s.served, func(r *runningRoute) bool { return r == change.started })
+		return nil
+	}
+	return s.serveAgain(change, change.started)
+}
+
+// serveAgain serves change's route with its settings from before the
+// reload, in place of prev, and returns it, or nil if it fails to listen.
+// s.mu must be held.
+func (s *routeSet) serveAgain(change *routeChange, prev *runningRoute) *runningRoute {
+	r := s.serve(change.index, change.prev, prev, false)
+	if err := r.listening(); err != nil {
+		routeLogger(change.prev).Error("failed to restore route after reload",
+			"error", err,
+			"hint", "the route is no longer served; fix the port conflict and reload the config")
+		return nil
+	}
+	return r
+}
+
+// rollBack puts every route the changes moved back as it was, after one of
+// them failed to listen in an admin.ReloadAll reload. s.mu must be held.
+func (s *routeSet) rollBack(changes []*routeChange) {
+	slog.Warn("reverting config reload", "hint", "a route failed to listen; fix it and reload again, or reload with mode valid to apply the rest")
+	restored := make(map[*runningRoute]*runningRoute)
+	for _, change := range changes {
+		if change.err != nil {
+			change.outcome = admin.RouteFailed
+		}
+		if change.started != nil {
+			change.started.stop()
+		}
+		switch {
+		case change.outcome == admin.RouteUpdated:
+			change.old.update(change.prev, "reverted reload")
+		case change.old == nil:
+			if change.started != nil {
+				s.served = slices.DeleteFunc(s.served, func(r *runningRoute) bool { return r == change.started })
+			}
+		case change.stopped:
+			prev := change.started
+			if prev == nil {
+				prev = change.old
+			}
+			restored[change.old] = s.serveAgain(change, prev)
+		}
+	}
+	running := s.running[:0]
+	for _, r := range s.running {
+		if next, ok := restored[r]; ok {
+			r = next
+		}
+		if r != nil {
+			running = append(running, r)
+		}
+	}
+	s.running = running
+}
+
+// readConfigs reads every config the paths expand to, into one snapshot
+// that changes whenever any of them, or the set of files matching a glob,
+// does.
+func readConfigs(paths configPaths) ([]byte, error) {
+	files, err := config.ExpandPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot []byte
+	for _, path := range files {
+		data, err := config.ReadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshot = append(snapshot, path...)
+		snapshot = append(snapshot, 0)
+		snapshot = append(snapshot, data...)
+		snapshot = append(snapshot, 0)
+	}
+	return snapshot, nil
+}
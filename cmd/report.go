@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// baselineComparison pairs a cursed route with its chaos-free twin.
+type baselineComparison struct {
+	route    config.RouteConfig
+	cursed   *proxy.RouteStats
+	baseline *proxy.RouteStats
+}
+
+func (c baselineComparison) report() {
+	cursed := c.cursed.Snapshot()
+	baseline := c.baseline.Snapshot()
+
+	routeLogger(c.route).Info("baseline comparison",
+		"baseline_port", c.route.BaselinePort,
+		"upstream", c.route.Upstream,
+		"connections", cursed.Connections,
+		"baseline_connections", baseline.Connections,
+		"failures", cursed.Failures,
+		"baseline_failures", baseline.Failures,
+		"failure_delta", cursed.Failures-baseline.Failures,
+		"avg_first_byte", cursed.AvgFirstByte,
+		"baseline_avg_first_byte", baseline.AvgFirstByte,
+		"first_byte_delta", cursed.AvgFirstByte-baseline.AvgFirstByte,
+		"faults", cursed.Faults)
+}
+
+// routeAssertion pairs a route's expectations with the stats they check.
+type routeAssertion struct {
+	route config.RouteConfig
+	stats *proxy.RouteStats
+}
+
+// check logs every failed expectation and reports whether all of them held.
+func (a routeAssertion) check() bool {
+	failures := proxy.CheckExpectations(*a.route.Expect, a.stats.Snapshot())
+	for _, failure := range failures {
+		routeLogger(a.route).Error("traffic assertion failed",
+			"upstream", a.route.Upstream,
+			"assertion", failure)
+	}
+	if len(failures) == 0 {
+		routeLogger(a.route).Info("traffic assertions passed", "upstream", a.route.Upstream)
+	}
+	return len(failures) == 0
+}
+
+// tlsSummary reports the ClientHello aggregates of a capturing route.
+type tlsSummary struct {
+	route config.RouteConfig
+	stats *proxy.RouteStats
+}
+
+func (s tlsSummary) report() {
+	tls := s.stats.Snapshot().TLS
+
+	routeLogger(s.route).Info("tls client hello summary",
+		"upstream", s.route.Upstream,
+		"client_hellos", tls.ClientHellos,
+		"not_tls", tls.NotTLS,
+		"versions", tls.Versions,
+		"sni", tls.ServerNames,
+		"alpn", tls.ALPN)
+	routeLogger(s.route).Debug("tls client hello cipher suites",
+		"cipher_suites", tls.CipherSuites)
+}
+
+// runSummary reports what a route did over the run: its traffic, the faults
+// injected and the delays they added.
+type runSummary struct {
+	route   config.RouteConfig
+	summary proxy.RouteReport
+}
+
+func (s runSummary) report() {
+	r := s.summary
+	routeLogger(s.route).Info("run summary",
+		"upstream", r.Upstream,
+		"connections", r.Connections,
+		"failures", r.Failures,
+		"failovers", r.Failovers,
+		"bytes_to_client", r.BytesToClient,
+		"bytes_to_server", r.BytesToServer,
+		"faults", r.Faults,
+		"close_reasons", r.CloseReasons)
+	for _, fault := range slices.Sorted(maps.Keys(r.InjectedDelay)) {
+		delay := r.InjectedDelay[fault]
+		routeLogger(s.route).Info("injected delay",
+			"fault", fault,
+			"count", delay.Count,
+			"p50_ms", delay.P50Ms,
+			"p90_ms", delay.P90Ms,
+			"p99_ms", delay.P99Ms,
+			"max_ms", delay.MaxMs)
+	}
+}
+
+// summary reports every route served so far, for the admin API.
+func (s *routeSet) summary() proxy.Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.summarize()
+}
+
+// summarize reports every route served so far. s.mu must be held.
+func (s *routeSet) summarize() proxy.Report {
+	report := proxy.Report{
+		Started:    s.started,
+		DurationMs: time.Since(s.started).Milliseconds(),
+		Routes:     make([]proxy.RouteReport, len(s.served)),
+	}
+	for i, r := range s.served {
+		report.Routes[i] = proxy.NewRouteReport(r.control, r.stats)
+	}
+	return report
+}
+
+// writeReport writes report as JSON to path, or to stdout for "-".
+func writeReport(path string, report proxy.Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
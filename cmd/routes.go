@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+	"github.com/chasewilson/chaos-proxy/internal/testserver"
+)
+
+// routeSet is the routes the proxy is serving. A config reload moves it to
+// the routes of the new config, touching only the routes that changed.
+type routeSet struct {
+	ctx context.Context
+	// opts holds the hooks every route shares.
+	opts proxy.ServeOptions
+	// loadOptions validates the routes changed through the admin API.
+	loadOptions config.LoadOptions
+	publisher   *portPublisher
+	testServers bool
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	running []*runningRoute
+	// served is every route started, in order, for the reports at shutdown.
+	served []*runningRoute
+	// started is when the proxy started, for the run's summary.
+	started time.Time
+	// upstreams have a -test-server started on them.
+	upstreams map[string]bool
+	slot      int
+	// closed is set once the proxy is shutting down, after which nothing
+	// more is started.
+	closed bool
+}
+
+// runningRoute is a route being served, along with its baseline listener if
+// it has one.
+type runningRoute struct {
+	route    config.RouteConfig
+	control  *proxy.RouteControl
+	stats    *proxy.RouteStats
+	baseline *runningBaseline
+	cancel   context.CancelFunc
+	// done is closed once the route, and its baseline, stop listening.
+	done chan struct{}
+	// bound gets, for each of the route's listeners, nil once it is
+	// listening or the error it failed with.
+	bound     chan error
+	listeners int
+}
+
+type runningBaseline struct {
+	control *proxy.RouteControl
+	stats   *proxy.RouteStats
+}
+
+func newRouteSet(ctx context.Context, opts proxy.ServeOptions, loadOptions config.LoadOptions, publisher *portPublisher, testServers bool) *routeSet {
+	return &routeSet{
+		ctx:         ctx,
+		opts:        opts,
+		loadOptions: loadOptions,
+		publisher:   publisher,
+		testServers: testServers,
+		upstreams:   make(map[string]bool),
+		started:     time.Now(),
+	}
+}
+
+// controls returns the controls of the running routes, for the admin API.
+func (s *routeSet) controls() []*proxy.RouteControl {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	controls := make([]*proxy.RouteControl, len(s.running))
+	for i, r := range s.running {
+		controls[i] = r.control
+	}
+	return controls
+}
+
+// start serves the routes of the config file loaded at startup. A route that
+// fails to listen stops the proxy.
+func (s *routeSet) start(routes []config.RouteConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.startTestServers(routes) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	for i, route := range routes {
+		s.running = append(s.running, s.serve(i, route, nil, true))
+	}
+}
+
+// serve starts route, the routeIndex-th in its config file. With prev, a
+// route that has stopped listening, route takes its place: its control,
+// with the switches made at runtime, and its stats carry on, and it
+// replaces prev in the reports at shutdown. With fatal, a listener failure
+// exits the proxy, as it does at startup; otherwise the route is logged and
+// dropped from the running routes. s.mu must be held.
+func (s *routeSet) serve(routeIndex int, route config.RouteConfig, prev *runningRoute, fatal bool) *runningRoute {
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &runningRoute{
+		route:   route,
+		control: proxy.NewRouteControl(route),
+		stats:   &proxy.RouteStats{},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		bound:   make(chan error, 2),
+	}
+	if prev != nil {
+		prev.control.Restart(route)
+		r.control, r.stats = prev.control, prev.stats
+		s.served[slices.Index(s.served, prev)] = r
+	} else {
+		s.served = append(s.served, r)
+	}
+
+	var listeners sync.WaitGroup
+	listen := func(route config.RouteConfig, opts proxy.ServeOptions, baseline bool) {
+		opts.Events = s.opts.Events
+		opts.Intensity = s.opts.Intensity
+		opts.TenantIntensity = s.opts.TenantIntensity
+		opts.DryRun = s.opts.DryRun
+		opts.LogSample = s.opts.LogSample
+		opts.Memory = s.opts.Memory
+		opts.OnConnClose = s.opts.OnConnClose
+		var onListen func(net.Addr)
+		if s.publisher != nil {
+			onListen = s.publisher.onListen(s.slot, routeIndex, route, baseline)
+			s.slot++
+		}
+		var bound sync.Once
+		signal := func(err error) { bound.Do(func() { r.bound <- err }) }
+		opts.OnListen = func(addr net.Addr) {
+			if onListen != nil {
+				onListen(addr)
+			}
+			signal(nil)
+		}
+		slog.Debug("calling ServeRoute", "port", route.LocalPort)
+		s.wg.Add(1)
+		listeners.Add(1)
+		r.listeners++
+		go func() {
+			defer s.wg.Done()
+			defer listeners.Done()
+			err := proxy.ServeRoute(ctx, route, opts)
+			if err == nil {
+				signal(errors.New("stopped before listening"))
+				return
+			}
+			signal(err)
+			routeLogger(route).Error("proxy listener failed",
+				"upstream", route.Upstream,
+				"error", err,
+				"hint", "check that the port is not already in use and you have necessary permissions")
+			if fatal {
+				os.Exit(1)
+			}
+			// Its baseline goes with it. A reload may be waiting on this
+			// route to stop, holding s.mu, so it is dropped separately.
+			cancel()
+			go s.drop(r)
+		}()
+	}
+
+	listen(route, proxy.ServeOptions{Stats: r.stats, Control: r.control}, false)
+	if route.BaselinePort != 0 {
+		baseline := route.Baseline()
+		r.baseline = &runningBaseline{control: proxy.NewRouteControl(baseline), stats: &proxy.RouteStats{}}
+		if prev != nil && prev.baseline != nil {
+			prev.baseline.control.Restart(baseline)
+			r.baseline = prev.baseline
+		}
+		listen(baseline, proxy.ServeOptions{Stats: r.baseline.stats, Control: r.baseline.control}, true)
+	}
+	go func() {
+		listeners.Wait()
+		close(r.done)
+	}()
+	return r
+}
+
+// listening waits for the route's listeners to bind, returning the first
+// error any of them failed with. It is called once, by the change
+// that started the route.
+func (r *runningRoute) listening() error {
+	var first error
+	for range r.listeners {
+		if err := <-r.bound; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// drop removes r from the running routes after it failed.
+func (s *routeSet) drop(r *runningRoute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = slices.DeleteFunc(s.running, func(running *runningRoute) bool { return running == r })
+}
+
+// routeLogger returns the logger for messages about route, which names it
+// as the proxy's own route logs do.
+func routeLogger(route config.RouteConfig) *slog.Logger {
+	logger := slog.With("port", route.LocalPort)
+	if route.Name != "" {
+		logger = logger.With("route", route.Name)
+	}
+	return logger
+}
+
+// stop closes the route's listeners and waits for them to close. Its open
+// connections are left to finish.
+func (r *runningRoute) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// startTestServers starts a -test-server on each upstream of routes that
+// doesn't have one yet, and reports whether it started any. s.mu must be
+// held.
+func (s *routeSet) startTestServers(routes []config.RouteConfig) bool {
+	if !s.testServers {
+		return false
+	}
+	started := false
+	for _, route := range routes {
+		if route.PerConnectionUpstream() || route.Stub != nil || route.Replay != nil || s.upstreams[route.Upstream] {
+			// Its clients pick their own destinations, or its stub or
+			// recordings answer them.
+			continue
+		}
+		s.upstreams[route.Upstream] = true
+		go testserver.NewTestServer(route.Upstream)
+		started = true
+	}
+	return started
+}
+
+// needsRestart reports whether moving the route to next's settings takes new
+// listeners, rather than new settings for the ones it has.
+func (r *runningRoute) needsRestart(next config.RouteConfig) bool {
+	return r.route.Relisten(next) ||
+		r.route.BaselinePort != next.BaselinePort
+}
+
+// update gives the running route, and its baseline, next's settings, as
+// changed by by. It reports whether they took them; if not, the route is
+// left as it was.
+func (r *runningRoute) update(next config.RouteConfig, by string) bool {
+	if err := r.control.Update(next); err != nil {
+		routeLogger(next).Error("failed to update route", "error", err, "hint", "the route's listener will be restarted instead")
+		return false
+	}
+	if r.baseline != nil && !reflect.DeepEqual(r.route.Baseline(), next.Baseline()) {
+		if err := r.baseline.control.Update(next.Baseline()); err != nil {
+			routeLogger(next).Error("failed to update baseline route", "baseline_port", next.BaselinePort, "error", err, "hint", "the route's listener will be restarted instead")
+			r.control.Update(r.route)
+			return false
+		}
+	}
+	routeLogger(next).Info("route changed by "+by+", updated in place", "upstream", next.Upstream)
+	r.route = next
+	return true
+}
+
+// wait blocks until the proxy shuts down, every route has stopped
+// listening and their open connections have finished, or been killed once
+// grace ran out, so that the reports count every connection. A reload
+// restarting the only route doesn't count.
+func (s *routeSet) wait(grace time.Duration) {
+	<-s.ctx.Done()
+	s.mu.Lock()
+	s.closed = true
+	var controls []*proxy.RouteControl
+	for _, r := range s.served {
+		controls = append(controls, r.control)
+		if r.baseline != nil {
+			controls = append(controls, r.baseline.control)
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	var open int64
+	for _, c := range controls {
+		open += c.Traffic().Open
+	}
+	if open == 0 {
+		return
+	}
+	slog.Info("waiting for open connections to finish", "connections", open, "grace", grace)
+	killed, left := proxy.Drain(controls, grace)
+	if killed > 0 {
+		slog.Warn("killed connections still open after the shutdown grace period",
+			"connections", killed,
+			"grace", grace,
+			"hint", "their traffic so far is counted; raise -shutdown-grace to let long transfers finish")
+	}
+	if left > 0 {
+		slog.Error("connections still open after being killed",
+			"connections", left,
+			"hint", "a connection held up by a long delay, such as latencyMs, closes when it ends; the reports leave it out")
+	}
+}
+
+// report logs the run summaries, baseline comparisons, ClientHello summaries
+// and traffic assertions of every route served, and reports whether the
+// assertions all held. The run's summary is returned for -report-file.
+func (s *routeSet) report() (proxy.Report, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := s.summarize()
+	for i, r := range s.served {
+		runSummary{route: r.route, summary: summary.Routes[i]}.report()
+	}
+	for _, r := range s.served {
+		if r.baseline != nil {
+			baselineComparison{route: r.route, cursed: r.stats, baseline: r.baseline.stats}.report()
+		}
+	}
+	for _, r := range s.served {
+		if r.route.CaptureClientHello {
+			tlsSummary{route: r.route, stats: r.stats}.report()
+		}
+	}
+	passed := true
+	for _, r := range s.served {
+		if r.route.Expect != nil && !(routeAssertion{route: r.route, stats: r.stats}).check() {
+			passed = false
+		}
+	}
+	return summary, passed
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/logger"
+)
+
+// runValidate implements `chaos-proxy validate`: the config is loaded and
+// validated exactly as the proxy would at startup, and optionally checked
+// against the machine it will run on, without opening any listener for
+// longer than the check takes. It returns the process exit status.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFiles := configFlag(fs)
+	autoPort := fs.Bool("allow-auto-port", false, "accept localPort 0, as -publish-ports does")
+	checkPorts := fs.Bool("check-ports", false, "also check that every route's ports are free to listen on")
+	checkUpstreams := fs.Bool("check-upstreams", false, "also check that every route's upstream accepts TCP connections")
+	timeout := fs.Duration("timeout", 2*time.Second, "how long to wait for each upstream with -check-upstreams")
+	verbose := fs.Bool("verbose", false, "show every check, not just the failures")
+	fs.Parse(args)
+
+	logger.NewLogger(*verbose, false)
+
+	if len(*configFiles) == 0 {
+		slog.Error("config file path is required",
+			"flag", "-config",
+			"hint", "usage: chaos-proxy validate -config <path-to-config.json>")
+		return 2
+	}
+
+	routes, err := configFiles.load(config.LoadOptions{AllowAutoPort: *autoPort})
+	if err != nil {
+		slog.Error("config validation failed",
+			"file", configFiles.String(),
+			"error", err,
+			"hint", "check the error messages above for specific issues and fix them in your config file")
+		return 2
+	}
+
+	passed := true
+	if *checkPorts && !portsFree(routes) {
+		passed = false
+	}
+	if *checkUpstreams && !upstreamsReachable(routes, *timeout) {
+		passed = false
+	}
+	if !passed {
+		slog.Error("config checks failed",
+			"file", configFiles.String(),
+			"hint", "the config is valid, but the proxy would not run cleanly on this machine; see the errors above")
+		return 1
+	}
+	source := configFiles.String()
+	if len(*configFiles) == 1 && configFiles.hasStdin() {
+		source = "stdin"
+	}
+	verb := "is"
+	if len(*configFiles) > 1 {
+		verb = "are"
+	}
+	fmt.Printf("%s %s valid: %d routes\n", source, verb, len(routes))
+	return 0
+}
+
+// portsFree reports whether every route, and its baseline, could listen on
+// its port right now. Each listener is closed as soon as it opens.
+func portsFree(routes []config.RouteConfig) bool {
+	free := true
+	for _, route := range routes {
+		ports := []int{route.LocalPort}
+		if route.BaselinePort != 0 {
+			ports = append(ports, route.BaselinePort)
+		}
+		for _, port := range ports {
+			if port == 0 {
+				// The OS picks a free one.
+				continue
+			}
+			addr := net.JoinHostPort(route.ListenHost(), strconv.Itoa(port))
+			if err := tryListen(route, addr); err != nil {
+				routeLogger(route).Error("port not available",
+					"address", addr,
+					"error", err,
+					"hint", "another process is listening on this port, or you need elevated permissions to use it")
+				free = false
+				continue
+			}
+			routeLogger(route).Debug("port available", "address", addr)
+		}
+	}
+	return free
+}
+
+func tryListen(route config.RouteConfig, addr string) error {
+	if route.IsUDP() {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// upstreamsReachable reports whether every route's upstream accepts a TCP
+// connection within timeout. Routes without a fixed upstream, UDP routes,
+// which have no connection to make, and routes chained to another route
+// of the config, which isn't running, are skipped.
+func upstreamsReachable(routes []config.RouteConfig, timeout time.Duration) bool {
+	var wg sync.WaitGroup
+	reachable := make([]bool, len(routes))
+	for i, route := range routes {
+		reachable[i] = true
+		switch {
+		case route.PerConnectionUpstream(), route.Stub != nil, route.Replay != nil:
+			continue
+		case route.IsUDP():
+			routeLogger(route).Debug("not checking UDP upstream", "upstream", route.Upstream)
+			continue
+		case config.ChainedRoute(routes, route) >= 0:
+			routeLogger(route).Debug("not checking upstream chained to another route", "upstream", route.Upstream)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", route.Upstream, timeout)
+			if err != nil {
+				routeLogger(route).Error("upstream not reachable",
+					"upstream", route.Upstream,
+					"error", err,
+					"hint", "check that the upstream is running and reachable from this machine")
+				reachable[i] = false
+				return
+			}
+			conn.Close()
+			routeLogger(route).Debug("upstream reachable", "upstream", route.Upstream)
+		}()
+	}
+	wg.Wait()
+
+	for _, ok := range reachable {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
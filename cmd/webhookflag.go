@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/url"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// webhookURLs is the value of a -webhook flag, which may be repeated to
+// notify several endpoints.
+type webhookURLs []string
+
+// webhookFlag defines the -webhook flag on fs.
+func webhookFlag(fs *flag.FlagSet) *webhookURLs {
+	urls := new(webhookURLs)
+	fs.Var(urls, "webhook", "http(s) URL to POST a JSON payload to whenever chaos is applied, such as a Slack incoming webhook; repeat to notify several")
+	return urls
+}
+
+// String returns the URLs as they may be logged, with any password masked.
+func (u *webhookURLs) String() string {
+	if u == nil {
+		return ""
+	}
+	redacted := make([]string, len(*u))
+	for i, raw := range *u {
+		redacted[i] = config.RedactPath(raw)
+	}
+	return strings.Join(redacted, ",")
+}
+
+func (u *webhookURLs) Set(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhook must be an http or https URL")
+	}
+	*u = append(*u, raw)
+	return nil
+}
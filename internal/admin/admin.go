@@ -0,0 +1,560 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// eventBuffer is how many events a slow stream client may lag behind before
+// it starts missing events.
+const eventBuffer = 256
+
+// maxRouteBody caps the body of a request that adds or changes a route.
+const maxRouteBody = 1 << 20
+
+// Errors a RouteChanges returns, wrapped, to pick the API's answer.
+var (
+	// ErrNoRoute means no route is on the port.
+	ErrNoRoute = errors.New("no route")
+	// ErrInvalidRoute means the route failed validation.
+	ErrInvalidRoute = errors.New("invalid route")
+	// ErrConflict means the change can't be made while the proxy runs as it
+	// does, such as a port another process holds.
+	ErrConflict = errors.New("route change not possible")
+)
+
+// RouteChanges adds, changes and removes running routes. Each change is
+// validated against the other running routes first.
+type RouteChanges interface {
+	AddRoute(route config.RouteConfig) error
+	// ReplaceRoute moves the route on port to route's settings.
+	ReplaceRoute(port int, route config.RouteConfig) error
+	RemoveRoute(port int) error
+}
+
+// Options wires the admin API to the running proxy.
+type Options struct {
+	// Events is the bus streamed by GET /events.
+	Events *events.Bus
+	// Intensity is the global chaos scale read and set by /chaos-scale.
+	Intensity *chaos.Intensity
+	// TenantIntensity is each tenant's chaos scale, by tenant name, read and
+	// set by /chaos-scale for the tenant's token.
+	TenantIntensity map[string]*chaos.Intensity
+	// Access holds the tokens the API asks for: every request must carry
+	// one as a bearer token, and a tenant's only reaches the tenant's own
+	// routes. Without tokens, the API is open to whoever reaches it.
+	Access config.Access
+	// Routes returns the running routes listed and switched by /routes. It
+	// is called for every request, since a config reload can add and remove
+	// routes.
+	Routes func() []*proxy.RouteControl
+	// Changes adds, changes and removes routes for the /routes endpoints
+	// that do, and for the Toxiproxy API. Nil leaves the routes as
+	// configured.
+	Changes RouteChanges
+	// Report returns the run's summary so far for GET /report. Nil leaves
+	// the endpoint unavailable.
+	Report func() proxy.Report
+	// Reloader reloads the config files for the /reload endpoints. Nil
+	// leaves them unavailable.
+	Reloader Reloader
+	// Debug serves net/http/pprof under /debug/pprof/ and expvar at
+	// /debug/vars. They expose the process's internals, so they are off
+	// unless asked for.
+	Debug bool
+}
+
+// NewHandler returns the admin HTTP API.
+func NewHandler(opts Options) http.Handler {
+	if opts.Routes == nil {
+		opts.Routes = func() []*proxy.RouteControl { return nil }
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", serveDashboard)
+	mux.HandleFunc("GET /events", streamEvents(opts.Events))
+	mux.HandleFunc("GET /chaos-scale", getChaosScale(opts.Intensity, opts.TenantIntensity))
+	mux.HandleFunc("PUT /chaos-scale", setChaosScale(opts.Intensity, opts.TenantIntensity))
+	mux.HandleFunc("GET /routes", listRoutes(opts.Routes))
+	mux.HandleFunc("POST /routes", addRoute(opts.Changes))
+	mux.HandleFunc("GET /routes/{port}", getRoute(opts.Routes))
+	mux.HandleFunc("PUT /routes/{port}", updateRoute(opts.Routes))
+	mux.HandleFunc("DELETE /routes/{port}", removeRoute(opts.Routes, opts.Changes))
+	mux.HandleFunc("GET /routes/{port}/config", getRouteConfig(opts.Routes))
+	mux.HandleFunc("PATCH /routes/{port}/config", patchRouteConfig(opts.Routes, opts.Changes))
+	mux.HandleFunc("GET /routes/{port}/connections", listRouteConnections(opts.Routes))
+	mux.HandleFunc("DELETE /routes/{port}/connections", killRouteConnections(opts.Routes))
+	mux.HandleFunc("GET /connections", listConnections(opts.Routes))
+	mux.HandleFunc("DELETE /connections/{id}", killConnection(opts.Routes))
+	mux.HandleFunc("GET /report", adminOnly(getReport(opts.Report)))
+	mux.HandleFunc("POST /reload", adminOnly(reloadConfig(opts.Reloader)))
+	mux.HandleFunc("GET /reload/last", adminOnly(lastReload(opts.Reloader)))
+	handleToxiproxy(mux, opts.Routes, opts.Changes)
+	if opts.Debug {
+		handleDebug(mux)
+	}
+	if opts.Access.Enabled() {
+		return authenticate(opts.Access, mux)
+	}
+	return mux
+}
+
+// chaosScale is the body of the /chaos-scale endpoints.
+type chaosScale struct {
+	Scale *float64 `json:"scale"`
+}
+
+// callerIntensity returns the chaos scale the caller of r reads and sets:
+// the global one for the operator, or the tenant's own, which multiplies
+// it.
+func callerIntensity(r *http.Request, global *chaos.Intensity, tenants map[string]*chaos.Intensity) (*chaos.Intensity, bool) {
+	c := callerOf(r)
+	if c.admin() {
+		return global, true
+	}
+	intensity, ok := tenants[c.tenant]
+	return intensity, ok
+}
+
+func getChaosScale(global *chaos.Intensity, tenants map[string]*chaos.Intensity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		intensity, ok := callerIntensity(r, global, tenants)
+		if !ok {
+			http.Error(w, "chaos scale is not adjustable", http.StatusNotImplemented)
+			return
+		}
+		scale := intensity.Own()
+		writeJSON(w, chaosScale{Scale: &scale})
+	}
+}
+
+func getReport(report func() proxy.Report) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if report == nil {
+			http.Error(w, "run summary is not available", http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, report())
+	}
+}
+
+func setChaosScale(global *chaos.Intensity, tenants map[string]*chaos.Intensity) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body chaosScale
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if body.Scale == nil || *body.Scale < 0 || math.IsInf(*body.Scale, 0) || math.IsNaN(*body.Scale) {
+			http.Error(w, `"scale" must be a number >= 0`, http.StatusBadRequest)
+			return
+		}
+		intensity, _ := callerIntensity(r, global, tenants)
+		if intensity == nil {
+			http.Error(w, "chaos scale is not adjustable", http.StatusNotImplemented)
+			return
+		}
+
+		previous := intensity.Own()
+		intensity.Set(*body.Scale)
+		if c := callerOf(r); !c.admin() {
+			slog.Info("tenant chaos scale changed", "tenant", c.tenant, "previous", previous, "scale", *body.Scale, "address", r.RemoteAddr)
+		} else {
+			slog.Info("chaos scale changed", "previous", previous, "scale", *body.Scale, "address", r.RemoteAddr)
+		}
+		writeJSON(w, body)
+	}
+}
+
+// route is a running route as reported by the /routes endpoints.
+type route struct {
+	Port     int      `json:"port"`
+	Name     string   `json:"name,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Upstream string   `json:"upstream"`
+	Enabled  bool     `json:"enabled"`
+	Chaos    bool     `json:"chaos"`
+
+	Connections     int64 `json:"connections"`
+	OpenConnections int64 `json:"openConnections"`
+	BytesToClient   int64 `json:"bytesToClient"`
+	BytesToServer   int64 `json:"bytesToServer"`
+}
+
+func newRoute(c *proxy.RouteControl) route {
+	traffic := c.Traffic()
+	return route{
+		Port:            c.Port(),
+		Name:            c.Name(),
+		Tenant:          c.Tenant(),
+		Tags:            c.Tags(),
+		Upstream:        c.Upstream(),
+		Enabled:         c.Enabled(),
+		Chaos:           c.ChaosEnabled(),
+		Connections:     traffic.Connections,
+		OpenConnections: traffic.Open,
+		BytesToClient:   traffic.BytesToClient,
+		BytesToServer:   traffic.BytesToServer,
+	}
+}
+
+// routeUpdate is the body of PUT /routes/{port}. Omitted fields are left
+// as they are.
+type routeUpdate struct {
+	Enabled *bool `json:"enabled"`
+	Chaos   *bool `json:"chaos"`
+}
+
+func listRoutes(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		controls := ownRoutes(r, running)
+		routes := make([]route, len(controls))
+		for i, c := range controls {
+			routes[i] = newRoute(c)
+		}
+		writeJSON(w, routes)
+	}
+}
+
+// findRoute returns the control of the running route on the request's
+// port, or answers the request with an error and returns nil.
+func findRoute(w http.ResponseWriter, r *http.Request, running func() []*proxy.RouteControl) *proxy.RouteControl {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil {
+		http.Error(w, "port must be a number", http.StatusBadRequest)
+		return nil
+	}
+	for _, c := range running() {
+		if c.Port() != port {
+			continue
+		}
+		if !callerOf(r).owns(c) {
+			forbidden(w, r, fmt.Sprintf("route on port %d", port))
+			return nil
+		}
+		return c
+	}
+	http.Error(w, fmt.Sprintf("no route on port %d", port), http.StatusNotFound)
+	return nil
+}
+
+func getRoute(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if control := findRoute(w, r, running); control != nil {
+			writeJSON(w, newRoute(control))
+		}
+	}
+}
+
+func updateRoute(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		control := findRoute(w, r, running)
+		if control == nil {
+			return
+		}
+		port := control.Port()
+
+		var body routeUpdate
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if body.Enabled == nil && body.Chaos == nil {
+			http.Error(w, `set "enabled", "chaos" or both`, http.StatusBadRequest)
+			return
+		}
+
+		if body.Enabled != nil {
+			control.SetEnabled(*body.Enabled)
+		}
+		if body.Chaos != nil {
+			control.SetChaosEnabled(*body.Chaos)
+		}
+		updated := newRoute(control)
+		slog.Info("route switched", "port", port, "route", updated.Name, "enabled", updated.Enabled, "chaos", updated.Chaos, "address", r.RemoteAddr)
+		writeJSON(w, updated)
+	}
+}
+
+func getRouteConfig(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if control := findRoute(w, r, running); control != nil {
+			writeJSON(w, control.Route())
+		}
+	}
+}
+
+func patchRouteConfig(running func() []*proxy.RouteControl, changes RouteChanges) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if changes == nil {
+			http.Error(w, "routes can't be changed through this API", http.StatusNotImplemented)
+			return
+		}
+		control := findRoute(w, r, running)
+		if control == nil {
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRouteBody))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read body: %v", err), http.StatusBadRequest)
+			return
+		}
+		patched, err := config.PatchRoute(control.Route(), body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid route settings: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !claim(r, &patched) {
+			forbidden(w, r, fmt.Sprintf("tenant %q", patched.Tenant))
+			return
+		}
+		port := control.Port()
+		if err := changes.ReplaceRoute(port, patched); err != nil {
+			writeChangeError(w, err)
+			return
+		}
+		slog.Info("route changed through the admin API", "port", port, "route", patched.Name, "address", r.RemoteAddr)
+		writeJSON(w, patched)
+	}
+}
+
+func addRoute(changes RouteChanges) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if changes == nil {
+			http.Error(w, "routes can't be added through this API", http.StatusNotImplemented)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRouteBody))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read body: %v", err), http.StatusBadRequest)
+			return
+		}
+		added, err := config.ParseRoute(body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid route: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !claim(r, &added) {
+			forbidden(w, r, fmt.Sprintf("tenant %q", added.Tenant))
+			return
+		}
+		if err := changes.AddRoute(added); err != nil {
+			writeChangeError(w, err)
+			return
+		}
+		slog.Info("route added through the admin API", "port", added.LocalPort, "route", added.Name, "address", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(added); err != nil {
+			slog.Error("failed to encode admin response", "error", err)
+		}
+	}
+}
+
+func removeRoute(running func() []*proxy.RouteControl, changes RouteChanges) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if changes == nil {
+			http.Error(w, "routes can't be removed through this API", http.StatusNotImplemented)
+			return
+		}
+		port, err := strconv.Atoi(r.PathValue("port"))
+		if err != nil {
+			http.Error(w, "port must be a number", http.StatusBadRequest)
+			return
+		}
+		for _, c := range running() {
+			if c.Port() == port && !callerOf(r).owns(c) {
+				forbidden(w, r, fmt.Sprintf("route on port %d", port))
+				return
+			}
+		}
+		if err := changes.RemoveRoute(port); err != nil {
+			writeChangeError(w, err)
+			return
+		}
+		slog.Info("route removed through the admin API", "port", port, "address", r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// connection is an open connection as reported by the /connections
+// endpoints.
+type connection struct {
+	ID            uint64    `json:"id"`
+	Port          int       `json:"port"`
+	Route         string    `json:"route,omitempty"`
+	Client        string    `json:"client"`
+	Upstream      string    `json:"upstream"`
+	Started       time.Time `json:"started"`
+	AgeMs         int64     `json:"ageMs"`
+	BytesToClient int64     `json:"bytesToClient"`
+	BytesToServer int64     `json:"bytesToServer"`
+	Chaos         []string  `json:"chaos"`
+}
+
+func appendConnections(conns []connection, c *proxy.RouteControl, now time.Time) []connection {
+	for _, conn := range c.Conns() {
+		chaos := conn.Chaos
+		if chaos == nil {
+			chaos = []string{}
+		}
+		conns = append(conns, connection{
+			ID:            conn.ID,
+			Port:          c.Port(),
+			Route:         c.Name(),
+			Client:        conn.Client,
+			Upstream:      conn.Upstream,
+			Started:       conn.Started,
+			AgeMs:         now.Sub(conn.Started).Milliseconds(),
+			BytesToClient: conn.BytesToClient,
+			BytesToServer: conn.BytesToServer,
+			Chaos:         chaos,
+		})
+	}
+	return conns
+}
+
+func listConnections(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conns := []connection{}
+		now := time.Now()
+		for _, c := range ownRoutes(r, running) {
+			conns = appendConnections(conns, c, now)
+		}
+		writeJSON(w, conns)
+	}
+}
+
+func listRouteConnections(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if control := findRoute(w, r, running); control != nil {
+			writeJSON(w, appendConnections([]connection{}, control, time.Now()))
+		}
+	}
+}
+
+// killed is the body answering DELETE /routes/{port}/connections.
+type killed struct {
+	Killed int `json:"killed"`
+}
+
+func killRouteConnections(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		control := findRoute(w, r, running)
+		if control == nil {
+			return
+		}
+		n := control.KillAll()
+		slog.Info("route connections killed through the admin API", "port", control.Port(), "route", control.Name(), "killed", n, "address", r.RemoteAddr)
+		writeJSON(w, killed{Killed: n})
+	}
+}
+
+func killConnection(running func() []*proxy.RouteControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "connection id must be a number", http.StatusBadRequest)
+			return
+		}
+		for _, c := range running() {
+			if !callerOf(r).owns(c) {
+				if slices.ContainsFunc(c.Conns(), func(conn proxy.Conn) bool { return conn.ID == id }) {
+					forbidden(w, r, fmt.Sprintf("connection %d", id))
+					return
+				}
+				continue
+			}
+			if c.Kill(id) {
+				slog.Info("connection killed through the admin API", "port", c.Port(), "route", c.Name(), "connection", id, "address", r.RemoteAddr)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("no open connection %d", id), http.StatusNotFound)
+	}
+}
+
+// writeChangeError answers a request whose route change failed.
+func writeChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoRoute):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidRoute):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode admin response", "error", err)
+	}
+}
+
+// streamEvents serves connection events as server-sent events until the
+// client disconnects.
+func streamEvents(bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		c := callerOf(r)
+		sub, unsubscribe := bus.Subscribe(eventBuffer)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		slog.Debug("event stream client connected", "address", r.RemoteAddr)
+		defer slog.Debug("event stream client disconnected", "address", r.RemoteAddr)
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-sub:
+				if !c.admin() && e.Tenant != c.tenant {
+					continue
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					slog.Error("failed to encode event", "error", err)
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus()
+	server := httptest.NewServer(NewHandler(Options{Events: bus}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The handler subscribes before writing headers, so publishing now is safe.
+	bus.Publish(events.Event{Type: events.Fault, Port: 8080, Fault: "drop"})
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var eventLine, dataLine string
+	timeout := time.After(2 * time.Second)
+	for dataLine == "" {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream closed before an event arrived")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				eventLine = line
+			}
+			if strings.HasPrefix(line, "data: ") {
+				dataLine = line
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	if eventLine != "event: fault" {
+		t.Errorf("event line = %q, want %q", eventLine, "event: fault")
+	}
+
+	var e events.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(dataLine, "data: ")), &e); err != nil {
+		t.Fatalf("failed to decode event data: %v", err)
+	}
+	if e.Port != 8080 || e.Fault != "drop" {
+		t.Errorf("event = %+v, want fault drop on port 8080", e)
+	}
+}
+
+func TestChaosScale(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantScale  float64
+	}{
+		{name: "set scale", body: `{"scale": 2}`, wantStatus: http.StatusOK, wantScale: 2},
+		{name: "zero turns chaos off", body: `{"scale": 0}`, wantStatus: http.StatusOK, wantScale: 0},
+		{name: "negative scale", body: `{"scale": -1}`, wantStatus: http.StatusBadRequest, wantScale: 1},
+		{name: "missing scale", body: `{}`, wantStatus: http.StatusBadRequest, wantScale: 1},
+		{name: "unknown field", body: `{"scale": 2, "extra": true}`, wantStatus: http.StatusBadRequest, wantScale: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intensity := chaos.NewIntensity(1)
+			handler := NewHandler(Options{Intensity: intensity})
+
+			req := httptest.NewRequest(http.MethodPut, "/chaos-scale", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := intensity.Scale(); got != tt.wantScale {
+				t.Errorf("scale = %v, want %v", got, tt.wantScale)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chaos-scale", nil))
+			var got struct{ Scale float64 }
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("GET /chaos-scale returned %q: %v", rec.Body.String(), err)
+			}
+			if got.Scale != tt.wantScale {
+				t.Errorf("GET /chaos-scale = %v, want %v", got.Scale, tt.wantScale)
+			}
+		})
+	}
+}
+
+func TestUpdateRoute(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		wantStatus  int
+		wantEnabled bool
+		wantChaos   bool
+	}{
+		{name: "disable route", path: "/routes/8080", body: `{"enabled": false}`, wantStatus: http.StatusOK, wantEnabled: false, wantChaos: true},
+		{name: "chaos off", path: "/routes/8080", body: `{"chaos": false}`, wantStatus: http.StatusOK, wantEnabled: true, wantChaos: false},
+		{name: "both", path: "/routes/8080", body: `{"enabled": false, "chaos": false}`, wantStatus: http.StatusOK, wantEnabled: false, wantChaos: false},
+		{name: "neither field", path: "/routes/8080", body: `{}`, wantStatus: http.StatusBadRequest, wantEnabled: true, wantChaos: true},
+		{name: "unknown field", path: "/routes/8080", body: `{"dropRate": 0}`, wantStatus: http.StatusBadRequest, wantEnabled: true, wantChaos: true},
+		{name: "unknown port", path: "/routes/8081", body: `{"enabled": false}`, wantStatus: http.StatusNotFound, wantEnabled: true, wantChaos: true},
+		{name: "port not a number", path: "/routes/http", body: `{"enabled": false}`, wantStatus: http.StatusBadRequest, wantEnabled: true, wantChaos: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control := proxy.NewRouteControl(config.RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9000"})
+			handler := NewHandler(Options{Routes: func() []*proxy.RouteControl { return []*proxy.RouteControl{control} }})
+
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if control.Enabled() != tt.wantEnabled || control.ChaosEnabled() != tt.wantChaos {
+				t.Errorf("enabled = %v, chaos = %v, want %v, %v", control.Enabled(), control.ChaosEnabled(), tt.wantEnabled, tt.wantChaos)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routes", nil))
+			var got []struct {
+				Port    int
+				Enabled bool
+				Chaos   bool
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("GET /routes returned %q: %v", rec.Body.String(), err)
+			}
+			if len(got) != 1 || got[0].Port != 8080 || got[0].Enabled != tt.wantEnabled || got[0].Chaos != tt.wantChaos {
+				t.Errorf("GET /routes = %+v, want port 8080 enabled %v chaos %v", got, tt.wantEnabled, tt.wantChaos)
+			}
+		})
+	}
+}
+
+// fakeChanges records the route changes asked of it, failing those for
+// port 9999 as invalid.
+type fakeChanges struct {
+	added    []config.RouteConfig
+	replaced map[int]config.RouteConfig
+	removed  []int
+}
+
+func (f *fakeChanges) AddRoute(route config.RouteConfig) error {
+	if route.LocalPort == 9999 {
+		return fmt.Errorf("%w: port 9999", ErrInvalidRoute)
+	}
+	f.added = append(f.added, route)
+	return nil
+}
+
+func (f *fakeChanges) ReplaceRoute(port int, route config.RouteConfig) error {
+	if route.LocalPort == 9999 {
+		return fmt.Errorf("%w: port 9999", ErrInvalidRoute)
+	}
+	f.replaced[port] = route
+	return nil
+}
+
+func (f *fakeChanges) RemoveRoute(port int) error {
+	if port != 8080 {
+		return fmt.Errorf("%w on port %d", ErrNoRoute, port)
+	}
+	f.removed = append(f.removed, port)
+	return nil
+}
+
+func TestRouteChanges(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		wantStatus   int
+		wantAdded    []config.RouteConfig
+		wantReplaced map[int]config.RouteConfig
+		wantRemoved  []int
+	}{
+		{
+			name:       "add",
+			method:     http.MethodPost,
+			path:       "/routes",
+			body:       `{"localPort": 8081, "upstream": "127.0.0.1:9001", "chaos": {"drop": {"rate": 0.5}}}`,
+			wantStatus: http.StatusCreated,
+			wantAdded:  []config.RouteConfig{{LocalPort: 8081, Upstream: "127.0.0.1:9001", DropRate: 0.5}},
+		},
+		{name: "add unknown field", method: http.MethodPost, path: "/routes", body: `{"localPort": 8081, "drop": 0.5}`, wantStatus: http.StatusBadRequest},
+		{name: "add invalid", method: http.MethodPost, path: "/routes", body: `{"localPort": 9999}`, wantStatus: http.StatusBadRequest},
+		{
+			name:         "patch",
+			method:       http.MethodPatch,
+			path:         "/routes/8080/config",
+			body:         `{"latencyMs": "250ms"}`,
+			wantStatus:   http.StatusOK,
+			wantReplaced: map[int]config.RouteConfig{8080: {LocalPort: 8080, Upstream: "127.0.0.1:9000", DropRate: 0.1, LatencyMs: 250}},
+		},
+		{name: "patch invalid", method: http.MethodPatch, path: "/routes/8080/config", body: `{"localPort": 9999}`, wantStatus: http.StatusBadRequest},
+		{name: "patch unknown port", method: http.MethodPatch, path: "/routes/8081/config", body: `{"latencyMs": 250}`, wantStatus: http.StatusNotFound},
+		{name: "remove", method: http.MethodDelete, path: "/routes/8080", wantStatus: http.StatusNoContent, wantRemoved: []int{8080}},
+		{name: "remove unknown port", method: http.MethodDelete, path: "/routes/8081", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control := proxy.NewRouteControl(config.RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9000", DropRate: 0.1})
+			changes := &fakeChanges{replaced: make(map[int]config.RouteConfig)}
+			handler := NewHandler(Options{
+				Routes:  func() []*proxy.RouteControl { return []*proxy.RouteControl{control} },
+				Changes: changes,
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !reflect.DeepEqual(changes.added, tt.wantAdded) {
+				t.Errorf("added = %+v, want %+v", changes.added, tt.wantAdded)
+			}
+			if tt.wantReplaced == nil {
+				tt.wantReplaced = map[int]config.RouteConfig{}
+			}
+			if !reflect.DeepEqual(changes.replaced, tt.wantReplaced) {
+				t.Errorf("replaced = %+v, want %+v", changes.replaced, tt.wantReplaced)
+			}
+			if !reflect.DeepEqual(changes.removed, tt.wantRemoved) {
+				t.Errorf("removed = %+v, want %+v", changes.removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestRouteChanges_NotSupported(t *testing.T) {
+	handler := NewHandler(Options{})
+	req := httptest.NewRequest(http.MethodPost, "/routes", strings.NewReader(`{"localPort": 8081}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	route := config.RouteConfig{Name: "db", LocalPort: port, Upstream: upstream.Addr().String()}
+	control := proxy.NewRouteControl(route)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.ServeRoute(ctx, route, proxy.ServeOptions{Control: control})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(time.Second))
+	client.Write([]byte("ping"))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+
+	server := httptest.NewServer(NewHandler(Options{Routes: func() []*proxy.RouteControl { return []*proxy.RouteControl{control} }}))
+	defer server.Close()
+
+	var conns []connection
+	resp, err := http.Get(server.URL + "/connections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&conns)
+	resp.Body.Close()
+	if len(conns) != 1 || conns[0].Port != port || conns[0].Route != "db" || conns[0].Client != client.LocalAddr().String() || conns[0].BytesToServer != 4 {
+		t.Fatalf("GET /connections = %+v, want the client's connection through db", conns)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "invalid id", path: "/connections/abc", wantStatus: http.StatusBadRequest},
+		{name: "unknown id", path: fmt.Sprintf("/connections/%d", conns[0].ID+1), wantStatus: http.StatusNotFound},
+		{name: "unknown route", path: "/routes/1/connections", wantStatus: http.StatusNotFound},
+		{name: "route", path: fmt.Sprintf("/routes/%d/connections", port), wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodDelete, server.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("DELETE %s status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("client read after killing the route's connections succeeded, want the connection reset")
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	server := httptest.NewServer(NewHandler(Options{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET / = %d %q, want 200 text/html", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `fetch(path`) {
+		t.Error("GET / body is not the dashboard")
+	}
+
+	// The dashboard is only at the root, not a catch-all for unknown paths.
+	resp, err = http.Get(server.URL + "/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /nope = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestReport(t *testing.T) {
+	want := proxy.Report{
+		DurationMs: 1500,
+		Routes: []proxy.RouteReport{{
+			Port:          8080,
+			Upstream:      "127.0.0.1:9090",
+			Connections:   3,
+			Faults:        map[string]int64{"latency": 2},
+			CloseReasons:  map[string]int64{"completed": 3},
+			InjectedDelay: map[string]proxy.DelayReport{"latency": {Count: 2, P50Ms: 10, P90Ms: 20, P99Ms: 20, MaxMs: 20}},
+		}},
+	}
+	tests := []struct {
+		name       string
+		report     func() proxy.Report
+		wantStatus int
+	}{
+		{"served", func() proxy.Report { return want }, http.StatusOK},
+		{"not available", nil, http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(Options{Report: tt.report})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/report", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got proxy.Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body is not a report: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("GET /report = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDebug(t *testing.T) {
+	tests := []struct {
+		name       string
+		debug      bool
+		wantStatus int
+	}{
+		{"on", true, http.StatusOK},
+		{"off by default", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(Options{Debug: tt.debug})
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/vars"} {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != tt.wantStatus {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
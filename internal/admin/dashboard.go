@@ -0,0 +1,19 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardPage is the web dashboard served at the admin root. It is a
+// single page that drives the API it is served from, so it works wherever
+// the admin listener does, with no assets fetched from elsewhere.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardPage)
+}
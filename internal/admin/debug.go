@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// handleDebug serves the runtime's profiles under /debug/pprof/ and its
+// exported variables at /debug/vars, for profiling the proxy itself under
+// load. They reveal the whole process, so tenants can't use them.
+func handleDebug(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", adminOnly(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", adminOnly(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", adminOnly(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", adminOnly(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", adminOnly(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", adminOnly(pprof.Trace))
+	mux.HandleFunc("GET /debug/vars", adminOnly(expvar.Handler().ServeHTTP))
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Reload modes, picking what a reload does with a config some of whose
+// routes fail validation or fail to listen.
+const (
+	// ReloadAll applies the config only if every route in it can be: a
+	// route that fails leaves every route as it was.
+	ReloadAll = "all"
+	// ReloadValid applies the routes that can be, leaving a route that fails
+	// as it was running, or out if it is new.
+	ReloadValid = "valid"
+)
+
+// Outcomes of a route in a reload.
+const (
+	RouteAdded     = "added"
+	RouteRemoved   = "removed"
+	RouteUpdated   = "updated"
+	RouteRestarted = "restarted"
+	RouteUnchanged = "unchanged"
+	// RouteFailed means the route failed validation or to listen, and was
+	// left as it was running, if it was.
+	RouteFailed = "failed"
+	// RouteReverted means the route's change was undone because another
+	// route failed in a ReloadAll reload.
+	RouteReverted = "reverted"
+)
+
+// ReloadResult is the outcome of a config reload, answering POST /reload
+// and GET /reload/last.
+type ReloadResult struct {
+	Time time.Time `json:"time"`
+	Mode string    `json:"mode"`
+	// Trigger is what asked for the reload: "signal", "watch" or "api".
+	Trigger string `json:"trigger"`
+	// Applied reports whether the reload changed the routes, in full with
+	// ReloadAll or in part with ReloadValid.
+	Applied bool `json:"applied"`
+	// Error is why the config couldn't be applied at all, such as a file
+	// that failed to load.
+	Error  string        `json:"error,omitempty"`
+	Routes []RouteReload `json:"routes"`
+}
+
+// RouteReload is what a reload did to one route.
+type RouteReload struct {
+	Port    int    `json:"port"`
+	Name    string `json:"name,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Reloader reloads the proxy's config files for the /reload endpoints.
+type Reloader interface {
+	// Reload reloads the config files in mode, either ReloadAll or
+	// ReloadValid. It fails, wrapping ErrConflict, when the config can't be
+	// reloaded, such as when it was read from stdin.
+	Reload(mode, trigger string) (ReloadResult, error)
+	// LastReload returns the result of the latest reload, if there has been
+	// one.
+	LastReload() (ReloadResult, bool)
+}
+
+func reloadConfig(reloader Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reloader == nil {
+			http.Error(w, "config reload is not available", http.StatusNotImplemented)
+			return
+		}
+		mode := r.URL.Query().Get("mode")
+		switch mode {
+		case "":
+			mode = ReloadAll
+		case ReloadAll, ReloadValid:
+		default:
+			http.Error(w, `"mode" must be "all" or "valid"`, http.StatusBadRequest)
+			return
+		}
+		slog.Info("config reload requested through the admin API", "mode", mode, "address", r.RemoteAddr)
+		result, err := reloader.Reload(mode, "api")
+		if err != nil {
+			writeChangeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Applied {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		writeJSON(w, result)
+	}
+}
+
+func lastReload(reloader Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reloader == nil {
+			http.Error(w, "config reload is not available", http.StatusNotImplemented)
+			return
+		}
+		result, ok := reloader.LastReload()
+		if !ok {
+			http.Error(w, "the config has not been reloaded", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, result)
+	}
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeReloader answers reloads with result, applied in mode "all" only,
+// and keeps the latest.
+type fakeReloader struct {
+	result ReloadResult
+	err    error
+	modes  []string
+	last   *ReloadResult
+}
+
+func (f *fakeReloader) Reload(mode, trigger string) (ReloadResult, error) {
+	f.modes = append(f.modes, mode)
+	if f.err != nil {
+		return ReloadResult{}, f.err
+	}
+	result := f.result
+	result.Mode, result.Trigger = mode, trigger
+	result.Applied = mode == ReloadAll
+	f.last = &result
+	return result, nil
+}
+
+func (f *fakeReloader) LastReload() (ReloadResult, bool) {
+	if f.last == nil {
+		return ReloadResult{}, false
+	}
+	return *f.last, true
+}
+
+func TestReload(t *testing.T) {
+	routes := []RouteReload{
+		{Port: 8080, Outcome: RouteUpdated},
+		{Port: 8081, Outcome: RouteFailed, Error: "bind: address already in use"},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		err        error
+		wantStatus int
+		wantModes  []string
+	}{
+		{name: "default mode", path: "/reload", wantStatus: http.StatusOK, wantModes: []string{ReloadAll}},
+		{name: "all", path: "/reload?mode=all", wantStatus: http.StatusOK, wantModes: []string{ReloadAll}},
+		{name: "not applied", path: "/reload?mode=valid", wantStatus: http.StatusUnprocessableEntity, wantModes: []string{ReloadValid}},
+		{name: "unknown mode", path: "/reload?mode=some", wantStatus: http.StatusBadRequest},
+		{name: "not reloadable", path: "/reload", err: fmt.Errorf("%w: stdin", ErrConflict), wantStatus: http.StatusConflict, wantModes: []string{ReloadAll}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloader := &fakeReloader{result: ReloadResult{Routes: routes}, err: tt.err}
+			handler := NewHandler(Options{Reloader: reloader})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !reflect.DeepEqual(reloader.modes, tt.wantModes) {
+				t.Errorf("modes = %v, want %v", reloader.modes, tt.wantModes)
+			}
+			if reloader.last == nil {
+				return
+			}
+			var got ReloadResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body is not a reload result: %v", err)
+			}
+			if got.Trigger != "api" || !reflect.DeepEqual(got.Routes, routes) {
+				t.Errorf("POST %s = %+v, want trigger api and routes %+v", tt.path, got, routes)
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reload/last", nil))
+			var last ReloadResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &last); err != nil {
+				t.Fatalf("GET /reload/last body is not a reload result: %v", err)
+			}
+			if !reflect.DeepEqual(last, got) {
+				t.Errorf("GET /reload/last = %+v, want %+v", last, got)
+			}
+		})
+	}
+}
+
+func TestReload_NoneYet(t *testing.T) {
+	tests := []struct {
+		name       string
+		reloader   Reloader
+		wantStatus int
+	}{
+		{"not reloaded", &fakeReloader{}, http.StatusNotFound},
+		{"not available", nil, http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(Options{Reloader: tt.reloader})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reload/last", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// caller is who sent an admin request: the operator, who may do anything,
+// or a tenant, who may only see and change the routes that are its own.
+type caller struct {
+	// tenant is empty for the operator.
+	tenant string
+}
+
+type callerKey struct{}
+
+// callerOf returns who sent r. Without tokens configured, every request is
+// the operator's.
+func callerOf(r *http.Request) caller {
+	c, _ := r.Context().Value(callerKey{}).(caller)
+	return c
+}
+
+func (c caller) admin() bool {
+	return c.tenant == ""
+}
+
+// owns reports whether the caller may see and change the route of control.
+func (c caller) owns(control *proxy.RouteControl) bool {
+	return c.admin() || control.Tenant() == c.tenant
+}
+
+// authenticate answers requests without one of the tokens of access with
+// 401, and hands the others on with their caller.
+func authenticate(access config.Access, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chaos-proxy"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		c, ok := identify(access, token)
+		if !ok {
+			slog.Warn("admin request with an unknown token", "method", r.Method, "path", r.URL.Path, "address", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chaos-proxy", error="invalid_token"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
+	})
+}
+
+// identify returns the caller token belongs to. Every token is compared, in
+// constant time, so the time taken doesn't tell which one came close.
+func identify(access config.Access, token string) (caller, bool) {
+	var found caller
+	ok := false
+	if access.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(access.AdminToken)) == 1 {
+		ok = true
+	}
+	for tenant, tenantToken := range access.Tenants {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(tenantToken)) == 1 {
+			found, ok = caller{tenant: tenant}, true
+		}
+	}
+	return found, ok
+}
+
+// adminOnly answers tenants' requests with 403, for the endpoints that
+// reach beyond any one tenant's routes.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c := callerOf(r); !c.admin() {
+			http.Error(w, fmt.Sprintf("tenant %q may not use %s %s; it needs the admin token", c.tenant, r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ownRoutes returns the running routes the caller of r may see.
+func ownRoutes(r *http.Request, running func() []*proxy.RouteControl) []*proxy.RouteControl {
+	c := callerOf(r)
+	controls := running()
+	if c.admin() {
+		return controls
+	}
+	own := controls[:0:0]
+	for _, control := range controls {
+		if c.owns(control) {
+			own = append(own, control)
+		}
+	}
+	return own
+}
+
+// forbidden answers a tenant's request for another tenant's route.
+func forbidden(w http.ResponseWriter, r *http.Request, what string) {
+	http.Error(w, fmt.Sprintf("%s belongs to another tenant than %q", what, callerOf(r).tenant), http.StatusForbidden)
+}
+
+// claim gives route the caller's tenant, or reports false when it names
+// another one.
+func claim(r *http.Request, route *config.RouteConfig) bool {
+	c := callerOf(r)
+	if c.admin() {
+		return true
+	}
+	if route.Tenant == "" {
+		route.Tenant = c.tenant
+	}
+	return route.Tenant == c.tenant
+}
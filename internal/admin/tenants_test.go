@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestTenants(t *testing.T) {
+	const (
+		adminToken    = "admin-secret"
+		paymentsToken = "payments-secret"
+	)
+	table := &routeTable{}
+	table.AddRoute(config.RouteConfig{Name: "payments", LocalPort: 8080, Upstream: "127.0.0.1:9090", Tenant: "payments"})
+	table.AddRoute(config.RouteConfig{Name: "search", LocalPort: 8081, Upstream: "127.0.0.1:9091", Tenant: "search"})
+	global := chaos.NewIntensity(1)
+	tenantIntensity := map[string]*chaos.Intensity{
+		"payments": chaos.NewScopedIntensity(global),
+		"search":   chaos.NewScopedIntensity(global),
+	}
+	handler := NewHandler(Options{
+		Intensity:       global,
+		TenantIntensity: tenantIntensity,
+		Routes:          table.running,
+		Changes:         table,
+		Reloader:        &fakeReloader{},
+		Access: config.Access{
+			AdminToken: adminToken,
+			Tenants:    map[string]string{"payments": paymentsToken, "search": "search-secret"},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/routes", wantStatus: http.StatusUnauthorized},
+		{name: "unknown token", token: "guess", method: http.MethodGet, path: "/routes", wantStatus: http.StatusUnauthorized},
+		{name: "own route", token: paymentsToken, method: http.MethodGet, path: "/routes/8080", wantStatus: http.StatusOK},
+		{name: "another tenant's route", token: paymentsToken, method: http.MethodGet, path: "/routes/8081", wantStatus: http.StatusForbidden},
+		{name: "switch another tenant's route", token: paymentsToken, method: http.MethodPut, path: "/routes/8081", body: `{"enabled": false}`, wantStatus: http.StatusForbidden},
+		{name: "change another tenant's route", token: paymentsToken, method: http.MethodPatch, path: "/routes/8081/config", body: `{"latencyMs": 100}`, wantStatus: http.StatusForbidden},
+		{name: "give a route away", token: paymentsToken, method: http.MethodPatch, path: "/routes/8080/config", body: `{"tenant": "search"}`, wantStatus: http.StatusForbidden},
+		{name: "remove another tenant's route", token: paymentsToken, method: http.MethodDelete, path: "/routes/8081", wantStatus: http.StatusForbidden},
+		{name: "another tenant's connections", token: paymentsToken, method: http.MethodGet, path: "/routes/8081/connections", wantStatus: http.StatusForbidden},
+		{name: "kill another tenant's connections", token: paymentsToken, method: http.MethodDelete, path: "/routes/8081/connections", wantStatus: http.StatusForbidden},
+		{name: "add a route for another tenant", token: paymentsToken, method: http.MethodPost, path: "/routes", body: `{"localPort": 8082, "upstream": "127.0.0.1:9092", "tenant": "search"}`, wantStatus: http.StatusForbidden},
+		{name: "another tenant's proxy", token: paymentsToken, method: http.MethodGet, path: "/proxies/search", wantStatus: http.StatusForbidden},
+		{name: "toxic on another tenant's proxy", token: paymentsToken, method: http.MethodPost, path: "/proxies/search/toxics", body: `{"type": "latency", "attributes": {"latency": 100}}`, wantStatus: http.StatusForbidden},
+		{name: "populate another tenant's proxy", token: paymentsToken, method: http.MethodPost, path: "/populate", body: `[{"name": "search", "listen": "127.0.0.1:8081", "upstream": "127.0.0.1:9099"}]`, wantStatus: http.StatusForbidden},
+		{name: "reload", token: paymentsToken, method: http.MethodPost, path: "/reload", wantStatus: http.StatusForbidden},
+		{name: "last reload", token: paymentsToken, method: http.MethodGet, path: "/reload/last", wantStatus: http.StatusForbidden},
+		{name: "report", token: paymentsToken, method: http.MethodGet, path: "/report", wantStatus: http.StatusForbidden},
+		{name: "admin reaches every route", token: adminToken, method: http.MethodGet, path: "/routes/8081", wantStatus: http.StatusOK},
+		{name: "admin reloads", token: adminToken, method: http.MethodPost, path: "/reload", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tenantCall(handler, tt.token, tt.method, tt.path, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d (body %q)", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+
+	// Nothing denied was changed.
+	search := table.controls[1]
+	if !search.Enabled() || search.Route().LatencyMs != 0 || len(search.Route().Toxics) != 0 || search.Upstream() != "127.0.0.1:9091" {
+		t.Errorf("search route = enabled %v, %+v after denied requests, want it untouched", search.Enabled(), search.Route())
+	}
+
+	var routes []route
+	rec := tenantCall(handler, paymentsToken, http.MethodGet, "/routes", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("GET /routes returned %q: %v", rec.Body.String(), err)
+	}
+	if len(routes) != 1 || routes[0].Port != 8080 {
+		t.Errorf("GET /routes for payments = %+v, want the payments route only", routes)
+	}
+	var proxies map[string]toxiproxyProxy
+	rec = tenantCall(handler, paymentsToken, http.MethodGet, "/proxies", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &proxies); err != nil {
+		t.Fatalf("GET /proxies returned %q: %v", rec.Body.String(), err)
+	}
+	if _, ok := proxies["search"]; ok || len(proxies) != 1 {
+		t.Errorf("GET /proxies for payments = %v, want the payments proxy only", proxies)
+	}
+
+	// A route added without a tenant is the caller's.
+	rec = tenantCall(handler, paymentsToken, http.MethodPost, "/routes", `{"localPort": 8082, "upstream": "127.0.0.1:9092"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /routes status = %d, want %d (body %q)", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	if tenant := table.controls[2].Tenant(); tenant != "payments" {
+		t.Errorf("added route's tenant = %q, want payments", tenant)
+	}
+
+	// A tenant's chaos scale is its own, on top of the global one.
+	rec = tenantCall(handler, paymentsToken, http.MethodPut, "/chaos-scale", `{"scale": 0.5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /chaos-scale status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	tenantCall(handler, adminToken, http.MethodPut, "/chaos-scale", `{"scale": 2}`)
+	if got := tenantIntensity["payments"].Scale(); got != 1 {
+		t.Errorf("payments scale = %v, want 0.5 of the global 2", got)
+	}
+	if got := tenantIntensity["search"].Scale(); got != 2 {
+		t.Errorf("search scale = %v, want the global 2", got)
+	}
+	var scale chaosScale
+	rec = tenantCall(handler, paymentsToken, http.MethodGet, "/chaos-scale", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &scale); err != nil || scale.Scale == nil || *scale.Scale != 0.5 {
+		t.Errorf("GET /chaos-scale for payments = %q, want its own scale 0.5", rec.Body.String())
+	}
+}
+
+func TestTenants_Open(t *testing.T) {
+	table := &routeTable{}
+	table.AddRoute(config.RouteConfig{LocalPort: 8081, Upstream: "127.0.0.1:9091", Tenant: "search"})
+	handler := NewHandler(Options{Routes: table.running})
+	if rec := tenantCall(handler, "", http.MethodGet, "/routes/8081", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET /routes/8081 without tokens configured = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// tenantCall sends a request to handler with token as its bearer token, if
+// given.
+func tenantCall(handler http.Handler, token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
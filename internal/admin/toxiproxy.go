@@ -0,0 +1,671 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// Toxiproxy toxic types that stand for toxics of another type here.
+// latency and bandwidth share their names.
+const (
+	toxiproxySlicer    = "slicer"
+	toxiproxyLimitData = "limit_data"
+	toxiproxyTimeout   = "timeout"
+)
+
+// toxiproxyProxy is a route as the Toxiproxy API reports it. A proxy is
+// named by its route's name, or its local port for a route without one.
+type toxiproxyProxy struct {
+	Name     string           `json:"name"`
+	Listen   string           `json:"listen"`
+	Upstream string           `json:"upstream"`
+	Enabled  bool             `json:"enabled"`
+	Toxics   []toxiproxyToxic `json:"toxics"`
+}
+
+// toxiproxyProxyInput is the body that creates or changes a proxy. Omitted
+// fields are left as they are, or defaulted for a new proxy.
+type toxiproxyProxyInput struct {
+	Name     string  `json:"name"`
+	Listen   *string `json:"listen"`
+	Upstream *string `json:"upstream"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+// toxiproxyToxic is one of a route's toxics as the Toxiproxy API reports
+// it.
+type toxiproxyToxic struct {
+	Name       string                     `json:"name"`
+	Type       string                     `json:"type"`
+	Stream     string                     `json:"stream"`
+	Toxicity   float64                    `json:"toxicity"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// toxiproxyToxicInput is the body that adds or changes a toxic. Omitted
+// fields are left as they are, or defaulted for a new toxic; attributes
+// are merged over the toxic's.
+type toxiproxyToxicInput struct {
+	Name       string                     `json:"name"`
+	Type       string                     `json:"type"`
+	Stream     *string                    `json:"stream"`
+	Toxicity   *float64                   `json:"toxicity"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+}
+
+// handleToxiproxy serves the Toxiproxy HTTP API on mux, so Toxiproxy
+// clients can drive the routes as proxies and their toxics.
+func handleToxiproxy(mux *http.ServeMux, running func() []*proxy.RouteControl, changes RouteChanges) {
+	t := toxiproxyAPI{running: running, changes: changes}
+	mux.HandleFunc("GET /proxies", t.listProxies)
+	mux.HandleFunc("POST /proxies", t.createProxy)
+	mux.HandleFunc("POST /populate", t.populate)
+	mux.HandleFunc("GET /proxies/{proxy}", t.getProxy)
+	mux.HandleFunc("POST /proxies/{proxy}", t.updateProxy)
+	mux.HandleFunc("PATCH /proxies/{proxy}", t.updateProxy)
+	mux.HandleFunc("DELETE /proxies/{proxy}", t.deleteProxy)
+	mux.HandleFunc("GET /proxies/{proxy}/toxics", t.listToxics)
+	mux.HandleFunc("POST /proxies/{proxy}/toxics", t.createToxic)
+	mux.HandleFunc("GET /proxies/{proxy}/toxics/{toxic}", t.getToxic)
+	mux.HandleFunc("POST /proxies/{proxy}/toxics/{toxic}", t.updateToxic)
+	mux.HandleFunc("PATCH /proxies/{proxy}/toxics/{toxic}", t.updateToxic)
+	mux.HandleFunc("DELETE /proxies/{proxy}/toxics/{toxic}", t.deleteToxic)
+	mux.HandleFunc("POST /reset", t.reset)
+	mux.HandleFunc("GET /version", t.version)
+}
+
+type toxiproxyAPI struct {
+	running func() []*proxy.RouteControl
+	changes RouteChanges
+}
+
+func (t toxiproxyAPI) listProxies(w http.ResponseWriter, r *http.Request) {
+	proxies := make(map[string]toxiproxyProxy)
+	for _, c := range ownRoutes(r, t.running) {
+		p := newToxiproxyProxy(c)
+		proxies[p.Name] = p
+	}
+	writeJSON(w, proxies)
+}
+
+func (t toxiproxyAPI) getProxy(w http.ResponseWriter, r *http.Request) {
+	if control := t.findProxy(w, r); control != nil {
+		writeJSON(w, newToxiproxyProxy(control))
+	}
+}
+
+func (t toxiproxyAPI) createProxy(w http.ResponseWriter, r *http.Request) {
+	var body toxiproxyProxyInput
+	if !decodeToxiproxyBody(w, r, &body) {
+		return
+	}
+	created, err := t.create(r, body)
+	if err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("proxy created through the Toxiproxy API", "proxy", created.Name, "listen", created.Listen, "address", r.RemoteAddr)
+	writeToxiproxyJSON(w, http.StatusCreated, created)
+}
+
+// populate creates every proxy of the body, moving any of the same name
+// to the addresses given, and leaves the other proxies alone.
+func (t toxiproxyAPI) populate(w http.ResponseWriter, r *http.Request) {
+	var body []toxiproxyProxyInput
+	if !decodeToxiproxyBody(w, r, &body) {
+		return
+	}
+	populated := []toxiproxyProxy{}
+	for _, input := range body {
+		var p toxiproxyProxy
+		var err error
+		if control := t.lookup(input.Name); control == nil {
+			p, err = t.create(r, input)
+		} else if callerOf(r).owns(control) {
+			p, err = t.update(control, input)
+		} else {
+			writeToxiproxyError(w, http.StatusForbidden, fmt.Sprintf("proxy %q belongs to another tenant than %q", input.Name, callerOf(r).tenant))
+			return
+		}
+		if err != nil {
+			writeToxiproxyChangeError(w, fmt.Errorf("proxy %q: %w", input.Name, err))
+			return
+		}
+		populated = append(populated, p)
+	}
+	slog.Info("proxies populated through the Toxiproxy API", "proxies", len(populated), "address", r.RemoteAddr)
+	writeToxiproxyJSON(w, http.StatusCreated, map[string][]toxiproxyProxy{"proxies": populated})
+}
+
+func (t toxiproxyAPI) updateProxy(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	var body toxiproxyProxyInput
+	if !decodeToxiproxyBody(w, r, &body) {
+		return
+	}
+	updated, err := t.update(control, body)
+	if err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("proxy changed through the Toxiproxy API", "proxy", updated.Name, "listen", updated.Listen, "enabled", updated.Enabled, "address", r.RemoteAddr)
+	writeJSON(w, updated)
+}
+
+func (t toxiproxyAPI) deleteProxy(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	if t.changes == nil {
+		writeToxiproxyError(w, http.StatusNotImplemented, "proxies can't be deleted through this API")
+		return
+	}
+	if err := t.changes.RemoveRoute(control.Port()); err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("proxy deleted through the Toxiproxy API", "proxy", r.PathValue("proxy"), "address", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t toxiproxyAPI) listToxics(w http.ResponseWriter, r *http.Request) {
+	if control := t.findProxy(w, r); control != nil {
+		writeJSON(w, newToxiproxyProxy(control).Toxics)
+	}
+}
+
+func (t toxiproxyAPI) getToxic(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	if i := findToxic(w, r, control); i >= 0 {
+		writeJSON(w, newToxiproxyToxic(control.Route().Toxics[i]))
+	}
+}
+
+func (t toxiproxyAPI) createToxic(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	var body toxiproxyToxicInput
+	if !decodeToxiproxyBody(w, r, &body) {
+		return
+	}
+	stream := config.StreamDownstream
+	if body.Stream != nil {
+		stream = *body.Stream
+	}
+	toxicity := 1.0
+	if body.Toxicity != nil {
+		toxicity = *body.Toxicity
+	}
+	if body.Name == "" {
+		body.Name = body.Type + "_" + stream
+	}
+	toxic, err := parseToxiproxyToxic(toxiproxyToxic{Name: body.Name, Type: body.Type, Stream: stream, Toxicity: toxicity, Attributes: body.Attributes})
+	if err != nil {
+		writeToxiproxyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	route := control.Route()
+	if toxicIndex(route, body.Name) >= 0 {
+		writeToxiproxyError(w, http.StatusConflict, fmt.Sprintf("toxic %q already exists", body.Name))
+		return
+	}
+	route.Toxics = append(slices.Clone(route.Toxics), toxic)
+	if err := t.replace(control, route); err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("toxic added through the Toxiproxy API", "proxy", r.PathValue("proxy"), "toxic", body.Name, "type", body.Type, "address", r.RemoteAddr)
+	writeJSON(w, newToxiproxyToxic(toxic))
+}
+
+func (t toxiproxyAPI) updateToxic(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	i := findToxic(w, r, control)
+	if i < 0 {
+		return
+	}
+	var body toxiproxyToxicInput
+	if !decodeToxiproxyBody(w, r, &body) {
+		return
+	}
+
+	route := control.Route()
+	current := newToxiproxyToxic(route.Toxics[i])
+	if body.Type != "" && body.Type != current.Type {
+		writeToxiproxyError(w, http.StatusBadRequest, fmt.Sprintf("toxic %q is a %s toxic; delete it and add a new one to change its type", current.Name, current.Type))
+		return
+	}
+	if body.Stream != nil {
+		current.Stream = *body.Stream
+	}
+	if body.Toxicity != nil {
+		current.Toxicity = *body.Toxicity
+	}
+	maps.Copy(current.Attributes, body.Attributes)
+	toxic, err := parseToxiproxyToxic(current)
+	if err != nil {
+		writeToxiproxyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// A toxic named by default keeps its settings' name in the config.
+	toxic.Name = route.Toxics[i].Name
+
+	route.Toxics = slices.Clone(route.Toxics)
+	route.Toxics[i] = toxic
+	if err := t.replace(control, route); err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("toxic changed through the Toxiproxy API", "proxy", r.PathValue("proxy"), "toxic", current.Name, "address", r.RemoteAddr)
+	writeJSON(w, newToxiproxyToxic(toxic))
+}
+
+func (t toxiproxyAPI) deleteToxic(w http.ResponseWriter, r *http.Request) {
+	control := t.findProxy(w, r)
+	if control == nil {
+		return
+	}
+	i := findToxic(w, r, control)
+	if i < 0 {
+		return
+	}
+	route := control.Route()
+	route.Toxics = slices.Delete(slices.Clone(route.Toxics), i, i+1)
+	if err := t.replace(control, route); err != nil {
+		writeToxiproxyChangeError(w, err)
+		return
+	}
+	slog.Info("toxic removed through the Toxiproxy API", "proxy", r.PathValue("proxy"), "toxic", r.PathValue("toxic"), "address", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reset enables every proxy and removes every toxic, as Toxiproxy clients
+// do between tests. A tenant's reset touches its own proxies only.
+func (t toxiproxyAPI) reset(w http.ResponseWriter, r *http.Request) {
+	for _, control := range ownRoutes(r, t.running) {
+		control.SetEnabled(true)
+		route := control.Route()
+		if len(route.Toxics) == 0 {
+			continue
+		}
+		route.Toxics = nil
+		if err := t.replace(control, route); err != nil {
+			writeToxiproxyChangeError(w, err)
+			return
+		}
+	}
+	slog.Info("proxies reset through the Toxiproxy API", "address", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t toxiproxyAPI) version(w http.ResponseWriter, r *http.Request) {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	writeJSON(w, map[string]string{"version": version})
+}
+
+// create adds a route for the new proxy input, owned by the tenant sending
+// r.
+func (t toxiproxyAPI) create(r *http.Request, input toxiproxyProxyInput) (toxiproxyProxy, error) {
+	if input.Name == "" {
+		return toxiproxyProxy{}, fmt.Errorf("%w: missing proxy name", ErrInvalidRoute)
+	}
+	if t.lookup(input.Name) != nil {
+		return toxiproxyProxy{}, fmt.Errorf("%w: proxy %q already exists", ErrConflict, input.Name)
+	}
+	if input.Listen == nil || input.Upstream == nil {
+		return toxiproxyProxy{}, fmt.Errorf("%w: proxy %q needs a listen and an upstream address", ErrInvalidRoute, input.Name)
+	}
+	if t.changes == nil {
+		return toxiproxyProxy{}, errNoChanges
+	}
+	route := config.RouteConfig{Name: input.Name, Upstream: *input.Upstream, Tenant: callerOf(r).tenant}
+	if err := setListen(&route, *input.Listen); err != nil {
+		return toxiproxyProxy{}, err
+	}
+	if err := t.changes.AddRoute(route); err != nil {
+		return toxiproxyProxy{}, err
+	}
+	control := t.lookup(input.Name)
+	if control == nil {
+		return toxiproxyProxy{}, fmt.Errorf("%w %q after adding it", ErrNoRoute, input.Name)
+	}
+	if input.Enabled != nil {
+		control.SetEnabled(*input.Enabled)
+	}
+	return newToxiproxyProxy(control), nil
+}
+
+// update moves the proxy of control to the addresses of input, and
+// switches it on or off.
+func (t toxiproxyAPI) update(control *proxy.RouteControl, input toxiproxyProxyInput) (toxiproxyProxy, error) {
+	route := control.Route()
+	if input.Upstream != nil {
+		route.Upstream = *input.Upstream
+	}
+	if input.Listen != nil {
+		if err := setListen(&route, *input.Listen); err != nil {
+			return toxiproxyProxy{}, err
+		}
+	}
+	if route.Upstream != control.Route().Upstream || route.ListenAddr() != control.Route().ListenAddr() {
+		name := proxyName(control)
+		if err := t.replace(control, route); err != nil {
+			return toxiproxyProxy{}, err
+		}
+		// A new listener comes with a new control.
+		if control = t.lookup(name); control == nil {
+			return toxiproxyProxy{}, fmt.Errorf("%w %q after changing it", ErrNoRoute, name)
+		}
+	}
+	if input.Enabled != nil {
+		control.SetEnabled(*input.Enabled)
+	}
+	return newToxiproxyProxy(control), nil
+}
+
+// replace moves the route of control to route's settings.
+func (t toxiproxyAPI) replace(control *proxy.RouteControl, route config.RouteConfig) error {
+	if t.changes == nil {
+		return errNoChanges
+	}
+	return t.changes.ReplaceRoute(control.Port(), route)
+}
+
+// errNoChanges is answered when the routes can't be changed at all.
+var errNoChanges = errors.New("proxies can't be changed through this API")
+
+// lookup returns the control of the proxy named name, or nil.
+func (t toxiproxyAPI) lookup(name string) *proxy.RouteControl {
+	for _, c := range t.running() {
+		if proxyName(c) == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// findProxy returns the control of the request's proxy, or answers the
+// request with an error and returns nil.
+func (t toxiproxyAPI) findProxy(w http.ResponseWriter, r *http.Request) *proxy.RouteControl {
+	name := r.PathValue("proxy")
+	if control := t.lookup(name); control != nil {
+		if !callerOf(r).owns(control) {
+			writeToxiproxyError(w, http.StatusForbidden, fmt.Sprintf("proxy %q belongs to another tenant than %q", name, callerOf(r).tenant))
+			return nil
+		}
+		return control
+	}
+	writeToxiproxyError(w, http.StatusNotFound, fmt.Sprintf("proxy %q not found", name))
+	return nil
+}
+
+// findToxic returns the index of the request's toxic among the route's, or
+// answers the request with an error and returns -1.
+func findToxic(w http.ResponseWriter, r *http.Request, control *proxy.RouteControl) int {
+	name := r.PathValue("toxic")
+	i := toxicIndex(control.Route(), name)
+	if i < 0 {
+		writeToxiproxyError(w, http.StatusNotFound, fmt.Sprintf("toxic %q not found", name))
+	}
+	return i
+}
+
+func toxicIndex(route config.RouteConfig, name string) int {
+	return slices.IndexFunc(route.Toxics, func(toxic config.ToxicConfig) bool {
+		return newToxiproxyToxic(toxic).Name == name
+	})
+}
+
+// proxyName is the name of the proxy a route stands for.
+func proxyName(c *proxy.RouteControl) string {
+	if name := c.Name(); name != "" {
+		return name
+	}
+	return strconv.Itoa(c.Port())
+}
+
+func newToxiproxyProxy(c *proxy.RouteControl) toxiproxyProxy {
+	route := c.Route()
+	toxics := make([]toxiproxyToxic, len(route.Toxics))
+	for i, toxic := range route.Toxics {
+		toxics[i] = newToxiproxyToxic(toxic)
+	}
+	return toxiproxyProxy{
+		Name:     proxyName(c),
+		Listen:   net.JoinHostPort(route.ListenHost(), strconv.Itoa(c.Port())),
+		Upstream: c.Upstream(),
+		Enabled:  c.Enabled(),
+		Toxics:   toxics,
+	}
+}
+
+// setListen sets the listen address and port of route from a Toxiproxy
+// listen address such as "localhost:26379".
+func setListen(route *config.RouteConfig, listen string) error {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("%w: listen %q: %v", ErrInvalidRoute, listen, err)
+	}
+	route.LocalPort, err = strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("%w: listen %q: port must be a number", ErrInvalidRoute, listen)
+	}
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	route.ListenAddress = host
+	return nil
+}
+
+// Toxiproxy attributes of the toxic types that differ from the toxics
+// here.
+type (
+	latencyAttributes struct {
+		Latency int `json:"latency"`
+		Jitter  int `json:"jitter"`
+	}
+	bandwidthAttributes struct {
+		// Rate is in KB/s.
+		Rate int64 `json:"rate"`
+	}
+	slicerAttributes struct {
+		AverageSize   int `json:"average_size"`
+		SizeVariation int `json:"size_variation"`
+		// Delay is in microseconds.
+		Delay int `json:"delay"`
+	}
+	limitDataAttributes struct {
+		Bytes int64 `json:"bytes"`
+	}
+	timeoutAttributes struct {
+		Timeout int `json:"timeout"`
+	}
+	rateAttributes struct {
+		Rate float64 `json:"rate"`
+	}
+)
+
+// newToxiproxyToxic returns toxic as the Toxiproxy API reports it, as the
+// Toxiproxy toxic type it stands for where there is one.
+func newToxiproxyToxic(toxic config.ToxicConfig) toxiproxyToxic {
+	var toxicType string
+	var attributes any
+	switch {
+	case toxic.Type == config.ToxicLatency:
+		toxicType, attributes = toxic.Type, latencyAttributes{Latency: int(toxic.LatencyMs), Jitter: int(toxic.JitterMs)}
+	case toxic.Type == config.ToxicBandwidth:
+		toxicType, attributes = toxic.Type, bandwidthAttributes{Rate: toxic.BytesPerSecond / 1000}
+	case toxic.Type == config.ToxicSegment:
+		minBytes, maxBytes := toxic.SegmentBytes()
+		toxicType, attributes = toxiproxySlicer, slicerAttributes{
+			AverageSize:   (minBytes + maxBytes) / 2,
+			SizeVariation: (maxBytes - minBytes) / 2,
+			Delay:         int(toxic.LatencyMs) * 1000,
+		}
+	case toxic.Type == config.ToxicTruncate:
+		toxicType, attributes = toxiproxyLimitData, limitDataAttributes{Bytes: toxic.Bytes}
+	case toxic.Type == config.ToxicDrop && toxic.Rate == 1:
+		toxicType, attributes = toxiproxyTimeout, timeoutAttributes{}
+	default:
+		toxicType, attributes = toxic.Type, rateAttributes{Rate: toxic.Rate}
+	}
+
+	stream := toxic.Stream
+	if stream == "" {
+		stream = config.StreamBoth
+	}
+	name := toxic.Name
+	if name == "" {
+		name = toxicType + "_" + stream
+	}
+	// Attributes are plain structs, which always marshal.
+	data, _ := json.Marshal(attributes)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	return toxiproxyToxic{
+		Name:       name,
+		Type:       toxicType,
+		Stream:     stream,
+		Toxicity:   toxic.ToxicityOrDefault(),
+		Attributes: fields,
+	}
+}
+
+// parseToxiproxyToxic returns the toxic a Toxiproxy toxic stands for.
+func parseToxiproxyToxic(t toxiproxyToxic) (config.ToxicConfig, error) {
+	toxicity := t.Toxicity
+	toxic := config.ToxicConfig{Name: t.Name, Stream: t.Stream, Toxicity: &toxicity}
+	switch t.Type {
+	case config.ToxicLatency:
+		var a latencyAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		toxic.Type, toxic.LatencyMs, toxic.JitterMs = config.ToxicLatency, config.Milliseconds(a.Latency), config.Milliseconds(a.Jitter)
+	case config.ToxicBandwidth:
+		var a bandwidthAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		toxic.Type, toxic.BytesPerSecond = config.ToxicBandwidth, a.Rate*1000
+	case toxiproxySlicer:
+		var a slicerAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		toxic.Type = config.ToxicSegment
+		toxic.MinBytes = max(a.AverageSize-a.SizeVariation, 1)
+		toxic.MaxBytes = max(a.AverageSize+a.SizeVariation, toxic.MinBytes)
+		toxic.LatencyMs = config.Milliseconds(a.Delay / 1000)
+	case toxiproxyLimitData:
+		var a limitDataAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		toxic.Type, toxic.Bytes = config.ToxicTruncate, a.Bytes
+	case toxiproxyTimeout:
+		var a timeoutAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		if a.Timeout != 0 {
+			return toxic, fmt.Errorf("timeout toxic %q: only timeout 0, which holds data forever, is supported", t.Name)
+		}
+		toxic.Type, toxic.Rate = config.ToxicDrop, 1
+	case config.ToxicCorrupt, config.ToxicDrop:
+		var a rateAttributes
+		if err := decodeAttributes(t, &a); err != nil {
+			return toxic, err
+		}
+		toxic.Type, toxic.Rate = t.Type, a.Rate
+	default:
+		return toxic, fmt.Errorf("toxic %q: unsupported toxic type %q, want one of latency, bandwidth, slicer, limit_data, timeout, corrupt or drop", t.Name, t.Type)
+	}
+	if t.Stream != config.StreamDownstream && t.Stream != config.StreamUpstream && t.Stream != config.StreamBoth {
+		return toxic, fmt.Errorf("toxic %q: stream must be upstream, downstream or both, got %q", t.Name, t.Stream)
+	}
+	return toxic, nil
+}
+
+// decodeAttributes decodes the attributes of t into v, rejecting any that
+// its type doesn't take.
+func decodeAttributes(t toxiproxyToxic, v any) error {
+	data, err := json.Marshal(t.Attributes)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid attributes for %s toxic %q: %v", t.Type, t.Name, err)
+	}
+	return nil
+}
+
+// decodeToxiproxyBody decodes the request body into v, or answers the
+// request with an error and returns false. Unknown fields are ignored, as
+// Toxiproxy clients send back whole proxies they read.
+func decodeToxiproxyBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRouteBody)).Decode(v); err != nil {
+		writeToxiproxyError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeToxiproxyChangeError answers a request whose change failed, as
+// writeChangeError does but in the error format Toxiproxy clients parse.
+func writeToxiproxyChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoChanges):
+		writeToxiproxyError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, ErrNoRoute):
+		writeToxiproxyError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidRoute):
+		writeToxiproxyError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrConflict):
+		writeToxiproxyError(w, http.StatusConflict, err.Error())
+	default:
+		writeToxiproxyError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeToxiproxyError(w http.ResponseWriter, status int, message string) {
+	writeToxiproxyJSON(w, status, map[string]any{"error": message, "status": status})
+}
+
+func writeToxiproxyJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode admin response", "error", err)
+	}
+}
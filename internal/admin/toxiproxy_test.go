@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// routeTable is an in-memory set of running routes, changed as the proxy
+// would change them.
+type routeTable struct {
+	controls []*proxy.RouteControl
+}
+
+func (r *routeTable) running() []*proxy.RouteControl {
+	return r.controls
+}
+
+func (r *routeTable) AddRoute(route config.RouteConfig) error {
+	r.controls = append(r.controls, proxy.NewRouteControl(route))
+	return nil
+}
+
+func (r *routeTable) ReplaceRoute(port int, route config.RouteConfig) error {
+	i := slices.IndexFunc(r.controls, func(c *proxy.RouteControl) bool { return c.Port() == port })
+	if i < 0 {
+		return ErrNoRoute
+	}
+	enabled := r.controls[i].Enabled()
+	r.controls[i] = proxy.NewRouteControl(route)
+	r.controls[i].SetEnabled(enabled)
+	return nil
+}
+
+func (r *routeTable) RemoveRoute(port int) error {
+	i := slices.IndexFunc(r.controls, func(c *proxy.RouteControl) bool { return c.Port() == port })
+	if i < 0 {
+		return ErrNoRoute
+	}
+	r.controls = slices.Delete(r.controls, i, i+1)
+	return nil
+}
+
+// toxiproxyCall makes a request to the Toxiproxy API and decodes its JSON
+// answer into out, if given.
+func toxiproxyCall(t *testing.T, server *httptest.Server, method, path, body string, wantStatus int, out any) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s status = %d, want %d", method, path, resp.StatusCode, wantStatus)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode answer: %v", method, path, err)
+		}
+	}
+}
+
+func TestToxiproxy(t *testing.T) {
+	table := &routeTable{}
+	table.AddRoute(config.RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9000", Toxics: []config.ToxicConfig{{Type: config.ToxicDrop, Rate: 0.5}}})
+	server := httptest.NewServer(NewHandler(Options{Routes: table.running, Changes: table}))
+	defer server.Close()
+
+	var created toxiproxyProxy
+	toxiproxyCall(t, server, http.MethodPost, "/proxies", `{"name": "redis", "listen": "localhost:26379", "upstream": "127.0.0.1:6379", "enabled": true}`, http.StatusCreated, &created)
+	wantProxy := toxiproxyProxy{Name: "redis", Listen: "127.0.0.1:26379", Upstream: "127.0.0.1:6379", Enabled: true, Toxics: []toxiproxyToxic{}}
+	if !reflect.DeepEqual(created, wantProxy) {
+		t.Errorf("created proxy = %+v, want %+v", created, wantProxy)
+	}
+	toxiproxyCall(t, server, http.MethodPost, "/proxies", `{"name": "redis", "listen": "localhost:26380", "upstream": "127.0.0.1:6379"}`, http.StatusConflict, nil)
+
+	var toxic toxiproxyToxic
+	toxiproxyCall(t, server, http.MethodPost, "/proxies/redis/toxics", `{"type": "latency", "attributes": {"latency": 100, "jitter": 10}}`, http.StatusOK, &toxic)
+	if toxic.Name != "latency_downstream" || toxic.Stream != config.StreamDownstream || toxic.Toxicity != 1 {
+		t.Errorf("added toxic = %+v, want latency_downstream with toxicity 1", toxic)
+	}
+	toxiproxyCall(t, server, http.MethodPost, "/proxies/redis/toxics", `{"type": "latency", "attributes": {"latency": 5}}`, http.StatusConflict, nil)
+	toxiproxyCall(t, server, http.MethodPost, "/proxies/redis/toxics", `{"type": "reset_peer"}`, http.StatusBadRequest, nil)
+
+	toxiproxyCall(t, server, http.MethodPost, "/proxies/redis/toxics/latency_downstream", `{"toxicity": 0.5, "attributes": {"latency": 200}}`, http.StatusOK, &toxic)
+	wantToxics := []config.ToxicConfig{{Name: "latency_downstream", Type: config.ToxicLatency, Stream: config.StreamDownstream, Toxicity: float64Ptr(0.5), LatencyMs: 200, JitterMs: 10}}
+	if got := table.controls[1].Route().Toxics; !reflect.DeepEqual(got, wantToxics) {
+		t.Errorf("route toxics after update = %+v, want %+v", got, wantToxics)
+	}
+
+	var proxies map[string]toxiproxyProxy
+	toxiproxyCall(t, server, http.MethodGet, "/proxies", "", http.StatusOK, &proxies)
+	if len(proxies) != 2 || proxies["8080"].Toxics[0].Type != config.ToxicDrop || len(proxies["redis"].Toxics) != 1 {
+		t.Errorf("proxies = %+v, want the unnamed route as 8080 with its drop toxic and redis with its latency toxic", proxies)
+	}
+
+	toxiproxyCall(t, server, http.MethodPost, "/proxies/redis", `{"enabled": false}`, http.StatusOK, &created)
+	if created.Enabled || table.controls[1].Enabled() {
+		t.Error("proxy still enabled after disabling it")
+	}
+
+	toxiproxyCall(t, server, http.MethodPost, "/reset", "", http.StatusNoContent, nil)
+	for _, c := range table.controls {
+		if !c.Enabled() || len(c.Route().Toxics) != 0 {
+			t.Errorf("route %d after reset: enabled %v, toxics %+v, want enabled without toxics", c.Port(), c.Enabled(), c.Route().Toxics)
+		}
+	}
+
+	var apiErr struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}
+	toxiproxyCall(t, server, http.MethodGet, "/proxies/redis/toxics/latency_downstream", "", http.StatusNotFound, &apiErr)
+	if apiErr.Status != http.StatusNotFound || !strings.Contains(apiErr.Error, "latency_downstream") {
+		t.Errorf("error = %+v, want a 404 naming the toxic", apiErr)
+	}
+
+	toxiproxyCall(t, server, http.MethodDelete, "/proxies/redis", "", http.StatusNoContent, nil)
+	toxiproxyCall(t, server, http.MethodGet, "/proxies/redis", "", http.StatusNotFound, nil)
+}
+
+func TestToxiproxy_Populate(t *testing.T) {
+	table := &routeTable{}
+	table.AddRoute(config.RouteConfig{Name: "redis", LocalPort: 26379, Upstream: "127.0.0.1:6379"})
+	server := httptest.NewServer(NewHandler(Options{Routes: table.running, Changes: table}))
+	defer server.Close()
+
+	var populated struct {
+		Proxies []toxiproxyProxy `json:"proxies"`
+	}
+	toxiproxyCall(t, server, http.MethodPost, "/populate", `[
+		{"name": "redis", "listen": "127.0.0.1:26379", "upstream": "127.0.0.1:6380"},
+		{"name": "postgres", "listen": "127.0.0.1:25432", "upstream": "127.0.0.1:5432"}
+	]`, http.StatusCreated, &populated)
+
+	var names []string
+	for _, p := range populated.Proxies {
+		names = append(names, p.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"redis", "postgres"}) {
+		t.Errorf("populated proxies = %v, want [redis postgres]", names)
+	}
+	if got := table.controls[0].Upstream(); got != "127.0.0.1:6380" {
+		t.Errorf("redis upstream = %q, want it moved to 127.0.0.1:6380", got)
+	}
+}
+
+func TestToxiproxyToxics(t *testing.T) {
+	tests := []struct {
+		name  string
+		toxic toxiproxyToxic
+		want  config.ToxicConfig
+	}{
+		{
+			name:  "bandwidth in KB/s",
+			toxic: toxiproxyToxic{Type: "bandwidth", Stream: "upstream", Attributes: attributes(`{"rate": 64}`)},
+			want:  config.ToxicConfig{Type: config.ToxicBandwidth, Stream: "upstream", BytesPerSecond: 64000},
+		},
+		{
+			name:  "slicer as segment",
+			toxic: toxiproxyToxic{Type: "slicer", Stream: "downstream", Attributes: attributes(`{"average_size": 8, "size_variation": 4, "delay": 2000}`)},
+			want:  config.ToxicConfig{Type: config.ToxicSegment, Stream: "downstream", MinBytes: 4, MaxBytes: 12, LatencyMs: 2},
+		},
+		{
+			name:  "limit_data as truncate",
+			toxic: toxiproxyToxic{Type: "limit_data", Stream: "downstream", Attributes: attributes(`{"bytes": 512}`)},
+			want:  config.ToxicConfig{Type: config.ToxicTruncate, Stream: "downstream", Bytes: 512},
+		},
+		{
+			name:  "timeout 0 as drop",
+			toxic: toxiproxyToxic{Type: "timeout", Stream: "downstream", Attributes: attributes(`{"timeout": 0}`)},
+			want:  config.ToxicConfig{Type: config.ToxicDrop, Stream: "downstream", Rate: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.toxic.Name = "toxic"
+			tt.toxic.Toxicity = 1
+			got, err := parseToxiproxyToxic(tt.toxic)
+			if err != nil {
+				t.Fatalf("parseToxiproxyToxic() error = %v", err)
+			}
+			tt.want.Name = "toxic"
+			tt.want.Toxicity = float64Ptr(1)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseToxiproxyToxic() = %+v, want %+v", got, tt.want)
+			}
+			if back := newToxiproxyToxic(got); !reflect.DeepEqual(back, tt.toxic) {
+				t.Errorf("newToxiproxyToxic() = %+v, want the toxic parsed, %+v", back, tt.toxic)
+			}
+		})
+	}
+
+	for _, toxic := range []toxiproxyToxic{
+		{Type: "timeout", Stream: "downstream", Attributes: attributes(`{"timeout": 1000}`)},
+		{Type: "latency", Stream: "downstream", Attributes: attributes(`{"delay": 100}`)},
+		{Type: "latency", Stream: "sideways"},
+	} {
+		if _, err := parseToxiproxyToxic(toxic); err == nil {
+			t.Errorf("parseToxiproxyToxic(%+v) succeeded, want an error", toxic)
+		}
+	}
+}
+
+func attributes(data string) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
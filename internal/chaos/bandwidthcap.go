@@ -0,0 +1,77 @@
+package chaos
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// BandwidthCap is a link of BytesPerSecond shared by every stream given it,
+// like a saturated uplink: their combined throughput is capped, and each
+// waits its turn on the link, so busy streams slow each other down. Unlike
+// BandwidthToxic, which caps each stream on its own, it keeps state across
+// connections and is safe for concurrent use.
+type BandwidthCap struct {
+	BytesPerSecond int64
+
+	mu sync.Mutex
+	// free is when the bytes already let onto the link will have drained
+	// at the rate, and the next can go.
+	free time.Time
+}
+
+func NewBandwidthCap(bytesPerSecond int64) *BandwidthCap {
+	return &BandwidthCap{BytesPerSecond: bytesPerSecond}
+}
+
+// reserve lets n bytes onto the link at now, and returns how long their
+// sender must wait for the bytes ahead of them to drain first.
+func (c *BandwidthCap) reserve(n int, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.free.Before(now) {
+		c.free = now
+	}
+	wait := c.free.Sub(now)
+	c.free = c.free.Add(time.Duration(int64(n) * int64(time.Second) / c.BytesPerSecond))
+	return wait
+}
+
+// BandwidthCapToxic sends a stream through Cap.
+type BandwidthCapToxic struct {
+	Cap *BandwidthCap
+}
+
+func (BandwidthCapToxic) Name() string { return "bandwidth_cap" }
+
+func (t BandwidthCapToxic) Wrap(dst io.Writer) io.Writer {
+	return &bandwidthCapWriter{dst: dst, cap: t.Cap}
+}
+
+type bandwidthCapWriter struct {
+	dst io.Writer
+	cap *BandwidthCap
+}
+
+// Write sends p in slices of a tenth of a second's worth of bytes, each
+// once the link is free for it, so one large write doesn't hold the link
+// while other streams wait.
+func (w *bandwidthCapWriter) Write(p []byte) (int, error) {
+	slice := max(w.cap.BytesPerSecond/10, 1)
+
+	written := 0
+	for written < len(p) {
+		end := min(written+int(slice), len(p))
+		time.Sleep(w.cap.reserve(end-written, time.Now()))
+		n, err := w.dst.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *bandwidthCapWriter) Flush() error {
+	return Flush(w.dst)
+}
@@ -0,0 +1,51 @@
+package chaos
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBandwidthCap_Reserve(t *testing.T) {
+	start := time.Now()
+	c := NewBandwidthCap(1000)
+
+	steps := []struct {
+		at   time.Duration
+		n    int
+		want time.Duration
+	}{
+		{0, 100, 0},                      // an idle link sends at once
+		{0, 100, 100 * time.Millisecond}, // behind the first 100 bytes
+		{50 * time.Millisecond, 300, 150 * time.Millisecond}, // behind both
+		{time.Second, 100, 0},                                // drained by then
+	}
+	for i, s := range steps {
+		if got := c.reserve(s.n, start.Add(s.at)); got != s.want {
+			t.Errorf("step %d: reserve(%d) at %v = %v, want %v", i, s.n, s.at, got, s.want)
+		}
+	}
+}
+
+func TestBandwidthCapToxic_SharedAcrossStreams(t *testing.T) {
+	toxic := BandwidthCapToxic{Cap: NewBandwidthCap(1000)}
+	payload := bytes.Repeat([]byte("x"), 200)
+
+	// Two streams of 200 bytes share 1000 B/s, so between them the last
+	// 100 bytes go out 300ms in; on its own, each would be done in 100ms.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			var buf bytes.Buffer
+			if n, err := toxic.Wrap(&buf).Write(payload); err != nil || n != len(payload) {
+				t.Errorf("Write() = %d, %v; want %d, nil", n, err, len(payload))
+			}
+		})
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("streams finished after %v, want at least 250ms sharing the cap", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package chaos
+
+import (
+	"sync"
+)
+
+// LossModel decides per connection whether to drop it. Implementations keep
+// state across connections, unlike the independent coin flip of DropRate.
+// scale multiplies the model's drop rates (see Intensity).
+type LossModel interface {
+	Drop(scale float64) bool
+}
+
+// BurstLoss is a Gilbert-Elliott two-state loss model. The route moves
+// between a good and a bad state once per connection and drops at the
+// state's rate, so failures arrive in bursts like real network outages.
+type BurstLoss struct {
+	GoodToBad    float64
+	BadToGood    float64
+	GoodDropRate float64
+	BadDropRate  float64
+	// Rand rolls the transitions and drops; nil draws from the global
+	// source.
+	Rand *Rand
+
+	mu  sync.Mutex
+	bad bool
+}
+
+func NewBurstLoss(goodToBad, badToGood, goodDropRate, badDropRate float64) *BurstLoss {
+	return &BurstLoss{
+		GoodToBad:    goodToBad,
+		BadToGood:    badToGood,
+		GoodDropRate: goodDropRate,
+		BadDropRate:  badDropRate,
+	}
+}
+
+func (b *BurstLoss) Drop(scale float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.bad {
+		if b.Rand.Float64() < b.BadToGood {
+			b.bad = false
+		}
+	} else if b.Rand.Float64() < b.GoodToBad {
+		b.bad = true
+	}
+
+	rate := b.GoodDropRate
+	if b.bad {
+		rate = b.BadDropRate
+	}
+	rate = scaleRate(rate, scale)
+	return rate > 0 && b.Rand.Float64() < rate
+}
+
+// Bad reports whether the model is currently in the bad state.
+func (b *BurstLoss) Bad() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bad
+}
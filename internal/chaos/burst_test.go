@@ -0,0 +1,80 @@
+package chaos
+
+import "testing"
+
+func TestBurstLoss_Deterministic(t *testing.T) {
+	tests := []struct {
+		name      string
+		goodToBad float64
+		badToGood float64
+		wantDrops []bool
+	}{
+		{
+			name:      "never leaves good state",
+			goodToBad: 0.0,
+			badToGood: 1.0,
+			wantDrops: []bool{false, false, false},
+		},
+		{
+			name:      "stuck in bad state",
+			goodToBad: 1.0,
+			badToGood: 0.0,
+			wantDrops: []bool{true, true, true},
+		},
+		{
+			name:      "alternates every connection",
+			goodToBad: 1.0,
+			badToGood: 1.0,
+			wantDrops: []bool{true, false, true, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loss := NewBurstLoss(tt.goodToBad, tt.badToGood, 0.0, 1.0)
+			for i, want := range tt.wantDrops {
+				if got := loss.Drop(1); got != want {
+					t.Errorf("Drop() #%d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBurstLoss_Bursty(t *testing.T) {
+	// Low transition probabilities should produce long runs of drops rather
+	// than isolated ones. Count how often a drop follows a drop.
+	loss := NewBurstLoss(0.05, 0.2, 0.0, 1.0)
+	iterations := 5000
+	drops, repeats := 0, 0
+	prev := false
+
+	for i := 0; i < iterations; i++ {
+		dropped := loss.Drop(1)
+		if dropped {
+			drops++
+			if prev {
+				repeats++
+			}
+		}
+		prev = dropped
+	}
+
+	if drops == 0 {
+		t.Fatal("expected some drops")
+	}
+
+	// Independent drops at the same overall rate would repeat roughly 20% of
+	// the time; the bad state lasts 5 connections on average.
+	if repeatRate := float64(repeats) / float64(drops); repeatRate < 0.6 {
+		t.Errorf("drop-after-drop rate = %.2f, want bursts (>= 0.6)", repeatRate)
+	}
+}
+
+func TestNewCurse_LossModel(t *testing.T) {
+	ritual := New(WithLoss(NewBurstLoss(1.0, 0.0, 0.0, 1.0)))
+
+	if curse := NewCurse(ritual); !curse.DropConnections {
+		t.Error("NewCurse() DropConnections = false, want loss model decision (true)")
+	}
+}
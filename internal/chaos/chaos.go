@@ -1,30 +1,146 @@
 package chaos
 
 import (
-	"math/rand"
+	"hash/fnv"
+	"io"
+	"math"
 	"time"
 )
 
+// Curse is what a Ritual decided for one connection: whether to drop it and
+// how long to hold it before forwarding.
 type Curse struct {
 	DropConnections bool
 	StartDelay      time.Duration
 }
 
+// Ritual is a route's chaos settings, built with New. Stateful models live
+// on the ritual so they carry across the route's connections. The zero
+// Ritual injects no chaos.
 type Ritual struct {
-	DropRate  float64
-	LatencyMs int
+	dropRate        float64
+	latency         time.Duration
+	dialFailureRate float64
+	connectLatency  time.Duration
+	finDelay        time.Duration
+	errorRate       float64
+	resetRate       float64
+	messageDelay    time.Duration
+	messageRate     float64
+	loss            LossModel
+	tarpit          *Tarpit
+	pipeline        Pipeline
+	load            *LoadLatency
+	intensity       *Intensity
+	rand            *Rand
 }
 
 func NewCurse(ritual Ritual) Curse {
+	return newCurse(ritual, ritual.rand.Float64)
+}
+
+// NewCurseFor decides the curse from a hash of key instead of a random roll,
+// so the same key (e.g. a client IP) always gets the same treatment. A
+// stateful Loss model still decides drops on its own.
+func NewCurseFor(ritual Ritual, key string) Curse {
+	roll := hashRoll(key)
+	return newCurse(ritual, func() float64 { return roll })
+}
+
+func newCurse(ritual Ritual, roll func() float64) Curse {
 	curse := Curse{}
+	scale := ritual.intensity.Scale()
 
-	if ritual.DropRate > 0 && rand.Float64() < ritual.DropRate {
+	if ritual.loss != nil {
+		curse.DropConnections = ritual.loss.Drop(scale)
+	} else if ritual.dropRate > 0 && roll() < scaleRate(ritual.dropRate, scale) {
 		curse.DropConnections = true
 	}
 
-	if ritual.LatencyMs > 0 {
-		curse.StartDelay = time.Duration(ritual.LatencyMs) * time.Millisecond
+	if ritual.latency > 0 {
+		curse.StartDelay = scaleDelay(ritual.latency, scale)
 	}
 
 	return curse
 }
+
+// DialFails rolls whether this connection's upstream dial should fail.
+func (r Ritual) DialFails() bool {
+	return r.dialFailureRate > 0 && r.rand.Float64() < scaleRate(r.dialFailureRate, r.intensity.Scale())
+}
+
+// RequestFails rolls whether this HTTP request, RPC, DNS query or database
+// query gets an injected error.
+func (r Ritual) RequestFails() bool {
+	return r.errorRate > 0 && r.rand.Float64() < scaleRate(r.errorRate, r.intensity.Scale())
+}
+
+// StreamResets rolls whether this HTTP/2 stream or database result set is
+// cut off mid-response.
+func (r Ritual) StreamResets() bool {
+	return r.resetRate > 0 && r.rand.Float64() < scaleRate(r.resetRate, r.intensity.Scale())
+}
+
+// DelaysMessages reports whether the ritual ever holds gRPC messages or DNS
+// responses, so callers can skip wrapping them when it doesn't.
+func (r Ritual) DelaysMessages() bool {
+	return r.messageDelay > 0
+}
+
+// MessageDelay rolls whether to hold this gRPC message or DNS response and
+// returns for how long, or 0.
+func (r Ritual) MessageDelay() time.Duration {
+	if r.messageDelay <= 0 || r.rand.Float64() >= r.messageRate {
+		return 0
+	}
+	return scaleDelay(r.messageDelay, r.intensity.Scale())
+}
+
+// ConnectDelay returns how long to hold off dialling the upstream.
+func (r Ritual) ConnectDelay() time.Duration {
+	return scaleDelay(r.connectLatency, r.intensity.Scale())
+}
+
+// FinDelay returns how long to hold a close before passing it on.
+func (r Ritual) FinDelay() time.Duration {
+	return scaleDelay(r.finDelay, r.intensity.Scale())
+}
+
+// Pipeline returns the stream toxics applied to every connection.
+func (r Ritual) Pipeline() Pipeline {
+	return r.pipeline
+}
+
+// Tarpit returns the ritual's tarpit, or nil if it has none.
+func (r Ritual) Tarpit() *Tarpit {
+	return r.tarpit
+}
+
+// Rand returns the ritual's source of random numbers, for the route's
+// other rolls. It is nil, drawing from the global source, unless the ritual
+// was given one with WithRand.
+func (r Ritual) Rand() *Rand {
+	return r.rand
+}
+
+// Load returns the counter of the route's open connections for its load
+// latency, or nil if it has none.
+func (r Ritual) Load() *LoadLatency {
+	return r.load
+}
+
+// hashRoll maps key to a stable value in [0, 1).
+func hashRoll(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()>>11) / math.Exp2(53)
+}
+
+// Flush releases anything w is holding back if it buffers (chaos writers
+// forward Flush down their chain). Other writers are left alone.
+func Flush(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
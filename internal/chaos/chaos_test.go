@@ -36,10 +36,7 @@ func TestNewCurse_DropRate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ritual := Ritual{
-				DropRate:  tt.dropRate,
-				LatencyMs: 0,
-			}
+			ritual := New(WithDropRate(tt.dropRate))
 
 			// For deterministic cases (0.0 and 1.0), test directly
 			if tt.dropRate == 0.0 {
@@ -70,10 +67,7 @@ func TestNewCurse_DropRateProbability(t *testing.T) {
 	drops := 0
 
 	for i := 0; i < iterations; i++ {
-		ritual := Ritual{
-			DropRate:  dropRate,
-			LatencyMs: 0,
-		}
+		ritual := New(WithDropRate(dropRate))
 		curse := NewCurse(ritual)
 		if curse.DropConnections {
 			drops++
@@ -125,10 +119,7 @@ func TestNewCurse_LatencyMs(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ritual := Ritual{
-				DropRate:  0.0,
-				LatencyMs: tt.latencyMs,
-			}
+			ritual := New(WithLatency(time.Duration(tt.latencyMs) * time.Millisecond))
 
 			curse := NewCurse(ritual)
 
@@ -180,10 +171,10 @@ func TestNewCurse_Combined(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ritual := Ritual{
-				DropRate:  tt.dropRate,
-				LatencyMs: tt.latencyMs,
-			}
+			ritual := New(
+				WithDropRate(tt.dropRate),
+				WithLatency(time.Duration(tt.latencyMs)*time.Millisecond),
+			)
 
 			curse := NewCurse(ritual)
 
@@ -248,10 +239,10 @@ func TestNewCurse_EdgeCases(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ritual := Ritual{
-				DropRate:  tt.dropRate,
-				LatencyMs: tt.latencyMs,
-			}
+			ritual := New(
+				WithDropRate(tt.dropRate),
+				WithLatency(time.Duration(tt.latencyMs)*time.Millisecond),
+			)
 
 			curse := NewCurse(ritual)
 
@@ -0,0 +1,102 @@
+package chaos
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultCoalesceBytes bounds how much a CoalesceWriter buffers before it
+// flushes early, so a fast stream can't grow the buffer without limit.
+const DefaultCoalesceBytes = 64 * 1024
+
+// CoalesceWriter buffers writes and releases them in one burst every
+// Interval, exposing protocols that assume small writes arrive promptly.
+type CoalesceWriter struct {
+	dst      io.Writer
+	interval time.Duration
+	maxBytes int
+	mem      *Memory
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+// NewCoalesceWriter buffers up to maxBytes (64 KiB when 0) between flushes.
+func NewCoalesceWriter(dst io.Writer, interval time.Duration, maxBytes int) *CoalesceWriter {
+	if maxBytes <= 0 {
+		maxBytes = DefaultCoalesceBytes
+	}
+
+	return &CoalesceWriter{
+		dst:      dst,
+		interval: interval,
+		maxBytes: maxBytes,
+	}
+}
+
+func (w *CoalesceWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if !w.mem.Reserve(len(p)) {
+		// Over the memory budget: p goes out now, behind what is held.
+		w.flushLocked()
+		if w.err != nil {
+			return 0, w.err
+		}
+		n, err := w.dst.Write(p)
+		w.err = err
+		return n, err
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.maxBytes {
+		w.flushLocked()
+		return len(p), w.err
+	}
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.flushLocked()
+		})
+	}
+	return len(p), nil
+}
+
+// Flush writes everything buffered so far, then flushes dst.
+func (w *CoalesceWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.flushLocked()
+	if w.err != nil {
+		return w.err
+	}
+	return Flush(w.dst)
+}
+
+func (w *CoalesceWriter) flushLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	if len(w.buf) == 0 {
+		return
+	}
+	if w.err == nil {
+		if _, err := w.dst.Write(w.buf); err != nil {
+			w.err = err
+		}
+	}
+	w.mem.Release(len(w.buf))
+	w.buf = w.buf[:0]
+}
@@ -0,0 +1,118 @@
+package chaos
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// recordingWriter keeps each Write call separately so tests can see bursts.
+type recordingWriter struct {
+	writes []string
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func TestCoalesceWriter_Timer(t *testing.T) {
+	rec := &recordingWriter{}
+	w := NewCoalesceWriter(rec, 50*time.Millisecond, 0)
+
+	for _, chunk := range []string{"a", "b", "c"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write(%q) error = %v", chunk, err)
+		}
+	}
+
+	w.mu.Lock()
+	before := len(rec.writes)
+	w.mu.Unlock()
+	if before != 0 {
+		t.Errorf("writes before interval = %d, want 0", before)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(rec.writes) != 1 || rec.writes[0] != "abc" {
+		t.Errorf("writes after interval = %q, want one burst %q", rec.writes, "abc")
+	}
+}
+
+func TestCoalesceWriter_MaxBytes(t *testing.T) {
+	rec := &recordingWriter{}
+	w := NewCoalesceWriter(rec, time.Hour, 4)
+
+	w.Write([]byte("ab"))
+	w.Write([]byte("cd"))
+	w.Write([]byte("e"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	want := []string{"abcd", "e"}
+	if len(rec.writes) != len(want) {
+		t.Fatalf("writes = %q, want %q", rec.writes, want)
+	}
+	for i := range want {
+		if rec.writes[i] != want[i] {
+			t.Errorf("write %d = %q, want %q", i, rec.writes[i], want[i])
+		}
+	}
+}
+
+func TestCoalesceWriter_Flush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCoalesceWriter(&buf, time.Hour, 0)
+
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if got := buf.String(); got != "hello world" {
+		t.Errorf("output = %q, want %q", got, "hello world")
+	}
+}
+
+func TestFlush_Chain(t *testing.T) {
+	var buf bytes.Buffer
+	inner := NewCoalesceWriter(&buf, time.Hour, 0)
+	outer := NewDuplicateWriter(inner, 0.0)
+
+	outer.Write([]byte("buffered"))
+	if err := Flush(outer); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	if got := buf.String(); got != "buffered" {
+		t.Errorf("output after chained flush = %q, want %q", got, "buffered")
+	}
+}
+
+func TestCoalesceWriter_OverMemory(t *testing.T) {
+	rec := &recordingWriter{}
+	mem := NewMemory(4)
+	w := NewCoalesceWriter(rec, time.Hour, 0)
+	w.mem = mem
+
+	// "abc" fits the budget and is held; "de" doesn't, so both go out at
+	// once, in order.
+	w.Write([]byte("abc"))
+	if used := mem.Used(); used != 3 {
+		t.Errorf("Used() holding %q = %d, want 3", "abc", used)
+	}
+	w.Write([]byte("de"))
+
+	want := []string{"abc", "de"}
+	if len(rec.writes) != len(want) || rec.writes[0] != want[0] || rec.writes[1] != want[1] {
+		t.Errorf("writes = %q, want %q", rec.writes, want)
+	}
+	if used := mem.Used(); used != 0 {
+		t.Errorf("Used() after writing through = %d, want 0", used)
+	}
+}
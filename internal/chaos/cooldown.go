@@ -0,0 +1,48 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+)
+
+// CooldownLoss drops connections at Rate, but after each drop the
+// probability falls to zero and climbs linearly back to Rate over Cooldown.
+// Failures come and go instead of landing back to back.
+type CooldownLoss struct {
+	Rate     float64
+	Cooldown time.Duration
+	// Rand rolls the drops; nil draws from the global source.
+	Rand *Rand
+
+	mu       sync.Mutex
+	lastDrop time.Time
+	now      func() time.Time
+}
+
+func NewCooldownLoss(rate float64, cooldown time.Duration) *CooldownLoss {
+	return &CooldownLoss{Rate: rate, Cooldown: cooldown, now: time.Now}
+}
+
+func (c *CooldownLoss) Drop(scale float64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.Rate <= 0 || c.Rand.Float64() >= scaleRate(c.rateAt(now), scale) {
+		return false
+	}
+	c.lastDrop = now
+	return true
+}
+
+// rateAt returns the drop probability at now, given the last drop.
+func (c *CooldownLoss) rateAt(now time.Time) float64 {
+	if c.lastDrop.IsZero() || c.Cooldown <= 0 {
+		return c.Rate
+	}
+	elapsed := now.Sub(c.lastDrop)
+	if elapsed >= c.Cooldown {
+		return c.Rate
+	}
+	return c.Rate * float64(elapsed) / float64(c.Cooldown)
+}
@@ -0,0 +1,83 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCooldownLoss(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		rate     float64
+		cooldown time.Duration
+		// offsets are times since start at which Drop is called.
+		offsets []time.Duration
+		want    []bool
+	}{
+		{
+			name:     "no drop right after a drop",
+			rate:     1.0,
+			cooldown: time.Second,
+			offsets:  []time.Duration{0, 0, 0},
+			want:     []bool{true, false, false},
+		},
+		{
+			name:     "full rate after cool-down",
+			rate:     1.0,
+			cooldown: time.Second,
+			offsets:  []time.Duration{0, time.Second, 2 * time.Second},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "zero cool-down behaves like the plain rate",
+			rate:     1.0,
+			cooldown: 0,
+			offsets:  []time.Duration{0, 0, 0},
+			want:     []bool{true, true, true},
+		},
+		{
+			name:     "zero rate never drops",
+			rate:     0.0,
+			cooldown: time.Second,
+			offsets:  []time.Duration{0, 2 * time.Second},
+			want:     []bool{false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loss := NewCooldownLoss(tt.rate, tt.cooldown)
+			for i, offset := range tt.offsets {
+				loss.now = func() time.Time { return start.Add(offset) }
+				if got := loss.Drop(1); got != tt.want[i] {
+					t.Errorf("Drop() at %v = %v, want %v", offset, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCooldownLoss_Recovery(t *testing.T) {
+	start := time.Unix(1000, 0)
+	loss := NewCooldownLoss(0.8, time.Second)
+	loss.lastDrop = start
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 0.0},
+		{250 * time.Millisecond, 0.2},
+		{500 * time.Millisecond, 0.4},
+		{time.Second, 0.8},
+		{5 * time.Second, 0.8},
+	}
+
+	for _, tt := range tests {
+		if got := loss.rateAt(start.Add(tt.elapsed)); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("rateAt(+%v) = %f, want %f", tt.elapsed, got, tt.want)
+		}
+	}
+}
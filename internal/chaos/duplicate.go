@@ -0,0 +1,39 @@
+package chaos
+
+import (
+	"io"
+)
+
+// DuplicateWriter re-sends a chunk immediately after forwarding it at a
+// configurable probability, simulating application-level duplicate delivery.
+type DuplicateWriter struct {
+	dst  io.Writer
+	rate float64
+	rand *Rand
+}
+
+func NewDuplicateWriter(dst io.Writer, rate float64) *DuplicateWriter {
+	return &DuplicateWriter{dst: dst, rate: rate}
+}
+
+// Write reports len(p) on success; the duplicate bytes are not counted so
+// callers still see the original stream length.
+func (w *DuplicateWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if len(p) > 0 && w.rand.Float64() < w.rate {
+		if _, err := w.dst.Write(p); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Flush flushes dst; DuplicateWriter itself buffers nothing.
+func (w *DuplicateWriter) Flush() error {
+	return Flush(w.dst)
+}
@@ -0,0 +1,55 @@
+package chaos
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDuplicateWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "zero rate passes through",
+			rate:   0.0,
+			chunks: []string{"a", "b"},
+			want:   "ab",
+		},
+		{
+			name:   "always duplicate",
+			rate:   1.0,
+			chunks: []string{"a", "b"},
+			want:   "aabb",
+		},
+		{
+			name:   "empty chunk not duplicated",
+			rate:   1.0,
+			chunks: []string{"", "a"},
+			want:   "aa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewDuplicateWriter(&buf, tt.rate)
+
+			for _, chunk := range tt.chunks {
+				n, err := w.Write([]byte(chunk))
+				if err != nil {
+					t.Fatalf("Write(%q) error = %v", chunk, err)
+				}
+				if n != len(chunk) {
+					t.Errorf("Write(%q) n = %d, want %d", chunk, n, len(chunk))
+				}
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
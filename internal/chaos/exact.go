@@ -0,0 +1,31 @@
+package chaos
+
+import "sync"
+
+// ExactLoss drops exactly Rate of connections by counting instead of rolling:
+// every connection adds Rate to a running credit, and a connection drops when
+// the credit reaches 1. A rate of 0.25 drops every 4th connection, and 10
+// connections at 0.5 always drop 5.
+type ExactLoss struct {
+	Rate float64
+
+	mu     sync.Mutex
+	credit float64
+}
+
+func NewExactLoss(rate float64) *ExactLoss {
+	return &ExactLoss{Rate: rate}
+}
+
+func (e *ExactLoss) Drop(scale float64) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.credit += scaleRate(e.Rate, scale)
+	// The epsilon keeps float error (0.29*100 = 28.999...) from skipping a drop.
+	if e.credit < 1-1e-9 {
+		return false
+	}
+	e.credit--
+	return true
+}
@@ -0,0 +1,64 @@
+package chaos
+
+import "testing"
+
+func TestExactLoss(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		count     int
+		wantDrops int
+		wantOrder []bool
+	}{
+		{
+			name:      "every fourth connection",
+			rate:      0.25,
+			count:     8,
+			wantDrops: 2,
+			wantOrder: []bool{false, false, false, true, false, false, false, true},
+		},
+		{
+			name:      "half of ten",
+			rate:      0.5,
+			count:     10,
+			wantDrops: 5,
+		},
+		{
+			name:      "never drop",
+			rate:      0.0,
+			count:     10,
+			wantDrops: 0,
+		},
+		{
+			name:      "always drop",
+			rate:      1.0,
+			count:     10,
+			wantDrops: 10,
+		},
+		{
+			name:      "float error does not skip drops",
+			rate:      0.29,
+			count:     100,
+			wantDrops: 29,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loss := NewExactLoss(tt.rate)
+			drops := 0
+			for i := 0; i < tt.count; i++ {
+				dropped := loss.Drop(1)
+				if dropped {
+					drops++
+				}
+				if tt.wantOrder != nil && dropped != tt.wantOrder[i] {
+					t.Errorf("Drop() #%d = %v, want %v", i+1, dropped, tt.wantOrder[i])
+				}
+			}
+			if drops != tt.wantDrops {
+				t.Errorf("drops = %d, want %d", drops, tt.wantDrops)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package chaos
+
+import "time"
+
+// Flap alternates a route between a healthy window and a fully-failing
+// downtime window, simulating a dependency that keeps bouncing.
+type Flap struct {
+	Interval time.Duration
+	Downtime time.Duration
+	start    time.Time
+}
+
+func NewFlap(intervalMs, downtimeMs int) *Flap {
+	if intervalMs <= 0 || downtimeMs <= 0 {
+		return nil
+	}
+
+	return &Flap{
+		Interval: time.Duration(intervalMs) * time.Millisecond,
+		Downtime: time.Duration(downtimeMs) * time.Millisecond,
+		start:    time.Now(),
+	}
+}
+
+// IsDown reports whether the route is inside a downtime window at now.
+// Each cycle starts healthy for Interval, then fails for Downtime.
+func (f *Flap) IsDown(now time.Time) bool {
+	if f == nil {
+		return false
+	}
+
+	cycle := f.Interval + f.Downtime
+	elapsed := now.Sub(f.start) % cycle
+	return elapsed >= f.Interval
+}
@@ -0,0 +1,56 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewFlap_Disabled(t *testing.T) {
+	tests := []struct {
+		name       string
+		intervalMs int
+		downtimeMs int
+	}{
+		{name: "both zero", intervalMs: 0, downtimeMs: 0},
+		{name: "interval only", intervalMs: 100, downtimeMs: 0},
+		{name: "downtime only", intervalMs: 0, downtimeMs: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flap := NewFlap(tt.intervalMs, tt.downtimeMs)
+			if flap != nil {
+				t.Errorf("NewFlap(%d, %d) = %v, want nil", tt.intervalMs, tt.downtimeMs, flap)
+			}
+			if flap.IsDown(time.Now()) {
+				t.Error("IsDown() = true on disabled flap, want false")
+			}
+		})
+	}
+}
+
+func TestFlap_IsDown(t *testing.T) {
+	flap := NewFlap(100, 50)
+	start := flap.start
+
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		wantDown bool
+	}{
+		{name: "start of cycle", offset: 0, wantDown: false},
+		{name: "end of healthy window", offset: 99 * time.Millisecond, wantDown: false},
+		{name: "start of downtime", offset: 100 * time.Millisecond, wantDown: true},
+		{name: "end of downtime", offset: 149 * time.Millisecond, wantDown: true},
+		{name: "second cycle healthy", offset: 150 * time.Millisecond, wantDown: false},
+		{name: "second cycle down", offset: 260 * time.Millisecond, wantDown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flap.IsDown(start.Add(tt.offset)); got != tt.wantDown {
+				t.Errorf("IsDown(+%v) = %v, want %v", tt.offset, got, tt.wantDown)
+			}
+		})
+	}
+}
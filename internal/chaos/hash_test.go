@@ -0,0 +1,62 @@
+package chaos
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestNewCurseFor_Deterministic(t *testing.T) {
+	ritual := New(WithDropRate(0.5))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		first := NewCurseFor(ritual, key).DropConnections
+		for j := 0; j < 10; j++ {
+			if got := NewCurseFor(ritual, key).DropConnections; got != first {
+				t.Fatalf("NewCurseFor(%q) = %v on repeat, want %v every time", key, got, first)
+			}
+		}
+	}
+}
+
+func TestNewCurseFor_Bounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		dropRate float64
+		wantDrop bool
+	}{
+		{name: "never drop", dropRate: 0.0, wantDrop: false},
+		{name: "always drop", dropRate: 1.0, wantDrop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				curse := NewCurseFor(New(WithDropRate(tt.dropRate)), fmt.Sprintf("client-%d", i))
+				if curse.DropConnections != tt.wantDrop {
+					t.Fatalf("DropConnections = %v, want %v", curse.DropConnections, tt.wantDrop)
+				}
+			}
+		})
+	}
+}
+
+func TestHashRoll_Distribution(t *testing.T) {
+	// Distinct keys should spread roughly uniformly over [0, 1).
+	iterations := 2000
+	below := 0
+	for i := 0; i < iterations; i++ {
+		roll := hashRoll(fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+		if roll < 0 || roll >= 1 {
+			t.Fatalf("hashRoll out of range: %f", roll)
+		}
+		if roll < 0.3 {
+			below++
+		}
+	}
+
+	if rate := float64(below) / float64(iterations); math.Abs(rate-0.3) > 0.05 {
+		t.Errorf("fraction below 0.3 = %.3f, want about 0.3", rate)
+	}
+}
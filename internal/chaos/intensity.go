@@ -0,0 +1,60 @@
+package chaos
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Intensity is a global multiplier for drop rates and latencies that can be
+// changed while the proxy runs. A nil *Intensity means a scale of 1.
+type Intensity struct {
+	bits atomic.Uint64
+	// parent's scale multiplies this one's, for a group of routes scaled
+	// apart from the rest.
+	parent *Intensity
+}
+
+func NewIntensity(scale float64) *Intensity {
+	i := &Intensity{}
+	i.Set(scale)
+	return i
+}
+
+// NewScopedIntensity returns an intensity for a group of routes, such as a
+// tenant's, whose own scale, starting at 1, is multiplied by parent's.
+func NewScopedIntensity(parent *Intensity) *Intensity {
+	i := &Intensity{parent: parent}
+	i.Set(1)
+	return i
+}
+
+// Scale is the multiplier applied, the intensity's own times its parent's.
+func (i *Intensity) Scale() float64 {
+	if i == nil {
+		return 1
+	}
+	return i.Own() * i.parent.Scale()
+}
+
+// Own is the scale set on the intensity, leaving out its parent's.
+func (i *Intensity) Own() float64 {
+	if i == nil {
+		return 1
+	}
+	return math.Float64frombits(i.bits.Load())
+}
+
+func (i *Intensity) Set(scale float64) {
+	i.bits.Store(math.Float64bits(scale))
+}
+
+// scaleRate multiplies a probability by the scale, capped at 1.
+func scaleRate(rate, scale float64) float64 {
+	return min(rate*scale, 1)
+}
+
+// scaleDelay multiplies a delay by the scale.
+func scaleDelay(d time.Duration, scale float64) time.Duration {
+	return time.Duration(float64(d) * scale)
+}
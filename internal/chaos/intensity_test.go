@@ -0,0 +1,109 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntensity_NilIsUnscaled(t *testing.T) {
+	var intensity *Intensity
+	if got := intensity.Scale(); got != 1 {
+		t.Errorf("nil Scale() = %v, want 1", got)
+	}
+}
+
+func TestIntensity_Scoped(t *testing.T) {
+	global := NewIntensity(2)
+	scoped := NewScopedIntensity(global)
+	if got := scoped.Scale(); got != 2 {
+		t.Errorf("new scoped Scale() = %v, want its parent's 2", got)
+	}
+	scoped.Set(0.5)
+	global.Set(3)
+	if got, own := scoped.Scale(), scoped.Own(); got != 1.5 || own != 0.5 {
+		t.Errorf("Scale(), Own() = %v, %v, want 1.5, 0.5", got, own)
+	}
+}
+
+func TestNewCurse_Intensity(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		scale     float64
+		wantDrop  bool
+		wantDelay time.Duration
+	}{
+		{
+			name:      "unit scale keeps settings",
+			opts:      []Option{WithDropRate(1.0), WithLatency(100 * time.Millisecond)},
+			scale:     1,
+			wantDrop:  true,
+			wantDelay: 100 * time.Millisecond,
+		},
+		{
+			name:      "zero scale disables drops and latency",
+			opts:      []Option{WithDropRate(1.0), WithLatency(100 * time.Millisecond)},
+			scale:     0,
+			wantDrop:  false,
+			wantDelay: 0,
+		},
+		{
+			name:      "scale multiplies latency",
+			opts:      []Option{WithLatency(100 * time.Millisecond)},
+			scale:     2.5,
+			wantDelay: 250 * time.Millisecond,
+		},
+		{
+			name:     "drop rate is capped at one",
+			opts:     []Option{WithDropRate(0.5)},
+			scale:    4,
+			wantDrop: true,
+		},
+		{
+			name:     "loss models are scaled",
+			opts:     []Option{WithLoss(NewExactLoss(1.0))},
+			scale:    0,
+			wantDrop: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curse := NewCurse(New(append(tt.opts, WithIntensity(NewIntensity(tt.scale)))...))
+
+			if curse.DropConnections != tt.wantDrop {
+				t.Errorf("DropConnections = %v, want %v", curse.DropConnections, tt.wantDrop)
+			}
+			if curse.StartDelay != tt.wantDelay {
+				t.Errorf("StartDelay = %v, want %v", curse.StartDelay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestExactLoss_Scaled(t *testing.T) {
+	loss := NewExactLoss(0.25)
+
+	// Doubling the scale drops every second connection instead of every fourth.
+	drops := 0
+	for i := 0; i < 8; i++ {
+		if loss.Drop(2) {
+			drops++
+		}
+	}
+	if drops != 4 {
+		t.Errorf("drops = %d, want 4", drops)
+	}
+}
+
+func TestRitual_ConnectDelay(t *testing.T) {
+	ritual := New(WithConnectLatency(100 * time.Millisecond))
+	if got := ritual.ConnectDelay(); got != 100*time.Millisecond {
+		t.Errorf("unscaled ConnectDelay() = %v, want 100ms", got)
+	}
+
+	ritual = New(WithConnectLatency(100*time.Millisecond), WithIntensity(NewIntensity(0.5)))
+	if got := ritual.ConnectDelay(); got != 50*time.Millisecond {
+		t.Errorf("ConnectDelay() at scale 0.5 = %v, want 50ms", got)
+	}
+}
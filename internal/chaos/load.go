@@ -0,0 +1,95 @@
+package chaos
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// LoadPoint is one point on a LoadLatency curve.
+type LoadPoint struct {
+	Connections int
+	Latency     time.Duration
+}
+
+// LoadLatency counts the connections open on a route and maps that count to
+// a delay along a piecewise-linear curve, so responses slow down as load
+// rises the way an overloaded upstream's would. The curve must be sorted by
+// Connections; the delay is held flat before the first point and beyond the
+// last.
+type LoadLatency struct {
+	Curve []LoadPoint
+
+	active atomic.Int64
+}
+
+func NewLoadLatency(curve []LoadPoint) *LoadLatency {
+	return &LoadLatency{Curve: curve}
+}
+
+// Enter counts a connection as open until the returned func is called.
+// It is safe to call on a nil LoadLatency.
+func (l *LoadLatency) Enter() (leave func()) {
+	if l == nil {
+		return func() {}
+	}
+	l.active.Add(1)
+	return func() { l.active.Add(-1) }
+}
+
+// Active returns the number of connections currently open.
+func (l *LoadLatency) Active() int {
+	return int(l.active.Load())
+}
+
+// Delay returns the latency for the current load.
+func (l *LoadLatency) Delay() time.Duration {
+	return l.delayAt(l.Active())
+}
+
+func (l *LoadLatency) delayAt(n int) time.Duration {
+	if len(l.Curve) == 0 {
+		return 0
+	}
+	if n <= l.Curve[0].Connections {
+		return l.Curve[0].Latency
+	}
+	for i := 1; i < len(l.Curve); i++ {
+		lo, hi := l.Curve[i-1], l.Curve[i]
+		if n <= hi.Connections {
+			frac := float64(n-lo.Connections) / float64(hi.Connections-lo.Connections)
+			return lo.Latency + time.Duration(frac*float64(hi.Latency-lo.Latency))
+		}
+	}
+	return l.Curve[len(l.Curve)-1].Latency
+}
+
+// LoadLatencyToxic delays every chunk by Load's current delay, multiplied
+// by Intensity.
+type LoadLatencyToxic struct {
+	Load      *LoadLatency
+	Intensity *Intensity
+}
+
+func (LoadLatencyToxic) Name() string { return "load_latency" }
+
+func (t LoadLatencyToxic) Wrap(dst io.Writer) io.Writer {
+	return &loadLatencyWriter{dst: dst, toxic: t}
+}
+
+type loadLatencyWriter struct {
+	dst   io.Writer
+	toxic LoadLatencyToxic
+}
+
+func (w *loadLatencyWriter) Write(p []byte) (int, error) {
+	delay := time.Duration(float64(w.toxic.Load.Delay()) * w.toxic.Intensity.Scale())
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return w.dst.Write(p)
+}
+
+func (w *loadLatencyWriter) Flush() error {
+	return Flush(w.dst)
+}
@@ -0,0 +1,56 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadLatency_DelayAt(t *testing.T) {
+	load := NewLoadLatency([]LoadPoint{
+		{Connections: 1, Latency: 0},
+		{Connections: 11, Latency: 100 * time.Millisecond},
+		{Connections: 21, Latency: 1100 * time.Millisecond},
+	})
+
+	tests := []struct {
+		active int
+		want   time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{6, 50 * time.Millisecond},
+		{11, 100 * time.Millisecond},
+		{16, 600 * time.Millisecond},
+		{21, 1100 * time.Millisecond},
+		{500, 1100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := load.delayAt(tt.active); got != tt.want {
+			t.Errorf("delayAt(%d) = %v, want %v", tt.active, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLatency_Enter(t *testing.T) {
+	load := NewLoadLatency([]LoadPoint{{Connections: 0, Latency: 0}, {Connections: 2, Latency: 20 * time.Millisecond}})
+
+	leaveA := load.Enter()
+	leaveB := load.Enter()
+	if got := load.Delay(); got != 20*time.Millisecond {
+		t.Errorf("Delay() with 2 open = %v, want 20ms", got)
+	}
+
+	leaveA()
+	if got := load.Delay(); got != 10*time.Millisecond {
+		t.Errorf("Delay() with 1 open = %v, want 10ms", got)
+	}
+
+	leaveB()
+	if got := load.Active(); got != 0 {
+		t.Errorf("Active() after leaving = %d, want 0", got)
+	}
+
+	var none *LoadLatency
+	none.Enter()()
+}
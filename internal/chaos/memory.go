@@ -0,0 +1,48 @@
+package chaos
+
+import "sync/atomic"
+
+// Memory is a budget of bytes for the data held back by the toxics that
+// buffer, coalesce and reorder, shared across every connection and route
+// given it, so a flood through many connections can't buffer without limit.
+// A toxic that can't reserve for a write stops holding data back and writes
+// it through, which blocks the sender until the peer takes it. Safe for
+// concurrent use; a nil *Memory has no limit.
+type Memory struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewMemory returns a budget of limit bytes.
+func NewMemory(limit int64) *Memory {
+	return &Memory{limit: limit}
+}
+
+// Reserve takes n bytes from the budget if they fit, and reports whether
+// they did. Bytes reserved must be given back with Release.
+func (m *Memory) Reserve(n int) bool {
+	if m == nil {
+		return true
+	}
+	if m.used.Add(int64(n)) > m.limit {
+		m.used.Add(-int64(n))
+		return false
+	}
+	return true
+}
+
+// Release gives back n bytes taken with Reserve.
+func (m *Memory) Release(n int) {
+	if m == nil {
+		return
+	}
+	m.used.Add(-int64(n))
+}
+
+// Used is the number of bytes reserved.
+func (m *Memory) Used() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.used.Load()
+}
@@ -0,0 +1,25 @@
+package chaos
+
+import "testing"
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(10)
+	if !m.Reserve(6) || !m.Reserve(4) {
+		t.Fatal("Reserve() within the limit failed")
+	}
+	if m.Reserve(1) {
+		t.Error("Reserve() past the limit succeeded")
+	}
+	if used := m.Used(); used != 10 {
+		t.Errorf("Used() = %d, want 10", used)
+	}
+	m.Release(6)
+	if !m.Reserve(5) {
+		t.Error("Reserve() after Release() failed")
+	}
+
+	var unlimited *Memory
+	if !unlimited.Reserve(1 << 40) {
+		t.Error("nil Reserve() failed, want no limit")
+	}
+}
@@ -0,0 +1,121 @@
+package chaos
+
+import "time"
+
+// Option sets one of a Ritual's chaos settings. Options are applied in the
+// order given to New; a later one overrides an earlier one of the same kind,
+// except WithToxic and WithBandwidth, which add to the pipeline.
+type Option func(*Ritual)
+
+// New builds a Ritual from opts. With none, it injects no chaos:
+//
+//	ritual := chaos.New(
+//		chaos.WithLatency(200*time.Millisecond),
+//		chaos.WithDropRate(0.1),
+//		chaos.WithBandwidth(64*1024),
+//	)
+func New(opts ...Option) Ritual {
+	var r Ritual
+	for _, opt := range opts {
+		opt(&r)
+	}
+	// The load latency stage goes last, once every toxic is in place and the
+	// intensity it scales by is known.
+	if r.load != nil {
+		r.pipeline = append(r.pipeline, Stage{
+			Toxic:    LoadLatencyToxic{Load: r.load, Intensity: r.intensity},
+			Stream:   Downstream,
+			Toxicity: 1,
+		})
+	}
+	return r
+}
+
+// WithDropRate drops this fraction, from 0 to 1, of new connections.
+func WithDropRate(rate float64) Option {
+	return func(r *Ritual) { r.dropRate = rate }
+}
+
+// WithLoss decides drops with a stateful loss model, such as NewBurstLoss,
+// in place of WithDropRate's independent coin flip.
+func WithLoss(loss LossModel) Option {
+	return func(r *Ritual) { r.loss = loss }
+}
+
+// WithLatency holds every connection for d before it is forwarded.
+func WithLatency(d time.Duration) Option {
+	return func(r *Ritual) { r.latency = d }
+}
+
+// WithDialFailureRate skips the upstream dial entirely for this fraction of
+// connections.
+func WithDialFailureRate(rate float64) Option {
+	return func(r *Ritual) { r.dialFailureRate = rate }
+}
+
+// WithConnectLatency delays the dial to the upstream by d.
+func WithConnectLatency(d time.Duration) Option {
+	return func(r *Ritual) { r.connectLatency = d }
+}
+
+// WithFinDelay delays passing a close on to the other side by d.
+func WithFinDelay(d time.Duration) Option {
+	return func(r *Ritual) { r.finDelay = d }
+}
+
+// WithErrorRate answers this fraction of HTTP requests, RPCs and DNS queries
+// with an injected error instead of forwarding them, and kills a database
+// query's connection before its result arrives.
+func WithErrorRate(rate float64) Option {
+	return func(r *Ritual) { r.errorRate = rate }
+}
+
+// WithResetRate cuts off this fraction of HTTP/2 streams and database
+// result sets partway through.
+func WithResetRate(rate float64) Option {
+	return func(r *Ritual) { r.resetRate = rate }
+}
+
+// WithMessageDelay holds a gRPC message or DNS response for d, with
+// probability rate, before passing it on.
+func WithMessageDelay(d time.Duration, rate float64) Option {
+	return func(r *Ritual) {
+		r.messageDelay = d
+		r.messageRate = rate
+	}
+}
+
+// WithTarpit punishes clients that reconnect too quickly.
+func WithTarpit(tarpit *Tarpit) Option {
+	return func(r *Ritual) { r.tarpit = tarpit }
+}
+
+// WithToxic adds stages to the end of the pipeline of stream toxics applied
+// to every connection.
+func WithToxic(stages ...Stage) Option {
+	return func(r *Ritual) { r.pipeline = append(r.pipeline, stages...) }
+}
+
+// WithBandwidth adds a toxic to the pipeline limiting both directions of
+// every connection to bytesPerSecond.
+func WithBandwidth(bytesPerSecond int64) Option {
+	return WithToxic(Stage{Toxic: BandwidthToxic{BytesPerSecond: bytesPerSecond}, Toxicity: 1})
+}
+
+// WithLoadLatency slows responses as the route's open connections rise,
+// along curve; see LoadLatency. Its toxic runs after every other.
+func WithLoadLatency(curve []LoadPoint) Option {
+	return func(r *Ritual) { r.load = NewLoadLatency(curve) }
+}
+
+// WithIntensity scales the drop rate, loss model and every delay by
+// intensity as it changes. Toxics given to WithToxic carry their own.
+func WithIntensity(intensity *Intensity) Option {
+	return func(r *Ritual) { r.intensity = intensity }
+}
+
+// WithRand rolls the ritual's chaos from rng instead of the global source.
+// Give the route's toxics and loss models the same one.
+func WithRand(rng *Rand) Option {
+	return func(r *Ritual) { r.rand = rng }
+}
@@ -0,0 +1,51 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew_NoOptions(t *testing.T) {
+	ritual := New()
+	if curse := NewCurse(ritual); curse.DropConnections || curse.StartDelay != 0 {
+		t.Errorf("NewCurse(New()) = %+v, want no chaos", curse)
+	}
+	if ritual.DialFails() || ritual.RequestFails() || ritual.StreamResets() || ritual.DelaysMessages() {
+		t.Error("New() ritual rolls faults, want none")
+	}
+	if len(ritual.Pipeline()) != 0 || ritual.Tarpit() != nil || ritual.Load() != nil {
+		t.Error("New() ritual has toxics, a tarpit or load latency, want none")
+	}
+}
+
+func TestNew_Pipeline(t *testing.T) {
+	intensity := NewIntensity(2)
+	ritual := New(
+		WithLoadLatency([]LoadPoint{{Connections: 1, Latency: time.Millisecond}}),
+		WithBandwidth(1024),
+		WithToxic(Stage{Toxic: DropToxic{}, Stream: Upstream, Toxicity: 1}),
+		WithIntensity(intensity),
+	)
+
+	pipeline := ritual.Pipeline()
+	want := []string{"bandwidth", "drop", "load_latency"}
+	if len(pipeline) != len(want) {
+		t.Fatalf("Pipeline() has %d stages, want %d", len(pipeline), len(want))
+	}
+	for i, name := range want {
+		if got := pipeline[i].Toxic.Name(); got != name {
+			t.Errorf("stage %d = %s, want %s", i, got, name)
+		}
+	}
+	load, ok := pipeline[2].Toxic.(LoadLatencyToxic)
+	if !ok || load.Load != ritual.Load() || load.Intensity != intensity {
+		t.Errorf("load stage = %+v, want the ritual's load and intensity", pipeline[2].Toxic)
+	}
+}
+
+func TestNew_LaterOptionWins(t *testing.T) {
+	ritual := New(WithLatency(time.Second), WithLatency(10*time.Millisecond))
+	if got := NewCurse(ritual).StartDelay; got != 10*time.Millisecond {
+		t.Errorf("StartDelay = %v, want the later 10ms", got)
+	}
+}
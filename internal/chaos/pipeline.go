@@ -0,0 +1,88 @@
+package chaos
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrSevered is returned by toxics that cut a stream short. The proxy closes
+// both sides of the connection when it sees it.
+var ErrSevered = errors.New("chaos: stream severed")
+
+// Stream selects which direction of a connection a toxic applies to.
+type Stream int
+
+const (
+	// Both applies the toxic to traffic in either direction.
+	Both Stream = iota
+	// Downstream is traffic from the upstream server to the client.
+	Downstream
+	// Upstream is traffic from the client to the upstream server.
+	Upstream
+)
+
+func (s Stream) String() string {
+	switch s {
+	case Downstream:
+		return "downstream"
+	case Upstream:
+		return "upstream"
+	default:
+		return "both"
+	}
+}
+
+// Toxic is one stream effect, Toxiproxy-style. Wrap is called once per
+// direction of every connection, so per-stream state belongs in the returned
+// writer. Writers that buffer should implement Flush and forward it to dst.
+type Toxic interface {
+	Name() string
+	Wrap(dst io.Writer) io.Writer
+}
+
+// Stage places a toxic in a route's pipeline.
+type Stage struct {
+	Toxic  Toxic
+	Stream Stream
+	// Toxicity is the chance the stage applies to a given connection.
+	Toxicity float64
+}
+
+// Pipeline is an ordered list of stages. Data passes through the stages in
+// order: the first stage sees bytes first and the last hands them to the
+// connection.
+type Pipeline []Stage
+
+// Roll rolls each stage's toxicity once and returns the stages that take
+// effect for a connection, from rng. apply is called for each stage rolled,
+// in pipeline order, and the stage is left out if it returns false.
+func (p Pipeline) Roll(rng *Rand, apply func(Stage) bool) Pipeline {
+	active := make(Pipeline, 0, len(p))
+	for _, stage := range p {
+		if stage.Toxicity < 1 && rng.Float64() >= stage.Toxicity {
+			continue
+		}
+		if apply != nil && !apply(stage) {
+			continue
+		}
+		active = append(active, stage)
+	}
+	return active
+}
+
+// Wrap wraps both directions of a connection with the stages Roll picks.
+func (p Pipeline) Wrap(rng *Rand, toClient, toServer io.Writer, apply func(Stage) bool) (io.Writer, io.Writer) {
+	active := p.Roll(rng, apply)
+
+	// Wrap back to front so the first stage ends up outermost.
+	for i := len(active) - 1; i >= 0; i-- {
+		stage := active[i]
+		if stage.Stream != Upstream {
+			toClient = stage.Toxic.Wrap(toClient)
+		}
+		if stage.Stream != Downstream {
+			toServer = stage.Toxic.Wrap(toServer)
+		}
+	}
+	return toClient, toServer
+}
@@ -0,0 +1,322 @@
+package chaos
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tagToxic appends its tag to every chunk so tests can see the order stages
+// ran in.
+type tagToxic string
+
+func (t tagToxic) Name() string { return string(t) }
+
+func (t tagToxic) Wrap(dst io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		if _, err := dst.Write(append(append([]byte(nil), p...), t...)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestPipeline_Wrap(t *testing.T) {
+	tests := []struct {
+		name         string
+		pipeline     Pipeline
+		refuse       string
+		wantToClient string
+		wantToServer string
+		wantApplied  []string
+	}{
+		{
+			name:         "empty pipeline passes through",
+			wantToClient: "x",
+			wantToServer: "x",
+		},
+		{
+			name: "stages run in order",
+			pipeline: Pipeline{
+				{Toxic: tagToxic("1"), Toxicity: 1},
+				{Toxic: tagToxic("2"), Toxicity: 1},
+			},
+			wantToClient: "x12",
+			wantToServer: "x12",
+			wantApplied:  []string{"1", "2"},
+		},
+		{
+			name: "stream selects direction",
+			pipeline: Pipeline{
+				{Toxic: tagToxic("d"), Stream: Downstream, Toxicity: 1},
+				{Toxic: tagToxic("u"), Stream: Upstream, Toxicity: 1},
+			},
+			wantToClient: "xd",
+			wantToServer: "xu",
+			wantApplied:  []string{"d", "u"},
+		},
+		{
+			name: "refused stage is left out",
+			pipeline: Pipeline{
+				{Toxic: tagToxic("1"), Toxicity: 1},
+				{Toxic: tagToxic("2"), Toxicity: 1},
+			},
+			refuse:       "1",
+			wantToClient: "x2",
+			wantToServer: "x2",
+			wantApplied:  []string{"2"},
+		},
+		{
+			name: "zero toxicity never applies",
+			pipeline: Pipeline{
+				{Toxic: tagToxic("1"), Toxicity: 0},
+			},
+			wantToClient: "x",
+			wantToServer: "x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var toClient, toServer bytes.Buffer
+			var applied []string
+
+			c, s := tt.pipeline.Wrap(nil, &toClient, &toServer, func(stage Stage) bool {
+				if stage.Toxic.Name() == tt.refuse {
+					return false
+				}
+				applied = append(applied, stage.Toxic.Name())
+				return true
+			})
+			c.Write([]byte("x"))
+			s.Write([]byte("x"))
+
+			if got := toClient.String(); got != tt.wantToClient {
+				t.Errorf("to client = %q, want %q", got, tt.wantToClient)
+			}
+			if got := toServer.String(); got != tt.wantToServer {
+				t.Errorf("to server = %q, want %q", got, tt.wantToServer)
+			}
+			if len(applied) != len(tt.wantApplied) {
+				t.Fatalf("applied = %v, want %v", applied, tt.wantApplied)
+			}
+			for i := range applied {
+				if applied[i] != tt.wantApplied[i] {
+					t.Errorf("applied = %v, want %v", applied, tt.wantApplied)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateToxic(t *testing.T) {
+	tests := []struct {
+		name    string
+		bytes   int64
+		chunks  []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "under the limit passes through",
+			bytes:  10,
+			chunks: []string{"abc", "def"},
+			want:   "abcdef",
+		},
+		{
+			name:    "cut inside a chunk",
+			bytes:   4,
+			chunks:  []string{"abc", "def"},
+			want:    "abcd",
+			wantErr: true,
+		},
+		{
+			name:    "zero bytes severs immediately",
+			bytes:   0,
+			chunks:  []string{"abc"},
+			want:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := TruncateToxic{Bytes: tt.bytes}.Wrap(&buf)
+
+			var err error
+			for _, chunk := range tt.chunks {
+				if _, err = w.Write([]byte(chunk)); err != nil {
+					break
+				}
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if tt.wantErr != errors.Is(err, ErrSevered) {
+				t.Errorf("error = %v, want severed %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDropToxic(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		want string
+	}{
+		{name: "zero rate passes through", rate: 0.0, want: "ab"},
+		{name: "always drop", rate: 1.0, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := DropToxic{Rate: tt.rate}.Wrap(&buf)
+
+			for _, chunk := range []string{"a", "b"} {
+				n, err := w.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v; want %d, nil", chunk, n, err, len(chunk))
+				}
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorruptToxic(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		wantChanged bool
+	}{
+		{name: "zero rate passes through", rate: 0.0, wantChanged: false},
+		{name: "always corrupt", rate: 1.0, wantChanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := CorruptToxic{Rate: tt.rate}.Wrap(&buf)
+
+			input := []byte("hello world")
+			original := append([]byte(nil), input...)
+			if _, err := w.Write(input); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			if !bytes.Equal(input, original) {
+				t.Errorf("caller's buffer was modified: %q", input)
+			}
+			if got := buf.Len(); got != len(original) {
+				t.Errorf("output length = %d, want %d", got, len(original))
+			}
+			if changed := !bytes.Equal(buf.Bytes(), original); changed != tt.wantChanged {
+				t.Errorf("output %q changed = %v, want %v", buf.Bytes(), changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestBandwidthToxic(t *testing.T) {
+	var buf bytes.Buffer
+	w := BandwidthToxic{BytesPerSecond: 1000}.Wrap(&buf)
+
+	start := time.Now()
+	payload := bytes.Repeat([]byte("x"), 200)
+	n, err := w.Write(payload)
+	elapsed := time.Since(start)
+
+	if err != nil || n != len(payload) {
+		t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(payload))
+	}
+	if buf.Len() != len(payload) {
+		t.Errorf("output length = %d, want %d", buf.Len(), len(payload))
+	}
+	// 200 bytes at 1000 B/s takes about 200ms.
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("write took %v, want at least 150ms", elapsed)
+	}
+}
+
+func TestLatencyToxic(t *testing.T) {
+	var buf bytes.Buffer
+	w := LatencyToxic{Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}.Wrap(&buf)
+
+	start := time.Now()
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("write took %v, want at least 40ms", elapsed)
+	}
+	if buf.String() != "x" {
+		t.Errorf("output = %q, want %q", buf.String(), "x")
+	}
+}
+
+func TestFirstByteLatencyToxic(t *testing.T) {
+	var buf bytes.Buffer
+	w := FirstByteLatencyToxic{Latency: 50 * time.Millisecond}.Wrap(&buf)
+
+	start := time.Now()
+	w.Write([]byte("a"))
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("first write took %v, want at least 50ms", elapsed)
+	}
+
+	start = time.Now()
+	w.Write([]byte("b"))
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("second write took %v, want no delay", elapsed)
+	}
+	if buf.String() != "ab" {
+		t.Errorf("output = %q, want %q", buf.String(), "ab")
+	}
+}
+
+func TestSegmentToxic(t *testing.T) {
+	tests := []struct {
+		name     string
+		toxic    SegmentToxic
+		input    string
+		minBytes int
+		maxBytes int
+	}{
+		{name: "single bytes", toxic: SegmentToxic{MinBytes: 1, MaxBytes: 1}, input: "hello", minBytes: 1, maxBytes: 1},
+		{name: "range", toxic: SegmentToxic{MinBytes: 2, MaxBytes: 4}, input: strings.Repeat("x", 100), minBytes: 2, maxBytes: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordingWriter{}
+			n, err := tt.toxic.Wrap(rec).Write([]byte(tt.input))
+			if err != nil || n != len(tt.input) {
+				t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(tt.input))
+			}
+
+			if got := strings.Join(rec.writes, ""); got != tt.input {
+				t.Errorf("output = %q, want %q", got, tt.input)
+			}
+			for i, w := range rec.writes {
+				last := i == len(rec.writes)-1
+				if len(w) > tt.maxBytes || (len(w) < tt.minBytes && !last) {
+					t.Errorf("write %d is %d bytes, want %d-%d", i, len(w), tt.minBytes, tt.maxBytes)
+				}
+			}
+		})
+	}
+}
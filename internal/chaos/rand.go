@@ -0,0 +1,57 @@
+package chaos
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Rand is a route's own source of random numbers for its chaos rolls, safe
+// for concurrent use. Each route having one keeps routes from contending
+// for the global source, and a seeded one makes a route's rolls repeat
+// from run to run, given the same traffic in the same order. A nil *Rand
+// draws from math/rand's global source.
+type Rand struct {
+	seed int64
+
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func NewRand(seed int64) *Rand {
+	return &Rand{seed: seed, r: rand.New(rand.NewSource(seed))}
+}
+
+// Seed returns the seed r was made with, to repeat its rolls with.
+func (r *Rand) Seed() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.seed
+}
+
+func (r *Rand) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *Rand) Intn(n int) int {
+	if r == nil {
+		return rand.Intn(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+func (r *Rand) Int63n(n int64) int64 {
+	if r == nil {
+		return rand.Int63n(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
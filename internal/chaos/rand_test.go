@@ -0,0 +1,46 @@
+package chaos
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestRand_NilDrawsFromGlobal(t *testing.T) {
+	var rng *Rand
+	if got := rng.Float64(); got < 0 || got >= 1 {
+		t.Errorf("nil Float64() = %v, want [0, 1)", got)
+	}
+	if got := rng.Seed(); got != 0 {
+		t.Errorf("nil Seed() = %d, want 0", got)
+	}
+}
+
+func TestRand_SeedRepeatsRolls(t *testing.T) {
+	// drops rolls a ritual's connections and a drop toxic's chunks, as a
+	// route does, and returns what was dropped.
+	drops := func(seed int64) (conns []bool, stream string) {
+		rng := NewRand(seed)
+		ritual := New(WithDropRate(0.5), WithRand(rng))
+		for range 20 {
+			conns = append(conns, NewCurse(ritual).DropConnections)
+		}
+		var buf bytes.Buffer
+		w := DropToxic{Rate: 0.5, Rand: rng}.Wrap(&buf)
+		for _, chunk := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			io.WriteString(w, chunk)
+		}
+		return conns, buf.String()
+	}
+
+	conns, stream := drops(42)
+	againConns, againStream := drops(42)
+	if !slices.Equal(conns, againConns) || stream != againStream {
+		t.Errorf("seed 42 rolled %v %q, then %v %q", conns, stream, againConns, againStream)
+	}
+	otherConns, otherStream := drops(7)
+	if slices.Equal(conns, otherConns) && stream == otherStream {
+		t.Errorf("seeds 42 and 7 both rolled %v %q", conns, stream)
+	}
+}
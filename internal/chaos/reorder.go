@@ -0,0 +1,113 @@
+package chaos
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// reorderHoldLimit caps how long a chunk may be held back waiting for a later
+// chunk to overtake it, so request/response protocols don't stall forever.
+const reorderHoldLimit = 100 * time.Millisecond
+
+// ReorderWriter holds back chunks at a configurable probability and releases
+// them after a later chunk, reordering the stream within a small window.
+type ReorderWriter struct {
+	dst    io.Writer
+	rate   float64
+	window int
+	rand   *Rand
+	// maxBytes caps the bytes held back at once, 0 for no cap, and mem is
+	// the budget they are reserved from.
+	maxBytes int
+	mem      *Memory
+
+	mu        sync.Mutex
+	held      [][]byte
+	heldBytes int
+	timer     *time.Timer
+	err       error
+}
+
+func NewReorderWriter(dst io.Writer, rate float64, window int) *ReorderWriter {
+	if window < 2 {
+		window = 2
+	}
+
+	return &ReorderWriter{
+		dst:    dst,
+		rate:   rate,
+		window: window,
+	}
+}
+
+func (w *ReorderWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	// The window counts the chunk that overtakes the held ones.
+	if len(w.held) < w.window-1 && w.rand.Float64() < w.rate && w.fits(len(p)) {
+		w.held = append(w.held, append([]byte(nil), p...))
+		w.heldBytes += len(p)
+		if w.timer == nil {
+			w.timer = time.AfterFunc(reorderHoldLimit, func() {
+				w.mu.Lock()
+				defer w.mu.Unlock()
+				w.flushLocked()
+			})
+		}
+		return len(p), nil
+	}
+
+	n, err := w.dst.Write(p)
+	if err != nil {
+		w.err = err
+		return n, err
+	}
+
+	w.flushLocked()
+	return n, w.err
+}
+
+// fits reserves n more bytes to hold back, if they fit under maxBytes and
+// the memory budget.
+func (w *ReorderWriter) fits(n int) bool {
+	if w.maxBytes > 0 && w.heldBytes+n > w.maxBytes {
+		return false
+	}
+	return w.mem.Reserve(n)
+}
+
+// Flush writes any chunks still being held back, then flushes dst.
+func (w *ReorderWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.flushLocked()
+	if w.err != nil {
+		return w.err
+	}
+	return Flush(w.dst)
+}
+
+func (w *ReorderWriter) flushLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	for _, chunk := range w.held {
+		if w.err != nil {
+			break
+		}
+		if _, err := w.dst.Write(chunk); err != nil {
+			w.err = err
+		}
+	}
+	w.mem.Release(w.heldBytes)
+	w.held, w.heldBytes = nil, 0
+}
@@ -0,0 +1,127 @@
+package chaos
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReorderWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		window int
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "zero rate passes through",
+			rate:   0.0,
+			window: 2,
+			chunks: []string{"a", "b", "c"},
+			want:   "abc",
+		},
+		{
+			name:   "always reorder with window 2",
+			rate:   1.0,
+			window: 2,
+			chunks: []string{"a", "b", "c", "d"},
+			want:   "badc",
+		},
+		{
+			name:   "always reorder with window 3",
+			rate:   1.0,
+			window: 3,
+			chunks: []string{"a", "b", "c"},
+			want:   "cab",
+		},
+		{
+			name:   "window below 2 is raised to 2",
+			rate:   1.0,
+			window: 0,
+			chunks: []string{"a", "b"},
+			want:   "ba",
+		},
+		{
+			name:   "trailing held chunk flushed",
+			rate:   1.0,
+			window: 2,
+			chunks: []string{"a", "b", "c"},
+			want:   "bac",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewReorderWriter(&buf, tt.rate, tt.window)
+
+			for _, chunk := range tt.chunks {
+				n, err := w.Write([]byte(chunk))
+				if err != nil {
+					t.Fatalf("Write(%q) error = %v", chunk, err)
+				}
+				if n != len(chunk) {
+					t.Errorf("Write(%q) n = %d, want %d", chunk, n, len(chunk))
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReorderWriter_HoldLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewReorderWriter(&buf, 1.0, 2)
+
+	if _, err := w.Write([]byte("held")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	time.Sleep(reorderHoldLimit + 50*time.Millisecond)
+
+	w.mu.Lock()
+	got := buf.String()
+	w.mu.Unlock()
+
+	if got != "held" {
+		t.Errorf("output after hold limit = %q, want %q", got, "held")
+	}
+}
+
+func TestReorderWriter_MaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int
+		memory   int64
+		want     string
+	}{
+		{name: "fits", want: "bbaa"},
+		{name: "over maxBytes", maxBytes: 1, want: "aabb"},
+		{name: "over memory", memory: 1, want: "aabb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewReorderWriter(&buf, 1.0, 2)
+			w.maxBytes = tt.maxBytes
+			if tt.memory > 0 {
+				w.mem = NewMemory(tt.memory)
+			}
+			w.Write([]byte("aa"))
+			w.Write([]byte("bb"))
+			if buf.String() != tt.want {
+				t.Errorf("output = %q, want %q", buf.String(), tt.want)
+			}
+			if used := w.mem.Used(); used != 0 {
+				t.Errorf("Used() with nothing held = %d, want 0", used)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+)
+
+// tarpitSweepSize is how many tracked clients trigger a sweep of stale ones.
+const tarpitSweepSize = 1024
+
+// Tarpit punishes clients that reconnect too quickly. Once a client opens
+// more than Threshold connections within Window, each extra connection is
+// delayed by another DelayStep (capped at MaxDelay), and past RejectAfter
+// connections it is rejected outright.
+type Tarpit struct {
+	Window      time.Duration
+	Threshold   int
+	DelayStep   time.Duration
+	MaxDelay    time.Duration
+	RejectAfter int
+
+	mu      sync.Mutex
+	clients map[string][]time.Time
+}
+
+func NewTarpit(window time.Duration, threshold int, delayStep, maxDelay time.Duration, rejectAfter int) *Tarpit {
+	return &Tarpit{
+		Window:      window,
+		Threshold:   threshold,
+		DelayStep:   delayStep,
+		MaxDelay:    maxDelay,
+		RejectAfter: rejectAfter,
+		clients:     make(map[string][]time.Time),
+	}
+}
+
+// Check records a connection from client at now and returns how long to
+// delay it, or reject if it should be refused.
+func (t *Tarpit) Check(client string, now time.Time) (delay time.Duration, reject bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.clients) >= tarpitSweepSize {
+		t.sweep(now)
+	}
+
+	recent := t.prune(t.clients[client], now)
+	recent = append(recent, now)
+	t.clients[client] = recent
+
+	count := len(recent)
+	if t.RejectAfter > 0 && count > t.RejectAfter {
+		return 0, true
+	}
+
+	excess := count - t.Threshold
+	if excess <= 0 {
+		return 0, false
+	}
+
+	delay = time.Duration(excess) * t.DelayStep
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay, false
+}
+
+// prune drops connection times that fell out of the window.
+func (t *Tarpit) prune(times []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-t.Window)
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+func (t *Tarpit) sweep(now time.Time) {
+	for client, times := range t.clients {
+		if recent := t.prune(times, now); len(recent) == 0 {
+			delete(t.clients, client)
+		} else {
+			t.clients[client] = recent
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTarpit_Check(t *testing.T) {
+	tarpit := NewTarpit(time.Second, 2, 100*time.Millisecond, 250*time.Millisecond, 5)
+	start := time.Now()
+
+	tests := []struct {
+		name       string
+		offset     time.Duration
+		wantDelay  time.Duration
+		wantReject bool
+	}{
+		{name: "first connection", offset: 0, wantDelay: 0},
+		{name: "second connection at threshold", offset: 10 * time.Millisecond, wantDelay: 0},
+		{name: "third connection delayed", offset: 20 * time.Millisecond, wantDelay: 100 * time.Millisecond},
+		{name: "fourth connection delayed more", offset: 30 * time.Millisecond, wantDelay: 200 * time.Millisecond},
+		{name: "fifth connection capped", offset: 40 * time.Millisecond, wantDelay: 250 * time.Millisecond},
+		{name: "sixth connection rejected", offset: 50 * time.Millisecond, wantReject: true},
+		{name: "window expired", offset: 2 * time.Second, wantDelay: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, reject := tarpit.Check("10.0.0.1", start.Add(tt.offset))
+			if delay != tt.wantDelay || reject != tt.wantReject {
+				t.Errorf("Check() = (%v, %v), want (%v, %v)", delay, reject, tt.wantDelay, tt.wantReject)
+			}
+		})
+	}
+}
+
+func TestTarpit_ClientsIsolated(t *testing.T) {
+	tarpit := NewTarpit(time.Second, 1, 100*time.Millisecond, 0, 0)
+	now := time.Now()
+
+	tarpit.Check("10.0.0.1", now)
+	if delay, _ := tarpit.Check("10.0.0.1", now); delay == 0 {
+		t.Error("expected repeat client to be delayed")
+	}
+	if delay, _ := tarpit.Check("10.0.0.2", now); delay != 0 {
+		t.Errorf("new client delay = %v, want 0", delay)
+	}
+}
+
+func TestTarpit_Nil(t *testing.T) {
+	var tarpit *Tarpit
+	if delay, reject := tarpit.Check("10.0.0.1", time.Now()); delay != 0 || reject {
+		t.Errorf("nil Check() = (%v, %v), want (0, false)", delay, reject)
+	}
+}
@@ -0,0 +1,307 @@
+package chaos
+
+import (
+	"io"
+	"time"
+)
+
+// LatencyToxic delays every chunk by Latency, plus or minus up to Jitter,
+// both multiplied by Intensity.
+type LatencyToxic struct {
+	Latency   time.Duration
+	Jitter    time.Duration
+	Intensity *Intensity
+	Rand      *Rand
+}
+
+func (LatencyToxic) Name() string { return "latency" }
+
+func (t LatencyToxic) Wrap(dst io.Writer) io.Writer {
+	return &latencyWriter{dst: dst, toxic: t}
+}
+
+type latencyWriter struct {
+	dst   io.Writer
+	toxic LatencyToxic
+}
+
+func (w *latencyWriter) Write(p []byte) (int, error) {
+	delay := w.toxic.Latency
+	if w.toxic.Jitter > 0 {
+		delay += time.Duration(w.toxic.Rand.Int63n(int64(2*w.toxic.Jitter)+1)) - w.toxic.Jitter
+	}
+	delay = time.Duration(float64(delay) * w.toxic.Intensity.Scale())
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return w.dst.Write(p)
+}
+
+func (w *latencyWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// FirstByteLatencyToxic holds back the first chunk of a stream by Latency,
+// multiplied by Intensity, and lets the rest through untouched.
+type FirstByteLatencyToxic struct {
+	Latency   time.Duration
+	Intensity *Intensity
+}
+
+func (FirstByteLatencyToxic) Name() string { return "first_byte_latency" }
+
+func (t FirstByteLatencyToxic) Wrap(dst io.Writer) io.Writer {
+	return &firstByteLatencyWriter{dst: dst, toxic: t}
+}
+
+type firstByteLatencyWriter struct {
+	dst     io.Writer
+	toxic   FirstByteLatencyToxic
+	started bool
+}
+
+func (w *firstByteLatencyWriter) Write(p []byte) (int, error) {
+	if !w.started && len(p) > 0 {
+		w.started = true
+		if delay := time.Duration(float64(w.toxic.Latency) * w.toxic.Intensity.Scale()); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+	return w.dst.Write(p)
+}
+
+func (w *firstByteLatencyWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// BandwidthToxic limits throughput to BytesPerSecond.
+type BandwidthToxic struct {
+	BytesPerSecond int64
+}
+
+func (BandwidthToxic) Name() string { return "bandwidth" }
+
+func (t BandwidthToxic) Wrap(dst io.Writer) io.Writer {
+	return &bandwidthWriter{dst: dst, rate: t.BytesPerSecond}
+}
+
+type bandwidthWriter struct {
+	dst  io.Writer
+	rate int64
+}
+
+// Write sends p in slices of a tenth of a second's worth of bytes, pausing
+// after each, so large writes trickle out rather than arriving in one burst.
+func (w *bandwidthWriter) Write(p []byte) (int, error) {
+	slice := max(w.rate/10, 1)
+
+	written := 0
+	for written < len(p) {
+		end := min(written+int(slice), len(p))
+		n, err := w.dst.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		time.Sleep(time.Duration(int64(n) * int64(time.Second) / w.rate))
+	}
+	return written, nil
+}
+
+func (w *bandwidthWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// CorruptToxic flips one random bit in each byte with probability Rate.
+type CorruptToxic struct {
+	Rate float64
+	Rand *Rand
+}
+
+func (CorruptToxic) Name() string { return "corrupt" }
+
+func (t CorruptToxic) Wrap(dst io.Writer) io.Writer {
+	return &corruptWriter{dst: dst, rate: t.Rate, rand: t.Rand}
+}
+
+type corruptWriter struct {
+	dst  io.Writer
+	rate float64
+	rand *Rand
+}
+
+// Write corrupts a copy of p; the caller's buffer is left untouched.
+func (w *corruptWriter) Write(p []byte) (int, error) {
+	out := append([]byte(nil), p...)
+	for i := range out {
+		if w.rand.Float64() < w.rate {
+			out[i] ^= 1 << w.rand.Intn(8)
+		}
+	}
+	return w.dst.Write(out)
+}
+
+func (w *corruptWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// TruncateToxic forwards the first Bytes bytes of a stream and then severs
+// the connection.
+type TruncateToxic struct {
+	Bytes int64
+}
+
+func (TruncateToxic) Name() string { return "truncate" }
+
+func (t TruncateToxic) Wrap(dst io.Writer) io.Writer {
+	return &truncateWriter{dst: dst, remaining: t.Bytes}
+}
+
+type truncateWriter struct {
+	dst       io.Writer
+	remaining int64
+}
+
+func (w *truncateWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		n, err := w.dst.Write(p)
+		w.remaining -= int64(n)
+		return n, err
+	}
+
+	n, err := w.dst.Write(p[:w.remaining])
+	w.remaining -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, ErrSevered
+}
+
+func (w *truncateWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// DropToxic silently discards chunks with probability Rate, multiplied by
+// Intensity. The connection stays open, so the peer sees a stream with gaps
+// in it.
+type DropToxic struct {
+	Rate      float64
+	Intensity *Intensity
+	Rand      *Rand
+}
+
+func (DropToxic) Name() string { return "drop" }
+
+func (t DropToxic) Wrap(dst io.Writer) io.Writer {
+	return &dropWriter{dst: dst, toxic: t}
+}
+
+type dropWriter struct {
+	dst   io.Writer
+	toxic DropToxic
+}
+
+func (w *dropWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 && w.toxic.Rand.Float64() < scaleRate(w.toxic.Rate, w.toxic.Intensity.Scale()) {
+		return len(p), nil
+	}
+	return w.dst.Write(p)
+}
+
+func (w *dropWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// SegmentToxic splits every chunk into writes of MinBytes to MaxBytes bytes,
+// pausing Pause between them, so the peer sees a message spread over many
+// small reads.
+type SegmentToxic struct {
+	MinBytes int
+	MaxBytes int
+	Pause    time.Duration
+	Rand     *Rand
+}
+
+func (SegmentToxic) Name() string { return "segment" }
+
+func (t SegmentToxic) Wrap(dst io.Writer) io.Writer {
+	return &segmentWriter{dst: dst, toxic: t}
+}
+
+type segmentWriter struct {
+	dst   io.Writer
+	toxic SegmentToxic
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	minBytes := max(w.toxic.MinBytes, 1)
+	maxBytes := max(w.toxic.MaxBytes, minBytes)
+
+	written := 0
+	for written < len(p) {
+		if written > 0 && w.toxic.Pause > 0 {
+			time.Sleep(w.toxic.Pause)
+		}
+		size := minBytes + w.toxic.Rand.Intn(maxBytes-minBytes+1)
+		n, err := w.dst.Write(p[written:min(written+size, len(p))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *segmentWriter) Flush() error {
+	return Flush(w.dst)
+}
+
+// ReorderToxic adapts ReorderWriter to the pipeline.
+type ReorderToxic struct {
+	Rate   float64
+	Window int
+	Rand   *Rand
+	// MaxBytes caps the bytes a stream holds back at once, 0 for no cap,
+	// and Memory is the budget they are reserved from.
+	MaxBytes int
+	Memory   *Memory
+}
+
+func (ReorderToxic) Name() string { return "reorder" }
+
+func (t ReorderToxic) Wrap(dst io.Writer) io.Writer {
+	w := NewReorderWriter(dst, t.Rate, t.Window)
+	w.rand = t.Rand
+	w.maxBytes, w.mem = t.MaxBytes, t.Memory
+	return w
+}
+
+// CoalesceToxic adapts CoalesceWriter to the pipeline.
+type CoalesceToxic struct {
+	Interval time.Duration
+	MaxBytes int
+	// Memory is the budget buffered bytes are reserved from.
+	Memory *Memory
+}
+
+func (CoalesceToxic) Name() string { return "coalesce" }
+
+func (t CoalesceToxic) Wrap(dst io.Writer) io.Writer {
+	w := NewCoalesceWriter(dst, t.Interval, t.MaxBytes)
+	w.mem = t.Memory
+	return w
+}
+
+// DuplicateToxic adapts DuplicateWriter to the pipeline.
+type DuplicateToxic struct {
+	Rate float64
+	Rand *Rand
+}
+
+func (DuplicateToxic) Name() string { return "duplicate" }
+
+func (t DuplicateToxic) Wrap(dst io.Writer) io.Writer {
+	w := NewDuplicateWriter(dst, t.Rate)
+	w.rand = t.Rand
+	return w
+}
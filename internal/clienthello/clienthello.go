@@ -0,0 +1,253 @@
+// Package clienthello parses the TLS ClientHello a client sends first, so a
+// passthrough proxy can see SNI, ALPN and offered versions without
+// terminating TLS.
+package clienthello
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	recordHeaderLen     = 5
+	maxRecordLen        = 1<<14 + 256 // plaintext limit plus headroom for odd stacks
+	recordTypeHandshake = 0x16
+	handshakeTypeHello  = 0x01
+
+	extServerName        = 0x0000
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+)
+
+// BufferSize is the bufio.Reader size Peek needs to see a whole record.
+const BufferSize = recordHeaderLen + maxRecordLen
+
+// ErrNotTLS is returned when the stream does not start with a TLS handshake.
+var ErrNotTLS = errors.New("clienthello: not a TLS handshake")
+
+var errTruncated = errors.New("clienthello: truncated message")
+
+// ClientHello holds the fields of a ClientHello useful for analytics.
+type ClientHello struct {
+	ServerName string
+	ALPN       []string
+	// Version is the highest version offered, from supported_versions when
+	// present and the legacy version field otherwise.
+	Version      uint16
+	CipherSuites []uint16
+}
+
+// VersionName returns the offered version as text, e.g. "TLS 1.3".
+func (h ClientHello) VersionName() string {
+	return tls.VersionName(h.Version)
+}
+
+// CipherSuiteNames returns the offered cipher suites as text.
+func (h ClientHello) CipherSuiteNames() []string {
+	names := make([]string, len(h.CipherSuites))
+	for i, id := range h.CipherSuites {
+		names[i] = tls.CipherSuiteName(id)
+	}
+	return names
+}
+
+// Peek parses the ClientHello at the front of r without consuming it, so
+// the bytes can still be forwarded. r must have been created with at least
+// BufferSize bytes of buffer. A ClientHello split across several records is
+// not supported.
+func Peek(r *bufio.Reader) (ClientHello, error) {
+	// Check the first byte on its own so a short plain-text message isn't
+	// held up waiting for a full record header that will never come.
+	first, err := r.Peek(1)
+	if err != nil {
+		return ClientHello{}, err
+	}
+	if first[0] != recordTypeHandshake {
+		return ClientHello{}, ErrNotTLS
+	}
+
+	header, err := r.Peek(recordHeaderLen)
+	if err != nil {
+		return ClientHello{}, err
+	}
+	if header[1] != 0x03 {
+		return ClientHello{}, ErrNotTLS
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if length > maxRecordLen {
+		return ClientHello{}, fmt.Errorf("clienthello: record length %d exceeds limit", length)
+	}
+	record, err := r.Peek(recordHeaderLen + length)
+	if err != nil {
+		return ClientHello{}, err
+	}
+	return Parse(record[recordHeaderLen:])
+}
+
+// Parse parses a handshake message holding a ClientHello.
+func Parse(handshake []byte) (ClientHello, error) {
+	s := parser(handshake)
+
+	msgType, ok := s.uint8()
+	if !ok || msgType != handshakeTypeHello {
+		return ClientHello{}, ErrNotTLS
+	}
+	body, ok := s.bytes24()
+	if !ok {
+		return ClientHello{}, errTruncated
+	}
+
+	var hello ClientHello
+	s = parser(body)
+	if hello.Version, ok = s.uint16(); !ok {
+		return ClientHello{}, errTruncated
+	}
+	if _, ok = s.take(32); !ok { // random
+		return ClientHello{}, errTruncated
+	}
+	if _, ok = s.bytes8(); !ok { // session id
+		return ClientHello{}, errTruncated
+	}
+
+	suites, ok := s.bytes16()
+	if !ok {
+		return ClientHello{}, errTruncated
+	}
+	for suites := parser(suites); len(suites) >= 2; {
+		id, _ := suites.uint16()
+		if !isGREASE(id) {
+			hello.CipherSuites = append(hello.CipherSuites, id)
+		}
+	}
+
+	if _, ok = s.bytes8(); !ok { // compression methods
+		return ClientHello{}, errTruncated
+	}
+	if len(s) == 0 {
+		// Extensions are optional before TLS 1.3.
+		return hello, nil
+	}
+
+	extensions, ok := s.bytes16()
+	if !ok {
+		return ClientHello{}, errTruncated
+	}
+	for ext := parser(extensions); len(ext) > 0; {
+		extType, ok1 := ext.uint16()
+		data, ok2 := ext.bytes16()
+		if !ok1 || !ok2 {
+			return ClientHello{}, errTruncated
+		}
+		if err := hello.parseExtension(extType, parser(data)); err != nil {
+			return ClientHello{}, err
+		}
+	}
+
+	return hello, nil
+}
+
+func (h *ClientHello) parseExtension(extType uint16, data parser) error {
+	switch extType {
+	case extServerName:
+		names, ok := data.bytes16()
+		if !ok {
+			return errTruncated
+		}
+		for names := parser(names); len(names) > 0; {
+			nameType, ok1 := names.uint8()
+			name, ok2 := names.bytes16()
+			if !ok1 || !ok2 {
+				return errTruncated
+			}
+			if nameType == 0 {
+				h.ServerName = string(name)
+			}
+		}
+	case extALPN:
+		protocols, ok := data.bytes16()
+		if !ok {
+			return errTruncated
+		}
+		for protocols := parser(protocols); len(protocols) > 0; {
+			proto, ok := protocols.bytes8()
+			if !ok {
+				return errTruncated
+			}
+			h.ALPN = append(h.ALPN, string(proto))
+		}
+	case extSupportedVersions:
+		versions, ok := data.bytes8()
+		if !ok {
+			return errTruncated
+		}
+		for versions := parser(versions); len(versions) >= 2; {
+			v, _ := versions.uint16()
+			if !isGREASE(v) && v > h.Version {
+				h.Version = v
+			}
+		}
+	}
+	return nil
+}
+
+// isGREASE reports whether v is one of the reserved values clients send to
+// keep servers tolerant of unknown values (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// parser reads big-endian fields off the front of a byte slice.
+type parser []byte
+
+func (p *parser) take(n int) ([]byte, bool) {
+	if len(*p) < n {
+		return nil, false
+	}
+	b := (*p)[:n]
+	*p = (*p)[n:]
+	return b, true
+}
+
+func (p *parser) uint8() (uint8, bool) {
+	b, ok := p.take(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (p *parser) uint16() (uint16, bool) {
+	b, ok := p.take(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func (p *parser) bytes8() ([]byte, bool) {
+	n, ok := p.uint8()
+	if !ok {
+		return nil, false
+	}
+	return p.take(int(n))
+}
+
+func (p *parser) bytes16() ([]byte, bool) {
+	n, ok := p.uint16()
+	if !ok {
+		return nil, false
+	}
+	return p.take(int(n))
+}
+
+func (p *parser) bytes24() ([]byte, bool) {
+	b, ok := p.take(3)
+	if !ok {
+		return nil, false
+	}
+	return p.take(int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+}
@@ -0,0 +1,142 @@
+package clienthello
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// captureClientHello starts a real TLS handshake with config and returns a
+// reader positioned at the first byte the client sent.
+func captureClientHello(t *testing.T, config *tls.Config) *bufio.Reader {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	go tls.Client(clientConn, config).Handshake()
+
+	serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return bufio.NewReaderSize(serverConn, BufferSize)
+}
+
+func TestPeek(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *tls.Config
+		wantSNI     string
+		wantALPN    []string
+		wantVersion uint16
+	}{
+		{
+			name: "TLS 1.3 with SNI and ALPN",
+			config: &tls.Config{
+				ServerName: "api.example.com",
+				NextProtos: []string{"h2", "http/1.1"},
+			},
+			wantSNI:     "api.example.com",
+			wantALPN:    []string{"h2", "http/1.1"},
+			wantVersion: tls.VersionTLS13,
+		},
+		{
+			name: "TLS 1.2 only",
+			config: &tls.Config{
+				ServerName: "legacy.example.com",
+				MaxVersion: tls.VersionTLS12,
+			},
+			wantSNI:     "legacy.example.com",
+			wantVersion: tls.VersionTLS12,
+		},
+		{
+			name: "no SNI",
+			config: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			wantVersion: tls.VersionTLS13,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := captureClientHello(t, tt.config)
+
+			hello, err := Peek(r)
+			if err != nil {
+				t.Fatalf("Peek() error = %v", err)
+			}
+
+			if hello.ServerName != tt.wantSNI {
+				t.Errorf("ServerName = %q, want %q", hello.ServerName, tt.wantSNI)
+			}
+			if !slices.Equal(hello.ALPN, tt.wantALPN) {
+				t.Errorf("ALPN = %v, want %v", hello.ALPN, tt.wantALPN)
+			}
+			if hello.Version != tt.wantVersion {
+				t.Errorf("Version = %s, want %s", hello.VersionName(), tls.VersionName(tt.wantVersion))
+			}
+			if len(hello.CipherSuites) == 0 {
+				t.Error("CipherSuites is empty, want offered suites")
+			}
+
+			// Peek must not consume anything.
+			header := make([]byte, recordHeaderLen)
+			if _, err := io.ReadFull(r, header); err != nil || header[0] != recordTypeHandshake {
+				t.Errorf("stream after Peek starts with %x (err %v), want the handshake record", header, err)
+			}
+		})
+	}
+}
+
+func TestPeek_NotTLS(t *testing.T) {
+	r := bufio.NewReaderSize(bytes.NewReader([]byte("GET / HTTP/1.1\r\n\r\n")), BufferSize)
+
+	if _, err := Peek(r); !errors.Is(err, ErrNotTLS) {
+		t.Errorf("Peek() error = %v, want ErrNotTLS", err)
+	}
+}
+
+func TestParse_Truncated(t *testing.T) {
+	tests := []struct {
+		name      string
+		handshake []byte
+	}{
+		{name: "empty", handshake: nil},
+		{name: "header only", handshake: []byte{handshakeTypeHello, 0, 0, 10}},
+		{name: "short body", handshake: []byte{handshakeTypeHello, 0, 0, 2, 0x03, 0x03}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.handshake); err == nil {
+				t.Error("Parse() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestIsGREASE(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  bool
+	}{
+		{0x0a0a, true},
+		{0xfafa, true},
+		{0x1a2a, false},
+		{tls.TLS_AES_128_GCM_SHA256, false},
+	}
+
+	for _, tt := range tests {
+		if got := isGREASE(tt.value); got != tt.want {
+			t.Errorf("isGREASE(%#04x) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
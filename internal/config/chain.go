@@ -0,0 +1,71 @@
+package config
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ListenHost is the address routes listen on unless they set listenAddress.
+const ListenHost = "127.0.0.1"
+
+// ChainedRoute returns the index of the route whose listener route's
+// upstream points at, or -1 when the upstream is outside this config. A UDP
+// route only chains to another UDP route, and a TCP route to a TCP one.
+func ChainedRoute(routes []RouteConfig, route RouteConfig) int {
+	host, portText, err := net.SplitHostPort(route.Upstream)
+	if err != nil {
+		return -1
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port == 0 {
+		return -1
+	}
+	for i, r := range routes {
+		if r.LocalPort == port && r.IsUDP() == route.IsUDP() && listensOn(r, host) {
+			return i
+		}
+	}
+	return -1
+}
+
+// listensOn reports whether connections to host reach route's listener. A
+// route listening on every address is reached through loopback too.
+func listensOn(route RouteConfig, host string) bool {
+	if host == route.ListenHost() {
+		return true
+	}
+	listen, err := netip.ParseAddr(route.ListenHost())
+	if err != nil || !listen.IsUnspecified() {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// findChainLoop returns the ports of the first chain of routes that leads
+// back to a route already on it, such as 8080 -> 8081 -> 8080, or nil.
+func findChainLoop(routes []RouteConfig) []int {
+	for start := range routes {
+		seen := make(map[int]bool)
+		var ports []int
+		for i := start; i >= 0; i = ChainedRoute(routes, routes[i]) {
+			ports = append(ports, routes[i].LocalPort)
+			if seen[i] {
+				return ports
+			}
+			seen[i] = true
+		}
+	}
+	return nil
+}
+
+// formatChain renders ports as "8080 -> 8081 -> 8080".
+func formatChain(ports []int) string {
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+	return strings.Join(parts, " -> ")
+}
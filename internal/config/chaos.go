@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// errInvalidChaos is returned for a "chaos" section that doesn't map onto
+// route settings.
+var errInvalidChaos = errors.New("invalid chaos section")
+
+// chaosSections maps each sub-object of a route's "chaos" section, and the
+// settings it takes, to the flat route settings they stand for.
+var chaosSections = map[string]map[string]string{
+	"drop": {
+		"rate":       "dropRate",
+		"mode":       "dropMode",
+		"cooldownMs": "dropCooldownMs",
+	},
+	"latency": {
+		"delayMs":     "latencyMs",
+		"connectMs":   "connectLatencyMs",
+		"firstByteMs": "firstByteLatencyMs",
+		"load":        "loadLatency",
+	},
+	"dialFailure": {
+		"rate": "dialFailureRate",
+	},
+	"flap": {
+		"intervalMs": "flapIntervalMs",
+		"downtimeMs": "flapDowntimeMs",
+	},
+	"connection": {
+		"maxLifetimeMs": "maxConnectionLifetimeMs",
+		"idleTimeoutMs": "idleTimeoutMs",
+		"idleReleaseMs": "idleReleaseMs",
+		"finDelayMs":    "finDelayMs",
+	},
+	"reorder": {
+		"rate":   "reorderRate",
+		"window": "reorderWindow",
+	},
+	"duplicate": {
+		"rate": "duplicateRate",
+	},
+	"coalesce": {
+		"ms":    "coalesceMs",
+		"bytes": "coalesceBytes",
+	},
+}
+
+// chaosSettings maps the settings of a route's "chaos" section that are
+// taken as they are to the flat route settings they stand for.
+var chaosSettings = map[string]string{
+	"burstLoss":    "burstLoss",
+	"toxics":       "toxics",
+	"tarpit":       "tarpit",
+	"bandwidthCap": "bandwidthCap",
+	"script":       "script",
+	"key":          "chaosKey",
+	"clients":      "chaosClients",
+}
+
+// flattenChaos rewrites the "chaos" section of every route, of the defaults
+// and of every profile in a config into the flat settings the rest of the
+// config is decoded from. Objects without a "chaos" section are left as
+// they are, so the flat form keeps working.
+func flattenChaos(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return flattenRoutes(data)
+	}
+
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		// Left for the strict decode to report.
+		return data, nil
+	}
+	changed := false
+	if raw, ok := file["routes"]; ok {
+		routes, err := flattenRoutes(raw)
+		if err != nil {
+			return nil, err
+		}
+		file["routes"] = routes
+		changed = true
+	}
+	if raw, ok := file["defaults"]; ok {
+		defaults, err := flattenChaosSection(raw, "defaults")
+		if err != nil {
+			return nil, err
+		}
+		file["defaults"] = defaults
+		changed = true
+	}
+	if raw, ok := file["profiles"]; ok {
+		var profiles map[string]json.RawMessage
+		if json.Unmarshal(raw, &profiles) == nil {
+			for name, profile := range profiles {
+				flat, err := flattenChaosSection(profile, fmt.Sprintf("profile %q", name))
+				if err != nil {
+					return nil, err
+				}
+				profiles[name] = flat
+			}
+			flat, err := json.Marshal(profiles)
+			if err != nil {
+				return nil, err
+			}
+			file["profiles"] = flat
+			changed = true
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(file)
+}
+
+func flattenRoutes(data []byte) ([]byte, error) {
+	var routes []json.RawMessage
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return data, nil
+	}
+	for i, route := range routes {
+		flat, err := flattenChaosSection(route, fmt.Sprintf("route %d", i))
+		if err != nil {
+			return nil, err
+		}
+		routes[i] = flat
+	}
+	return json.Marshal(routes)
+}
+
+// flattenChaosSection replaces the "chaos" section of the object in data
+// with the flat settings it stands for. Setting the same thing both ways is
+// an error, since neither would obviously win.
+func flattenChaosSection(data json.RawMessage, where string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data, nil
+	}
+	raw, ok := fields["chaos"]
+	if !ok {
+		return data, nil
+	}
+	delete(fields, "chaos")
+
+	var chaos map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &chaos); err != nil {
+		return nil, fmt.Errorf("%w: %s: chaos must be an object", errInvalidChaos, where)
+	}
+	set := func(path, key string, value json.RawMessage) error {
+		if _, ok := fields[key]; ok {
+			return fmt.Errorf("%w: %s: chaos.%s and %s set the same thing; keep one of them", errInvalidChaos, where, path, key)
+		}
+		fields[key] = value
+		return nil
+	}
+	for name, value := range chaos {
+		if key, ok := chaosSettings[name]; ok {
+			if err := set(name, key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		settings, ok := chaosSections[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s: unknown chaos setting %q, want one of %s", errInvalidChaos, where, name, strings.Join(chaosNames(), ", "))
+		}
+		var section map[string]json.RawMessage
+		if err := json.Unmarshal(value, &section); err != nil {
+			return nil, fmt.Errorf("%w: %s: chaos.%s must be an object", errInvalidChaos, where, name)
+		}
+		for setting, value := range section {
+			key, ok := settings[setting]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s: unknown setting %q in chaos.%s, want one of %s", errInvalidChaos, where, setting, name, strings.Join(slices.Sorted(maps.Keys(settings)), ", "))
+			}
+			if err := set(name+"."+setting, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// chaosNames lists the settings a "chaos" section takes.
+func chaosNames() []string {
+	names := slices.Collect(maps.Keys(chaosSections))
+	names = slices.AppendSeq(names, maps.Keys(chaosSettings))
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig_ChaosSection(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileContent string
+		wantErr     bool
+		errContains string
+		want        []RouteConfig
+	}{
+		{
+			name: "nested form",
+			fileContent: `[{
+				"localPort": 8080,
+				"upstream": "127.0.0.1:9090",
+				"chaos": {
+					"drop": {"rate": 0.1, "cooldownMs": "2s"},
+					"latency": {"delayMs": 100, "connectMs": 50, "load": [{"connections": 10, "latencyMs": 200}]},
+					"dialFailure": {"rate": 0.05},
+					"flap": {"intervalMs": 10000, "downtimeMs": 1000},
+					"connection": {"maxLifetimeMs": 60000, "idleTimeoutMs": 30000},
+					"reorder": {"rate": 0.1, "window": 4},
+					"key": "clientIP",
+					"clients": ["10.0.0.0/8"],
+					"toxics": [{"type": "latency", "latencyMs": 20}]
+				}
+			}]`,
+			want: []RouteConfig{{
+				LocalPort:               8080,
+				Upstream:                "127.0.0.1:9090",
+				DropRate:                0.1,
+				DropCooldownMs:          2000,
+				LatencyMs:               100,
+				ConnectLatencyMs:        50,
+				LoadLatency:             []LoadPoint{{Connections: 10, LatencyMs: 200}},
+				DialFailureRate:         0.05,
+				FlapIntervalMs:          10000,
+				FlapDowntimeMs:          1000,
+				MaxConnectionLifetimeMs: 60000,
+				IdleTimeoutMs:           30000,
+				ReorderRate:             0.1,
+				ReorderWindow:           4,
+				ChaosKey:                "clientIP",
+				ChaosClients:            []string{"10.0.0.0/8"},
+				Toxics:                  []ToxicConfig{{Type: ToxicLatency, LatencyMs: 20}},
+			}},
+		},
+		{
+			name: "mixed with flat settings",
+			fileContent: `[
+				{"localPort": 8080, "upstream": "127.0.0.1:9090", "dropRate": 0.1, "chaos": {"latency": {"delayMs": 100}}},
+				{"localPort": 8081, "upstream": "127.0.0.1:9091", "latencyMs": 100}
+			]`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", DropRate: 0.1, LatencyMs: 100},
+				{LocalPort: 8081, Upstream: "127.0.0.1:9091", LatencyMs: 100},
+			},
+		},
+		{
+			name: "defaults and profiles",
+			fileContent: `{
+				"profiles": {"slow": {"chaos": {"latency": {"delayMs": 250}}}},
+				"defaults": {"chaos": {"drop": {"rate": 0.1}, "latency": {"delayMs": 100}}},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"drop": {"rate": 0}}},
+					{"localPort": 8081, "upstream": "127.0.0.1:9091", "profile": "slow"}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", LatencyMs: 100},
+				{LocalPort: 8081, Upstream: "127.0.0.1:9091", Profile: "slow", DropRate: 0.1, LatencyMs: 250},
+			},
+		},
+		{
+			name:        "set both ways",
+			fileContent: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "dropRate": 0.1, "chaos": {"drop": {"rate": 0.2}}}]`,
+			wantErr:     true,
+			errContains: "chaos.drop.rate and dropRate",
+		},
+		{
+			name:        "unknown section",
+			fileContent: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"jitter": {"ms": 10}}}]`,
+			wantErr:     true,
+			errContains: `unknown chaos setting "jitter"`,
+		},
+		{
+			name:        "unknown setting",
+			fileContent: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"drop": {"probability": 0.1}}}]`,
+			wantErr:     true,
+			errContains: `unknown setting "probability" in chaos.drop`,
+		},
+		{
+			name:        "section not an object",
+			fileContent: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"drop": 0.1}}]`,
+			wantErr:     true,
+			errContains: "chaos.drop must be an object",
+		},
+		{
+			name:        "invalid value",
+			fileContent: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"drop": {"rate": 1.5}}}]`,
+			wantErr:     true,
+			errContains: "validation failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tt.fileContent), 0644); err != nil {
+				t.Fatalf("failed to write test config file: %v", err)
+			}
+
+			routes, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !contains(err.Error(), tt.errContains) {
+					t.Errorf("LoadConfig() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if !reflect.DeepEqual(routes, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+}
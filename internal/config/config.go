@@ -1,143 +1,2367 @@
 package config
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"net/http"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/script"
 )
 
 type RouteConfig struct {
-	LocalPort int     `json:"localPort"`
-	Upstream  string  `json:"upstream"`
-	DropRate  float64 `json:"dropRate"`
-	LatencyMs int     `json:"latencyMs"`
+	// Name identifies the route in logs, events, reports and the admin API
+	// instead of just its port. Unique across the config file.
+	Name string `json:"name,omitempty"`
+	// Tags are free-form labels carried along with the name, such as the
+	// team, environment or experiment the route belongs to.
+	Tags []string `json:"tags,omitempty"`
+
+	Tenant    string `json:"tenant,omitempty"`
+	LocalPort int    `json:"localPort"`
+	Upstream  string `json:"upstream"`
+	// FallbackUpstream takes a connection when its dial of Upstream fails,
+	// real or injected, once FallbackAfterFailures dials in a row have
+	// failed (1 by default). The count starts over when a dial of Upstream
+	// succeeds.
+	FallbackUpstream      string       `json:"fallbackUpstream,omitempty"`
+	FallbackAfterFailures int          `json:"fallbackAfterFailures,omitempty"`
+	DropRate              float64      `json:"dropRate"`
+	LatencyMs             Milliseconds `json:"latencyMs"`
+	// ListenAddress is the IP address the route listens on, ListenHost by
+	// default. "0.0.0.0" or "::" accepts connections from other machines.
+	ListenAddress string `json:"listenAddress,omitempty"`
+	// Protocol is what the route listens for and relays: TCP connections
+	// (the default) or UDP datagrams, where chaos is rolled per packet.
+	Protocol string `json:"protocol,omitempty"`
+	// Mode selects how the route understands its traffic: opaque TCP (the
+	// default), HTTP/1.x, where chaos is rolled per request, HTTP/2 and
+	// gRPC, where it is rolled per stream, or DNS, Postgres and MySQL,
+	// where it is rolled per query. In socks5 mode the route is a SOCKS5
+	// proxy instead, and in transparent mode it serves connections
+	// redirected to it by the kernel; neither has an upstream of its own.
+	Mode string `json:"mode,omitempty"`
+	// PortRules give a transparent route's connections other chaos by the
+	// port they were originally headed to.
+	PortRules []PortRule `json:"portRules,omitempty"`
+	// HTTP configures request-level chaos for routes in http mode.
+	HTTP *HTTPConfig `json:"http,omitempty"`
+	// HTTP2 configures stream-level chaos for routes in http2 mode.
+	HTTP2 *HTTP2Config `json:"http2,omitempty"`
+	// GRPC configures RPC-level chaos for routes in grpc mode.
+	GRPC *GRPCConfig `json:"grpc,omitempty"`
+	// DNS configures query-level chaos for routes in dns mode.
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// Database configures query-level chaos for routes in postgres and
+	// mysql modes.
+	Database *DatabaseConfig `json:"database,omitempty"`
+	// Stub answers the route's connections with a canned response instead
+	// of dialling an upstream, which the route then doesn't have.
+	Stub *StubConfig `json:"stub,omitempty"`
+	// Record writes each connection's traffic to a file, so it can be
+	// replayed later without the upstream.
+	Record *RecordConfig `json:"record,omitempty"`
+	// Replay answers the route's connections with recorded traffic instead
+	// of dialling an upstream, which the route then doesn't have.
+	Replay *ReplayConfig `json:"replay,omitempty"`
+	// Enabled, when false, starts the route with its port bound but every
+	// connection reset, until it is switched on through the admin API.
+	Enabled *bool `json:"enabled,omitempty"`
+	// DialFailureRate is the chance a connection is closed without dialling
+	// the upstream at all, as if the upstream were down. It is rolled
+	// independently of dropRate.
+	DialFailureRate float64 `json:"dialFailureRate,omitempty"`
+	// ConnectLatencyMs delays the dial to the upstream, separately from the
+	// data-path latency above.
+	ConnectLatencyMs Milliseconds `json:"connectLatencyMs,omitempty"`
+	// FirstByteLatencyMs holds back the first byte of the upstream's
+	// response, like a server slow to start answering on a fast network.
+	FirstByteLatencyMs Milliseconds `json:"firstByteLatencyMs,omitempty"`
+	// FinDelayMs holds a close from one side this long before closing the
+	// other, like a peer slow to acknowledge that a connection is gone.
+	FinDelayMs Milliseconds `json:"finDelayMs,omitempty"`
+	// DropMode selects how dropRate is applied: a random roll per connection
+	// or an exact count.
+	DropMode string `json:"dropMode,omitempty"`
+	// DropCooldownMs makes dropRate fall to zero after each drop and recover
+	// linearly over this many milliseconds.
+	DropCooldownMs Milliseconds `json:"dropCooldownMs,omitempty"`
+
+	FlapIntervalMs Milliseconds `json:"flapIntervalMs,omitempty"`
+	FlapDowntimeMs Milliseconds `json:"flapDowntimeMs,omitempty"`
+
+	// MaxConnectionLifetimeMs resets connections that stay open longer than
+	// this, like a NAT or load balancer timing them out.
+	MaxConnectionLifetimeMs Milliseconds `json:"maxConnectionLifetimeMs,omitempty"`
+
+	// MaxConnections caps the connections the route serves at once, 0 for
+	// no limit. A low one is a fault of its own, like an upstream whose
+	// connection pool is exhausted.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// Overflow is what happens to connections over maxConnections: closed
+	// (OverflowReject, the default), reset (OverflowReset) or held until
+	// one of the others closes (OverflowQueue).
+	Overflow string `json:"overflow,omitempty"`
+	// QueueTimeoutMs rejects a queued connection still waiting after this
+	// long. 0 waits as long as it takes.
+	QueueTimeoutMs Milliseconds `json:"queueTimeoutMs,omitempty"`
+	// MaxConnectionsPerSecond caps how fast the route takes new
+	// connections, 0 for no limit, like an upstream throttling SYNs under
+	// load. Up to a second's worth may arrive at once.
+	MaxConnectionsPerSecond float64 `json:"maxConnectionsPerSecond,omitempty"`
+	// AcceptThrottle is what happens to connections over
+	// maxConnectionsPerSecond: held until the rate allows them
+	// (AcceptThrottleDelay, the default) or closed (AcceptThrottleReject).
+	AcceptThrottle string `json:"acceptThrottle,omitempty"`
+
+	// IdleTimeoutMs silently drops connections with no traffic in either
+	// direction for this long; the next bytes sent get a reset.
+	// IdleReleaseMs is how long a dropped connection is blackholed before
+	// the proxy gives up waiting for those bytes and releases it, resetting
+	// both sides (default DefaultIdleReleaseMs).
+	IdleTimeoutMs Milliseconds `json:"idleTimeoutMs,omitempty"`
+	IdleReleaseMs Milliseconds `json:"idleReleaseMs,omitempty"`
+
+	// ReadTimeoutMs closes a connection when a read from either side waits
+	// longer than this, and WriteTimeoutMs when a write to either side
+	// does, so a peer that vanished or stopped reading doesn't hold the
+	// connection open forever. Unlike idleTimeoutMs they are not chaos: the
+	// proxy closes both sides. 0 disables them.
+	ReadTimeoutMs  Milliseconds `json:"readTimeoutMs,omitempty"`
+	WriteTimeoutMs Milliseconds `json:"writeTimeoutMs,omitempty"`
+
+	// DialTimeoutMs gives up on a dial of the upstream that hasn't
+	// connected after this long, instead of waiting out the kernel's
+	// connect timeout. DialRetries dials again up to that many times when
+	// a dial fails, waiting DialBackoffMs before the first retry and twice
+	// as long before each one after. Like the read and write timeouts they
+	// are not chaos; dialFailureRate's injected failures aren't retried.
+	DialTimeoutMs Milliseconds `json:"dialTimeoutMs,omitempty"`
+	DialRetries   int          `json:"dialRetries,omitempty"`
+	DialBackoffMs Milliseconds `json:"dialBackoffMs,omitempty"`
+
+	// LoadLatency delays responses by an amount that grows with the number
+	// of connections open on the route, like an upstream slowing under load.
+	LoadLatency []LoadPoint `json:"loadLatency,omitempty"`
+
+	ReorderRate   float64 `json:"reorderRate,omitempty"`
+	ReorderWindow int     `json:"reorderWindow,omitempty"`
+
+	DuplicateRate float64 `json:"duplicateRate,omitempty"`
+
+	// CoalesceMs buffers writes and flushes them in bursts this often.
+	CoalesceMs    Milliseconds `json:"coalesceMs,omitempty"`
+	CoalesceBytes int          `json:"coalesceBytes,omitempty"`
+
+	// Profile pulls in a named set of chaos settings, built in or defined
+	// under "profiles" in the config file.
+	Profile string `json:"profile,omitempty"`
+
+	// Toxics is an ordered pipeline of stream effects applied after the
+	// reorder, coalesce and duplicate options above.
+	Toxics []ToxicConfig `json:"toxics,omitempty"`
+
+	// ChaosKey makes the per-connection chaos decision deterministic by
+	// hashing the client address instead of rolling a random number.
+	ChaosKey string `json:"chaosKey,omitempty"`
+	// Seed seeds the route's random chaos rolls, so a run with the same
+	// traffic rolls the same way again. Unset, the route picks one itself.
+	Seed *int64 `json:"seed,omitempty"`
+
+	// BurstLoss replaces dropRate with a Gilbert-Elliott burst loss model.
+	BurstLoss *BurstLossConfig `json:"burstLoss,omitempty"`
+
+	// Tarpit delays or rejects clients that reconnect too quickly.
+	Tarpit *TarpitConfig `json:"tarpit,omitempty"`
+
+	// BandwidthCap caps the combined throughput of all the route's
+	// connections, like a saturated link, where a bandwidth toxic caps
+	// each connection on its own.
+	BandwidthCap *BandwidthCapConfig `json:"bandwidthCap,omitempty"`
+
+	// Script is a file of rules deciding which connections, or requests in
+	// http, http2 and grpc modes, get a fault, on top of the route's other
+	// chaos.
+	Script string `json:"script,omitempty"`
+
+	// Expect declares traffic assertions checked when the proxy shuts down.
+	Expect *Expectations `json:"expect,omitempty"`
+
+	// ChaosClients limits chaos to clients in these CIDR ranges (or single
+	// IPs). Other clients are proxied cleanly. Empty means every client.
+	ChaosClients []string `json:"chaosClients,omitempty"`
+
+	// CaptureClientHello parses the TLS ClientHello of every connection and
+	// logs its SNI, ALPN, version and cipher suites. The bytes are forwarded
+	// untouched.
+	CaptureClientHello bool `json:"captureClientHello,omitempty"`
+
+	// TLS terminates client TLS on the listener, so chaos and toxics act on
+	// the plaintext instead of breaking TLS records.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// UpstreamTLS dials the upstream over TLS, re-encrypting what the route
+	// forwards.
+	UpstreamTLS *UpstreamTLSConfig `json:"upstreamTLS,omitempty"`
+
+	// ProxyProtocol reads PROXY protocol headers from a load balancer in
+	// front of the route and sends them to the upstream, so both see the
+	// real client address.
+	ProxyProtocol *ProxyProtocolConfig `json:"proxyProtocol,omitempty"`
+
+	// PayloadLog logs the first bytes of each connection in both directions,
+	// with sensitive matches redacted.
+	PayloadLog *PayloadLogConfig `json:"payloadLog,omitempty"`
+
+	// HandoffSocket, when set, also accepts already-established connections
+	// passed over this Unix socket (SCM_RIGHTS), so another process can
+	// delegate a connection without a second TCP hop.
+	HandoffSocket string `json:"handoffSocket,omitempty"`
+
+	// AcceptListeners binds the route's port this many times with
+	// SO_REUSEPORT, each listener with its own accept loop, so the kernel
+	// spreads new connections across them and accepting scales past one
+	// core. 0 or 1 is a single listener. Linux only.
+	AcceptListeners int `json:"acceptListeners,omitempty"`
+
+	// BufferBytes sizes the buffers the route's connections are copied
+	// through, DefaultBufferBytes if 0. Smaller buffers save memory across
+	// thousands of connections; larger ones suit bulk transfers.
+	BufferBytes int `json:"bufferBytes,omitempty"`
+
+	// MaxBufferedBytes caps the bytes a toxic that holds data back,
+	// coalescing or reordering, holds for one direction of a connection,
+	// DefaultMaxBufferedBytes if 0. Past it the data is sent on, so a
+	// flood into a stalled peer blocks its sender instead of filling memory.
+	MaxBufferedBytes int `json:"maxBufferedBytes,omitempty"`
+
+	// BaselinePort, when set, runs a second chaos-free listener to the same
+	// upstream so cursed and clean traffic can be compared side by side.
+	BaselinePort int `json:"baselinePort,omitempty"`
+}
+
+// MaxDialRetries bounds RouteConfig.DialRetries.
+const MaxDialRetries = 10
+
+// Bounds and default of RouteConfig.BufferBytes. The default is io.Copy's.
+const (
+	DefaultBufferBytes = 32 << 10
+	MinBufferBytes     = 512
+	MaxBufferBytes     = 16 << 20
+)
+
+// DefaultMaxBufferedBytes is RouteConfig.MaxBufferedBytes's default.
+const DefaultMaxBufferedBytes = 1 << 20
+
+// MaxBufferedBytesOrDefault is the most a toxic of the route holds back for
+// one direction of a connection.
+func (r RouteConfig) MaxBufferedBytesOrDefault() int {
+	if r.MaxBufferedBytes == 0 {
+		return DefaultMaxBufferedBytes
+	}
+	return r.MaxBufferedBytes
+}
+
+// DefaultIdleReleaseMs is RouteConfig.IdleReleaseMs's default.
+const DefaultIdleReleaseMs = 5 * 60 * 1000
+
+// IdleReleaseOrDefault is how long an idle-dropped connection of the route
+// is blackholed before it is released.
+func (r RouteConfig) IdleReleaseOrDefault() Milliseconds {
+	if r.IdleReleaseMs == 0 {
+		return DefaultIdleReleaseMs
+	}
+	return r.IdleReleaseMs
+}
+
+// MaxAcceptListeners bounds RouteConfig.AcceptListeners.
+const MaxAcceptListeners = 256
+
+// BufferBytesOrDefault is the size of the route's copy buffers.
+func (r RouteConfig) BufferBytesOrDefault() int {
+	if r.BufferBytes == 0 {
+		return DefaultBufferBytes
+	}
+	return r.BufferBytes
+}
+
+// maxSocketPathLen is the smallest sun_path limit across supported platforms
+// (macOS and the BSDs; Linux allows 108).
+const maxSocketPathLen = 104
+
+// Supported Protocol values.
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// Supported Mode values.
+const (
+	ModeTCP         = "tcp"
+	ModeHTTP        = "http"
+	ModeHTTP2       = "http2"
+	ModeGRPC        = "grpc"
+	ModeDNS         = "dns"
+	ModePostgres    = "postgres"
+	ModeMySQL       = "mysql"
+	ModeSOCKS5      = "socks5"
+	ModeTransparent = "transparent"
+)
+
+// Supported DropMode values.
+const (
+	DropModeRandom = "random"
+	DropModeExact  = "exact"
+)
+
+// Supported Overflow values.
+const (
+	OverflowReject = "reject"
+	OverflowReset  = "reset"
+	OverflowQueue  = "queue"
+)
+
+// Supported AcceptThrottle values.
+const (
+	AcceptThrottleDelay  = "delay"
+	AcceptThrottleReject = "reject"
+)
+
+// Supported ChaosKey values.
+const (
+	ChaosKeyRandom     = "random"
+	ChaosKeyClientIP   = "clientIP"
+	ChaosKeyClientAddr = "clientAddr"
+)
+
+// Supported ToxicConfig.Type values.
+const (
+	ToxicLatency   = "latency"
+	ToxicBandwidth = "bandwidth"
+	ToxicCorrupt   = "corrupt"
+	ToxicTruncate  = "truncate"
+	ToxicDrop      = "drop"
+	ToxicSegment   = "segment"
+)
+
+// Default write sizes for the segment toxic.
+const (
+	DefaultSegmentMinBytes = 1
+	DefaultSegmentMaxBytes = 16
+)
+
+// Supported ToxicConfig.Stream values. Empty means both directions.
+const (
+	StreamBoth       = "both"
+	StreamDownstream = "downstream"
+	StreamUpstream   = "upstream"
+)
+
+// ToxicConfig is one stage of a route's toxic pipeline. Which settings apply
+// depends on Type:
+//
+//   - latency: latencyMs and jitterMs per chunk
+//   - bandwidth: bytesPerSecond
+//   - corrupt: rate, the chance each byte has a bit flipped
+//   - truncate: bytes forwarded before the connection is severed
+//   - drop: rate, the chance each chunk is silently discarded
+//   - segment: minBytes to maxBytes per write, pausing latencyMs between them
+type ToxicConfig struct {
+	// Name identifies the toxic among its route's, for the Toxiproxy API.
+	// Optional.
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Stream is "downstream" (to the client), "upstream" (to the server) or
+	// "both" (the default).
+	Stream string `json:"stream,omitempty"`
+	// Toxicity is the chance the toxic applies to a connection (default 1.0).
+	Toxicity *float64 `json:"toxicity,omitempty"`
+
+	LatencyMs      Milliseconds `json:"latencyMs,omitempty"`
+	JitterMs       Milliseconds `json:"jitterMs,omitempty"`
+	BytesPerSecond int64        `json:"bytesPerSecond,omitempty"`
+	Rate           float64      `json:"rate,omitempty"`
+	Bytes          int64        `json:"bytes,omitempty"`
+	MinBytes       int          `json:"minBytes,omitempty"`
+	MaxBytes       int          `json:"maxBytes,omitempty"`
+}
+
+// SegmentBytes returns the segment toxic's write size range with defaults
+// applied.
+func (t ToxicConfig) SegmentBytes() (minBytes, maxBytes int) {
+	minBytes, maxBytes = t.MinBytes, t.MaxBytes
+	if minBytes == 0 {
+		minBytes = DefaultSegmentMinBytes
+	}
+	if maxBytes == 0 {
+		maxBytes = max(DefaultSegmentMaxBytes, minBytes)
+	}
+	return minBytes, maxBytes
+}
+
+// ToxicityOrDefault returns Toxicity, defaulting to 1.0.
+func (t ToxicConfig) ToxicityOrDefault() float64 {
+	if t.Toxicity == nil {
+		return 1.0
+	}
+	return *t.Toxicity
+}
+
+// BurstLossConfig describes a two-state (good/bad) loss model, similar to
+// netem's gemodel. The state may change once per connection.
+type BurstLossConfig struct {
+	GoodToBad    float64  `json:"goodToBad"`
+	BadToGood    float64  `json:"badToGood"`
+	GoodDropRate float64  `json:"goodDropRate,omitempty"`
+	BadDropRate  *float64 `json:"badDropRate,omitempty"`
+}
+
+// BadRate returns the drop probability in the bad state, defaulting to 1.0.
+func (b BurstLossConfig) BadRate() float64 {
+	if b.BadDropRate == nil {
+		return 1.0
+	}
+	return *b.BadDropRate
+}
+
+// TarpitConfig punishes clients (by IP) that open more than Threshold
+// connections within WindowMs: each extra connection waits another
+// DelayStepMs (up to MaxDelayMs), and past RejectAfter it is refused.
+type TarpitConfig struct {
+	WindowMs    Milliseconds `json:"windowMs"`
+	Threshold   int          `json:"threshold"`
+	DelayStepMs Milliseconds `json:"delayStepMs"`
+	MaxDelayMs  Milliseconds `json:"maxDelayMs,omitempty"`
+	RejectAfter int          `json:"rejectAfter,omitempty"`
+}
+
+// BandwidthCapConfig caps each direction of a route's traffic, summed over
+// all its connections. 0 leaves a direction uncapped.
+type BandwidthCapConfig struct {
+	// UpstreamBytesPerSecond caps the bytes sent to the upstream, and
+	// DownstreamBytesPerSecond those sent to clients.
+	UpstreamBytesPerSecond   int64 `json:"upstreamBytesPerSecond,omitempty"`
+	DownstreamBytesPerSecond int64 `json:"downstreamBytesPerSecond,omitempty"`
+}
+
+// DefaultHTTPErrorStatus is the status injected HTTP errors use when
+// ErrorStatus is unset.
+const DefaultHTTPErrorStatus = http.StatusServiceUnavailable
+
+// HTTPConfig is request-level chaos for routes in http mode. Drops and
+// latency come from the route's dropRate and latencyMs, applied per request.
+type HTTPConfig struct {
+	// ErrorRate is the chance a request is answered with ErrorStatus by the
+	// proxy instead of being forwarded.
+	ErrorRate   float64 `json:"errorRate,omitempty"`
+	ErrorStatus int     `json:"errorStatus,omitempty"`
+	// Headers rewrite request and response headers on the way through.
+	Headers []HeaderRule `json:"headers,omitempty"`
+	// Body damages response bodies on their way back.
+	Body *HTTPBodyConfig `json:"body,omitempty"`
+	// ClockSkewMs moves the Date, Expires and Last-Modified dates of
+	// responses by this much, earlier when negative, as an upstream whose
+	// clock is off would send them.
+	ClockSkewMs Milliseconds `json:"clockSkewMs,omitempty"`
+	// Match limits the chaos above to the requests it matches; the rest are
+	// forwarded clean.
+	Match *HTTPMatch `json:"match,omitempty"`
+}
+
+// HTTP2Config is stream-level chaos for routes in http2 mode. Drops, which
+// reset a stream before it is forwarded, and latency come from the route's
+// dropRate and latencyMs, applied per stream.
+type HTTP2Config struct {
+	// ResetRate is the chance a stream is reset partway through its
+	// response, after its headers have been sent.
+	ResetRate float64 `json:"resetRate,omitempty"`
+}
+
+// DefaultGRPCErrorCode is the status injected gRPC errors use when
+// ErrorCodes is unset.
+const DefaultGRPCErrorCode = "UNAVAILABLE"
+
+// grpcCodes maps the gRPC status codes an injected error can use to their
+// numbers. OK is left out, as it isn't an error.
+var grpcCodes = map[string]int{
+	"CANCELLED":           1,
+	"UNKNOWN":             2,
+	"INVALID_ARGUMENT":    3,
+	"DEADLINE_EXCEEDED":   4,
+	"NOT_FOUND":           5,
+	"ALREADY_EXISTS":      6,
+	"PERMISSION_DENIED":   7,
+	"RESOURCE_EXHAUSTED":  8,
+	"FAILED_PRECONDITION": 9,
+	"ABORTED":             10,
+	"OUT_OF_RANGE":        11,
+	"UNIMPLEMENTED":       12,
+	"INTERNAL":            13,
+	"UNAVAILABLE":         14,
+	"DATA_LOSS":           15,
+	"UNAUTHENTICATED":     16,
+}
+
+// GRPCCode returns the number of the gRPC status code called name, such as
+// UNAVAILABLE, and whether an injected error can use it.
+func GRPCCode(name string) (int, bool) {
+	code, ok := grpcCodes[name]
+	return code, ok
+}
+
+// GRPCConfig is RPC-level chaos for routes in grpc mode. Drops and latency
+// come from the route's dropRate and latencyMs, applied per RPC.
+type GRPCConfig struct {
+	// ErrorRate is the chance an RPC is answered by the proxy with one of
+	// ErrorCodes instead of being forwarded.
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// ErrorCodes are the status code names injected errors pick from at
+	// random (default UNAVAILABLE).
+	ErrorCodes []string `json:"errorCodes,omitempty"`
+	// MessageDelayMs holds individual messages on a stream, in either
+	// direction.
+	MessageDelayMs Milliseconds `json:"messageDelayMs,omitempty"`
+	// MessageDelayRate is the chance a message is held (default 1.0).
+	MessageDelayRate *float64 `json:"messageDelayRate,omitempty"`
+}
+
+// ErrorCodesOrDefault returns ErrorCodes, or DefaultGRPCErrorCode alone
+// when unset. It is safe to call on a nil config.
+func (g *GRPCConfig) ErrorCodesOrDefault() []string {
+	if g == nil || len(g.ErrorCodes) == 0 {
+		return []string{DefaultGRPCErrorCode}
+	}
+	return g.ErrorCodes
+}
+
+// MessageDelayRateOrDefault returns MessageDelayRate, or 1.0 when unset.
+func (g GRPCConfig) MessageDelayRateOrDefault() float64 {
+	if g.MessageDelayRate == nil {
+		return 1.0
+	}
+	return *g.MessageDelayRate
+}
+
+// DefaultDNSErrorCode is the response code injected DNS errors use when
+// ErrorCodes is unset.
+const DefaultDNSErrorCode = "SERVFAIL"
+
+// dnsRcodes maps the DNS response codes an injected error can use to their
+// numbers. NOERROR is left out, as it isn't an error.
+var dnsRcodes = map[string]int{
+	"FORMERR":  1,
+	"SERVFAIL": 2,
+	"NXDOMAIN": 3,
+	"NOTIMP":   4,
+	"REFUSED":  5,
+}
+
+// DNSRcode returns the number of the DNS response code called name, such as
+// SERVFAIL, and whether an injected error can use it.
+func DNSRcode(name string) (int, bool) {
+	rcode, ok := dnsRcodes[name]
+	return rcode, ok
+}
+
+// maxDNSTTL is the largest TTL a resolver must honour (RFC 2181).
+const maxDNSTTL = 1<<31 - 1
+
+// DNSConfig is query-level chaos for routes in dns mode. Drops and latency
+// come from the route's dropRate and latencyMs.
+type DNSConfig struct {
+	// ErrorRate is the chance a query is answered by the proxy with one of
+	// ErrorCodes instead of being forwarded.
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// ErrorCodes are the response code names injected errors pick from at
+	// random (default SERVFAIL).
+	ErrorCodes []string `json:"errorCodes,omitempty"`
+	// DelayMs holds responses from the upstream, with probability
+	// DelayRate (default 1.0).
+	DelayMs   Milliseconds `json:"delayMs,omitempty"`
+	DelayRate *float64     `json:"delayRate,omitempty"`
+	// TTLRate is the chance every TTL in a response is replaced with TTL
+	// seconds (default 0), so clients cache it too briefly or too long.
+	TTLRate float64 `json:"ttlRate,omitempty"`
+	TTL     int     `json:"ttl,omitempty"`
+}
+
+// ErrorCodesOrDefault returns ErrorCodes, or DefaultDNSErrorCode alone when
+// unset. It is safe to call on a nil config.
+func (d *DNSConfig) ErrorCodesOrDefault() []string {
+	if d == nil || len(d.ErrorCodes) == 0 {
+		return []string{DefaultDNSErrorCode}
+	}
+	return d.ErrorCodes
+}
+
+// DelayRateOrDefault returns DelayRate, or 1.0 when unset.
+func (d DNSConfig) DelayRateOrDefault() float64 {
+	if d.DelayRate == nil {
+		return 1.0
+	}
+	return *d.DelayRate
+}
+
+// DefaultPartialRows is how many rows of a result set get through before
+// it is cut off when PartialRows is unset.
+const DefaultPartialRows = 1
+
+// DatabaseConfig is query-level chaos for routes in postgres and mysql
+// modes. Drops and latency come from the route's dropRate and latencyMs.
+type DatabaseConfig struct {
+	// KillQueryRate is the chance the connection is killed once a query
+	// has reached the upstream, before its result reaches the client.
+	KillQueryRate float64 `json:"killQueryRate,omitempty"`
+	// PartialResultRate is the chance the connection is killed partway
+	// through a result set, after PartialRows rows (default 1) or just
+	// before its end if it has fewer.
+	PartialResultRate float64 `json:"partialResultRate,omitempty"`
+	PartialRows       int     `json:"partialRows,omitempty"`
+}
+
+// PartialRowsOrDefault returns PartialRows, or DefaultPartialRows when
+// unset.
+func (d DatabaseConfig) PartialRowsOrDefault() int {
+	if d.PartialRows == 0 {
+		return DefaultPartialRows
+	}
+	return d.PartialRows
+}
+
+// StubConfig is a canned upstream built into the proxy, so a flaky
+// dependency can be faked without running a server for it. The route's
+// chaos applies between the client and the stub as it would to a real
+// upstream.
+type StubConfig struct {
+	// Body is what the stub answers with: in tcp mode, every chunk of data
+	// the client sends, and in http and http2 modes, every request.
+	Body string `json:"body,omitempty"`
+	// BodyFile reads the body from a file instead, for binary or large
+	// responses.
+	BodyFile string `json:"bodyFile,omitempty"`
+	// Status is the HTTP status code of every response, default 200. Only
+	// for http and http2 modes.
+	Status int `json:"status,omitempty"`
+	// Headers are set on every HTTP response. Only for http and http2
+	// modes.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// DefaultStubStatus is the status a stub answers HTTP requests with when
+// none is configured.
+const DefaultStubStatus = 200
+
+// StatusOrDefault returns Status, or DefaultStubStatus when unset.
+func (s StubConfig) StatusOrDefault() int {
+	if s.Status == 0 {
+		return DefaultStubStatus
+	}
+	return s.Status
+}
+
+// RecordConfig records the bytes of each connection in both directions,
+// as they arrive and before any chaos, to one file per connection in Dir.
+type RecordConfig struct {
+	Dir string `json:"dir"`
+}
+
+// ReplayConfig plays back the recordings in Dir in place of an upstream,
+// one per connection, in file name order and starting over once every one
+// has been used. The route's chaos applies on top, as it would in front of
+// the upstream they were recorded from.
+type ReplayConfig struct {
+	Dir string `json:"dir"`
+	// Timing keeps the pauses the upstream took before each recorded
+	// response, instead of answering as soon as the client has asked.
+	Timing bool `json:"timing,omitempty"`
+}
+
+// RecordingExt is the file extension of recordings.
+const RecordingExt = ".jsonl"
+
+// HTTPBodyConfig damages response bodies while leaving their status and
+// headers intact, so clients fail while reading or decoding them rather
+// than at the transport.
+type HTTPBodyConfig struct {
+	// TruncateRate is the chance a response body is cut off at a random
+	// point, short of its length, and the connection closed.
+	TruncateRate float64 `json:"truncateRate,omitempty"`
+	// CorruptRate is the chance one byte of a JSON response body is
+	// replaced so it no longer parses. The body keeps its length.
+	CorruptRate float64 `json:"corruptRate,omitempty"`
+}
+
+// HTTPMatch selects requests by method, path and headers. A request matches
+// when it meets every condition that is set.
+type HTTPMatch struct {
+	// Methods matches any of the listed methods.
+	Methods    []string `json:"methods,omitempty"`
+	PathPrefix string   `json:"pathPrefix,omitempty"`
+	// PathRegex is matched against the request path, unanchored.
+	PathRegex string `json:"pathRegex,omitempty"`
+	// Headers maps header names to the value they must have. An empty
+	// value only requires the header to be present.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Supported HeaderRule.In values.
+const (
+	HeaderInRequest  = "request"
+	HeaderInResponse = "response"
+)
+
+// Supported HeaderRule.Action values.
+const (
+	HeaderAdd     = "add"
+	HeaderSet     = "set"
+	HeaderRemove  = "remove"
+	HeaderCorrupt = "corrupt"
+)
+
+// HeaderRule changes one header of a fraction of requests or responses.
+type HeaderRule struct {
+	In     string `json:"in"`
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// Value is the value added or set; the other actions take none.
+	Value string `json:"value,omitempty"`
+	// Rate is the chance the rule applies to a given message (default 1.0).
+	Rate *float64 `json:"rate,omitempty"`
+}
+
+// RateOrDefault returns Rate, or 1.0 when unset.
+func (h HeaderRule) RateOrDefault() float64 {
+	if h.Rate == nil {
+		return 1.0
+	}
+	return *h.Rate
+}
+
+// ErrorStatusOrDefault returns ErrorStatus, or DefaultHTTPErrorStatus when
+// unset. It is safe to call on a nil config.
+func (h *HTTPConfig) ErrorStatusOrDefault() int {
+	if h == nil || h.ErrorStatus == 0 {
+		return DefaultHTTPErrorStatus
+	}
+	return h.ErrorStatus
+}
+
+// TLSConfig is the certificate a route presents to clients when it
+// terminates TLS.
+type TLSConfig struct {
+	// CertFile is a PEM certificate chain, leaf first; KeyFile its
+	// private key.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Handshake breaks client handshakes on purpose.
+	Handshake *TLSHandshakeConfig `json:"handshake,omitempty"`
+}
+
+// Bad certificates a route can present in place of its own.
+const (
+	BadCertExpired    = "expired"
+	BadCertSelfSigned = "selfSigned"
+)
+
+// TLSHandshakeConfig is a route's chaos for the client handshakes it
+// terminates. Each handshake rolls it once, after the ClientHello arrives.
+type TLSHandshakeConfig struct {
+	// DelayMs holds back the ServerHello for DelayRate of handshakes
+	// (default 1.0).
+	DelayMs   Milliseconds `json:"delayMs,omitempty"`
+	DelayRate *float64     `json:"delayRate,omitempty"`
+	// AbortRate is the chance the connection is closed without an alert
+	// instead of answering the ClientHello.
+	AbortRate float64 `json:"abortRate,omitempty"`
+	// BadCertRate is the chance the route presents BadCert (default
+	// BadCertSelfSigned) instead of its own certificate.
+	BadCertRate float64 `json:"badCertRate,omitempty"`
+	BadCert     string  `json:"badCert,omitempty"`
+}
+
+// DelayRateOrDefault returns DelayRate, or 1.0 when unset.
+func (h TLSHandshakeConfig) DelayRateOrDefault() float64 {
+	if h.DelayRate == nil {
+		return 1.0
+	}
+	return *h.DelayRate
+}
+
+// BadCertOrDefault returns BadCert, or BadCertSelfSigned when unset.
+func (h TLSHandshakeConfig) BadCertOrDefault() string {
+	if h.BadCert == "" {
+		return BadCertSelfSigned
+	}
+	return h.BadCert
+}
+
+// UpstreamTLSConfig is how a route verifies its upstream, and identifies
+// itself to it, when it dials it over TLS.
+type UpstreamTLSConfig struct {
+	// ServerName is sent as SNI and checked against the upstream's
+	// certificate (default: the upstream's host).
+	ServerName string `json:"serverName,omitempty"`
+	// CAFile is a PEM bundle to verify the upstream against instead of the
+	// system roots.
+	CAFile string `json:"caFile,omitempty"`
+	// InsecureSkipVerify accepts any certificate the upstream presents.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CertFile is a PEM client certificate chain, leaf first, presented to
+	// upstreams that ask for one; KeyFile its private key.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// Supported ProxyProtocolConfig.Send values.
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// ProxyProtocolConfig is how a route handles PROXY protocol headers, which
+// carry a connection's original client address across a TCP proxy.
+type ProxyProtocolConfig struct {
+	// Accept requires every client connection to start with a v1 or v2
+	// header, and treats the address in it as the client's.
+	Accept bool `json:"accept,omitempty"`
+	// Send is the version of the header written to the upstream ahead of
+	// each connection's data: "v1", "v2", or empty for none.
+	Send string `json:"send,omitempty"`
+}
+
+// DefaultPayloadLogBytes is how much of each direction PayloadLog captures
+// when MaxBytes is unset.
+const DefaultPayloadLogBytes = 256
+
+// maxPayloadLogBytes caps MaxBytes; snippets are for reading, not capture.
+const maxPayloadLogBytes = 64 * 1024
+
+// PayloadLogConfig logs up to MaxBytes from the start of each direction of
+// a connection. Every match of a Redact pattern is replaced before logging.
+type PayloadLogConfig struct {
+	MaxBytes int      `json:"maxBytes,omitempty"`
+	Redact   []string `json:"redact,omitempty"`
+}
+
+// MaxBytesOrDefault returns MaxBytes, or DefaultPayloadLogBytes when unset.
+func (p PayloadLogConfig) MaxBytesOrDefault() int {
+	if p.MaxBytes == 0 {
+		return DefaultPayloadLogBytes
+	}
+	return p.MaxBytes
+}
+
+// LoadPoint is one point on a loadLatency curve: with Connections open on
+// the route, responses are delayed by LatencyMs. Latency is interpolated
+// linearly between points and held flat beyond the last one.
+type LoadPoint struct {
+	Connections int          `json:"connections"`
+	LatencyMs   Milliseconds `json:"latencyMs"`
+}
+
+// Expectations are traffic assertions for a route, evaluated at shutdown.
+// Unset fields are not checked.
+type Expectations struct {
+	MinConnections     *int64 `json:"minConnections,omitempty"`
+	MaxConnections     *int64 `json:"maxConnections,omitempty"`
+	MaxFailures        *int64 `json:"maxFailures,omitempty"`
+	MaxConnectionBytes *int64 `json:"maxConnectionBytes,omitempty"`
+}
+
+// IsEnabled reports whether the route starts accepting connections. Routes
+// are enabled unless the config says otherwise.
+func (r RouteConfig) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// WithoutChaos returns a copy of the route with every chaos option cleared.
+// Observation-only options such as CaptureClientHello are kept.
+func (r RouteConfig) WithoutChaos() RouteConfig {
+	clean := RouteConfig{
+		Name:                  r.Name,
+		Tags:                  r.Tags,
+		Tenant:                r.Tenant,
+		LocalPort:             r.LocalPort,
+		ListenAddress:         r.ListenAddress,
+		Upstream:              r.Upstream,
+		FallbackUpstream:      r.FallbackUpstream,
+		FallbackAfterFailures: r.FallbackAfterFailures,
+		Protocol:              r.Protocol,
+		CaptureClientHello:    r.CaptureClientHello,
+		TLS:                   r.TLS,
+		UpstreamTLS:           r.UpstreamTLS,
+		ProxyProtocol:         r.ProxyProtocol,
+		PayloadLog:            r.PayloadLog,
+		Stub:                  r.Stub,
+		Record:                r.Record,
+		Replay:                r.Replay,
+		BufferBytes:           r.BufferBytes,
+		AcceptListeners:       r.AcceptListeners,
+		MaxBufferedBytes:      r.MaxBufferedBytes,
+		ReadTimeoutMs:         r.ReadTimeoutMs,
+		WriteTimeoutMs:        r.WriteTimeoutMs,
+		DialTimeoutMs:         r.DialTimeoutMs,
+		DialRetries:           r.DialRetries,
+		DialBackoffMs:         r.DialBackoffMs,
+	}
+	// A route without an upstream has none without its mode either, and a
+	// stub or replay answers in the protocol of the route's mode.
+	if r.PerConnectionUpstream() || r.Stub != nil || r.Replay != nil {
+		clean.Mode = r.Mode
+	}
+	if r.TLS != nil && r.TLS.Handshake != nil {
+		tls := *r.TLS
+		tls.Handshake = nil
+		clean.TLS = &tls
+	}
+	return clean
+}
+
+// ListenHost is the IP address the route listens on.
+func (r RouteConfig) ListenHost() string {
+	if r.ListenAddress == "" {
+		return ListenHost
+	}
+	return r.ListenAddress
+}
+
+// ListenAddr is the host:port the route listens on.
+func (r RouteConfig) ListenAddr() string {
+	return net.JoinHostPort(r.ListenHost(), strconv.Itoa(r.LocalPort))
+}
+
+// PerConnectionUpstream reports whether the route finds each connection's
+// upstream as it arrives, from a SOCKS5 request or the connection's original
+// destination, instead of having one upstream.
+func (r RouteConfig) PerConnectionUpstream() bool {
+	return r.Mode == ModeSOCKS5 || r.Mode == ModeTransparent
+}
+
+// IsUDP reports whether the route relays UDP datagrams instead of TCP
+// connections.
+func (r RouteConfig) IsUDP() bool {
+	return r.Protocol == ProtocolUDP
+}
+
+// Relisten reports whether moving the route to next's settings takes a new
+// listener rather than new settings for the one it has: a different port,
+// protocol, handoff socket or number of accept listeners, transparent mode
+// switched on or off, or any
+// change to a UDP route, whose sessions hold on to its settings. Only
+// routes that differ need asking.
+func (r RouteConfig) Relisten(next RouteConfig) bool {
+	return r.LocalPort != next.LocalPort ||
+		r.ListenHost() != next.ListenHost() ||
+		r.IsUDP() || next.IsUDP() ||
+		(r.Mode == ModeTransparent) != (next.Mode == ModeTransparent) ||
+		r.HandoffSocket != next.HandoffSocket ||
+		max(r.AcceptListeners, 1) != max(next.AcceptListeners, 1)
+}
+
+// tcpOnlyOptions lists the options set on the route that only make sense
+// for TCP connections.
+func (r RouteConfig) tcpOnlyOptions() []string {
+	var options []string
+	add := func(set bool, option string) {
+		if set {
+			options = append(options, option)
+		}
+	}
+	add(r.Mode != "" && r.Mode != ModeTCP && r.Mode != ModeDNS, "mode")
+	add(r.DialFailureRate > 0, "dialFailureRate")
+	add(r.ConnectLatencyMs > 0, "connectLatencyMs")
+	add(r.FallbackUpstream != "", "fallbackUpstream")
+	add(r.FallbackAfterFailures != 0, "fallbackAfterFailures")
+	add(r.FirstByteLatencyMs > 0, "firstByteLatencyMs")
+	add(r.FinDelayMs > 0, "finDelayMs")
+	add(r.FlapIntervalMs > 0, "flapIntervalMs")
+	add(r.MaxConnectionLifetimeMs > 0, "maxConnectionLifetimeMs")
+	add(r.MaxConnections != 0, "maxConnections")
+	add(r.MaxConnectionsPerSecond != 0, "maxConnectionsPerSecond")
+	add(r.IdleTimeoutMs > 0, "idleTimeoutMs")
+	add(r.IdleReleaseMs > 0, "idleReleaseMs")
+	add(r.ReadTimeoutMs != 0, "readTimeoutMs")
+	add(r.WriteTimeoutMs != 0, "writeTimeoutMs")
+	add(r.DialTimeoutMs != 0, "dialTimeoutMs")
+	add(r.DialRetries != 0, "dialRetries")
+	add(r.DialBackoffMs != 0, "dialBackoffMs")
+	add(len(r.LoadLatency) > 0, "loadLatency")
+	add(r.CoalesceMs > 0, "coalesceMs")
+	add(len(r.Toxics) > 0, "toxics")
+	add(r.Tarpit != nil, "tarpit")
+	add(r.BandwidthCap != nil, "bandwidthCap")
+	add(r.Script != "", "script")
+	add(r.CaptureClientHello, "captureClientHello")
+	add(r.TLS != nil, "tls")
+	add(r.UpstreamTLS != nil, "upstreamTLS")
+	add(r.ProxyProtocol != nil, "proxyProtocol")
+	add(r.PayloadLog != nil, "payloadLog")
+	add(r.HandoffSocket != "", "handoffSocket")
+	add(r.AcceptListeners != 0, "acceptListeners")
+	add(r.BufferBytes != 0, "bufferBytes")
+	add(r.BaselinePort != 0, "baselinePort")
+	add(r.Stub != nil, "stub")
+	add(r.Record != nil, "record")
+	add(r.Replay != nil, "replay")
+	return options
+}
+
+// Baseline returns a copy of the route listening on BaselinePort with every
+// chaos option cleared.
+func (r RouteConfig) Baseline() RouteConfig {
+	baseline := r.WithoutChaos()
+	baseline.LocalPort = r.BaselinePort
+	return baseline
+}
+
+// ChaosPrefixes parses ChaosClients. Entries that fail to parse are skipped;
+// validation rejects them before a route is ever served.
+func (r RouteConfig) ChaosPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(r.ChaosClients))
+	for _, entry := range r.ChaosClients {
+		if prefix, err := parseClientPrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// parseClientPrefix accepts a CIDR ("10.2.0.0/16") or a single IP.
+func parseClientPrefix(entry string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// LoadOptions relaxes validation for callers that can handle it.
+type LoadOptions struct {
+	// AllowAutoPort accepts localPort 0, letting the OS pick a free port.
+	// Only enable this when the bound ports are published somewhere.
+	AllowAutoPort bool
+}
+
+// LoadConfig loads the route configuration from a JSON or JSON5 file,
+// standard input or a URL (see ReadConfig).
+func LoadConfig(configPath string) ([]RouteConfig, error) {
+	return LoadConfigWithOptions(configPath, LoadOptions{})
+}
+
+// LoadConfigWithOptions loads the route configuration from a JSON or JSON5
+// file, standard input or a URL using the given validation options.
+func LoadConfigWithOptions(configPath string, opts LoadOptions) ([]RouteConfig, error) {
+	routes, _, err := loadConfig(configPath, opts)
+	return routes, err
+}
+
+func loadConfig(configPath string, opts LoadOptions) ([]RouteConfig, Access, error) {
+	configLogger := slog.With("file", RedactPath(configPath))
+	routes, access, err := loadFile(configPath, configLogger)
+	if err != nil {
+		return nil, Access{}, err
+	}
+	if err := validateConfig(routes, opts, configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	if err := access.checkTokens(configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	access.warnTenantless(routes, configLogger)
+
+	return routes, access, nil
+}
+
+// loadFile reads and decodes the config at configPath, applying its
+// defaults and profiles, without validating the routes. The admin API
+// tokens it names are read too.
+func loadFile(configPath string, configLogger *slog.Logger) ([]RouteConfig, Access, error) {
+	source := RedactPath(configPath)
+	data, err := ReadConfig(configPath)
+	if err != nil {
+		hint := "check that the file exists and you have read permissions"
+		if IsURL(configPath) {
+			hint = "check that the URL is reachable and answers with the config, with credentials as userinfo if it needs them"
+		}
+		configLogger.Error("failed to open config file", "error", err, "hint", hint)
+		return nil, Access{}, fmt.Errorf("cannot open config file %q: %w", source, err)
+	}
+
+	var file fileConfig
+	if err := file.decode(data); errors.Is(err, errInvalidChaos) {
+		configLogger.Error("invalid chaos section in config file", "error", err, "hint", "the error names the setting at fault; a setting may be written under chaos or flat on the route, but not both")
+		return nil, Access{}, fmt.Errorf("invalid chaos section in config file %q: %w", source, err)
+	} else if errors.Is(err, errInvalidPortRange) {
+		configLogger.Error("invalid port range in config file", "error", err, "hint", fmt.Sprintf("a localPort range such as \"8000-8015\" expands into one route per port; use %s in upstream for each route's own upstream port", upstreamPortPlaceholder))
+		return nil, Access{}, fmt.Errorf("invalid port range in config file %q: %w", source, err)
+	} else if err != nil {
+		configLogger.Error("invalid JSON in config file", "error", err, "hint", "verify JSON syntax is valid (check for missing commas, quotes, brackets); comments and trailing commas are allowed")
+		return nil, Access{}, fmt.Errorf("invalid JSON in config file %q: %w", source, err)
+	}
+
+	if err := file.applyDefaults(configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	if err := applyProfiles(file.Routes, file.Profiles, configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	access, err := file.loadAccess(configLogger)
+	if err != nil {
+		return nil, Access{}, err
+	}
+	return file.Routes, access, nil
+}
+
+// ValidateRoutes validates routes that don't come from a config file, such
+// as one given on the command line, exactly as LoadConfigWithOptions would,
+// logging each problem to logger.
+func ValidateRoutes(routes []RouteConfig, opts LoadOptions, logger *slog.Logger) error {
+	return validateConfig(routes, opts, logger)
+}
+
+// fileConfig is the top level of a config file: either a bare array of
+// routes, or an object that can also define profiles and defaults.
+type fileConfig struct {
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+	// Defaults holds route settings, by JSON key, that every route inherits
+	// unless it sets them itself.
+	Defaults map[string]json.RawMessage `json:"defaults,omitempty"`
+	Routes   []RouteConfig              `json:"routes"`
+	// AdminToken and Tenants say who may use the admin API; see Access.
+	AdminToken *TokenRef               `json:"adminToken,omitempty"`
+	Tenants    map[string]TenantConfig `json:"tenants,omitempty"`
+
+	// rawRoutes holds the settings each route sets itself, when there are
+	// defaults to tell them apart from.
+	rawRoutes []map[string]json.RawMessage
+}
+
+func (f *fileConfig) decode(data []byte) error {
+	data, err := fromJSON5(data)
+	if err != nil {
+		return err
+	}
+	if data, err = flattenChaos(data); err != nil {
+		return err
+	}
+	if data, err = expandPortRanges(data); err != nil {
+		return err
+	}
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return decodeStrict(data, &f.Routes)
+	}
+	if err := decodeStrict(data, f); err != nil {
+		return err
+	}
+	if len(f.Defaults) == 0 {
+		return nil
+	}
+	var raw struct {
+		Routes []map[string]json.RawMessage `json:"routes"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	f.rawRoutes = raw.Routes
+	return nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
 }
 
-// LoadConfig loads the route configuration from a JSON file.
-func LoadConfig(configPath string) ([]RouteConfig, error) {
-	configLogger := slog.With("file", configPath)
-	file, err := os.Open(configPath)
-	if err != nil {
-		configLogger.Error("failed to open config file", "error", err, "hint", "check that the file exists and you have read permissions")
-		return nil, fmt.Errorf("cannot open config file %q: %w", configPath, err)
+func validateConfig(routes []RouteConfig, opts LoadOptions, configLogger *slog.Logger) error {
+	origins := make([]routeOrigin, len(routes))
+	for i := range routes {
+		origins[i] = routeOrigin{logger: configLogger, index: i}
+	}
+	return validateRoutes(routes, origins, opts, configLogger)
+}
+
+// routeOrigin is where a route was defined, so errors can point there when
+// routes from several files are validated together.
+type routeOrigin struct {
+	// logger is the logger of the route's file.
+	logger *slog.Logger
+	file   string
+	// index is the route's position in its file.
+	index int
+}
+
+// validateRoutes validates routes, including the conflicts between them,
+// logging each problem with the origin of the routes involved.
+func validateRoutes(routes []RouteConfig, origins []routeOrigin, opts LoadOptions, configLogger *slog.Logger) error {
+	if len(routes) == 0 {
+		configLogger.Error("empty route configuration", "hint", "config file must contain at least one route")
+		return fmt.Errorf("validation failed: empty route configuration")
+	}
+
+	hasErrors := false
+	for i, route := range routes {
+		if err := validateRouteConfig(route, origins[i].index, opts, origins[i].logger); err != nil {
+			hasErrors = true
+		}
+	}
+	if !validateConflicts(routes, origins, opts, configLogger) {
+		hasErrors = true
+	}
+
+	if hasErrors {
+		return fmt.Errorf("validation failed: see error messages above for details")
+	}
+
+	return nil
+}
+
+// validateConflicts checks routes, each valid on its own, against each
+// other: ports, names and handoff sockets used twice, and chains of routes
+// that loop. It logs each problem with the later route's origin, and
+// reports whether there were none.
+func validateConflicts(routes []RouteConfig, origins []routeOrigin, opts LoadOptions, configLogger *slog.Logger) bool {
+	// Each map holds the index of the route that took the port, name or
+	// socket first.
+	portMap := make(map[int]int)
+	socketMap := make(map[string]int)
+	nameMap := make(map[string]int)
+	hasErrors := false
+
+	// conflict names the earlier route j when it comes from another file
+	// than route i.
+	conflict := func(i, j int) []any {
+		if origins[i].file == origins[j].file {
+			return nil
+		}
+		return []any{"other_file", origins[j].file, "other_route_index", origins[j].index}
+	}
+
+	for i, route := range routes {
+		origin := origins[i]
+
+		// Auto-assigned ports (localPort 0) can't collide with each other.
+		autoPort := route.LocalPort == 0 && opts.AllowAutoPort
+		if j, exists := portMap[route.LocalPort]; exists && !autoPort {
+			origin.logger.Error("duplicate local port detected", append([]any{
+				"port", route.LocalPort,
+				"route_index", origin.index,
+				"hint", fmt.Sprintf("each route must use a unique localPort. Port %d is already used by another route", route.LocalPort)},
+				conflict(i, j)...)...)
+			hasErrors = true
+		} else if !autoPort {
+			portMap[route.LocalPort] = i
+		}
+
+		if route.Name != "" {
+			if j, exists := nameMap[route.Name]; exists {
+				origin.logger.Error("duplicate route name detected", append([]any{
+					"name", route.Name,
+					"route_index", origin.index,
+					"hint", fmt.Sprintf("each route must have a unique name. %q is already used by another route", route.Name)},
+					conflict(i, j)...)...)
+				hasErrors = true
+			} else {
+				nameMap[route.Name] = i
+			}
+		}
+
+		if route.HandoffSocket != "" {
+			socket := filepath.Clean(route.HandoffSocket)
+			if j, exists := socketMap[socket]; exists {
+				origin.logger.Error("duplicate handoff socket detected", append([]any{
+					"socket", route.HandoffSocket,
+					"route_index", origin.index,
+					"hint", "each route must use its own handoffSocket"},
+					conflict(i, j)...)...)
+				hasErrors = true
+			} else {
+				socketMap[socket] = i
+			}
+		}
+
+		if route.BaselinePort == 0 {
+			continue
+		}
+		if j, exists := portMap[route.BaselinePort]; exists {
+			origin.logger.Error("duplicate baseline port detected", append([]any{
+				"port", route.BaselinePort,
+				"route_index", origin.index,
+				"hint", fmt.Sprintf("baselinePort must not collide with any localPort or baselinePort. Port %d is already in use", route.BaselinePort)},
+				conflict(i, j)...)...)
+			hasErrors = true
+		} else {
+			portMap[route.BaselinePort] = i
+		}
+	}
+
+	if loop := findChainLoop(routes); loop != nil {
+		configLogger.Error("route chain loops back on itself",
+			"chain", formatChain(loop),
+			"hint", fmt.Sprintf("a route's upstream may point at another route's localPort (%s:<port>) to chain them, but the chain must end at a real upstream", ListenHost))
+		hasErrors = true
+	}
+	return !hasErrors
+}
+
+func validateRouteConfig(config RouteConfig, routeIndex int, opts LoadOptions, configLogger *slog.Logger) error {
+	hasErrors := false
+	routeLogger := configLogger.With("route_index", routeIndex)
+	if config.Name != "" {
+		routeLogger = routeLogger.With("route", config.Name)
+	}
+
+	// Validate local port - 0 isn't allowed unless the caller publishes
+	// auto-assigned ports. Otherwise require static port assignment.
+	minPort := 1
+	if opts.AllowAutoPort {
+		minPort = 0
+	}
+	if config.LocalPort < minPort || config.LocalPort > 65535 {
+		routeLogger.Error("invalid local port",
+			"port", config.LocalPort,
+			"valid_range", "1-65535",
+			"hint", fmt.Sprintf("localPort must be between 1 and 65535, got %d", config.LocalPort))
+		hasErrors = true
+	}
+
+	if config.ListenAddress != "" {
+		if _, err := netip.ParseAddr(config.ListenAddress); err != nil {
+			routeLogger.Error("invalid listen address",
+				"listen_address", config.ListenAddress,
+				"error", err,
+				"hint", "listenAddress must be an IP address without a port, such as '127.0.0.1', '0.0.0.0' or '::'")
+			hasErrors = true
+		}
+	}
+
+	if config.PerConnectionUpstream() {
+		switch {
+		case config.Upstream == "":
+		case config.Mode == ModeTransparent:
+			routeLogger.Error("upstream with transparent mode",
+				"upstream", config.Upstream,
+				"hint", "a transparent route connects each client to the destination it originally dialled; remove upstream")
+			hasErrors = true
+		default:
+			routeLogger.Error("upstream with socks5 mode",
+				"upstream", config.Upstream,
+				"hint", "a socks5 route connects each client to the destination it asks for; remove upstream")
+			hasErrors = true
+		}
+	} else if config.Stub != nil {
+		if config.Upstream != "" {
+			routeLogger.Error("upstream with stub",
+				"upstream", config.Upstream,
+				"hint", "a route with a stub answers its connections itself; remove upstream or stub")
+			hasErrors = true
+		}
+	} else if config.Replay != nil {
+		if config.Upstream != "" {
+			routeLogger.Error("upstream with replay",
+				"upstream", config.Upstream,
+				"hint", "a route with replay answers its connections from recordings; remove upstream or replay")
+			hasErrors = true
+		}
+	} else if config.Upstream == "" {
+		routeLogger.Error("upstream field is empty", "hint", "upstream must be in format 'ip:port' (e.g., '127.0.0.1:9090')")
+		hasErrors = true
+	} else {
+		host, port, err := net.SplitHostPort(config.Upstream)
+		if err != nil {
+			routeLogger.Error("invalid upstream format",
+				"upstream", config.Upstream,
+				"error", err,
+				"hint", "upstream must be in format 'ip:port' (e.g., '127.0.0.1:9090' or '[::1]:9090' for IPv6)")
+			hasErrors = true
+		} else {
+			if net.ParseIP(host) == nil {
+				routeLogger.Error("upstream host is not a valid IP address",
+					"upstream", config.Upstream,
+					"host", host,
+					"hint", fmt.Sprintf("host must be an IP address (e.g., '127.0.0.1' or '[::1]'), not a hostname. Got %q", host))
+				hasErrors = true
+			}
+
+			portNum, err := strconv.Atoi(port)
+			if err != nil {
+				routeLogger.Error("upstream port is not a number",
+					"upstream", config.Upstream,
+					"port", port,
+					"error", err,
+					"hint", fmt.Sprintf("port must be a number between 1-65535, got %q", port))
+				hasErrors = true
+			} else if portNum <= 0 || portNum > 65535 {
+				routeLogger.Error("upstream port out of valid range",
+					"upstream", config.Upstream,
+					"port", portNum,
+					"valid_range", "1-65535",
+					"hint", fmt.Sprintf("port must be between 1 and 65535, got %d", portNum))
+				hasErrors = true
+			}
+		}
+	}
+
+	if config.FallbackUpstream != "" {
+		if config.PerConnectionUpstream() || config.Stub != nil || config.Replay != nil {
+			routeLogger.Error("fallback upstream without upstream",
+				"fallback_upstream", config.FallbackUpstream,
+				"hint", "fallbackUpstream stands in for the route's upstream; socks5 and transparent routes, stubs and replays have none. Remove fallbackUpstream")
+			hasErrors = true
+		} else if addr, err := netip.ParseAddrPort(config.FallbackUpstream); err != nil || addr.Port() == 0 {
+			routeLogger.Error("invalid fallback upstream",
+				"fallback_upstream", config.FallbackUpstream,
+				"hint", "fallbackUpstream must be in format 'ip:port' like upstream (e.g., '127.0.0.1:9091' or '[::1]:9091')")
+			hasErrors = true
+		} else if config.FallbackUpstream == config.Upstream {
+			routeLogger.Error("fallback upstream same as upstream",
+				"fallback_upstream", config.FallbackUpstream,
+				"hint", "a fallback to the upstream that just failed to dial would fail too; point fallbackUpstream at another server")
+			hasErrors = true
+		}
+	}
+	if config.FallbackAfterFailures < 0 {
+		routeLogger.Error("invalid fallback failure count",
+			"fallback_after_failures", config.FallbackAfterFailures,
+			"hint", "fallbackAfterFailures is how many dials of the upstream in a row must fail before connections fail over; leave it out to fail over on the first")
+		hasErrors = true
+	} else if config.FallbackAfterFailures > 0 && config.FallbackUpstream == "" {
+		routeLogger.Error("fallback failure count without fallback upstream",
+			"fallback_after_failures", config.FallbackAfterFailures,
+			"hint", "set fallbackUpstream to the server connections fail over to, or remove fallbackAfterFailures")
+		hasErrors = true
+	}
+
+	if config.DropRate < 0.0 || config.DropRate > 1.0 {
+		routeLogger.Error("invalid drop rate",
+			"drop_rate", config.DropRate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("dropRate must be between 0.0 and 1.0 (probability), got %.2f", config.DropRate))
+		hasErrors = true
+	}
+
+	if config.LatencyMs < 0 {
+		routeLogger.Error("invalid latency",
+			"latency_ms", config.LatencyMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("latencyMs must be >= 0 (milliseconds), got %d", config.LatencyMs))
+		hasErrors = true
+	}
+
+	if config.DialFailureRate < 0.0 || config.DialFailureRate > 1.0 {
+		routeLogger.Error("invalid dial failure rate",
+			"dial_failure_rate", config.DialFailureRate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("dialFailureRate must be between 0.0 and 1.0 (probability), got %.2f", config.DialFailureRate))
+		hasErrors = true
+	}
+
+	if config.ConnectLatencyMs < 0 {
+		routeLogger.Error("invalid connect latency",
+			"connect_latency_ms", config.ConnectLatencyMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("connectLatencyMs must be >= 0 (milliseconds), got %d", config.ConnectLatencyMs))
+		hasErrors = true
+	}
+
+	if config.FirstByteLatencyMs < 0 {
+		routeLogger.Error("invalid first byte latency",
+			"first_byte_latency_ms", config.FirstByteLatencyMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("firstByteLatencyMs must be >= 0 (milliseconds), got %d", config.FirstByteLatencyMs))
+		hasErrors = true
+	}
+
+	if config.FinDelayMs < 0 {
+		routeLogger.Error("invalid FIN delay",
+			"fin_delay_ms", config.FinDelayMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("finDelayMs must be >= 0 (milliseconds), got %d", config.FinDelayMs))
+		hasErrors = true
+	}
+
+	if config.MaxConnectionLifetimeMs < 0 {
+		routeLogger.Error("invalid connection lifetime",
+			"max_connection_lifetime_ms", config.MaxConnectionLifetimeMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("maxConnectionLifetimeMs must be >= 0 (milliseconds, 0 disables), got %d", config.MaxConnectionLifetimeMs))
+		hasErrors = true
+	}
+
+	if config.IdleTimeoutMs < 0 {
+		routeLogger.Error("invalid idle timeout",
+			"idle_timeout_ms", config.IdleTimeoutMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("idleTimeoutMs must be >= 0 (milliseconds, 0 disables), got %d", config.IdleTimeoutMs))
+		hasErrors = true
+	}
+
+	if config.IdleReleaseMs < 0 {
+		routeLogger.Error("invalid idle release time",
+			"idle_release_ms", config.IdleReleaseMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("idleReleaseMs must be >= 0 (milliseconds, 0 for the default of %d), got %d", DefaultIdleReleaseMs, config.IdleReleaseMs))
+		hasErrors = true
+	} else if config.IdleReleaseMs > 0 && config.IdleTimeoutMs == 0 {
+		routeLogger.Error("idle release time without idle timeout",
+			"idle_release_ms", config.IdleReleaseMs,
+			"hint", "idleReleaseMs is how long a connection dropped by idleTimeoutMs is blackholed before it is released; set idleTimeoutMs, or remove idleReleaseMs")
+		hasErrors = true
+	}
+
+	if len(config.HandoffSocket) > maxSocketPathLen {
+		routeLogger.Error("handoff socket path too long",
+			"socket", config.HandoffSocket,
+			"valid_range", fmt.Sprintf("<= %d bytes", maxSocketPathLen),
+			"hint", fmt.Sprintf("Unix socket paths are limited to %d bytes, got %d; use a shorter path", maxSocketPathLen, len(config.HandoffSocket)))
+		hasErrors = true
+	}
+
+	if config.AcceptListeners < 0 || config.AcceptListeners > MaxAcceptListeners {
+		routeLogger.Error("invalid accept listener count",
+			"accept_listeners", config.AcceptListeners,
+			"valid_range", fmt.Sprintf("0-%d", MaxAcceptListeners),
+			"hint", fmt.Sprintf("acceptListeners must be between 0 and %d (0 or 1 for a single listener), got %d", MaxAcceptListeners, config.AcceptListeners))
+		hasErrors = true
+	}
+
+	if config.BaselinePort < 0 || config.BaselinePort > 65535 {
+		routeLogger.Error("invalid baseline port",
+			"baseline_port", config.BaselinePort,
+			"valid_range", "1-65535",
+			"hint", fmt.Sprintf("baselinePort must be between 1 and 65535 (or omitted), got %d", config.BaselinePort))
+		hasErrors = true
+	}
+
+	if config.ReorderRate < 0.0 || config.ReorderRate > 1.0 {
+		routeLogger.Error("invalid reorder rate",
+			"reorder_rate", config.ReorderRate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("reorderRate must be between 0.0 and 1.0 (probability), got %.2f", config.ReorderRate))
+		hasErrors = true
+	}
+
+	if config.ReorderWindow < 0 {
+		routeLogger.Error("invalid reorder window",
+			"reorder_window", config.ReorderWindow,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("reorderWindow must be >= 0 (chunks, 0 uses the default of 2), got %d", config.ReorderWindow))
+		hasErrors = true
+	}
+
+	if config.DuplicateRate < 0.0 || config.DuplicateRate > 1.0 {
+		routeLogger.Error("invalid duplicate rate",
+			"duplicate_rate", config.DuplicateRate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("duplicateRate must be between 0.0 and 1.0 (probability), got %.2f", config.DuplicateRate))
+		hasErrors = true
+	}
+
+	if config.CoalesceMs < 0 || config.CoalesceBytes < 0 {
+		routeLogger.Error("invalid coalescing settings",
+			"coalesce_ms", config.CoalesceMs,
+			"coalesce_bytes", config.CoalesceBytes,
+			"valid_range", ">= 0",
+			"hint", "coalesceMs and coalesceBytes must be >= 0")
+		hasErrors = true
+	}
+
+	if config.BufferBytes != 0 && (config.BufferBytes < MinBufferBytes || config.BufferBytes > MaxBufferBytes) {
+		routeLogger.Error("invalid buffer size",
+			"buffer_bytes", config.BufferBytes,
+			"valid_range", fmt.Sprintf("%d-%d", MinBufferBytes, MaxBufferBytes),
+			"hint", fmt.Sprintf("bufferBytes must be between %d and %d (bytes), or 0 for the default of %d, got %d", MinBufferBytes, MaxBufferBytes, DefaultBufferBytes, config.BufferBytes))
+		hasErrors = true
+	}
+
+	if config.MaxBufferedBytes < 0 {
+		routeLogger.Error("invalid buffered byte limit",
+			"max_buffered_bytes", config.MaxBufferedBytes,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("maxBufferedBytes must be >= 0 (bytes, 0 for the default of %d), got %d", DefaultMaxBufferedBytes, config.MaxBufferedBytes))
+		hasErrors = true
+	}
+
+	if config.ReadTimeoutMs < 0 || config.WriteTimeoutMs < 0 {
+		routeLogger.Error("invalid read or write timeout",
+			"read_timeout_ms", config.ReadTimeoutMs,
+			"write_timeout_ms", config.WriteTimeoutMs,
+			"valid_range", ">= 0",
+			"hint", "readTimeoutMs and writeTimeoutMs must be >= 0 (milliseconds, 0 disables)")
+		hasErrors = true
+	}
+
+	if config.DialTimeoutMs < 0 {
+		routeLogger.Error("invalid dial timeout",
+			"dial_timeout_ms", config.DialTimeoutMs,
+			"valid_range", ">= 0",
+			"hint", "dialTimeoutMs must be >= 0 (milliseconds, 0 for the kernel's connect timeout)")
+		hasErrors = true
+	}
+	if config.DialRetries < 0 || config.DialRetries > MaxDialRetries {
+		routeLogger.Error("invalid dial retry count",
+			"dial_retries", config.DialRetries,
+			"valid_range", fmt.Sprintf("0-%d", MaxDialRetries),
+			"hint", fmt.Sprintf("dialRetries is how many more times a failed dial of the upstream is tried, between 0 and %d", MaxDialRetries))
+		hasErrors = true
+	}
+	if config.DialBackoffMs < 0 {
+		routeLogger.Error("invalid dial backoff",
+			"dial_backoff_ms", config.DialBackoffMs,
+			"valid_range", ">= 0",
+			"hint", "dialBackoffMs must be >= 0 (milliseconds)")
+		hasErrors = true
+	} else if config.DialBackoffMs > 0 && config.DialRetries == 0 {
+		routeLogger.Error("dial backoff without retries",
+			"dial_backoff_ms", config.DialBackoffMs,
+			"hint", "dialBackoffMs is the wait before retrying a failed dial; set dialRetries too, or remove dialBackoffMs")
+		hasErrors = true
+	}
+
+	if config.MaxConnections < 0 {
+		routeLogger.Error("invalid connection limit",
+			"max_connections", config.MaxConnections,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("maxConnections must be >= 0 (0 disables), got %d", config.MaxConnections))
+		hasErrors = true
+	}
+	switch config.Overflow {
+	case "", OverflowReject, OverflowReset, OverflowQueue:
+		if config.Overflow != "" && config.MaxConnections == 0 {
+			routeLogger.Error("overflow without a connection limit",
+				"overflow", config.Overflow,
+				"hint", "overflow says what happens to connections over maxConnections; set maxConnections too")
+			hasErrors = true
+		}
+	default:
+		routeLogger.Error("invalid overflow",
+			"overflow", config.Overflow,
+			"valid_values", []string{OverflowReject, OverflowReset, OverflowQueue},
+			"hint", fmt.Sprintf("overflow must be %q, %q or %q, got %q", OverflowReject, OverflowReset, OverflowQueue, config.Overflow))
+		hasErrors = true
+	}
+	if config.QueueTimeoutMs < 0 {
+		routeLogger.Error("invalid queue timeout",
+			"queue_timeout_ms", config.QueueTimeoutMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("queueTimeoutMs must be >= 0 (milliseconds, 0 waits indefinitely), got %d", config.QueueTimeoutMs))
+		hasErrors = true
+	} else if config.QueueTimeoutMs > 0 && config.Overflow != OverflowQueue {
+		routeLogger.Error("queue timeout without a queue",
+			"queue_timeout_ms", config.QueueTimeoutMs,
+			"overflow", config.Overflow,
+			"hint", fmt.Sprintf("queueTimeoutMs only applies with overflow %q", OverflowQueue))
+		hasErrors = true
+	}
+
+	if config.MaxConnectionsPerSecond < 0 {
+		routeLogger.Error("invalid connection rate",
+			"max_connections_per_second", config.MaxConnectionsPerSecond,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("maxConnectionsPerSecond must be >= 0 (0 disables), got %v", config.MaxConnectionsPerSecond))
+		hasErrors = true
+	}
+	switch config.AcceptThrottle {
+	case "", AcceptThrottleDelay, AcceptThrottleReject:
+		if config.AcceptThrottle != "" && config.MaxConnectionsPerSecond == 0 {
+			routeLogger.Error("accept throttle without a connection rate",
+				"accept_throttle", config.AcceptThrottle,
+				"hint", "acceptThrottle says what happens to connections over maxConnectionsPerSecond; set maxConnectionsPerSecond too")
+			hasErrors = true
+		}
+	default:
+		routeLogger.Error("invalid accept throttle",
+			"accept_throttle", config.AcceptThrottle,
+			"valid_values", []string{AcceptThrottleDelay, AcceptThrottleReject},
+			"hint", fmt.Sprintf("acceptThrottle must be %q or %q, got %q", AcceptThrottleDelay, AcceptThrottleReject, config.AcceptThrottle))
+		hasErrors = true
+	}
+
+	if config.DropCooldownMs < 0 {
+		routeLogger.Error("invalid drop cool-down",
+			"drop_cooldown_ms", config.DropCooldownMs,
+			"valid_range", ">= 0",
+			"hint", fmt.Sprintf("dropCooldownMs must be >= 0 (milliseconds), got %d", config.DropCooldownMs))
+		hasErrors = true
+	} else if config.DropCooldownMs > 0 && (config.DropMode == DropModeExact || config.BurstLoss != nil) {
+		routeLogger.Error("conflicting loss options",
+			"drop_cooldown_ms", config.DropCooldownMs,
+			"hint", "dropCooldownMs applies to random dropRate and cannot be combined with dropMode 'exact' or burstLoss")
+		hasErrors = true
+	}
+
+	switch config.DropMode {
+	case "", DropModeRandom:
+	case DropModeExact:
+		if config.BurstLoss != nil {
+			routeLogger.Error("conflicting loss options",
+				"drop_mode", config.DropMode,
+				"hint", "dropMode 'exact' applies to dropRate and cannot be combined with burstLoss")
+			hasErrors = true
+		}
+	default:
+		routeLogger.Error("invalid drop mode",
+			"drop_mode", config.DropMode,
+			"valid_values", []string{DropModeRandom, DropModeExact},
+			"hint", fmt.Sprintf("dropMode must be %q or %q, got %q", DropModeRandom, DropModeExact, config.DropMode))
+		hasErrors = true
+	}
+
+	switch config.Protocol {
+	case "", ProtocolTCP:
+	case ProtocolUDP:
+		for _, option := range config.tcpOnlyOptions() {
+			routeLogger.Error("option not supported over UDP",
+				"option", option,
+				"hint", fmt.Sprintf("%s only applies to TCP connections; udp routes support dropRate, dropMode, dropCooldownMs, burstLoss, latencyMs, duplicateRate, reorderRate, reorderWindow and mode %q", option, ModeDNS))
+			hasErrors = true
+		}
+	default:
+		routeLogger.Error("invalid protocol",
+			"protocol", config.Protocol,
+			"valid_values", []string{ProtocolTCP, ProtocolUDP},
+			"hint", fmt.Sprintf("protocol must be %q or %q, got %q", ProtocolTCP, ProtocolUDP, config.Protocol))
+		hasErrors = true
+	}
+
+	switch config.Mode {
+	case "", ModeTCP, ModeHTTP, ModeHTTP2, ModeGRPC, ModeDNS, ModePostgres, ModeMySQL, ModeSOCKS5, ModeTransparent:
+		if config.HTTP != nil && config.Mode != ModeHTTP {
+			routeLogger.Error("http settings without http mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("the http section only applies to routes with mode %q", ModeHTTP))
+			hasErrors = true
+		}
+		if config.HTTP2 != nil && config.Mode != ModeHTTP2 {
+			routeLogger.Error("http2 settings without http2 mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("the http2 section only applies to routes with mode %q", ModeHTTP2))
+			hasErrors = true
+		}
+		if config.GRPC != nil && config.Mode != ModeGRPC {
+			routeLogger.Error("grpc settings without grpc mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("the grpc section only applies to routes with mode %q", ModeGRPC))
+			hasErrors = true
+		}
+		if config.Database != nil && config.Mode != ModePostgres && config.Mode != ModeMySQL {
+			routeLogger.Error("database settings without a database mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("the database section only applies to routes with mode %q or %q", ModePostgres, ModeMySQL))
+			hasErrors = true
+		}
+		if (config.Mode == ModePostgres || config.Mode == ModeMySQL) && (config.TLS != nil || config.UpstreamTLS != nil) {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("%s negotiates TLS inside its own protocol, so the route can't terminate or originate it; remove tls and upstreamTLS and have clients connect without TLS", config.Mode))
+			hasErrors = true
+		}
+		if config.DNS != nil && config.Mode != ModeDNS {
+			routeLogger.Error("dns settings without dns mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("the dns section only applies to routes with mode %q", ModeDNS))
+			hasErrors = true
+		}
+		if config.CaptureClientHello && config.Mode != "" && config.Mode != ModeTCP && !config.PerConnectionUpstream() {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("captureClientHello reads TLS, which a %s mode route can't parse; use one or the other", config.Mode))
+			hasErrors = true
+		}
+		if config.Mode == ModeSOCKS5 && (config.TLS != nil || config.UpstreamTLS != nil) {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", "SOCKS5 clients tunnel their own TLS to each destination; remove tls and upstreamTLS from socks5 routes")
+			hasErrors = true
+		}
+		if config.Mode == ModeTransparent && (config.TLS != nil || config.UpstreamTLS != nil) {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", "redirected clients run their own TLS with each destination; remove tls and upstreamTLS from transparent routes")
+			hasErrors = true
+		}
+		if config.Mode == ModeTransparent && config.ProxyProtocol != nil && config.ProxyProtocol.Accept {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", "redirected connections come straight from their clients, without a PROXY protocol header; remove proxyProtocol.accept from transparent routes")
+			hasErrors = true
+		}
+		if config.Mode == ModeTransparent && config.AcceptListeners > 1 {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"accept_listeners", config.AcceptListeners,
+				"hint", "transparent routes listen with IP_TRANSPARENT on a single listener; remove acceptListeners from transparent routes")
+			hasErrors = true
+		}
+	default:
+		routeLogger.Error("invalid mode",
+			"mode", config.Mode,
+			"valid_values", []string{ModeTCP, ModeHTTP, ModeHTTP2, ModeGRPC, ModeDNS, ModePostgres, ModeMySQL, ModeSOCKS5, ModeTransparent},
+			"hint", fmt.Sprintf("mode must be %q, %q, %q, %q, %q, %q, %q, %q or %q, got %q", ModeTCP, ModeHTTP, ModeHTTP2, ModeGRPC, ModeDNS, ModePostgres, ModeMySQL, ModeSOCKS5, ModeTransparent, config.Mode))
+		hasErrors = true
+	}
+
+	if h := config.HTTP2; h != nil && (h.ResetRate < 0.0 || h.ResetRate > 1.0) {
+		routeLogger.Error("invalid HTTP/2 reset rate",
+			"reset_rate", h.ResetRate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("http2.resetRate must be between 0.0 and 1.0 (probability), got %.2f", h.ResetRate))
+		hasErrors = true
+	}
+
+	if g := config.GRPC; g != nil {
+		if g.ErrorRate < 0.0 || g.ErrorRate > 1.0 {
+			routeLogger.Error("invalid gRPC error rate",
+				"error_rate", g.ErrorRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("grpc.errorRate must be between 0.0 and 1.0 (probability), got %.2f", g.ErrorRate))
+			hasErrors = true
+		}
+		for _, name := range g.ErrorCodes {
+			if _, ok := GRPCCode(name); !ok {
+				routeLogger.Error("invalid gRPC error code",
+					"error_code", name,
+					"valid_values", slices.Sorted(maps.Keys(grpcCodes)),
+					"hint", fmt.Sprintf("grpc.errorCodes entries must be upper-case gRPC status names such as %q or %q", DefaultGRPCErrorCode, "DEADLINE_EXCEEDED"))
+				hasErrors = true
+			}
+		}
+		if g.MessageDelayMs < 0 {
+			routeLogger.Error("invalid gRPC message delay",
+				"message_delay_ms", g.MessageDelayMs,
+				"valid_range", ">= 0",
+				"hint", "grpc.messageDelayMs must be >= 0")
+			hasErrors = true
+		}
+		if rate := g.MessageDelayRateOrDefault(); rate < 0.0 || rate > 1.0 {
+			routeLogger.Error("invalid gRPC message delay rate",
+				"message_delay_rate", rate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("grpc.messageDelayRate must be between 0.0 and 1.0 (probability), got %.2f", rate))
+			hasErrors = true
+		}
+	}
+
+	if d := config.DNS; d != nil {
+		if d.ErrorRate < 0.0 || d.ErrorRate > 1.0 {
+			routeLogger.Error("invalid DNS error rate",
+				"error_rate", d.ErrorRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("dns.errorRate must be between 0.0 and 1.0 (probability), got %.2f", d.ErrorRate))
+			hasErrors = true
+		}
+		for _, name := range d.ErrorCodes {
+			if _, ok := DNSRcode(name); !ok {
+				routeLogger.Error("invalid DNS error code",
+					"error_code", name,
+					"valid_values", slices.Sorted(maps.Keys(dnsRcodes)),
+					"hint", fmt.Sprintf("dns.errorCodes entries must be upper-case DNS response code names such as %q or %q", DefaultDNSErrorCode, "NXDOMAIN"))
+				hasErrors = true
+			}
+		}
+		if d.DelayMs < 0 {
+			routeLogger.Error("invalid DNS delay",
+				"delay_ms", d.DelayMs,
+				"valid_range", ">= 0",
+				"hint", "dns.delayMs must be >= 0")
+			hasErrors = true
+		}
+		if rate := d.DelayRateOrDefault(); rate < 0.0 || rate > 1.0 {
+			routeLogger.Error("invalid DNS delay rate",
+				"delay_rate", rate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("dns.delayRate must be between 0.0 and 1.0 (probability), got %.2f", rate))
+			hasErrors = true
+		}
+		if d.TTLRate < 0.0 || d.TTLRate > 1.0 {
+			routeLogger.Error("invalid DNS TTL rate",
+				"ttl_rate", d.TTLRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("dns.ttlRate must be between 0.0 and 1.0 (probability), got %.2f", d.TTLRate))
+			hasErrors = true
+		}
+		if d.TTL < 0 || d.TTL > maxDNSTTL {
+			routeLogger.Error("invalid DNS TTL",
+				"ttl", d.TTL,
+				"valid_range", fmt.Sprintf("0-%d", maxDNSTTL),
+				"hint", fmt.Sprintf("dns.ttl must be between 0 and %d seconds", maxDNSTTL))
+			hasErrors = true
+		}
+	}
+
+	if d := config.Database; d != nil {
+		if d.KillQueryRate < 0.0 || d.KillQueryRate > 1.0 {
+			routeLogger.Error("invalid database kill query rate",
+				"kill_query_rate", d.KillQueryRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("database.killQueryRate must be between 0.0 and 1.0 (probability), got %.2f", d.KillQueryRate))
+			hasErrors = true
+		}
+		if d.PartialResultRate < 0.0 || d.PartialResultRate > 1.0 {
+			routeLogger.Error("invalid database partial result rate",
+				"partial_result_rate", d.PartialResultRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("database.partialResultRate must be between 0.0 and 1.0 (probability), got %.2f", d.PartialResultRate))
+			hasErrors = true
+		}
+		if d.PartialRows < 0 {
+			routeLogger.Error("invalid database partial rows",
+				"partial_rows", d.PartialRows,
+				"valid_range", ">= 0",
+				"hint", fmt.Sprintf("database.partialRows must be >= 0 (0 means %d), got %d", DefaultPartialRows, d.PartialRows))
+			hasErrors = true
+		}
 	}
-	defer file.Close()
 
-	var config []RouteConfig
-	decoder := json.NewDecoder(file)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&config); err != nil {
-		configLogger.Error("invalid JSON in config file", "error", err, "hint", "verify JSON syntax is valid (check for missing commas, quotes, brackets)")
-		return nil, fmt.Errorf("invalid JSON in config file %q: %w", configPath, err)
+	if st := config.Stub; st != nil {
+		httpStub := config.Mode == ModeHTTP || config.Mode == ModeHTTP2
+		if config.Mode != "" && config.Mode != ModeTCP && !httpStub {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("a stub answers as opaque TCP or plain HTTP; use mode %q, %q or %q with stub", ModeTCP, ModeHTTP, ModeHTTP2))
+			hasErrors = true
+		}
+		if config.UpstreamTLS != nil || (config.ProxyProtocol != nil && config.ProxyProtocol.Send != "") {
+			routeLogger.Error("conflicting stub options",
+				"hint", "a stub is never dialled, so there is no upstream connection for upstreamTLS or proxyProtocol.send; remove them")
+			hasErrors = true
+		}
+		if st.Body != "" && st.BodyFile != "" {
+			routeLogger.Error("conflicting stub options",
+				"hint", "stub.body and stub.bodyFile both set the stub's response; use one")
+			hasErrors = true
+		} else if st.BodyFile != "" {
+			if _, err := os.ReadFile(st.BodyFile); err != nil {
+				routeLogger.Error("invalid stub body file",
+					"body_file", st.BodyFile,
+					"error", err,
+					"hint", "stub.bodyFile must be a readable file")
+				hasErrors = true
+			}
+		}
+		if !httpStub && (st.Status != 0 || len(st.Headers) > 0) {
+			routeLogger.Error("stub HTTP options without an HTTP mode",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("stub.status and stub.headers only apply to routes with mode %q or %q; a tcp stub answers with its body alone", ModeHTTP, ModeHTTP2))
+			hasErrors = true
+		}
+		if st.Status != 0 && (st.Status < 200 || st.Status > 599) {
+			routeLogger.Error("invalid stub status",
+				"status", st.Status,
+				"valid_range", "200-599",
+				"hint", fmt.Sprintf("stub.status must be an HTTP status code between 200 and 599, got %d", st.Status))
+			hasErrors = true
+		}
 	}
 
-	if err := validateConfig(config, configLogger); err != nil {
-		return nil, err
+	if rec := config.Record; rec != nil && rec.Dir == "" {
+		routeLogger.Error("missing record directory",
+			"hint", "record.dir must name the directory recordings are written to; it is created if needed")
+		hasErrors = true
 	}
 
-	return config, nil
-}
+	if rp := config.Replay; rp != nil {
+		if config.Stub != nil {
+			routeLogger.Error("conflicting replay options",
+				"hint", "stub and replay both stand in for the upstream; use one")
+			hasErrors = true
+		}
+		if config.Mode != "" && config.Mode != ModeTCP && config.Mode != ModeHTTP {
+			routeLogger.Error("conflicting mode options",
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("recordings are replayed as opaque TCP or HTTP/1.x; use mode %q or %q with replay", ModeTCP, ModeHTTP))
+			hasErrors = true
+		}
+		if config.UpstreamTLS != nil || (config.ProxyProtocol != nil && config.ProxyProtocol.Send != "") {
+			routeLogger.Error("conflicting replay options",
+				"hint", "a replay is never dialled, so there is no upstream connection for upstreamTLS or proxyProtocol.send; remove them")
+			hasErrors = true
+		}
+		if rp.Dir == "" {
+			routeLogger.Error("missing replay directory",
+				"hint", "replay.dir must name a directory of recordings made with record")
+			hasErrors = true
+		} else if recordings, err := filepath.Glob(filepath.Join(rp.Dir, "*"+RecordingExt)); err != nil || len(recordings) == 0 {
+			routeLogger.Error("no recordings to replay",
+				"dir", rp.Dir,
+				"hint", fmt.Sprintf("replay.dir must hold %s files written by a route with record.dir", RecordingExt))
+			hasErrors = true
+		}
+	}
 
-func validateConfig(routes []RouteConfig, configLogger *slog.Logger) error {
-	if len(routes) == 0 {
-		configLogger.Error("empty route configuration", "hint", "config file must contain at least one route")
-		return fmt.Errorf("validation failed: empty route configuration")
+	if h := config.HTTP; h != nil {
+		if h.ErrorRate < 0.0 || h.ErrorRate > 1.0 {
+			routeLogger.Error("invalid HTTP error rate",
+				"error_rate", h.ErrorRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("http.errorRate must be between 0.0 and 1.0 (probability), got %.2f", h.ErrorRate))
+			hasErrors = true
+		}
+		if h.ErrorStatus != 0 && (h.ErrorStatus < 400 || h.ErrorStatus > 599) {
+			routeLogger.Error("invalid HTTP error status",
+				"error_status", h.ErrorStatus,
+				"valid_range", "400-599",
+				"hint", fmt.Sprintf("http.errorStatus must be a 4xx or 5xx status (default %d), got %d", DefaultHTTPErrorStatus, h.ErrorStatus))
+			hasErrors = true
+		}
+		for i, rule := range h.Headers {
+			if !validateHeaderRule(rule, routeLogger.With("header_rule_index", i)) {
+				hasErrors = true
+			}
+		}
+		if b := h.Body; b != nil {
+			if b.TruncateRate < 0.0 || b.TruncateRate > 1.0 {
+				routeLogger.Error("invalid HTTP body truncate rate",
+					"truncate_rate", b.TruncateRate,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("http.body.truncateRate must be between 0.0 and 1.0 (probability), got %.2f", b.TruncateRate))
+				hasErrors = true
+			}
+			if b.CorruptRate < 0.0 || b.CorruptRate > 1.0 {
+				routeLogger.Error("invalid HTTP body corrupt rate",
+					"corrupt_rate", b.CorruptRate,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("http.body.corruptRate must be between 0.0 and 1.0 (probability), got %.2f", b.CorruptRate))
+				hasErrors = true
+			}
+		}
+		if h.ClockSkewMs > maxMilliseconds || h.ClockSkewMs < -maxMilliseconds {
+			routeLogger.Error("invalid HTTP clock skew",
+				"clock_skew_ms", h.ClockSkewMs,
+				"valid_range", fmt.Sprintf("%d-%d", -maxMilliseconds, maxMilliseconds),
+				"hint", fmt.Sprintf("http.clockSkewMs must be within about 24 days either way, got %dms", h.ClockSkewMs))
+			hasErrors = true
+		}
+		if h.Match != nil && !validateHTTPMatch(*h.Match, routeLogger) {
+			hasErrors = true
+		}
 	}
 
-	portMap := make(map[int]struct{})
-	hasErrors := false
+	switch config.ChaosKey {
+	case "", ChaosKeyRandom, ChaosKeyClientIP, ChaosKeyClientAddr:
+	default:
+		routeLogger.Error("invalid chaos key",
+			"chaos_key", config.ChaosKey,
+			"valid_values", []string{ChaosKeyRandom, ChaosKeyClientIP, ChaosKeyClientAddr},
+			"hint", fmt.Sprintf("chaosKey must be %q, %q or %q, got %q", ChaosKeyRandom, ChaosKeyClientIP, ChaosKeyClientAddr, config.ChaosKey))
+		hasErrors = true
+	}
 
-	for i, route := range routes {
-		if err := validateRouteConfig(route, i, configLogger); err != nil {
+	if config.BurstLoss != nil {
+		if config.DropRate > 0 {
+			routeLogger.Error("conflicting loss options",
+				"drop_rate", config.DropRate,
+				"hint", "burstLoss replaces dropRate; set only one of them")
 			hasErrors = true
 		}
 
-		if _, exists := portMap[route.LocalPort]; exists {
-			configLogger.Error("duplicate local port detected",
-				"port", route.LocalPort,
-				"route_index", i,
-				"hint", fmt.Sprintf("each route must use a unique localPort. Port %d is already used by another route", route.LocalPort))
-			hasErrors = true
-		} else {
-			portMap[route.LocalPort] = struct{}{}
+		probabilities := map[string]float64{
+			"goodToBad":    config.BurstLoss.GoodToBad,
+			"badToGood":    config.BurstLoss.BadToGood,
+			"goodDropRate": config.BurstLoss.GoodDropRate,
+			"badDropRate":  config.BurstLoss.BadRate(),
+		}
+		for _, field := range []string{"goodToBad", "badToGood", "goodDropRate", "badDropRate"} {
+			if value := probabilities[field]; value < 0.0 || value > 1.0 {
+				routeLogger.Error("invalid burst loss probability",
+					"field", "burstLoss."+field,
+					"value", value,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("burstLoss.%s must be between 0.0 and 1.0 (probability), got %.2f", field, value))
+				hasErrors = true
+			}
 		}
 	}
 
-	if hasErrors {
-		return fmt.Errorf("validation failed: see error messages above for details")
+	if tarpit := config.Tarpit; tarpit != nil {
+		if tarpit.WindowMs <= 0 {
+			routeLogger.Error("invalid tarpit window",
+				"window_ms", tarpit.WindowMs,
+				"valid_range", "> 0",
+				"hint", fmt.Sprintf("tarpit.windowMs must be > 0 (milliseconds), got %d", tarpit.WindowMs))
+			hasErrors = true
+		}
+		if tarpit.Threshold < 0 || tarpit.DelayStepMs < 0 || tarpit.MaxDelayMs < 0 || tarpit.RejectAfter < 0 {
+			routeLogger.Error("invalid tarpit settings",
+				"threshold", tarpit.Threshold,
+				"delay_step_ms", tarpit.DelayStepMs,
+				"max_delay_ms", tarpit.MaxDelayMs,
+				"reject_after", tarpit.RejectAfter,
+				"valid_range", ">= 0",
+				"hint", "tarpit.threshold, delayStepMs, maxDelayMs and rejectAfter must be >= 0")
+			hasErrors = true
+		}
 	}
 
-	return nil
-}
-
-func validateRouteConfig(config RouteConfig, routeIndex int, configLogger *slog.Logger) error {
-	hasErrors := false
-	routeLogger := configLogger.With("route_index", routeIndex)
+	if c := config.BandwidthCap; c != nil {
+		if c.UpstreamBytesPerSecond < 0 || c.DownstreamBytesPerSecond < 0 {
+			routeLogger.Error("invalid bandwidth cap",
+				"upstream_bytes_per_second", c.UpstreamBytesPerSecond,
+				"downstream_bytes_per_second", c.DownstreamBytesPerSecond,
+				"valid_range", ">= 0",
+				"hint", "bandwidthCap.upstreamBytesPerSecond and downstreamBytesPerSecond must be >= 0 (0 leaves that direction uncapped)")
+			hasErrors = true
+		} else if c.UpstreamBytesPerSecond == 0 && c.DownstreamBytesPerSecond == 0 {
+			routeLogger.Error("empty bandwidth cap",
+				"hint", "bandwidthCap needs upstreamBytesPerSecond, downstreamBytesPerSecond or both; remove it to leave the route uncapped")
+			hasErrors = true
+		}
+	}
 
-	// Validate local port - 0 isn't allowed. Require static port assignment.
-	if config.LocalPort <= 0 || config.LocalPort > 65535 {
-		routeLogger.Error("invalid local port",
-			"port", config.LocalPort,
-			"valid_range", "1-65535",
-			"hint", fmt.Sprintf("localPort must be between 1 and 65535, got %d", config.LocalPort))
-		hasErrors = true
+	if expect := config.Expect; expect != nil {
+		for _, check := range []struct {
+			field string
+			value *int64
+		}{
+			{"minConnections", expect.MinConnections},
+			{"maxConnections", expect.MaxConnections},
+			{"maxFailures", expect.MaxFailures},
+			{"maxConnectionBytes", expect.MaxConnectionBytes},
+		} {
+			field, value := check.field, check.value
+			if value != nil && *value < 0 {
+				routeLogger.Error("invalid expectation",
+					"field", "expect."+field,
+					"value", *value,
+					"valid_range", ">= 0",
+					"hint", fmt.Sprintf("expect.%s must be >= 0, got %d", field, *value))
+				hasErrors = true
+			}
+		}
+		if expect.MinConnections != nil && expect.MaxConnections != nil && *expect.MinConnections > *expect.MaxConnections {
+			routeLogger.Error("impossible expectation",
+				"min_connections", *expect.MinConnections,
+				"max_connections", *expect.MaxConnections,
+				"hint", "expect.minConnections must not exceed expect.maxConnections")
+			hasErrors = true
+		}
 	}
 
-	if config.Upstream == "" {
-		routeLogger.Error("upstream field is empty", "hint", "upstream must be in format 'ip:port' (e.g., '127.0.0.1:9090')")
-		hasErrors = true
-	} else {
-		host, port, err := net.SplitHostPort(config.Upstream)
-		if err != nil {
-			routeLogger.Error("invalid upstream format",
-				"upstream", config.Upstream,
+	if t := config.TLS; t != nil {
+		if t.CertFile == "" || t.KeyFile == "" {
+			routeLogger.Error("missing TLS certificate",
+				"cert_file", t.CertFile,
+				"key_file", t.KeyFile,
+				"hint", "tls needs both certFile and keyFile (PEM)")
+			hasErrors = true
+		} else if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			routeLogger.Error("invalid TLS certificate",
+				"cert_file", t.CertFile,
+				"key_file", t.KeyFile,
 				"error", err,
-				"hint", "upstream must be in format 'ip:port' (e.g., '127.0.0.1:9090' or '[::1]:9090' for IPv6)")
+				"hint", "tls.certFile and tls.keyFile must be a readable PEM certificate chain and its matching private key")
 			hasErrors = true
-		} else {
-			if net.ParseIP(host) == nil {
-				routeLogger.Error("upstream host is not a valid IP address",
-					"upstream", config.Upstream,
-					"host", host,
-					"hint", fmt.Sprintf("host must be an IP address (e.g., '127.0.0.1' or '[::1]'), not a hostname. Got %q", host))
+		}
+		if h := t.Handshake; h != nil {
+			if h.DelayMs < 0 {
+				routeLogger.Error("invalid TLS handshake delay",
+					"delay_ms", h.DelayMs,
+					"valid_range", ">= 0",
+					"hint", "tls.handshake.delayMs must be >= 0")
+				hasErrors = true
+			}
+			if rate := h.DelayRateOrDefault(); rate < 0.0 || rate > 1.0 {
+				routeLogger.Error("invalid TLS handshake delay rate",
+					"delay_rate", rate,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("tls.handshake.delayRate must be between 0.0 and 1.0 (probability), got %.2f", rate))
+				hasErrors = true
+			}
+			if h.AbortRate < 0.0 || h.AbortRate > 1.0 {
+				routeLogger.Error("invalid TLS handshake abort rate",
+					"abort_rate", h.AbortRate,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("tls.handshake.abortRate must be between 0.0 and 1.0 (probability), got %.2f", h.AbortRate))
+				hasErrors = true
+			}
+			if h.BadCertRate < 0.0 || h.BadCertRate > 1.0 {
+				routeLogger.Error("invalid TLS bad certificate rate",
+					"bad_cert_rate", h.BadCertRate,
+					"valid_range", "0.0-1.0",
+					"hint", fmt.Sprintf("tls.handshake.badCertRate must be between 0.0 and 1.0 (probability), got %.2f", h.BadCertRate))
+				hasErrors = true
+			}
+			if b := h.BadCertOrDefault(); b != BadCertExpired && b != BadCertSelfSigned {
+				routeLogger.Error("invalid TLS bad certificate",
+					"bad_cert", b,
+					"valid_values", []string{BadCertExpired, BadCertSelfSigned},
+					"hint", fmt.Sprintf("tls.handshake.badCert must be %q or %q", BadCertExpired, BadCertSelfSigned))
 				hasErrors = true
 			}
+		}
+		if config.CaptureClientHello {
+			routeLogger.Error("conflicting TLS options",
+				"hint", "a route that terminates TLS completes the handshake itself; remove captureClientHello or tls")
+			hasErrors = true
+		}
+	}
 
-			portNum, err := strconv.Atoi(port)
-			if err != nil {
-				routeLogger.Error("upstream port is not a number",
-					"upstream", config.Upstream,
-					"port", port,
+	if u := config.UpstreamTLS; u != nil && (u.CertFile != "" || u.KeyFile != "") {
+		if u.CertFile == "" || u.KeyFile == "" {
+			routeLogger.Error("missing upstream client certificate",
+				"cert_file", u.CertFile,
+				"key_file", u.KeyFile,
+				"hint", "a client certificate needs both upstreamTLS.certFile and upstreamTLS.keyFile (PEM)")
+			hasErrors = true
+		} else if _, err := tls.LoadX509KeyPair(u.CertFile, u.KeyFile); err != nil {
+			routeLogger.Error("invalid upstream client certificate",
+				"cert_file", u.CertFile,
+				"key_file", u.KeyFile,
+				"error", err,
+				"hint", "upstreamTLS.certFile and upstreamTLS.keyFile must be a readable PEM certificate chain and its matching private key")
+			hasErrors = true
+		}
+	}
+	if u := config.UpstreamTLS; u != nil && u.CAFile != "" {
+		bundle, err := os.ReadFile(u.CAFile)
+		if err == nil && !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			err = errors.New("no PEM certificates found")
+		}
+		if err != nil {
+			routeLogger.Error("invalid upstream CA bundle",
+				"ca_file", u.CAFile,
+				"error", err,
+				"hint", "upstreamTLS.caFile must be a readable file of PEM certificates")
+			hasErrors = true
+		}
+	}
+
+	if pp := config.ProxyProtocol; pp != nil && pp.Send != "" && pp.Send != ProxyProtocolV1 && pp.Send != ProxyProtocolV2 {
+		routeLogger.Error("invalid PROXY protocol version",
+			"send", pp.Send,
+			"valid_values", []string{ProxyProtocolV1, ProxyProtocolV2},
+			"hint", fmt.Sprintf("proxyProtocol.send must be %q, %q, or omitted to send no header", ProxyProtocolV1, ProxyProtocolV2))
+		hasErrors = true
+	}
+
+	if payload := config.PayloadLog; payload != nil {
+		if payload.MaxBytes < 0 || payload.MaxBytes > maxPayloadLogBytes {
+			routeLogger.Error("invalid payload log size",
+				"max_bytes", payload.MaxBytes,
+				"valid_range", fmt.Sprintf("0-%d", maxPayloadLogBytes),
+				"hint", fmt.Sprintf("payloadLog.maxBytes must be between 1 and %d (0 means %d), got %d", maxPayloadLogBytes, DefaultPayloadLogBytes, payload.MaxBytes))
+			hasErrors = true
+		}
+		for i, pattern := range payload.Redact {
+			if _, err := regexp.Compile(pattern); err != nil {
+				routeLogger.Error("invalid redaction pattern",
+					"pattern_index", i,
+					"pattern", pattern,
 					"error", err,
-					"hint", fmt.Sprintf("port must be a number between 1-65535, got %q", port))
-				hasErrors = true
-			} else if portNum <= 0 || portNum > 65535 {
-				routeLogger.Error("upstream port out of valid range",
-					"upstream", config.Upstream,
-					"port", portNum,
-					"valid_range", "1-65535",
-					"hint", fmt.Sprintf("port must be between 1 and 65535, got %d", portNum))
+					"hint", "payloadLog.redact entries must be valid Go regular expressions (RE2 syntax)")
 				hasErrors = true
 			}
 		}
 	}
 
-	if config.DropRate < 0.0 || config.DropRate > 1.0 {
-		routeLogger.Error("invalid drop rate",
-			"drop_rate", config.DropRate,
-			"valid_range", "0.0-1.0",
-			"hint", fmt.Sprintf("dropRate must be between 0.0 and 1.0 (probability), got %.2f", config.DropRate))
+	if config.Script != "" && !validateScript(config, routeLogger) {
 		hasErrors = true
 	}
 
-	if config.LatencyMs < 0 {
-		routeLogger.Error("invalid latency",
-			"latency_ms", config.LatencyMs,
+	for i, point := range config.LoadLatency {
+		if point.Connections < 0 || point.LatencyMs < 0 {
+			routeLogger.Error("invalid load latency point",
+				"point_index", i,
+				"connections", point.Connections,
+				"latency_ms", point.LatencyMs,
+				"valid_range", ">= 0",
+				"hint", "loadLatency connections and latencyMs must be >= 0")
+			hasErrors = true
+		}
+		if i > 0 && point.Connections <= config.LoadLatency[i-1].Connections {
+			routeLogger.Error("unordered load latency curve",
+				"point_index", i,
+				"connections", point.Connections,
+				"previous_connections", config.LoadLatency[i-1].Connections,
+				"hint", "loadLatency points must be sorted by strictly increasing connections")
+			hasErrors = true
+		}
+	}
+
+	if !validatePortRules(config, routeLogger) {
+		hasErrors = true
+	}
+
+	toxicNames := make(map[string]int)
+	for i, toxic := range config.Toxics {
+		toxicLogger := routeLogger.With("toxic_index", i)
+		if !validateToxicConfig(toxic, toxicLogger) {
+			hasErrors = true
+		}
+		if toxic.Name == "" {
+			continue
+		}
+		if other, ok := toxicNames[toxic.Name]; ok {
+			toxicLogger.Error("duplicate toxic name",
+				"toxic", toxic.Name,
+				"other_toxic_index", other,
+				"hint", "toxic names must be unique within a route")
+			hasErrors = true
+			continue
+		}
+		toxicNames[toxic.Name] = i
+	}
+
+	for _, entry := range config.ChaosClients {
+		if _, err := parseClientPrefix(entry); err != nil {
+			routeLogger.Error("invalid chaos client range",
+				"chaos_client", entry,
+				"error", err,
+				"hint", fmt.Sprintf("chaosClients entries must be CIDR ranges or IP addresses (e.g., '10.2.0.0/16' or '10.2.3.4'), got %q", entry))
+			hasErrors = true
+		}
+	}
+
+	if config.Tenant != "" && !isValidLabel(config.Tenant) {
+		routeLogger.Error("invalid tenant name",
+			"tenant", config.Tenant,
+			"hint", "tenant must contain only letters, digits, '-' or '_' (e.g., 'payments-team')")
+		hasErrors = true
+	}
+
+	if _, err := strconv.Atoi(config.Name); config.Name != "" && (!isValidLabel(config.Name) || err == nil) {
+		routeLogger.Error("invalid route name",
+			"name", config.Name,
+			"hint", "name must contain only letters, digits, '-' or '_', and not only digits, so it can't be mistaken for a port (e.g., 'checkout-db')")
+		hasErrors = true
+	}
+
+	for _, tag := range config.Tags {
+		if tag == "" || !isValidLabel(tag) {
+			routeLogger.Error("invalid route tag",
+				"tag", tag,
+				"hint", "tags must be non-empty and contain only letters, digits, '-' or '_' (e.g., 'payments' or 'game-day')")
+			hasErrors = true
+		}
+	}
+
+	if config.FlapIntervalMs < 0 || config.FlapDowntimeMs < 0 {
+		routeLogger.Error("invalid flap timing",
+			"flap_interval_ms", config.FlapIntervalMs,
+			"flap_downtime_ms", config.FlapDowntimeMs,
 			"valid_range", ">= 0",
-			"hint", fmt.Sprintf("latencyMs must be >= 0 (milliseconds), got %d", config.LatencyMs))
+			"hint", "flapIntervalMs and flapDowntimeMs must be >= 0 (milliseconds)")
+		hasErrors = true
+	} else if (config.FlapIntervalMs > 0) != (config.FlapDowntimeMs > 0) {
+		routeLogger.Error("incomplete flap configuration",
+			"flap_interval_ms", config.FlapIntervalMs,
+			"flap_downtime_ms", config.FlapDowntimeMs,
+			"hint", "flapIntervalMs and flapDowntimeMs must be set together")
 		hasErrors = true
 	}
 
@@ -147,3 +2371,291 @@ func validateRouteConfig(config RouteConfig, routeIndex int, configLogger *slog.
 
 	return nil
 }
+
+// validateScript logs every problem with the route's script and reports
+// whether it is valid.
+func validateScript(config RouteConfig, routeLogger *slog.Logger) bool {
+	s, err := script.Load(config.Script)
+	if err != nil {
+		routeLogger.Error("invalid chaos script",
+			"script", config.Script,
+			"error", err,
+			"hint", "script must be a readable file of rules, one per line, such as 'if client.requests > 100: error'; see the README's Scripting section")
+		return false
+	}
+
+	valid := true
+	for _, rule := range s.Rules() {
+		if rule.Action != script.Error {
+			continue
+		}
+		switch config.Mode {
+		case ModeHTTP, ModeHTTP2:
+			if rule.Status != 0 && (rule.Status < 400 || rule.Status > 599) {
+				routeLogger.Error("invalid script error status",
+					"script", config.Script,
+					"line", rule.Line,
+					"status", rule.Status,
+					"hint", fmt.Sprintf("error in a script must be followed by a 4xx or 5xx status, or nothing for %d, got %d", DefaultHTTPErrorStatus, rule.Status))
+				valid = false
+			}
+		case ModeGRPC:
+			if rule.Status < 0 || rule.Status > 16 {
+				routeLogger.Error("invalid script error status",
+					"script", config.Script,
+					"line", rule.Line,
+					"status", rule.Status,
+					"hint", fmt.Sprintf("in grpc mode, error in a script takes a gRPC status code between 1 and 16, or nothing for one of grpc.errorCodes, got %d", rule.Status))
+				valid = false
+			}
+		default:
+			routeLogger.Error("script error action without a request mode",
+				"script", config.Script,
+				"line", rule.Line,
+				"mode", config.Mode,
+				"hint", fmt.Sprintf("error answers requests, so it only applies to routes with mode %q, %q or %q; use drop or delay for connections", ModeHTTP, ModeHTTP2, ModeGRPC))
+			valid = false
+		}
+	}
+	return valid
+}
+
+// validateHeaderRule logs every problem with an HTTP header rule and reports
+// whether it is valid.
+func validateHeaderRule(rule HeaderRule, ruleLogger *slog.Logger) bool {
+	valid := true
+
+	switch rule.In {
+	case HeaderInRequest, HeaderInResponse:
+	default:
+		ruleLogger.Error("invalid header rule target",
+			"in", rule.In,
+			"valid_values", []string{HeaderInRequest, HeaderInResponse},
+			"hint", fmt.Sprintf("http.headers[].in must be %q or %q, got %q", HeaderInRequest, HeaderInResponse, rule.In))
+		valid = false
+	}
+
+	switch rule.Action {
+	case HeaderAdd, HeaderSet:
+		if strings.ContainsAny(rule.Value, "\r\n") {
+			ruleLogger.Error("invalid header value",
+				"header", rule.Name,
+				"hint", "header values must not contain line breaks")
+			valid = false
+		}
+	case HeaderRemove, HeaderCorrupt:
+		if rule.Value != "" {
+			ruleLogger.Error("header value without add or set",
+				"action", rule.Action,
+				"header", rule.Name,
+				"hint", fmt.Sprintf("value only applies to %q and %q header rules", HeaderAdd, HeaderSet))
+			valid = false
+		}
+	default:
+		ruleLogger.Error("invalid header rule action",
+			"action", rule.Action,
+			"valid_values", []string{HeaderAdd, HeaderSet, HeaderRemove, HeaderCorrupt},
+			"hint", fmt.Sprintf("http.headers[].action must be %q, %q, %q or %q, got %q", HeaderAdd, HeaderSet, HeaderRemove, HeaderCorrupt, rule.Action))
+		valid = false
+	}
+
+	if !isHeaderName(rule.Name) {
+		ruleLogger.Error("invalid header name",
+			"header", rule.Name,
+			"hint", "http.headers[].name must be a header field name such as 'ETag' (letters, digits and !#$%&'*+-.^_`|~)")
+		valid = false
+	}
+
+	// The proxy frames messages itself, so it can't send a message whose
+	// framing headers disagree with what it actually writes.
+	switch http.CanonicalHeaderKey(rule.Name) {
+	case "Host", "Transfer-Encoding":
+		ruleLogger.Error("unsupported header rule",
+			"header", rule.Name,
+			"hint", "Host and Transfer-Encoding frame the message and can't be rewritten")
+		valid = false
+	case "Content-Length":
+		if rule.In != HeaderInResponse || rule.Action != HeaderRemove {
+			ruleLogger.Error("unsupported header rule",
+				"header", rule.Name,
+				"in", rule.In,
+				"action", rule.Action,
+				"hint", "Content-Length can only be removed from responses, which then end when the connection closes")
+			valid = false
+		}
+	}
+
+	if rate := rule.RateOrDefault(); rate < 0.0 || rate > 1.0 {
+		ruleLogger.Error("invalid header rule rate",
+			"rate", rate,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("http.headers[].rate must be between 0.0 and 1.0 (probability), got %.2f", rate))
+		valid = false
+	}
+
+	return valid
+}
+
+// validateHTTPMatch logs every problem with an HTTP match and reports
+// whether it is valid.
+func validateHTTPMatch(match HTTPMatch, routeLogger *slog.Logger) bool {
+	valid := true
+
+	if len(match.Methods) == 0 && match.PathPrefix == "" && match.PathRegex == "" && len(match.Headers) == 0 {
+		routeLogger.Error("empty HTTP match",
+			"hint", "http.match needs at least one of methods, pathPrefix, pathRegex or headers; remove it to apply chaos to every request")
+		valid = false
+	}
+
+	for _, method := range match.Methods {
+		if !isHeaderName(method) {
+			routeLogger.Error("invalid HTTP match method",
+				"method", method,
+				"hint", "http.match.methods entries must be HTTP methods such as 'POST'")
+			valid = false
+		}
+	}
+
+	if match.PathPrefix != "" && !strings.HasPrefix(match.PathPrefix, "/") {
+		routeLogger.Error("invalid HTTP match path prefix",
+			"path_prefix", match.PathPrefix,
+			"hint", fmt.Sprintf("http.match.pathPrefix must start with '/', e.g. %q", "/"+match.PathPrefix))
+		valid = false
+	}
+
+	if match.PathRegex != "" {
+		if _, err := regexp.Compile(match.PathRegex); err != nil {
+			routeLogger.Error("invalid HTTP match path regex",
+				"path_regex", match.PathRegex,
+				"error", err,
+				"hint", "http.match.pathRegex must be a valid Go regular expression (RE2 syntax)")
+			valid = false
+		}
+	}
+
+	for name, value := range match.Headers {
+		if !isHeaderName(name) {
+			routeLogger.Error("invalid HTTP match header",
+				"header", name,
+				"hint", "http.match.headers keys must be header field names such as 'X-Tenant' (letters, digits and !#$%&'*+-.^_`|~)")
+			valid = false
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			routeLogger.Error("invalid HTTP match header",
+				"header", name,
+				"hint", "header values must not contain line breaks")
+			valid = false
+		}
+	}
+
+	return valid
+}
+
+// isHeaderName reports whether name is a valid HTTP field name (an RFC 9110
+// token).
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateToxicConfig logs every problem with a toxic and reports whether it
+// is valid.
+func validateToxicConfig(toxic ToxicConfig, toxicLogger *slog.Logger) bool {
+	valid := true
+
+	switch toxic.Type {
+	case ToxicLatency:
+		if toxic.LatencyMs < 0 || toxic.JitterMs < 0 {
+			toxicLogger.Error("invalid latency toxic",
+				"latency_ms", toxic.LatencyMs,
+				"jitter_ms", toxic.JitterMs,
+				"valid_range", ">= 0",
+				"hint", "latencyMs and jitterMs must be >= 0 (milliseconds)")
+			valid = false
+		}
+	case ToxicBandwidth:
+		if toxic.BytesPerSecond <= 0 {
+			toxicLogger.Error("invalid bandwidth toxic",
+				"bytes_per_second", toxic.BytesPerSecond,
+				"valid_range", "> 0",
+				"hint", fmt.Sprintf("bytesPerSecond must be > 0, got %d", toxic.BytesPerSecond))
+			valid = false
+		}
+	case ToxicCorrupt, ToxicDrop:
+		if toxic.Rate < 0.0 || toxic.Rate > 1.0 {
+			toxicLogger.Error("invalid toxic rate",
+				"type", toxic.Type,
+				"rate", toxic.Rate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("rate must be between 0.0 and 1.0 (probability), got %.2f", toxic.Rate))
+			valid = false
+		}
+	case ToxicTruncate:
+		if toxic.Bytes < 0 {
+			toxicLogger.Error("invalid truncate toxic",
+				"bytes", toxic.Bytes,
+				"valid_range", ">= 0",
+				"hint", fmt.Sprintf("bytes must be >= 0, got %d", toxic.Bytes))
+			valid = false
+		}
+	case ToxicSegment:
+		minBytes, maxBytes := toxic.SegmentBytes()
+		if toxic.MinBytes < 0 || toxic.MaxBytes < 0 || minBytes > maxBytes || toxic.LatencyMs < 0 {
+			toxicLogger.Error("invalid segment toxic",
+				"min_bytes", toxic.MinBytes,
+				"max_bytes", toxic.MaxBytes,
+				"latency_ms", toxic.LatencyMs,
+				"valid_range", "1 <= minBytes <= maxBytes, latencyMs >= 0",
+				"hint", fmt.Sprintf("minBytes (default %d) must not exceed maxBytes (default %d), and latencyMs must be >= 0", DefaultSegmentMinBytes, DefaultSegmentMaxBytes))
+			valid = false
+		}
+	default:
+		toxicLogger.Error("unknown toxic type",
+			"type", toxic.Type,
+			"valid_values", []string{ToxicLatency, ToxicBandwidth, ToxicCorrupt, ToxicTruncate, ToxicDrop, ToxicSegment},
+			"hint", fmt.Sprintf("toxic type must be one of latency, bandwidth, corrupt, truncate, drop or segment, got %q", toxic.Type))
+		valid = false
+	}
+
+	switch toxic.Stream {
+	case "", StreamBoth, StreamDownstream, StreamUpstream:
+	default:
+		toxicLogger.Error("invalid toxic stream",
+			"stream", toxic.Stream,
+			"valid_values", []string{StreamBoth, StreamDownstream, StreamUpstream},
+			"hint", fmt.Sprintf("stream must be %q, %q or %q, got %q", StreamBoth, StreamDownstream, StreamUpstream, toxic.Stream))
+		valid = false
+	}
+
+	if toxicity := toxic.ToxicityOrDefault(); toxicity < 0.0 || toxicity > 1.0 {
+		toxicLogger.Error("invalid toxicity",
+			"toxicity", toxicity,
+			"valid_range", "0.0-1.0",
+			"hint", fmt.Sprintf("toxicity must be between 0.0 and 1.0 (probability), got %.2f", toxicity))
+		valid = false
+	}
+
+	return valid
+}
+
+// isValidLabel reports whether name is safe to use as a log and metrics label.
+func isValidLabel(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
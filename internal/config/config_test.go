@@ -1,10 +1,20 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"log/slog"
+	"math/big"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // testLogger creates a silent logger for tests (only errors)
@@ -64,6 +74,21 @@ func TestLoadConfig(t *testing.T) {
 			fileContent: `{invalid json}`,
 			wantErr:     true,
 		},
+		{
+			name: "JSON5 with comments and trailing commas",
+			fileContent: `{
+				// Shared by the checkout and billing teams.
+				routes: [
+					{
+						localPort: 8080,
+						upstream: '127.0.0.1:9090',
+						dropRate: 0.1, /* reproduces INC-1234 */
+					},
+				],
+			}`,
+			wantErr: false,
+			wantLen: 1,
+		},
 		{
 			name: "unknown fields should error",
 			fileContent: `[
@@ -150,6 +175,66 @@ func TestLoadConfig_ValidFields(t *testing.T) {
 	}
 }
 
+func TestLoadConfigWithOptions_AutoPort(t *testing.T) {
+	fileContent := `[
+		{"localPort": 0, "upstream": "127.0.0.1:9090"},
+		{"localPort": 0, "upstream": "127.0.0.1:9091"},
+		{"localPort": 8080, "upstream": "127.0.0.1:9092"}
+	]`
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	if err := os.WriteFile(configPath, []byte(fileContent), 0644); err != nil {
+		t.Fatalf("failed to write test config file: %v", err)
+	}
+
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("LoadConfig() expected error for localPort 0 without AllowAutoPort, got nil")
+	}
+
+	config, err := LoadConfigWithOptions(configPath, LoadOptions{AllowAutoPort: true})
+	if err != nil {
+		t.Fatalf("LoadConfigWithOptions() unexpected error: %v", err)
+	}
+	if len(config) != 3 {
+		t.Errorf("expected 3 routes, got %d", len(config))
+	}
+}
+
+func TestLoadConfig_BurstLossDefaults(t *testing.T) {
+	fileContent := `[
+		{
+			"localPort": 8080,
+			"upstream": "127.0.0.1:9090",
+			"burstLoss": {"goodToBad": 0.05, "badToGood": 0.3}
+		}
+	]`
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	if err := os.WriteFile(configPath, []byte(fileContent), 0644); err != nil {
+		t.Fatalf("failed to write test config file: %v", err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() unexpected error: %v", err)
+	}
+
+	burst := config[0].BurstLoss
+	if burst == nil {
+		t.Fatal("BurstLoss = nil, want parsed model")
+	}
+	if burst.GoodDropRate != 0.0 {
+		t.Errorf("GoodDropRate = %f, want 0.0", burst.GoodDropRate)
+	}
+	if burst.BadRate() != 1.0 {
+		t.Errorf("BadRate() = %f, want default 1.0", burst.BadRate())
+	}
+}
+
 func TestLoadConfig_ValidationErrors(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -434,6 +519,23 @@ func TestLoadConfig_DuplicatePorts(t *testing.T) {
 			wantErr:     true,
 			errContains: "validation failed",
 		},
+		{
+			name: "duplicate route names",
+			fileContent: `[
+				{
+					"name": "orders",
+					"localPort": 8080,
+					"upstream": "127.0.0.1:9090"
+				},
+				{
+					"name": "orders",
+					"localPort": 8081,
+					"upstream": "127.0.0.1:9091"
+				}
+			]`,
+			wantErr:     true,
+			errContains: "validation failed",
+		},
 	}
 
 	for _, tt := range tests {
@@ -507,6 +609,65 @@ func TestValidateConfig(t *testing.T) {
 			wantErrLen:  1,
 			errContains: []string{"cannot use duplicate local port"},
 		},
+		{
+			name: "baseline port collides with local port",
+			routes: []RouteConfig{
+				{
+					LocalPort:    8080,
+					Upstream:     "127.0.0.1:9090",
+					BaselinePort: 8081,
+				},
+				{
+					LocalPort: 8081,
+					Upstream:  "127.0.0.1:9091",
+				},
+			},
+			wantErrLen:  1,
+			errContains: []string{"duplicate baseline port"},
+		},
+		{
+			name: "chained routes",
+			routes: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:8081"},
+				{LocalPort: 8081, Upstream: "127.0.0.1:9090"},
+			},
+			wantErrLen: 0,
+		},
+		{
+			name: "chain loops back",
+			routes: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:8081"},
+				{LocalPort: 8081, Upstream: "127.0.0.1:8082"},
+				{LocalPort: 8082, Upstream: "127.0.0.1:8080"},
+			},
+			wantErrLen:  1,
+			errContains: []string{"route chain loops back on itself"},
+		},
+		{
+			name: "route points at itself",
+			routes: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:8080"},
+			},
+			wantErrLen:  1,
+			errContains: []string{"route chain loops back on itself"},
+		},
+		{
+			name: "duplicate handoff socket",
+			routes: []RouteConfig{
+				{
+					LocalPort:     8080,
+					Upstream:      "127.0.0.1:9090",
+					HandoffSocket: "/tmp/chaos.sock",
+				},
+				{
+					LocalPort:     8081,
+					Upstream:      "127.0.0.1:9091",
+					HandoffSocket: "/tmp/./chaos.sock",
+				},
+			},
+			wantErrLen:  1,
+			errContains: []string{"duplicate handoff socket"},
+		},
 		{
 			name: "invalid route and duplicate port",
 			routes: []RouteConfig{
@@ -550,7 +711,7 @@ func TestValidateConfig(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := testLogger().With("file", "test-config.json")
-			err := validateConfig(tt.routes, logger)
+			err := validateConfig(tt.routes, LoadOptions{}, logger)
 
 			if (err != nil) != (tt.wantErrLen > 0) {
 				t.Errorf("validateConfig() error = %v, wantErr %v", err, tt.wantErrLen > 0)
@@ -566,6 +727,132 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestRouteConfig_Baseline(t *testing.T) {
+	route := RouteConfig{
+		Tenant:         "payments",
+		LocalPort:      8080,
+		Upstream:       "127.0.0.1:9090",
+		DropRate:       0.5,
+		LatencyMs:      200,
+		FlapIntervalMs: 1000,
+		FlapDowntimeMs: 100,
+		ReorderRate:    0.3,
+		BaselinePort:   8090,
+		Toxics:         []ToxicConfig{{Type: ToxicDrop, Rate: 0.1}},
+
+		CaptureClientHello: true,
+		ProxyProtocol:      &ProxyProtocolConfig{Accept: true, Send: ProxyProtocolV1},
+	}
+
+	want := RouteConfig{
+		Tenant:             "payments",
+		LocalPort:          8090,
+		Upstream:           "127.0.0.1:9090",
+		CaptureClientHello: true,
+		ProxyProtocol:      &ProxyProtocolConfig{Accept: true, Send: ProxyProtocolV1},
+	}
+
+	if got := route.Baseline(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Baseline() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_WithoutChaos_SOCKS5(t *testing.T) {
+	route := RouteConfig{LocalPort: 1080, Mode: ModeSOCKS5, DropRate: 0.5}
+
+	want := RouteConfig{LocalPort: 1080, Mode: ModeSOCKS5}
+	if got := route.WithoutChaos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutChaos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_WithoutChaos_Transparent(t *testing.T) {
+	route := RouteConfig{LocalPort: 15001, Mode: ModeTransparent, LatencyMs: 100}
+
+	want := RouteConfig{LocalPort: 15001, Mode: ModeTransparent}
+	if got := route.WithoutChaos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutChaos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_WithoutChaos_Stub(t *testing.T) {
+	stub := &StubConfig{Body: "ok", Status: 503}
+	route := RouteConfig{LocalPort: 8080, Mode: ModeHTTP, HTTP: &HTTPConfig{ErrorRate: 0.5}, Stub: stub}
+
+	want := RouteConfig{LocalPort: 8080, Mode: ModeHTTP, Stub: stub}
+	if got := route.WithoutChaos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutChaos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_WithoutChaos_Timeouts(t *testing.T) {
+	route := RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", IdleTimeoutMs: 1000, ReadTimeoutMs: 30000, WriteTimeoutMs: 5000}
+
+	// Idle timeouts are chaos; read and write timeouts protect the proxy.
+	want := RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", ReadTimeoutMs: 30000, WriteTimeoutMs: 5000}
+	if got := route.WithoutChaos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutChaos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_WithoutChaos_BufferBytes(t *testing.T) {
+	route := RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", LatencyMs: 100, BufferBytes: 4096}
+
+	want := RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", BufferBytes: 4096}
+	if got := route.WithoutChaos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutChaos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteConfig_Relisten(t *testing.T) {
+	route := RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090"}
+	tests := []struct {
+		name string
+		next func(r *RouteConfig)
+		want bool
+	}{
+		{name: "chaos settings", next: func(r *RouteConfig) { r.LatencyMs = 100; r.DropRate = 0.5 }, want: false},
+		{name: "upstream", next: func(r *RouteConfig) { r.Upstream = "127.0.0.1:9091" }, want: false},
+		{name: "http mode", next: func(r *RouteConfig) { r.Mode = ModeHTTP }, want: false},
+		{name: "port", next: func(r *RouteConfig) { r.LocalPort = 8081 }, want: true},
+		{name: "udp", next: func(r *RouteConfig) { r.Protocol = ProtocolUDP }, want: true},
+		{name: "transparent mode", next: func(r *RouteConfig) { r.Mode = ModeTransparent }, want: true},
+		{name: "handoff socket", next: func(r *RouteConfig) { r.HandoffSocket = "/tmp/handoff.sock" }, want: true},
+		{name: "accept listeners", next: func(r *RouteConfig) { r.AcceptListeners = 4 }, want: true},
+		{name: "single accept listener spelled out", next: func(r *RouteConfig) { r.AcceptListeners = 1 }, want: false},
+		{name: "listen address", next: func(r *RouteConfig) { r.ListenAddress = "0.0.0.0" }, want: true},
+		{name: "default listen address spelled out", next: func(r *RouteConfig) { r.ListenAddress = ListenHost }, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := route
+			tt.next(&next)
+			if got := route.Relisten(next); got != tt.want {
+				t.Errorf("Relisten() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_ChaosPrefixes(t *testing.T) {
+	route := RouteConfig{
+		ChaosClients: []string{"10.2.3.4/16", "192.168.1.7", "not-an-ip"},
+	}
+
+	prefixes := route.ChaosPrefixes()
+	want := []string{"10.2.0.0/16", "192.168.1.7/32"}
+
+	if len(prefixes) != len(want) {
+		t.Fatalf("ChaosPrefixes() = %v, want %v", prefixes, want)
+	}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("ChaosPrefixes()[%d] = %s, want %s", i, prefix, want[i])
+		}
+	}
+}
+
 func TestValidateRouteConfig(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -777,38 +1064,2118 @@ func TestValidateRouteConfig(t *testing.T) {
 			wantErr:     true,
 			errContains: "invalid upstream port",
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			logger := testLogger().With("file", "test-config.json")
-			err := validateRouteConfig(tt.config, 0, logger)
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("validateRouteConfig() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			// Since detailed errors are logged via slog, we just verify an error was returned
-			// The specific error details are checked through slog output
-			if tt.wantErr && err != nil && !contains(err.Error(), "validation failed") {
-				t.Errorf("validateRouteConfig() error = %v, want error containing 'validation failed'", err)
-			}
-		})
-	}
-}
-
-// Helper function to check if a string contains a substring
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
-		(len(s) > 0 && len(substr) > 0 && stringContains(s, substr)))
-}
-
-func stringContains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
+		{
+			name: "valid reorder config",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				ReorderRate:   0.2,
+				ReorderWindow: 4,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid reorder rate",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				ReorderRate: 1.5,
+			},
+			wantErr:     true,
+			errContains: "invalid reorder rate",
+		},
+		{
+			name: "negative reorder window",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				ReorderWindow: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid reorder window",
+		},
+		{
+			name: "invalid duplicate rate",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				DuplicateRate: -0.1,
+			},
+			wantErr:     true,
+			errContains: "invalid duplicate rate",
+		},
+		{
+			name: "valid buffer size",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				BufferBytes: 4096,
+			},
+			wantErr: false,
+		},
+		{
+			name: "buffer size too small",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				BufferBytes: 64,
+			},
+			wantErr:     true,
+			errContains: "invalid buffer size",
+		},
+		{
+			name: "buffer size over UDP",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				Protocol:    ProtocolUDP,
+				BufferBytes: 4096,
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "fallback upstream",
+			config: RouteConfig{
+				LocalPort:             8080,
+				Upstream:              "127.0.0.1:9090",
+				FallbackUpstream:      "127.0.0.1:9091",
+				FallbackAfterFailures: 3,
+			},
+			wantErr: false,
+		},
+		{
+			name: "fallback upstream with a hostname",
+			config: RouteConfig{
+				LocalPort:        8080,
+				Upstream:         "127.0.0.1:9090",
+				FallbackUpstream: "backup:9091",
+			},
+			wantErr:     true,
+			errContains: "invalid fallback upstream",
+		},
+		{
+			name: "fallback upstream same as upstream",
+			config: RouteConfig{
+				LocalPort:        8080,
+				Upstream:         "127.0.0.1:9090",
+				FallbackUpstream: "127.0.0.1:9090",
+			},
+			wantErr:     true,
+			errContains: "fallback upstream same as upstream",
+		},
+		{
+			name: "fallback upstream with socks5 mode",
+			config: RouteConfig{
+				LocalPort:        8080,
+				Mode:             ModeSOCKS5,
+				FallbackUpstream: "127.0.0.1:9091",
+			},
+			wantErr:     true,
+			errContains: "fallback upstream without upstream",
+		},
+		{
+			name: "fallback failure count without fallback upstream",
+			config: RouteConfig{
+				LocalPort:             8080,
+				Upstream:              "127.0.0.1:9090",
+				FallbackAfterFailures: 2,
+			},
+			wantErr:     true,
+			errContains: "fallback failure count without fallback upstream",
+		},
+		{
+			name: "dial timeout and retries",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				DialTimeoutMs: 500,
+				DialRetries:   3,
+				DialBackoffMs: 100,
+			},
+			wantErr: false,
+		},
+		{
+			name: "too many dial retries",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				DialRetries: MaxDialRetries + 1,
+			},
+			wantErr:     true,
+			errContains: "invalid dial retry count",
+		},
+		{
+			name: "dial backoff without retries",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				DialBackoffMs: 100,
+			},
+			wantErr:     true,
+			errContains: "dial backoff without retries",
+		},
+		{
+			name: "negative buffered byte limit",
+			config: RouteConfig{
+				LocalPort:        8080,
+				Upstream:         "127.0.0.1:9090",
+				MaxBufferedBytes: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid buffered byte limit",
+		},
+		{
+			name: "valid accept listeners",
+			config: RouteConfig{
+				LocalPort:       8080,
+				Upstream:        "127.0.0.1:9090",
+				AcceptListeners: 8,
+			},
+			wantErr: false,
+		},
+		{
+			name: "too many accept listeners",
+			config: RouteConfig{
+				LocalPort:       8080,
+				Upstream:        "127.0.0.1:9090",
+				AcceptListeners: MaxAcceptListeners + 1,
+			},
+			wantErr:     true,
+			errContains: "invalid accept listener count",
+		},
+		{
+			name: "accept listeners in transparent mode",
+			config: RouteConfig{
+				LocalPort:       8080,
+				Mode:            ModeTransparent,
+				AcceptListeners: 4,
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "valid port rules",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				PortRules: []PortRule{
+					{Ports: PortRange{5432, 5432}, DropRate: float64Ptr(0.2)},
+					{Ports: PortRange{6379, 6380}, Toxics: []ToxicConfig{{Type: ToxicBandwidth, BytesPerSecond: 1024}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "port rules without transparent mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				PortRules: []PortRule{{Ports: PortRange{5432, 5432}, DropRate: float64Ptr(0.2)}},
+			},
+			wantErr:     true,
+			errContains: "port rules without transparent mode",
+		},
+		{
+			name: "port rule range backwards",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				PortRules: []PortRule{{Ports: PortRange{6380, 6379}}},
+			},
+			wantErr:     true,
+			errContains: "invalid port rule ports",
+		},
+		{
+			name: "overlapping port rules",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				PortRules: []PortRule{{Ports: PortRange{6379, 6390}}, {Ports: PortRange{6385, 6385}}},
+			},
+			wantErr:     true,
+			errContains: "overlapping port rules",
+		},
+		{
+			name: "port rule drop rate out of range",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				PortRules: []PortRule{{Ports: PortRange{5432, 5432}, DropRate: float64Ptr(2)}},
+			},
+			wantErr:     true,
+			errContains: "invalid drop rate",
+		},
+		{
+			name: "port rule with invalid toxic",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				PortRules: []PortRule{{Ports: PortRange{5432, 5432}, Toxics: []ToxicConfig{{Type: "melt"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "accept listeners over UDP",
+			config: RouteConfig{
+				LocalPort:       8080,
+				Upstream:        "127.0.0.1:9090",
+				Protocol:        ProtocolUDP,
+				AcceptListeners: 4,
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "valid read and write timeouts",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				ReadTimeoutMs:  30000,
+				WriteTimeoutMs: 5000,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative write timeout",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				WriteTimeoutMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid read or write timeout",
+		},
+		{
+			name: "valid connection limit with a queue",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				MaxConnections: 2,
+				Overflow:       OverflowQueue,
+				QueueTimeoutMs: 500,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative connection limit",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				MaxConnections: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid connection limit",
+		},
+		{
+			name: "invalid overflow",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				MaxConnections: 2,
+				Overflow:       "drop",
+			},
+			wantErr:     true,
+			errContains: "invalid overflow",
+		},
+		{
+			name: "overflow without a connection limit",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Overflow:  OverflowReset,
+			},
+			wantErr:     true,
+			errContains: "overflow without a connection limit",
+		},
+		{
+			name: "queue timeout without a queue",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				MaxConnections: 2,
+				QueueTimeoutMs: 500,
+			},
+			wantErr:     true,
+			errContains: "queue timeout without a queue",
+		},
+		{
+			name: "valid connection rate",
+			config: RouteConfig{
+				LocalPort:               8080,
+				Upstream:                "127.0.0.1:9090",
+				MaxConnectionsPerSecond: 0.5,
+				AcceptThrottle:          AcceptThrottleReject,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative connection rate",
+			config: RouteConfig{
+				LocalPort:               8080,
+				Upstream:                "127.0.0.1:9090",
+				MaxConnectionsPerSecond: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid connection rate",
+		},
+		{
+			name: "invalid accept throttle",
+			config: RouteConfig{
+				LocalPort:               8080,
+				Upstream:                "127.0.0.1:9090",
+				MaxConnectionsPerSecond: 10,
+				AcceptThrottle:          "drop",
+			},
+			wantErr:     true,
+			errContains: "invalid accept throttle",
+		},
+		{
+			name: "accept throttle without a connection rate",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				AcceptThrottle: AcceptThrottleDelay,
+			},
+			wantErr:     true,
+			errContains: "accept throttle without a connection rate",
+		},
+		{
+			name: "valid burst loss",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				BurstLoss: &BurstLossConfig{GoodToBad: 0.05, BadToGood: 0.3},
+			},
+			wantErr: false,
+		},
+		{
+			name: "burst loss with drop rate",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				DropRate:  0.1,
+				BurstLoss: &BurstLossConfig{GoodToBad: 0.05, BadToGood: 0.3},
+			},
+			wantErr:     true,
+			errContains: "conflicting loss options",
+		},
+		{
+			name: "burst loss probability out of range",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				BurstLoss: &BurstLossConfig{GoodToBad: 1.5, BadToGood: 0.3},
+			},
+			wantErr:     true,
+			errContains: "invalid burst loss probability",
+		},
+		{
+			name: "valid tarpit",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Tarpit:    &TarpitConfig{WindowMs: 1000, Threshold: 3, DelayStepMs: 100, MaxDelayMs: 2000, RejectAfter: 10},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tarpit without window",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Tarpit:    &TarpitConfig{Threshold: 3, DelayStepMs: 100},
+			},
+			wantErr:     true,
+			errContains: "invalid tarpit window",
+		},
+		{
+			name: "tarpit negative threshold",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Tarpit:    &TarpitConfig{WindowMs: 1000, Threshold: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid tarpit settings",
+		},
+		{
+			name: "valid bandwidth cap",
+			config: RouteConfig{
+				LocalPort:    8080,
+				Upstream:     "127.0.0.1:9090",
+				BandwidthCap: &BandwidthCapConfig{UpstreamBytesPerSecond: 128 << 10},
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative bandwidth cap",
+			config: RouteConfig{
+				LocalPort:    8080,
+				Upstream:     "127.0.0.1:9090",
+				BandwidthCap: &BandwidthCapConfig{UpstreamBytesPerSecond: 1000, DownstreamBytesPerSecond: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid bandwidth cap",
+		},
+		{
+			name: "empty bandwidth cap",
+			config: RouteConfig{
+				LocalPort:    8080,
+				Upstream:     "127.0.0.1:9090",
+				BandwidthCap: &BandwidthCapConfig{},
+			},
+			wantErr:     true,
+			errContains: "empty bandwidth cap",
+		},
+		{
+			name: "negative expectation",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Expect:    &Expectations{MaxFailures: int64Ptr(-1)},
+			},
+			wantErr:     true,
+			errContains: "invalid expectation",
+		},
+		{
+			name: "min connections above max",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Expect:    &Expectations{MinConnections: int64Ptr(5), MaxConnections: int64Ptr(2)},
+			},
+			wantErr:     true,
+			errContains: "impossible expectation",
+		},
+		{
+			name: "valid chaos key",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				DropRate:  0.2,
+				ChaosKey:  ChaosKeyClientIP,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown chaos key",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				ChaosKey:  "clientPort",
+			},
+			wantErr:     true,
+			errContains: "invalid chaos key",
+		},
+		{
+			name: "valid exact drop mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				DropRate:  0.25,
+				DropMode:  DropModeExact,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown drop mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				DropMode:  "sometimes",
+			},
+			wantErr:     true,
+			errContains: "invalid drop mode",
+		},
+		{
+			name: "exact drop mode with burst loss",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				DropMode:  DropModeExact,
+				BurstLoss: &BurstLossConfig{GoodToBad: 0.1, BadToGood: 0.5},
+			},
+			wantErr:     true,
+			errContains: "conflicting loss options",
+		},
+		{
+			name: "valid chaos clients",
+			config: RouteConfig{
+				LocalPort:    8080,
+				Upstream:     "127.0.0.1:9090",
+				ChaosClients: []string{"10.2.0.0/16", "192.168.1.7", "fd00::/8"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid chaos client range",
+			config: RouteConfig{
+				LocalPort:    8080,
+				Upstream:     "127.0.0.1:9090",
+				ChaosClients: []string{"10.2.0.0/33"},
+			},
+			wantErr:     true,
+			errContains: "invalid chaos client range",
+		},
+		{
+			name: "negative connection lifetime",
+			config: RouteConfig{
+				LocalPort:               8080,
+				Upstream:                "127.0.0.1:9090",
+				MaxConnectionLifetimeMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid connection lifetime",
+		},
+		{
+			name: "dial failure rate above one",
+			config: RouteConfig{
+				LocalPort:       8080,
+				Upstream:        "127.0.0.1:9090",
+				DialFailureRate: 1.5,
+			},
+			wantErr:     true,
+			errContains: "invalid dial failure rate",
+		},
+		{
+			name: "invalid redaction pattern",
+			config: RouteConfig{
+				LocalPort:  8080,
+				Upstream:   "127.0.0.1:9090",
+				PayloadLog: &PayloadLogConfig{Redact: []string{"(unclosed"}},
+			},
+			wantErr:     true,
+			errContains: "invalid redaction pattern",
+		},
+		{
+			name: "payload log size too large",
+			config: RouteConfig{
+				LocalPort:  8080,
+				Upstream:   "127.0.0.1:9090",
+				PayloadLog: &PayloadLogConfig{MaxBytes: 1 << 20},
+			},
+			wantErr:     true,
+			errContains: "invalid payload log size",
+		},
+		{
+			name: "valid http mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 500},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid udp route",
+			config: RouteConfig{
+				LocalPort:     5353,
+				Upstream:      "127.0.0.1:53",
+				Protocol:      ProtocolUDP,
+				LatencyMs:     20,
+				DuplicateRate: 0.05,
+				ReorderRate:   0.05,
+				BurstLoss:     &BurstLossConfig{GoodToBad: 0.01, BadToGood: 0.3},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown protocol",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Protocol:  "sctp",
+			},
+			wantErr:     true,
+			errContains: "invalid protocol",
+		},
+		{
+			name: "udp route with tcp-only options",
+			config: RouteConfig{
+				LocalPort:     5353,
+				Upstream:      "127.0.0.1:53",
+				Protocol:      ProtocolUDP,
+				Mode:          ModeHTTP,
+				IdleTimeoutMs: 1000,
+				Toxics:        []ToxicConfig{{Type: ToxicLatency, LatencyMs: 10}},
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "valid socks5 mode",
+			config: RouteConfig{
+				LocalPort:          1080,
+				Mode:               ModeSOCKS5,
+				DropRate:           0.1,
+				CaptureClientHello: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "socks5 mode with upstream",
+			config: RouteConfig{
+				LocalPort: 1080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeSOCKS5,
+			},
+			wantErr:     true,
+			errContains: "upstream with socks5 mode",
+		},
+		{
+			name: "socks5 mode with upstream tls",
+			config: RouteConfig{
+				LocalPort:   1080,
+				Mode:        ModeSOCKS5,
+				UpstreamTLS: &UpstreamTLSConfig{InsecureSkipVerify: true},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "socks5 mode over udp",
+			config: RouteConfig{
+				LocalPort: 1080,
+				Protocol:  ProtocolUDP,
+				Mode:      ModeSOCKS5,
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "valid transparent mode",
+			config: RouteConfig{
+				LocalPort:          15001,
+				Mode:               ModeTransparent,
+				LatencyMs:          100,
+				CaptureClientHello: true,
+				ProxyProtocol:      &ProxyProtocolConfig{Send: ProxyProtocolV2},
+			},
+			wantErr: false,
+		},
+		{
+			name: "transparent mode with upstream",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeTransparent,
+			},
+			wantErr:     true,
+			errContains: "upstream with transparent mode",
+		},
+		{
+			name: "transparent mode with tls",
+			config: RouteConfig{
+				LocalPort: 15001,
+				Mode:      ModeTransparent,
+				TLS:       &TLSConfig{CertFile: "proxy.crt", KeyFile: "proxy.key"},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "transparent mode accepting proxy protocol",
+			config: RouteConfig{
+				LocalPort:     15001,
+				Mode:          ModeTransparent,
+				ProxyProtocol: &ProxyProtocolConfig{Accept: true},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "valid proxy protocol",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				ProxyProtocol: &ProxyProtocolConfig{Accept: true, Send: ProxyProtocolV2},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown proxy protocol version",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				ProxyProtocol: &ProxyProtocolConfig{Send: "2"},
+			},
+			wantErr:     true,
+			errContains: "invalid PROXY protocol version",
+		},
+		{
+			name: "proxy protocol over udp",
+			config: RouteConfig{
+				LocalPort:     5353,
+				Upstream:      "127.0.0.1:53",
+				Protocol:      ProtocolUDP,
+				ProxyProtocol: &ProxyProtocolConfig{Accept: true},
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "unknown mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      "udp",
+			},
+			wantErr:     true,
+			errContains: "invalid mode",
+		},
+		{
+			name: "http settings without http mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				HTTP:      &HTTPConfig{ErrorRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "http settings without http mode",
+		},
+		{
+			name: "valid http2 mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP2,
+				DropRate:  0.1,
+				HTTP2:     &HTTP2Config{ResetRate: 0.1},
+			},
+			wantErr: false,
+		},
+		{
+			name: "http2 settings without http2 mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				HTTP2:     &HTTP2Config{ResetRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "http2 settings without http2 mode",
+		},
+		{
+			name: "http2 reset rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP2,
+				HTTP2:     &HTTP2Config{ResetRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP/2 reset rate",
+		},
+		{
+			name: "http2 mode with captureClientHello",
+			config: RouteConfig{
+				LocalPort:          8080,
+				Upstream:           "127.0.0.1:9090",
+				Mode:               ModeHTTP2,
+				CaptureClientHello: true,
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "valid grpc mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{ErrorRate: 0.1, ErrorCodes: []string{"UNAVAILABLE", "DEADLINE_EXCEEDED"}, MessageDelayMs: 50, MessageDelayRate: float64Ptr(0.2)},
+			},
+			wantErr: false,
+		},
+		{
+			name: "grpc settings without grpc mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				GRPC:      &GRPCConfig{ErrorRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "grpc settings without grpc mode",
+		},
+		{
+			name: "http settings in grpc mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				HTTP:      &HTTPConfig{ErrorRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "http settings without http mode",
+		},
+		{
+			name: "grpc error rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{ErrorRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid gRPC error rate",
+		},
+		{
+			name: "unknown grpc error code",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{ErrorRate: 0.1, ErrorCodes: []string{"unavailable"}},
+			},
+			wantErr:     true,
+			errContains: "invalid gRPC error code",
+		},
+		{
+			name: "grpc OK is not an error",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{ErrorRate: 0.1, ErrorCodes: []string{"OK"}},
+			},
+			wantErr:     true,
+			errContains: "invalid gRPC error code",
+		},
+		{
+			name: "negative grpc message delay",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{MessageDelayMs: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid gRPC message delay",
+		},
+		{
+			name: "grpc message delay rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeGRPC,
+				GRPC:      &GRPCConfig{MessageDelayMs: 10, MessageDelayRate: float64Ptr(2)},
+			},
+			wantErr:     true,
+			errContains: "invalid gRPC message delay rate",
+		},
+		{
+			name: "valid dns mode over udp",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Protocol:  ProtocolUDP,
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{ErrorRate: 0.1, ErrorCodes: []string{"SERVFAIL", "NXDOMAIN"}, DelayMs: 200, DelayRate: float64Ptr(0.5), TTLRate: 0.2, TTL: 86400},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid dns mode over tcp",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DropRate:  0.1,
+			},
+			wantErr: false,
+		},
+		{
+			name: "dns settings without dns mode",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Protocol:  ProtocolUDP,
+				DNS:       &DNSConfig{ErrorRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "dns settings without dns mode",
+		},
+		{
+			name: "http mode over udp",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Protocol:  ProtocolUDP,
+				Mode:      ModeHTTP,
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "dns error rate too high",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{ErrorRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS error rate",
+		},
+		{
+			name: "unknown dns error code",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{ErrorRate: 0.1, ErrorCodes: []string{"nxdomain"}},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS error code",
+		},
+		{
+			name: "negative dns delay",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{DelayMs: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS delay",
+		},
+		{
+			name: "dns delay rate too high",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{DelayMs: 10, DelayRate: float64Ptr(2)},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS delay rate",
+		},
+		{
+			name: "dns ttl rate too high",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{TTLRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS TTL rate",
+		},
+		{
+			name: "negative dns ttl",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Mode:      ModeDNS,
+				DNS:       &DNSConfig{TTLRate: 0.5, TTL: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid DNS TTL",
+		},
+		{
+			name: "valid tcp stub",
+			config: RouteConfig{
+				LocalPort: 6379,
+				DropRate:  0.1,
+				Stub:      &StubConfig{Body: "+PONG\r\n"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid http stub",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Mode:      ModeHTTP2,
+				Stub:      &StubConfig{Body: "{}", Status: 503, Headers: map[string]string{"Retry-After": "1"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "stub with upstream",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Stub:      &StubConfig{Body: "ok"},
+			},
+			wantErr:     true,
+			errContains: "upstream with stub",
+		},
+		{
+			name: "stub in grpc mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Mode:      ModeGRPC,
+				Stub:      &StubConfig{Body: "ok"},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "stub with upstreamTLS",
+			config: RouteConfig{
+				LocalPort:   8080,
+				UpstreamTLS: &UpstreamTLSConfig{},
+				Stub:        &StubConfig{Body: "ok"},
+			},
+			wantErr:     true,
+			errContains: "conflicting stub options",
+		},
+		{
+			name: "stub with body and bodyFile",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Stub:      &StubConfig{Body: "ok", BodyFile: "reply.bin"},
+			},
+			wantErr:     true,
+			errContains: "conflicting stub options",
+		},
+		{
+			name: "stub with missing bodyFile",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Stub:      &StubConfig{BodyFile: "/nonexistent/reply.bin"},
+			},
+			wantErr:     true,
+			errContains: "invalid stub body file",
+		},
+		{
+			name: "stub status in tcp mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Stub:      &StubConfig{Body: "ok", Status: 503},
+			},
+			wantErr:     true,
+			errContains: "stub HTTP options without an HTTP mode",
+		},
+		{
+			name: "invalid stub status",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Mode:      ModeHTTP,
+				Stub:      &StubConfig{Status: 99},
+			},
+			wantErr:     true,
+			errContains: "invalid stub status",
+		},
+		{
+			name: "stub over udp",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Protocol:  ProtocolUDP,
+				Stub:      &StubConfig{Body: "ok"},
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "valid record",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Record:    &RecordConfig{Dir: "recordings"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "record without a directory",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Record:    &RecordConfig{},
+			},
+			wantErr:     true,
+			errContains: "missing record directory",
+		},
+		{
+			name: "replay with upstream",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Replay:    &ReplayConfig{Dir: "recordings"},
+			},
+			wantErr:     true,
+			errContains: "upstream with replay",
+		},
+		{
+			name: "replay without recordings",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Replay:    &ReplayConfig{Dir: "/nonexistent/recordings"},
+			},
+			wantErr:     true,
+			errContains: "no recordings to replay",
+		},
+		{
+			name: "replay in http2 mode",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Mode:      ModeHTTP2,
+				Replay:    &ReplayConfig{Dir: "recordings"},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "replay with stub",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Stub:      &StubConfig{Body: "ok"},
+				Replay:    &ReplayConfig{Dir: "recordings"},
+			},
+			wantErr:     true,
+			errContains: "conflicting replay options",
+		},
+		{
+			name: "record over udp",
+			config: RouteConfig{
+				LocalPort: 5353,
+				Upstream:  "127.0.0.1:53",
+				Protocol:  ProtocolUDP,
+				Record:    &RecordConfig{Dir: "recordings"},
+			},
+			wantErr:     true,
+			errContains: "option not supported over UDP",
+		},
+		{
+			name: "valid postgres mode",
+			config: RouteConfig{
+				LocalPort: 15432,
+				Upstream:  "127.0.0.1:5432",
+				Mode:      ModePostgres,
+				Database:  &DatabaseConfig{KillQueryRate: 0.1, PartialResultRate: 0.2, PartialRows: 10},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid mysql mode",
+			config: RouteConfig{
+				LocalPort: 13306,
+				Upstream:  "127.0.0.1:3306",
+				Mode:      ModeMySQL,
+				Database:  &DatabaseConfig{KillQueryRate: 0.1},
+			},
+			wantErr: false,
+		},
+		{
+			name: "database settings without a database mode",
+			config: RouteConfig{
+				LocalPort: 15432,
+				Upstream:  "127.0.0.1:5432",
+				Database:  &DatabaseConfig{KillQueryRate: 0.1},
+			},
+			wantErr:     true,
+			errContains: "database settings without a database mode",
+		},
+		{
+			name: "postgres mode with tls",
+			config: RouteConfig{
+				LocalPort:   15432,
+				Upstream:    "127.0.0.1:5432",
+				Mode:        ModePostgres,
+				UpstreamTLS: &UpstreamTLSConfig{},
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "database kill query rate too high",
+			config: RouteConfig{
+				LocalPort: 13306,
+				Upstream:  "127.0.0.1:3306",
+				Mode:      ModeMySQL,
+				Database:  &DatabaseConfig{KillQueryRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid database kill query rate",
+		},
+		{
+			name: "database partial result rate too low",
+			config: RouteConfig{
+				LocalPort: 13306,
+				Upstream:  "127.0.0.1:3306",
+				Mode:      ModeMySQL,
+				Database:  &DatabaseConfig{PartialResultRate: -0.1},
+			},
+			wantErr:     true,
+			errContains: "invalid database partial result rate",
+		},
+		{
+			name: "negative database partial rows",
+			config: RouteConfig{
+				LocalPort: 15432,
+				Upstream:  "127.0.0.1:5432",
+				Mode:      ModePostgres,
+				Database:  &DatabaseConfig{PartialResultRate: 0.5, PartialRows: -1},
+			},
+			wantErr:     true,
+			errContains: "invalid database partial rows",
+		},
+		{
+			name: "http mode with captureClientHello",
+			config: RouteConfig{
+				LocalPort:          8080,
+				Upstream:           "127.0.0.1:9090",
+				Mode:               ModeHTTP,
+				CaptureClientHello: true,
+			},
+			wantErr:     true,
+			errContains: "conflicting mode options",
+		},
+		{
+			name: "HTTP error rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 1.5},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP error rate",
+		},
+		{
+			name: "HTTP error status not an error",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 200},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP error status",
+		},
+		{
+			name: "valid HTTP clock skew",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ClockSkewMs: -3600000},
+			},
+			wantErr: false,
+		},
+		{
+			name: "HTTP clock skew too large",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ClockSkewMs: -maxMilliseconds - 1},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP clock skew",
+		},
+		{
+			name: "valid header rules",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInRequest, Action: HeaderRemove, Name: "Authorization"}, {In: HeaderInResponse, Action: HeaderRemove, Name: "Content-Length", Rate: float64Ptr(0.1)}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "header rule with unknown action",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInRequest, Action: "rename", Name: "Authorization"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid header rule action",
+		},
+		{
+			name: "header rule with unknown target",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: "trailer", Action: HeaderRemove, Name: "ETag"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid header rule target",
+		},
+		{
+			name: "header rule with invalid name",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInResponse, Action: HeaderRemove, Name: "Bad Header"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid header name",
+		},
+		{
+			name: "header value with line break",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInResponse, Action: HeaderSet, Name: "X-Test", Value: "a\r\nInjected: b"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid header value",
+		},
+		{
+			name: "value on remove rule",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInResponse, Action: HeaderRemove, Name: "ETag", Value: "x"}}},
+			},
+			wantErr:     true,
+			errContains: "header value without add or set",
+		},
+		{
+			name: "corrupting Content-Length",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInResponse, Action: HeaderCorrupt, Name: "content-length"}}},
+			},
+			wantErr:     true,
+			errContains: "unsupported header rule",
+		},
+		{
+			name: "rewriting Host",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInRequest, Action: HeaderSet, Name: "Host", Value: "example.com"}}},
+			},
+			wantErr:     true,
+			errContains: "unsupported header rule",
+		},
+		{
+			name: "header rule rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Headers: []HeaderRule{{In: HeaderInResponse, Action: HeaderRemove, Name: "ETag", Rate: float64Ptr(2)}}},
+			},
+			wantErr:     true,
+			errContains: "invalid header rule rate",
+		},
+		{
+			name: "valid HTTP body chaos",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Body: &HTTPBodyConfig{TruncateRate: 0.1, CorruptRate: 0.05}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "HTTP body truncate rate too high",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Body: &HTTPBodyConfig{TruncateRate: 1.5}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP body truncate rate",
+		},
+		{
+			name: "negative HTTP body corrupt rate",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{Body: &HTTPBodyConfig{CorruptRate: -0.1}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP body corrupt rate",
+		},
+		{
+			name: "valid HTTP match",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{Methods: []string{"POST"}, PathPrefix: "/checkout", PathRegex: `^/checkout/\d+$`, Headers: map[string]string{"X-Tenant": "acme", "Authorization": ""}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "empty HTTP match",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{}},
+			},
+			wantErr:     true,
+			errContains: "empty HTTP match",
+		},
+		{
+			name: "HTTP match with invalid method",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{Methods: []string{"GET /"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP match method",
+		},
+		{
+			name: "HTTP match path prefix without slash",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{PathPrefix: "checkout"}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP match path prefix",
+		},
+		{
+			name: "HTTP match with invalid path regex",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{PathRegex: "(["}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP match path regex",
+		},
+		{
+			name: "HTTP match with invalid header",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Mode:      ModeHTTP,
+				HTTP:      &HTTPConfig{ErrorRate: 0.5, Match: &HTTPMatch{Headers: map[string]string{"Bad Header": "x"}}},
+			},
+			wantErr:     true,
+			errContains: "invalid HTTP match header",
+		},
+		{
+			name: "negative FIN delay",
+			config: RouteConfig{
+				LocalPort:  8080,
+				Upstream:   "127.0.0.1:9090",
+				FinDelayMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid FIN delay",
+		},
+		{
+			name: "negative first byte latency",
+			config: RouteConfig{
+				LocalPort:          8080,
+				Upstream:           "127.0.0.1:9090",
+				FirstByteLatencyMs: -5,
+			},
+			wantErr:     true,
+			errContains: "invalid first byte latency",
+		},
+		{
+			name: "negative connect latency",
+			config: RouteConfig{
+				LocalPort:        8080,
+				Upstream:         "127.0.0.1:9090",
+				ConnectLatencyMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid connect latency",
+		},
+		{
+			name: "unordered load latency curve",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				LoadLatency: []LoadPoint{
+					{Connections: 10, LatencyMs: 100},
+					{Connections: 5, LatencyMs: 200},
+				},
+			},
+			wantErr:     true,
+			errContains: "unordered load latency curve",
+		},
+		{
+			name: "negative load latency",
+			config: RouteConfig{
+				LocalPort:   8080,
+				Upstream:    "127.0.0.1:9090",
+				LoadLatency: []LoadPoint{{Connections: 1, LatencyMs: -1}},
+			},
+			wantErr:     true,
+			errContains: "invalid load latency point",
+		},
+		{
+			name: "handoff socket path too long",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				HandoffSocket: "/tmp/" + strings.Repeat("a", 100) + ".sock",
+			},
+			wantErr:     true,
+			errContains: "handoff socket path too long",
+		},
+		{
+			name: "negative idle timeout",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				IdleTimeoutMs: -5,
+			},
+			wantErr:     true,
+			errContains: "invalid idle timeout",
+		},
+		{
+			name: "valid idle release time",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				IdleTimeoutMs: 1000,
+				IdleReleaseMs: 60000,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative idle release time",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				IdleTimeoutMs: 1000,
+				IdleReleaseMs: -5,
+			},
+			wantErr:     true,
+			errContains: "invalid idle release time",
+		},
+		{
+			name: "idle release time without idle timeout",
+			config: RouteConfig{
+				LocalPort:     8080,
+				Upstream:      "127.0.0.1:9090",
+				IdleReleaseMs: 60000,
+			},
+			wantErr:     true,
+			errContains: "idle release time without idle timeout",
+		},
+		{
+			name: "valid drop cool-down",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				DropRate:       0.3,
+				DropCooldownMs: 2000,
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative drop cool-down",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				DropCooldownMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid drop cool-down",
+		},
+		{
+			name: "drop cool-down with exact mode",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				DropRate:       0.5,
+				DropMode:       DropModeExact,
+				DropCooldownMs: 1000,
+			},
+			wantErr:     true,
+			errContains: "conflicting loss options",
+		},
+		{
+			name: "valid toxics",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics: []ToxicConfig{
+					{Type: ToxicLatency, LatencyMs: 100, JitterMs: 20},
+					{Type: ToxicBandwidth, BytesPerSecond: 1024, Stream: StreamDownstream},
+					{Type: ToxicCorrupt, Rate: 0.01},
+					{Type: ToxicTruncate, Bytes: 512, Stream: StreamUpstream},
+					{Type: ToxicDrop, Rate: 0.1, Toxicity: float64Ptr(0.5)},
+					{Type: ToxicSegment, MaxBytes: 8},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown toxic type",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics:    []ToxicConfig{{Type: "slicer"}},
+			},
+			wantErr:     true,
+			errContains: "unknown toxic type",
+		},
+		{
+			name: "segment toxic with min above max",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics:    []ToxicConfig{{Type: ToxicSegment, MinBytes: 32, MaxBytes: 8}},
+			},
+			wantErr:     true,
+			errContains: "invalid segment toxic",
+		},
+		{
+			name: "bandwidth toxic without rate",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics:    []ToxicConfig{{Type: ToxicBandwidth}},
+			},
+			wantErr:     true,
+			errContains: "invalid bandwidth toxic",
+		},
+		{
+			name: "invalid toxic stream",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics:    []ToxicConfig{{Type: ToxicDrop, Rate: 0.5, Stream: "sideways"}},
+			},
+			wantErr:     true,
+			errContains: "invalid toxic stream",
+		},
+		{
+			name: "duplicate toxic name",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics: []ToxicConfig{
+					{Name: "slow", Type: ToxicLatency, LatencyMs: 100},
+					{Name: "slow", Type: ToxicBandwidth, BytesPerSecond: 1024},
+				},
+			},
+			wantErr:     true,
+			errContains: "duplicate toxic name",
+		},
+		{
+			name: "toxicity out of range",
+			config: RouteConfig{
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+				Toxics:    []ToxicConfig{{Type: ToxicCorrupt, Rate: 0.5, Toxicity: float64Ptr(1.5)}},
+			},
+			wantErr:     true,
+			errContains: "invalid toxicity",
+		},
+		{
+			name: "negative coalesce interval",
+			config: RouteConfig{
+				LocalPort:  8080,
+				Upstream:   "127.0.0.1:9090",
+				CoalesceMs: -10,
+			},
+			wantErr:     true,
+			errContains: "invalid coalescing settings",
+		},
+		{
+			name: "valid tenant",
+			config: RouteConfig{
+				Tenant:    "payments-team_1",
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid tenant characters",
+			config: RouteConfig{
+				Tenant:    "payments team",
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+			},
+			wantErr:     true,
+			errContains: "invalid tenant name",
+		},
+		{
+			name: "listen address",
+			config: RouteConfig{
+				LocalPort:     8080,
+				ListenAddress: "::",
+				Upstream:      "127.0.0.1:9090",
+			},
+			wantErr: false,
+		},
+		{
+			name: "listen address with port",
+			config: RouteConfig{
+				LocalPort:     8080,
+				ListenAddress: "0.0.0.0:8080",
+				Upstream:      "127.0.0.1:9090",
+			},
+			wantErr:     true,
+			errContains: "invalid listen address",
+		},
+		{
+			name: "valid name and tags",
+			config: RouteConfig{
+				Name:      "payments-db",
+				Tags:      []string{"team-payments", "staging"},
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+			},
+			wantErr: false,
+		},
+		{
+			name: "numeric name",
+			config: RouteConfig{
+				Name:      "8080",
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+			},
+			wantErr:     true,
+			errContains: "invalid route name",
+		},
+		{
+			name: "empty tag",
+			config: RouteConfig{
+				Tags:      []string{"staging", ""},
+				LocalPort: 8080,
+				Upstream:  "127.0.0.1:9090",
+			},
+			wantErr:     true,
+			errContains: "invalid route tag",
+		},
+		{
+			name: "valid flap config",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				FlapIntervalMs: 1000,
+				FlapDowntimeMs: 250,
+			},
+			wantErr: false,
+		},
+		{
+			name: "flap interval without downtime",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				FlapIntervalMs: 1000,
+			},
+			wantErr:     true,
+			errContains: "incomplete flap configuration",
+		},
+		{
+			name: "negative flap downtime",
+			config: RouteConfig{
+				LocalPort:      8080,
+				Upstream:       "127.0.0.1:9090",
+				FlapIntervalMs: 1000,
+				FlapDowntimeMs: -1,
+			},
+			wantErr:     true,
+			errContains: "invalid flap timing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := testLogger().With("file", "test-config.json")
+			err := validateRouteConfig(tt.config, 0, LoadOptions{}, logger)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRouteConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			// Since detailed errors are logged via slog, we just verify an error was returned
+			// The specific error details are checked through slog output
+			if tt.wantErr && err != nil && !contains(err.Error(), "validation failed") {
+				t.Errorf("validateRouteConfig() error = %v, want error containing 'validation failed'", err)
+			}
+		})
+	}
+}
+
+// Helper function to check if a string contains a substring
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func contains(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
+		(len(s) > 0 && len(substr) > 0 && stringContains(s, substr)))
+}
+
+func stringContains(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFindChainLoop(t *testing.T) {
+	routes := []RouteConfig{
+		{LocalPort: 8080, Upstream: "127.0.0.1:8081"},
+		{LocalPort: 8081, Upstream: "127.0.0.1:8082"},
+		{LocalPort: 8082, Upstream: "127.0.0.1:8081"},
+	}
+	if got := formatChain(findChainLoop(routes)); got != "8080 -> 8081 -> 8082 -> 8081" {
+		t.Errorf("findChainLoop() = %q, want %q", got, "8080 -> 8081 -> 8082 -> 8081")
+	}
+
+	// A UDP route's upstream on a TCP route's port is a different socket.
+	routes = []RouteConfig{
+		{LocalPort: 8080, Upstream: "127.0.0.1:8081", Protocol: ProtocolUDP},
+		{LocalPort: 8081, Upstream: "127.0.0.1:8080"},
+	}
+	if loop := findChainLoop(routes); loop != nil {
+		t.Errorf("findChainLoop() = %q, want no loop across protocols", formatChain(loop))
+	}
+
+	// A route listening on every address is reached through loopback, but
+	// one listening on another address is not.
+	routes = []RouteConfig{
+		{LocalPort: 8080, Upstream: "127.0.0.1:8081", ListenAddress: "0.0.0.0"},
+		{LocalPort: 8081, Upstream: "127.0.0.1:8080"},
+	}
+	if got := formatChain(findChainLoop(routes)); got != "8080 -> 8081 -> 8080" {
+		t.Errorf("findChainLoop() = %q, want %q", got, "8080 -> 8081 -> 8080")
+	}
+	routes[0].ListenAddress = "10.0.0.5"
+	if loop := findChainLoop(routes); loop != nil {
+		t.Errorf("findChainLoop() = %q, want no loop through another address", formatChain(loop))
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to dir and
+// returns their paths.
+func writeTestCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestValidateRouteConfig_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "proxy.test")
+	otherCert, _ := writeTestCert(t, dir, "upstream.test")
+	notPEM := filepath.Join(dir, "not.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name: "terminate and re-encrypt",
+			route: RouteConfig{
+				TLS:         &TLSConfig{CertFile: certFile, KeyFile: keyFile},
+				UpstreamTLS: &UpstreamTLSConfig{ServerName: "upstream.test", CAFile: otherCert},
+			},
+		},
+		{
+			name:  "upstream TLS with system roots",
+			route: RouteConfig{UpstreamTLS: &UpstreamTLSConfig{ServerName: "upstream.test"}},
+		},
+		{
+			name:    "missing key",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: certFile}},
+			wantErr: true,
+		},
+		{
+			name:    "key doesn't match certificate",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: otherCert, KeyFile: keyFile}},
+			wantErr: true,
+		},
+		{
+			name:    "missing certificate file",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile}},
+			wantErr: true,
+		},
+		{
+			name:  "upstream client certificate",
+			route: RouteConfig{UpstreamTLS: &UpstreamTLSConfig{CAFile: otherCert, CertFile: certFile, KeyFile: keyFile}},
+		},
+		{
+			name:    "upstream client certificate without key",
+			route:   RouteConfig{UpstreamTLS: &UpstreamTLSConfig{CertFile: certFile}},
+			wantErr: true,
+		},
+		{
+			name:    "upstream client key doesn't match certificate",
+			route:   RouteConfig{UpstreamTLS: &UpstreamTLSConfig{CertFile: otherCert, KeyFile: keyFile}},
+			wantErr: true,
+		},
+		{
+			name:    "CA bundle without certificates",
+			route:   RouteConfig{UpstreamTLS: &UpstreamTLSConfig{CAFile: notPEM}},
+			wantErr: true,
+		},
+		{
+			name: "handshake chaos",
+			route: RouteConfig{TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, Handshake: &TLSHandshakeConfig{
+				DelayMs: 500, AbortRate: 0.1, BadCertRate: 0.1, BadCert: BadCertExpired,
+			}}},
+		},
+		{
+			name:    "handshake abort rate above 1",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, Handshake: &TLSHandshakeConfig{AbortRate: 1.5}}},
+			wantErr: true,
+		},
+		{
+			name:    "negative handshake delay",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, Handshake: &TLSHandshakeConfig{DelayMs: -1}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown bad certificate",
+			route:   RouteConfig{TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, Handshake: &TLSHandshakeConfig{BadCertRate: 1, BadCert: "revoked"}}},
+			wantErr: true,
+		},
+		{
+			name: "terminating TLS and capturing the ClientHello",
+			route: RouteConfig{
+				TLS:                &TLSConfig{CertFile: certFile, KeyFile: keyFile},
+				CaptureClientHello: true,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.LocalPort = 8443
+			tt.route.Upstream = "127.0.0.1:9443"
+			err := validateRouteConfig(tt.route, 0, LoadOptions{}, testLogger())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRouteConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRouteConfig_Replay(t *testing.T) {
+	recordings := t.TempDir()
+	os.WriteFile(filepath.Join(recordings, "conn"+RecordingExt), []byte(`{"from":"upstream","ms":0,"data":"aGk="}`+"\n"), 0600)
+	empty := t.TempDir()
+
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{
+			name:  "replay in tcp mode",
+			route: RouteConfig{Replay: &ReplayConfig{Dir: recordings}},
+		},
+		{
+			name:  "replay in http mode with timing",
+			route: RouteConfig{Mode: ModeHTTP, Replay: &ReplayConfig{Dir: recordings, Timing: true}},
+		},
+		{
+			name:    "directory without recordings",
+			route:   RouteConfig{Replay: &ReplayConfig{Dir: empty}},
+			wantErr: true,
+		},
+		{
+			name:    "missing directory",
+			route:   RouteConfig{Replay: &ReplayConfig{}},
+			wantErr: true,
+		},
+		{
+			name:    "replay with upstreamTLS",
+			route:   RouteConfig{UpstreamTLS: &UpstreamTLSConfig{}, Replay: &ReplayConfig{Dir: recordings}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.LocalPort = 8080
+			err := validateRouteConfig(tt.route, 0, LoadOptions{}, testLogger())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRouteConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRouteConfig_Script(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(src), 0600)
+		return path
+	}
+	drops := write("drops.chaos", "if client.connections > 3: drop\n")
+	failRequests := write("errors.chaos", "if client.requests > 100: error\n")
+	teapot := write("teapot.chaos", "error 418\n")
+	informational := write("informational.chaos", "error 101\n")
+	grpcCode := write("grpc.chaos", "error 8\n")
+	broken := write("broken.chaos", "if client.requests >: drop\n")
+
+	tests := []struct {
+		name    string
+		route   RouteConfig
+		wantErr bool
+	}{
+		{name: "connection script in tcp mode", route: RouteConfig{Script: drops}},
+		{name: "error in http mode", route: RouteConfig{Mode: ModeHTTP, Script: failRequests}},
+		{name: "error status in http2 mode", route: RouteConfig{Mode: ModeHTTP2, Script: teapot}},
+		{name: "gRPC status code in grpc mode", route: RouteConfig{Mode: ModeGRPC, Script: grpcCode}},
+		{name: "error in tcp mode", route: RouteConfig{Script: failRequests}, wantErr: true},
+		{name: "non-error status", route: RouteConfig{Mode: ModeHTTP, Script: informational}, wantErr: true},
+		{name: "HTTP status in grpc mode", route: RouteConfig{Mode: ModeGRPC, Script: teapot}, wantErr: true},
+		{name: "syntax error", route: RouteConfig{Script: broken}, wantErr: true},
+		{name: "missing file", route: RouteConfig{Script: filepath.Join(dir, "missing.chaos")}, wantErr: true},
+		{name: "udp route", route: RouteConfig{Protocol: ProtocolUDP, Script: drops}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.LocalPort = 8080
+			tt.route.Upstream = "127.0.0.1:9090"
+			err := validateRouteConfig(tt.route, 0, LoadOptions{}, testLogger())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRouteConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+)
+
+// routeOnlySettings are the route settings that can't be shared through
+// "defaults", since every route needs its own.
+var routeOnlySettings = []string{"name", "localPort", "baselinePort", "handoffSocket"}
+
+// applyDefaults gives every route the settings under "defaults" that it
+// doesn't set itself. A setting is taken whole: a route that sets "http"
+// replaces the default http settings rather than merging into them. The
+// loss and latency settings of a route's profile win over the defaults, as
+// if the route set them itself.
+func (f *fileConfig) applyDefaults(configLogger *slog.Logger) error {
+	if len(f.Defaults) == 0 {
+		return nil
+	}
+
+	hasErrors := false
+	for _, key := range routeOnlySettings {
+		if _, ok := f.Defaults[key]; ok {
+			configLogger.Error("route-specific setting in defaults",
+				"setting", key,
+				"hint", fmt.Sprintf("every route must set %s itself; move it out of defaults", key))
+			hasErrors = true
+		}
+	}
+	if hasErrors {
+		return fmt.Errorf("validation failed: see error messages above for details")
+	}
+	if err := decodeRoute(f.Defaults, &RouteConfig{}); err != nil {
+		configLogger.Error("invalid defaults",
+			"error", err,
+			"hint", "defaults take the same settings as a route, with the same types")
+		return fmt.Errorf("invalid defaults: %w", err)
+	}
+
+	for i, own := range f.rawRoutes {
+		merged := maps.Clone(f.Defaults)
+		maps.Copy(merged, own)
+
+		var profileName string
+		if raw, ok := merged["profile"]; ok && json.Unmarshal(raw, &profileName) == nil {
+			if profile, ok := findProfile(profileName, f.Profiles); ok {
+				for _, key := range profile.settings() {
+					if _, set := own[key]; !set {
+						delete(merged, key)
+					}
+				}
+			}
+		}
+
+		var route RouteConfig
+		if err := decodeRoute(merged, &route); err != nil {
+			configLogger.Error("invalid route with defaults",
+				"route_index", i,
+				"error", err,
+				"hint", "the route's settings and the defaults it inherits must form a valid route")
+			return fmt.Errorf("invalid route %d with defaults: %w", i, err)
+		}
+		f.Routes[i] = route
+	}
+	return nil
+}
+
+// settings lists the route settings, by JSON key, that the profile fills in.
+func (p Profile) settings() []string {
+	var keys []string
+	if p.DropRate != 0 || p.BurstLoss != nil {
+		keys = append(keys, "dropRate", "burstLoss")
+	}
+	if p.LatencyMs != 0 {
+		keys = append(keys, "latencyMs")
+	}
+	return keys
+}
+
+// decodeRoute decodes the route settings in fields into route, rejecting
+// unknown ones.
+func decodeRoute(fields map[string]json.RawMessage, route *RouteConfig) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return decodeStrict(data, route)
+}
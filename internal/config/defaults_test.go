@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileContent string
+		wantErr     bool
+		errContains string
+		want        []RouteConfig
+	}{
+		{
+			name: "routes inherit defaults",
+			fileContent: `{
+				"defaults": {"latencyMs": 100, "dropRate": 0.1, "listenAddress": "0.0.0.0", "idleTimeoutMs": 30000},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090"},
+					{"localPort": 8081, "upstream": "127.0.0.1:9091", "idleTimeoutMs": 5000}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", LatencyMs: 100, DropRate: 0.1, ListenAddress: "0.0.0.0", IdleTimeoutMs: 30000},
+				{LocalPort: 8081, Upstream: "127.0.0.1:9091", LatencyMs: 100, DropRate: 0.1, ListenAddress: "0.0.0.0", IdleTimeoutMs: 5000},
+			},
+		},
+		{
+			name: "route overrides with zero",
+			fileContent: `{
+				"defaults": {"latencyMs": 100, "dropRate": 0.1},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "latencyMs": 0, "dropRate": 0}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090"},
+			},
+		},
+		{
+			name: "objects are taken whole",
+			fileContent: `{
+				"defaults": {"mode": "http", "http": {"errorRate": 0.1, "errorStatus": 503}},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "http": {"errorRate": 0.2}}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", Mode: ModeHTTP, HTTP: &HTTPConfig{ErrorRate: 0.2}},
+			},
+		},
+		{
+			name: "profile wins over defaults",
+			fileContent: `{
+				"profiles": {"slow-db": {"latencyMs": 250}},
+				"defaults": {"latencyMs": 100, "dropRate": 0.1},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "slow-db"},
+					{"localPort": 8081, "upstream": "127.0.0.1:9091", "profile": "slow-db", "latencyMs": 50}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: "slow-db", LatencyMs: 250, DropRate: 0.1},
+				{LocalPort: 8081, Upstream: "127.0.0.1:9091", Profile: "slow-db", LatencyMs: 50, DropRate: 0.1},
+			},
+		},
+		{
+			name: "profile from defaults",
+			fileContent: `{
+				"profiles": {"slow-db": {"latencyMs": 250}},
+				"defaults": {"profile": "slow-db", "latencyMs": 100},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090"}
+				]
+			}`,
+			want: []RouteConfig{
+				{LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: "slow-db", LatencyMs: 250},
+			},
+		},
+		{
+			name: "route-specific setting",
+			fileContent: `{
+				"defaults": {"localPort": 8080},
+				"routes": [
+					{"upstream": "127.0.0.1:9090"}
+				]
+			}`,
+			wantErr:     true,
+			errContains: "validation failed",
+		},
+		{
+			name: "unknown setting",
+			fileContent: `{
+				"defaults": {"latency": 100},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090"}
+				]
+			}`,
+			wantErr:     true,
+			errContains: "invalid defaults",
+		},
+		{
+			name: "invalid inherited setting",
+			fileContent: `{
+				"defaults": {"listenAddress": "localhost"},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090"}
+				]
+			}`,
+			wantErr:     true,
+			errContains: "validation failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tt.fileContent), 0644); err != nil {
+				t.Fatalf("failed to write test config file: %v", err)
+			}
+
+			routes, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !contains(err.Error(), tt.errContains) {
+					t.Errorf("LoadConfig() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if !reflect.DeepEqual(routes, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Milliseconds is a length of time in a config file. It is written either
+// as a number of milliseconds, or as a Go duration string such as "150ms",
+// "2s" or "1m", which can't be off by a factor of ten unnoticed.
+type Milliseconds int
+
+func (m *Milliseconds) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(`"`)) {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*m = Milliseconds(n)
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil || d%time.Millisecond != 0 || d/time.Millisecond > maxMilliseconds || d/time.Millisecond < -maxMilliseconds {
+		return fmt.Errorf("invalid duration %q: use a number of milliseconds, or a whole number of milliseconds written like \"150ms\", \"2s\" or \"1m\"", text)
+	}
+	*m = Milliseconds(d / time.Millisecond)
+	return nil
+}
+
+// maxMilliseconds is the longest duration string accepted, so a
+// Milliseconds fits an int on every platform.
+const maxMilliseconds = 1<<31 - 1
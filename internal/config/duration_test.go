@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMilliseconds_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Milliseconds
+		wantErr bool
+	}{
+		{name: "integer", data: `150`, want: 150},
+		{name: "milliseconds string", data: `"150ms"`, want: 150},
+		{name: "seconds string", data: `"2s"`, want: 2000},
+		{name: "minutes string", data: `"1m"`, want: 60000},
+		{name: "fractional seconds", data: `"1.5s"`, want: 1500},
+		{name: "compound", data: `"1m30s"`, want: 90000},
+		{name: "negative", data: `"-2s"`, want: -2000},
+		{name: "zero", data: `"0"`, want: 0},
+		{name: "missing unit", data: `"150"`, wantErr: true},
+		{name: "unknown unit", data: `"2 seconds"`, wantErr: true},
+		{name: "whole milliseconds in microseconds", data: `"2000us"`, want: 2},
+		{name: "fraction of a millisecond", data: `"1.5ms"`, wantErr: true},
+		{name: "too long", data: `"1000h"`, wantErr: true},
+		{name: "fractional integer", data: `1.5`, wantErr: true},
+		{name: "boolean", data: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Milliseconds
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Durations(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	content := `[{
+		"localPort": 8080,
+		"upstream": "127.0.0.1:9090",
+		"latencyMs": "150ms",
+		"idleTimeoutMs": "30s",
+		"toxics": [{"type": "latency", "latencyMs": "1s", "jitterMs": 20}]
+	}]`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config file: %v", err)
+	}
+
+	routes, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	route := routes[0]
+	if route.LatencyMs != 150 || route.IdleTimeoutMs != 30000 || route.Toxics[0].LatencyMs != 1000 || route.Toxics[0].JitterMs != 20 {
+		t.Errorf("LoadConfig() = latencyMs %d, idleTimeoutMs %d, toxic latencyMs %d, jitterMs %d; want 150, 30000, 1000, 20",
+			route.LatencyMs, route.IdleTimeoutMs, route.Toxics[0].LatencyMs, route.Toxics[0].JitterMs)
+	}
+
+	content = `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "latencyMs": "3 seconds"}]`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config file: %v", err)
+	}
+	_, err = LoadConfig(configPath)
+	if err == nil || !contains(err.Error(), `invalid duration "3 seconds"`) {
+		t.Errorf("LoadConfig() error = %v, want an error quoting the duration", err)
+	}
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fromJSON5 translates a config written in JSON5 into plain JSON, so the
+// strict decoder can read it. Plain JSON comes out meaning the same. Comments,
+// trailing commas, unquoted keys, single-quoted strings and the looser
+// number forms are accepted; Infinity and NaN, which no config field can
+// hold, are not.
+func fromJSON5(data []byte) ([]byte, error) {
+	s := &json5Scanner{src: data}
+	if err := s.translate(); err != nil {
+		return nil, err
+	}
+	return s.out.Bytes(), nil
+}
+
+type json5Scanner struct {
+	src []byte
+	pos int
+	out bytes.Buffer
+}
+
+// errorf reports a syntax error at the current position.
+func (s *json5Scanner) errorf(format string, args ...any) error {
+	line := 1 + bytes.Count(s.src[:s.pos], []byte("\n"))
+	col := s.pos - bytes.LastIndexByte(s.src[:s.pos], '\n')
+	return fmt.Errorf("line %d, column %d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (s *json5Scanner) translate() error {
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		switch {
+		case c == '/':
+			if err := s.comment(); err != nil {
+				return err
+			}
+		case c == '"' || c == '\'':
+			if err := s.string(); err != nil {
+				return err
+			}
+		case c == ',':
+			s.pos++
+			if next := s.peek(); next == ']' || next == '}' {
+				// A trailing comma; JSON has no room for it.
+				continue
+			}
+			s.out.WriteByte(',')
+		case c == '+' || c == '-' || c == '.' || isDigit(c):
+			if err := s.number(); err != nil {
+				return err
+			}
+		case isIdentStart(c):
+			if err := s.identifier(); err != nil {
+				return err
+			}
+		default:
+			s.out.WriteByte(c)
+			s.pos++
+		}
+	}
+	return nil
+}
+
+// peek returns the next byte after whitespace and comments without
+// consuming anything, or 0 at the end of the input.
+func (s *json5Scanner) peek() byte {
+	for i := s.pos; i < len(s.src); {
+		switch c := s.src[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case bytes.HasPrefix(s.src[i:], []byte("//")):
+			end := bytes.IndexByte(s.src[i:], '\n')
+			if end < 0 {
+				return 0
+			}
+			i += end
+		case bytes.HasPrefix(s.src[i:], []byte("/*")):
+			end := bytes.Index(s.src[i+2:], []byte("*/"))
+			if end < 0 {
+				return 0
+			}
+			i += 2 + end + 2
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// comment skips a comment, leaving a space so that the tokens either side
+// stay apart.
+func (s *json5Scanner) comment() error {
+	rest := s.src[s.pos:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("//")):
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		s.pos += end
+	case bytes.HasPrefix(rest, []byte("/*")):
+		end := bytes.Index(rest[2:], []byte("*/"))
+		if end < 0 {
+			return s.errorf("unterminated comment")
+		}
+		s.out.WriteByte(' ')
+		s.pos += 2 + end + 2
+	default:
+		return s.errorf("unexpected '/'")
+	}
+	return nil
+}
+
+// string reads a single- or double-quoted string and writes it back
+// double-quoted.
+func (s *json5Scanner) string() error {
+	start := s.pos
+	quote := s.src[s.pos]
+	s.pos++
+	var value strings.Builder
+	for {
+		if s.pos >= len(s.src) {
+			s.pos = start
+			return s.errorf("unterminated string")
+		}
+		c := s.src[s.pos]
+		switch {
+		case c == quote:
+			s.pos++
+			encoded, err := json.Marshal(value.String())
+			if err != nil {
+				return s.errorf("%v", err)
+			}
+			s.out.Write(encoded)
+			return nil
+		case c == '\n' || c == '\r':
+			return s.errorf("line break in string; end the line with a backslash to continue it")
+		case c == '\\':
+			if err := s.escape(&value); err != nil {
+				return err
+			}
+		default:
+			r, size := utf8.DecodeRune(s.src[s.pos:])
+			value.WriteRune(r)
+			s.pos += size
+		}
+	}
+}
+
+// escape reads one escape sequence in a string.
+func (s *json5Scanner) escape(value *strings.Builder) error {
+	s.pos++
+	if s.pos >= len(s.src) {
+		return s.errorf("unterminated string")
+	}
+	c := s.src[s.pos]
+	s.pos++
+	switch c {
+	case 'b':
+		value.WriteByte('\b')
+	case 'f':
+		value.WriteByte('\f')
+	case 'n':
+		value.WriteByte('\n')
+	case 'r':
+		value.WriteByte('\r')
+	case 't':
+		value.WriteByte('\t')
+	case 'v':
+		value.WriteByte('\v')
+	case '0':
+		value.WriteByte(0)
+	case '\n':
+		// A line continuation.
+	case '\r':
+		if s.pos < len(s.src) && s.src[s.pos] == '\n' {
+			s.pos++
+		}
+	case 'x', 'u':
+		digits := 2
+		if c == 'u' {
+			digits = 4
+		}
+		if s.pos+digits > len(s.src) {
+			return s.errorf("invalid \\%c escape", c)
+		}
+		n, err := strconv.ParseUint(string(s.src[s.pos:s.pos+digits]), 16, 32)
+		if err != nil {
+			return s.errorf("invalid \\%c escape", c)
+		}
+		s.pos += digits
+		r := rune(n)
+		if utf16Surrogate(r) && bytes.HasPrefix(s.src[s.pos:], []byte(`\u`)) && s.pos+6 <= len(s.src) {
+			if low, err := strconv.ParseUint(string(s.src[s.pos+2:s.pos+6]), 16, 32); err == nil && low >= 0xdc00 && low < 0xe000 {
+				r = (r-0xd800)<<10 + (rune(low) - 0xdc00) + 0x10000
+				s.pos += 6
+			}
+		}
+		value.WriteRune(r)
+	default:
+		// Any other escaped character, quotes and backslashes included,
+		// stands for itself.
+		s.pos--
+		r, size := utf8.DecodeRune(s.src[s.pos:])
+		value.WriteRune(r)
+		s.pos += size
+	}
+	return nil
+}
+
+func utf16Surrogate(r rune) bool {
+	return r >= 0xd800 && r < 0xdc00
+}
+
+// number reads a number and writes it back in JSON's form: no leading plus,
+// no bare decimal points, and hexadecimal as decimal.
+func (s *json5Scanner) number() error {
+	start := s.pos
+	if c := s.src[s.pos]; c == '+' || c == '-' {
+		if c == '-' {
+			s.out.WriteByte('-')
+		}
+		s.pos++
+	}
+	rest := s.src[s.pos:]
+	if bytes.HasPrefix(rest, []byte("0x")) || bytes.HasPrefix(rest, []byte("0X")) {
+		end := 2
+		for end < len(rest) && isHexDigit(rest[end]) {
+			end++
+		}
+		n, err := strconv.ParseUint(string(rest[2:end]), 16, 64)
+		if err != nil {
+			literal := s.src[start : s.pos+end]
+			s.pos = start
+			return s.errorf("invalid hexadecimal number %q", literal)
+		}
+		s.out.WriteString(strconv.FormatUint(n, 10))
+		s.pos += end
+		return nil
+	}
+	if isIdentStart(s.peekByte()) {
+		// Infinity and NaN, signed.
+		s.pos = start
+		return s.errorf("%s is not a valid number here", s.word(start+1))
+	}
+
+	var number []byte
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		if !isDigit(c) && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			break
+		}
+		number = append(number, c)
+		s.pos++
+	}
+	if len(number) > 0 && number[0] == '.' {
+		number = append([]byte("0"), number...)
+	}
+	if dot := bytes.IndexByte(number, '.'); dot >= 0 && (dot+1 == len(number) || !isDigit(number[dot+1])) {
+		number = slices.Insert(number, dot+1, '0')
+	}
+	s.out.Write(number)
+	return nil
+}
+
+func (s *json5Scanner) peekByte() byte {
+	if s.pos < len(s.src) {
+		return s.src[s.pos]
+	}
+	return 0
+}
+
+// word returns the identifier starting at i.
+func (s *json5Scanner) word(i int) string {
+	end := i
+	for end < len(s.src) && isIdentPart(s.src[end]) {
+		end++
+	}
+	return string(s.src[i:end])
+}
+
+// identifier reads a literal or an unquoted object key.
+func (s *json5Scanner) identifier() error {
+	word := s.word(s.pos)
+	switch word {
+	case "true", "false", "null":
+		s.out.WriteString(word)
+		s.pos += len(word)
+		return nil
+	case "Infinity", "NaN":
+		return s.errorf("%s is not a valid number here", word)
+	}
+	s.pos += len(word)
+	if s.peek() != ':' {
+		s.pos -= len(word)
+		return s.errorf("unexpected %q; quote strings, and only object keys can go unquoted", word)
+	}
+	encoded, _ := json.Marshal(word)
+	s.out.Write(encoded)
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromJSON5(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "plain JSON",
+			input: `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "dropRate": 0.1, "tls": null, "on": true}]`,
+			want:  `[{"localPort": 8080, "upstream": "127.0.0.1:9090", "dropRate": 0.1, "tls": null, "on": true}]`,
+		},
+		{
+			name: "comments",
+			input: `// checkout's database
+			[ /* flaky since the migration */ {"localPort": 8080, // the old port
+			"latencyMs": 1/**/}]`,
+			want: `[{"localPort": 8080, "latencyMs": 1}]`,
+		},
+		{
+			name:  "comment markers inside strings",
+			input: `{"path": "/api//v1/*x*/", 'glob': '// not a comment'}`,
+			want:  `{"path": "/api//v1/*x*/", "glob": "// not a comment"}`,
+		},
+		{
+			name:  "trailing commas",
+			input: `[{"a": [1, 2, ], "b": {"c": 3, /* last */ }, }, ]`,
+			want:  `[{"a": [1, 2], "b": {"c": 3}}]`,
+		},
+		{
+			name:  "unquoted keys",
+			input: `{localPort: 8080, $weird_key2 : 1, http: {errorRate: 0.5}}`,
+			want:  `{"localPort": 8080, "$weird_key2": 1, "http": {"errorRate": 0.5}}`,
+		},
+		{
+			name:  "single-quoted strings",
+			input: `{'body': 'say "hi"', "it": 'it\'s'}`,
+			want:  `{"body": "say \"hi\"", "it": "it's"}`,
+		},
+		{
+			name: "escapes",
+			input: `["a\tb", '\x41é😀', "line \
+continued", "\/"]`,
+			want: `["a\tb", "Aé😀", "line continued", "/"]`,
+		},
+		{
+			name:  "numbers",
+			input: `[+1, .5, 5., -0x1F, 1.e3, -2.5E-1]`,
+			want:  `[1, 0.5, 5.0, -31, 1.0e3, -0.25]`,
+		},
+		{
+			name:    "unterminated comment",
+			input:   `[1 /* never closed`,
+			wantErr: "line 1, column 4: unterminated comment",
+		},
+		{
+			name:    "unterminated string",
+			input:   "[\n  'open",
+			wantErr: "line 2, column 3: unterminated string",
+		},
+		{
+			name:    "bare word",
+			input:   `{"mode": http}`,
+			wantErr: `unexpected "http"`,
+		},
+		{
+			name:    "infinity",
+			input:   `{"latencyMs": -Infinity}`,
+			wantErr: "Infinity is not a valid number here",
+		},
+		{
+			name:    "line break in string",
+			input:   "['a\nb']",
+			wantErr: "line break in string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fromJSON5([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("fromJSON5() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fromJSON5() unexpected error: %v", err)
+			}
+			var gotValue, wantValue any
+			if err := json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("fromJSON5() = %s, not valid JSON: %v", got, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("bad test case: %v", err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("fromJSON5() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
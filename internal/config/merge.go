@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ExpandPaths expands the glob patterns among the config paths, in order,
+// into the files they match. URLs and Stdin are kept as they are. A pattern
+// that matches no file is an error, like a missing file would be. A file
+// named more than once, say by a pattern and on its own, is loaded once.
+func ExpandPaths(paths []string) ([]string, error) {
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			expanded = append(expanded, path)
+		}
+	}
+	for _, path := range paths {
+		if path == Stdin || IsURL(path) || !strings.ContainsAny(path, "*?[") {
+			add(path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config pattern %q: %w", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("config pattern %q matches no files", path)
+		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+	return expanded, nil
+}
+
+// LoadConfigsWithOptions loads several config files, or glob patterns of
+// them, and merges their routes in order. Each file's profiles and defaults
+// apply only to its own routes. The merged routes are validated together,
+// so a localPort, baselinePort, name or handoffSocket used in two files is
+// reported with both.
+func LoadConfigsWithOptions(paths []string, opts LoadOptions) ([]RouteConfig, error) {
+	routes, _, err := LoadConfigsWithAccess(paths, opts)
+	return routes, err
+}
+
+// LoadConfigsWithAccess is LoadConfigsWithOptions that also returns who
+// may use the admin API, from the adminToken and tenants of the configs,
+// with the tokens they name read.
+func LoadConfigsWithAccess(paths []string, opts LoadOptions) ([]RouteConfig, Access, error) {
+	files, err := ExpandPaths(paths)
+	if err != nil {
+		slog.Error("failed to expand config paths", "error", err, "hint", "check that each -config pattern matches at least one file")
+		return nil, Access{}, err
+	}
+	if len(files) == 1 {
+		return loadConfig(files[0], opts)
+	}
+
+	var routes []RouteConfig
+	var origins []routeOrigin
+	var access Access
+	for _, path := range files {
+		source := RedactPath(path)
+		fileLogger := slog.With("file", source)
+		fileRoutes, fileAccess, err := loadFile(path, fileLogger)
+		if err != nil {
+			return nil, Access{}, err
+		}
+		if access, err = mergeAccess(access, fileAccess, fileLogger); err != nil {
+			return nil, Access{}, err
+		}
+		for i := range fileRoutes {
+			origins = append(origins, routeOrigin{logger: fileLogger, file: source, index: i})
+		}
+		routes = append(routes, fileRoutes...)
+	}
+
+	configLogger := slog.With("files", len(files))
+	if err := validateRoutes(routes, origins, opts, configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	if err := access.checkTokens(configLogger); err != nil {
+		return nil, Access{}, err
+	}
+	access.warnTenantless(routes, configLogger)
+	return routes, access, nil
+}
+
+// RejectedRoute is a route LoadConfigsValid left out, with the validation
+// messages that rejected it.
+type RejectedRoute struct {
+	Route RouteConfig
+	// Index is the route's position among every route loaded, valid or not.
+	Index int
+	Err   error
+}
+
+// LoadConfigsValid is LoadConfigsWithOptions for applying what it can of
+// configs with mistakes in them: a route that fails validation, on its own
+// or against the valid routes before it, is logged and left out, rather
+// than failing the load. Configs that can't be read or decoded, or that
+// have no routes, still fail it.
+func LoadConfigsValid(paths []string, opts LoadOptions) ([]RouteConfig, []RejectedRoute, error) {
+	files, err := ExpandPaths(paths)
+	if err != nil {
+		slog.Error("failed to expand config paths", "error", err, "hint", "check that each -config pattern matches at least one file")
+		return nil, nil, err
+	}
+
+	var routes []RouteConfig
+	var origins []routeOrigin
+	for _, path := range files {
+		source := RedactPath(path)
+		// The admin API tokens are read at startup only.
+		fileRoutes, _, err := loadFile(path, slog.With("file", source))
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range fileRoutes {
+			origins = append(origins, routeOrigin{file: source, index: i})
+		}
+		routes = append(routes, fileRoutes...)
+	}
+	if len(routes) == 0 {
+		slog.Error("empty route configuration", "files", len(files), "hint", "config file must contain at least one route")
+		return nil, nil, fmt.Errorf("validation failed: empty route configuration")
+	}
+
+	var valid []RouteConfig
+	var validOrigins []routeOrigin
+	var rejected []RejectedRoute
+	for i, route := range routes {
+		// The route's messages are collected for the rejection, and the
+		// valid routes before it have none to add.
+		var messages strings.Builder
+		origin := origins[i]
+		origin.logger = messageLogger(&messages).With("file", origin.file)
+		candidates := append(slices.Clip(valid), route)
+		candidateOrigins := append(slices.Clip(validOrigins), origin)
+		if validateRouteConfig(route, origin.index, opts, origin.logger) != nil ||
+			!validateConflicts(candidates, candidateOrigins, opts, origin.logger) {
+			err := fmt.Errorf("validation failed:\n%s", strings.TrimSpace(messages.String()))
+			slog.Warn("route left out of config",
+				"file", origin.file,
+				"route_index", origin.index,
+				"port", route.LocalPort,
+				"error", err,
+				"hint", "fix the route in its config file")
+			rejected = append(rejected, RejectedRoute{Route: route, Index: i, Err: err})
+			continue
+		}
+		valid, validOrigins = candidates, candidateOrigins
+	}
+	return valid, rejected, nil
+}
+
+// messageLogger returns a logger writing validation messages to w as text,
+// without timestamps, to be reported along with the route they are about.
+func messageLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
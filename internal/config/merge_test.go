@@ -0,0 +1,118 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigs(t *testing.T) {
+	files := map[string]string{
+		"payments.json": `{
+			"profiles": {"slow": {"latencyMs": 250}},
+			"defaults": {"dropRate": 0.1},
+			"routes": [{"name": "payments", "localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "slow"}]
+		}`,
+		"search.json":  `[{"name": "search", "localPort": 8081, "upstream": "127.0.0.1:9091"}]`,
+		"empty.json":   `[]`,
+		"clash.json":   `[{"localPort": 8080, "upstream": "127.0.0.1:9092"}]`,
+		"renamed.json": `[{"name": "search", "localPort": 8082, "upstream": "127.0.0.1:9092"}]`,
+		"profile.json": `[{"localPort": 8083, "upstream": "127.0.0.1:9093", "profile": "slow"}]`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test config file: %v", err)
+		}
+	}
+
+	payments := RouteConfig{Name: "payments", LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: "slow", LatencyMs: 250, DropRate: 0.1}
+	search := RouteConfig{Name: "search", LocalPort: 8081, Upstream: "127.0.0.1:9091"}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		wantErr bool
+		want    []RouteConfig
+	}{
+		{name: "single file", paths: []string{"search.json"}, want: []RouteConfig{search}},
+		{name: "merged in order", paths: []string{"search.json", "payments.json"}, want: []RouteConfig{search, payments}},
+		{name: "empty file", paths: []string{"payments.json", "empty.json"}, want: []RouteConfig{payments}},
+		{name: "glob", paths: []string{"[ps][ae]*.json"}, want: []RouteConfig{payments, search}},
+		{name: "file named twice", paths: []string{"search.json", "s*.json"}, want: []RouteConfig{search}},
+		{name: "only empty files", paths: []string{"empty.json", "empty.json"}, wantErr: true},
+		{name: "port used in two files", paths: []string{"payments.json", "clash.json"}, wantErr: true},
+		{name: "name used in two files", paths: []string{"search.json", "renamed.json"}, wantErr: true},
+		{name: "profile from another file", paths: []string{"payments.json", "profile.json"}, wantErr: true},
+		{name: "glob without matches", paths: []string{"missing-*.json"}, wantErr: true},
+		{name: "missing file", paths: []string{"search.json", "missing.json"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make([]string, len(tt.paths))
+			for i, path := range tt.paths {
+				paths[i] = filepath.Join(dir, path)
+			}
+
+			routes, err := LoadConfigsWithOptions(paths, LoadOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfigsWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(routes, tt.want) {
+				t.Errorf("LoadConfigsWithOptions() = %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigsValid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routes.json")
+	content := `[
+		{"name": "payments", "localPort": 8080, "upstream": "127.0.0.1:9090"},
+		{"name": "broken", "localPort": 8081, "upstream": "127.0.0.1:9091", "dropRate": 2},
+		{"name": "clash", "localPort": 8080, "upstream": "127.0.0.1:9092"},
+		{"name": "search", "localPort": 8082, "upstream": "127.0.0.1:9093"}
+	]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config file: %v", err)
+	}
+
+	routes, rejected, err := LoadConfigsValid([]string{path}, LoadOptions{})
+	if err != nil {
+		t.Fatalf("LoadConfigsValid() error = %v", err)
+	}
+	var names []string
+	for _, route := range routes {
+		names = append(names, route.Name)
+	}
+	if want := []string{"payments", "search"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("valid routes = %v, want %v", names, want)
+	}
+
+	wantRejected := []struct {
+		index   int
+		message string
+	}{
+		{1, "invalid drop rate"},
+		{2, "duplicate local port"},
+	}
+	if len(rejected) != len(wantRejected) {
+		t.Fatalf("rejected %d routes, want %d: %v", len(rejected), len(wantRejected), rejected)
+	}
+	for i, want := range wantRejected {
+		if rejected[i].Index != want.index {
+			t.Errorf("rejected[%d].Index = %d, want %d", i, rejected[i].Index, want.index)
+		}
+		if !strings.Contains(rejected[i].Err.Error(), want.message) {
+			t.Errorf("rejected[%d].Err = %v, want it to contain %q", i, rejected[i].Err, want.message)
+		}
+	}
+
+	if _, _, err := LoadConfigsValid([]string{filepath.Join(dir, "missing.json")}, LoadOptions{}); err == nil {
+		t.Error("LoadConfigsValid() of a missing file succeeded")
+	}
+}
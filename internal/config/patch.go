@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+)
+
+// ParseRoute decodes one route written as in a config file, flat or with a
+// "chaos" section, and expands its profile, which must be a built-in one.
+// The route still needs validating.
+func ParseRoute(data []byte) (RouteConfig, error) {
+	flat, err := flattenChaosSection(data, "route")
+	if err != nil {
+		return RouteConfig{}, err
+	}
+	var route RouteConfig
+	if err := decodeStrict(flat, &route); err != nil {
+		return RouteConfig{}, err
+	}
+	if err := route.ExpandProfile(); err != nil {
+		return RouteConfig{}, err
+	}
+	return route, nil
+}
+
+// PatchRoute returns route with the settings in patch, written as in a
+// config file, flat or with a "chaos" section. Settings patch leaves out are
+// kept, and each one it sets is taken whole, as with defaults. A profile it
+// sets fills in what route doesn't set itself. The route still needs
+// validating.
+func PatchRoute(route RouteConfig, patch []byte) (RouteConfig, error) {
+	flat, err := flattenChaosSection(patch, "patch")
+	if err != nil {
+		return RouteConfig{}, err
+	}
+	var changes map[string]json.RawMessage
+	if err := json.Unmarshal(flat, &changes); err != nil {
+		return RouteConfig{}, err
+	}
+
+	current, err := json.Marshal(route)
+	if err != nil {
+		return RouteConfig{}, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(current, &fields); err != nil {
+		return RouteConfig{}, err
+	}
+	maps.Copy(fields, changes)
+
+	var patched RouteConfig
+	if err := decodeRoute(fields, &patched); err != nil {
+		return RouteConfig{}, err
+	}
+	if _, ok := changes["profile"]; ok {
+		if err := patched.ExpandProfile(); err != nil {
+			return RouteConfig{}, err
+		}
+	}
+	return patched, nil
+}
+
+// ExpandProfile fills in the route's built-in profile.
+func (r *RouteConfig) ExpandProfile() error {
+	if r.Profile == "" {
+		return nil
+	}
+	profile, ok := BuiltinProfiles[r.Profile]
+	if !ok {
+		return fmt.Errorf("unknown chaos profile %q, want one of %v", r.Profile, profileNames(nil))
+	}
+	r.applyProfile(profile)
+	return nil
+}
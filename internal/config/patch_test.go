@@ -0,0 +1,102 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    RouteConfig
+		wantErr bool
+	}{
+		{
+			name: "flat",
+			body: `{"localPort": 8080, "upstream": "127.0.0.1:9090", "dropRate": 0.1}`,
+			want: RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", DropRate: 0.1},
+		},
+		{
+			name: "chaos section",
+			body: `{"localPort": 8080, "upstream": "127.0.0.1:9090", "chaos": {"latency": {"delayMs": "1s"}}}`,
+			want: RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", LatencyMs: 1000},
+		},
+		{
+			name: "built-in profile",
+			body: `{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "datacenter-partition"}`,
+			want: RouteConfig{LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: "datacenter-partition", BurstLoss: BuiltinProfiles["datacenter-partition"].BurstLoss},
+		},
+		{name: "unknown profile", body: `{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "slow"}`, wantErr: true},
+		{name: "unknown field", body: `{"localPort": 8080, "upstream": "127.0.0.1:9090", "latency": 100}`, wantErr: true},
+		{name: "not an object", body: `[{"localPort": 8080}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoute([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRoute() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchRoute(t *testing.T) {
+	route := RouteConfig{
+		Name:      "orders",
+		LocalPort: 8080,
+		Upstream:  "127.0.0.1:9090",
+		DropRate:  0.1,
+		LatencyMs: 100,
+		HTTP:      &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 503},
+	}
+
+	tests := []struct {
+		name    string
+		patch   string
+		want    RouteConfig
+		wantErr bool
+	}{
+		{
+			name:  "flat",
+			patch: `{"dropRate": 0.5}`,
+			want:  RouteConfig{Name: "orders", LocalPort: 8080, Upstream: "127.0.0.1:9090", DropRate: 0.5, LatencyMs: 100, HTTP: &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 503}},
+		},
+		{
+			name:  "chaos section and zero",
+			patch: `{"chaos": {"drop": {"rate": 0}, "latency": {"delayMs": "2s"}}}`,
+			want:  RouteConfig{Name: "orders", LocalPort: 8080, Upstream: "127.0.0.1:9090", LatencyMs: 2000, HTTP: &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 503}},
+		},
+		{
+			name:  "object taken whole",
+			patch: `{"http": {"errorRate": 0.2}}`,
+			want:  RouteConfig{Name: "orders", LocalPort: 8080, Upstream: "127.0.0.1:9090", DropRate: 0.1, LatencyMs: 100, HTTP: &HTTPConfig{ErrorRate: 0.2}},
+		},
+		{
+			name:  "profile",
+			patch: `{"profile": "3g", "dropRate": 0}`,
+			want:  RouteConfig{Name: "orders", LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: "3g", DropRate: 0.02, LatencyMs: 100, HTTP: &HTTPConfig{ErrorRate: 0.1, ErrorStatus: 503}, Toxics: BuiltinProfiles["3g"].Toxics},
+		},
+		{name: "unknown field", patch: `{"dropPercent": 5}`, wantErr: true},
+		{name: "wrong type", patch: `{"dropRate": "high"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PatchRoute(route, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PatchRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PatchRoute() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if route.DropRate != 0.1 || route.HTTP.ErrorRate != 0.1 {
+		t.Errorf("PatchRoute() changed the route it patched: %+v", route)
+	}
+}
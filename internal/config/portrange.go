@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// errInvalidPortRange is returned for a route whose port range can't be
+// expanded into routes.
+var errInvalidPortRange = errors.New("invalid port range")
+
+// maxPortRange caps how many routes one port range expands into, so a typo
+// like "8000-80100" doesn't open thousands of listeners.
+const maxPortRange = 1024
+
+// upstreamPortPlaceholder in a route's upstream is replaced with the port
+// of each route its localPort range expands into, plus upstreamPortOffset.
+const upstreamPortPlaceholder = "{port}"
+
+// expandPortRanges replaces every route whose localPort is a range such as
+// "8000-8015" with one route per port, in order. Other routes are left as
+// they are.
+func expandPortRanges(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		return expandRoutes(data)
+	}
+
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		// Left for the strict decode to report.
+		return data, nil
+	}
+	raw, ok := file["routes"]
+	if !ok {
+		return data, nil
+	}
+	routes, err := expandRoutes(raw)
+	if err != nil {
+		return nil, err
+	}
+	file["routes"] = routes
+	return json.Marshal(file)
+}
+
+func expandRoutes(data []byte) ([]byte, error) {
+	var routes []json.RawMessage
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return data, nil
+	}
+	var expanded []json.RawMessage
+	for i, route := range routes {
+		ports, err := expandRoute(route, i)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, ports...)
+	}
+	return json.Marshal(expanded)
+}
+
+// expandRoute expands the route in data, the i-th of its file, into one
+// route per port of its localPort range, filling in the upstream template.
+func expandRoute(data json.RawMessage, i int) ([]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return []json.RawMessage{data}, nil
+	}
+	var upstream string
+	if raw, ok := fields["upstream"]; ok && json.Unmarshal(raw, &upstream) != nil {
+		return []json.RawMessage{data}, nil
+	}
+	rawRange, isRange := fields["localPort"]
+	isRange = isRange && bytes.HasPrefix(bytes.TrimSpace(rawRange), []byte(`"`))
+	template := strings.Contains(upstream, upstreamPortPlaceholder)
+	rawOffset, hasOffset := fields["upstreamPortOffset"]
+	if !isRange && !template && !hasOffset {
+		return []json.RawMessage{data}, nil
+	}
+
+	var offset int
+	if hasOffset {
+		if !template {
+			return nil, fmt.Errorf("%w: route %d: upstreamPortOffset needs %s in upstream, got %q", errInvalidPortRange, i, upstreamPortPlaceholder, upstream)
+		}
+		if err := json.Unmarshal(rawOffset, &offset); err != nil {
+			return nil, fmt.Errorf("%w: route %d: upstreamPortOffset must be an integer", errInvalidPortRange, i)
+		}
+		delete(fields, "upstreamPortOffset")
+	}
+
+	var first, last int
+	if isRange {
+		var text string
+		if err := json.Unmarshal(rawRange, &text); err != nil {
+			return nil, fmt.Errorf("%w: route %d: localPort must be a port or a range such as \"8000-8015\"", errInvalidPortRange, i)
+		}
+		var err error
+		if first, last, err = parsePortRange(text); err != nil {
+			return nil, fmt.Errorf("%w: route %d: %v", errInvalidPortRange, i, err)
+		}
+		for _, key := range []string{"baselinePort", "handoffSocket"} {
+			if _, ok := fields[key]; ok {
+				return nil, fmt.Errorf("%w: route %d: %s can't be shared by the routes of a port range", errInvalidPortRange, i, key)
+			}
+		}
+	} else if err := json.Unmarshal(rawRange, &first); err != nil {
+		// A missing or malformed localPort is left for validation to report.
+		return []json.RawMessage{data}, nil
+	} else {
+		last = first
+	}
+
+	var name string
+	if raw, ok := fields["name"]; ok {
+		json.Unmarshal(raw, &name)
+	}
+
+	var routes []json.RawMessage
+	for port := first; port <= last; port++ {
+		route := maps.Clone(fields)
+		route["localPort"] = json.RawMessage(strconv.Itoa(port))
+		if template {
+			upstreamPort := port + offset
+			if upstreamPort < 1 || upstreamPort > 65535 {
+				return nil, fmt.Errorf("%w: route %d: upstream port %d for localPort %d is out of range 1-65535", errInvalidPortRange, i, upstreamPort, port)
+			}
+			raw, err := json.Marshal(strings.ReplaceAll(upstream, upstreamPortPlaceholder, strconv.Itoa(upstreamPort)))
+			if err != nil {
+				return nil, err
+			}
+			route["upstream"] = raw
+		}
+		if name != "" && isRange {
+			raw, err := json.Marshal(fmt.Sprintf("%s-%d", name, port))
+			if err != nil {
+				return nil, err
+			}
+			route["name"] = raw
+		}
+		raw, err := json.Marshal(route)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, raw)
+	}
+	return routes, nil
+}
+
+// parsePortRange parses a port range such as "8000-8015", both ends
+// included.
+func parsePortRange(text string) (first, last int, err error) {
+	from, to, ok := strings.Cut(text, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("localPort %q must be a number, or a range such as \"8000-8015\"", text)
+	}
+	first, err1 := strconv.Atoi(strings.TrimSpace(from))
+	last, err2 := strconv.Atoi(strings.TrimSpace(to))
+	switch {
+	case err1 != nil || err2 != nil:
+		return 0, 0, fmt.Errorf("localPort range %q must be two ports joined by \"-\"", text)
+	case first < 1 || last > 65535 || first > last:
+		return 0, 0, fmt.Errorf("localPort range %q must run from a lower to a higher port within 1-65535", text)
+	case last-first+1 > maxPortRange:
+		return 0, 0, fmt.Errorf("localPort range %q has %d ports, more than the %d a range may have", text, last-first+1, maxPortRange)
+	}
+	return first, last, nil
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig_PortRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileContent string
+		wantErr     bool
+		errContains string
+		want        []RouteConfig
+	}{
+		{
+			name:        "shared upstream",
+			fileContent: `[{"localPort": "8000-8002", "upstream": "127.0.0.1:9090", "latencyMs": 100}]`,
+			want: []RouteConfig{
+				{LocalPort: 8000, Upstream: "127.0.0.1:9090", LatencyMs: 100},
+				{LocalPort: 8001, Upstream: "127.0.0.1:9090", LatencyMs: 100},
+				{LocalPort: 8002, Upstream: "127.0.0.1:9090", LatencyMs: 100},
+			},
+		},
+		{
+			name: "upstream template with offset and names",
+			fileContent: `{
+				"defaults": {"dropRate": 0.1},
+				"routes": [
+					{"name": "shard", "localPort": "8000-8001", "upstream": "10.0.0.5:{port}", "upstreamPortOffset": 1000},
+					{"localPort": 8100, "upstream": "127.0.0.1:9090"}
+				]
+			}`,
+			want: []RouteConfig{
+				{Name: "shard-8000", LocalPort: 8000, Upstream: "10.0.0.5:9000", DropRate: 0.1},
+				{Name: "shard-8001", LocalPort: 8001, Upstream: "10.0.0.5:9001", DropRate: 0.1},
+				{LocalPort: 8100, Upstream: "127.0.0.1:9090", DropRate: 0.1},
+			},
+		},
+		{
+			name:        "template on a single port",
+			fileContent: `[{"localPort": 8000, "upstream": "127.0.0.1:{port}", "upstreamPortOffset": -1000}]`,
+			want: []RouteConfig{
+				{LocalPort: 8000, Upstream: "127.0.0.1:7000"},
+			},
+		},
+		{
+			name:        "overlaps another route",
+			fileContent: `[{"localPort": "8000-8002", "upstream": "127.0.0.1:9090"}, {"localPort": 8001, "upstream": "127.0.0.1:9091"}]`,
+			wantErr:     true,
+			errContains: "validation failed",
+		},
+		{
+			name:        "reversed",
+			fileContent: `[{"localPort": "8002-8000", "upstream": "127.0.0.1:9090"}]`,
+			wantErr:     true,
+			errContains: "lower to a higher port",
+		},
+		{
+			name:        "not a range",
+			fileContent: `[{"localPort": "8000", "upstream": "127.0.0.1:9090"}]`,
+			wantErr:     true,
+			errContains: "must be a number, or a range",
+		},
+		{
+			name:        "too many ports",
+			fileContent: `[{"localPort": "1-65535", "upstream": "127.0.0.1:9090"}]`,
+			wantErr:     true,
+			errContains: "more than the 1024",
+		},
+		{
+			name:        "offset without template",
+			fileContent: `[{"localPort": "8000-8001", "upstream": "127.0.0.1:9090", "upstreamPortOffset": 1000}]`,
+			wantErr:     true,
+			errContains: "upstreamPortOffset needs {port}",
+		},
+		{
+			name:        "upstream port out of range",
+			fileContent: `[{"localPort": "65000-65001", "upstream": "127.0.0.1:{port}", "upstreamPortOffset": 535}]`,
+			wantErr:     true,
+			errContains: "upstream port 65536",
+		},
+		{
+			name:        "baseline port",
+			fileContent: `[{"localPort": "8000-8001", "upstream": "127.0.0.1:9090", "baselinePort": 9000}]`,
+			wantErr:     true,
+			errContains: "baselinePort can't be shared",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tt.fileContent), 0644); err != nil {
+				t.Fatalf("failed to write test config file: %v", err)
+			}
+
+			routes, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !contains(err.Error(), tt.errContains) {
+					t.Errorf("LoadConfig() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if !reflect.DeepEqual(routes, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// PortRule overrides a transparent route's chaos for the connections whose
+// original destination port is in Ports, so one listener can degrade
+// database, cache and API traffic differently. Settings it leaves out are
+// the route's.
+type PortRule struct {
+	Ports     PortRange     `json:"ports"`
+	DropRate  *float64      `json:"dropRate,omitempty"`
+	LatencyMs *Milliseconds `json:"latencyMs,omitempty"`
+	// Toxics replace the route's toxics whole when set; an empty list
+	// removes them, so it isn't left out when written back.
+	Toxics []ToxicConfig `json:"toxics"`
+}
+
+// PortRange is an inclusive range of ports, written as a port number, 5432,
+// or a range in a string, "6379-6380".
+type PortRange struct {
+	First, Last int
+}
+
+func (p *PortRange) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(`"`)) {
+		var port int
+		if err := json.Unmarshal(data, &port); err != nil {
+			return err
+		}
+		*p = PortRange{First: port, Last: port}
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	first, last, isRange := strings.Cut(text, "-")
+	var err error
+	if p.First, err = strconv.Atoi(strings.TrimSpace(first)); err != nil {
+		return fmt.Errorf("invalid port range %q: use a port, such as 5432, or a range, such as \"6379-6380\"", text)
+	}
+	p.Last = p.First
+	if isRange {
+		if p.Last, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return fmt.Errorf("invalid port range %q: use a port, such as 5432, or a range, such as \"6379-6380\"", text)
+		}
+	}
+	return nil
+}
+
+func (p PortRange) MarshalJSON() ([]byte, error) {
+	if p.First == p.Last {
+		return json.Marshal(p.First)
+	}
+	return json.Marshal(p.String())
+}
+
+func (p PortRange) String() string {
+	if p.First == p.Last {
+		return strconv.Itoa(p.First)
+	}
+	return fmt.Sprintf("%d-%d", p.First, p.Last)
+}
+
+// Contains reports whether port is in the range.
+func (p PortRange) Contains(port int) bool {
+	return port >= p.First && port <= p.Last
+}
+
+// PortRuleFor returns the index of the route's port rule for connections
+// originally headed to port, or -1 when none has it.
+func (r RouteConfig) PortRuleFor(port int) int {
+	for i, rule := range r.PortRules {
+		if rule.Ports.Contains(port) {
+			return i
+		}
+	}
+	return -1
+}
+
+// WithPortRule returns the route with the chaos of its i-th port rule in
+// place of its own.
+func (r RouteConfig) WithPortRule(i int) RouteConfig {
+	rule := r.PortRules[i]
+	if rule.DropRate != nil {
+		r.DropRate = *rule.DropRate
+	}
+	if rule.LatencyMs != nil {
+		r.LatencyMs = *rule.LatencyMs
+	}
+	if rule.Toxics != nil {
+		r.Toxics = rule.Toxics
+	}
+	r.PortRules = nil
+	return r
+}
+
+// validatePortRules logs every problem with a route's port rules and
+// reports whether they are valid.
+func validatePortRules(config RouteConfig, routeLogger *slog.Logger) bool {
+	valid := true
+	if len(config.PortRules) > 0 && config.Mode != ModeTransparent {
+		routeLogger.Error("port rules without transparent mode",
+			"mode", config.Mode,
+			"hint", fmt.Sprintf("portRules pick chaos by the port a redirected connection was headed to, so they only apply to routes with mode %q", ModeTransparent))
+		valid = false
+	}
+	for i, rule := range config.PortRules {
+		ruleLogger := routeLogger.With("port_rule_index", i)
+		ports := rule.Ports
+		if ports.First < 1 || ports.Last > 65535 || ports.First > ports.Last {
+			ruleLogger.Error("invalid port rule ports",
+				"ports", ports.String(),
+				"valid_range", "1-65535",
+				"hint", "portRules ports must be a port, or a range from a lower port to a higher one, between 1 and 65535")
+			valid = false
+		}
+		for j, other := range config.PortRules[:i] {
+			if ports.First <= other.Ports.Last && other.Ports.First <= ports.Last {
+				ruleLogger.Error("overlapping port rules",
+					"ports", ports.String(),
+					"other_port_rule_index", j,
+					"other_ports", other.Ports.String(),
+					"hint", "each original destination port may be in one port rule only")
+				valid = false
+			}
+		}
+		if rule.DropRate != nil && (*rule.DropRate < 0.0 || *rule.DropRate > 1.0) {
+			ruleLogger.Error("invalid drop rate",
+				"drop_rate", *rule.DropRate,
+				"valid_range", "0.0-1.0",
+				"hint", fmt.Sprintf("portRules dropRate must be between 0.0 and 1.0 (probability), got %.2f", *rule.DropRate))
+			valid = false
+		}
+		if rule.DropRate != nil && config.BurstLoss != nil {
+			ruleLogger.Error("conflicting loss options",
+				"ports", ports.String(),
+				"hint", "the route's burstLoss replaces dropRate, so a port rule can't set one; remove burstLoss or the rule's dropRate")
+			valid = false
+		}
+		if rule.LatencyMs != nil && *rule.LatencyMs < 0 {
+			ruleLogger.Error("invalid latency",
+				"latency_ms", *rule.LatencyMs,
+				"valid_range", ">= 0",
+				"hint", fmt.Sprintf("portRules latencyMs must be >= 0 (milliseconds), got %d", *rule.LatencyMs))
+			valid = false
+		}
+		for k, toxic := range rule.Toxics {
+			if !validateToxicConfig(toxic, ruleLogger.With("toxic_index", k)) {
+				valid = false
+			}
+		}
+	}
+	return valid
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPortRange_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    PortRange
+		wantErr bool
+	}{
+		{name: "port", data: `5432`, want: PortRange{5432, 5432}},
+		{name: "port string", data: `"5432"`, want: PortRange{5432, 5432}},
+		{name: "range", data: `"6379-6380"`, want: PortRange{6379, 6380}},
+		{name: "range with spaces", data: `"6379 - 6380"`, want: PortRange{6379, 6380}},
+		{name: "not a number", data: `"redis"`, wantErr: true},
+		{name: "open range", data: `"6379-"`, wantErr: true},
+		{name: "fractional", data: `5432.5`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PortRange
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.data, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.data, got, tt.want)
+			}
+			// It writes back as it was read, spaces aside.
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal(%+v) error = %v", got, err)
+			}
+			var again PortRange
+			if err := json.Unmarshal(data, &again); err != nil || again != got {
+				t.Errorf("Marshal(%+v) = %s, which reads back as %+v, %v", got, data, again, err)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_WithPortRule(t *testing.T) {
+	drop := 0.5
+	latency := Milliseconds(250)
+	route := RouteConfig{
+		Mode:      ModeTransparent,
+		DropRate:  0.1,
+		LatencyMs: 100,
+		Toxics:    []ToxicConfig{{Type: ToxicBandwidth, BytesPerSecond: 1024}},
+		PortRules: []PortRule{
+			{Ports: PortRange{5432, 5432}, DropRate: &drop},
+			{Ports: PortRange{6379, 6380}, LatencyMs: &latency, Toxics: []ToxicConfig{}},
+		},
+	}
+
+	tests := []struct {
+		port        int
+		wantRule    int
+		wantDrop    float64
+		wantLatency Milliseconds
+		wantToxics  int
+	}{
+		{port: 5432, wantRule: 0, wantDrop: 0.5, wantLatency: 100, wantToxics: 1},
+		{port: 6380, wantRule: 1, wantDrop: 0.1, wantLatency: 250, wantToxics: 0},
+		{port: 443, wantRule: -1},
+	}
+	for _, tt := range tests {
+		i := route.PortRuleFor(tt.port)
+		if i != tt.wantRule {
+			t.Errorf("PortRuleFor(%d) = %d, want %d", tt.port, i, tt.wantRule)
+			continue
+		}
+		if i < 0 {
+			continue
+		}
+		got := route.WithPortRule(i)
+		if got.DropRate != tt.wantDrop || got.LatencyMs != tt.wantLatency || len(got.Toxics) != tt.wantToxics || got.PortRules != nil {
+			t.Errorf("WithPortRule(%d) = drop %v latency %d toxics %d rules %v, want drop %v latency %d toxics %d and no rules",
+				i, got.DropRate, got.LatencyMs, len(got.Toxics), got.PortRules, tt.wantDrop, tt.wantLatency, tt.wantToxics)
+		}
+	}
+}
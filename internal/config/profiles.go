@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// Profile is a named set of chaos settings a route can pull in with
+// "profile". Settings the route sets itself win over the profile's.
+type Profile struct {
+	DropRate  float64          `json:"dropRate,omitempty"`
+	LatencyMs Milliseconds     `json:"latencyMs,omitempty"`
+	BurstLoss *BurstLossConfig `json:"burstLoss,omitempty"`
+	// Toxics run ahead of the route's own toxics.
+	Toxics []ToxicConfig `json:"toxics,omitempty"`
+}
+
+// BuiltinProfiles are the presets available without defining them in the
+// config file. The numbers are rough figures for each kind of link, meant as
+// a starting point rather than a faithful model.
+var BuiltinProfiles = map[string]Profile{
+	// Home or office Wi-Fi with interference: modest, very jittery latency
+	// and short bursts of lost connections.
+	"flaky-wifi": {
+		BurstLoss: &BurstLossConfig{GoodToBad: 0.05, BadToGood: 0.5, BadDropRate: float64Ptr(0.5)},
+		Toxics: []ToxicConfig{
+			{Type: ToxicLatency, LatencyMs: 15, JitterMs: 15},
+			{Type: ToxicBandwidth, BytesPerSecond: 2_500_000},
+		},
+	},
+	// Mobile 3G: around 300ms round trip, ~1.6 Mbit/s down and ~768 kbit/s
+	// up, occasional drops.
+	"3g": {
+		DropRate: 0.02,
+		Toxics: []ToxicConfig{
+			{Type: ToxicLatency, LatencyMs: 150, JitterMs: 50},
+			{Type: ToxicBandwidth, BytesPerSecond: 200_000, Stream: StreamDownstream},
+			{Type: ToxicBandwidth, BytesPerSecond: 96_000, Stream: StreamUpstream},
+		},
+	},
+	// Geostationary satellite: around 600ms round trip, ~25 Mbit/s down and
+	// ~3 Mbit/s up.
+	"satellite": {
+		DropRate: 0.01,
+		Toxics: []ToxicConfig{
+			{Type: ToxicLatency, LatencyMs: 300, JitterMs: 20},
+			{Type: ToxicBandwidth, BytesPerSecond: 3_000_000, Stream: StreamDownstream},
+			{Type: ToxicBandwidth, BytesPerSecond: 375_000, Stream: StreamUpstream},
+		},
+	},
+	// A network partition between datacenters: the link is mostly fine, but
+	// now and then every connection fails for a stretch.
+	"datacenter-partition": {
+		BurstLoss: &BurstLossConfig{GoodToBad: 0.02, BadToGood: 0.1, BadDropRate: float64Ptr(1.0)},
+	},
+}
+
+// applyProfiles expands each route's profile in place. User-defined
+// profiles take precedence over built-in ones of the same name.
+func applyProfiles(routes []RouteConfig, userProfiles map[string]Profile, configLogger *slog.Logger) error {
+	hasErrors := false
+
+	for i := range routes {
+		route := &routes[i]
+		if route.Profile == "" {
+			continue
+		}
+
+		profile, ok := findProfile(route.Profile, userProfiles)
+		if !ok {
+			configLogger.Error("unknown chaos profile",
+				"route_index", i,
+				"profile", route.Profile,
+				"valid_values", profileNames(userProfiles),
+				"hint", fmt.Sprintf("profile must be a built-in profile or one defined under \"profiles\", got %q", route.Profile))
+			hasErrors = true
+			continue
+		}
+
+		route.applyProfile(profile)
+	}
+
+	if hasErrors {
+		return fmt.Errorf("validation failed: see error messages above for details")
+	}
+	return nil
+}
+
+// findProfile looks name up among the user-defined profiles, then the
+// built-in ones.
+func findProfile(name string, userProfiles map[string]Profile) (Profile, bool) {
+	if profile, ok := userProfiles[name]; ok {
+		return profile, true
+	}
+	profile, ok := BuiltinProfiles[name]
+	return profile, ok
+}
+
+// applyProfile fills in the profile's settings where the route has none of
+// its own. The loss settings are taken as a group so a route's dropRate is
+// never combined with a profile's burstLoss.
+func (r *RouteConfig) applyProfile(profile Profile) {
+	if r.DropRate == 0 && r.BurstLoss == nil {
+		r.DropRate = profile.DropRate
+		r.BurstLoss = profile.BurstLoss
+	}
+	if r.LatencyMs == 0 {
+		r.LatencyMs = profile.LatencyMs
+	}
+	if len(profile.Toxics) > 0 {
+		r.Toxics = append(slices.Clone(profile.Toxics), r.Toxics...)
+	}
+}
+
+// profileNames lists every profile name available to routes.
+func profileNames(userProfiles map[string]Profile) []string {
+	names := slices.Collect(maps.Keys(BuiltinProfiles))
+	for name := range userProfiles {
+		if _, builtin := BuiltinProfiles[name]; !builtin {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
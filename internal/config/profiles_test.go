@@ -0,0 +1,186 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestBuiltinProfiles tests that every built-in profile expands to a valid route
+func TestBuiltinProfiles(t *testing.T) {
+	for name := range BuiltinProfiles {
+		t.Run(name, func(t *testing.T) {
+			routes := []RouteConfig{{LocalPort: 8080, Upstream: "127.0.0.1:9090", Profile: name}}
+
+			if err := applyProfiles(routes, nil, testLogger()); err != nil {
+				t.Fatalf("applyProfiles() error = %v", err)
+			}
+			if err := validateRouteConfig(routes[0], 0, LoadOptions{}, testLogger()); err != nil {
+				t.Errorf("profile %q expands to an invalid route: %v", name, err)
+			}
+		})
+	}
+}
+
+func TestRouteConfig_ApplyProfile(t *testing.T) {
+	profile := Profile{
+		DropRate:  0.1,
+		LatencyMs: 50,
+		Toxics:    []ToxicConfig{{Type: ToxicLatency, LatencyMs: 10}},
+	}
+
+	tests := []struct {
+		name  string
+		route RouteConfig
+		want  RouteConfig
+	}{
+		{
+			name:  "profile fills unset fields",
+			route: RouteConfig{},
+			want: RouteConfig{
+				DropRate:  0.1,
+				LatencyMs: 50,
+				Toxics:    []ToxicConfig{{Type: ToxicLatency, LatencyMs: 10}},
+			},
+		},
+		{
+			name: "route settings win",
+			route: RouteConfig{
+				DropRate:  0.5,
+				LatencyMs: 200,
+			},
+			want: RouteConfig{
+				DropRate:  0.5,
+				LatencyMs: 200,
+				Toxics:    []ToxicConfig{{Type: ToxicLatency, LatencyMs: 10}},
+			},
+		},
+		{
+			name: "route burst loss keeps profile drop rate out",
+			route: RouteConfig{
+				BurstLoss: &BurstLossConfig{GoodToBad: 0.1, BadToGood: 0.5},
+			},
+			want: RouteConfig{
+				LatencyMs: 50,
+				BurstLoss: &BurstLossConfig{GoodToBad: 0.1, BadToGood: 0.5},
+				Toxics:    []ToxicConfig{{Type: ToxicLatency, LatencyMs: 10}},
+			},
+		},
+		{
+			name: "profile toxics run first",
+			route: RouteConfig{
+				Toxics: []ToxicConfig{{Type: ToxicDrop, Rate: 0.5}},
+			},
+			want: RouteConfig{
+				DropRate:  0.1,
+				LatencyMs: 50,
+				Toxics: []ToxicConfig{
+					{Type: ToxicLatency, LatencyMs: 10},
+					{Type: ToxicDrop, Rate: 0.5},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := tt.route
+			route.applyProfile(profile)
+
+			if !reflect.DeepEqual(route, tt.want) {
+				t.Errorf("applyProfile() = %+v, want %+v", route, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Profiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileContent string
+		wantErr     bool
+		wantLatency Milliseconds
+	}{
+		{
+			name: "built-in profile",
+			fileContent: `[
+				{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "3g"}
+			]`,
+			wantErr: false,
+		},
+		{
+			name: "user-defined profile",
+			fileContent: `{
+				"profiles": {
+					"slow-db": {"latencyMs": 250}
+				},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "slow-db"}
+				]
+			}`,
+			wantErr:     false,
+			wantLatency: 250,
+		},
+		{
+			name: "user profile shadows built-in",
+			fileContent: `{
+				"profiles": {
+					"3g": {"latencyMs": 75}
+				},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "3g"}
+				]
+			}`,
+			wantErr:     false,
+			wantLatency: 75,
+		},
+		{
+			name: "unknown profile",
+			fileContent: `[
+				{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "dial-up"}
+			]`,
+			wantErr: true,
+		},
+		{
+			name: "invalid user profile",
+			fileContent: `{
+				"profiles": {
+					"broken": {"dropRate": 2.0}
+				},
+				"routes": [
+					{"localPort": 8080, "upstream": "127.0.0.1:9090", "profile": "broken"}
+				]
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "unknown top-level field",
+			fileContent: `{
+				"routes": [{"localPort": 8080, "upstream": "127.0.0.1:9090"}],
+				"extra": true
+			}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(configPath, []byte(tt.fileContent), 0644); err != nil {
+				t.Fatalf("failed to write test config file: %v", err)
+			}
+
+			routes, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if routes[0].LatencyMs != tt.wantLatency {
+				t.Errorf("LatencyMs = %d, want %d", routes[0].LatencyMs, tt.wantLatency)
+			}
+		})
+	}
+}
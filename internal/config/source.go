@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Stdin is the config path that reads the config from standard input.
+const Stdin = "-"
+
+const (
+	// fetchTimeout bounds fetching a config from a URL.
+	fetchTimeout = 10 * time.Second
+	// maxConfigSize caps how much of a config is read from stdin or a URL.
+	maxConfigSize = 16 << 20
+)
+
+// IsURL reports whether the config path is an http or https URL to fetch
+// the config from.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// ReadConfig reads the raw config at path: a file, Stdin, or an http or
+// https URL. A URL may carry credentials as userinfo, sent as basic auth.
+func ReadConfig(path string) ([]byte, error) {
+	switch {
+	case path == Stdin:
+		return readLimited(os.Stdin)
+	case IsURL(path):
+		return fetchConfig(path)
+	default:
+		return os.ReadFile(path)
+	}
+}
+
+func fetchConfig(rawURL string) ([]byte, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching config: %s", resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConfigSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("config is larger than %d bytes", maxConfigSize)
+	}
+	return data, nil
+}
+
+// RedactPath returns the config path with any password in a URL masked, for
+// logs and error messages.
+func RedactPath(path string) string {
+	if !IsURL(path) {
+		return path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// TenantConfig is a team sharing the proxy, defined under "tenants" by
+// name. Its token lets it see and change the routes whose tenant is its
+// name through the admin API, and nothing else.
+type TenantConfig struct {
+	Token TokenRef `json:"token"`
+}
+
+// TokenRef says where an admin API token is kept, so the config itself
+// never holds one: in an environment variable, or in a file, whose
+// surrounding whitespace is trimmed.
+type TokenRef struct {
+	Env  string `json:"env,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// Access is who may use the admin API, from the config files: the holder
+// of AdminToken may do anything, and each tenant's token, by tenant name,
+// only reaches its own routes. With neither, the API asks for no token.
+type Access struct {
+	AdminToken string
+	Tenants    map[string]string
+}
+
+// Enabled reports whether the admin API asks for a token.
+func (a Access) Enabled() bool {
+	return a.AdminToken != "" || len(a.Tenants) > 0
+}
+
+// resolve reads the token ref points at.
+func (ref TokenRef) resolve() (string, error) {
+	switch {
+	case ref.Env != "" && ref.File != "":
+		return "", errors.New("set env or file, not both")
+	case ref.Env != "":
+		token, ok := os.LookupEnv(ref.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", ref.Env)
+		}
+		if token = strings.TrimSpace(token); token == "" {
+			return "", fmt.Errorf("environment variable %s is empty", ref.Env)
+		}
+		return token, nil
+	case ref.File != "":
+		data, err := os.ReadFile(ref.File)
+		if err != nil {
+			return "", err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("file %s is empty", ref.File)
+		}
+		return token, nil
+	default:
+		return "", errors.New("set env or file")
+	}
+}
+
+// loadAccess reads the tokens of a config file's adminToken and tenants.
+func (f *fileConfig) loadAccess(configLogger *slog.Logger) (Access, error) {
+	var access Access
+	hasErrors := false
+	if f.AdminToken != nil {
+		token, err := f.AdminToken.resolve()
+		if err != nil {
+			configLogger.Error("invalid admin token",
+				"error", err,
+				"hint", "adminToken must be {\"env\": \"VARIABLE\"} or {\"file\": \"/path/to/token\"}, naming a variable or file that holds the token")
+			hasErrors = true
+		}
+		access.AdminToken = token
+	}
+	for _, name := range slices.Sorted(maps.Keys(f.Tenants)) {
+		if name == "" || !isValidLabel(name) {
+			configLogger.Error("invalid tenant name",
+				"tenant", name,
+				"hint", "tenant names may use letters, digits, '-' and '_' only")
+			hasErrors = true
+			continue
+		}
+		token, err := f.Tenants[name].Token.resolve()
+		if err != nil {
+			configLogger.Error("invalid tenant token",
+				"tenant", name,
+				"error", err,
+				"hint", "a tenant's token must be {\"env\": \"VARIABLE\"} or {\"file\": \"/path/to/token\"}, naming a variable or file that holds the token")
+			hasErrors = true
+			continue
+		}
+		if access.Tenants == nil {
+			access.Tenants = make(map[string]string)
+		}
+		access.Tenants[name] = token
+	}
+	if hasErrors {
+		return Access{}, fmt.Errorf("validation failed: see error messages above for details")
+	}
+	return access, nil
+}
+
+// mergeAccess adds the access of another config file to a, reporting an
+// admin token or tenant defined in both.
+func mergeAccess(a, other Access, configLogger *slog.Logger) (Access, error) {
+	hasErrors := false
+	if other.AdminToken != "" {
+		if a.AdminToken != "" {
+			configLogger.Error("duplicate admin token",
+				"hint", "adminToken may be set in one config file only")
+			hasErrors = true
+		}
+		a.AdminToken = other.AdminToken
+	}
+	for _, name := range slices.Sorted(maps.Keys(other.Tenants)) {
+		if _, ok := a.Tenants[name]; ok {
+			configLogger.Error("duplicate tenant",
+				"tenant", name,
+				"hint", "each tenant may be defined in one config file only")
+			hasErrors = true
+			continue
+		}
+		if a.Tenants == nil {
+			a.Tenants = make(map[string]string)
+		}
+		a.Tenants[name] = other.Tenants[name]
+	}
+	if hasErrors {
+		return Access{}, fmt.Errorf("validation failed: see error messages above for details")
+	}
+	return a, nil
+}
+
+// checkTokens reports a token given to more than one caller, which the
+// admin API couldn't tell apart.
+func (a Access) checkTokens(configLogger *slog.Logger) error {
+	holders := make(map[string]string)
+	if a.AdminToken != "" {
+		holders[a.AdminToken] = "adminToken"
+	}
+	hasErrors := false
+	for _, name := range slices.Sorted(maps.Keys(a.Tenants)) {
+		token := a.Tenants[name]
+		if other, ok := holders[token]; ok {
+			configLogger.Error("shared admin API token",
+				"tenant", name,
+				"other", other,
+				"hint", "give every tenant, and adminToken, a token of its own, so the admin API can tell them apart")
+			hasErrors = true
+			continue
+		}
+		holders[token] = "tenant " + name
+	}
+	if hasErrors {
+		return fmt.Errorf("validation failed: see error messages above for details")
+	}
+	return nil
+}
+
+// warnTenantless logs the routes whose tenant has no token, which only the
+// admin token reaches.
+func (a Access) warnTenantless(routes []RouteConfig, configLogger *slog.Logger) {
+	if !a.Enabled() {
+		return
+	}
+	for i, route := range routes {
+		if _, ok := a.Tenants[route.Tenant]; route.Tenant != "" && !ok {
+			configLogger.Warn("route tenant has no token",
+				"route_index", i,
+				"port", route.LocalPort,
+				"tenant", route.Tenant,
+				"hint", "define the tenant under \"tenants\" for it to reach the route through the admin API; until then only adminToken does")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfigsWithAccess(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "search.token")
+	if err := os.WriteFile(tokenFile, []byte("search-secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	t.Setenv("TEST_ADMIN_TOKEN", "admin-secret")
+	t.Setenv("TEST_PAYMENTS_TOKEN", "payments-secret")
+	t.Setenv("TEST_EMPTY_TOKEN", "")
+
+	files := map[string]string{
+		"payments.json": `{
+			"adminToken": {"env": "TEST_ADMIN_TOKEN"},
+			"tenants": {"payments": {"token": {"env": "TEST_PAYMENTS_TOKEN"}}},
+			"routes": [{"localPort": 8080, "upstream": "127.0.0.1:9090", "tenant": "payments"}]
+		}`,
+		"search.json": `{
+			"tenants": {"search": {"token": {"file": "` + filepath.ToSlash(tokenFile) + `"}}},
+			"routes": [{"localPort": 8081, "upstream": "127.0.0.1:9091", "tenant": "search"}]
+		}`,
+		"plain.json":  `[{"localPort": 8082, "upstream": "127.0.0.1:9092"}]`,
+		"again.json":  `{"tenants": {"payments": {"token": {"env": "TEST_ADMIN_TOKEN"}}}, "routes": []}`,
+		"shared.json": `{"tenants": {"search": {"token": {"env": "TEST_PAYMENTS_TOKEN"}}}, "routes": []}`,
+		"unset.json":  `{"tenants": {"search": {"token": {"env": "TEST_UNSET_TOKEN"}}}, "routes": [{"localPort": 8083, "upstream": "127.0.0.1:9093"}]}`,
+		"empty.json":  `{"adminToken": {"env": "TEST_EMPTY_TOKEN"}, "routes": [{"localPort": 8083, "upstream": "127.0.0.1:9093"}]}`,
+		"both.json":   `{"adminToken": {"env": "TEST_ADMIN_TOKEN", "file": "x"}, "routes": [{"localPort": 8083, "upstream": "127.0.0.1:9093"}]}`,
+		"name.json":   `{"tenants": {"pay ments": {"token": {"env": "TEST_PAYMENTS_TOKEN"}}}, "routes": [{"localPort": 8083, "upstream": "127.0.0.1:9093"}]}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write test config file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    Access
+		wantErr bool
+	}{
+		{name: "no tokens", paths: []string{"plain.json"}, want: Access{}},
+		{
+			name:  "merged",
+			paths: []string{"payments.json", "search.json", "plain.json"},
+			want: Access{
+				AdminToken: "admin-secret",
+				Tenants:    map[string]string{"payments": "payments-secret", "search": "search-secret"},
+			},
+		},
+		{name: "tenant defined twice", paths: []string{"payments.json", "again.json"}, wantErr: true},
+		{name: "token shared by two tenants", paths: []string{"payments.json", "shared.json"}, wantErr: true},
+		{name: "variable not set", paths: []string{"unset.json"}, wantErr: true},
+		{name: "empty token", paths: []string{"empty.json"}, wantErr: true},
+		{name: "env and file", paths: []string{"both.json"}, wantErr: true},
+		{name: "invalid tenant name", paths: []string{"name.json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := make([]string, len(tt.paths))
+			for i, path := range tt.paths {
+				paths[i] = filepath.Join(dir, path)
+			}
+			_, access, err := LoadConfigsWithAccess(paths, LoadOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfigsWithAccess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(access, tt.want) {
+				t.Errorf("LoadConfigsWithAccess() access = %+v, want %+v", access, tt.want)
+			}
+		})
+	}
+}
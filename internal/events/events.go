@@ -0,0 +1,94 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	ConnectionOpen  Type = "connection_open"
+	ConnectionClose Type = "connection_close"
+	ConnectionError Type = "connection_error"
+	Fault           Type = "fault"
+	TLSClientHello  Type = "tls_client_hello"
+)
+
+// Event describes something that happened to a proxied connection.
+type Event struct {
+	Time          time.Time `json:"time"`
+	Type          Type      `json:"type"`
+	Port          int       `json:"port"`
+	Route         string    `json:"route,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Client        string    `json:"client,omitempty"`
+	Conn          uint64    `json:"conn,omitempty"`
+	Upstream      string    `json:"upstream,omitempty"`
+	Fault         string    `json:"fault,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+	BytesToClient int64     `json:"bytesToClient,omitempty"`
+	BytesToServer int64     `json:"bytesToServer,omitempty"`
+	TLS           *TLSInfo  `json:"tls,omitempty"`
+}
+
+// TLSInfo is what a client offered in its TLS ClientHello.
+type TLSInfo struct {
+	ServerName   string   `json:"serverName,omitempty"`
+	ALPN         []string `json:"alpn,omitempty"`
+	Version      string   `json:"version"`
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+}
+
+// Bus fans events out to subscribers. A nil *Bus is valid and drops every
+// event, so the proxy can publish unconditionally.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish delivers e to every subscriber without blocking. Subscribers that
+// fall behind miss events rather than stalling the proxy.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of future events and a function that
+// unsubscribes and closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	sub := make(chan Event, buffer)
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.mu.Unlock()
+			close(sub)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	bus := NewBus()
+	first, unsubscribeFirst := bus.Subscribe(4)
+	second, unsubscribeSecond := bus.Subscribe(4)
+	defer unsubscribeSecond()
+
+	bus.Publish(Event{Type: ConnectionOpen, Port: 8080})
+
+	for i, sub := range []<-chan Event{first, second} {
+		select {
+		case e := <-sub:
+			if e.Type != ConnectionOpen || e.Port != 8080 {
+				t.Errorf("subscriber %d got %+v, want connection_open on 8080", i, e)
+			}
+			if e.Time.IsZero() {
+				t.Errorf("subscriber %d got zero event time", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("subscriber %d did not receive event", i)
+		}
+	}
+
+	unsubscribeFirst()
+	unsubscribeFirst() // safe to call twice
+	if _, ok := <-first; ok {
+		t.Error("expected channel to be closed after unsubscribe")
+	}
+
+	bus.Publish(Event{Type: ConnectionClose})
+	if e := <-second; e.Type != ConnectionClose {
+		t.Errorf("remaining subscriber got %+v, want connection_close", e)
+	}
+}
+
+func TestBus_SlowSubscriberDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	_, unsubscribe := bus.Subscribe(1)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			bus.Publish(Event{Type: Fault})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+}
+
+func TestBus_NilIsNoop(t *testing.T) {
+	var bus *Bus
+	bus.Publish(Event{Type: Fault})
+}
@@ -0,0 +1,33 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// AuditLog writes one JSON line to W for every finished connection, to be
+// attached to an experiment's report.
+type AuditLog struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{W: w}
+}
+
+// Record writes the record of a finished connection. It is called from
+// every route's connections, so writes are serialized. A failed write is
+// logged and the record skipped.
+func (a *AuditLog) Record(record proxy.ConnRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := json.NewEncoder(a.W).Encode(record); err != nil {
+		slog.Error("failed to write connection record", "exporter", "audit-log", "conn", record.Conn, "error", err, "hint", "check that the audit file's disk is writable and not full")
+	}
+}
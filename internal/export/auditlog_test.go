@@ -0,0 +1,41 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+func TestAuditLog_Record(t *testing.T) {
+	var buf bytes.Buffer
+	audit := NewAuditLog(&buf)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			audit.Record(proxy.ConnRecord{Conn: uint64(i + 1), Port: 8080, Chaos: []string{"latency"}, CloseReason: proxy.CloseCompleted})
+		})
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record proxy.ConnRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %q is not a record: %v", scanner.Text(), err)
+		}
+		if !reflect.DeepEqual(record.Chaos, []string{"latency"}) || record.CloseReason != proxy.CloseCompleted {
+			t.Errorf("record = %+v, want it as written", record)
+		}
+		seen[record.Conn] = true
+	}
+	if len(seen) != 20 {
+		t.Errorf("read %d records, want all 20, one per line", len(seen))
+	}
+}
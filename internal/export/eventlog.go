@@ -0,0 +1,30 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// EventLog writes events to W as JSON lines, apart from the proxy's
+// operational logs.
+type EventLog struct {
+	W io.Writer
+}
+
+func NewEventLog(w io.Writer) *EventLog {
+	return &EventLog{W: w}
+}
+
+// Run writes events from sub until sub is closed. Failed writes are logged
+// and the event skipped.
+func (l *EventLog) Run(sub <-chan events.Event) {
+	encoder := json.NewEncoder(l.W)
+	for e := range sub {
+		if err := encoder.Encode(e); err != nil {
+			slog.Error("failed to write event", "exporter", "event-log", "type", e.Type, "port", e.Port, "error", err, "hint", "check that the events file's disk is writable and not full")
+		}
+	}
+}
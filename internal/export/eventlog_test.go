@@ -0,0 +1,33 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+func TestEventLog_Run(t *testing.T) {
+	sub := make(chan events.Event, 2)
+	sub <- events.Event{Type: events.ConnectionOpen, Port: 8080}
+	sub <- events.Event{Type: events.Fault, Port: 8080, Fault: "drop"}
+	close(sub)
+
+	var buf bytes.Buffer
+	NewEventLog(&buf).Run(sub)
+
+	var got []events.Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not an event: %v", scanner.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[1].Fault != "drop" {
+		t.Errorf("logged events = %+v, want both, one per line", got)
+	}
+}
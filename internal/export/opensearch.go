@@ -0,0 +1,135 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	requestTimeout       = 10 * time.Second
+)
+
+// OpenSearch ships events to an OpenSearch or Elasticsearch cluster through
+// the _bulk API. Credentials can be passed as URL userinfo.
+type OpenSearch struct {
+	URL           string
+	Index         string
+	BatchSize     int
+	FlushInterval time.Duration
+	Client        *http.Client
+}
+
+func NewOpenSearch(url, index string) *OpenSearch {
+	return &OpenSearch{
+		URL:           strings.TrimSuffix(url, "/"),
+		Index:         index,
+		BatchSize:     defaultBatchSize,
+		FlushInterval: defaultFlushInterval,
+		Client:        &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Run batches events from sub and ships them until sub is closed, then
+// flushes whatever is left. Failed batches are logged and dropped so a
+// broken cluster never backs up into the proxy.
+func (o *OpenSearch) Run(sub <-chan events.Event) {
+	exportLogger := slog.With("exporter", "opensearch", "url", redactURL(o.URL))
+	ticker := time.NewTicker(o.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]events.Event, 0, o.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := o.Ship(context.Background(), batch); err != nil {
+			exportLogger.Error("failed to export events", "events", len(batch), "error", err, "hint", "check that the cluster is reachable and the credentials are valid")
+		} else {
+			exportLogger.Debug("exported events", "events", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case e, ok := <-sub:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= o.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Ship sends one batch of events in a single _bulk request.
+func (o *OpenSearch) Ship(ctx context.Context, batch []events.Event) error {
+	var body bytes.Buffer
+	action, err := json.Marshal(map[string]any{"index": map[string]string{"_index": o.Index}})
+	if err != nil {
+		return fmt.Errorf("cannot encode bulk action: %w", err)
+	}
+	for _, e := range batch {
+		doc, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("cannot encode event: %w", err)
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL+"/_bulk", &body)
+	if err != nil {
+		return fmt.Errorf("cannot build bulk request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bulk request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bulk request returned %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(respBody, &result); err == nil && result.Errors {
+		return fmt.Errorf("bulk request had per-document errors")
+	}
+	return nil
+}
+
+// redactURL strips userinfo so credentials never reach the logs.
+func redactURL(raw string) string {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return raw
+	}
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		rest = rest[at+1:]
+	}
+	return scheme + "://" + rest
+}
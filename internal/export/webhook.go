@@ -0,0 +1,99 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// Webhook posts a JSON payload to a URL for every chaos action the proxy
+// takes, that is every fault event. Each payload is the event with a "text"
+// line summing it up, which is what Slack incoming webhooks display.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// webhookPayload is the body posted for an event.
+type webhookPayload struct {
+	events.Event
+	Text string `json:"text"`
+}
+
+// Run posts fault events from sub until sub is closed. Deliveries are made
+// one at a time; while the endpoint is slow, events beyond sub's buffer are
+// missed rather than slowing down the proxy. Failed deliveries are logged
+// and not retried.
+func (h *Webhook) Run(sub <-chan events.Event) {
+	exportLogger := slog.With("exporter", "webhook", "url", redactURL(h.URL))
+	for e := range sub {
+		if e.Type != events.Fault {
+			continue
+		}
+		if err := h.Send(context.Background(), e); err != nil {
+			exportLogger.Error("failed to deliver webhook", "fault", e.Fault, "port", e.Port, "error", err, "hint", "check that the webhook URL is right and its endpoint is reachable")
+		} else {
+			exportLogger.Debug("delivered webhook", "fault", e.Fault, "port", e.Port)
+		}
+	}
+}
+
+// Send posts one event.
+func (h *Webhook) Send(ctx context.Context, e events.Event) error {
+	body, err := json.Marshal(webhookPayload{Event: e, Text: summary(e)})
+	if err != nil {
+		return fmt.Errorf("cannot encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chaos-proxy")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook request returned %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+	return nil
+}
+
+// summary describes the event in one line, such as
+// "chaos-proxy: drop on checkout-db (port 8080) for 10.0.0.5:51234".
+func summary(e events.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "chaos-proxy: %s on ", e.Fault)
+	if e.Route != "" {
+		fmt.Fprintf(&b, "%s (port %d)", e.Route, e.Port)
+	} else {
+		fmt.Fprintf(&b, "port %d", e.Port)
+	}
+	if e.Client != "" {
+		fmt.Fprintf(&b, " for %s", e.Client)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+func TestWebhook_Run(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		mu.Lock()
+		payloads = append(payloads, payload)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sub := make(chan events.Event, 3)
+	sub <- events.Event{Type: events.ConnectionOpen, Port: 8080}
+	sub <- events.Event{Type: events.Fault, Port: 8080, Route: "checkout-db", Client: "10.0.0.5:51234", Fault: "drop"}
+	sub <- events.Event{Type: events.ConnectionClose, Port: 8080}
+	close(sub)
+
+	done := make(chan struct{})
+	go func() {
+		NewWebhook(server.URL).Run(sub)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the subscription closed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(payloads) != 1 {
+		t.Fatalf("delivered %d payloads, want only the fault", len(payloads))
+	}
+	if got, want := payloads[0]["text"], "chaos-proxy: drop on checkout-db (port 8080) for 10.0.0.5:51234"; got != want {
+		t.Errorf("text = %q, want %q", got, want)
+	}
+	if payloads[0]["fault"] != "drop" || payloads[0]["route"] != "checkout-db" {
+		t.Errorf("payload = %v, want the event's fields", payloads[0])
+	}
+}
+
+func TestWebhook_SendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Send(t.Context(), events.Event{Type: events.Fault}); err == nil {
+		t.Error("Send() expected error, got nil")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		event events.Event
+		want  string
+	}{
+		{
+			event: events.Event{Port: 8080, Fault: "flap"},
+			want:  "chaos-proxy: flap on port 8080",
+		},
+		{
+			event: events.Event{Port: 8080, Route: "api", Client: "10.0.0.5:51234", Fault: "http_error", Detail: "503"},
+			want:  "chaos-proxy: http_error on api (port 8080) for 10.0.0.5:51234: 503",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := summary(tt.event); got != tt.want {
+			t.Errorf("summary(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
@@ -2,11 +2,22 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 )
 
 func NewLogger(verbose, quiet bool) {
+	slog.SetDefault(slog.New(newHandler(os.Stderr, verbose, quiet, false)))
+}
+
+// NewFileLogger logs to w, such as a RotatingFile, instead of stderr. Its
+// lines keep their time, since a log file is read long after the fact.
+func NewFileLogger(w io.Writer, verbose, quiet bool) {
+	slog.SetDefault(slog.New(newHandler(w, verbose, quiet, true)))
+}
+
+func newHandler(w io.Writer, verbose, quiet, withTime bool) slog.Handler {
 	var level slog.Level
 
 	if verbose && quiet {
@@ -26,14 +37,12 @@ func NewLogger(verbose, quiet bool) {
 	options := slog.HandlerOptions{
 		Level: level,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			if a.Key == slog.TimeKey {
+			if a.Key == slog.TimeKey && !withTime {
 				return slog.Attr{}
 			}
 			return a
 		},
 	}
 
-	handler := slog.NewTextHandler(os.Stderr, &options)
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
+	return slog.NewTextHandler(w, &options)
 }
@@ -0,0 +1,146 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files so that they sort by age.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is a log file that is moved aside once it grows past MaxSize
+// bytes or has been written to for longer than MaxAge, keeping the newest
+// MaxBackups old files. A zero limit disables it.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// OpenRotatingFile opens the file at path for appending, creating it if
+// needed.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot stat log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it past
+// MaxSize or it is older than MaxAge. A single write is never split across
+// files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			// Keep logging to whatever file is open rather than losing
+			// the line.
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, opens a new one in its place and removes
+// backups past MaxBackups. If the file can't be moved, it is reopened to
+// carry on.
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	backup := f.Path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(f.Path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("cannot rotate log file: %w", renameErr)
+	}
+	return f.prune()
+}
+
+func (f *RotatingFile) prune() error {
+	if f.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[:max(len(backups)-f.MaxBackups, 0)] {
+		if err := os.Remove(backup); err != nil {
+			return fmt.Errorf("cannot remove old log file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Backups returns the paths of the rotated files, oldest first.
+func (f *RotatingFile) Backups() ([]string, error) {
+	dir, base := filepath.Split(f.Path)
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list old log files: %w", err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), base+".")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, filepath.Join(dir, entry.Name()))
+		}
+	}
+	// ReadDir sorts by name, and the stamps sort by age.
+	return backups, nil
+}
+
+// Close closes the file. Later writes fail.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile_Size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	// Not a backup, so never pruned.
+	if err := os.WriteFile(path+".keep", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n", "eeeeeeeeeeeeeeee\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) error = %v", line, err)
+		}
+		// Rotated files are named by the millisecond.
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "eeeeeeeeeeeeeeee\n" {
+		t.Errorf("log file = %q, want the last line, written whole past MaxSize", current)
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want the newest 2", backups)
+	}
+	for i, want := range []string{"cccccc\n", "dddddd\n"} {
+		if got, _ := os.ReadFile(backups[i]); string(got) != want {
+			t.Errorf("backup %d = %q, want %q", i, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".keep"); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
+
+func TestRotatingFile_Age(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(path, 0, 20*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+	time.Sleep(30 * time.Millisecond)
+	f.Write([]byte("second\n"))
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "second\n" {
+		t.Errorf("log file = %q, want only the line written after MaxAge", current)
+	}
+	backups, _ := f.Backups()
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+	if got, _ := os.ReadFile(backups[0]); string(got) != "earlier run\nfirst\n" {
+		t.Errorf("backup = %q, want the appended file", got)
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// Why a connection closed, as given in its ConnRecord. A fault that ends a
+// connection is given by its fault name, such as "drop" or "lifetime".
+const (
+	// CloseCompleted is a connection that closed of its own accord, when
+	// both sides were done or one went away.
+	CloseCompleted = "completed"
+	// CloseKilled is a connection killed through the admin API.
+	CloseKilled = "killed"
+	// CloseDisabled is a connection reset because its route was disabled.
+	CloseDisabled = "disabled"
+	// CloseError is a connection that failed, such as on an unreachable
+	// upstream or a failed handshake. Its record carries the error.
+	CloseError = "error"
+	// CloseSevered is a connection a toxic cut, such as truncate.
+	CloseSevered = "severed"
+	// CloseTimeout is a connection closed because a read or write on it
+	// waited longer than the route's readTimeoutMs or writeTimeoutMs.
+	CloseTimeout = "timeout"
+	// CloseShutdown is a connection still open when the proxy shut down,
+	// killed once the shutdown grace period ran out.
+	CloseShutdown = "shutdown"
+	// CloseHopLimit is a connection closed for passing through more
+	// chained routes than the proxy allows, as a route loop does.
+	CloseHopLimit = "hop_limit"
+)
+
+// ConnRecord is the account of a finished connection, or UDP session: what
+// it connected, how long it lasted and what was done to it.
+type ConnRecord struct {
+	Conn     uint64   `json:"conn"`
+	Port     int      `json:"port"`
+	Route    string   `json:"route,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Client   string   `json:"client"`
+	Upstream string   `json:"upstream"`
+
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationMs"`
+
+	BytesToClient int64 `json:"bytesToClient"`
+	BytesToServer int64 `json:"bytesToServer"`
+	// Chaos names the faults applied, each once, in the order first
+	// applied, as in fault events.
+	Chaos       []string `json:"chaos"`
+	CloseReason string   `json:"closeReason"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// closeConn stops tracking conn, counts why it closed and hands its record
+// to OnConnClose.
+func (o ServeOptions) closeConn(route config.RouteConfig, conn *openConn) {
+	o.Control.untrack(conn)
+	end := time.Now()
+	conn.mu.Lock()
+	record := ConnRecord{
+		Conn:          conn.id,
+		Port:          o.Control.Port(),
+		Route:         route.Name,
+		Tenant:        route.Tenant,
+		Tags:          route.Tags,
+		Client:        conn.client,
+		Upstream:      conn.upstream,
+		Start:         conn.started,
+		End:           end,
+		DurationMs:    end.Sub(conn.started).Milliseconds(),
+		BytesToClient: conn.bytesToClient.Load(),
+		BytesToServer: conn.bytesToServer.Load(),
+		Chaos:         append([]string{}, conn.chaos...),
+		CloseReason:   conn.reason,
+		Error:         conn.err,
+	}
+	conn.mu.Unlock()
+	if record.CloseReason == "" {
+		record.CloseReason = CloseCompleted
+	}
+
+	o.Stats.recordClose(record.CloseReason)
+	if o.OnConnClose != nil {
+		o.OnConnClose(record)
+	}
+}
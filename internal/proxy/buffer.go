@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+)
+
+// bufferPools holds a *sync.Pool of copy buffers for each buffer size a
+// route uses, so connections reuse buffers instead of allocating their own.
+var bufferPools sync.Map
+
+// bufferPool returns the pool of size-byte buffers.
+func bufferPool(size int) *sync.Pool {
+	if pool, ok := bufferPools.Load(size); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := bufferPools.LoadOrStore(size, &sync.Pool{New: func() any {
+		buf := make([]byte, size)
+		return &buf
+	}})
+	return pool.(*sync.Pool)
+}
+
+// copyBuffer is io.Copy through a pooled buffer of size bytes. dst's
+// ReadFrom is hidden from io.CopyBuffer: a TCP connection's only splices
+// from another, which src, wrapped for the route's counters, never is, and
+// otherwise copies through a buffer of its own.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	pool := bufferPool(size)
+	buf := pool.Get().(*[]byte)
+	defer pool.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
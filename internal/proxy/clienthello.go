@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+
+	"github.com/chasewilson/chaos-proxy/internal/clienthello"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// captureClientHello peeks at the client's TLS ClientHello, logs and records
+// it, and returns a reader that still yields every byte the client sent.
+// It blocks until the client sends its first record, so it must run on the
+// client-to-server side of the connection.
+func captureClientHello(client net.Conn, route config.RouteConfig, routeLogger *slog.Logger, opts ServeOptions) io.Reader {
+	reader := bufio.NewReaderSize(client, clienthello.BufferSize)
+	clientAddr := client.RemoteAddr().String()
+
+	hello, err := clienthello.Peek(reader)
+	switch {
+	case errors.Is(err, clienthello.ErrNotTLS):
+		routeLogger.Debug("connection is not TLS", "address", clientAddr, "upstream", route.Upstream)
+		opts.Stats.recordNotTLS()
+		return reader
+	case err != nil:
+		// The client closed early or sent a ClientHello we can't parse;
+		// forward whatever arrived and let the upstream decide.
+		routeLogger.Debug("could not parse TLS client hello", "address", clientAddr, "upstream", route.Upstream, "error", err)
+		return reader
+	}
+
+	routeLogger.Info("tls client hello",
+		"address", clientAddr,
+		"upstream", route.Upstream,
+		"sni", hello.ServerName,
+		"alpn", hello.ALPN,
+		"version", hello.VersionName())
+	routeLogger.Debug("tls client hello cipher suites", "address", clientAddr, "cipher_suites", hello.CipherSuiteNames())
+
+	opts.Stats.recordClientHello(hello)
+	opts.publish(route, events.TLSClientHello, clientAddr, func(e *events.Event) {
+		e.TLS = &events.TLSInfo{
+			ServerName:   hello.ServerName,
+			ALPN:         hello.ALPN,
+			Version:      hello.VersionName(),
+			CipherSuites: hello.CipherSuiteNames(),
+		}
+	})
+	return reader
+}
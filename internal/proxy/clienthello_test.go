@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// TestCaptureClientHello tests that a ClientHello passing through the proxy
+// is published, counted, and still forwarded to the upstream
+func TestCaptureClientHello(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:          proxyPort,
+		Upstream:           upstream.Addr().String(),
+		CaptureClientHello: true,
+	}
+
+	bus := events.NewBus()
+	sub, unsubscribe := bus.Subscribe(16)
+	defer unsubscribe()
+	stats := &RouteStats{}
+
+	go ServeRoute(context.Background(), route, ServeOptions{Stats: stats, Events: bus})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	// The echo upstream reflects the ClientHello back, so the handshake
+	// fails, but only after the ClientHello made it through the proxy.
+	client.SetDeadline(time.Now().Add(1 * time.Second))
+	tlsClient := tls.Client(client, &tls.Config{ServerName: "api.example.com", NextProtos: []string{"h2"}})
+	if err := tlsClient.Handshake(); err == nil {
+		t.Fatal("handshake with an echo server unexpectedly succeeded")
+	}
+
+	var hello events.Event
+	for hello.Type != events.TLSClientHello {
+		select {
+		case hello = <-sub:
+		case <-time.After(1 * time.Second):
+			t.Fatal("timed out waiting for tls_client_hello event")
+		}
+	}
+	if hello.TLS == nil || hello.TLS.ServerName != "api.example.com" {
+		t.Fatalf("event TLS = %+v, want serverName api.example.com", hello.TLS)
+	}
+	if len(hello.TLS.ALPN) != 1 || hello.TLS.ALPN[0] != "h2" {
+		t.Errorf("event ALPN = %v, want [h2]", hello.TLS.ALPN)
+	}
+
+	snapshot := stats.Snapshot().TLS
+	if snapshot.ClientHellos != 1 {
+		t.Errorf("ClientHellos = %d, want 1", snapshot.ClientHellos)
+	}
+	if snapshot.ServerNames["api.example.com"] != 1 {
+		t.Errorf("ServerNames = %v, want api.example.com counted once", snapshot.ServerNames)
+	}
+	if snapshot.Versions["TLS 1.3"] != 1 {
+		t.Errorf("Versions = %v, want TLS 1.3 counted once", snapshot.Versions)
+	}
+}
+
+// TestCaptureClientHello_NotTLS tests that plain traffic is counted and
+// forwarded unchanged
+func TestCaptureClientHello_NotTLS(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:          proxyPort,
+		Upstream:           upstream.Addr().String(),
+		CaptureClientHello: true,
+	}
+	stats := &RouteStats{}
+
+	go ServeRoute(context.Background(), route, ServeOptions{Stats: stats})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	// Shorter than a TLS record header, so capture must not wait for more.
+	message := "hi\n"
+	if _, err := client.Write([]byte(message)); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	buf := make([]byte, len(message))
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+	if string(buf) != message {
+		t.Errorf("echo = %q, want %q", buf, message)
+	}
+
+	if got := stats.Snapshot().TLS.NotTLS; got != 1 {
+		t.Errorf("NotTLS = %d, want 1", got)
+	}
+}
@@ -0,0 +1,433 @@
+package proxy
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// RouteControl switches a running route, or just its chaos, on and off, and
+// moves it to new settings. Switching only affects new connections; open
+// ones carry on as they were. It also counts the route's live traffic.
+type RouteControl struct {
+	mu    sync.Mutex
+	route config.RouteConfig
+	// update is set while the route is serving and can take new settings.
+	update func(config.RouteConfig) error
+
+	port     atomic.Int64
+	disabled atomic.Bool
+	chaosOff atomic.Bool
+
+	connections   atomic.Int64
+	open          atomic.Int64
+	bytesToClient atomic.Int64
+	bytesToServer atomic.Int64
+
+	connsMu sync.Mutex
+	conns   map[uint64]*openConn
+	// byClient finds the connection of a client address, for the faults
+	// published against it.
+	byClient map[string]*openConn
+}
+
+// Traffic is a point-in-time copy of a route's traffic counters, counted
+// since the route started listening.
+type Traffic struct {
+	// Connections counts the connections accepted, or UDP sessions opened.
+	Connections int64
+	// Open counts the connections, or UDP sessions, open right now.
+	Open int64
+	// BytesToClient and BytesToServer count the bytes read from each side
+	// to be forwarded, as they are read.
+	BytesToClient int64
+	BytesToServer int64
+}
+
+// NewRouteControl returns the control for route, enabled as configured and
+// with its chaos on.
+func NewRouteControl(route config.RouteConfig) *RouteControl {
+	c := &RouteControl{route: route}
+	c.port.Store(int64(route.LocalPort))
+	c.disabled.Store(!route.IsEnabled())
+	return c
+}
+
+// Port is the route's local port: the bound port once it is listening,
+// which differs from the configured one for localPort 0.
+func (c *RouteControl) Port() int {
+	return int(c.port.Load())
+}
+
+// Name is the route's configured name, if it has one.
+func (c *RouteControl) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.route.Name
+}
+
+func (c *RouteControl) Tenant() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.route.Tenant
+}
+
+func (c *RouteControl) Tags() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.route.Tags
+}
+
+func (c *RouteControl) Upstream() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.route.Upstream
+}
+
+// Route returns the route's current settings.
+func (c *RouteControl) Route() config.RouteConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.route
+}
+
+func (c *RouteControl) Traffic() Traffic {
+	return Traffic{
+		Connections:   c.connections.Load(),
+		Open:          c.open.Load(),
+		BytesToClient: c.bytesToClient.Load(),
+		BytesToServer: c.bytesToServer.Load(),
+	}
+}
+
+func (c *RouteControl) opened() {
+	c.connections.Add(1)
+	c.open.Add(1)
+}
+
+func (c *RouteControl) closed() {
+	c.open.Add(-1)
+}
+
+// connIDs numbers the connections of every route, so an ID names one
+// connection in the whole proxy.
+var connIDs atomic.Uint64
+
+// Conn is a point-in-time copy of an open connection through a route, or
+// of a UDP session.
+type Conn struct {
+	ID       uint64
+	Client   string
+	Upstream string
+	Started  time.Time
+	// BytesToClient and BytesToServer count the bytes read from each side
+	// to be forwarded, as they are read.
+	BytesToClient int64
+	BytesToServer int64
+	// Chaos names the faults applied to the connection so far, such as
+	// "latency" or a toxic's name, each once, in the order first applied.
+	Chaos []string
+}
+
+// openConn is an open connection tracked by its route's control.
+type openConn struct {
+	id      uint64
+	client  string
+	started time.Time
+	kill    func()
+
+	bytesToClient atomic.Int64
+	bytesToServer atomic.Int64
+
+	mu       sync.Mutex
+	upstream string
+	chaos    []string
+	// reason is why the connection closed, if not of its own accord, and
+	// err the error that closed it, for its audit record.
+	reason string
+	err    string
+}
+
+func (o *openConn) setUpstream(upstream string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.upstream = upstream
+}
+
+// end records why the connection is closing. The first reason given
+// stands, since the others follow from it.
+func (o *openConn) end(reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.reason == "" {
+		o.reason = reason
+	}
+}
+
+// track starts tracking connection id, from client to upstream, which kill
+// tears down. IDs are taken from connIDs. ServeOptions.closeConn must be
+// called once it closes.
+func (c *RouteControl) track(id uint64, client, upstream string, kill func()) *openConn {
+	conn := &openConn{id: id, client: client, upstream: upstream, started: time.Now(), kill: kill}
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	if c.conns == nil {
+		c.conns = make(map[uint64]*openConn)
+		c.byClient = make(map[string]*openConn)
+	}
+	c.conns[conn.id] = conn
+	c.byClient[client] = conn
+	return conn
+}
+
+func (c *RouteControl) untrack(conn *openConn) {
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	delete(c.conns, conn.id)
+	if c.byClient[conn.client] == conn {
+		delete(c.byClient, conn.client)
+	}
+}
+
+// connID returns the ID of the open connection of client, or 0 if it has
+// none.
+func (c *RouteControl) connID(client string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	if conn := c.byClient[client]; conn != nil {
+		return conn.id
+	}
+	return 0
+}
+
+// failed records that the connection of client closes on err.
+func (c *RouteControl) failed(client, err string) {
+	if c == nil {
+		return
+	}
+	c.connsMu.Lock()
+	conn := c.byClient[client]
+	c.connsMu.Unlock()
+	if conn == nil {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.reason == "" {
+		conn.reason, conn.err = CloseError, err
+	}
+}
+
+// applied records that fault was applied to the connection of client.
+func (c *RouteControl) applied(client, fault string) {
+	if c == nil {
+		return
+	}
+	c.connsMu.Lock()
+	conn := c.byClient[client]
+	c.connsMu.Unlock()
+	if conn == nil {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !slices.Contains(conn.chaos, fault) {
+		conn.chaos = append(conn.chaos, fault)
+	}
+}
+
+// Conns returns the route's open connections, oldest first.
+func (c *RouteControl) Conns() []Conn {
+	c.connsMu.Lock()
+	open := make([]*openConn, 0, len(c.conns))
+	for _, conn := range c.conns {
+		open = append(open, conn)
+	}
+	c.connsMu.Unlock()
+	slices.SortFunc(open, func(a, b *openConn) int { return cmp.Compare(a.id, b.id) })
+
+	conns := make([]Conn, len(open))
+	for i, conn := range open {
+		conn.mu.Lock()
+		conns[i] = Conn{
+			ID:            conn.id,
+			Client:        conn.client,
+			Upstream:      conn.upstream,
+			Started:       conn.started,
+			BytesToClient: conn.bytesToClient.Load(),
+			BytesToServer: conn.bytesToServer.Load(),
+			Chaos:         slices.Clone(conn.chaos),
+		}
+		conn.mu.Unlock()
+	}
+	return conns
+}
+
+// Kill tears down the route's open connection with the given ID, resetting
+// a TCP client's connection. It reports whether the route had it.
+func (c *RouteControl) Kill(id uint64) bool {
+	c.connsMu.Lock()
+	conn, ok := c.conns[id]
+	c.connsMu.Unlock()
+	if ok {
+		conn.end(CloseKilled)
+		conn.kill()
+	}
+	return ok
+}
+
+// KillAll tears down every open connection of the route, as Kill does, and
+// returns how many there were. Connections accepted meanwhile carry on.
+func (c *RouteControl) KillAll() int {
+	return c.killAll(CloseKilled)
+}
+
+func (c *RouteControl) killAll(reason string) int {
+	c.connsMu.Lock()
+	open := make([]*openConn, 0, len(c.conns))
+	for _, conn := range c.conns {
+		open = append(open, conn)
+	}
+	c.connsMu.Unlock()
+	for _, conn := range open {
+		conn.end(reason)
+		conn.kill()
+	}
+	return len(open)
+}
+
+// drainPoll is how often Drain checks for connections still open, and
+// drainKillWait how long it waits for those it killed to close.
+const (
+	drainPoll     = 20 * time.Millisecond
+	drainKillWait = 5 * time.Second
+)
+
+// Drain waits up to grace for the open connections of the routes of
+// controls, which have stopped listening, to finish, then kills those left
+// with the close reason CloseShutdown and waits for them to close. A
+// connection is counted in its route's stats as it closes, so once none is
+// left the stats are complete. It returns how many connections it killed,
+// and how many were still open when it gave up waiting.
+func Drain(controls []*RouteControl, grace time.Duration) (killed int, left int64) {
+	open := func() int64 {
+		var n int64
+		for _, c := range controls {
+			n += c.open.Load()
+		}
+		return n
+	}
+	waitClosed := func(timeout time.Duration) int64 {
+		deadline := time.Now().Add(timeout)
+		for {
+			n := open()
+			if n == 0 || !time.Now().Before(deadline) {
+				return n
+			}
+			time.Sleep(min(drainPoll, time.Until(deadline)))
+		}
+	}
+
+	if waitClosed(grace) == 0 {
+		return 0, 0
+	}
+	for _, c := range controls {
+		killed += c.killAll(CloseShutdown)
+	}
+	return killed, waitClosed(drainKillWait)
+}
+
+// counting returns r counting the bytes read from it into counter as they
+// are read.
+func counting(r io.Reader, counter *atomic.Int64) io.Reader {
+	return &trafficReader{r: r, counter: counter}
+}
+
+type trafficReader struct {
+	r       io.Reader
+	counter *atomic.Int64
+}
+
+func (t *trafficReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.counter.Add(int64(n))
+	return n, err
+}
+
+// Enabled reports whether the route accepts connections. A disabled route
+// keeps its port but resets every connection.
+func (c *RouteControl) Enabled() bool {
+	return !c.disabled.Load()
+}
+
+func (c *RouteControl) SetEnabled(enabled bool) {
+	c.disabled.Store(!enabled)
+}
+
+// ChaosEnabled reports whether new connections get the route's chaos, or
+// are proxied cleanly.
+func (c *RouteControl) ChaosEnabled() bool {
+	return !c.chaosOff.Load()
+}
+
+func (c *RouteControl) SetChaosEnabled(enabled bool) {
+	c.chaosOff.Store(!enabled)
+}
+
+// Update moves the running route to route's settings without closing its
+// listener, so route must not need a new one (see
+// config.RouteConfig.Relisten). New connections get the new settings and
+// fresh chaos state; open ones finish with what they started with. The
+// route is only switched on or off if route's enabled setting differs from
+// the one it replaces, so a switch made at runtime survives an update that
+// leaves it alone. On error the route carries on unchanged.
+func (c *RouteControl) Update(route config.RouteConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.update == nil {
+		return errors.New("route is not serving")
+	}
+	if c.route.Relisten(route) {
+		return errors.New("the new settings need a new listener")
+	}
+	if err := c.update(route); err != nil {
+		return err
+	}
+	if route.IsEnabled() != c.route.IsEnabled() {
+		c.SetEnabled(route.IsEnabled())
+	}
+	c.route = route
+	return nil
+}
+
+// Restart moves a route whose listener has stopped to route's settings, for
+// a new ServeRoute to serve with the control in place of its old one. Its
+// traffic counters, open connections and chaos switch carry on, and it is
+// only switched on or off if route's enabled setting differs from the one
+// it replaces, as with Update.
+func (c *RouteControl) Restart(route config.RouteConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if route.IsEnabled() != c.route.IsEnabled() {
+		c.SetEnabled(route.IsEnabled())
+	}
+	c.route = route
+	c.port.Store(int64(route.LocalPort))
+}
+
+// serving sets how a serving route takes new settings, or clears it with
+// nil once the route stops.
+func (c *RouteControl) serving(update func(config.RouteConfig) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.update = update
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// maxQueryDetail bounds how much of a query's text goes into logs and
+// fault events.
+const maxQueryDetail = 100
+
+// errDBKilled is returned by the database forwarders once the route's
+// chaos has killed the connection.
+var errDBKilled = errors.New("connection killed by database chaos")
+
+// dbChaos is a postgres or mysql mode connection's query-level chaos, and
+// the state its two directions share about the query in flight. The
+// protocol forwarders call sent for each query the client sends, and
+// responding, row and resultEnd as the upstream's reply comes back; each of
+// the latter returns false once the connection has been killed.
+type dbChaos struct {
+	route      config.RouteConfig
+	ritual     chaos.Ritual
+	clientAddr string
+	logger     *slog.Logger
+	opts       ServeOptions
+	// kill resets both sides of the connection.
+	kill func()
+
+	mu sync.Mutex
+	// query is the last query sent, and armed whether the connection dies
+	// as soon as the upstream starts answering it.
+	query string
+	armed bool
+
+	// The result set being relayed, touched only by the upstream's side:
+	// rows passed on so far, and how many may pass before it is cut off,
+	// or -1 to leave it whole.
+	rows     int
+	cutAfter int
+}
+
+// sent records query as sent to the upstream and rolls whether the
+// connection is killed before its result arrives.
+func (d *dbChaos) sent(query string) {
+	query = queryDetail(query)
+	armed := d.ritual.RequestFails() &&
+		d.opts.decide(d.logger, "db_kill_query", "killing connection between query and result", "address", d.clientAddr, "upstream", d.route.Upstream, "query", query)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.query, d.armed = query, armed
+}
+
+// responding is called before each message from the upstream is passed on.
+// If the query in flight was picked to be killed, the upstream has now
+// received it, and the client never hears how it went.
+func (d *dbChaos) responding() bool {
+	d.mu.Lock()
+	armed, query := d.armed, d.query
+	d.armed = false
+	d.mu.Unlock()
+	if !armed {
+		return true
+	}
+	d.opts.publishFault(d.route, d.clientAddr, "db_kill_query", query)
+	d.kill()
+	return false
+}
+
+// row is called before each row of a result set is passed on. The first
+// row rolls whether the result set is cut off.
+func (d *dbChaos) row() bool {
+	if d.rows == 0 {
+		d.cutAfter = -1
+		// StreamResets only fires for a route with database settings.
+		if d.ritual.StreamResets() {
+			limit := d.route.Database.PartialRowsOrDefault()
+			if d.opts.decide(d.logger, "db_partial_result", "cutting off result set", "address", d.clientAddr, "upstream", d.route.Upstream, "query", d.lastQuery(), "rows", limit) {
+				d.cutAfter = limit
+			}
+		}
+	}
+	if d.cutAfter >= 0 && d.rows >= d.cutAfter {
+		return d.cut()
+	}
+	d.rows++
+	return true
+}
+
+// resultEnd is called before the message that ends a result set, or any
+// reply, is passed on. A result set picked to be cut off that had fewer
+// rows than allowed is cut off here, so the client never sees it finish.
+func (d *dbChaos) resultEnd() bool {
+	if d.rows > 0 && d.cutAfter >= 0 {
+		return d.cut()
+	}
+	d.rows = 0
+	return true
+}
+
+func (d *dbChaos) cut() bool {
+	d.opts.publishFault(d.route, d.clientAddr, "db_partial_result", fmt.Sprintf("after %d rows", d.rows))
+	d.kill()
+	return false
+}
+
+func (d *dbChaos) lastQuery() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.query
+}
+
+// queryDetail shortens a query's text to one line for logs and events.
+func queryDetail(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxQueryDetail {
+		query = strings.ToValidUTF8(query[:maxQueryDetail], "") + "..."
+	}
+	return query
+}
+
+// relayRest passes everything left on r to w untouched, for connections the
+// forwarders can no longer follow, such as ones that switched to TLS.
+func relayRest(w io.Writer, r io.Reader) error {
+	_, err := io.Copy(w, r)
+	return err
+}
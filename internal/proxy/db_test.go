@@ -0,0 +1,542 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// pgMessage builds a typed Postgres message.
+func pgMessage(typ byte, body ...byte) []byte {
+	msg := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(body)+4))
+	return append(msg, body...)
+}
+
+// pgStartup builds an untyped startup-phase message with the given code.
+func pgStartup(code uint32) []byte {
+	msg := binary.BigEndian.AppendUint32(nil, 8)
+	return binary.BigEndian.AppendUint32(msg, code)
+}
+
+// startFakePostgres starts an upstream that trusts every client, refuses
+// encryption unless acceptSSL is set (then echoes whatever follows), and
+// answers every query with rows rows. Queries it receives are reported.
+func startFakePostgres(t *testing.T, rows int, acceptSSL bool) (net.Listener, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	queries := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					msg, err := readPGStartup(r)
+					if err != nil {
+						return
+					}
+					if binary.BigEndian.Uint32(msg[4:]) != pgSSLRequest {
+						break
+					}
+					if acceptSSL {
+						conn.Write([]byte{'S'})
+						io.Copy(conn, r)
+						return
+					}
+					conn.Write([]byte{'N'})
+				}
+				conn.Write(append(pgMessage('R', 0, 0, 0, 0), pgMessage('Z', 'I')...))
+
+				statements := map[string]string{}
+				for {
+					typ, length, err := readPGHeader(r)
+					if err != nil {
+						return
+					}
+					body := make([]byte, length)
+					if _, err := io.ReadFull(r, body); err != nil {
+						return
+					}
+					var reply []byte
+					switch typ {
+					case 'Q':
+						query, _ := pgString(body)
+						queries <- query
+						reply = pgMessage('T', 0, 0)
+					case 'P':
+						name, rest := pgString(body)
+						statements[name], _ = pgString(rest)
+						reply = pgMessage('1')
+					case 'B':
+						reply = pgMessage('2')
+					case 'E':
+						queries <- statements[""]
+					case 'S':
+						conn.Write(pgMessage('Z', 'I'))
+						continue
+					case 'X':
+						return
+					default:
+						continue
+					}
+					if typ == 'Q' || typ == 'E' {
+						for range rows {
+							reply = append(reply, pgMessage('D', 0, 1, 0, 0, 0, 1, '1')...)
+						}
+						reply = append(reply, pgMessage('C', []byte("SELECT\x00")...)...)
+						if typ == 'Q' {
+							reply = append(reply, pgMessage('Z', 'I')...)
+						}
+					}
+					conn.Write(reply)
+				}
+			}()
+		}
+	}()
+	return listener, queries
+}
+
+// pgResult reads messages up to ReadyForQuery and returns how many rows
+// arrived and whether the result completed.
+func pgResult(r *bufio.Reader) (rows int, complete bool, err error) {
+	for {
+		typ, length, err := readPGHeader(r)
+		if err != nil {
+			return rows, complete, err
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return rows, complete, err
+		}
+		switch typ {
+		case 'D':
+			rows++
+		case 'C':
+			complete = true
+		case 'Z':
+			return rows, complete, nil
+		}
+	}
+}
+
+// TestPostgres tests that queries are killed between send and result, and
+// result sets cut off partway, and that encrypted connections pass through
+func TestPostgres(t *testing.T) {
+	extended := append(pgMessage('P', []byte("\x00SELECT 1\x00\x00\x00")...), pgMessage('B', 0, 0, 0, 0, 0, 0, 0, 0)...)
+	extended = append(extended, pgMessage('E', 0, 0, 0, 0, 0)...)
+	extended = append(extended, pgMessage('S')...)
+
+	tests := []struct {
+		name         string
+		database     *config.DatabaseConfig
+		ssl          bool
+		query        []byte
+		wantRows     int
+		wantComplete bool
+		wantFault    string
+		wantDetail   string
+	}{
+		{
+			name:         "clean query",
+			query:        pgMessage('Q', []byte("SELECT 1\x00")...),
+			wantRows:     3,
+			wantComplete: true,
+		},
+		{
+			name:       "killed query",
+			database:   &config.DatabaseConfig{KillQueryRate: 1},
+			query:      pgMessage('Q', []byte("SELECT  *\n FROM users\x00")...),
+			wantFault:  "db_kill_query",
+			wantDetail: "SELECT * FROM users",
+		},
+		{
+			name:       "killed extended query",
+			database:   &config.DatabaseConfig{KillQueryRate: 1},
+			query:      extended,
+			wantFault:  "db_kill_query",
+			wantDetail: "SELECT 1",
+		},
+		{
+			name:       "partial result",
+			database:   &config.DatabaseConfig{PartialResultRate: 1, PartialRows: 2},
+			query:      pgMessage('Q', []byte("SELECT 1\x00")...),
+			wantRows:   2,
+			wantFault:  "db_partial_result",
+			wantDetail: "after 2 rows",
+		},
+		{
+			name:       "partial result shorter than partialRows",
+			database:   &config.DatabaseConfig{PartialResultRate: 1, PartialRows: 5},
+			query:      pgMessage('Q', []byte("SELECT 1\x00")...),
+			wantRows:   3,
+			wantFault:  "db_partial_result",
+			wantDetail: "after 3 rows",
+		},
+		{
+			name:       "refused SSL then plaintext",
+			database:   &config.DatabaseConfig{KillQueryRate: 1},
+			ssl:        true,
+			query:      pgMessage('Q', []byte("SELECT 1\x00")...),
+			wantFault:  "db_kill_query",
+			wantDetail: "SELECT 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, queries := startFakePostgres(t, 3, false)
+			route := config.RouteConfig{Upstream: upstream.Addr().String(), Mode: config.ModePostgres, Database: tt.database}
+			bus := events.NewBus()
+			sub, unsubscribe := bus.Subscribe(16)
+			defer unsubscribe()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bound := make(chan net.Addr, 1)
+			go ServeRoute(ctx, route, ServeOptions{Events: bus, OnListen: func(addr net.Addr) { bound <- addr }})
+
+			conn, err := net.Dial("tcp", (<-bound).String())
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			r := bufio.NewReader(conn)
+
+			if tt.ssl {
+				conn.Write(pgStartup(pgSSLRequest))
+				if answer, err := r.ReadByte(); err != nil || answer != 'N' {
+					t.Fatalf("SSL answer = %q, %v; want N", answer, err)
+				}
+			}
+			conn.Write(pgStartup(196608))
+			if _, complete, err := pgResult(r); err != nil || complete {
+				t.Fatalf("startup failed: %v", err)
+			}
+
+			conn.Write(tt.query)
+			rows, complete, err := pgResult(r)
+			if rows != tt.wantRows || complete != tt.wantComplete {
+				t.Errorf("got %d rows, complete %v; want %d rows, complete %v", rows, complete, tt.wantRows, tt.wantComplete)
+			}
+			if tt.wantFault == "" {
+				if err != nil {
+					t.Errorf("query failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("connection survived, want it killed")
+			}
+			if tt.wantFault == "db_kill_query" {
+				select {
+				case <-queries:
+				default:
+					t.Error("upstream never received the killed query")
+				}
+			}
+			for {
+				select {
+				case e := <-sub:
+					if e.Type != events.Fault {
+						continue
+					}
+					if e.Fault != tt.wantFault || e.Detail != tt.wantDetail {
+						t.Errorf("fault = %s %q, want %s %q", e.Fault, e.Detail, tt.wantFault, tt.wantDetail)
+					}
+					return
+				case <-time.After(time.Second):
+					t.Fatalf("timed out waiting for %s fault", tt.wantFault)
+				}
+			}
+		})
+	}
+}
+
+// TestPostgres_SSL tests that a connection that negotiates TLS is relayed
+// untouched
+func TestPostgres_SSL(t *testing.T) {
+	upstream, _ := startFakePostgres(t, 3, true)
+	route := config.RouteConfig{
+		Upstream: upstream.Addr().String(),
+		Mode:     config.ModePostgres,
+		Database: &config.DatabaseConfig{KillQueryRate: 1, PartialResultRate: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+
+	conn, err := net.Dial("tcp", (<-bound).String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write(pgStartup(pgSSLRequest))
+	query := pgMessage('Q', []byte("SELECT 1\x00")...)
+	conn.Write(query)
+	got := make([]byte, 1+len(query))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("failed to read through proxy: %v", err)
+	}
+	if got[0] != 'S' || string(got[1:]) != string(query) {
+		t.Errorf("got %q, want S followed by the echoed bytes", got)
+	}
+}
+
+// mysqlPacket builds a MySQL packet.
+func mysqlPacket(seq byte, payload ...byte) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+// mysqlHandshakeResponse builds a protocol 4.1 handshake response with the
+// given capabilities.
+func mysqlHandshakeResponse(caps uint32, full bool) []byte {
+	payload := binary.LittleEndian.AppendUint32(nil, caps|mysqlClientProtocol41)
+	payload = append(payload, make([]byte, 28)...)
+	if full {
+		payload = append(payload, "root\x00\x00"...)
+	}
+	return mysqlPacket(1, payload...)
+}
+
+// startFakeMySQL starts an upstream that lets every client in, then
+// answers every query with one column and rows rows, echoing everything
+// after a TLS request instead. Queries it receives are reported.
+func startFakeMySQL(t *testing.T, rows int) (net.Listener, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	queries := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write(mysqlPacket(0, append([]byte{10}, "8.0.0\x00"...)...))
+				packet, err := readMySQLPacket(r)
+				if err != nil {
+					return
+				}
+				caps := binary.LittleEndian.Uint32(packet[4:])
+				if len(packet[4:]) == mysqlSSLRequestLen {
+					io.Copy(conn, r)
+					return
+				}
+				deprecateEOF := caps&mysqlClientDeprecateEOF != 0
+				conn.Write(mysqlPacket(2, 0, 0, 0, 2, 0, 0, 0))
+
+				for {
+					packet, err := readMySQLPacket(r)
+					if err != nil || packet[4] != mysqlComQuery {
+						return
+					}
+					queries <- string(packet[5:])
+					reply := mysqlPacket(1, 1)
+					reply = append(reply, mysqlPacket(2, []byte("\x03def\x00\x00\x00\x01n\x00\x0c\x3f\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00")...)...)
+					seq := byte(3)
+					if !deprecateEOF {
+						reply = append(reply, mysqlPacket(seq, mysqlEOF, 0, 0, 2, 0)...)
+						seq++
+					}
+					for range rows {
+						reply = append(reply, mysqlPacket(seq, 1, '1')...)
+						seq++
+					}
+					if deprecateEOF {
+						reply = append(reply, mysqlPacket(seq, mysqlEOF, 0, 0, 2, 0, 0, 0)...)
+					} else {
+						reply = append(reply, mysqlPacket(seq, mysqlEOF, 0, 0, 2, 0)...)
+					}
+					conn.Write(reply)
+				}
+			}()
+		}
+	}()
+	return listener, queries
+}
+
+// mysqlResult reads a one-column result set and returns how many rows
+// arrived and whether it completed.
+func mysqlResult(r *bufio.Reader, deprecateEOF bool) (rows int, complete bool, err error) {
+	skip := 2 // column count, column definition
+	if !deprecateEOF {
+		skip++
+	}
+	for {
+		packet, err := readMySQLPacket(r)
+		if err != nil {
+			return rows, false, err
+		}
+		switch {
+		case skip > 0:
+			skip--
+		case packet[4] == mysqlEOF && len(packet) < 4+9:
+			return rows, true, nil
+		default:
+			rows++
+		}
+	}
+}
+
+// TestMySQL tests that queries are killed between send and result, and
+// result sets cut off partway
+func TestMySQL(t *testing.T) {
+	tests := []struct {
+		name         string
+		database     *config.DatabaseConfig
+		caps         uint32
+		wantRows     int
+		wantComplete bool
+		wantFault    string
+		wantDetail   string
+	}{
+		{name: "clean query", wantRows: 3, wantComplete: true},
+		{name: "clean query without EOF packets", caps: mysqlClientDeprecateEOF, wantRows: 3, wantComplete: true},
+		{
+			name:       "killed query",
+			database:   &config.DatabaseConfig{KillQueryRate: 1},
+			wantFault:  "db_kill_query",
+			wantDetail: "SELECT n FROM t",
+		},
+		{
+			name:       "partial result",
+			database:   &config.DatabaseConfig{PartialResultRate: 1},
+			wantRows:   1,
+			wantFault:  "db_partial_result",
+			wantDetail: "after 1 rows",
+		},
+		{
+			name:       "partial result without EOF packets",
+			database:   &config.DatabaseConfig{PartialResultRate: 1, PartialRows: 2},
+			caps:       mysqlClientDeprecateEOF,
+			wantRows:   2,
+			wantFault:  "db_partial_result",
+			wantDetail: "after 2 rows",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, queries := startFakeMySQL(t, 3)
+			route := config.RouteConfig{Upstream: upstream.Addr().String(), Mode: config.ModeMySQL, Database: tt.database}
+			bus := events.NewBus()
+			sub, unsubscribe := bus.Subscribe(16)
+			defer unsubscribe()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bound := make(chan net.Addr, 1)
+			go ServeRoute(ctx, route, ServeOptions{Events: bus, OnListen: func(addr net.Addr) { bound <- addr }})
+
+			conn, err := net.Dial("tcp", (<-bound).String())
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			r := bufio.NewReader(conn)
+
+			if _, err := readMySQLPacket(r); err != nil {
+				t.Fatalf("failed to read greeting: %v", err)
+			}
+			conn.Write(mysqlHandshakeResponse(tt.caps, true))
+			if packet, err := readMySQLPacket(r); err != nil || packet[4] != mysqlOK {
+				t.Fatalf("login failed: %v", err)
+			}
+
+			conn.Write(mysqlPacket(0, append([]byte{mysqlComQuery}, "SELECT n FROM t"...)...))
+			rows, complete, err := mysqlResult(r, tt.caps&mysqlClientDeprecateEOF != 0)
+			if rows != tt.wantRows || complete != tt.wantComplete {
+				t.Errorf("got %d rows, complete %v; want %d rows, complete %v", rows, complete, tt.wantRows, tt.wantComplete)
+			}
+			if tt.wantFault == "" {
+				if err != nil {
+					t.Errorf("query failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Error("connection survived, want it killed")
+			}
+			if tt.wantFault == "db_kill_query" {
+				select {
+				case <-queries:
+				default:
+					t.Error("upstream never received the killed query")
+				}
+			}
+			for {
+				select {
+				case e := <-sub:
+					if e.Type != events.Fault {
+						continue
+					}
+					if e.Fault != tt.wantFault || e.Detail != tt.wantDetail {
+						t.Errorf("fault = %s %q, want %s %q", e.Fault, e.Detail, tt.wantFault, tt.wantDetail)
+					}
+					return
+				case <-time.After(time.Second):
+					t.Fatalf("timed out waiting for %s fault", tt.wantFault)
+				}
+			}
+		})
+	}
+}
+
+// TestMySQL_SSL tests that a connection that asks for TLS is relayed
+// untouched
+func TestMySQL_SSL(t *testing.T) {
+	upstream, _ := startFakeMySQL(t, 3)
+	route := config.RouteConfig{
+		Upstream: upstream.Addr().String(),
+		Mode:     config.ModeMySQL,
+		Database: &config.DatabaseConfig{KillQueryRate: 1, PartialResultRate: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+
+	conn, err := net.Dial("tcp", (<-bound).String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+
+	if _, err := readMySQLPacket(r); err != nil {
+		t.Fatalf("failed to read greeting: %v", err)
+	}
+	conn.Write(mysqlHandshakeResponse(mysqlClientSSL, false))
+	hello := []byte("\x16\x03\x01\x00\x05hello")
+	conn.Write(hello)
+	got := make([]byte, len(hello))
+	if _, err := io.ReadFull(r, got); err != nil || string(got) != string(hello) {
+		t.Errorf("echo = %q, %v; want %q", got, err, hello)
+	}
+}
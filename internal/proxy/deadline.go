@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"time"
+)
+
+// deadlineConn gives each read and write on a connection its own deadline,
+// so a peer that vanished without a FIN, or stopped reading, fails the copy
+// waiting on it instead of holding its goroutine forever. 0 leaves that
+// side without one.
+type deadlineConn struct {
+	net.Conn
+	read, write time.Duration
+}
+
+// halfCloseDeadlineConn is a deadlineConn over a connection that can
+// half-close, so a FIN is still passed on as one.
+type halfCloseDeadlineConn struct {
+	*deadlineConn
+}
+
+func (c halfCloseDeadlineConn) CloseWrite() error {
+	return c.Conn.(interface{ CloseWrite() error }).CloseWrite()
+}
+
+// withDeadlines wraps conn so its reads and writes time out after read and
+// write. conn is returned as it is if neither is set.
+func withDeadlines(conn net.Conn, read, write time.Duration) net.Conn {
+	if read <= 0 && write <= 0 {
+		return conn
+	}
+	c := &deadlineConn{Conn: conn, read: read, write: write}
+	if _, ok := conn.(interface{ CloseWrite() error }); ok {
+		return halfCloseDeadlineConn{c}
+	}
+	return c
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	if c.read > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.read))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if c.write > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.write))
+	}
+	return c.Conn.Write(p)
+}
+
+// keepAlive turns on TCP keepalives for conn, if it is a TCP connection, so
+// the kernel notices a peer that vanished and fails reads waiting on it.
+// Connections from net.Listen and net.Dial have them already; handed-off
+// ones and those from a caller's listener or dialer may not.
+func keepAlive(conn net.Conn) {
+	if tcp, ok := conn.(interface {
+		SetKeepAliveConfig(net.KeepAliveConfig) error
+	}); ok {
+		tcp.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true})
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestWithDeadlines(t *testing.T) {
+	a, b := tcpPair(t)
+	if got := withDeadlines(a, 0, 0); got != net.Conn(a) {
+		t.Errorf("withDeadlines() with no timeouts = %T, want the connection as it is", got)
+	}
+	if _, ok := withDeadlines(a, time.Second, 0).(interface{ CloseWrite() error }); !ok {
+		t.Error("withDeadlines() of a TCP connection can't half-close")
+	}
+	if _, ok := withDeadlines(struct{ net.Conn }{a}, time.Second, 0).(interface{ CloseWrite() error }); ok {
+		t.Error("withDeadlines() of a connection without CloseWrite can half-close")
+	}
+
+	conn := withDeadlines(b, 50*time.Millisecond, 0)
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("Read() with nothing sent = %v, want a timeout", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Read() timed out after %v, want about 50ms", took)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{
+		LocalPort:     findFreePort(t),
+		Upstream:      upstream.Addr().String(),
+		ReadTimeoutMs: 200,
+	}
+	records := make(chan ConnRecord, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeRoute(ctx, route, ServeOptions{OnConnClose: func(r ConnRecord) { records <- r }})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Traffic pushes the deadline back; then neither side sends anything.
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+			t.Fatalf("connection closed while in use: %v", err)
+		}
+	}
+	start := time.Now()
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read from a silent connection succeeded, want it closed")
+	}
+	if took := time.Since(start); took < 150*time.Millisecond || took > time.Second {
+		t.Errorf("silent connection closed after %v, want about 200ms", took)
+	}
+
+	select {
+	case r := <-records:
+		if r.CloseReason != CloseTimeout {
+			t.Errorf("closeReason = %q, want %q", r.CloseReason, CloseTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no ConnRecord for the timed out connection")
+	}
+}
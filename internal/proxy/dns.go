@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// dnsHeaderLen is the size of a DNS message header.
+const dnsHeaderLen = 12
+
+// dnsTypeOPT is the EDNS pseudo-record type, whose TTL field holds flags
+// rather than a TTL.
+const dnsTypeOPT = 41
+
+// dnsChaos is a dns mode route's per-query chaos, shared by its UDP and TCP
+// transports.
+type dnsChaos struct {
+	route      config.RouteConfig
+	ritual     chaos.Ritual
+	clientAddr string
+	logger     *slog.Logger
+	opts       ServeOptions
+}
+
+// newDNSChaos returns route's DNS chaos for one client, or nil when the
+// route isn't in dns mode.
+func newDNSChaos(route config.RouteConfig, ritual chaos.Ritual, clientAddr string, logger *slog.Logger, opts ServeOptions) *dnsChaos {
+	if route.Mode != config.ModeDNS {
+		return nil
+	}
+	return &dnsChaos{route: route, ritual: ritual, clientAddr: clientAddr, logger: logger, opts: opts}
+}
+
+// fail rolls whether query gets an injected error and, if it does, returns
+// the response to answer it with instead of forwarding it.
+func (d *dnsChaos) fail(query []byte) []byte {
+	if !d.ritual.RequestFails() {
+		return nil
+	}
+	name, end, ok := dnsQuestion(query)
+	if !ok || query[2]&0x80 != 0 {
+		// Not a query the proxy can answer; let the upstream deal with it.
+		return nil
+	}
+	codes := d.route.DNS.ErrorCodesOrDefault()
+	code := codes[d.ritual.Rand().Intn(len(codes))]
+	if !d.opts.decide(d.logger, "dns_error", "answering DNS query with an error", "address", d.clientAddr, "upstream", d.route.Upstream, "name", name, "code", code) {
+		return nil
+	}
+	d.opts.publishFault(d.route, d.clientAddr, "dns_error", code+" "+name)
+	rcode, _ := config.DNSRcode(code)
+	return dnsError(query[:end], rcode)
+}
+
+// respond rolls the chaos for a response from the upstream, rewriting its
+// TTLs in place, and returns how long to hold it, or 0.
+func (d *dnsChaos) respond(response []byte) time.Duration {
+	name, _, _ := dnsQuestion(response)
+	attrs := []any{"address", d.clientAddr, "upstream", d.route.Upstream, "name", name}
+
+	if cfg := d.route.DNS; cfg != nil && cfg.TTLRate > 0 && d.ritual.Rand().Float64() < cfg.TTLRate {
+		offsets, ok := dnsTTLOffsets(response)
+		if ok && len(offsets) > 0 && d.opts.decide(d.logger, "dns_ttl", "rewriting DNS TTLs", append(attrs, "ttl", cfg.TTL, "records", len(offsets))...) {
+			d.opts.publishFault(d.route, d.clientAddr, "dns_ttl", fmt.Sprintf("%ds %s", cfg.TTL, name))
+			for _, off := range offsets {
+				binary.BigEndian.PutUint32(response[off:], uint32(cfg.TTL))
+			}
+		}
+	}
+
+	if delay := d.ritual.MessageDelay(); delay > 0 && d.opts.decide(d.logger, "dns_delay", "delaying DNS response", append(attrs, "delay", delay)...) {
+		d.opts.publishDelay(d.route, d.clientAddr, "dns_delay", delay)
+		return delay
+	}
+	return 0
+}
+
+// dnsQuestion returns the name asked about in msg's first question, and
+// where its question section ends. ok is false if msg is too malformed to
+// find either.
+func dnsQuestion(msg []byte) (name string, end int, ok bool) {
+	if len(msg) < dnsHeaderLen {
+		return "", 0, false
+	}
+	end = dnsHeaderLen
+	for i := range int(binary.BigEndian.Uint16(msg[4:])) {
+		var qname string
+		if qname, end, ok = dnsName(msg, end); !ok || end+4 > len(msg) {
+			return "", 0, false
+		}
+		if i == 0 {
+			name = qname
+		}
+		end += 4 // type and class
+	}
+	return name, end, true
+}
+
+// dnsName reads the possibly compressed domain name at off in msg and
+// returns it and the offset just past it.
+func dnsName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	next := -1
+	// Each pointer must jump backwards, which bounds the number of jumps.
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, true
+		case n&0xC0 == 0xC0:
+			if off+2 > len(msg) || jumps > len(msg) {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			target := int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			if target >= off {
+				return "", 0, false
+			}
+			off = target
+			jumps++
+		case n&0xC0 != 0:
+			return "", 0, false
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, false
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// dnsTTLOffsets returns the offsets of the TTL of every resource record in
+// msg, skipping EDNS pseudo-records. ok is false if msg is malformed, in
+// which case none should be touched.
+func dnsTTLOffsets(msg []byte) (offsets []int, ok bool) {
+	_, off, ok := dnsQuestion(msg)
+	if !ok {
+		return nil, false
+	}
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	for range records {
+		if _, off, ok = dnsName(msg, off); !ok || off+10 > len(msg) {
+			return nil, false
+		}
+		if binary.BigEndian.Uint16(msg[off:]) != dnsTypeOPT {
+			offsets = append(offsets, off+4)
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+		if off > len(msg) {
+			return nil, false
+		}
+	}
+	return offsets, true
+}
+
+// dnsError builds the response to a query that fails with rcode, from the
+// query's header and question section.
+func dnsError(question []byte, rcode int) []byte {
+	resp := append([]byte(nil), question...)
+	// Keep the ID, opcode and RD, set QR and RA, and answer nothing.
+	resp[2] = 0x80 | question[2]&0x79
+	resp[3] = 0x80 | byte(rcode)
+	clear(resp[6:dnsHeaderLen])
+	return resp
+}
+
+// readDNSMessage reads one length-prefixed DNS message, as DNS is framed
+// over TCP and TLS. A clean EOF between messages is returned as io.EOF.
+func readDNSMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeDNSMessage writes msg with its length prefix in a single write, so
+// toxics see whole messages.
+func writeDNSMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+// dnsForwarder relays DNS over a TCP connection one message at a time, so
+// each query and response gets the route's DNS chaos.
+type dnsForwarder struct {
+	dns                    *dnsChaos
+	fromClient, fromServer io.Reader
+	toClient, toServer     *countingWriter
+
+	// mu serializes writes to the client, which come from both directions
+	// when the proxy answers a query itself.
+	mu sync.Mutex
+}
+
+// queries relays the client's queries to the upstream, answering those
+// that get an injected error itself. It returns nil when the client is
+// done sending.
+func (f *dnsForwarder) queries() error {
+	for {
+		msg, err := readDNSMessage(f.fromClient)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if resp := f.dns.fail(msg); resp != nil {
+			if err := f.writeClient(resp); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeDNSMessage(f.toServer, msg); err != nil {
+			return err
+		}
+	}
+}
+
+// responses relays the upstream's responses to the client. It returns nil
+// when the upstream is done sending.
+func (f *dnsForwarder) responses() error {
+	for {
+		msg, err := readDNSMessage(f.fromServer)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if delay := f.dns.respond(msg); delay > 0 {
+			time.Sleep(delay)
+		}
+		if err := f.writeClient(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *dnsForwarder) writeClient(msg []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeDNSMessage(f.toClient, msg)
+}
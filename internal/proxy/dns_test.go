@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// dnsQuery builds a recursive A query for name.
+func dnsQuery(id uint16, name string) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0, 0, 1, 0, 1)
+}
+
+// dnsAnswer answers query with 127.0.0.1 and a TTL of 300 seconds, the
+// answer's name compressed to point at the question.
+func dnsAnswer(query []byte) []byte {
+	resp := append([]byte(nil), query...)
+	resp[2] |= 0x80
+	resp[3] = 0x80
+	binary.BigEndian.PutUint16(resp[6:], 1)
+	return append(resp, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0x01, 0x2C, 0, 4, 127, 0, 0, 1)
+}
+
+// startDNSServer starts an upstream answering every query with dnsAnswer,
+// over UDP or TCP, and returns its address.
+func startDNSServer(t *testing.T, protocol string) string {
+	t.Helper()
+	if protocol == config.ProtocolUDP {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to start DNS server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			buf := make([]byte, maxDatagram)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(dnsAnswer(buf[:n]), addr)
+			}
+		}()
+		return conn.LocalAddr().String()
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start DNS server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					query, err := readDNSMessage(conn)
+					if err != nil {
+						return
+					}
+					writeDNSMessage(conn, dnsAnswer(query))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// startDNSRoute serves route in front of a DNS server and returns the
+// proxy's address.
+func startDNSRoute(t *testing.T, route config.RouteConfig) string {
+	t.Helper()
+	route.Mode = config.ModeDNS
+	route.Upstream = startDNSServer(t, route.Protocol)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+	select {
+	case addr := <-bound:
+		return addr.String()
+	case <-time.After(1 * time.Second):
+		t.Fatal("DNS route did not start listening")
+		return ""
+	}
+}
+
+// TestDNSError tests that injected errors answer the query they fail
+func TestDNSError(t *testing.T) {
+	query := dnsQuery(0xBEEF, "example.com")
+	_, end, _ := dnsQuestion(query)
+	resp := dnsError(query[:end], 3)
+
+	if !bytes.Equal(resp[:2], query[:2]) {
+		t.Errorf("ID = %x, want %x", resp[:2], query[:2])
+	}
+	if resp[2]&0x80 == 0 || resp[2]&0x01 == 0 {
+		t.Errorf("flags = %08b, want QR and RD set", resp[2])
+	}
+	if rcode := resp[3] & 0x0F; rcode != 3 {
+		t.Errorf("rcode = %d, want 3", rcode)
+	}
+	if !bytes.Equal(resp[12:], query[12:end]) {
+		t.Errorf("question = %x, want %x", resp[12:], query[12:end])
+	}
+}
+
+// TestDNSTTLOffsets tests that every record's TTL is found, and none in a
+// malformed message
+func TestDNSTTLOffsets(t *testing.T) {
+	answer := dnsAnswer(dnsQuery(1, "example.com"))
+	withOPT := append(append([]byte(nil), answer...), 0, 0, 41, 0x10, 0, 0, 0, 0, 0, 0, 0)
+	withOPT[11] = 1
+	loop := append(dnsQuery(1, "example.com")[:12:12], 0xC0, 12, 0, 1, 0, 1)
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    int
+		wantErr bool
+	}{
+		{name: "answer with compressed name", msg: answer, want: 1},
+		{name: "OPT record skipped", msg: withOPT, want: 1},
+		{name: "query has no records", msg: dnsQuery(1, "example.com"), want: 0},
+		{name: "truncated record", msg: answer[:len(answer)-2], wantErr: true},
+		{name: "pointer loop", msg: loop, wantErr: true},
+		{name: "shorter than a header", msg: answer[:8], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offsets, ok := dnsTTLOffsets(tt.msg)
+			if ok == tt.wantErr {
+				t.Fatalf("dnsTTLOffsets() ok = %v, wantErr %v", ok, tt.wantErr)
+			}
+			if len(offsets) != tt.want {
+				t.Errorf("got %d TTLs, want %d", len(offsets), tt.want)
+			}
+			for _, off := range offsets {
+				if ttl := binary.BigEndian.Uint32(tt.msg[off:]); ttl != 300 {
+					t.Errorf("TTL at %d = %d, want 300", off, ttl)
+				}
+			}
+		})
+	}
+}
+
+// TestDNSMode tests DNS chaos over UDP and TCP
+func TestDNSMode(t *testing.T) {
+	delayRate := 1.0
+	tests := []struct {
+		name      string
+		dns       *config.DNSConfig
+		wantRcode byte
+		wantTTL   uint32
+		minDelay  time.Duration
+	}{
+		{name: "relayed cleanly", wantTTL: 300},
+		{name: "injected error", dns: &config.DNSConfig{ErrorRate: 1, ErrorCodes: []string{"NXDOMAIN"}}, wantRcode: 3},
+		{name: "TTLs rewritten", dns: &config.DNSConfig{TTLRate: 1, TTL: 5}, wantTTL: 5},
+		{name: "response delayed", dns: &config.DNSConfig{DelayMs: 100, DelayRate: &delayRate}, wantTTL: 300, minDelay: 100 * time.Millisecond},
+	}
+
+	for _, protocol := range []string{config.ProtocolUDP, config.ProtocolTCP} {
+		for _, tt := range tests {
+			t.Run(protocol+"/"+tt.name, func(t *testing.T) {
+				addr := startDNSRoute(t, config.RouteConfig{Protocol: protocol, DNS: tt.dns})
+				conn, err := net.Dial(protocol, addr)
+				if err != nil {
+					t.Fatalf("failed to dial proxy: %v", err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+				query := dnsQuery(0x1234, "example.com")
+				start := time.Now()
+				var resp []byte
+				if protocol == config.ProtocolUDP {
+					conn.Write(query)
+					buf := make([]byte, maxDatagram)
+					n, err := conn.Read(buf)
+					if err != nil {
+						t.Fatalf("failed to read response: %v", err)
+					}
+					resp = buf[:n]
+				} else {
+					writeDNSMessage(conn, query)
+					if resp, err = readDNSMessage(conn); err != nil {
+						t.Fatalf("failed to read response: %v", err)
+					}
+				}
+				elapsed := time.Since(start)
+
+				if binary.BigEndian.Uint16(resp) != 0x1234 {
+					t.Errorf("ID = %#x, want 0x1234", binary.BigEndian.Uint16(resp))
+				}
+				if rcode := resp[3] & 0x0F; rcode != tt.wantRcode {
+					t.Errorf("rcode = %d, want %d", rcode, tt.wantRcode)
+				}
+				offsets, _ := dnsTTLOffsets(resp)
+				if tt.wantTTL == 0 && len(offsets) != 0 {
+					t.Errorf("got %d answers, want none", len(offsets))
+				}
+				for _, off := range offsets {
+					if ttl := binary.BigEndian.Uint32(resp[off:]); ttl != tt.wantTTL {
+						t.Errorf("TTL = %d, want %d", ttl, tt.wantTTL)
+					}
+				}
+				if elapsed < tt.minDelay {
+					t.Errorf("response took %v, want at least %v", elapsed, tt.minDelay)
+				}
+			})
+		}
+	}
+}
+
+// TestDNSMessage_Truncated tests that a connection cut mid-message is an
+// error rather than a clean end
+func TestDNSMessage_Truncated(t *testing.T) {
+	if _, err := readDNSMessage(bytes.NewReader([]byte{0, 10, 1, 2})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readDNSMessage() error = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// failRPC rolls whether an RPC gets an injected gRPC error and, if it does,
+// answers it through w. It reports whether the RPC was answered.
+func (f *h2Forwarder) failRPC(w http.ResponseWriter, attrs []any) bool {
+	if !f.ritual.RequestFails() {
+		return false
+	}
+	codes := f.route.GRPC.ErrorCodesOrDefault()
+	name := codes[f.ritual.Rand().Intn(len(codes))]
+	if !f.opts.decide(f.logger, "grpc_error", "answering RPC with an error", append(attrs, "code", name)...) {
+		return false
+	}
+	f.opts.publishFault(f.route, f.clientAddr, "grpc_error", name)
+	code, _ := config.GRPCCode(name)
+	writeGRPCStatus(w, code, "chaos-proxy: injected "+name)
+	return true
+}
+
+// messages wraps one direction of an RPC's body so its messages can be
+// held by the route's message delay.
+func (f *h2Forwarder) messages(body io.ReadCloser, direction string, attrs []any) io.ReadCloser {
+	if !f.ritual.DelaysMessages() {
+		return body
+	}
+	return &messageDelayer{ReadCloser: body, delay: func() {
+		if delay := f.ritual.MessageDelay(); delay > 0 && f.opts.decide(f.logger, "grpc_delay", "delaying gRPC message", append(attrs, "direction", direction, "delay", delay)...) {
+			f.opts.publishFault(f.route, f.clientAddr, "grpc_delay", direction)
+			f.opts.Stats.recordDelay("grpc_delay", delay)
+			time.Sleep(delay)
+		}
+	}}
+}
+
+// grpcUnavailable is the UNAVAILABLE status code.
+const grpcUnavailable = 14
+
+// writeGRPCStatus answers an RPC with a trailers-only response carrying
+// code, the way a gRPC server rejects a call without sending any messages.
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// messageDelayer passes a gRPC stream through, calling delay before each
+// of its length-prefixed messages.
+type messageDelayer struct {
+	io.ReadCloser
+	delay func()
+
+	header    [5]byte
+	pending   []byte // the rest of the current message's header
+	remaining int64  // payload bytes left in the current message
+}
+
+func (d *messageDelayer) Read(p []byte) (int, error) {
+	if len(d.pending) == 0 && d.remaining == 0 {
+		// A clean EOF here, between messages, ends the stream.
+		if _, err := io.ReadFull(d.ReadCloser, d.header[:]); err != nil {
+			return 0, err
+		}
+		d.delay()
+		d.pending = d.header[:]
+		d.remaining = int64(binary.BigEndian.Uint32(d.header[1:]))
+	}
+
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.ReadCloser.Read(p)
+	d.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && d.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
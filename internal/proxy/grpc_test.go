@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// grpcEcho is a gRPC-shaped upstream that answers every message of a
+// stream with the same message as soon as it arrives, then OK.
+func grpcEcho(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	rc.Flush()
+	for {
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			break
+		}
+		writeGRPCMessage(w, msg)
+		rc.Flush()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	copy(frame[5:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// unaryRPC sends msg as a unary RPC and returns the reply and grpc-status.
+func unaryRPC(client *http.Client, url string, msg []byte) ([]byte, string, error) {
+	var body bytes.Buffer
+	writeGRPCMessage(&body, msg)
+	req, _ := http.NewRequest(http.MethodPost, url+"/echo.Echo/Say", &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	reply, err := readGRPCMessage(resp.Body)
+	io.Copy(io.Discard, resp.Body)
+	// A trailers-only response carries its status in the headers.
+	status := resp.Header.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Trailer.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return nil, status, nil
+	}
+	return reply, status, err
+}
+
+// TestGRPCMode_Forward tests that RPCs are multiplexed over one upstream
+// connection with their messages and trailers intact
+func TestGRPCMode_Forward(t *testing.T) {
+	url, upstreamConns := startH2Mode(t, config.RouteConfig{Mode: config.ModeGRPC}, http.HandlerFunc(grpcEcho))
+	client := h2Client(t)
+
+	for i := range 10 {
+		msg := fmt.Appendf(nil, "message %d", i)
+		reply, status, err := unaryRPC(client, url, msg)
+		if err != nil || status != "0" || !bytes.Equal(reply, msg) {
+			t.Fatalf("RPC %d = %q, status %q, error %v, want %q and status 0", i, reply, status, err, msg)
+		}
+	}
+	if got := upstreamConns.Load(); got != 1 {
+		t.Errorf("upstream connections = %d, want 1", got)
+	}
+}
+
+// TestGRPCMode_Chaos tests that drops and injected statuses are rolled per
+// RPC, and message delays per message
+func TestGRPCMode_Chaos(t *testing.T) {
+	tests := []struct {
+		name  string
+		route config.RouteConfig
+		check func(t *testing.T, client *http.Client, url string)
+	}{
+		{
+			name:  "injected status",
+			route: config.RouteConfig{GRPC: &config.GRPCConfig{ErrorRate: 1, ErrorCodes: []string{"DEADLINE_EXCEEDED"}}},
+			check: func(t *testing.T, client *http.Client, url string) {
+				for range 3 {
+					if _, status, err := unaryRPC(client, url, []byte("hi")); err != nil || status != "4" {
+						t.Errorf("status = %q, error %v, want 4 (DEADLINE_EXCEEDED)", status, err)
+					}
+				}
+			},
+		},
+		{
+			name:  "dropped RPCs reset their stream",
+			route: config.RouteConfig{DropRate: 1},
+			check: func(t *testing.T, client *http.Client, url string) {
+				for range 3 {
+					if _, status, err := unaryRPC(client, url, []byte("hi")); err == nil {
+						t.Errorf("status = %q, want the stream reset", status)
+					}
+				}
+			},
+		},
+		{
+			name:  "messages delayed both ways on a stream",
+			route: config.RouteConfig{GRPC: &config.GRPCConfig{MessageDelayMs: 40}},
+			check: func(t *testing.T, client *http.Client, url string) {
+				reqBody, writer := io.Pipe()
+				req, _ := http.NewRequest(http.MethodPost, url+"/echo.Echo/Chat", reqBody)
+				req.Header.Set("Content-Type", "application/grpc")
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("failed to open stream: %v", err)
+				}
+				defer resp.Body.Close()
+
+				for i := range 3 {
+					start := time.Now()
+					msg := fmt.Appendf(nil, "turn %d", i)
+					writeGRPCMessage(writer, msg)
+					reply, err := readGRPCMessage(resp.Body)
+					if err != nil || !bytes.Equal(reply, msg) {
+						t.Fatalf("turn %d = %q, error %v, want %q", i, reply, err, msg)
+					}
+					if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+						t.Errorf("turn %d took %v, want at least 80ms", i, elapsed)
+					}
+				}
+				writer.Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.Mode = config.ModeGRPC
+			url, _ := startH2Mode(t, tt.route, http.HandlerFunc(grpcEcho))
+			tt.check(t, h2Client(t), url)
+		})
+	}
+}
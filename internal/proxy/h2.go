@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/script"
+)
+
+// h2Forwarder relays the streams a client multiplexes over one cleartext
+// HTTP/2 connection to the upstream over the client's own upstream
+// connection, rolling chaos per stream. In grpc mode each stream is an RPC.
+type h2Forwarder struct {
+	route      config.RouteConfig
+	ritual     chaos.Ritual
+	script     *script.Script
+	clientAddr string
+	logger     *slog.Logger
+	opts       ServeOptions
+
+	toClient, toServer *countingWriter
+	transport          *http.Transport
+}
+
+// errUpstreamGone stops the transport dialling again once the upstream
+// connection it was handed has closed.
+var errUpstreamGone = errors.New("h2: upstream connection closed")
+
+// serve speaks cleartext HTTP/2 (h2c) to client and server until either
+// side closes. It returns nil when the connection ended cleanly.
+func (f *h2Forwarder) serve(client, server *streamConn) error {
+	// When the upstream goes away, so does the client, as in tcp mode.
+	server.onClose = func() { client.Close() }
+
+	var dialed atomic.Bool
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	f.transport = &http.Transport{
+		Protocols: protocols,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			if dialed.Swap(true) {
+				return nil, errUpstreamGone
+			}
+			return server, nil
+		},
+	}
+	defer f.transport.CloseIdleConnections()
+
+	listener := newConnListener(client)
+	srv := &http.Server{
+		Handler:   f,
+		Protocols: protocols,
+		ErrorLog:  slog.NewLogLogger(f.logger.Handler(), slog.LevelDebug),
+	}
+	if err := srv.Serve(listener); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP forwards one stream, rolling its chaos first.
+func (f *h2Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, opts := f.route, f.opts
+	target := r.Method + " " + r.URL.RequestURI()
+	attrs := []any{"address", f.clientAddr, "upstream", route.Upstream, "method", r.Method, "path", r.URL.Path}
+
+	if f.script != nil && f.scriptStream(w, r, attrs) {
+		return
+	}
+
+	curse := newCurse(route, f.ritual, f.clientAddr)
+	if curse.DropConnections && opts.decide(f.logger, "drop", "resetting stream", attrs...) {
+		opts.Stats.recordFailure()
+		opts.publishFault(route, f.clientAddr, "drop", target)
+		// Resets the stream, leaving the connection's other streams alone.
+		panic(http.ErrAbortHandler)
+	}
+
+	if route.Mode == config.ModeGRPC && f.failRPC(w, attrs) {
+		return
+	}
+
+	if curse.StartDelay > 0 && opts.decide(f.logger, "latency", "delaying stream", append(attrs, "delay", curse.StartDelay)...) {
+		opts.publishDelay(route, f.clientAddr, "latency", curse.StartDelay)
+		time.Sleep(curse.StartDelay)
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.URL.Scheme = "http"
+	out.URL.Host = route.Upstream
+	out.Body = f.messages(r.Body, "to-server", attrs)
+	resp, err := f.transport.RoundTrip(out)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		f.logger.Error("failed to forward stream upstream",
+			"address", f.clientAddr,
+			"upstream", route.Upstream,
+			"path", r.URL.Path,
+			"error", err,
+			"hint", fmt.Sprintf("route is in %q mode: its upstream must speak cleartext HTTP/2 (h2c with prior knowledge)", route.Mode))
+		if route.Mode == config.ModeGRPC {
+			writeGRPCStatus(w, grpcUnavailable, "chaos-proxy: upstream unavailable")
+		} else {
+			http.Error(w, "chaos-proxy: upstream unavailable", http.StatusBadGateway)
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	if f.ritual.StreamResets() && r.Method != http.MethodHead {
+		if err := f.cutStream(resp, target, attrs); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	body := f.messages(resp.Body, "to-client", attrs)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// The upstream reset the stream, or chaos cut it; pass the
+			// reset on.
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	// HTTP/2 trailers, including grpc-status, arrive after the body.
+	for name, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+name, value)
+		}
+	}
+}
+
+// cutStream arranges for resp's stream to be reset at a random point of
+// its body, after its headers have gone out.
+func (f *h2Forwarder) cutStream(resp *http.Response, target string, attrs []any) error {
+	size, err := sizeBody(resp)
+	if err != nil {
+		return err
+	}
+	var at int64
+	if size > 0 {
+		at = f.ritual.Rand().Int63n(size)
+	}
+	if f.opts.decide(f.logger, "http2_reset", "resetting stream mid-response", append(attrs, "status", resp.StatusCode, "at", at, "length", size)...) {
+		f.opts.publishFault(f.route, f.clientAddr, "http2_reset", fmt.Sprintf("%s after %d of %d bytes", target, at, size))
+		resp.Body = &cutBody{ReadCloser: resp.Body, remaining: at}
+	}
+	return nil
+}
+
+// streamConn is a connection whose reads and writes go through the
+// proxy's wrapped streams, so toxics, the idle killer and payload logging
+// see them. Every write is flushed, since HTTP/2 writes whole frames.
+type streamConn struct {
+	net.Conn
+	r       io.Reader
+	w       *countingWriter
+	onClose func()
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	err := c.Conn.Close()
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return err
+}
+
+// connListener hands an http.Server its one connection, then blocks until
+// the server closes it, so Serve returns when the connection is done.
+type connListener struct {
+	conns     chan net.Conn
+	addr      net.Addr
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(conn *streamConn) *connListener {
+	l := &connListener{conns: make(chan net.Conn, 1), addr: conn.LocalAddr(), done: make(chan struct{})}
+	conn.onClose = func() { l.Close() }
+	l.conns <- conn
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// startH2Mode serves route, in http2 or grpc mode, in front of an h2c
+// upstream running handler, and returns the proxy's URL and a count of
+// upstream connections.
+func startH2Mode(t *testing.T, route config.RouteConfig, handler http.Handler) (string, *atomic.Int64) {
+	t.Helper()
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(handler)
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	route.LocalPort = findFreePort(t)
+	route.Upstream = upstream.Listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go ServeRoute(ctx, route, ServeOptions{})
+	time.Sleep(50 * time.Millisecond)
+
+	return fmt.Sprintf("http://127.0.0.1:%d", route.LocalPort), &conns
+}
+
+// h2Client sends every request over one cleartext HTTP/2 connection.
+func h2Client(t *testing.T) *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 2 * time.Second}
+}
+
+// TestHTTP2Mode_Streams tests that chaos hits individual streams while the
+// others sharing their connection carry on
+func TestHTTP2Mode_Streams(t *testing.T) {
+	body := strings.Repeat("x", 16*1024)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	})
+
+	tests := []struct {
+		name  string
+		route config.RouteConfig
+		check func(t *testing.T, ok, failed, headersOnly int, elapsed time.Duration)
+	}{
+		{
+			name:  "clean",
+			route: config.RouteConfig{},
+			check: func(t *testing.T, ok, failed, headersOnly int, _ time.Duration) {
+				if ok != 20 {
+					t.Errorf("ok = %d, failed = %d, cut = %d, want all 20 ok", ok, failed, headersOnly)
+				}
+			},
+		},
+		{
+			name:  "streams reset before forwarding",
+			route: config.RouteConfig{DropRate: 0.5},
+			check: func(t *testing.T, ok, failed, headersOnly int, _ time.Duration) {
+				if ok == 0 || failed == 0 || headersOnly > 0 {
+					t.Errorf("ok = %d, failed = %d, cut = %d, want a mix of ok and failed streams", ok, failed, headersOnly)
+				}
+			},
+		},
+		{
+			name:  "streams reset mid-response",
+			route: config.RouteConfig{HTTP2: &config.HTTP2Config{ResetRate: 1}},
+			check: func(t *testing.T, ok, failed, headersOnly int, _ time.Duration) {
+				if headersOnly != 20 {
+					t.Errorf("ok = %d, failed = %d, cut = %d, want every response cut after its headers", ok, failed, headersOnly)
+				}
+			},
+		},
+		{
+			name:  "latency per stream",
+			route: config.RouteConfig{LatencyMs: 100},
+			check: func(t *testing.T, ok, _, _ int, elapsed time.Duration) {
+				if ok != 20 || elapsed < 100*time.Millisecond || elapsed > time.Second {
+					t.Errorf("ok = %d in %v, want 20 concurrent streams delayed 100ms each", ok, elapsed)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.Mode = config.ModeHTTP2
+			url, upstreamConns := startH2Mode(t, tt.route, upstream)
+			client := h2Client(t)
+			// Open the connection first, so the concurrent streams share it.
+			if resp, err := client.Head(url); err == nil {
+				resp.Body.Close()
+			}
+
+			var ok, failed, headersOnly atomic.Int64
+			var wg sync.WaitGroup
+			start := time.Now()
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := client.Get(url + "/item")
+					if err != nil {
+						failed.Add(1)
+						return
+					}
+					defer resp.Body.Close()
+					got, err := io.ReadAll(resp.Body)
+					switch {
+					case err != nil && len(got) < len(body):
+						headersOnly.Add(1)
+					case err == nil && string(got) == body:
+						ok.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			tt.check(t, int(ok.Load()), int(failed.Load()), int(headersOnly.Load()), time.Since(start))
+			if got := upstreamConns.Load(); got != 1 {
+				t.Errorf("upstream connections = %d, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+//go:build unix
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// maxHandoffFDs bounds how many descriptors one handoff message may carry.
+const maxHandoffFDs = 16
+
+// handoffListener is a net.Listener fed by other processes: they connect to
+// a Unix socket and pass already-accepted connections over it as SCM_RIGHTS
+// descriptors. Each message carries at least one byte of ordinary data
+// alongside the descriptors; the data itself is ignored.
+type handoffListener struct {
+	unix   *net.UnixListener
+	conns  chan net.Conn
+	logger *slog.Logger
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	senders map[*net.UnixConn]struct{}
+}
+
+func listenHandoff(path string, logger *slog.Logger) (*handoffListener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	unix, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &handoffListener{
+		unix:    unix,
+		conns:   make(chan net.Conn),
+		logger:  logger,
+		closed:  make(chan struct{}),
+		senders: make(map[*net.UnixConn]struct{}),
+	}
+	go l.acceptSenders()
+	return l, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous run.
+// A socket something is still listening on is left alone, as is anything
+// that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+func (l *handoffListener) acceptSenders() {
+	for {
+		sender, err := l.unix.AcceptUnix()
+		if err != nil {
+			return
+		}
+
+		l.mu.Lock()
+		select {
+		case <-l.closed:
+			l.mu.Unlock()
+			sender.Close()
+			return
+		default:
+		}
+		l.senders[sender] = struct{}{}
+		l.mu.Unlock()
+
+		go l.receive(sender)
+	}
+}
+
+// receive reads handoff messages from one sender until it disconnects.
+// A sender may hand off any number of connections over one socket.
+func (l *handoffListener) receive(sender *net.UnixConn) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.senders, sender)
+		l.mu.Unlock()
+		sender.Close()
+	}()
+
+	buf := make([]byte, 64)
+	oob := make([]byte, syscall.CmsgSpace(maxHandoffFDs*4))
+	for {
+		n, oobn, flags, _, err := sender.ReadMsgUnix(buf, oob)
+		if err != nil || (n == 0 && oobn == 0) {
+			return
+		}
+		if flags&syscall.MSG_CTRUNC != 0 {
+			l.logger.Warn("handoff message carried more descriptors than fit, the rest were closed",
+				"socket", l.unix.Addr().String(),
+				"max_descriptors", maxHandoffFDs,
+				"hint", fmt.Sprintf("pass at most %d connections per handoff message; the connections over that were lost", maxHandoffFDs))
+		}
+		if oobn == 0 {
+			continue
+		}
+
+		fds := receivedFDs(oob[:oobn])
+		for i, fd := range fds {
+			conn, err := fileConn(fd)
+			if err != nil {
+				continue
+			}
+			if !l.deliver(conn) {
+				for _, fd := range fds[i+1:] {
+					syscall.Close(fd)
+				}
+				return
+			}
+		}
+	}
+}
+
+// receivedFDs returns the descriptors passed in the control messages of a
+// handoff message.
+func receivedFDs(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	return fds
+}
+
+// fileConn turns a received descriptor into a net.Conn. The descriptor is
+// duplicated by net.FileConn, so the original is closed either way.
+func fileConn(fd int) (net.Conn, error) {
+	f := os.NewFile(uintptr(fd), "handoff")
+	defer f.Close()
+	return net.FileConn(f)
+}
+
+func (l *handoffListener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.closed:
+		conn.Close()
+		return false
+	}
+}
+
+func (l *handoffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *handoffListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		close(l.closed)
+		for sender := range l.senders {
+			sender.Close()
+		}
+		l.mu.Unlock()
+		err = l.unix.Close()
+	})
+	return err
+}
+
+func (l *handoffListener) Addr() net.Addr {
+	return l.unix.Addr()
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+func listenHandoff(path string, logger *slog.Logger) (net.Listener, error) {
+	return nil, errors.New("connection handoff requires Unix domain sockets")
+}
@@ -0,0 +1,233 @@
+//go:build unix
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// handOff accepts one connection on a fresh TCP listener, passes it to the
+// proxy over socket, and returns the client side.
+func handOff(t *testing.T, socket string) net.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer accepted.Close()
+
+	f, err := accepted.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatalf("failed to get fd: %v", err)
+	}
+	defer f.Close()
+
+	sender, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to connect to handoff socket: %v", err)
+	}
+	defer sender.Close()
+
+	rights := syscall.UnixRights(int(f.Fd()))
+	if _, _, err := sender.(*net.UnixConn).WriteMsgUnix([]byte{0}, rights, nil); err != nil {
+		t.Fatalf("failed to hand off connection: %v", err)
+	}
+	return client
+}
+
+func TestHandoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		dropRate float64
+		wantEcho bool
+	}{
+		{name: "forwards handed-off connection", dropRate: 0.0, wantEcho: true},
+		{name: "applies chaos to handed-off connection", dropRate: 1.0, wantEcho: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			socket := filepath.Join(t.TempDir(), "handoff.sock")
+			route := config.RouteConfig{
+				LocalPort:     findFreePort(t),
+				Upstream:      upstream.Addr().String(),
+				DropRate:      tt.dropRate,
+				HandoffSocket: socket,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ListenAndServeRoute(ctx, route)
+			time.Sleep(50 * time.Millisecond)
+
+			client := handOff(t, socket)
+			defer client.Close()
+			client.SetDeadline(time.Now().Add(2 * time.Second))
+
+			client.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			_, err := io.ReadFull(client, buf)
+			if tt.wantEcho {
+				if err != nil {
+					t.Fatalf("failed to read echo: %v", err)
+				}
+				if string(buf) != "ping" {
+					t.Errorf("got %q, want %q", buf, "ping")
+				}
+			} else if err == nil {
+				t.Error("expected the handed-off connection to be dropped")
+			}
+		})
+	}
+}
+
+func TestListenHandoff_StaleSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "handoff.sock")
+
+	// A socket file with nothing listening is left by an unclean exit.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listenHandoff(socket, slog.Default())
+	if err != nil {
+		t.Fatalf("listenHandoff() over stale socket: %v", err)
+	}
+
+	// A live socket is not stolen.
+	if _, err := listenHandoff(socket, slog.Default()); err == nil {
+		t.Error("listenHandoff() over a live socket succeeded, want error")
+	}
+	l.Close()
+
+	// Nor is a regular file.
+	file := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenHandoff(file, slog.Default()); err == nil {
+		t.Error("listenHandoff() over a regular file succeeded, want error")
+	}
+}
+
+// handOffMany passes n freshly accepted connections to the proxy over
+// socket in one message, and returns their client sides.
+func handOffMany(t *testing.T, socket string, n int) []net.Conn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	var clients []net.Conn
+	var fds []int
+	for range n {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		accepted, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("failed to accept: %v", err)
+		}
+		f, err := accepted.(*net.TCPConn).File()
+		accepted.Close()
+		if err != nil {
+			t.Fatalf("failed to get fd: %v", err)
+		}
+		defer f.Close()
+		clients = append(clients, client)
+		fds = append(fds, int(f.Fd()))
+	}
+
+	sender, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to connect to handoff socket: %v", err)
+	}
+	defer sender.Close()
+	if _, _, err := sender.(*net.UnixConn).WriteMsgUnix([]byte{0}, syscall.UnixRights(fds...), nil); err != nil {
+		t.Fatalf("failed to hand off connections: %v", err)
+	}
+	return clients
+}
+
+// closedByProxy reports whether the proxy let go of the connection of
+// client, so the client reads EOF.
+func closedByProxy(client net.Conn) bool {
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	return err != nil && !os.IsTimeout(err)
+}
+
+// TestHandoff_Leftovers tests that no handed-off connection is held open
+// when it can't be delivered: those over maxHandoffFDs in one message, and
+// those left in a message when the listener closes
+func TestHandoff_Leftovers(t *testing.T) {
+	t.Run("over the limit", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "handoff.sock")
+		l, err := listenHandoff(socket, slog.Default())
+		if err != nil {
+			t.Fatalf("listenHandoff() error = %v", err)
+		}
+		defer l.Close()
+
+		clients := handOffMany(t, socket, maxHandoffFDs+1)
+		for i := range maxHandoffFDs {
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatalf("Accept() %d error = %v", i, err)
+			}
+			defer conn.Close()
+		}
+		if !closedByProxy(clients[maxHandoffFDs]) {
+			t.Error("connection over maxHandoffFDs is still open")
+		}
+	})
+
+	t.Run("listener closed", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "handoff.sock")
+		l, err := listenHandoff(socket, slog.Default())
+		if err != nil {
+			t.Fatalf("listenHandoff() error = %v", err)
+		}
+
+		clients := handOffMany(t, socket, 3)
+		time.Sleep(50 * time.Millisecond)
+		l.Close()
+		for i, client := range clients {
+			if !closedByProxy(client) {
+				t.Errorf("connection %d still open after the listener closed", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// Hooks are callbacks on a route's connections, for a program embedding the
+// proxy to watch them and veto its chaos. Any may be nil. They are called
+// on the connection's own goroutines, so they must be safe for concurrent
+// use, and a slow one holds up the connection it was called for.
+type Hooks struct {
+	// OnAccept is called when a client connects, before any chaos. TCP
+	// routes only.
+	OnAccept func(ConnInfo)
+	// OnUpstreamConnect is called once a connection's upstream is dialled,
+	// with the upstream it reached. TCP routes only.
+	OnUpstreamConnect func(ConnInfo)
+	// OnDecision is called with every chaos decision before it is carried
+	// out. Returning false vetoes it: the fault is skipped, as in a dry
+	// run, and nothing is published for it. Dry runs don't call it.
+	OnDecision func(Decision) bool
+	// OnData is called with each chunk read from either side of a
+	// connection, before the route's chaos touches it. TCP routes only.
+	OnData func(Chunk)
+}
+
+// ConnInfo identifies a connection to a hook.
+type ConnInfo struct {
+	// Conn is the connection's ID, as in its events and ConnRecord.
+	Conn     uint64
+	Route    string
+	Client   string
+	Upstream string
+}
+
+// Decision is a chaos decision about to be carried out.
+type Decision struct {
+	ConnInfo
+	// Fault names the fault, as in fault events and ConnRecord.Chaos, such
+	// as "drop", "latency" or a toxic's name.
+	Fault string
+	// Message and Attrs are what the decision is logged with.
+	Message string
+	Attrs   []slog.Attr
+}
+
+// Chunk is bytes read from one side of a connection.
+type Chunk struct {
+	ConnInfo
+	// From is "client" or "upstream".
+	From string
+	// Data is only valid during the call, and must not be changed; copy it
+	// to keep it.
+	Data []byte
+}
+
+// connInfo describes the connection tracked for a hook.
+func connInfo(route config.RouteConfig, tracked *openConn) ConnInfo {
+	tracked.mu.Lock()
+	defer tracked.mu.Unlock()
+	return ConnInfo{Conn: tracked.id, Route: route.Name, Client: tracked.client, Upstream: tracked.upstream}
+}
+
+// decision builds the Decision for OnDecision from a decide call. The
+// connection is the one of the call's address attribute.
+func (o ServeOptions) decision(fault, msg string, args []any) Decision {
+	var r slog.Record
+	r.Add(args...)
+	d := Decision{Fault: fault, Message: msg}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "address":
+			d.Client = a.Value.String()
+		case "upstream":
+			d.Upstream = a.Value.String()
+		}
+		d.Attrs = append(d.Attrs, a)
+		return true
+	})
+	if o.Control != nil {
+		d.Route = o.Control.Route().Name
+		d.Conn = o.Control.connID(d.Client)
+	}
+	return d
+}
+
+// hookReader hands what is read through it to OnData.
+type hookReader struct {
+	r      io.Reader
+	info   ConnInfo
+	from   string
+	onData func(Chunk)
+}
+
+func (h *hookReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.onData(Chunk{ConnInfo: h.info, From: h.from, Data: p[:n]})
+	}
+	return n, err
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxHops bounds how many routes one connection may pass through in this
+// process. Config validation rejects literal loops; this catches the rest,
+// such as chains through auto-assigned ports.
+const maxHops = 16
+
+// hop places a connection on a chain of routes in this process. The zero
+// value is a connection from an outside client.
+type hop struct {
+	// ports lists the local ports of the earlier routes on the chain.
+	ports []int
+}
+
+// next returns the hop of a connection that the route on port dials.
+func (h hop) next(port int) hop {
+	return hop{ports: append(slices.Clone(h.ports), port)}
+}
+
+// number is the connection's position on the chain, from 1.
+func (h hop) number() int {
+	return len(h.ports) + 1
+}
+
+// chain renders the route ports the connection passed through, ending with
+// port, as "8080 -> 8081".
+func (h hop) chain(port int) string {
+	parts := make([]string, 0, len(h.ports)+1)
+	for _, p := range h.ports {
+		parts = append(parts, strconv.Itoa(p))
+	}
+	parts = append(parts, strconv.Itoa(port))
+	return strings.Join(parts, " -> ")
+}
+
+// hopRegistry remembers the local address of every upstream connection the
+// process dials, so a route whose upstream is another route can recognise
+// the connections that arrive from an earlier hop.
+type hopRegistry struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	// dialing counts dials in flight per upstream address.
+	dialing map[string]int
+	// hops maps a dialled connection's local address to its hop.
+	hops map[string]hop
+}
+
+var hops = newHopRegistry()
+
+func newHopRegistry() *hopRegistry {
+	r := &hopRegistry{
+		dialing: make(map[string]int),
+		hops:    make(map[string]hop),
+	}
+	r.changed = sync.NewCond(&r.mu)
+	return r
+}
+
+// dial dials upstream and registers the connection as hop h. release must
+// be called once the connection is done.
+func (r *hopRegistry) dial(upstream string, h hop, dial func() (net.Conn, error)) (conn net.Conn, release func(), err error) {
+	r.mu.Lock()
+	r.dialing[upstream]++
+	r.mu.Unlock()
+
+	conn, err = dial()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dialing[upstream]--; r.dialing[upstream] == 0 {
+		delete(r.dialing, upstream)
+	}
+	r.changed.Broadcast()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only TCP connections can arrive at another route; those of a custom
+	// dialer, such as pipes, may share a local address.
+	if _, ok := conn.LocalAddr().(*net.TCPAddr); !ok {
+		return conn, func() {}, nil
+	}
+	local := conn.LocalAddr().String()
+	r.hops[local] = h
+	return conn, func() {
+		r.mu.Lock()
+		delete(r.hops, local)
+		r.mu.Unlock()
+	}, nil
+}
+
+// lookup returns the hop of a connection from remote accepted on listenAddr.
+// While this process is still dialling listenAddr, the connection may be
+// one of those dials, so lookup waits for them to register.
+func (r *hopRegistry) lookup(listenAddr, remote string) hop {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if h, ok := r.hops[remote]; ok {
+			return h
+		}
+		if r.dialing[listenAddr] == 0 {
+			return hop{}
+		}
+		r.changed.Wait()
+	}
+}
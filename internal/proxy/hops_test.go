@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestHopRegistry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	registry := newHopRegistry()
+	conn, release, err := registry.dial(addr, hop{}.next(8080), func() (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	})
+	if err != nil {
+		t.Fatalf("dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer accepted.Close()
+
+	h := registry.lookup(addr, accepted.RemoteAddr().String())
+	if h.number() != 2 || h.chain(8081) != "8080 -> 8081" {
+		t.Errorf("lookup() = hop %d (%s), want hop 2 (8080 -> 8081)", h.number(), h.chain(8081))
+	}
+
+	release()
+	if h := registry.lookup(addr, accepted.RemoteAddr().String()); h.number() != 1 {
+		t.Errorf("lookup() after release = hop %d, want 1", h.number())
+	}
+}
+
+func TestChainedRoutes(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	second := config.RouteConfig{LocalPort: findFreePort(t), Upstream: upstream.Addr().String()}
+	first := config.RouteConfig{LocalPort: findFreePort(t), Upstream: fmt.Sprintf("127.0.0.1:%d", second.LocalPort)}
+	secondStats := &RouteStats{}
+	go ServeRoute(ctx, second, ServeOptions{Stats: secondStats})
+	go ListenAndServeRoute(ctx, first)
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", first.LocalPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read echo through both routes: %v", err)
+	}
+	if got := secondStats.Snapshot().Connections; got != 1 {
+		t.Errorf("second route connections = %d, want 1", got)
+	}
+}
+
+func TestChainLoopIsCut(t *testing.T) {
+	port := findFreePort(t)
+	route := config.RouteConfig{LocalPort: port, Upstream: fmt.Sprintf("127.0.0.1:%d", port)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stats := &RouteStats{}
+	go ServeRoute(ctx, route, ServeOptions{Stats: stats})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := client.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("Read() error = %v, want the looping connection closed", err)
+	}
+	if got := stats.Snapshot().Connections; got != maxHops+1 {
+		t.Errorf("connections = %d, want %d", got, maxHops+1)
+	}
+	// Only the connection over the limit is cut for it; the others close
+	// as it does.
+	deadline := time.Now().Add(2 * time.Second)
+	for stats.Snapshot().Closes[CloseHopLimit] == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := stats.Snapshot().Closes[CloseHopLimit]; got != 1 {
+		t.Errorf("%s closes = %d, want 1", CloseHopLimit, got)
+	}
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
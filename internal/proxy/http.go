@@ -0,0 +1,358 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/script"
+)
+
+// httpForwarder relays HTTP/1.x requests from a client over its upstream
+// connection one at a time, so drops, delays and errors are rolled per
+// request instead of once per connection.
+type httpForwarder struct {
+	route      config.RouteConfig
+	ritual     chaos.Ritual
+	match      *requestMatch
+	script     *script.Script
+	clientAddr string
+	logger     *slog.Logger
+	opts       ServeOptions
+
+	client, server         net.Conn
+	fromClient, fromServer *bufio.Reader
+	toClient, toServer     *countingWriter
+}
+
+// serve forwards requests until either side closes, a request is dropped,
+// or the connection is upgraded to another protocol and tunnelled. It
+// returns nil when the client closed the connection cleanly.
+func (f *httpForwarder) serve() error {
+	for {
+		req, err := http.ReadRequest(f.fromClient)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			if !isConnError(err) {
+				f.logger.Error("malformed HTTP request, closing connection",
+					"address", f.clientAddr,
+					"error", err,
+					"hint", fmt.Sprintf("route is in %q mode: send it HTTP/1.x only, or remove mode to proxy raw TCP", config.ModeHTTP))
+			}
+			return err
+		}
+
+		if err := f.forward(req); err != nil {
+			return err
+		}
+	}
+}
+
+// errConnectionDone stops the request loop without an error of its own:
+// the connection was dropped, tunnelled or asked to close.
+var errConnectionDone = errors.New("http: connection done")
+
+// forward rolls req's chaos, if it matches the route's http.match, and
+// relays it and its response.
+func (f *httpForwarder) forward(req *http.Request) error {
+	route, opts := f.route, f.opts
+	target := req.Method + " " + req.URL.RequestURI()
+	attrs := []any{"address", f.clientAddr, "upstream", route.Upstream, "method", req.Method, "path", req.URL.Path}
+
+	// Unmatched requests skip the rolls entirely, so they don't advance
+	// loss models either.
+	chaotic := f.match.matches(req)
+	var curse chaos.Curse
+	if chaotic {
+		curse = newCurse(route, f.ritual, f.clientAddr)
+	} else {
+		f.logger.Debug("request doesn't match http.match, forwarding it clean", attrs...)
+	}
+	if chaotic && f.script != nil {
+		if answered, err := f.scriptRequest(req, attrs); answered {
+			return err
+		}
+	}
+	if curse.DropConnections && opts.decide(f.logger, "drop", "dropping request", attrs...) {
+		opts.Stats.recordFailure()
+		opts.publishFault(route, f.clientAddr, "drop", target)
+		return errConnectionDone
+	}
+
+	if chaotic && f.ritual.RequestFails() {
+		status := route.HTTP.ErrorStatusOrDefault()
+		if opts.decide(f.logger, "http_error", "answering request with an error", append(attrs, "status", status)...) {
+			opts.publishFault(route, f.clientAddr, "http_error", strconv.Itoa(status))
+			return f.writeError(req, status)
+		}
+	}
+
+	if curse.StartDelay > 0 && opts.decide(f.logger, "latency", "delaying request", append(attrs, "delay", curse.StartDelay)...) {
+		opts.publishDelay(route, f.clientAddr, "latency", curse.StartDelay)
+		time.Sleep(curse.StartDelay)
+	}
+
+	if chaotic {
+		f.rewriteHeaders(config.HeaderInRequest, req.Header, attrs)
+	}
+
+	// The body is sent while the response is read, so a client waiting
+	// for 100 Continue gets it before it sends the body.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Stop Request.Write from adding Go's default User-Agent.
+		req.Header["User-Agent"] = []string{""}
+	}
+	written := make(chan error, 1)
+	go func() {
+		err := req.Write(f.toServer)
+		if err == nil {
+			err = chaos.Flush(f.toServer)
+		}
+		written <- err
+	}()
+
+	resp, err := f.readResponse(req)
+	if err != nil {
+		if !isConnError(err) {
+			f.logger.Error("malformed HTTP response from upstream, closing connection",
+				"address", f.clientAddr,
+				"upstream", route.Upstream,
+				"error", err,
+				"hint", fmt.Sprintf("route is in %q mode: its upstream must speak HTTP/1.x", config.ModeHTTP))
+		}
+		return err
+	}
+
+	hadLength := resp.Header.Get("Content-Length") != ""
+	if chaotic {
+		f.rewriteHeaders(config.HeaderInResponse, resp.Header, attrs)
+		f.skewDates(resp.Header, attrs)
+	}
+	if hadLength && resp.Header.Get("Content-Length") == "" {
+		// Without a length, the body ends when the connection closes.
+		resp.ContentLength = -1
+		resp.Close = true
+	}
+	if chaotic {
+		if err := f.damageBody(req, resp, attrs); err != nil {
+			return err
+		}
+	}
+
+	if err := resp.Write(f.toClient); err != nil {
+		if errors.Is(err, errBodyCut) {
+			// Send what was written before the cut, then close.
+			chaos.Flush(f.toClient)
+			return errConnectionDone
+		}
+		return err
+	}
+	if err := chaos.Flush(f.toClient); err != nil {
+		return err
+	}
+	if err := <-written; err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusSwitchingProtocols || (req.Method == http.MethodConnect && resp.StatusCode/100 == 2) {
+		f.logger.Debug("tunnelling upgraded HTTP connection", "address", f.clientAddr, "upstream", route.Upstream, "status", resp.StatusCode)
+		f.tunnel()
+		return errConnectionDone
+	}
+	if req.Close || resp.Close {
+		return errConnectionDone
+	}
+	return nil
+}
+
+// rewriteHeaders rolls each of the route's header rules for messages of
+// kind in and applies those that fire to header.
+func (f *httpForwarder) rewriteHeaders(in string, header http.Header, attrs []any) {
+	if f.route.HTTP == nil {
+		return
+	}
+	for _, rule := range f.route.HTTP.Headers {
+		if rule.In != in || f.ritual.Rand().Float64() >= rule.RateOrDefault() {
+			continue
+		}
+		name := http.CanonicalHeaderKey(rule.Name)
+		if (rule.Action == config.HeaderRemove || rule.Action == config.HeaderCorrupt) && len(header[name]) == 0 {
+			continue
+		}
+		if !f.opts.decide(f.logger, "header", "rewriting header", append(attrs, "in", in, "action", rule.Action, "header", name)...) {
+			continue
+		}
+
+		switch rule.Action {
+		case config.HeaderAdd:
+			header.Add(name, rule.Value)
+		case config.HeaderSet:
+			header.Set(name, rule.Value)
+		case config.HeaderRemove:
+			header.Del(name)
+		case config.HeaderCorrupt:
+			for i, value := range header[name] {
+				header[name][i] = corruptValue(f.ritual.Rand(), value)
+			}
+		}
+		f.opts.publishFault(f.route, f.clientAddr, "header", rule.Action+" "+in+" "+name)
+	}
+}
+
+// skewedHeaders are the response headers whose HTTP-dates
+// http.clockSkewMs moves.
+var skewedHeaders = []string{"Date", "Expires", "Last-Modified"}
+
+// skewDates moves the dates of header by the route's http.clockSkewMs,
+// keeping the IMF-fixdate format. Values that aren't HTTP-dates, such as
+// "Expires: 0", are left alone.
+func (f *httpForwarder) skewDates(header http.Header, attrs []any) {
+	if f.route.HTTP == nil || f.route.HTTP.ClockSkewMs == 0 {
+		return
+	}
+	skew := time.Duration(f.route.HTTP.ClockSkewMs) * time.Millisecond
+	skewed := make(map[string][]string)
+	for _, name := range skewedHeaders {
+		for i, value := range header[name] {
+			t, err := http.ParseTime(value)
+			if err != nil {
+				continue
+			}
+			if skewed[name] == nil {
+				skewed[name] = slices.Clone(header[name])
+			}
+			skewed[name][i] = t.Add(skew).UTC().Format(http.TimeFormat)
+		}
+	}
+	if len(skewed) == 0 || !f.opts.decide(f.logger, "clock_skew", "skewing response dates", append(attrs, "skew", skew)...) {
+		return
+	}
+	maps.Copy(header, skewed)
+	f.opts.publishFault(f.route, f.clientAddr, "clock_skew", skew.String())
+}
+
+// corruptValue swaps one character of value, picked with rng, for a
+// different printable one, so the header is wrong but still well-formed.
+func corruptValue(rng *chaos.Rand, value string) string {
+	if value == "" {
+		return "!"
+	}
+	b := []byte(value)
+	i := rng.Intn(len(b))
+	c := b[i]
+	for c == b[i] {
+		c = byte('!' + rng.Intn('~'-'!'+1))
+	}
+	b[i] = c
+	return string(b)
+}
+
+// readResponse reads the response to req, passing interim 1xx responses
+// such as 100 Continue straight on to the client.
+func (f *httpForwarder) readResponse(req *http.Request) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(f.fromServer, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		if err := resp.Write(f.toClient); err != nil {
+			return nil, err
+		}
+		if err := chaos.Flush(f.toClient); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeError answers req with status instead of forwarding it. The request
+// body is read first so the connection can carry on.
+func (f *httpForwarder) writeError(req *http.Request, status int) error {
+	if _, err := io.Copy(io.Discard, req.Body); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("chaos-proxy: injected %d %s\n", status, http.StatusText(status))
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         req.Close,
+		Request:       req,
+	}
+	if err := resp.Write(f.toClient); err != nil {
+		return err
+	}
+	if err := chaos.Flush(f.toClient); err != nil {
+		return err
+	}
+	if req.Close {
+		return errConnectionDone
+	}
+	return nil
+}
+
+// tunnel copies raw bytes both ways after an upgrade, until either side
+// closes.
+func (f *httpForwarder) tunnel() {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(f.toServer, f.fromClient)
+		chaos.Flush(f.toServer)
+		f.client.Close()
+		f.server.Close()
+		close(done)
+	}()
+	io.Copy(f.toClient, f.fromServer)
+	chaos.Flush(f.toClient)
+	f.client.Close()
+	f.server.Close()
+	<-done
+}
+
+// isConnError reports whether err comes from the connection going away
+// (closed, reset, timed out or severed by a toxic) rather than from
+// malformed HTTP.
+func isConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, chaos.ErrSevered)
+}
+
+// countingWriter counts the bytes written through it. It may be read while
+// another goroutine writes.
+type countingWriter struct {
+	dst io.Writer
+	n   atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.dst.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) Flush() error {
+	return chaos.Flush(w.dst)
+}
@@ -0,0 +1,445 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// startHTTPMode serves route in http mode in front of handler and returns
+// the proxy's URL and a count of upstream connections.
+func startHTTPMode(t *testing.T, route config.RouteConfig, handler http.Handler) (string, *atomic.Int64) {
+	t.Helper()
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(handler)
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	t.Cleanup(upstream.Close)
+
+	route.Mode = config.ModeHTTP
+	route.LocalPort = findFreePort(t)
+	route.Upstream = upstream.Listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go ServeRoute(ctx, route, ServeOptions{})
+	time.Sleep(50 * time.Millisecond)
+
+	return fmt.Sprintf("http://127.0.0.1:%d", route.LocalPort), &conns
+}
+
+// keepAliveClient sends every request over one connection, and never
+// retries, so each request's outcome is visible.
+func keepAliveClient(t *testing.T) *http.Client {
+	transport := &http.Transport{MaxConnsPerHost: 1, MaxIdleConnsPerHost: 1}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 2 * time.Second}
+}
+
+func echoPath(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	fmt.Fprint(w, r.URL.Path)
+}
+
+// TestHTTPMode_KeepAlive tests that clean requests share one upstream
+// connection, as they would without the proxy
+func TestHTTPMode_KeepAlive(t *testing.T) {
+	url, upstreamConns := startHTTPMode(t, config.RouteConfig{}, http.HandlerFunc(echoPath))
+	client := keepAliveClient(t)
+
+	for i := range 10 {
+		path := fmt.Sprintf("/item/%d", i)
+		resp, err := client.Post(url+path, "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != path {
+			t.Errorf("request %d = %d %q, want 200 %q", i, resp.StatusCode, body, path)
+		}
+	}
+	if got := upstreamConns.Load(); got != 1 {
+		t.Errorf("upstream connections = %d, want 1", got)
+	}
+}
+
+// TestHTTPMode_Chaos tests that drops, errors and latency are rolled per
+// request on a kept-alive connection
+func TestHTTPMode_Chaos(t *testing.T) {
+	tests := []struct {
+		name  string
+		route config.RouteConfig
+		check func(t *testing.T, statuses map[int]int, failed int, elapsed time.Duration)
+	}{
+		{
+			name:  "errors mixed into one connection",
+			route: config.RouteConfig{HTTP: &config.HTTPConfig{ErrorRate: 0.5, ErrorStatus: http.StatusTeapot}},
+			check: func(t *testing.T, statuses map[int]int, failed int, _ time.Duration) {
+				if statuses[http.StatusTeapot] == 0 || statuses[http.StatusOK] == 0 || failed > 0 {
+					t.Errorf("statuses = %v, failed = %d, want a mix of 418 and 200 and no failures", statuses, failed)
+				}
+			},
+		},
+		{
+			name:  "every request dropped",
+			route: config.RouteConfig{DropRate: 1},
+			check: func(t *testing.T, statuses map[int]int, failed int, _ time.Duration) {
+				if len(statuses) > 0 {
+					t.Errorf("statuses = %v, want every request dropped", statuses)
+				}
+			},
+		},
+		{
+			name:  "latency per request",
+			route: config.RouteConfig{LatencyMs: 30},
+			check: func(t *testing.T, statuses map[int]int, _ int, elapsed time.Duration) {
+				if statuses[http.StatusOK] != 20 || elapsed < 20*30*time.Millisecond {
+					t.Errorf("statuses = %v in %v, want 20 OK taking at least %v", statuses, elapsed, 20*30*time.Millisecond)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := startHTTPMode(t, tt.route, http.HandlerFunc(echoPath))
+			client := keepAliveClient(t)
+
+			statuses := make(map[int]int)
+			failed := 0
+			start := time.Now()
+			for range 20 {
+				resp, err := client.Post(url+"/checkout", "text/plain", strings.NewReader("order"))
+				if err != nil {
+					failed++
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				statuses[resp.StatusCode]++
+			}
+			tt.check(t, statuses, failed, time.Since(start))
+		})
+	}
+}
+
+// TestHTTPMode_Upgrade tests that an upgraded connection is tunnelled as
+// raw bytes after the 101 response
+func TestHTTPMode_Upgrade(t *testing.T) {
+	url, _ := startHTTPMode(t, config.RouteConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	fmt.Fprint(conn, "GET /echo HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	fmt.Fprint(conn, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("failed to read through tunnel: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("tunnel echoed %q, want %q", buf, "ping")
+	}
+}
+
+// TestHTTPMode_Headers tests that header rules rewrite requests on their way
+// upstream and responses on their way back
+func TestHTTPMode_Headers(t *testing.T) {
+	never := 0.0
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc123"`)
+		fmt.Fprintf(w, "auth=%s test=%s", r.Header.Get("Authorization"), r.Header.Get("X-Test"))
+	})
+
+	tests := []struct {
+		name  string
+		rule  config.HeaderRule
+		check func(t *testing.T, resp *http.Response, body string)
+	}{
+		{
+			name: "strip Authorization",
+			rule: config.HeaderRule{In: config.HeaderInRequest, Action: config.HeaderRemove, Name: "authorization"},
+			check: func(t *testing.T, _ *http.Response, body string) {
+				if body != "auth= test=" {
+					t.Errorf("upstream saw %q, want no Authorization", body)
+				}
+			},
+		},
+		{
+			name: "set request header",
+			rule: config.HeaderRule{In: config.HeaderInRequest, Action: config.HeaderSet, Name: "X-Test", Value: "chaos"},
+			check: func(t *testing.T, _ *http.Response, body string) {
+				if body != "auth=Bearer secret test=chaos" {
+					t.Errorf("upstream saw %q, want X-Test set", body)
+				}
+			},
+		},
+		{
+			name: "corrupt ETag",
+			rule: config.HeaderRule{In: config.HeaderInResponse, Action: config.HeaderCorrupt, Name: "ETag"},
+			check: func(t *testing.T, resp *http.Response, _ string) {
+				if etag := resp.Header.Get("ETag"); etag == `"abc123"` || len(etag) != len(`"abc123"`) {
+					t.Errorf("ETag = %q, want a same-length corruption of %q", etag, `"abc123"`)
+				}
+			},
+		},
+		{
+			name: "drop Content-Length",
+			rule: config.HeaderRule{In: config.HeaderInResponse, Action: config.HeaderRemove, Name: "Content-Length"},
+			check: func(t *testing.T, resp *http.Response, body string) {
+				if resp.ContentLength != -1 || body != "auth=Bearer secret test=" {
+					t.Errorf("ContentLength = %d, body %q, want an unframed but complete body", resp.ContentLength, body)
+				}
+			},
+		},
+		{
+			name: "rate zero never fires",
+			rule: config.HeaderRule{In: config.HeaderInResponse, Action: config.HeaderRemove, Name: "ETag", Rate: &never},
+			check: func(t *testing.T, resp *http.Response, _ string) {
+				if resp.Header.Get("ETag") != `"abc123"` {
+					t.Errorf("ETag = %q, want it untouched", resp.Header.Get("ETag"))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{HTTP: &config.HTTPConfig{Headers: []config.HeaderRule{tt.rule}}}
+			url, _ := startHTTPMode(t, route, upstream)
+			client := keepAliveClient(t)
+
+			// Twice, so a rewrite that breaks framing shows up on the
+			// second request.
+			for range 2 {
+				req, _ := http.NewRequest(http.MethodGet, url+"/", nil)
+				req.Header.Set("Authorization", "Bearer secret")
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+				tt.check(t, resp, string(body))
+			}
+		})
+	}
+}
+
+// TestHTTPMode_ClockSkew tests that http.clockSkewMs moves the dates of
+// responses, in IMF-fixdate form, and leaves values that aren't dates
+// alone.
+func TestHTTPMode_ClockSkew(t *testing.T) {
+	date := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+		// RFC 850 form, which is still to be accepted.
+		w.Header().Set("Last-Modified", date.Add(-time.Hour).Format(time.RFC850))
+		w.Header().Set("Expires", "0")
+		w.Header().Set("X-Date", date.Format(http.TimeFormat))
+	})
+
+	tests := []struct {
+		name string
+		skew config.Milliseconds
+		want time.Duration
+	}{
+		{"ahead", 90 * 60 * 1000, 90 * time.Minute},
+		{"behind", -30 * 1000, -30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := startHTTPMode(t, config.RouteConfig{HTTP: &config.HTTPConfig{ClockSkewMs: tt.skew}}, upstream)
+			resp, err := http.Get(url + "/")
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			want := map[string]string{
+				"Date":          date.Add(tt.want).Format(http.TimeFormat),
+				"Last-Modified": date.Add(-time.Hour + tt.want).Format(http.TimeFormat),
+				"Expires":       "0",
+				"X-Date":        date.Format(http.TimeFormat),
+			}
+			for name, value := range want {
+				if got := resp.Header.Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+// TestHTTPMode_Match tests that chaos only hits requests matching
+// http.match, while the rest of a kept-alive connection stays clean
+func TestHTTPMode_Match(t *testing.T) {
+	route := config.RouteConfig{HTTP: &config.HTTPConfig{
+		ErrorRate: 1,
+		Match: &config.HTTPMatch{
+			Methods:    []string{http.MethodPost},
+			PathPrefix: "/checkout",
+			Headers:    map[string]string{"X-Tenant": "acme"},
+		},
+	}}
+	url, _ := startHTTPMode(t, route, http.HandlerFunc(echoPath))
+	client := keepAliveClient(t)
+
+	tests := []struct {
+		method, path, tenant string
+		wantChaos            bool
+	}{
+		{http.MethodPost, "/checkout", "acme", true},
+		{http.MethodPost, "/checkout/42", "acme", true},
+		{http.MethodGet, "/checkout", "acme", false},
+		{http.MethodPost, "/health", "acme", false},
+		{http.MethodPost, "/checkout", "other", false},
+		{http.MethodPost, "/checkout", "", false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, url+tt.path, strings.NewReader("order"))
+		if tt.tenant != "" {
+			req.Header.Set("X-Tenant", tt.tenant)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s (tenant %q) failed: %v", tt.method, tt.path, tt.tenant, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		gotChaos := resp.StatusCode == config.DefaultHTTPErrorStatus
+		if gotChaos != tt.wantChaos {
+			t.Errorf("%s %s (tenant %q) = %d, want chaos %v", tt.method, tt.path, tt.tenant, resp.StatusCode, tt.wantChaos)
+		}
+	}
+}
+
+// TestHTTPMode_Body tests that response bodies are cut short or corrupted
+// behind intact headers
+func TestHTTPMode_Body(t *testing.T) {
+	const payload = `{"order": 42, "items": ["book", "lamp"], "total": 31.5}`
+	respond := func(contentType string, chunked bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			if !chunked {
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			}
+			io.WriteString(w, payload)
+			if chunked {
+				http.NewResponseController(w).Flush()
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		body    config.HTTPBodyConfig
+		handler http.Handler
+		check   func(t *testing.T, resp *http.Response, body []byte, readErr error)
+	}{
+		{
+			name:    "truncate against Content-Length",
+			body:    config.HTTPBodyConfig{TruncateRate: 1},
+			handler: respond("application/json", false),
+			check: func(t *testing.T, resp *http.Response, body []byte, readErr error) {
+				if resp.ContentLength != int64(len(payload)) || readErr == nil || len(body) >= len(payload) {
+					t.Errorf("ContentLength = %d, read %d bytes with error %v, want %d declared and a short read", resp.ContentLength, len(body), readErr, len(payload))
+				}
+			},
+		},
+		{
+			name:    "truncate chunked body",
+			body:    config.HTTPBodyConfig{TruncateRate: 1},
+			handler: respond("text/plain", true),
+			check: func(t *testing.T, _ *http.Response, body []byte, readErr error) {
+				if readErr == nil || len(body) >= len(payload) {
+					t.Errorf("read %d bytes with error %v, want a short read", len(body), readErr)
+				}
+			},
+		},
+		{
+			name:    "corrupt JSON",
+			body:    config.HTTPBodyConfig{CorruptRate: 1},
+			handler: respond("application/json; charset=utf-8", true),
+			check: func(t *testing.T, _ *http.Response, body []byte, readErr error) {
+				if readErr != nil || len(body) != len(payload) || json.Valid(body) {
+					t.Errorf("read %q with error %v, want a complete body that isn't valid JSON", body, readErr)
+				}
+			},
+		},
+		{
+			name:    "leave other content types alone",
+			body:    config.HTTPBodyConfig{CorruptRate: 1},
+			handler: respond("text/plain", false),
+			check: func(t *testing.T, _ *http.Response, body []byte, readErr error) {
+				if readErr != nil || string(body) != payload {
+					t.Errorf("read %q with error %v, want it untouched", body, readErr)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{HTTP: &config.HTTPConfig{Body: &tt.body}}
+			url, _ := startHTTPMode(t, route, tt.handler)
+			client := keepAliveClient(t)
+
+			for range 5 {
+				resp, err := client.Get(url + "/order")
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				body, readErr := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status = %d, want 200", resp.StatusCode)
+				}
+				tt.check(t, resp, body, readErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxBodyScan is how much of a response body without a Content-Length is
+// read ahead to pick a point to damage. Longer bodies are damaged within
+// their first maxBodyScan bytes.
+const maxBodyScan = 64 << 10
+
+// errBodyCut ends a response body that is being truncated.
+var errBodyCut = errors.New("http: response body truncated by chaos")
+
+// corruptByte is what a corrupted body byte becomes. JSON allows no raw
+// control characters, inside strings or out, so a NUL never parses.
+const corruptByte = 0x00
+
+// damageBody rolls the route's body chaos for resp and, when it fires,
+// replaces resp.Body with one that is cut short or corrupted.
+func (f *httpForwarder) damageBody(req *http.Request, resp *http.Response, attrs []any) error {
+	if f.route.HTTP == nil || f.route.HTTP.Body == nil || req.Method == http.MethodHead || resp.Body == http.NoBody {
+		return nil
+	}
+	cfg := f.route.HTTP.Body
+	truncate := f.ritual.Rand().Float64() < cfg.TruncateRate
+	corrupt := !truncate && isJSON(resp.Header.Get("Content-Type")) && f.ritual.Rand().Float64() < cfg.CorruptRate
+	if !truncate && !corrupt {
+		return nil
+	}
+
+	size, err := sizeBody(resp)
+	if err != nil || size == 0 {
+		return err
+	}
+	at := f.ritual.Rand().Int63n(size)
+
+	if truncate {
+		if f.opts.decide(f.logger, "http_truncate", "truncating response body", append(attrs, "status", resp.StatusCode, "at", at, "length", size)...) {
+			f.opts.publishFault(f.route, f.clientAddr, "http_truncate", fmt.Sprintf("%d of %d bytes", at, size))
+			resp.Body = &cutBody{ReadCloser: resp.Body, remaining: at}
+		}
+		return nil
+	}
+	if f.opts.decide(f.logger, "http_corrupt", "corrupting response body", append(attrs, "status", resp.StatusCode, "at", at, "length", size)...) {
+		f.opts.publishFault(f.route, f.clientAddr, "http_corrupt", fmt.Sprintf("byte %d of %d", at, size))
+		resp.Body = &corruptBody{ReadCloser: resp.Body, at: at}
+	}
+	return nil
+}
+
+// sizeBody returns the length of resp's body. When the response doesn't
+// declare one, it reads up to maxBodyScan bytes ahead to find out, and
+// puts them back in front of the rest of the body.
+func sizeBody(resp *http.Response) (int64, error) {
+	if resp.ContentLength >= 0 {
+		return resp.ContentLength, nil
+	}
+
+	buf := make([]byte, maxBodyScan)
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf[:n]), resp.Body), resp.Body}
+	return int64(n), nil
+}
+
+// isJSON reports whether contentType is application/json or a +json type
+// such as application/problem+json.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// cutBody passes on the first remaining bytes of a body, then fails with
+// errBodyCut.
+type cutBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *cutBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, errBodyCut
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// corruptBody replaces the byte at offset at of a body with corruptByte.
+type corruptBody struct {
+	io.ReadCloser
+	at, offset int64
+}
+
+func (b *corruptBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if i := b.at - b.offset; i >= 0 && i < int64(n) {
+		p[i] = corruptByte
+	}
+	b.offset += int64(n)
+	return n, err
+}
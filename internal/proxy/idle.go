@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+)
+
+// idleKiller blackholes a connection once neither side has sent anything
+// for timeout, like a middlebox silently forgetting it. Nothing is sent at
+// that point; the next bytes from either side are answered with a reset
+// instead of being forwarded. A connection nobody sends on again is reset
+// after release, so it doesn't hold its sockets for good.
+type idleKiller struct {
+	timeout time.Duration
+	release time.Duration
+	conns   [2]net.Conn
+	// onIdle reports whether to go ahead and blackhole the connection.
+	onIdle func() bool
+	// onReset is told whether the reset is on new traffic or the release.
+	onReset func(released bool)
+
+	lastActive atomic.Int64 // unix nanoseconds
+	dead       atomic.Bool
+	resetOnce  sync.Once
+	timer      *time.Timer
+}
+
+func newIdleKiller(timeout, release time.Duration, client, server net.Conn, onIdle func() bool, onReset func(released bool)) *idleKiller {
+	k := &idleKiller{
+		timeout: timeout,
+		release: release,
+		conns:   [2]net.Conn{client, server},
+		onIdle:  onIdle,
+		onReset: onReset,
+	}
+	k.lastActive.Store(time.Now().UnixNano())
+	k.timer = time.AfterFunc(timeout, k.check)
+	return k
+}
+
+// check kills the connection if it has been idle long enough, and otherwise
+// sleeps until it could next reach the timeout. Once the timeout is reached
+// the killer stops watching, whether or not onIdle let it kill, but for the
+// release of a killed connection.
+func (k *idleKiller) check() {
+	if k.dead.Load() {
+		k.reset(true)
+		return
+	}
+	idle := time.Since(time.Unix(0, k.lastActive.Load()))
+	if idle < k.timeout {
+		k.timer.Reset(k.timeout - idle)
+		return
+	}
+	if k.onIdle() {
+		k.dead.Store(true)
+		k.timer.Reset(k.release)
+	}
+}
+
+// reset ends a killed connection with a reset to both sides, once.
+func (k *idleKiller) reset(released bool) {
+	k.resetOnce.Do(func() {
+		k.onReset(released)
+		for _, c := range k.conns {
+			reset(c)
+		}
+	})
+}
+
+func (k *idleKiller) stop() {
+	k.timer.Stop()
+}
+
+// reader wraps one side's reads so they count as activity, or trigger the
+// reset once the connection is dead.
+func (k *idleKiller) reader(r io.Reader) io.Reader {
+	return &idleReader{Reader: r, killer: k}
+}
+
+type idleReader struct {
+	io.Reader
+	killer *idleKiller
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	k := r.killer
+	if k.dead.Load() {
+		k.reset(false)
+		return 0, chaos.ErrSevered
+	}
+	k.lastActive.Store(time.Now().UnixNano())
+	return n, err
+}
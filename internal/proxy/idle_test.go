@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// TestIdleTimeout tests that idle connections are dropped silently and reset
+// when traffic resumes, while active connections survive
+func TestIdleTimeout(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:     proxyPort,
+		Upstream:      upstream.Addr().String(),
+		IdleTimeoutMs: 100,
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	dial := func() net.Conn {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	ping := func(client net.Conn) error {
+		if _, err := client.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		client.SetReadDeadline(time.Now().Add(1 * time.Second))
+		_, err := io.ReadFull(client, buf)
+		return err
+	}
+
+	t.Run("active connection survives", func(t *testing.T) {
+		client := dial()
+		for i := 0; i < 6; i++ {
+			if err := ping(client); err != nil {
+				t.Fatalf("ping %d failed on an active connection: %v", i, err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	t.Run("idle connection is dropped silently", func(t *testing.T) {
+		client := dial()
+		if err := ping(client); err != nil {
+			t.Fatalf("first ping failed: %v", err)
+		}
+
+		// Nothing arrives when the timeout passes: the drop is silent.
+		client.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("read during idle drop = %v, want a deadline timeout", err)
+		}
+
+		// The next request is answered with a reset instead of an echo.
+		if err := ping(client); err == nil {
+			t.Fatal("ping after idle timeout succeeded, want the connection reset")
+		}
+	})
+}
+
+// TestIdleTimeout_Release tests that an idle-dropped connection neither side
+// sends on again is released after idleReleaseMs instead of held for good
+func TestIdleTimeout_Release(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{
+		LocalPort:     findFreePort(t),
+		Upstream:      upstream.Addr().String(),
+		IdleTimeoutMs: 100,
+		IdleReleaseMs: 200,
+	}
+	control := NewRouteControl(route)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{Control: control, OnListen: func(addr net.Addr) { bound <- addr }})
+
+	client, err := net.Dial("tcp", (<-bound).String())
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(1 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("failed to read the echo: %v", err)
+	}
+
+	// Blackholed, and so silent, until the release.
+	start := time.Now()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("idle-dropped connection was never released")
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("connection released after %v, want after idleTimeoutMs and idleReleaseMs", elapsed)
+	}
+
+	deadline := time.Now().Add(1 * time.Second)
+	for control.Traffic().Open != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections still open after the release, want 0", control.Traffic().Open)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// connLimit holds the slots of a route's maxConnections, and paces its
+// connections to maxConnectionsPerSecond. A route's servers share one, so
+// the connections of a retired server still hold theirs. Every connection a
+// server handles holds a slot, given back when it finishes; with no limit,
+// taking one always succeeds.
+type connLimit struct {
+	mu      sync.Mutex
+	serving int
+	// max is the limit last taken against, so that slots given back go to
+	// queued connections only while the route is under it.
+	max int
+	// waiting are the connections queued for a slot, first come first.
+	waiting []chan struct{}
+
+	// tokens is a bucket of the connections the route may take now,
+	// refilled at the rate per second up to a second's worth; last is when
+	// it was refilled. It goes below zero for connections waiting on it.
+	tokens float64
+	last   time.Time
+
+	stopped chan struct{}
+	stop    func()
+}
+
+func newConnLimit() *connLimit {
+	l := &connLimit{stopped: make(chan struct{})}
+	l.stop = sync.OnceFunc(func() { close(l.stopped) })
+	return l
+}
+
+// take takes a slot if fewer than max connections hold one and none are
+// queued for one. max 0 means no limit.
+func (l *connLimit) take(max int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.takeLocked(max)
+}
+
+func (l *connLimit) takeLocked(max int) bool {
+	l.max = max
+	if max > 0 && (l.serving >= max || len(l.waiting) > 0) {
+		return false
+	}
+	l.serving++
+	return true
+}
+
+// wait takes a slot, queueing for one behind the connections already
+// waiting if none is free. It gives up after timeout, unless it is 0, or
+// once the limit is stopped, and reports whether it got one.
+func (l *connLimit) wait(max int, timeout time.Duration) bool {
+	l.mu.Lock()
+	if l.takeLocked(max) {
+		l.mu.Unlock()
+		return true
+	}
+	ready := make(chan struct{})
+	l.waiting = append(l.waiting, ready)
+	l.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-ready:
+		return true
+	case <-expired:
+	case <-l.stopped:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := slices.Index(l.waiting, ready); i >= 0 {
+		l.waiting = slices.Delete(l.waiting, i, i+1)
+		return false
+	}
+	// The slot was handed over as the wait gave up.
+	return true
+}
+
+// give gives back a slot, handing it to the first connection queued while
+// the route is under its limit.
+func (l *connLimit) give() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.serving--
+	for len(l.waiting) > 0 && (l.max <= 0 || l.serving < l.max) {
+		close(l.waiting[0])
+		l.waiting = l.waiting[1:]
+		l.serving++
+	}
+}
+
+// allow takes a token for a connection at rate per second, if one is free.
+func (l *connLimit) allow(rate float64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(rate, now)
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// reserve takes a token for a connection at rate per second and returns
+// how long until it is due: 0 if one is free, or else behind those already
+// reserved.
+func (l *connLimit) reserve(rate float64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(rate, now)
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / rate * float64(time.Second))
+}
+
+func (l *connLimit) refill(rate float64, now time.Time) {
+	burst := math.Max(1, rate)
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	}
+	l.last = now
+}
+
+// sleep waits for d, and reports false if the limit is stopped first.
+func (l *connLimit) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.stopped:
+		return false
+	}
+}
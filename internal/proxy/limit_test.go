@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestConnLimit(t *testing.T) {
+	l := newConnLimit()
+	if !l.take(1) {
+		t.Fatal("take() on an empty limit = false")
+	}
+	if l.take(1) {
+		t.Fatal("take() over the limit = true")
+	}
+	if !l.take(0) {
+		t.Fatal("take() with no limit = false")
+	}
+	l.give()
+
+	// A queued connection gets the next slot given back, and one that
+	// times out gives up its place.
+	if l.wait(1, 20*time.Millisecond) {
+		t.Fatal("wait() with no slot given back = true, want it timed out")
+	}
+	got := make(chan bool, 1)
+	go func() { got <- l.wait(1, 0) }()
+	time.Sleep(20 * time.Millisecond)
+	if l.take(1) {
+		t.Fatal("take() ahead of a queued connection = true")
+	}
+	l.give()
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Fatal("wait() = false, want the slot given back")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait() still waiting after a slot was given back")
+	}
+
+	go func() { got <- l.wait(1, 0) }()
+	time.Sleep(20 * time.Millisecond)
+	l.stop()
+	if ok := <-got; ok {
+		t.Error("wait() after stop() = true, want it to give up")
+	}
+}
+
+func TestConnLimit_Rate(t *testing.T) {
+	l := newConnLimit()
+	now := time.Now()
+	// A second's worth may come at once; then they are paced.
+	for i := range 4 {
+		if !l.allow(4, now) {
+			t.Fatalf("allow() #%d within the burst = false", i+1)
+		}
+	}
+	if l.allow(4, now) {
+		t.Fatal("allow() past the burst = true")
+	}
+	if !l.allow(4, now.Add(250*time.Millisecond)) {
+		t.Fatal("allow() a quarter second on = false, want a token refilled")
+	}
+
+	// Reservations queue behind each other.
+	for i, want := range []time.Duration{250 * time.Millisecond, 500 * time.Millisecond} {
+		if got := l.reserve(4, now.Add(250*time.Millisecond)); got != want {
+			t.Errorf("reserve() #%d = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestMaxConnectionsPerSecond(t *testing.T) {
+	tests := []struct {
+		name     string
+		throttle string
+		// wantDelay is how long the third connection should be held;
+		// 0 means it is turned away.
+		wantDelay time.Duration
+	}{
+		{name: "delay", throttle: config.AcceptThrottleDelay, wantDelay: 500 * time.Millisecond},
+		{name: "reject", throttle: config.AcceptThrottleReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			proxyPort := findFreePort(t)
+			route := config.RouteConfig{
+				LocalPort:               proxyPort,
+				Upstream:                upstream.Addr().String(),
+				MaxConnectionsPerSecond: 2,
+				AcceptThrottle:          tt.throttle,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ListenAndServeRoute(ctx, route)
+			time.Sleep(50 * time.Millisecond)
+
+			roundTrip := func() (time.Duration, error) {
+				start := time.Now()
+				conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+				if err != nil {
+					return 0, err
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(2 * time.Second))
+				if _, err := conn.Write([]byte("ping")); err != nil {
+					return 0, err
+				}
+				_, err = io.ReadFull(conn, make([]byte, 4))
+				return time.Since(start), err
+			}
+
+			for i := range 2 {
+				if took, err := roundTrip(); err != nil || took > 200*time.Millisecond {
+					t.Fatalf("connection #%d within the burst took %v, %v", i+1, took, err)
+				}
+			}
+			took, err := roundTrip()
+			if tt.wantDelay == 0 {
+				if err == nil {
+					t.Errorf("connection over the rate served after %v, want it turned away", took)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connection over the rate: %v", err)
+			}
+			if took < tt.wantDelay-100*time.Millisecond {
+				t.Errorf("connection over the rate took %v, want it held about %v", took, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		// wantErr checks what the second connection reads while the first
+		// holds the only slot; nil means it waits and is served once the
+		// first closes.
+		wantErr func(error) bool
+	}{
+		{name: "reject", overflow: config.OverflowReject, wantErr: func(err error) bool { return err == io.EOF }},
+		{name: "reset", overflow: config.OverflowReset, wantErr: func(err error) bool { return errors.Is(err, syscall.ECONNRESET) }},
+		{name: "queue", overflow: config.OverflowQueue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			proxyPort := findFreePort(t)
+			route := config.RouteConfig{
+				LocalPort:      proxyPort,
+				Upstream:       upstream.Addr().String(),
+				MaxConnections: 1,
+				Overflow:       tt.overflow,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ListenAndServeRoute(ctx, route)
+			time.Sleep(50 * time.Millisecond)
+
+			dial := func() net.Conn {
+				t.Helper()
+				conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+				if err != nil {
+					t.Fatalf("failed to connect to proxy: %v", err)
+				}
+				return conn
+			}
+			ping := func(conn net.Conn, timeout time.Duration) error {
+				if _, err := conn.Write([]byte("ping")); err != nil {
+					return err
+				}
+				conn.SetReadDeadline(time.Now().Add(timeout))
+				_, err := io.ReadFull(conn, make([]byte, 4))
+				return err
+			}
+
+			first := dial()
+			defer first.Close()
+			if err := ping(first, time.Second); err != nil {
+				t.Fatalf("first connection: %v", err)
+			}
+
+			second := dial()
+			defer second.Close()
+			if tt.wantErr != nil {
+				// Nothing is sent first, since closing a connection with
+				// bytes unread resets it.
+				second.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := second.Read(make([]byte, 1)); !tt.wantErr(err) {
+					t.Errorf("second connection over the limit got %v", err)
+				}
+				return
+			}
+			err := ping(second, 200*time.Millisecond)
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				t.Fatalf("queued connection got %v, want it held", err)
+			}
+			first.Close()
+			second.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(second, make([]byte, 4)); err != nil {
+				t.Errorf("queued connection after a slot freed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// requestMatch decides which requests an http mode route's chaos applies
+// to.
+type requestMatch struct {
+	methods    []string
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	headers    map[string]string
+}
+
+// newRequestMatch returns nil, which matches every request, when route has
+// no http.match. The path regex is checked when the config is loaded.
+func newRequestMatch(route config.RouteConfig) *requestMatch {
+	if route.HTTP == nil || route.HTTP.Match == nil {
+		return nil
+	}
+	cfg := route.HTTP.Match
+	m := &requestMatch{pathPrefix: cfg.PathPrefix, headers: make(map[string]string, len(cfg.Headers))}
+	for _, method := range cfg.Methods {
+		m.methods = append(m.methods, strings.ToUpper(method))
+	}
+	if cfg.PathRegex != "" {
+		m.pathRegex = regexp.MustCompile(cfg.PathRegex)
+	}
+	for name, value := range cfg.Headers {
+		m.headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return m
+}
+
+// matches reports whether req meets every condition of the match.
+func (m *requestMatch) matches(req *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	if len(m.methods) > 0 && !slices.Contains(m.methods, req.Method) {
+		return false
+	}
+	if !strings.HasPrefix(req.URL.Path, m.pathPrefix) {
+		return false
+	}
+	if m.pathRegex != nil && !m.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	for name, want := range m.headers {
+		values, ok := req.Header[name]
+		if !ok {
+			return false
+		}
+		if want != "" && !slices.Contains(values, want) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,385 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MySQL capability flags, commands and status flags the forwarder acts on.
+const (
+	mysqlClientProtocol41   = 0x00000200
+	mysqlClientCompress     = 0x00000020
+	mysqlClientSSL          = 0x00000800
+	mysqlClientDeprecateEOF = 0x01000000
+
+	mysqlComQuery       = 0x03
+	mysqlComStmtPrepare = 0x16
+	mysqlComStmtExecute = 0x17
+
+	mysqlServerMoreResults = 0x0008
+)
+
+// MySQL packet headers the forwarder recognizes.
+const (
+	mysqlOK     = 0x00
+	mysqlEOF    = 0xFE
+	mysqlErr    = 0xFF
+	mysqlInfile = 0xFB
+)
+
+// mysqlMaxPayload is the largest payload one packet carries; a payload of
+// exactly this size continues in the next packet.
+const mysqlMaxPayload = 0xFFFFFF
+
+// mysqlSSLRequestLen is the size of the shortened handshake response a
+// client sends to ask for TLS.
+const mysqlSSLRequestLen = 32
+
+// mysqlPhase is where a MySQL connection is in its lifecycle.
+type mysqlPhase int
+
+const (
+	mysqlHandshake mysqlPhase = iota
+	mysqlAuth
+	mysqlCommand
+	// mysqlOpaque connections switched to TLS or compression, which the
+	// forwarder can't follow.
+	mysqlOpaque
+)
+
+// mysqlReply is what the upstream's reply to a command is expected to hold.
+type mysqlReply int
+
+const (
+	mysqlReplyOther mysqlReply = iota
+	mysqlReplyResult
+	mysqlReplyPrepare
+)
+
+// mysqlResultState tracks the upstream's progress through a reply that may
+// carry result sets: their column count, column definitions, then rows.
+type mysqlResultState int
+
+const (
+	mysqlIdle mysqlResultState = iota
+	mysqlFirst
+	mysqlColumns
+	mysqlColumnsEOF
+	mysqlRows
+)
+
+// mysqlForwarder relays a mysql mode connection one packet at a time,
+// following commands and their result sets for the route's database chaos.
+// A connection that switches to TLS or compression is relayed untouched
+// from then on. Each side updates the phase before forwarding the packet
+// that moves the connection on, and the other side reads it only once the
+// peer's next bytes arrive, so neither reads them the wrong way.
+type mysqlForwarder struct {
+	db                     *dbChaos
+	fromClient, fromServer *bufio.Reader
+	toClient, toServer     *countingWriter
+
+	mu           sync.Mutex
+	phase        mysqlPhase
+	compress     bool
+	deprecateEOF bool
+	// pending is the reply expected to the client's latest command, and
+	// preparing the query it asked to prepare, if any.
+	pending   mysqlReply
+	preparing string
+	// statements maps prepared statement IDs to their query text.
+	statements map[uint32]string
+}
+
+func newMySQLForwarder(db *dbChaos, fromClient, fromServer io.Reader, toClient, toServer *countingWriter) *mysqlForwarder {
+	return &mysqlForwarder{
+		db:         db,
+		fromClient: bufio.NewReader(fromClient),
+		fromServer: bufio.NewReader(fromServer),
+		toClient:   toClient,
+		toServer:   toServer,
+		statements: make(map[uint32]string),
+	}
+}
+
+// queries relays the client's packets to the upstream. It returns nil when
+// the client is done sending.
+func (f *mysqlForwarder) queries() error {
+	for {
+		if _, err := f.fromClient.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		f.mu.Lock()
+		phase, compress := f.phase, f.compress
+		f.mu.Unlock()
+		if phase == mysqlOpaque || (phase == mysqlCommand && compress) {
+			return relayRest(f.toServer, f.fromClient)
+		}
+
+		packet, err := readMySQLPacket(f.fromClient)
+		if err != nil {
+			return err
+		}
+		payload := packet[4:]
+		switch {
+		case phase == mysqlHandshake:
+			f.handshakeResponse(payload)
+		case phase == mysqlCommand && packet[3] == 0 && len(payload) > 0:
+			f.command(payload)
+		}
+		if _, err := f.toServer.Write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// handshakeResponse picks up the capabilities the client settled on. It is
+// called before the response is forwarded, so the upstream's side knows
+// how to read what comes back.
+func (f *mysqlForwarder) handshakeResponse(payload []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(payload) < 4 {
+		f.phase = mysqlOpaque
+		return
+	}
+	caps := binary.LittleEndian.Uint32(payload)
+	switch {
+	case caps&mysqlClientProtocol41 == 0:
+		// The pre-4.1 protocol isn't worth following.
+		f.phase = mysqlOpaque
+	case caps&mysqlClientSSL != 0 && len(payload) == mysqlSSLRequestLen:
+		f.phase = mysqlOpaque
+	default:
+		f.phase = mysqlAuth
+		f.compress = caps&mysqlClientCompress != 0
+		f.deprecateEOF = caps&mysqlClientDeprecateEOF != 0
+	}
+}
+
+// command records the reply expected to a command, rolling the chaos for
+// queries.
+func (f *mysqlForwarder) command(payload []byte) {
+	reply, query := mysqlReplyOther, ""
+	switch payload[0] {
+	case mysqlComQuery:
+		reply, query = mysqlReplyResult, string(payload[1:])
+	case mysqlComStmtExecute:
+		reply = mysqlReplyResult
+		if len(payload) >= 5 {
+			id := binary.LittleEndian.Uint32(payload[1:])
+			f.mu.Lock()
+			query = f.statements[id]
+			f.mu.Unlock()
+			if query == "" {
+				query = fmt.Sprintf("statement %d", id)
+			}
+		}
+	case mysqlComStmtPrepare:
+		reply = mysqlReplyPrepare
+	}
+
+	f.mu.Lock()
+	f.pending = reply
+	if reply == mysqlReplyPrepare {
+		f.preparing = string(payload[1:])
+	}
+	f.mu.Unlock()
+	if reply == mysqlReplyResult {
+		f.db.sent(query)
+	}
+}
+
+// responses relays the upstream's packets to the client. It returns nil
+// when the upstream is done sending.
+func (f *mysqlForwarder) responses() error {
+	state := mysqlIdle
+	columns := uint64(0)
+	continued := false
+	for {
+		if _, err := f.fromServer.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		f.mu.Lock()
+		phase, compress, deprecateEOF := f.phase, f.compress, f.deprecateEOF
+		f.mu.Unlock()
+		if phase == mysqlOpaque {
+			return relayRest(f.toClient, f.fromServer)
+		}
+
+		packet, err := readMySQLPacket(f.fromServer)
+		if err != nil {
+			return err
+		}
+		payload := packet[4:]
+
+		switch {
+		case phase == mysqlAuth && len(payload) > 0 && payload[0] == mysqlOK:
+			f.mu.Lock()
+			f.phase = mysqlCommand
+			f.mu.Unlock()
+			if compress {
+				if _, err := f.toClient.Write(packet); err != nil {
+					return err
+				}
+				return relayRest(f.toClient, f.fromServer)
+			}
+		case phase == mysqlCommand && !continued && len(payload) > 0:
+			if !f.db.responding() {
+				return errDBKilled
+			}
+			if state == mysqlIdle {
+				state = f.reply(payload)
+			}
+			switch state {
+			case mysqlFirst:
+				switch payload[0] {
+				case mysqlOK:
+					if mysqlStatus(payload, false)&mysqlServerMoreResults == 0 {
+						state = mysqlIdle
+					}
+				case mysqlErr, mysqlInfile:
+					state = mysqlIdle
+				default:
+					columns, _ = mysqlLenenc(payload)
+					state = mysqlColumns
+					if columns == 0 {
+						state = mysqlIdle
+					}
+				}
+			case mysqlColumns:
+				columns--
+				if columns == 0 {
+					state = mysqlColumnsEOF
+					if deprecateEOF {
+						state = mysqlRows
+					}
+				}
+			case mysqlColumnsEOF:
+				state = mysqlRows
+			case mysqlRows:
+				switch {
+				case payload[0] == mysqlErr:
+					if !f.db.resultEnd() {
+						return errDBKilled
+					}
+					state = mysqlIdle
+				case payload[0] == mysqlEOF && len(payload) < mysqlMaxPayload:
+					if !f.db.resultEnd() {
+						return errDBKilled
+					}
+					state = mysqlIdle
+					if mysqlStatus(payload, !deprecateEOF)&mysqlServerMoreResults != 0 {
+						state = mysqlFirst
+					}
+				default:
+					if !f.db.row() {
+						return errDBKilled
+					}
+				}
+			}
+		}
+		continued = len(payload) == mysqlMaxPayload
+
+		if _, err := f.toClient.Write(packet); err != nil {
+			return err
+		}
+	}
+}
+
+// reply starts following the upstream's reply to the client's latest
+// command, whose first packet is payload, and returns the state to read
+// that packet in.
+func (f *mysqlForwarder) reply(payload []byte) mysqlResultState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := f.pending
+	f.pending = mysqlReplyOther
+	switch reply {
+	case mysqlReplyResult:
+		return mysqlFirst
+	case mysqlReplyPrepare:
+		if payload[0] == mysqlOK && len(payload) >= 5 {
+			f.statements[binary.LittleEndian.Uint32(payload[1:])] = f.preparing
+		}
+	}
+	return mysqlIdle
+}
+
+// readMySQLPacket reads one packet, header included. Callers have already
+// seen its first byte arrive, so running out of data is always unexpected.
+func readMySQLPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	n := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	packet := append(header, make([]byte, n)...)
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+// mysqlLenenc decodes the length-encoded integer at the start of b and
+// returns it and its size.
+func mysqlLenenc(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	var size int
+	switch b[0] {
+	case 0xFC:
+		size = 2
+	case 0xFD:
+		size = 3
+	case 0xFE:
+		size = 8
+	default:
+		return uint64(b[0]), 1
+	}
+	if len(b) < 1+size {
+		return 0, 0
+	}
+	var v uint64
+	for i := size; i > 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, 1 + size
+}
+
+// mysqlStatus returns the server status flags of an OK packet, or of an
+// old-style EOF packet when eof is set, or 0 if payload is too short.
+func mysqlStatus(payload []byte, eof bool) uint16 {
+	if eof {
+		if len(payload) < 5 {
+			return 0
+		}
+		return binary.LittleEndian.Uint16(payload[3:])
+	}
+	off := 1
+	for range 2 { // affected rows, then last insert ID
+		_, n := mysqlLenenc(payload[off:])
+		if n == 0 {
+			return 0
+		}
+		off += n
+	}
+	if len(payload) < off+2 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(payload[off:])
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+const redactedText = "[REDACTED]"
+
+// redactLookahead is how far past maxBytes a snippet keeps capturing, so a
+// secret straddling the cut is still matched whole before it is trimmed.
+const redactLookahead = 256
+
+// payloadLog logs the start of each direction of a connection with every
+// redaction match replaced.
+type payloadLog struct {
+	maxBytes int
+	redact   []*regexp.Regexp
+}
+
+// newPayloadLog returns nil when cfg is nil. The patterns are checked when
+// the config is loaded.
+func newPayloadLog(cfg *config.PayloadLogConfig) *payloadLog {
+	if cfg == nil {
+		return nil
+	}
+	p := &payloadLog{maxBytes: cfg.MaxBytesOrDefault()}
+	for _, pattern := range cfg.Redact {
+		p.redact = append(p.redact, regexp.MustCompile(pattern))
+	}
+	return p
+}
+
+// capture returns a reader that copies what is read from src into the
+// returned snippet.
+func (p *payloadLog) capture(src io.Reader) (io.Reader, *snippet) {
+	s := &snippet{limit: p.maxBytes + redactLookahead}
+	return io.TeeReader(src, s), s
+}
+
+// text returns the snippet with redactions applied, cut to maxBytes, and
+// whether anything was cut.
+func (p *payloadLog) text(s *snippet) (string, bool) {
+	s.mu.Lock()
+	data := append([]byte(nil), s.buf...)
+	s.mu.Unlock()
+
+	for _, re := range p.redact {
+		data = re.ReplaceAll(data, []byte(redactedText))
+	}
+	if len(data) > p.maxBytes {
+		return string(data[:p.maxBytes]), true
+	}
+	return string(data), false
+}
+
+func (p *payloadLog) log(logger *slog.Logger, clientAddr, direction string, s *snippet) {
+	text, truncated := p.text(s)
+	if text == "" {
+		return
+	}
+	logger.Info("payload snippet",
+		"address", clientAddr,
+		"direction", direction,
+		"truncated", truncated,
+		"payload", text)
+}
+
+// snippet keeps the first limit bytes written to it and discards the rest.
+type snippet struct {
+	mu    sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (s *snippet) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room := s.limit - len(s.buf); room > 0 {
+		s.buf = append(s.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestPayloadLog_Text(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.PayloadLogConfig
+		input         string
+		want          string
+		wantTruncated bool
+	}{
+		{
+			name:  "no redaction",
+			cfg:   config.PayloadLogConfig{},
+			input: "GET / HTTP/1.1\r\n",
+			want:  "GET / HTTP/1.1\r\n",
+		},
+		{
+			name: "redacts tokens and card numbers",
+			cfg: config.PayloadLogConfig{Redact: []string{
+				`(?i)bearer [a-z0-9._-]+`,
+				`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{4}\b`,
+			}},
+			input: "Authorization: Bearer abc.def-123\r\ncard=4111 1111 1111 1111",
+			want:  "Authorization: [REDACTED]\r\ncard=[REDACTED]",
+		},
+		{
+			name:          "truncates to max bytes",
+			cfg:           config.PayloadLogConfig{MaxBytes: 5},
+			input:         "hello world",
+			want:          "hello",
+			wantTruncated: true,
+		},
+		{
+			name:          "redacts a secret straddling the cut",
+			cfg:           config.PayloadLogConfig{MaxBytes: 10, Redact: []string{`secret-[a-z]+`}},
+			input:         "key=secret-abcdefghij&more",
+			want:          "key=[REDAC",
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPayloadLog(&tt.cfg)
+			src, s := p.capture(strings.NewReader(tt.input))
+			if _, err := io.Copy(io.Discard, src); err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+
+			got, truncated := p.text(s)
+			if got != tt.want {
+				t.Errorf("text() = %q, want %q", got, tt.want)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codes of the untyped requests a Postgres client may send before, or
+// instead of, its startup message.
+const (
+	pgCancelRequest = 80877102
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+)
+
+// Postgres message types the forwarder acts on. The same byte means
+// different things in each direction.
+const (
+	// From the client.
+	pgQuery   = 'Q'
+	pgParse   = 'P'
+	pgBind    = 'B'
+	pgExecute = 'E'
+
+	// From the server.
+	pgDataRow         = 'D'
+	pgCommandComplete = 'C'
+	pgErrorResponse   = 'E'
+	pgPortalSuspended = 's'
+	pgReadyForQuery   = 'Z'
+)
+
+// maxPGStartup bounds the untyped startup messages, as the server does.
+const maxPGStartup = 10000
+
+// errPGMalformed is returned for a stream that doesn't frame as the
+// Postgres protocol.
+var errPGMalformed = errors.New("malformed Postgres message")
+
+// pgForwarder relays a postgres mode connection one message at a time,
+// following queries and their results for the route's database chaos. A
+// connection that negotiates TLS or GSSAPI encryption is relayed untouched
+// from then on. The client's side flags an encryption request before
+// forwarding it, and the upstream's side checks the flag only once the
+// answer arrives, so the answer's single byte is never read as a message
+// header.
+type pgForwarder struct {
+	db                     *dbChaos
+	fromClient, fromServer *bufio.Reader
+	toClient, toServer     *countingWriter
+
+	// answers carries the server's one-byte answer to an encryption
+	// request from responses to queries, which waits for it to know how
+	// the client will carry on. awaiting tells responses one is due.
+	answers  chan byte
+	mu       sync.Mutex
+	awaiting bool
+
+	// The query text behind each prepared statement and portal, so an
+	// Execute can be reported by the query it runs.
+	statements, portals map[string]string
+}
+
+func newPGForwarder(db *dbChaos, fromClient, fromServer io.Reader, toClient, toServer *countingWriter) *pgForwarder {
+	return &pgForwarder{
+		db:         db,
+		fromClient: bufio.NewReader(fromClient),
+		fromServer: bufio.NewReader(fromServer),
+		toClient:   toClient,
+		toServer:   toServer,
+		answers:    make(chan byte, 1),
+		statements: make(map[string]string),
+		portals:    make(map[string]string),
+	}
+}
+
+// queries relays the client's messages to the upstream. It returns nil
+// when the client is done sending.
+func (f *pgForwarder) queries() error {
+	for {
+		msg, err := readPGStartup(f.fromClient)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		code := binary.BigEndian.Uint32(msg[4:])
+		encrypt := code == pgSSLRequest || code == pgGSSENCRequest
+		if encrypt {
+			f.mu.Lock()
+			f.awaiting = true
+			f.mu.Unlock()
+		}
+		if _, err := f.toServer.Write(msg); err != nil {
+			return err
+		}
+		if code == pgCancelRequest {
+			return relayRest(f.toServer, f.fromClient)
+		}
+		if !encrypt {
+			break
+		}
+		f.toServer.Flush()
+		if answer, ok := <-f.answers; !ok || answer != 'N' {
+			return relayRest(f.toServer, f.fromClient)
+		}
+		// Refused; the client carries on in plaintext or gives up.
+	}
+
+	for {
+		typ, length, err := readPGHeader(f.fromClient)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case pgQuery, pgParse, pgBind, pgExecute:
+			msg := make([]byte, 5+length)
+			msg[0] = typ
+			binary.BigEndian.PutUint32(msg[1:], uint32(length+4))
+			if _, err := io.ReadFull(f.fromClient, msg[5:]); err != nil {
+				return err
+			}
+			f.inspect(typ, msg[5:])
+			if _, err := f.toServer.Write(msg); err != nil {
+				return err
+			}
+		default:
+			if err := relayPGMessage(f.toServer, f.fromClient, typ, length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inspect follows the client's queries: simple queries directly, and
+// extended ones from Parse to Bind to Execute.
+func (f *pgForwarder) inspect(typ byte, body []byte) {
+	switch typ {
+	case pgQuery:
+		query, _ := pgString(body)
+		f.db.sent(query)
+	case pgParse:
+		name, rest := pgString(body)
+		f.statements[name], _ = pgString(rest)
+	case pgBind:
+		portal, rest := pgString(body)
+		statement, _ := pgString(rest)
+		f.portals[portal] = f.statements[statement]
+	case pgExecute:
+		portal, _ := pgString(body)
+		f.db.sent(f.portals[portal])
+	}
+}
+
+// responses relays the upstream's messages to the client. It returns nil
+// when the upstream is done sending.
+func (f *pgForwarder) responses() error {
+	defer close(f.answers)
+	for {
+		if _, err := f.fromServer.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		f.mu.Lock()
+		awaiting := f.awaiting
+		f.awaiting = false
+		f.mu.Unlock()
+		if awaiting {
+			answer, _ := f.fromServer.ReadByte()
+			if _, err := f.toClient.Write([]byte{answer}); err != nil {
+				return err
+			}
+			f.answers <- answer
+			if answer != 'N' {
+				return relayRest(f.toClient, f.fromServer)
+			}
+			continue
+		}
+
+		typ, length, err := readPGHeader(f.fromServer)
+		if err != nil {
+			return err
+		}
+		if !f.db.responding() {
+			return errDBKilled
+		}
+		switch typ {
+		case pgDataRow:
+			if !f.db.row() {
+				return errDBKilled
+			}
+		case pgCommandComplete, pgErrorResponse, pgPortalSuspended, pgReadyForQuery:
+			if !f.db.resultEnd() {
+				return errDBKilled
+			}
+		}
+		if err := relayPGMessage(f.toClient, f.fromServer, typ, length); err != nil {
+			return err
+		}
+	}
+}
+
+// readPGStartup reads an untyped message: a startup message, or a request
+// to cancel a query or encrypt the connection.
+func readPGStartup(r io.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header)
+	if n < 8 || n > maxPGStartup {
+		return nil, fmt.Errorf("%w: startup message of length %d", errPGMalformed, n)
+	}
+	msg := append(header, make([]byte, n-8)...)
+	if _, err := io.ReadFull(r, msg[8:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// readPGHeader reads a typed message's type and the length of its body.
+func readPGHeader(r io.Reader) (byte, int, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n < 4 || n > 1<<30 {
+		return 0, 0, fmt.Errorf("%w: message %q of length %d", errPGMalformed, header[0], n)
+	}
+	return header[0], int(n) - 4, nil
+}
+
+// relayPGMessage writes a message's header to w and streams its body from
+// r, so large rows aren't held in memory.
+func relayPGMessage(w io.Writer, r io.Reader, typ byte, length int) error {
+	header := []byte{typ, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[1:], uint32(length+4))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	n, err := io.CopyN(w, r, int64(length))
+	if err == io.EOF && n < int64(length) {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// pgString splits the null-terminated string at the start of b from the
+// rest.
+func pgString(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b), nil
+	}
+	return string(b[:i]), b[i+1:]
+}
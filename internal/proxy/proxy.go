@@ -1,47 +1,479 @@
 package proxy
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand"
 	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chasewilson/chaos-proxy/internal/chaos"
 	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+	"github.com/chasewilson/chaos-proxy/internal/script"
 )
 
-type bytesTransferred struct {
-	direction string
-	bytes     int64
+// ServeOptions carries optional hooks for ServeRoute. The zero value is
+// equivalent to ListenAndServeRoute.
+type ServeOptions struct {
+	// Stats records connection outcomes when non-nil.
+	Stats *RouteStats
+	// OnListen is called with the bound address once the listener is up.
+	// Useful with LocalPort 0, where the OS picks the port.
+	OnListen func(addr net.Addr)
+	// Events receives connection open/close/fault events when non-nil.
+	Events *events.Bus
+	// Intensity scales drop rates and latencies, shared across routes so it
+	// can be changed at runtime. Nil means unscaled.
+	Intensity *chaos.Intensity
+	// TenantIntensity scales the routes of each tenant, by tenant name, in
+	// place of Intensity, so a tenant's scale can be changed without
+	// touching the others'. It is looked up again when a route's settings
+	// are updated in place.
+	TenantIntensity map[string]*chaos.Intensity
+	// Memory is the budget the data held back by coalescing, reordering and
+	// delayed UDP datagrams is reserved from, shared across routes so it
+	// bounds the process. Nil means no limit beyond each route's
+	// maxBufferedBytes.
+	Memory *chaos.Memory
+	// DryRun rolls and logs every chaos decision but forwards connections
+	// untouched. Nothing is published as a fault.
+	DryRun bool
+	// LogSample logs the routine lines of a connection, its upstream
+	// connecting and the bytes it transferred, for only one connection in
+	// every LogSample, to keep logging off the hot path under heavy load.
+	// Chaos, warnings and errors are logged for every connection. 0 or 1
+	// logs every connection's.
+	LogSample int
+	// Control switches the route and its chaos at runtime. Nil means a
+	// control of the route's own, following its enabled setting.
+	Control *RouteControl
+	// OnConnClose is called with the record of every connection, or UDP
+	// session, once it has closed.
+	OnConnClose func(ConnRecord)
+	// Listener serves the route's connections in place of a listener on its
+	// address, such as one bound ahead of time or an in-memory one. It is
+	// closed when the route stops. TCP routes only.
+	Listener net.Listener
+	// Dial connects to the route's upstream in place of net.Dial, such as
+	// over an in-memory pipe or a custom transport. Its context ends after
+	// the route's dialTimeoutMs. TCP routes only.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Hooks are called as the route's connections progress, and can veto
+	// its chaos decisions.
+	Hooks Hooks
+}
+
+// intensity returns the intensity scaling route's chaos.
+func (o ServeOptions) intensity(route config.RouteConfig) *chaos.Intensity {
+	if intensity, ok := o.TenantIntensity[route.Tenant]; ok {
+		return intensity
+	}
+	return o.Intensity
 }
 
 func ListenAndServeRoute(ctx context.Context, route config.RouteConfig) error {
-	routeLogger := slog.With("port", route.LocalPort)
-	addr := fmt.Sprintf("127.0.0.1:%d", route.LocalPort)
-	routeLogger.Info("starting TCP listener", "address", addr)
+	return ServeRoute(ctx, route, ServeOptions{})
+}
+
+// ServeRoute is ListenAndServeRoute with optional hooks.
+func ServeRoute(ctx context.Context, route config.RouteConfig, opts ServeOptions) error {
+	routeLogger := newRouteLogger(route)
+	addr := route.ListenAddr()
+	if opts.Listener != nil {
+		defer opts.Listener.Close()
+		addr = opts.Listener.Addr().String()
+	}
+	if route.IsUDP() {
+		if opts.Listener != nil || opts.Dial != nil {
+			return errors.New("a UDP route can't be given a listener or dialer")
+		}
+		return serveUDP(ctx, route, opts, routeLogger, addr)
+	}
 
-	listener, err := net.Listen("tcp", addr)
+	server, err := newRouteServer(route, opts.intensity(route), opts.Memory, routeLogger)
 	if err != nil {
+		return err
+	}
+
+	routeLogger.Info("starting TCP listener", "address", addr, "tls", server.serverTLS != nil)
+	routeLogger.Debug("rolling route chaos", "seed", server.ritual.Rand().Seed(), "hint", "set the route's seed to this to roll the same way again")
+
+	// A listener of the caller's is used as it is, with one accept loop.
+	reusePort := opts.Listener == nil && route.AcceptListeners > 1
+	var listener net.Listener
+	switch {
+	case opts.Listener != nil:
+		listener = opts.Listener
+	case route.Mode == config.ModeTransparent:
+		listener, err = listenTransparent(addr, routeLogger)
+	case reusePort:
+		listener, err = listenReusePort(addr)
+	default:
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		server.retire()
 		routeLogger.Error("failed to start listener", "error", err, "hint", "port may be in use or you may need elevated permissions")
 		return fmt.Errorf("failed to start listener: %w", err)
 	}
 	defer listener.Close()
 
-	routeLogger.Debug("listener started successfully", "address", addr)
+	// Every listener is bound before the route reports that it is
+	// listening, so OnListen means it is serving in full.
+	listeners := []net.Listener{listener}
+	if reusePort {
+		// The rest bind the port the first got, in case addr left it to
+		// the kernel.
+		for range route.AcceptListeners - 1 {
+			l, err := listenReusePort(listener.Addr().String())
+			if err != nil {
+				routeLogger.Error("failed to start accept listener",
+					"address", listener.Addr().String(),
+					"error", err,
+					"hint", "another process may hold the port without SO_REUSEPORT; lower acceptListeners or free the port")
+				server.retire()
+				return fmt.Errorf("failed to start accept listener: %w", err)
+			}
+			defer l.Close()
+			listeners = append(listeners, l)
+		}
+		routeLogger.Info("accepting on several listeners", "listeners", len(listeners))
+	}
+	if route.HandoffSocket != "" {
+		handoff, err := listenHandoff(route.HandoffSocket, routeLogger)
+		if err != nil {
+			routeLogger.Error("failed to start handoff listener",
+				"socket", route.HandoffSocket,
+				"error", err,
+				"hint", "check that the directory exists and no other process is serving this socket")
+			server.retire()
+			return fmt.Errorf("failed to start handoff listener: %w", err)
+		}
+		defer handoff.Close()
+		routeLogger.Info("accepting handed-off connections", "socket", route.HandoffSocket)
+		listeners = append(listeners, handoff)
+	}
+
+	// A listener of the caller's may not be bound to a TCP port.
+	port := route.LocalPort
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok {
+		port = tcpAddr.Port
+	}
+	opts.listening(route, listener.Addr(), port, routeLogger)
+	server.addr = listener.Addr().String()
+	server.port = port
+	server.limit = newConnLimit()
+	defer server.limit.stop()
+	server.opts = opts
+
+	live := &liveServer{}
+	live.Store(server)
+	defer func() { live.Load().retire() }()
+	opts.Control.serving(live.update)
+	defer opts.Control.serving(nil)
 
 	go func() {
 		<-ctx.Done()
 		routeLogger.Debug("context cancelled, closing listener", "address", addr)
-		listener.Close()
+		for _, l := range listeners {
+			l.Close()
+		}
+		// An accept loop may be holding a connection back for
+		// maxConnectionsPerSecond.
+		server.limit.stop()
 	}()
 
+	// Every listener feeds the same route, so stateful chaos (loss models,
+	// tarpit) is shared between them. The first to fail stops the rest.
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			err := live.acceptLoop(l)
+			for _, other := range listeners {
+				other.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	var firstErr error
+	for range listeners {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func newRouteLogger(route config.RouteConfig) *slog.Logger {
+	routeLogger := slog.With("port", route.LocalPort)
+	if route.Name != "" {
+		routeLogger = routeLogger.With("route", route.Name)
+	}
+	if route.Tenant != "" {
+		routeLogger = routeLogger.With("tenant", route.Tenant)
+	}
+	if len(route.Tags) > 0 {
+		routeLogger = routeLogger.With("tags", route.Tags)
+	}
+	return routeLogger
+}
+
+// newRouteServer loads everything route's connections need before its
+// listener opens: certificates, and the stub, replay or recorder it has.
+// The caller fills in where the server is bound.
+func newRouteServer(route config.RouteConfig, intensity *chaos.Intensity, memory *chaos.Memory, routeLogger *slog.Logger) (*routeServer, error) {
+	serverTLS, err := newServerTLS(route)
+	if err != nil {
+		routeLogger.Error("failed to load TLS certificate",
+			"cert_file", route.TLS.CertFile,
+			"key_file", route.TLS.KeyFile,
+			"error", err,
+			"hint", "tls.certFile and tls.keyFile must be a readable PEM certificate chain and its private key")
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	var badCert *tls.Certificate
+	if serverTLS != nil {
+		if badCert, err = newBadCert(route, serverTLS); err != nil {
+			routeLogger.Error("failed to generate bad TLS certificate",
+				"error", err,
+				"hint", "tls.handshake.badCertRate needs a throwaway certificate; remove it or check the system's entropy source")
+			return nil, fmt.Errorf("failed to generate bad TLS certificate: %w", err)
+		}
+	}
+	upstreamTLS, err := newUpstreamTLS(route)
+	if err != nil {
+		routeLogger.Error("failed to load upstream TLS files",
+			"ca_file", route.UpstreamTLS.CAFile,
+			"cert_file", route.UpstreamTLS.CertFile,
+			"key_file", route.UpstreamTLS.KeyFile,
+			"error", err,
+			"hint", "upstreamTLS.caFile must be a readable file of PEM certificates, and upstreamTLS.certFile and keyFile a PEM certificate chain and its matching private key")
+		return nil, fmt.Errorf("failed to load upstream TLS files: %w", err)
+	}
+	recorder, err := newRecorder(route, routeLogger)
+	if err != nil {
+		routeLogger.Error("failed to create recording directory",
+			"dir", route.Record.Dir,
+			"error", err,
+			"hint", "record.dir must be a directory the proxy can create and write to")
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	var rules *script.Script
+	if route.Script != "" {
+		if rules, err = script.Load(route.Script); err != nil {
+			routeLogger.Error("failed to load chaos script",
+				"script", route.Script,
+				"error", err,
+				"hint", "script must be a readable file of rules, one per line, such as 'if client.requests > 100: error'")
+			return nil, fmt.Errorf("failed to load chaos script: %w", err)
+		}
+	}
+	var local localUpstream
+	if route.Stub != nil {
+		if local, err = startStub(route, routeLogger); err != nil {
+			routeLogger.Error("failed to start stub",
+				"body_file", route.Stub.BodyFile,
+				"error", err,
+				"hint", "stub.bodyFile must be a readable file, and the stub needs a free loopback port")
+			return nil, fmt.Errorf("failed to start stub: %w", err)
+		}
+	} else if route.Replay != nil {
+		if local, err = startReplay(route, routeLogger); err != nil {
+			routeLogger.Error("failed to start replay",
+				"dir", route.Replay.Dir,
+				"error", err,
+				"hint", "replay.dir must hold readable recordings written by a route with record.dir")
+			return nil, fmt.Errorf("failed to start replay: %w", err)
+		}
+	}
+
+	portRituals := make([]chaos.Ritual, len(route.PortRules))
+	for i := range route.PortRules {
+		portRituals[i] = newRitual(route.WithPortRule(i), intensity, memory)
+	}
+
+	return &routeServer{
+		route:        route,
+		cleanRoute:   route.WithoutChaos(),
+		ritual:       newRitual(route, intensity, memory),
+		portRituals:  portRituals,
+		flap:         chaos.NewFlap(int(route.FlapIntervalMs), int(route.FlapDowntimeMs)),
+		chaosClients: route.ChaosPrefixes(),
+		payload:      newPayloadLog(route.PayloadLog),
+		match:        newRequestMatch(route),
+		script:       rules,
+		serverTLS:    serverTLS,
+		badCert:      badCert,
+		upstreamTLS:  upstreamTLS,
+		local:        local,
+		recorder:     recorder,
+		logger:       routeLogger,
+	}, nil
+}
+
+// routeServer is a route's chaos state, shared by every listener feeding it.
+// Updating the route's settings replaces it; connections it already took
+// finish with it.
+type routeServer struct {
+	// addr and port are where the TCP listener is bound, and limit holds
+	// the route's connection slots.
+	addr       string
+	port       int
+	limit      *connLimit
+	route      config.RouteConfig
+	cleanRoute config.RouteConfig
+	ritual     chaos.Ritual
+	// portRituals hold the chaos state of each of a transparent route's
+	// port rules, in place of ritual for the connections they take.
+	portRituals  []chaos.Ritual
+	flap         *chaos.Flap
+	chaosClients []netip.Prefix
+	payload      *payloadLog
+	match        *requestMatch
+	// script decides faults of its own for connections or requests; nil
+	// when the route has none.
+	script *script.Script
+	// serverTLS and upstreamTLS are set when the route terminates client
+	// TLS and dials its upstream over TLS.
+	serverTLS   *tls.Config
+	upstreamTLS *tls.Config
+	// badCert stands in for the route's certificate when handshake chaos
+	// presents a bad one.
+	badCert *tls.Certificate
+	// local is dialled in place of an upstream when the route has a stub
+	// or replay, and recorder records connections when it has record.
+	local    localUpstream
+	recorder *recorder
+	logger   *slog.Logger
+	opts     ServeOptions
+
+	// primaryFailures counts the dials of the route's upstream that have
+	// failed in a row, for failing over to its fallback upstream.
+	primaryFailures atomic.Int64
+
+	// conns counts the connections the server has taken and not finished,
+	// so that once it is retired its local upstream outlives them.
+	mu      sync.Mutex
+	conns   int
+	retired bool
+}
+
+// failover counts a failed dial of the route's upstream and reports whether
+// the connection should go to the fallback upstream instead.
+func (s *routeServer) failover() bool {
+	if s.route.FallbackUpstream == "" {
+		return false
+	}
+	return s.primaryFailures.Add(1) >= int64(max(s.route.FallbackAfterFailures, 1))
+}
+
+// acquire takes a connection for the server, unless it has been retired.
+func (s *routeServer) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retired {
+		return false
+	}
+	s.conns++
+	s.opts.Control.opened()
+	return true
+}
+
+// release finishes a connection taken with acquire.
+func (s *routeServer) release() {
+	s.mu.Lock()
+	s.conns--
+	idle := s.retired && s.conns == 0
+	s.mu.Unlock()
+	s.opts.Control.closed()
+	if idle {
+		s.closeLocal()
+	}
+}
+
+// retire stops the server taking connections, closing its local upstream
+// once those it has are finished.
+func (s *routeServer) retire() {
+	s.mu.Lock()
+	s.retired = true
+	idle := s.conns == 0
+	s.mu.Unlock()
+	if idle {
+		s.closeLocal()
+	}
+}
+
+func (s *routeServer) closeLocal() {
+	if s.local != nil {
+		s.local.Close()
+	}
+}
+
+// liveServer holds the routeServer a route's listeners hand new
+// connections to.
+type liveServer struct {
+	atomic.Pointer[routeServer]
+}
+
+// acquire takes a connection for the current server.
+func (l *liveServer) acquire() *routeServer {
+	for {
+		// Retired servers have been replaced; the next load finds the new
+		// one.
+		if s := l.Load(); s.acquire() {
+			return s
+		}
+	}
+}
+
+// update replaces the server with one for route, which keeps the current
+// one's listener. It is the route's RouteControl.Update.
+func (l *liveServer) update(route config.RouteConfig) error {
+	current := l.Load()
+	routeLogger := newRouteLogger(route)
+	next, err := newRouteServer(route, current.opts.intensity(route), current.opts.Memory, routeLogger)
+	if err != nil {
+		return err
+	}
+	next.addr, next.port, next.limit = current.addr, current.port, current.limit
+	next.opts = current.opts
+	// An unchanged flap keeps its cycle, so an update of other settings
+	// doesn't move the outage window.
+	if route.FlapIntervalMs == current.route.FlapIntervalMs && route.FlapDowntimeMs == current.route.FlapDowntimeMs {
+		next.flap = current.flap
+	}
+	l.Store(next)
+	current.retire()
+	routeLogger.Debug("route settings updated", "address", next.addr, "upstream", route.Upstream)
+	return nil
+}
+
+// localUpstream is a server built into the proxy that a route dials in
+// place of a configured upstream: a stub, or a replay of recorded traffic.
+type localUpstream interface {
+	addr() string
+	Close() error
+}
+
+// acceptLoop serves connections from listener until it is closed, handing
+// each to the server current when it arrives.
+func (l *liveServer) acceptLoop(listener net.Listener) error {
 	for {
-		routeLogger.Debug("waiting for connection...")
+		l.Load().logger.Debug("waiting for connection...")
 		client, err := listener.Accept()
 		if err != nil {
+			routeLogger := l.Load().logger
 			if errors.Is(err, net.ErrClosed) {
 				routeLogger.Debug("listener closed")
 				return nil
@@ -51,77 +483,1021 @@ func ListenAndServeRoute(ctx context.Context, route config.RouteConfig) error {
 			return fmt.Errorf("failed to accept connection: %w", err)
 		}
 
-		routeLogger.Debug("connection accepted", "address", client.RemoteAddr())
-		go handleConnection(client, route, routeLogger)
+		s := l.acquire()
+		route, opts := s.route, s.opts
+		stats := opts.Stats
+		// The ID is given at accept so that every log line and event of
+		// the connection can be told apart from those of the others.
+		id := connIDs.Add(1)
+		logger := s.logger.With("conn", id)
+		logger.Debug("connection accepted", "address", client.RemoteAddr())
+		if pp := route.ProxyProtocol; pp != nil && pp.Accept {
+			// The header may take a round trip to arrive, so it is read off
+			// the accept loop.
+			go func() {
+				conn, err := readProxyHeader(client)
+				if err != nil {
+					logger.Error("failed to read PROXY protocol header",
+						"address", client.RemoteAddr(),
+						"error", err,
+						"hint", "proxyProtocol.accept expects every connection to come from a load balancer sending PROXY protocol v1 or v2 headers")
+					stats.recordConnection()
+					stats.recordFailure()
+					tracked := opts.Control.track(id, client.RemoteAddr().String(), route.Upstream, func() { reset(client) })
+					opts.publish(route, events.ConnectionOpen, client.RemoteAddr().String(), nil)
+					opts.publish(route, events.ConnectionError, client.RemoteAddr().String(), func(e *events.Event) { e.Detail = err.Error() })
+					opts.closeConn(route, tracked)
+					client.Close()
+					s.release()
+					return
+				}
+				logger.Debug("read PROXY protocol header", "address", conn.RemoteAddr(), "via", client.RemoteAddr())
+				s.admit(conn, id, logger)
+			}()
+			continue
+		}
+		s.admit(client, id, logger)
+	}
+}
+
+// admit decides how an accepted connection is served: reset, rejected, or
+// handled with or without the route's chaos. The connection must have been
+// acquired; admit releases it once it is done with. It is tracked as id,
+// and logged by logger.
+func (s *routeServer) admit(client net.Conn, id uint64, logger *slog.Logger) {
+	route, opts := s.route, s.opts
+	stats := opts.Stats
+
+	// Resets go to the TCP connection underneath any TLS.
+	tracked := opts.Control.track(id, client.RemoteAddr().String(), route.Upstream, func() { reset(client) })
+	stats.recordConnection()
+	opts.publish(route, events.ConnectionOpen, client.RemoteAddr().String(), nil)
+	if opts.Hooks.OnAccept != nil {
+		opts.Hooks.OnAccept(connInfo(route, tracked))
+	}
+
+	if !opts.Control.Enabled() {
+		logger.Info("route disabled, resetting connection", "address", client.RemoteAddr())
+		stats.recordFailure()
+		tracked.end(CloseDisabled)
+		reset(client)
+		opts.closeConn(route, tracked)
+		s.release()
+		return
+	}
+
+	if rate := route.MaxConnectionsPerSecond; rate > 0 {
+		if route.AcceptThrottle == config.AcceptThrottleReject {
+			if !s.limit.allow(rate, time.Now()) {
+				logger.Info("route over maxConnectionsPerSecond, turning connection away",
+					"address", client.RemoteAddr(),
+					"max_connections_per_second", rate)
+				s.turnAway(client, tracked, "throttle", fmt.Sprintf("maxConnectionsPerSecond %v", rate), false)
+				return
+			}
+		} else if wait := s.limit.reserve(rate, time.Now()); wait > 0 {
+			// Holding up admit holds up the accept loop, so connections
+			// behind this one wait in the listen backlog, as behind an
+			// upstream throttling SYNs.
+			logger.Debug("route over maxConnectionsPerSecond, delaying connection",
+				"address", client.RemoteAddr(),
+				"max_connections_per_second", rate,
+				"delay", wait)
+			opts.publishDelay(route, client.RemoteAddr().String(), "throttle", wait)
+			if !s.limit.sleep(wait) {
+				s.turnAway(client, tracked, "throttle", "route stopped", false)
+				return
+			}
+		}
+	}
+
+	if !s.limit.take(route.MaxConnections) {
+		if route.Overflow != config.OverflowQueue {
+			s.overflow(client, tracked, logger)
+			return
+		}
+		// Queued connections wait off the accept loop, which goes on
+		// turning the route's other connections away or queueing them.
+		logger.Debug("route at maxConnections, queueing connection", "address", client.RemoteAddr(), "max_connections", route.MaxConnections)
+		go func() {
+			if !s.limit.wait(route.MaxConnections, time.Duration(route.QueueTimeoutMs)*time.Millisecond) {
+				s.overflow(client, tracked, logger)
+				return
+			}
+			s.dispatch(client, tracked, logger)
+		}()
+		return
+	}
+	s.dispatch(client, tracked, logger)
+}
+
+// dispatch hands a connection that holds one of the route's connection
+// slots to a goroutine to handle it, with or without the route's chaos.
+func (s *routeServer) dispatch(client net.Conn, tracked *openConn, logger *slog.Logger) {
+	route, opts := s.route, s.opts
+	stats := opts.Stats
+
+	if !opts.Control.ChaosEnabled() {
+		logger.Debug("route chaos switched off, proxying without chaos", "address", client.RemoteAddr())
+		go s.handle(client, tracked, s.cleanRoute, chaos.Ritual{}, logger)
+		return
+	}
+
+	if !targetsClient(s.chaosClients, client.RemoteAddr()) {
+		logger.Debug("client outside chaosClients, proxying without chaos", "address", client.RemoteAddr())
+		go s.handle(client, tracked, s.cleanRoute, chaos.Ritual{}, logger)
+		return
+	}
+
+	if s.flap.IsDown(time.Now()) && opts.decide(logger, "flap", "route flapping down, rejecting connection", "address", client.RemoteAddr(), "upstream", route.Upstream) {
+		stats.recordFailure()
+		opts.publishFault(route, client.RemoteAddr().String(), "flap", "")
+		tracked.end("flap")
+		client.Close()
+		opts.closeConn(route, tracked)
+		s.limit.give()
+		s.release()
+		return
+	}
+
+	go s.handle(client, tracked, route, s.ritual, logger)
+}
+
+// overflow turns away a connection over the route's maxConnections, as
+// its overflow says: reset, or closed when rejected or when its wait in the
+// queue timed out.
+func (s *routeServer) overflow(client net.Conn, tracked *openConn, logger *slog.Logger) {
+	logger.Info("route at maxConnections, turning connection away",
+		"address", client.RemoteAddr(),
+		"max_connections", s.route.MaxConnections,
+		"overflow", s.route.Overflow)
+	s.turnAway(client, tracked, "overflow", fmt.Sprintf("maxConnections %d", s.route.MaxConnections), s.route.Overflow == config.OverflowReset)
+}
+
+// turnAway closes a connection the route won't serve, or resets it, as the
+// fault named.
+func (s *routeServer) turnAway(client net.Conn, tracked *openConn, fault, detail string, resetIt bool) {
+	route, opts := s.route, s.opts
+	opts.Stats.recordFailure()
+	opts.publishFault(route, client.RemoteAddr().String(), fault, detail)
+	tracked.end(fault)
+	if resetIt {
+		reset(client)
+	} else {
+		client.Close()
+	}
+	opts.closeConn(route, tracked)
+	s.release()
+}
+
+// handle serves one connection. Clean connections count toward the route's
+// load too, even though they aren't delayed themselves.
+func (s *routeServer) handle(client net.Conn, tracked *openConn, route config.RouteConfig, ritual chaos.Ritual, logger *slog.Logger) {
+	defer s.release()
+	defer s.limit.give()
+	defer s.opts.closeConn(route, tracked)
+	defer s.ritual.Load().Enter()()
+
+	// A connection dialled by another route in this process is the next hop
+	// on a chain of routes.
+	from := hops.lookup(s.addr, transportAddr(client).String())
+	if from.number() > 1 {
+		logger = logger.With("hop", from.number(), "chain", from.chain(s.port))
+	}
+	if from.number() > maxHops {
+		logger.Error("chained connection passed too many routes, closing it",
+			"address", client.RemoteAddr(),
+			"max_hops", maxHops,
+			"hint", "a route's upstream leads back to a route on the same chain; point the last route at a real upstream")
+		s.opts.Stats.recordFailure()
+		tracked.end(CloseHopLimit)
+		client.Close()
+		return
+	}
+
+	s.handleConnection(client, tracked, route, ritual, from.next(s.port), logger)
+}
+
+// targetsClient reports whether chaos applies to a client. An empty prefix
+// list targets everyone.
+func targetsClient(prefixes []netip.Prefix, addr net.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// newRitual builds the route's chaos settings. Stateful models live on the
+// ritual so they carry across the route's connections, as does the route's
+// source of random numbers, seeded from its seed if it has one.
+func newRitual(route config.RouteConfig, intensity *chaos.Intensity, memory *chaos.Memory) chaos.Ritual {
+	seed := rand.Int63()
+	if route.Seed != nil {
+		seed = *route.Seed
+	}
+	rng := chaos.NewRand(seed)
+	opts := []chaos.Option{
+		chaos.WithDropRate(route.DropRate),
+		chaos.WithLatency(time.Duration(route.LatencyMs) * time.Millisecond),
+		chaos.WithDialFailureRate(route.DialFailureRate),
+		chaos.WithConnectLatency(time.Duration(route.ConnectLatencyMs) * time.Millisecond),
+		chaos.WithFinDelay(time.Duration(route.FinDelayMs) * time.Millisecond),
+		chaos.WithIntensity(intensity),
+		chaos.WithRand(rng),
+	}
+	if route.HTTP != nil {
+		opts = append(opts, chaos.WithErrorRate(route.HTTP.ErrorRate))
+	}
+	if route.HTTP2 != nil {
+		opts = append(opts, chaos.WithResetRate(route.HTTP2.ResetRate))
+	}
+	if g := route.GRPC; g != nil {
+		opts = append(opts,
+			chaos.WithErrorRate(g.ErrorRate),
+			chaos.WithMessageDelay(time.Duration(g.MessageDelayMs)*time.Millisecond, g.MessageDelayRateOrDefault()))
+	}
+	if d := route.Database; d != nil {
+		opts = append(opts, chaos.WithErrorRate(d.KillQueryRate), chaos.WithResetRate(d.PartialResultRate))
+	}
+	if d := route.DNS; d != nil {
+		opts = append(opts,
+			chaos.WithErrorRate(d.ErrorRate),
+			chaos.WithMessageDelay(time.Duration(d.DelayMs)*time.Millisecond, d.DelayRateOrDefault()))
+	}
+	if route.DropMode == config.DropModeExact {
+		opts = append(opts, chaos.WithLoss(chaos.NewExactLoss(route.DropRate)))
+	}
+	if route.DropCooldownMs > 0 {
+		cooldown := chaos.NewCooldownLoss(route.DropRate, time.Duration(route.DropCooldownMs)*time.Millisecond)
+		cooldown.Rand = rng
+		opts = append(opts, chaos.WithLoss(cooldown))
+	}
+	if b := route.BurstLoss; b != nil {
+		burst := chaos.NewBurstLoss(b.GoodToBad, b.BadToGood, b.GoodDropRate, b.BadRate())
+		burst.Rand = rng
+		opts = append(opts, chaos.WithLoss(burst))
+	}
+	opts = append(opts, chaos.WithToxic(newPipeline(route, intensity, memory, rng)...))
+	if len(route.LoadLatency) > 0 {
+		curve := make([]chaos.LoadPoint, len(route.LoadLatency))
+		for i, p := range route.LoadLatency {
+			curve[i] = chaos.LoadPoint{Connections: p.Connections, Latency: time.Duration(p.LatencyMs) * time.Millisecond}
+		}
+		opts = append(opts, chaos.WithLoadLatency(curve))
+	}
+	if t := route.Tarpit; t != nil {
+		opts = append(opts, chaos.WithTarpit(chaos.NewTarpit(
+			time.Duration(t.WindowMs)*time.Millisecond,
+			t.Threshold,
+			time.Duration(t.DelayStepMs)*time.Millisecond,
+			time.Duration(t.MaxDelayMs)*time.Millisecond,
+			t.RejectAfter)))
+	}
+	return chaos.New(opts...)
+}
+
+// newPipeline builds the route's toxic pipeline. The flat reorder, coalesce
+// and duplicate options keep their historical order (data passes through
+// duplicate, then coalesce, then reorder), followed by firstByteLatencyMs,
+// the configured toxics and last the bandwidth cap, which the route's
+// connections share. Toxics that roll draw from rng, and those that hold
+// data back hold at most the route's maxBufferedBytes, reserved from memory.
+func newPipeline(route config.RouteConfig, intensity *chaos.Intensity, memory *chaos.Memory, rng *chaos.Rand) chaos.Pipeline {
+	maxBuffered := route.MaxBufferedBytesOrDefault()
+	var pipeline chaos.Pipeline
+	if route.DuplicateRate > 0 {
+		pipeline = append(pipeline, chaos.Stage{Toxic: chaos.DuplicateToxic{Rate: route.DuplicateRate, Rand: rng}, Toxicity: 1})
+	}
+	if route.CoalesceMs > 0 {
+		coalesceBytes := route.CoalesceBytes
+		if coalesceBytes == 0 {
+			coalesceBytes = chaos.DefaultCoalesceBytes
+		}
+		pipeline = append(pipeline, chaos.Stage{Toxic: chaos.CoalesceToxic{
+			Interval: time.Duration(route.CoalesceMs) * time.Millisecond,
+			MaxBytes: min(coalesceBytes, maxBuffered),
+			Memory:   memory,
+		}, Toxicity: 1})
+	}
+	if route.ReorderRate > 0 {
+		pipeline = append(pipeline, chaos.Stage{Toxic: chaos.ReorderToxic{Rate: route.ReorderRate, Window: route.ReorderWindow, Rand: rng, MaxBytes: maxBuffered, Memory: memory}, Toxicity: 1})
+	}
+
+	if route.FirstByteLatencyMs > 0 {
+		pipeline = append(pipeline, chaos.Stage{Toxic: chaos.FirstByteLatencyToxic{
+			Latency:   time.Duration(route.FirstByteLatencyMs) * time.Millisecond,
+			Intensity: intensity,
+		}, Stream: chaos.Downstream, Toxicity: 1})
+	}
+
+	for _, t := range route.Toxics {
+		stage := chaos.Stage{Toxicity: t.ToxicityOrDefault()}
+		switch t.Stream {
+		case config.StreamDownstream:
+			stage.Stream = chaos.Downstream
+		case config.StreamUpstream:
+			stage.Stream = chaos.Upstream
+		}
+
+		switch t.Type {
+		case config.ToxicLatency:
+			stage.Toxic = chaos.LatencyToxic{
+				Latency:   time.Duration(t.LatencyMs) * time.Millisecond,
+				Jitter:    time.Duration(t.JitterMs) * time.Millisecond,
+				Intensity: intensity,
+				Rand:      rng,
+			}
+		case config.ToxicBandwidth:
+			stage.Toxic = chaos.BandwidthToxic{BytesPerSecond: t.BytesPerSecond}
+		case config.ToxicCorrupt:
+			stage.Toxic = chaos.CorruptToxic{Rate: t.Rate, Rand: rng}
+		case config.ToxicTruncate:
+			stage.Toxic = chaos.TruncateToxic{Bytes: t.Bytes}
+		case config.ToxicDrop:
+			stage.Toxic = chaos.DropToxic{Rate: t.Rate, Intensity: intensity, Rand: rng}
+		case config.ToxicSegment:
+			minBytes, maxBytes := t.SegmentBytes()
+			stage.Toxic = chaos.SegmentToxic{
+				MinBytes: minBytes,
+				MaxBytes: maxBytes,
+				Pause:    time.Duration(t.LatencyMs) * time.Millisecond,
+				Rand:     rng,
+			}
+		default:
+			// Validation rejects unknown types before a route is served.
+			continue
+		}
+		pipeline = append(pipeline, stage)
+	}
+
+	if c := route.BandwidthCap; c != nil {
+		if c.UpstreamBytesPerSecond > 0 {
+			pipeline = append(pipeline, chaos.Stage{Toxic: chaos.BandwidthCapToxic{Cap: chaos.NewBandwidthCap(c.UpstreamBytesPerSecond)}, Stream: chaos.Upstream, Toxicity: 1})
+		}
+		if c.DownstreamBytesPerSecond > 0 {
+			pipeline = append(pipeline, chaos.Stage{Toxic: chaos.BandwidthCapToxic{Cap: chaos.NewBandwidthCap(c.DownstreamBytesPerSecond)}, Stream: chaos.Downstream, Toxicity: 1})
+		}
+	}
+	return pipeline
+}
+
+// newCurse rolls the connection's chaos, hashing the client address instead
+// of rolling randomly when the route asks for deterministic chaos.
+func newCurse(route config.RouteConfig, ritual chaos.Ritual, clientAddr string) chaos.Curse {
+	switch route.ChaosKey {
+	case config.ChaosKeyClientIP:
+		clientIP, _, _ := net.SplitHostPort(clientAddr)
+		return chaos.NewCurseFor(ritual, clientIP)
+	case config.ChaosKeyClientAddr:
+		return chaos.NewCurseFor(ritual, clientAddr)
+	default:
+		return chaos.NewCurse(ritual)
 	}
 }
 
-func handleConnection(client net.Conn, route config.RouteConfig, routeLogger *slog.Logger) {
+// handleConnection forwards client to the route's upstream. The upstream
+// connection is registered as hop next, for routes chained behind this one.
+func (s *routeServer) handleConnection(client net.Conn, tracked *openConn, route config.RouteConfig, ritual chaos.Ritual, next hop, routeLogger *slog.Logger) {
 	defer client.Close()
+	start := time.Now()
+	payload, match, opts := s.payload, s.match, s.opts
+	stats := opts.Stats
 
 	clientAddr := client.RemoteAddr().String()
 	routeLogger.Debug("handling new connection", "address", clientAddr, "upstream", route.Upstream)
 
-	server, err := net.Dial("tcp", route.Upstream)
+	// Resets go to the TCP connection underneath any TLS.
+	rawClient := client
+
+	if ritual.Tarpit() != nil {
+		clientIP, _, _ := net.SplitHostPort(clientAddr)
+		delay, reject := ritual.Tarpit().Check(clientIP, time.Now())
+		if reject && opts.decide(routeLogger, "tarpit", "tarpit rejecting rapid reconnect", "address", clientAddr, "upstream", route.Upstream) {
+			stats.recordFailure()
+			opts.publishFault(route, clientAddr, "tarpit", "rejected")
+			tracked.end("tarpit")
+			return
+		}
+		if delay > 0 && opts.decide(routeLogger, "tarpit", "tarpit delaying rapid reconnect", "address", clientAddr, "upstream", route.Upstream, "delay", delay) {
+			opts.publishDelay(route, clientAddr, "tarpit", delay)
+			time.Sleep(delay)
+		}
+	}
+
+	if s.serverTLS != nil {
+		conn := tls.Server(client, s.clientTLS(route, client, routeLogger))
+		if err := handshake(conn); errors.Is(err, errHandshakeAborted) {
+			stats.recordFailure()
+			tracked.end("tls_abort")
+			return
+		} else if err != nil {
+			routeLogger.Error("TLS handshake with client failed",
+				"address", clientAddr,
+				"error", err,
+				"hint", "the route terminates TLS: check that the client connects with TLS and trusts the route's certificate")
+			stats.recordFailure()
+			opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+			return
+		}
+		client = conn
+	}
+
+	// A SOCKS5 client names the upstream itself, and hears how dialling it
+	// went through answer.
+	answer := func(rep byte, bound net.Addr) {}
+	if route.Mode == config.ModeSOCKS5 {
+		dest, err := socksHandshake(client)
+		if err != nil {
+			routeLogger.Error("SOCKS5 handshake with client failed",
+				"address", clientAddr,
+				"error", err,
+				"hint", "the route is a SOCKS5 proxy: configure the client to use it without authentication, for CONNECT requests")
+			stats.recordFailure()
+			opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+			return
+		}
+		route.Upstream = dest
+		routeLogger.Debug("SOCKS5 client asked for destination", "address", clientAddr, "upstream", dest)
+		answer = func(rep byte, bound net.Addr) { socksReply(client, rep, bound) }
+	}
+	// A redirected client is headed wherever it dialled before it was.
+	if route.Mode == config.ModeTransparent {
+		dest, port, err := originalDestination(client, s.port)
+		if err != nil {
+			routeLogger.Error("failed to find original destination of redirected connection",
+				"address", clientAddr,
+				"error", err,
+				"hint", "the route is a transparent proxy: redirect traffic to it with iptables or nftables REDIRECT or TPROXY rules instead of dialling it directly")
+			stats.recordFailure()
+			opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+			return
+		}
+		route.Upstream = dest
+		routeLogger.Debug("redirected client's original destination", "address", clientAddr, "upstream", dest)
+		// Its chaos is the port rule's for the port it was headed to, if
+		// one has it. A clean connection's route has no rules.
+		if i := route.PortRuleFor(port); i >= 0 {
+			routeLogger.Debug("port rule picks redirected connection's chaos", "address", clientAddr, "upstream", dest, "ports", route.PortRules[i].Ports.String())
+			route, ritual = route.WithPortRule(i), s.portRituals[i]
+		}
+	}
+	if s.local != nil {
+		route.Upstream = s.local.addr()
+	}
+	tracked.setUpstream(route.Upstream)
+
+	// failover moves the connection to the fallback upstream when its dial
+	// of the upstream has failed, once enough have in a row. A connection
+	// fails over once, so the fallback's own failures aren't counted.
+	failedOver := false
+	failover := func(reason string) bool {
+		if failedOver || !s.failover() {
+			return false
+		}
+		routeLogger.Warn("failing over to fallback upstream",
+			"address", clientAddr,
+			"upstream", route.Upstream,
+			"fallback_upstream", route.FallbackUpstream,
+			"reason", reason,
+			"hint", "connections go to fallbackUpstream until a dial of upstream succeeds again")
+		stats.recordFailover()
+		route.Upstream = route.FallbackUpstream
+		tracked.setUpstream(route.Upstream)
+		failedOver = true
+		return true
+	}
+
+	if ritual.DialFails() && opts.decide(routeLogger, "dial_failure", "failing upstream dial", "address", clientAddr, "upstream", route.Upstream) {
+		opts.publishFault(route, clientAddr, "dial_failure", "")
+		if !failover("dial_failure") {
+			stats.recordFailure()
+			tracked.end("dial_failure")
+			answer(socksConnectionRefused, nil)
+			return
+		}
+	}
+
+	if delay := ritual.ConnectDelay(); delay > 0 && opts.decide(routeLogger, "connect_latency", "delaying upstream dial", "address", clientAddr, "upstream", route.Upstream, "delay", delay) {
+		opts.publishDelay(route, clientAddr, "connect_latency", delay)
+		time.Sleep(delay)
+	}
+
+	server, release, err := opts.dialUpstream(route, next, routeLogger)
+	if err != nil && failover(err.Error()) {
+		server, release, err = opts.dialUpstream(route, next, routeLogger)
+	} else if err == nil && !failedOver {
+		s.primaryFailures.Store(0)
+	}
 	if err != nil {
 		routeLogger.Error("failed to connect to upstream", "error", err, "hint", fmt.Sprintf("check that upstream server is running and reachable at %s", route.Upstream))
+		stats.recordFailure()
+		opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+		answer(socksReplyFor(err), nil)
 		return
 	}
+	defer release()
 	defer server.Close()
 
-	routeLogger.Info("successfully connected to upstream", "address", clientAddr, "upstream", route.Upstream)
+	// The header goes ahead of everything else, TLS included, and isn't
+	// subject to the route's chaos.
+	if pp := route.ProxyProtocol; pp != nil && pp.Send != "" {
+		if _, err := server.Write(proxyHeader(pp.Send, client.RemoteAddr(), client.LocalAddr())); err != nil {
+			routeLogger.Error("failed to send PROXY protocol header",
+				"address", clientAddr,
+				"upstream", route.Upstream,
+				"error", err,
+				"hint", "the upstream closed the connection straight away; check that it accepts connections from the proxy")
+			stats.recordFailure()
+			opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+			answer(socksGeneralFailure, nil)
+			return
+		}
+	}
+
+	rawServer := server
+	if s.upstreamTLS != nil {
+		conn := tls.Client(server, s.upstreamTLS)
+		if err := handshake(conn); err != nil {
+			routeLogger.Error("TLS handshake with upstream failed",
+				"address", clientAddr,
+				"upstream", route.Upstream,
+				"error", err,
+				"hint", "check that the upstream speaks TLS, that upstreamTLS.serverName and upstreamTLS.caFile match its certificate, and that upstreamTLS.certFile is one it trusts if it asks for a client certificate")
+			stats.recordFailure()
+			opts.publish(route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+			return
+		}
+		server = conn
+	}
+
+	sampled := opts.sampled(tracked.id)
+	if sampled {
+		routeLogger.Info("successfully connected to upstream", "address", clientAddr, "upstream", route.Upstream)
+	}
+	info := connInfo(route, tracked)
+	if opts.Hooks.OnUpstreamConnect != nil {
+		opts.Hooks.OnUpstreamConnect(info)
+	}
+	var rec *recording
+	if s.recorder != nil {
+		rec = s.recorder.open(clientAddr)
+	}
+	if rec != nil {
+		defer rec.close()
+	}
+	answer(socksSucceeded, server.LocalAddr())
 
-	ritual := chaos.Ritual{
-		DropRate:  route.DropRate,
-		LatencyMs: route.LatencyMs,
+	// In http, http2 and grpc modes drops and delays are rolled per request
+	// instead, and so is the script. Clean connections have no script.
+	perRequest := route.Mode == config.ModeHTTP || route.Mode == config.ModeHTTP2 || route.Mode == config.ModeGRPC
+	var rules *script.Script
+	if route.Script != "" {
+		rules = s.script
+	}
+	var curse chaos.Curse
+	if !perRequest {
+		curse = newCurse(route, ritual, clientAddr)
+		if rules != nil {
+			var carryOn bool
+			if client, carryOn = s.scriptConnection(client, tracked, route, routeLogger); !carryOn {
+				return
+			}
+		}
 	}
-	curse := chaos.NewCurse(ritual)
 
-	if curse.DropConnections {
-		routeLogger.Info("[CHAOS] dropping connections", "address", clientAddr, "upstream", route.Upstream)
+	if curse.DropConnections && opts.decide(routeLogger, "drop", "dropping connections", "address", clientAddr, "upstream", route.Upstream) {
+		stats.recordFailure()
+		opts.publishFault(route, clientAddr, "drop", "")
+		tracked.end("drop")
 		return
 	}
 
-	done := make(chan struct{}, 2)
-	bytesResults := make(chan bytesTransferred, 2)
+	keepAlive(rawClient)
+	keepAlive(rawServer)
+	readTimeout := time.Duration(route.ReadTimeoutMs) * time.Millisecond
+	writeTimeout := time.Duration(route.WriteTimeoutMs) * time.Millisecond
+	client = withDeadlines(client, readTimeout, writeTimeout)
+	server = withDeadlines(server, readTimeout, writeTimeout)
 
-	routeLogger.Debug("starting data forwarding", "address", clientAddr, "upstream", route.Upstream)
-	go func() {
-		if curse.StartDelay > 0 {
-			routeLogger.Info("[CHAOS] adding delay to upstream", "address", clientAddr, "upstream", route.Upstream, "delay", curse.StartDelay)
-			time.Sleep(curse.StartDelay)
-		}
-		written, _ := io.Copy(client, server)
-		bytesResults <- bytesTransferred{
-			direction: "to-client",
-			bytes:     written}
-		done <- struct{}{}
-	}()
+	var toClient, toServer io.Writer = client, server
+	if stats != nil {
+		toClient = &firstByteWriter{Writer: toClient, start: start, stats: stats}
+	}
+	// Directions no toxic wraps can be spliced.
+	plainToClient, plainToServer := toClient, toServer
+	// Toxics make their per-chunk decisions as data flows, so a dry run
+	// can only report which of them the connection would get.
+	toClient, toServer = ritual.Pipeline().Wrap(ritual.Rand(), toClient, toServer, func(stage chaos.Stage) bool {
+		if !opts.decide(routeLogger, stage.Toxic.Name(), "applying toxic", "address", clientAddr, "upstream", route.Upstream, "toxic", stage.Toxic.Name(), "stream", stage.Stream) {
+			return false
+		}
+		opts.publishFault(route, clientAddr, stage.Toxic.Name(), stage.Stream.String())
+		return true
+	})
 
-	go func() {
-		written, _ := io.Copy(server, client)
-		bytesResults <- bytesTransferred{
-			direction: "to-server",
-			bytes:     written}
-		done <- struct{}{}
-	}()
+	if route.MaxConnectionLifetimeMs > 0 {
+		lifetime := time.Duration(route.MaxConnectionLifetimeMs) * time.Millisecond
+		timer := time.AfterFunc(lifetime, func() {
+			if !opts.decide(routeLogger, "lifetime", "resetting connection at max lifetime", "address", clientAddr, "upstream", route.Upstream, "lifetime", lifetime) {
+				return
+			}
+			opts.publishFault(route, clientAddr, "lifetime", lifetime.String())
+			tracked.end("lifetime")
+			reset(rawClient)
+			reset(rawServer)
+		})
+		defer timer.Stop()
+	}
+
+	var idle *idleKiller
+	if route.IdleTimeoutMs > 0 {
+		timeout := time.Duration(route.IdleTimeoutMs) * time.Millisecond
+		release := time.Duration(route.IdleReleaseOrDefault()) * time.Millisecond
+		idle = newIdleKiller(timeout, release, rawClient, rawServer,
+			func() bool {
+				if !opts.decide(routeLogger, "idle", "connection idle, silently dropping it", "address", clientAddr, "upstream", route.Upstream, "idle_timeout", timeout) {
+					return false
+				}
+				opts.publishFault(route, clientAddr, "idle", timeout.String())
+				tracked.end("idle")
+				return true
+			},
+			func(released bool) {
+				if released {
+					routeLogger.Info("releasing idle-dropped connection nobody sent on", "address", clientAddr, "upstream", route.Upstream, "idle_release", release)
+					return
+				}
+				routeLogger.Info("[CHAOS] resetting idle-dropped connection on new traffic", "address", clientAddr, "upstream", route.Upstream)
+			})
+		defer idle.stop()
+	}
+
+	// When one side finishes sending, its FIN is passed on as a write
+	// shutdown (after the route's FIN delay) and the other direction keeps
+	// flowing, since some protocols half-close and then wait for the reply.
+	// Anything else, including a toxic severing the connection, takes both
+	// sides down so neither copy waits on a peer that will never hear back.
+	td := newTeardown(client, server, func() {
+		if delay := ritual.FinDelay(); delay > 0 && opts.decide(routeLogger, "fin_delay", "delaying FIN", "address", clientAddr, "upstream", route.Upstream, "delay", delay) {
+			opts.publishDelay(route, clientAddr, "fin_delay", delay)
+			time.Sleep(delay)
+		}
+	})
+	finish := func(dst net.Conn, err error) {
+		if errors.Is(err, chaos.ErrSevered) {
+			routeLogger.Info("[CHAOS] severing connection", "address", clientAddr, "upstream", route.Upstream)
+			tracked.end(CloseSevered)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			routeLogger.Info("connection timed out, closing it",
+				"address", clientAddr,
+				"upstream", route.Upstream,
+				"read_timeout", readTimeout,
+				"write_timeout", writeTimeout,
+				"hint", "a peer sent nothing for readTimeoutMs, or took no data for writeTimeoutMs; raise them if the protocol waits that long")
+			tracked.end(CloseTimeout)
+		}
+		td.end(dst, err)
+	}
+
+	var toClientSnippet, toServerSnippet *snippet
+	// source wraps the reads from one side, client or upstream, for the
+	// data hook, traffic counters, idle killer, payload log and recording.
+	source := func(src io.Reader, from string, captured **snippet) io.Reader {
+		if opts.Hooks.OnData != nil {
+			src = &hookReader{r: src, info: info, from: from, onData: opts.Hooks.OnData}
+		}
+		if from == fromClient {
+			src = counting(counting(src, &opts.Control.bytesToServer), &tracked.bytesToServer)
+		} else {
+			src = counting(counting(src, &opts.Control.bytesToClient), &tracked.bytesToClient)
+		}
+		if idle != nil {
+			src = idle.reader(src)
+		}
+		if rec != nil {
+			src = rec.reader(src, from)
+		}
+		if payload != nil {
+			src, *captured = payload.capture(src)
+		}
+		return src
+	}
+	// spliced counts what a spliced copy moved, as source's readers count
+	// what they read. A direction can only be spliced if nothing else
+	// reads it on the way.
+	spliced := func(from string) func(int64) {
+		total, conn := &opts.Control.bytesToClient, &tracked.bytesToClient
+		if from == fromClient {
+			total, conn = &opts.Control.bytesToServer, &tracked.bytesToServer
+		}
+		return func(n int64) {
+			total.Add(n)
+			conn.Add(n)
+		}
+	}
+	watched := idle != nil || rec != nil || payload != nil || opts.Hooks.OnData != nil
 
+	routeLogger.Debug("starting data forwarding", "address", clientAddr, "upstream", route.Upstream)
 	var bytesToClient, bytesToServer int64
-	for i := 0; i < 2; i++ {
-		result := <-bytesResults
-		if result.direction == "to-client" {
-			bytesToClient = result.bytes
+	switch route.Mode {
+	case config.ModeHTTP:
+		f := &httpForwarder{
+			route:      route,
+			ritual:     ritual,
+			match:      match,
+			script:     rules,
+			clientAddr: clientAddr,
+			logger:     routeLogger,
+			opts:       opts,
+			client:     client,
+			server:     server,
+			fromClient: bufio.NewReader(source(client, fromClient, &toServerSnippet)),
+			fromServer: bufio.NewReader(source(server, fromUpstream, &toClientSnippet)),
+			toClient:   &countingWriter{dst: toClient},
+			toServer:   &countingWriter{dst: toServer},
+		}
+		td.run(func() { finish(server, f.serve()) })
+		td.wait()
+		bytesToClient, bytesToServer = f.toClient.n.Load(), f.toServer.n.Load()
+	case config.ModeHTTP2, config.ModeGRPC:
+		f := &h2Forwarder{
+			route:      route,
+			ritual:     ritual,
+			script:     rules,
+			clientAddr: clientAddr,
+			logger:     routeLogger,
+			opts:       opts,
+			toClient:   &countingWriter{dst: toClient},
+			toServer:   &countingWriter{dst: toServer},
+		}
+		td.run(func() {
+			finish(server, f.serve(
+				&streamConn{Conn: client, r: source(client, fromClient, &toServerSnippet), w: f.toClient},
+				&streamConn{Conn: server, r: source(server, fromUpstream, &toClientSnippet), w: f.toServer}))
+		})
+		td.wait()
+		bytesToClient, bytesToServer = f.toClient.n.Load(), f.toServer.n.Load()
+	case config.ModePostgres, config.ModeMySQL:
+		db := &dbChaos{
+			route:      route,
+			ritual:     ritual,
+			clientAddr: clientAddr,
+			logger:     routeLogger,
+			opts:       opts,
+			kill: func() {
+				reset(rawClient)
+				reset(rawServer)
+			},
+		}
+		toClient, toServer := &countingWriter{dst: toClient}, &countingWriter{dst: toServer}
+		var queries, responses func() error
+		if route.Mode == config.ModePostgres {
+			f := newPGForwarder(db, source(client, fromClient, &toServerSnippet), source(server, fromUpstream, &toClientSnippet), toClient, toServer)
+			queries, responses = f.queries, f.responses
 		} else {
-			bytesToServer = result.bytes
+			f := newMySQLForwarder(db, source(client, fromClient, &toServerSnippet), source(server, fromUpstream, &toClientSnippet), toClient, toServer)
+			queries, responses = f.queries, f.responses
 		}
+		td.run(func() {
+			if curse.StartDelay > 0 && opts.decide(routeLogger, "latency", "adding delay to upstream", "address", clientAddr, "upstream", route.Upstream, "delay", curse.StartDelay) {
+				opts.publishDelay(route, clientAddr, "latency", curse.StartDelay)
+				time.Sleep(curse.StartDelay)
+			}
+			err := responses()
+			toClient.Flush()
+			finish(client, err)
+		})
+		td.run(func() {
+			err := queries()
+			toServer.Flush()
+			finish(server, err)
+		})
+		td.wait()
+		bytesToClient, bytesToServer = toClient.n.Load(), toServer.n.Load()
+	case config.ModeDNS:
+		f := &dnsForwarder{
+			dns:        newDNSChaos(route, ritual, clientAddr, routeLogger, opts),
+			fromClient: source(client, fromClient, &toServerSnippet),
+			fromServer: source(server, fromUpstream, &toClientSnippet),
+			toClient:   &countingWriter{dst: toClient},
+			toServer:   &countingWriter{dst: toServer},
+		}
+		td.run(func() {
+			if curse.StartDelay > 0 && opts.decide(routeLogger, "latency", "adding delay to upstream", "address", clientAddr, "upstream", route.Upstream, "delay", curse.StartDelay) {
+				opts.publishDelay(route, clientAddr, "latency", curse.StartDelay)
+				time.Sleep(curse.StartDelay)
+			}
+			err := f.responses()
+			chaos.Flush(f.toClient)
+			finish(client, err)
+		})
+		td.run(func() {
+			err := f.queries()
+			chaos.Flush(f.toServer)
+			finish(server, err)
+		})
+		td.wait()
+		bytesToClient, bytesToServer = f.toClient.n.Load(), f.toServer.n.Load()
+	default:
+		td.run(func() {
+			if curse.StartDelay > 0 && opts.decide(routeLogger, "latency", "adding delay to upstream", "address", clientAddr, "upstream", route.Upstream, "delay", curse.StartDelay) {
+				opts.publishDelay(route, clientAddr, "latency", curse.StartDelay)
+				time.Sleep(curse.StartDelay)
+			}
+			var err error
+			if dst, src, ok := spliceConns(client, server); ok && !watched && toClient == plainToClient {
+				routeLogger.Debug("splicing upstream to client", "address", clientAddr, "upstream", route.Upstream)
+				bytesToClient, err = spliceCopy(dst, src, toClient, route.BufferBytesOrDefault(), spliced(fromUpstream))
+			} else {
+				bytesToClient, err = copyBuffer(toClient, source(server, fromUpstream, &toClientSnippet), route.BufferBytesOrDefault())
+			}
+			chaos.Flush(toClient)
+			finish(client, err)
+		})
+		td.run(func() {
+			var err error
+			if dst, src, ok := spliceConns(server, client); ok && !watched && !route.CaptureClientHello && toServer == plainToServer {
+				routeLogger.Debug("splicing client to upstream", "address", clientAddr, "upstream", route.Upstream)
+				bytesToServer, err = spliceCopy(dst, src, toServer, route.BufferBytesOrDefault(), spliced(fromClient))
+			} else {
+				var src io.Reader = client
+				if route.CaptureClientHello {
+					src = captureClientHello(client, route, routeLogger, opts)
+				}
+				bytesToServer, err = copyBuffer(toServer, source(src, fromClient, &toServerSnippet), route.BufferBytesOrDefault())
+			}
+			chaos.Flush(toServer)
+			finish(server, err)
+		})
+		td.wait()
 	}
 
 	totalBytes := bytesToClient + bytesToServer
-	routeLogger.Info(fmt.Sprintf("bytes transferred: %d", totalBytes),
-		"bytes_to_client", bytesToClient,
-		"bytes_to_server", bytesToServer)
+	stats.recordBytes(totalBytes)
+	if sampled {
+		routeLogger.Info(fmt.Sprintf("bytes transferred: %d", totalBytes),
+			"bytes_to_client", bytesToClient,
+			"bytes_to_server", bytesToServer)
+	}
+	if payload != nil {
+		payload.log(routeLogger, clientAddr, "to-server", toServerSnippet)
+		payload.log(routeLogger, clientAddr, "to-client", toClientSnippet)
+	}
 
 	routeLogger.Debug("connection closed", "address", clientAddr, "upstream", route.Upstream)
+	opts.publish(route, events.ConnectionClose, clientAddr, func(e *events.Event) {
+		e.BytesToClient = bytesToClient
+		e.BytesToServer = bytesToServer
+	})
+}
+
+// reset closes c with an RST instead of a FIN where possible, the way a load
+// balancer or NAT gateway tears down a connection it has timed out.
+func reset(c net.Conn) {
+	if tcp, ok := c.(interface{ SetLinger(int) error }); ok {
+		tcp.SetLinger(0)
+	}
+	c.Close()
+}
+
+// sampled reports whether the routine lines of connection id are logged,
+// per LogSample.
+func (o ServeOptions) sampled(id uint64) bool {
+	return o.LogSample <= 1 || id%uint64(o.LogSample) == 0
+}
+
+// decide logs a chaos decision, the fault named fault, and reports whether
+// to carry it out. In a dry run, or if OnDecision vetoes it, the decision is
+// only logged.
+func (o ServeOptions) decide(logger *slog.Logger, fault, msg string, args ...any) bool {
+	if o.DryRun {
+		logger.Info("[CHAOS DRY RUN] "+msg, args...)
+		return false
+	}
+	if o.Hooks.OnDecision != nil && !o.Hooks.OnDecision(o.decision(fault, msg, args)) {
+		logger.Info("[CHAOS VETOED] "+msg, args...)
+		return false
+	}
+	logger.Info("[CHAOS] "+msg, args...)
+	return true
+}
+
+// listening records that route is bound to addr, on port, and calls
+// OnListen. It gives the route a control of its own if it has none.
+func (o *ServeOptions) listening(route config.RouteConfig, addr net.Addr, port int, logger *slog.Logger) {
+	logger.Debug("listener started successfully", "address", addr)
+	if o.Control == nil {
+		o.Control = NewRouteControl(route)
+	}
+	o.Control.port.Store(int64(port))
+	if !o.Control.Enabled() {
+		logger.Info("route is disabled, resetting its connections until it is enabled", "address", addr)
+	}
+	if o.OnListen != nil {
+		o.OnListen(addr)
+	}
+}
+
+// maxDialBackoff caps the wait between retries of a failed upstream dial
+// as it doubles.
+const maxDialBackoff = 30 * time.Second
+
+// dialUpstream dials route's upstream as hop h, giving up on each attempt
+// after dialTimeoutMs and retrying a failed one up to dialRetries times,
+// with the wait before each retry doubling from dialBackoffMs.
+func (o ServeOptions) dialUpstream(route config.RouteConfig, h hop, logger *slog.Logger) (net.Conn, func(), error) {
+	timeout := time.Duration(route.DialTimeoutMs) * time.Millisecond
+	backoff := time.Duration(route.DialBackoffMs) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		// The retries wait outside the registry, so connections accepted
+		// meanwhile aren't held up looking for a hop.
+		conn, release, err := hops.dial(route.Upstream, h, func() (net.Conn, error) {
+			return o.dial(route.Upstream, timeout)
+		})
+		if err == nil || attempt > route.DialRetries {
+			return conn, release, err
+		}
+		logger.Warn("failed to connect to upstream, retrying",
+			"upstream", route.Upstream,
+			"attempt", attempt,
+			"retries", route.DialRetries,
+			"backoff", backoff,
+			"error", err,
+			"hint", "the upstream may be down or slow to accept; dialTimeoutMs, dialRetries and dialBackoffMs set how long the proxy keeps trying")
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxDialBackoff)
+	}
+}
+
+// dial connects to a route's upstream at address, with Dial if it is set,
+// giving up after timeout unless it is 0.
+func (o ServeOptions) dial(address string, timeout time.Duration) (net.Conn, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if o.Dial != nil {
+		return o.Dial(ctx, "tcp", address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// publish sends a connection event for route, letting fill add
+// type-specific fields. An error is also recorded as why the connection
+// closes, events or not.
+func (o ServeOptions) publish(route config.RouteConfig, eventType events.Type, clientAddr string, fill func(*events.Event)) {
+	if o.Events == nil && eventType != events.ConnectionError {
+		return
+	}
+
+	e := events.Event{
+		Type:     eventType,
+		Port:     route.LocalPort,
+		Route:    route.Name,
+		Tenant:   route.Tenant,
+		Tags:     route.Tags,
+		Client:   clientAddr,
+		Upstream: route.Upstream,
+		Conn:     o.Control.connID(clientAddr),
+	}
+	if fill != nil {
+		fill(&e)
+	}
+	if eventType == events.ConnectionError {
+		o.Control.failed(clientAddr, e.Detail)
+	}
+	if o.Events != nil {
+		o.Events.Publish(e)
+	}
+}
+
+// publishDelay publishes a fault that delayed the connection, recording the
+// delay for the route's stats.
+func (o ServeOptions) publishDelay(route config.RouteConfig, clientAddr, fault string, delay time.Duration) {
+	o.Stats.recordDelay(fault, delay)
+	o.publishFault(route, clientAddr, fault, delay.String())
+}
 
-	<-done
+func (o ServeOptions) publishFault(route config.RouteConfig, clientAddr, fault, detail string) {
+	o.Stats.recordFault(fault)
+	o.Control.applied(clientAddr, fault)
+	o.publish(route, events.Fault, clientAddr, func(e *events.Event) {
+		e.Fault = fault
+		e.Detail = detail
+	})
 }
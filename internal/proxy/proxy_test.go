@@ -1,16 +1,24 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
 )
 
 // TestMain sets up a silent logger for all tests to avoid cluttering test output
@@ -267,6 +275,178 @@ func TestUpstreamUnreachable(t *testing.T) {
 	}
 }
 
+func TestFallbackUpstream(t *testing.T) {
+	tests := []struct {
+		name                  string
+		deadUpstream          bool
+		dialFailureRate       float64
+		fallbackAfterFailures int
+		// wantEcho is whether each connection in turn gets an echo.
+		wantEcho      []bool
+		wantFailures  int64
+		wantFailovers int64
+	}{
+		{
+			name:          "unreachable upstream fails over at once",
+			deadUpstream:  true,
+			wantEcho:      []bool{true, true},
+			wantFailovers: 2,
+		},
+		{
+			name:                  "unreachable upstream fails over after two failures",
+			deadUpstream:          true,
+			fallbackAfterFailures: 2,
+			wantEcho:              []bool{false, true, true},
+			wantFailures:          1,
+			wantFailovers:         2,
+		},
+		{
+			name:            "injected dial failure fails over",
+			dialFailureRate: 1.0,
+			wantEcho:        []bool{true},
+			wantFailovers:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := startTestEchoServer(t)
+			defer fallback.Close()
+			upstream := fmt.Sprintf("127.0.0.1:%d", findFreePort(t))
+			if !tt.deadUpstream {
+				primary := startTestEchoServer(t)
+				defer primary.Close()
+				upstream = primary.Addr().String()
+			}
+
+			route := config.RouteConfig{
+				LocalPort:             findFreePort(t),
+				Upstream:              upstream,
+				FallbackUpstream:      fallback.Addr().String(),
+				FallbackAfterFailures: tt.fallbackAfterFailures,
+				DialFailureRate:       tt.dialFailureRate,
+			}
+			stats := &RouteStats{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, route, ServeOptions{Stats: stats})
+			time.Sleep(50 * time.Millisecond)
+
+			for i, want := range tt.wantEcho {
+				client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+				if err != nil {
+					t.Fatalf("failed to connect to proxy: %v", err)
+				}
+				client.SetDeadline(time.Now().Add(time.Second))
+				client.Write([]byte("ping"))
+				_, err = io.ReadFull(client, make([]byte, 4))
+				client.Close()
+				if got := err == nil; got != want {
+					t.Errorf("connection %d echoed = %v (%v), want %v", i, got, err, want)
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			snapshot := stats.Snapshot()
+			if snapshot.Failures != tt.wantFailures {
+				t.Errorf("Failures = %d, want %d", snapshot.Failures, tt.wantFailures)
+			}
+			if snapshot.Failovers != tt.wantFailovers {
+				t.Errorf("Failovers = %d, want %d", snapshot.Failovers, tt.wantFailovers)
+			}
+		})
+	}
+}
+
+func TestDialRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		dialTimeoutMs config.Milliseconds
+		dialRetries   int
+		// failures is how many dials fail before one connects; -1 hangs
+		// every dial until its context ends.
+		failures     int
+		wantEcho     bool
+		wantAttempts int32
+		// maxElapsed bounds how long the connection takes to echo or fail.
+		maxElapsed time.Duration
+	}{
+		{
+			name:         "retries until a dial connects",
+			dialRetries:  2,
+			failures:     2,
+			wantEcho:     true,
+			wantAttempts: 3,
+			maxElapsed:   time.Second,
+		},
+		{
+			name:         "gives up once retries run out",
+			dialRetries:  1,
+			failures:     2,
+			wantAttempts: 2,
+			maxElapsed:   time.Second,
+		},
+		{
+			name:          "times out hanging dials",
+			dialTimeoutMs: 50,
+			dialRetries:   1,
+			failures:      -1,
+			wantAttempts:  2,
+			maxElapsed:    500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			var attempts atomic.Int32
+			dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+				switch n := attempts.Add(1); {
+				case tt.failures < 0:
+					<-ctx.Done()
+					return nil, ctx.Err()
+				case int(n) <= tt.failures:
+					return nil, errors.New("connection refused")
+				}
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			}
+			route := config.RouteConfig{
+				LocalPort:     findFreePort(t),
+				Upstream:      upstream.Addr().String(),
+				DialTimeoutMs: tt.dialTimeoutMs,
+				DialRetries:   tt.dialRetries,
+				DialBackoffMs: 10,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, route, ServeOptions{Dial: dial})
+			time.Sleep(50 * time.Millisecond)
+
+			start := time.Now()
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+			client.SetDeadline(time.Now().Add(2 * time.Second))
+			client.Write([]byte("ping"))
+			_, err = io.ReadFull(client, make([]byte, 4))
+			if got := err == nil; got != tt.wantEcho {
+				t.Errorf("echoed = %v (%v), want %v", got, err, tt.wantEcho)
+			}
+			if elapsed := time.Since(start); elapsed > tt.maxElapsed {
+				t.Errorf("connection took %v, want at most %v", elapsed, tt.maxElapsed)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("dialled %d times, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
 // TestConnectionCleanup tests that connections are properly closed
 func TestConnectionCleanup(t *testing.T) {
 	upstream := startTestEchoServer(t)
@@ -421,7 +601,7 @@ func TestDropRate(t *testing.T) {
 func TestLatency(t *testing.T) {
 	tests := []struct {
 		name      string
-		latencyMs int
+		latencyMs config.Milliseconds
 	}{
 		{
 			name:      "no latency",
@@ -503,6 +683,295 @@ func TestLatency(t *testing.T) {
 	}
 }
 
+// TestConnectLatency tests that connectLatencyMs delays the upstream dial
+// but not the data path once connected.
+func TestConnectLatency(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	defer upstream.Close()
+
+	accepted := make(chan time.Time, 1)
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- time.Now()
+		handleEcho(conn)
+	}()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:        proxyPort,
+		Upstream:         upstream.Addr().String(),
+		ConnectLatencyMs: 150,
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	select {
+	case at := <-accepted:
+		if dialDelay := at.Sub(start); dialDelay < 130*time.Millisecond {
+			t.Errorf("upstream saw the connection after %v, want at least 150ms", dialDelay)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream never saw the connection")
+	}
+
+	// Once connected, data flows without the dial delay.
+	roundTripStart := time.Now()
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+	if elapsed := time.Since(roundTripStart); elapsed > 100*time.Millisecond {
+		t.Errorf("round trip after connect took %v, want no added delay", elapsed)
+	}
+}
+
+// TestDialFailure tests that dialFailureRate closes the client without the
+// upstream ever seeing a connection, unlike dropRate.
+func TestDialFailure(t *testing.T) {
+	tests := []struct {
+		name             string
+		route            config.RouteConfig
+		wantUpstreamConn bool
+	}{
+		{
+			name:             "dial failure never reaches upstream",
+			route:            config.RouteConfig{DialFailureRate: 1.0},
+			wantUpstreamConn: false,
+		},
+		{
+			name:             "drop connects before closing",
+			route:            config.RouteConfig{DropRate: 1.0},
+			wantUpstreamConn: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to start upstream: %v", err)
+			}
+			defer upstream.Close()
+
+			upstreamConns := make(chan struct{}, 1)
+			go func() {
+				conn, err := upstream.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+				upstreamConns <- struct{}{}
+			}()
+
+			route := tt.route
+			route.LocalPort = findFreePort(t)
+			route.Upstream = upstream.Addr().String()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ListenAndServeRoute(ctx, route)
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := client.Read(make([]byte, 1)); err == nil {
+				t.Fatal("expected the client connection to be closed")
+			}
+
+			select {
+			case <-upstreamConns:
+				if !tt.wantUpstreamConn {
+					t.Error("upstream received a connection, want none")
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.wantUpstreamConn {
+					t.Error("upstream never received a connection")
+				}
+			}
+		})
+	}
+}
+
+// TestFirstByteLatency tests that firstByteLatencyMs delays the first
+// response, however long after connecting it comes, and nothing after it.
+func TestFirstByteLatency(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:          proxyPort,
+		Upstream:           upstream.Addr().String(),
+		FirstByteLatencyMs: 150,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ListenAndServeRoute(ctx, route)
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	// An idle client doesn't use up the delay before its first request.
+	time.Sleep(200 * time.Millisecond)
+
+	roundTrip := func() time.Duration {
+		start := time.Now()
+		client.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatalf("failed to read echo: %v", err)
+		}
+		return time.Since(start)
+	}
+
+	if got := roundTrip(); got < 130*time.Millisecond {
+		t.Errorf("first round trip = %v, want at least 150ms", got)
+	}
+	if got := roundTrip(); got > 100*time.Millisecond {
+		t.Errorf("second round trip = %v, want no added delay", got)
+	}
+}
+
+// TestFinDelay tests that a client's FIN reaches the upstream, held back by
+// finDelayMs when set.
+func TestFinDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		finDelayMs config.Milliseconds
+		wantMin    time.Duration
+		wantMax    time.Duration
+	}{
+		{name: "close propagates immediately", finDelayMs: 0, wantMin: 0, wantMax: 100 * time.Millisecond},
+		{name: "close is delayed", finDelayMs: 200, wantMin: 180 * time.Millisecond, wantMax: 600 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to start upstream: %v", err)
+			}
+			defer upstream.Close()
+
+			closed := make(chan time.Time, 1)
+			go func() {
+				conn, err := upstream.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+				closed <- time.Now()
+			}()
+
+			route := config.RouteConfig{
+				LocalPort:  findFreePort(t),
+				Upstream:   upstream.Addr().String(),
+				FinDelayMs: tt.finDelayMs,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ListenAndServeRoute(ctx, route)
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			client.Write([]byte("bye"))
+			time.Sleep(50 * time.Millisecond)
+
+			start := time.Now()
+			client.Close()
+
+			select {
+			case at := <-closed:
+				if elapsed := at.Sub(start); elapsed < tt.wantMin || elapsed > tt.wantMax {
+					t.Errorf("upstream saw the close after %v, want %v-%v", elapsed, tt.wantMin, tt.wantMax)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("upstream never saw the client's close")
+			}
+		})
+	}
+}
+
+// TestHalfClose tests that a client's write shutdown reaches the upstream
+// as a FIN while the response still flows back, HTTP/1.0 style.
+func TestHalfClose(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := io.ReadAll(conn)
+		conn.Write([]byte("reply to " + string(request)))
+	}()
+
+	route := config.RouteConfig{
+		LocalPort: findFreePort(t),
+		Upstream:  upstream.Addr().String(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ListenAndServeRoute(ctx, route)
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	client.Write([]byte("request"))
+	if err := client.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite() error = %v", err)
+	}
+
+	reply, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	if string(reply) != "reply to request" {
+		t.Errorf("reply = %q, want %q", reply, "reply to request")
+	}
+}
+
 // TestChaosCombined tests both drop rate and latency together
 func TestChaosCombined(t *testing.T) {
 	upstream := startTestEchoServer(t)
@@ -702,42 +1171,1139 @@ func TestBytesTransferred(t *testing.T) {
 	}
 }
 
-// Helper Functions
-
-// startTestEchoServer starts a simple echo server for testing
-func startTestEchoServer(t *testing.T) net.Listener {
-	t.Helper()
+// TestFlapping tests that connections are rejected during flap downtime
+func TestFlapping(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("failed to start test echo server: %v", err)
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:      proxyPort,
+		Upstream:       upstream.Addr().String(),
+		FlapIntervalMs: 200,
+		FlapDowntimeMs: 200,
 	}
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				return // Listener closed
-			}
-			go handleEcho(conn)
-		}
-	}()
-
-	return listener
-}
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
 
-// handleEcho echoes back everything it receives
-func handleEcho(conn net.Conn) {
-	defer conn.Close()
-	buf := make([]byte, 32768)
-	for {
-		n, err := conn.Read(buf)
+	roundTrip := func() error {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
 		if err != nil {
-			return
+			return err
 		}
-		_, err = conn.Write(buf[:n])
-		if err != nil {
-			return
+		defer client.Close()
+
+		client.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := client.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(client, buf)
+		return err
+	}
+
+	// Healthy window: 0-200ms after listener start
+	if err := roundTrip(); err != nil {
+		t.Fatalf("expected healthy route during flap interval, got %v", err)
+	}
+
+	// Downtime window: 200-400ms after listener start
+	time.Sleep(250 * time.Millisecond)
+	if err := roundTrip(); err == nil {
+		t.Error("expected connection to fail during flap downtime, but it succeeded")
+	}
+}
+
+// TestReorder tests that reordered chunks are all delivered
+func TestReorder(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:     proxyPort,
+		Upstream:      upstream.Addr().String(),
+		ReorderRate:   1.0,
+		ReorderWindow: 2,
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	for _, chunk := range []string{"AAAA", "BBBB"} {
+		if _, err := client.Write([]byte(chunk)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	buf := make([]byte, 8)
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read reordered data: %v", err)
+	}
+
+	got := string(buf)
+	if got != "AAAABBBB" && got != "BBBBAAAA" {
+		t.Errorf("reordered data = %q, want both chunks intact", got)
+	}
+}
+
+// TestToxicTruncate tests that a truncate toxic cuts the stream and closes
+// the client connection
+func TestToxicTruncate(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort: proxyPort,
+		Upstream:  upstream.Addr().String(),
+		Toxics: []config.ToxicConfig{
+			{Type: config.ToxicTruncate, Bytes: 4, Stream: config.StreamDownstream},
+		},
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("AAAABBBB")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("expected the proxy to close the connection, got %v", err)
+	}
+	if string(got) != "AAAA" {
+		t.Errorf("truncated data = %q, want %q", got, "AAAA")
+	}
+}
+
+// TestMaxConnectionLifetime tests that long-lived connections are reset once
+// they reach the route's maximum lifetime
+func TestMaxConnectionLifetime(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:               proxyPort,
+		Upstream:                upstream.Addr().String(),
+		MaxConnectionLifetimeMs: 200,
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	// The connection works normally before the lifetime is up.
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 4)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read echo before lifetime: %v", err)
+	}
+
+	// Then the proxy tears it down.
+	if _, err := client.Read(buf); err == nil {
+		t.Fatal("expected the connection to be closed at max lifetime")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("connection closed after %v, want about 200ms", elapsed)
+	}
+}
+
+func TestLoadLatency(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort: proxyPort,
+		Upstream:  upstream.Addr().String(),
+		LoadLatency: []config.LoadPoint{
+			{Connections: 0, LatencyMs: 0},
+			{Connections: 3, LatencyMs: 300},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ListenAndServeRoute(ctx, route)
+	time.Sleep(50 * time.Millisecond)
+
+	roundTrip := func(conn net.Conn) time.Duration {
+		t.Helper()
+		start := time.Now()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		buf := make([]byte, 4)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("failed to read echo: %v", err)
+		}
+		return time.Since(start)
+	}
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// Alone on the route: one connection, about 100ms.
+	client := dial()
+	if got := roundTrip(client); got < 80*time.Millisecond || got > 250*time.Millisecond {
+		t.Errorf("round trip with 1 connection = %v, want about 100ms", got)
+	}
+
+	// Two more connections push the route to the top of the curve.
+	dial()
+	dial()
+	time.Sleep(50 * time.Millisecond)
+	if got := roundTrip(client); got < 280*time.Millisecond || got > 600*time.Millisecond {
+		t.Errorf("round trip with 3 connections = %v, want about 300ms", got)
+	}
+}
+
+// TestServeRoute_Stats tests that connection outcomes are recorded
+func TestServeRoute_Stats(t *testing.T) {
+	tests := []struct {
+		name         string
+		dropRate     float64
+		wantFailures int64
+		wantFirst    bool
+		wantFaults   map[string]int64
+	}{
+		{
+			name:         "successful connection",
+			dropRate:     0.0,
+			wantFailures: 0,
+			wantFirst:    true,
+		},
+		{
+			name:         "dropped connection",
+			dropRate:     1.0,
+			wantFailures: 1,
+			wantFirst:    false,
+			wantFaults:   map[string]int64{"drop": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			proxyPort := findFreePort(t)
+			route := config.RouteConfig{
+				LocalPort: proxyPort,
+				Upstream:  upstream.Addr().String(),
+				DropRate:  tt.dropRate,
+			}
+
+			stats := &RouteStats{}
+			go ServeRoute(context.Background(), route, ServeOptions{Stats: stats})
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			client.SetDeadline(time.Now().Add(500 * time.Millisecond))
+			client.Write([]byte("ping"))
+			io.ReadFull(client, make([]byte, 4))
+			client.Close()
+			time.Sleep(50 * time.Millisecond)
+
+			snapshot := stats.Snapshot()
+			if snapshot.Connections != 1 {
+				t.Errorf("Connections = %d, want 1", snapshot.Connections)
+			}
+			if snapshot.Failures != tt.wantFailures {
+				t.Errorf("Failures = %d, want %d", snapshot.Failures, tt.wantFailures)
+			}
+			if (snapshot.AvgFirstByte > 0) != tt.wantFirst {
+				t.Errorf("AvgFirstByte = %v, want recorded = %v", snapshot.AvgFirstByte, tt.wantFirst)
+			}
+			if !maps.Equal(snapshot.Faults, tt.wantFaults) {
+				t.Errorf("Faults = %v, want %v", snapshot.Faults, tt.wantFaults)
+			}
+		})
+	}
+}
+
+// TestServeRoute_OnListen tests that the bound address is published for auto-assigned ports
+func TestServeRoute_OnListen(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{
+		LocalPort: 0,
+		Upstream:  upstream.Addr().String(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{
+		OnListen: func(addr net.Addr) { bound <- addr },
+	})
+
+	var addr net.Addr
+	select {
+	case addr = <-bound:
+	case <-time.After(1 * time.Second):
+		t.Fatal("OnListen was not called")
+	}
+
+	if addr.(*net.TCPAddr).Port == 0 {
+		t.Fatal("OnListen reported port 0, want the OS-assigned port")
+	}
+
+	client, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to connect to published address: %v", err)
+	}
+	defer client.Close()
+
+	client.SetDeadline(time.Now().Add(1 * time.Second))
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("failed to read through published address: %v", err)
+	}
+}
+
+// pipeListener is an in-memory net.Listener whose connections are made by
+// dial, over net.Pipe.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+func (l *pipeListener) dial(context.Context, string, string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// TestServeRoute_ListenerAndDial tests that a route serves a listener and
+// dials its upstream as given, here both in memory
+func TestServeRoute_ListenerAndDial(t *testing.T) {
+	front, upstream := newPipeListener(), newPipeListener()
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go handleEcho(conn)
+		}
+	}()
+
+	var dialed []string
+	var mu sync.Mutex
+	route := config.RouteConfig{LocalPort: 8080, Upstream: "10.0.0.1:5432", LatencyMs: 10}
+	ctx, cancel := context.WithCancel(context.Background())
+	control := NewRouteControl(route)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeRoute(ctx, route, ServeOptions{
+			Control:  control,
+			Listener: front,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				mu.Lock()
+				dialed = append(dialed, network+" "+address)
+				mu.Unlock()
+				return upstream.dial(ctx, network, address)
+			},
+		})
+	}()
+
+	client, err := front.dial(context.Background(), "", "")
+	if err != nil {
+		t.Fatalf("failed to connect through the listener: %v", err)
+	}
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v through the route, want the echo", buf, err)
+	}
+	client.Close()
+
+	mu.Lock()
+	if !reflect.DeepEqual(dialed, []string{"tcp 10.0.0.1:5432"}) {
+		t.Errorf("dialed %v, want the route's upstream once", dialed)
+	}
+	mu.Unlock()
+	if got := control.Port(); got != 8080 {
+		t.Errorf("Port() = %d, want the route's localPort for a listener without one", got)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeRoute() = %v, want nil once stopped", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeRoute did not return after cancel")
+	}
+	select {
+	case <-front.done:
+	default:
+		t.Error("listener left open after the route stopped")
+	}
+}
+
+// TestServeRoute_Events tests that connection events are published
+func TestServeRoute_Events(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort: proxyPort,
+		Upstream:  upstream.Addr().String(),
+		DropRate:  1.0,
+	}
+
+	bus := events.NewBus()
+	sub, unsubscribe := bus.Subscribe(16)
+	defer unsubscribe()
+
+	go ServeRoute(context.Background(), route, ServeOptions{Events: bus})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	var conn uint64
+	wantTypes := []events.Type{events.ConnectionOpen, events.Fault}
+	for _, want := range wantTypes {
+		select {
+		case e := <-sub:
+			if e.Type != want {
+				t.Fatalf("event type = %q, want %q", e.Type, want)
+			}
+			if e.Port != proxyPort {
+				t.Errorf("event port = %d, want %d", e.Port, proxyPort)
+			}
+			if e.Type == events.Fault && e.Fault != "drop" {
+				t.Errorf("fault = %q, want drop", e.Fault)
+			}
+			if e.Conn == 0 || (conn != 0 && e.Conn != conn) {
+				t.Errorf("%q event conn = %d, want the connection's ID, %d", e.Type, e.Conn, conn)
+			}
+			conn = e.Conn
+		case <-time.After(1 * time.Second):
+			t.Fatalf("timed out waiting for %q event", want)
+		}
+	}
+
+	second, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer second.Close()
+	select {
+	case e := <-sub:
+		if e.Conn == 0 || e.Conn == conn {
+			t.Errorf("second connection's conn = %d, want an ID other than the first's, %d", e.Conn, conn)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for the second connection's event")
+	}
+}
+
+// TestTarpit tests that rapid reconnects are rejected once over the limit
+func TestTarpit(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort: proxyPort,
+		Upstream:  upstream.Addr().String(),
+		Tarpit:    &config.TarpitConfig{WindowMs: 5000, Threshold: 1, RejectAfter: 2},
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	roundTrip := func() error {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		client.SetDeadline(time.Now().Add(500 * time.Millisecond))
+		if _, err := client.Write([]byte("ping")); err != nil {
+			return err
+		}
+		_, err = io.ReadFull(client, make([]byte, 4))
+		return err
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := roundTrip(); err != nil {
+			t.Fatalf("connection %d failed within tarpit limit: %v", i, err)
+		}
+	}
+	if err := roundTrip(); err == nil {
+		t.Error("expected third rapid connection to be rejected by tarpit")
+	}
+}
+
+// TestDropModeExact tests that exact mode drops precisely dropRate of connections
+func TestDropModeExact(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort: proxyPort,
+		Upstream:  upstream.Addr().String(),
+		DropRate:  0.5,
+		DropMode:  config.DropModeExact,
+	}
+
+	go ListenAndServeRoute(context.Background(), route)
+	time.Sleep(50 * time.Millisecond)
+
+	drops := 0
+	for i := 0; i < 10; i++ {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+
+		client.SetDeadline(time.Now().Add(500 * time.Millisecond))
+		client.Write([]byte("ping"))
+		if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+			drops++
+		}
+		client.Close()
+	}
+
+	if drops != 5 {
+		t.Errorf("drops = %d out of 10, want exactly 5", drops)
+	}
+}
+
+// TestChaosClients tests that chaos only applies to clients in chaosClients
+func TestChaosClients(t *testing.T) {
+	tests := []struct {
+		name         string
+		chaosClients []string
+		wantDropped  bool
+	}{
+		{
+			name:         "client inside range",
+			chaosClients: []string{"127.0.0.0/8"},
+			wantDropped:  true,
+		},
+		{
+			name:         "client matches single IP",
+			chaosClients: []string{"127.0.0.1"},
+			wantDropped:  true,
+		},
+		{
+			name:         "client outside range",
+			chaosClients: []string{"10.2.0.0/16"},
+			wantDropped:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startTestEchoServer(t)
+			defer upstream.Close()
+
+			proxyPort := findFreePort(t)
+			route := config.RouteConfig{
+				LocalPort:    proxyPort,
+				Upstream:     upstream.Addr().String(),
+				DropRate:     1.0,
+				ChaosClients: tt.chaosClients,
+			}
+
+			go ListenAndServeRoute(context.Background(), route)
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+
+			client.SetDeadline(time.Now().Add(500 * time.Millisecond))
+			client.Write([]byte("ping"))
+			_, err = io.ReadFull(client, make([]byte, 4))
+
+			if dropped := err != nil; dropped != tt.wantDropped {
+				t.Errorf("dropped = %v (err %v), want %v", dropped, err, tt.wantDropped)
+			}
+		})
+	}
+}
+
+// Helper Functions
+
+// TestRouteControl tests switching a running route and its chaos on and off
+func TestRouteControl(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	disabled := false
+	route := config.RouteConfig{
+		LocalPort: findFreePort(t),
+		Upstream:  upstream.Addr().String(),
+		DropRate:  1,
+		Enabled:   &disabled,
+	}
+	control := NewRouteControl(route)
+
+	go ServeRoute(context.Background(), route, ServeOptions{Control: control})
+	time.Sleep(50 * time.Millisecond)
+
+	echoes := func() bool {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		defer client.Close()
+		client.SetDeadline(time.Now().Add(1 * time.Second))
+		client.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(client, buf)
+		return err == nil && string(buf) == "ping"
+	}
+
+	if echoes() {
+		t.Error("route disabled in config echoed")
+	}
+
+	steps := []struct {
+		name     string
+		enabled  bool
+		chaos    bool
+		wantEcho bool
+	}{
+		{name: "enabled with chaos", enabled: true, chaos: true, wantEcho: false},
+		{name: "chaos switched off", enabled: true, chaos: false, wantEcho: true},
+		{name: "disabled again", enabled: false, chaos: false, wantEcho: false},
+	}
+	for _, step := range steps {
+		control.SetEnabled(step.enabled)
+		control.SetChaosEnabled(step.chaos)
+		if got := echoes(); got != step.wantEcho {
+			t.Errorf("%s: echoed = %v, want %v", step.name, got, step.wantEcho)
+		}
+	}
+}
+
+// TestRouteControl_Update tests moving a running route to new settings:
+// new connections get them, open ones keep the old, and runtime switches
+// survive
+func TestRouteControl_Update(t *testing.T) {
+	route := config.RouteConfig{Stub: &config.StubConfig{Body: "one"}}
+	control := NewRouteControl(route)
+	if err := control.Update(route); err == nil {
+		t.Error("Update() of a route that isn't serving succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{Control: control, OnListen: func(addr net.Addr) { bound <- addr }})
+	addr := (<-bound).String()
+
+	ask := func(conn net.Conn) string {
+		conn.SetDeadline(time.Now().Add(1 * time.Second))
+		conn.Write([]byte("?"))
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err.Error()
+		}
+		return string(buf)
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		return conn
+	}
+
+	open := dial()
+	defer open.Close()
+	if got := ask(open); got != "one" {
+		t.Fatalf("before update: got %q, want one", got)
+	}
+
+	route.Stub = &config.StubConfig{Body: "two"}
+	if err := control.Update(route); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	fresh := dial()
+	if got := ask(fresh); got != "two" {
+		t.Errorf("new connection after update: got %q, want two", got)
+	}
+	fresh.Close()
+	if got := ask(open); got != "one" {
+		t.Errorf("open connection after update: got %q, want one", got)
+	}
+
+	control.SetEnabled(false)
+	route.Stub = &config.StubConfig{Body: "333"}
+	if err := control.Update(route); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if control.Enabled() {
+		t.Error("Update() that left enabled alone switched the route back on")
+	}
+
+	moved := route
+	moved.LocalPort = 1
+	if err := control.Update(moved); err == nil {
+		t.Error("Update() to another port succeeded")
+	}
+	control.SetEnabled(true)
+	fresh = dial()
+	defer fresh.Close()
+	if got := ask(fresh); got != "333" {
+		t.Errorf("after failed update: got %q, want 333", got)
+	}
+}
+
+// TestRouteControl_UpdateKeepsFlap tests that an update leaving the flap
+// settings alone keeps the route in its place in the flap cycle
+func TestRouteControl_UpdateKeepsFlap(t *testing.T) {
+	route := config.RouteConfig{Stub: &config.StubConfig{Body: "one"}, FlapIntervalMs: 200, FlapDowntimeMs: 10000}
+	control := NewRouteControl(route)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{Control: control, OnListen: func(addr net.Addr) { bound <- addr }})
+	addr := (<-bound).String()
+
+	up := func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(1 * time.Second))
+		conn.Write([]byte("?"))
+		_, err = io.ReadFull(conn, make([]byte, 3))
+		return err == nil
+	}
+
+	if !up() {
+		t.Fatal("route down before its first flap interval passed")
+	}
+	time.Sleep(300 * time.Millisecond)
+	if up() {
+		t.Fatal("route up during its flap downtime")
+	}
+
+	route.Stub = &config.StubConfig{Body: "two"}
+	route.LatencyMs = 1
+	if err := control.Update(route); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if up() {
+		t.Error("update of other settings restarted the flap cycle, want the route still down")
+	}
+
+	route.FlapIntervalMs = 5000
+	if err := control.Update(route); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if !up() {
+		t.Error("update of the flap settings kept the old cycle, want a new one starting up")
+	}
+}
+
+func TestRouteControl_Restart(t *testing.T) {
+	route := config.RouteConfig{Stub: &config.StubConfig{Body: "one"}}
+	control := NewRouteControl(route)
+	serve := func(route config.RouteConfig) (string, context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		bound := make(chan net.Addr, 1)
+		done := make(chan error, 1)
+		go func() {
+			done <- ServeRoute(ctx, route, ServeOptions{Control: control, OnListen: func(addr net.Addr) { bound <- addr }})
+		}()
+		return (<-bound).String(), cancel, done
+	}
+	ask := func(addr string) string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(1 * time.Second))
+		conn.Write([]byte("?"))
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err.Error()
+		}
+		return string(buf)
+	}
+
+	addr, cancel, done := serve(route)
+	if got := ask(addr); got != "one" {
+		t.Fatalf("before restart: got %q, want one", got)
+	}
+	control.SetChaosEnabled(false)
+	cancel()
+	<-done
+
+	route.Stub = &config.StubConfig{Body: "two"}
+	control.Restart(route)
+	addr, cancel, done = serve(route)
+	defer func() { cancel(); <-done }()
+	if got := ask(addr); got != "two" {
+		t.Errorf("after restart: got %q, want two", got)
+	}
+	if control.ChaosEnabled() {
+		t.Error("Restart() switched the route's chaos back on")
+	}
+	if got := control.Traffic().Connections; got != 2 {
+		t.Errorf("Traffic().Connections = %d, want 2 across both listeners", got)
+	}
+}
+
+// TestDryRun tests that a dry run forwards connections untouched while
+// logging the chaos it would have injected
+func TestDryRun(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	// ServeRoute binds its logger to the default handler when it starts.
+	var logs syncBuffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	proxyPort := findFreePort(t)
+	route := config.RouteConfig{
+		LocalPort:       proxyPort,
+		Upstream:        upstream.Addr().String(),
+		DropRate:        1,
+		DialFailureRate: 1,
+		LatencyMs:       500,
+		Toxics: []config.ToxicConfig{
+			{Type: config.ToxicTruncate, Bytes: 2},
+		},
+	}
+
+	stats := &RouteStats{}
+	go ServeRoute(context.Background(), route, ServeOptions{Stats: stats, DryRun: true})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", proxyPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	if _, err := client.Write([]byte("pingpong")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(1 * time.Second))
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("dry run did not forward the connection: %v", err)
+	}
+	if string(buf) != "pingpong" {
+		t.Errorf("echo = %q, want %q", buf, "pingpong")
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("echo took %v, want no injected latency", elapsed)
+	}
+	client.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	snapshot := stats.Snapshot()
+	if snapshot.Failures != 0 || len(snapshot.Faults) != 0 {
+		t.Errorf("Failures = %d, Faults = %v, want none in a dry run", snapshot.Failures, snapshot.Faults)
+	}
+
+	for _, want := range []string{
+		"[CHAOS DRY RUN] failing upstream dial",
+		"[CHAOS DRY RUN] dropping connections",
+		"[CHAOS DRY RUN] adding delay to upstream",
+		"[CHAOS DRY RUN] applying toxic",
+		"toxic=truncate",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs missing %q:\n%s", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "[CHAOS] ") {
+		t.Errorf("dry run logged injected chaos:\n%s", logs.String())
+	}
+}
+
+// TestLogSample tests that a route sampling its logs logs the routine lines
+// of one connection in every LogSample, and both lines of it
+func TestLogSample(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	var logs syncBuffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	route := config.RouteConfig{LocalPort: findFreePort(t), Upstream: upstream.Addr().String()}
+	records := make(chan ConnRecord, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeRoute(ctx, route, ServeOptions{LogSample: 5, OnConnClose: func(r ConnRecord) { records <- r }})
+	time.Sleep(50 * time.Millisecond)
+
+	// Ten connections in a row have two IDs divisible by 5.
+	for range 10 {
+		client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		client.SetDeadline(time.Now().Add(2 * time.Second))
+		client.Write([]byte("ping"))
+		if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+			t.Fatalf("failed to read the echo: %v", err)
+		}
+		client.Close()
+		select {
+		case <-records:
+		case <-time.After(2 * time.Second):
+			t.Fatal("no ConnRecord for the closed connection")
+		}
+	}
+
+	if got := strings.Count(logs.String(), "successfully connected to upstream"); got != 2 {
+		t.Errorf("logged %d connections connecting, want 2 of 10:\n%s", got, logs.String())
+	}
+	if got := strings.Count(logs.String(), "bytes transferred"); got != 2 {
+		t.Errorf("logged %d connections' bytes, want 2 of 10:\n%s", got, logs.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe to log to from several goroutines
+// while a test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startTestEchoServer starts a simple echo server for testing
+func TestRouteControl_Traffic(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{LocalPort: findFreePort(t), Upstream: upstream.Addr().String()}
+	control := NewRouteControl(route)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeRoute(ctx, route, ServeOptions{Control: control})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	client.SetDeadline(time.Now().Add(1 * time.Second))
+	client.Write([]byte("ping"))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+
+	// The counters are live: the connection is still open.
+	if got, want := control.Traffic(), (Traffic{Connections: 1, Open: 1, BytesToClient: 4, BytesToServer: 4}); got != want {
+		t.Errorf("Traffic() with the connection open = %+v, want %+v", got, want)
+	}
+
+	client.Close()
+	deadline := time.Now().Add(1 * time.Second)
+	for control.Traffic().Open != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := control.Traffic(), (Traffic{Connections: 1, BytesToClient: 4, BytesToServer: 4}); got != want {
+		t.Errorf("Traffic() after the connection closed = %+v, want %+v", got, want)
+	}
+}
+
+func TestRouteControl_Conns(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{
+		LocalPort: findFreePort(t),
+		Upstream:  upstream.Addr().String(),
+		Toxics:    []config.ToxicConfig{{Type: config.ToxicLatency, LatencyMs: 1}},
+	}
+	control := NewRouteControl(route)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeRoute(ctx, route, ServeOptions{Control: control})
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(1 * time.Second))
+	client.Write([]byte("ping"))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("failed to read echo: %v", err)
+	}
+
+	conns := control.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("Conns() = %+v, want the open connection", conns)
+	}
+	conn := conns[0]
+	if conn.Client != client.LocalAddr().String() || conn.Upstream != route.Upstream ||
+		conn.BytesToClient != 4 || conn.BytesToServer != 4 || !reflect.DeepEqual(conn.Chaos, []string{"latency"}) {
+		t.Errorf("Conns()[0] = %+v, want the client's connection with 4 bytes each way and latency applied", conn)
+	}
+
+	if control.Kill(conn.ID + 1) {
+		t.Errorf("Kill(%d) of an unknown connection = true", conn.ID+1)
+	}
+	if !control.Kill(conn.ID) {
+		t.Fatalf("Kill(%d) = false, want the connection killed", conn.ID)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("client read after Kill() succeeded, want the connection reset")
+	}
+	deadline := time.Now().Add(1 * time.Second)
+	for len(control.Conns()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if conns := control.Conns(); len(conns) != 0 {
+		t.Errorf("Conns() after Kill() = %+v, want none", conns)
+	}
+}
+
+func startTestEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start test echo server: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // Listener closed
+			}
+			go handleEcho(conn)
+		}
+	}()
+
+	return listener
+}
+
+// handleEcho echoes back everything it receives
+func handleEcho(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 32768)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		_, err = conn.Write(buf[:n])
+		if err != nil {
+			return
 		}
 	}
 }
@@ -764,3 +2330,163 @@ func generateLargeString(size int) string {
 	}
 	return string(result)
 }
+
+func TestServeRoute_ConnRecords(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+	// Nothing listens on a port just freed.
+	unreachable := fmt.Sprintf("127.0.0.1:%d", findFreePort(t))
+
+	tests := []struct {
+		name       string
+		route      config.RouteConfig
+		disabled   bool
+		wantReason string
+		wantChaos  []string
+		wantBytes  int64
+	}{
+		{
+			name:       "completed",
+			route:      config.RouteConfig{Name: "echo", Upstream: upstream.Addr().String(), LatencyMs: 1},
+			wantReason: CloseCompleted,
+			wantChaos:  []string{"latency"},
+			wantBytes:  4,
+		},
+		{
+			name:       "dropped",
+			route:      config.RouteConfig{Upstream: upstream.Addr().String(), DropRate: 1},
+			wantReason: "drop",
+			wantChaos:  []string{"drop"},
+		},
+		{
+			name:       "disabled",
+			route:      config.RouteConfig{Upstream: upstream.Addr().String()},
+			disabled:   true,
+			wantReason: CloseDisabled,
+			wantChaos:  []string{},
+		},
+		{
+			name:       "unreachable upstream",
+			route:      config.RouteConfig{Upstream: unreachable},
+			wantReason: CloseError,
+			wantChaos:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.route.LocalPort = findFreePort(t)
+			control := NewRouteControl(tt.route)
+			control.SetEnabled(!tt.disabled)
+			records := make(chan ConnRecord, 1)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, tt.route, ServeOptions{Control: control, OnConnClose: func(r ConnRecord) { records <- r }})
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", tt.route.LocalPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			client.SetDeadline(time.Now().Add(1 * time.Second))
+			client.Write([]byte("ping"))
+			io.ReadFull(client, make([]byte, 4))
+			client.Close()
+
+			var record ConnRecord
+			select {
+			case record = <-records:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no record of the connection")
+			}
+			if record.CloseReason != tt.wantReason {
+				t.Errorf("closeReason = %q (error %q), want %q", record.CloseReason, record.Error, tt.wantReason)
+			}
+			if (record.Error != "") != (tt.wantReason == CloseError) {
+				t.Errorf("error = %q, want one only for %q", record.Error, CloseError)
+			}
+			if !reflect.DeepEqual(record.Chaos, tt.wantChaos) {
+				t.Errorf("chaos = %v, want %v", record.Chaos, tt.wantChaos)
+			}
+			if record.BytesToClient != tt.wantBytes || record.BytesToServer != tt.wantBytes {
+				t.Errorf("bytes = %d to client, %d to server, want %d each", record.BytesToClient, record.BytesToServer, tt.wantBytes)
+			}
+			if record.Conn == 0 || record.Port != tt.route.LocalPort || record.Route != tt.route.Name ||
+				record.Client != client.LocalAddr().String() || record.End.Before(record.Start) {
+				t.Errorf("record = %+v, want the connection's ID, route, client and times", record)
+			}
+		})
+	}
+}
+
+// TestDrain tests shutting down with a connection still open: it is waited
+// for within the grace period, killed after it, and either way counted in
+// the route's stats before Drain returns
+func TestDrain(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	tests := []struct {
+		name string
+		// closeAfter closes the client this long after shutdown, or never
+		// when zero.
+		closeAfter time.Duration
+		wantKilled int
+		wantReason string
+	}{
+		{"closed within grace", 50 * time.Millisecond, 0, CloseCompleted},
+		{"killed after grace", 0, 1, CloseShutdown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{LocalPort: findFreePort(t), Upstream: upstream.Addr().String()}
+			control := NewRouteControl(route)
+			stats := &RouteStats{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bound := make(chan net.Addr, 1)
+			served := make(chan error, 1)
+			go func() {
+				served <- ServeRoute(ctx, route, ServeOptions{Control: control, Stats: stats, OnListen: func(addr net.Addr) { bound <- addr }})
+			}()
+
+			client, err := net.Dial("tcp", (<-bound).String())
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+			client.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+				t.Fatalf("failed to read the echo: %v", err)
+			}
+
+			cancel()
+			select {
+			case <-served:
+			case <-time.After(2 * time.Second):
+				t.Fatal("ServeRoute() didn't return after shutdown")
+			}
+			if got := stats.Snapshot().MaxConnectionBytes; got != 0 {
+				t.Fatalf("stats counted %d bytes before the connection closed", got)
+			}
+			if tt.closeAfter > 0 {
+				time.AfterFunc(tt.closeAfter, func() { client.Close() })
+			}
+
+			killed, left := Drain([]*RouteControl{control}, 500*time.Millisecond)
+			if killed != tt.wantKilled || left != 0 {
+				t.Errorf("Drain() = %d killed, %d left, want %d killed, 0 left", killed, left, tt.wantKilled)
+			}
+			snapshot := stats.Snapshot()
+			if snapshot.MaxConnectionBytes != 8 {
+				t.Errorf("MaxConnectionBytes = %d after Drain(), want 8", snapshot.MaxConnectionBytes)
+			}
+			if snapshot.Closes[tt.wantReason] != 1 {
+				t.Errorf("closes = %v after Drain(), want one %q", snapshot.Closes, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// proxyHeaderTimeout bounds how long a client may take to send its PROXY
+// protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// maxProxyV1Header is the longest v1 header line, CRLF included.
+const maxProxyV1Header = 107
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// PROXY protocol v2 commands and address families.
+const (
+	proxyV2Local = 0x20
+	proxyV2Proxy = 0x21
+
+	proxyV2Unspec = 0x00
+	proxyV2TCP4   = 0x11
+	proxyV2UDP4   = 0x12
+	proxyV2TCP6   = 0x21
+	proxyV2UDP6   = 0x22
+)
+
+// errProxyHeader is returned for a connection that doesn't start with a
+// well-formed PROXY protocol header.
+var errProxyHeader = errors.New("malformed PROXY protocol header")
+
+// proxiedConn is a client connection that arrived through a load balancer.
+// It reports the addresses from its PROXY protocol header instead of the
+// load balancer's, and reads whatever followed the header.
+type proxiedConn struct {
+	net.Conn
+	r             *bufio.Reader
+	remote, local net.Addr
+}
+
+func (c *proxiedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// CloseWrite and SetLinger reach the TCP connection underneath, so
+// half-closes and resets work as for any other client.
+func (c *proxiedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+func (c *proxiedConn) SetLinger(sec int) error {
+	if l, ok := c.Conn.(interface{ SetLinger(int) error }); ok {
+		return l.SetLinger(sec)
+	}
+	return nil
+}
+
+// transportAddr returns the address conn is actually connected from, which
+// for a proxied connection is the load balancer's.
+func transportAddr(conn net.Conn) net.Addr {
+	if pc, ok := conn.(*proxiedConn); ok {
+		return pc.Conn.RemoteAddr()
+	}
+	return conn.RemoteAddr()
+}
+
+// readProxyHeader reads the v1 or v2 PROXY protocol header conn must start
+// with. Headers for health checks (v1 UNKNOWN, v2 LOCAL) and for address
+// families other than IPv4 and IPv6 leave conn's own addresses in place.
+func readProxyHeader(conn net.Conn) (*proxiedConn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	var src, dst net.Addr
+	switch first[0] {
+	case 'P':
+		src, dst, err = readProxyV1(r)
+	case proxyV2Signature[0]:
+		src, dst, err = readProxyV2(r)
+	default:
+		err = fmt.Errorf("%w: connection starts with %q", errProxyHeader, first)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &proxiedConn{Conn: conn, r: r, remote: conn.RemoteAddr(), local: conn.LocalAddr()}
+	if src != nil {
+		pc.remote, pc.local = src, dst
+	}
+	return pc, nil
+}
+
+// readProxyV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < maxProxyV1Header && !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, b)
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header longer than %d bytes", errProxyHeader, maxProxyV1Header)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, nil, fmt.Errorf("%w: %q", errProxyHeader, line)
+		}
+		if src, err = parseProxyV1Addr(fields[2], fields[4]); err == nil {
+			dst, err = parseProxyV1Addr(fields[3], fields[5])
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errProxyHeader, err)
+		}
+		return src, dst, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported protocol %q", errProxyHeader, fields[1])
+	}
+}
+
+func parseProxyV1Addr(host, port string) (net.Addr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p))), nil
+}
+
+// readProxyV2 reads a binary header: the signature, version and command,
+// address family, length, and the addresses followed by any TLVs, which are
+// skipped.
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, fmt.Errorf("%w: bad v2 signature", errProxyHeader)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	switch header[12] {
+	case proxyV2Local:
+		return nil, nil, nil
+	case proxyV2Proxy:
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported v2 version and command %#x", errProxyHeader, header[12])
+	}
+
+	var size int
+	switch header[13] {
+	case proxyV2TCP4, proxyV2UDP4:
+		size = 4
+	case proxyV2TCP6, proxyV2UDP6:
+		size = 16
+	default:
+		// Unix sockets and unspecified families say nothing useful about
+		// the client.
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: v2 addresses truncated", errProxyHeader)
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+}
+
+// proxyHeader builds the header announcing a connection from src to dst in
+// the given version. Addresses that aren't IP addresses, such as a handed-off
+// connection's, are sent as v1 UNKNOWN or v2 LOCAL, which upstreams treat as
+// a connection from the proxy itself.
+func proxyHeader(version string, src, dst net.Addr) []byte {
+	srcAP, srcErr := netip.ParseAddrPort(src.String())
+	dstAP, dstErr := netip.ParseAddrPort(dst.String())
+	known := srcErr == nil && dstErr == nil
+	if known {
+		srcAP = netip.AddrPortFrom(srcAP.Addr().Unmap(), srcAP.Port())
+		dstAP = netip.AddrPortFrom(dstAP.Addr().Unmap(), dstAP.Port())
+		// Both addresses must be of one family; IPv4 maps into IPv6.
+		if srcAP.Addr().Is4() != dstAP.Addr().Is4() {
+			srcAP = netip.AddrPortFrom(netip.AddrFrom16(srcAP.Addr().As16()), srcAP.Port())
+			dstAP = netip.AddrPortFrom(netip.AddrFrom16(dstAP.Addr().As16()), dstAP.Port())
+		}
+	}
+
+	if version == config.ProxyProtocolV1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family := "TCP6"
+		if srcAP.Addr().Is4() {
+			family = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, srcAP.Addr(), dstAP.Addr(), srcAP.Port(), dstAP.Port())
+	}
+
+	header := append([]byte(nil), proxyV2Signature...)
+	if !known {
+		return append(header, proxyV2Local, proxyV2Unspec, 0, 0)
+	}
+	family, body := byte(proxyV2TCP6), srcAP.Addr().AsSlice()
+	if srcAP.Addr().Is4() {
+		family = proxyV2TCP4
+	}
+	body = append(body, dstAP.Addr().AsSlice()...)
+	body = binary.BigEndian.AppendUint16(body, srcAP.Port())
+	body = binary.BigEndian.AppendUint16(body, dstAP.Port())
+	header = append(header, proxyV2Proxy, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
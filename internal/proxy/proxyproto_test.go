@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// TestReadProxyHeader tests that v1 and v2 headers yield the client address
+// and leave the data after them unread
+func TestReadProxyHeader(t *testing.T) {
+	client4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 4242}
+	server4 := &net.TCPAddr{IP: net.ParseIP("198.51.100.1").To4(), Port: 443}
+	client6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 4242}
+	server6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	unix := &net.UnixAddr{Name: "/tmp/handoff.sock", Net: "unix"}
+
+	tests := []struct {
+		name       string
+		header     []byte
+		wantRemote string // empty keeps the connection's own address
+		wantErr    bool
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 203.0.113.7 198.51.100.1 4242 443\r\n"), wantRemote: "203.0.113.7:4242"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 4242 443\r\n"), wantRemote: "[2001:db8::7]:4242"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v2 tcp4", header: proxyHeader(config.ProxyProtocolV2, client4, server4), wantRemote: "203.0.113.7:4242"},
+		{name: "v2 tcp6", header: proxyHeader(config.ProxyProtocolV2, client6, server6), wantRemote: "[2001:db8::7]:4242"},
+		{name: "v2 local", header: proxyHeader(config.ProxyProtocolV2, unix, server4)},
+		{name: "round trip v1", header: proxyHeader(config.ProxyProtocolV1, client4, server4), wantRemote: "203.0.113.7:4242"},
+		{name: "round trip v1 mixed families", header: proxyHeader(config.ProxyProtocolV1, client4, server6), wantRemote: "203.0.113.7:4242"},
+		{name: "round trip v1 unknown", header: proxyHeader(config.ProxyProtocolV1, unix, server4)},
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), wantErr: true},
+		{name: "v1 bad address", header: []byte("PROXY TCP4 203.0.113 198.51.100.1 4242 443\r\n"), wantErr: true},
+		{name: "v1 missing ports", header: []byte("PROXY TCP4 203.0.113.7 198.51.100.1\r\n"), wantErr: true},
+		{name: "v1 too long", header: []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), wantErr: true},
+		{name: "v2 bad signature", header: []byte("\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientSide, proxySide := net.Pipe()
+			defer clientSide.Close()
+			defer proxySide.Close()
+			go clientSide.Write(append(append([]byte(nil), tt.header...), "hello"...))
+
+			conn, err := readProxyHeader(proxySide)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, errProxyHeader) {
+					t.Errorf("readProxyHeader() error = %v, want errProxyHeader", err)
+				}
+				return
+			}
+
+			want := tt.wantRemote
+			if want == "" {
+				want = proxySide.RemoteAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("RemoteAddr() = %s, want %s", got, want)
+			}
+			data := make([]byte, 5)
+			if _, err := io.ReadFull(conn, data); err != nil || string(data) != "hello" {
+				t.Errorf("data after header = %q, %v; want %q", data, err, "hello")
+			}
+		})
+	}
+}
+
+// startProxyProtocolServer starts an echo upstream that requires a PROXY
+// protocol header and reports the client address from each one.
+func startProxyProtocolServer(t *testing.T) (net.Listener, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	clients := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				proxied, err := readProxyHeader(conn)
+				if err != nil {
+					clients <- "error: " + err.Error()
+					return
+				}
+				clients <- proxied.RemoteAddr().String()
+				io.Copy(proxied, proxied)
+			}()
+		}
+	}()
+	return listener, clients
+}
+
+// TestProxyProtocol tests that the client address from an accepted header
+// is used for targeting and passed on in the header sent upstream
+func TestProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name       string
+		route      config.RouteConfig
+		header     string
+		wantEcho   bool
+		wantClient string
+	}{
+		{
+			name:       "accepted v1 passed on as v2",
+			route:      config.RouteConfig{ProxyProtocol: &config.ProxyProtocolConfig{Accept: true, Send: config.ProxyProtocolV2}},
+			header:     "PROXY TCP4 203.0.113.7 127.0.0.1 4242 8080\r\n",
+			wantEcho:   true,
+			wantClient: "203.0.113.7:4242",
+		},
+		{
+			name:       "accepted v1 passed on as v1",
+			route:      config.RouteConfig{ProxyProtocol: &config.ProxyProtocolConfig{Accept: true, Send: config.ProxyProtocolV1}},
+			header:     "PROXY TCP4 203.0.113.7 127.0.0.1 4242 8080\r\n",
+			wantEcho:   true,
+			wantClient: "203.0.113.7:4242",
+		},
+		{
+			name: "real client targeted by chaosClients",
+			route: config.RouteConfig{
+				DropRate:      1,
+				ChaosClients:  []string{"203.0.113.0/24"},
+				ProxyProtocol: &config.ProxyProtocolConfig{Accept: true, Send: config.ProxyProtocolV2},
+			},
+			header:   "PROXY TCP4 203.0.113.7 127.0.0.1 4242 8080\r\n",
+			wantEcho: false,
+		},
+		{
+			name: "real client outside chaosClients",
+			route: config.RouteConfig{
+				DropRate:      1,
+				ChaosClients:  []string{"203.0.113.0/24"},
+				ProxyProtocol: &config.ProxyProtocolConfig{Accept: true, Send: config.ProxyProtocolV2},
+			},
+			header:     "PROXY TCP4 198.51.100.9 127.0.0.1 4242 8080\r\n",
+			wantEcho:   true,
+			wantClient: "198.51.100.9:4242",
+		},
+		{
+			name:     "missing header rejected",
+			route:    config.RouteConfig{ProxyProtocol: &config.ProxyProtocolConfig{Accept: true, Send: config.ProxyProtocolV2}},
+			wantEcho: false,
+		},
+		{
+			name:       "sent without accepting",
+			route:      config.RouteConfig{ProxyProtocol: &config.ProxyProtocolConfig{Send: config.ProxyProtocolV2}},
+			wantEcho:   true,
+			wantClient: "127.0.0.1:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, clients := startProxyProtocolServer(t)
+			route := tt.route
+			route.Upstream = upstream.Addr().String()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bound := make(chan net.Addr, 1)
+			go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+			addr := <-bound
+
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			conn.Write([]byte(tt.header + "ping"))
+
+			echo := make([]byte, 4)
+			_, err = io.ReadFull(conn, echo)
+			if got := err == nil && bytes.Equal(echo, []byte("ping")); got != tt.wantEcho {
+				t.Fatalf("echoed = %v (%q, %v), want %v", got, echo, err, tt.wantEcho)
+			}
+			if !tt.wantEcho {
+				return
+			}
+			if client := <-clients; !strings.HasPrefix(client, tt.wantClient) {
+				t.Errorf("upstream saw client %s, want %s", client, tt.wantClient)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// Sides of a connection a recorded chunk was read from.
+const (
+	fromClient   = "client"
+	fromUpstream = "upstream"
+)
+
+// recordedChunk is one line of a recording: bytes read from one side of a
+// connection, Ms milliseconds after it opened. Data is base64 in the file.
+type recordedChunk struct {
+	From string `json:"from"`
+	Ms   int64  `json:"ms"`
+	Data []byte `json:"data"`
+}
+
+// recorder writes the traffic of a route's connections to files, one per
+// connection, named so that they sort in the order the connections opened.
+type recorder struct {
+	dir    string
+	port   int
+	seq    atomic.Int64
+	logger *slog.Logger
+}
+
+// newRecorder creates the route's recording directory, or returns nil if
+// the route records nothing.
+func newRecorder(route config.RouteConfig, logger *slog.Logger) (*recorder, error) {
+	if route.Record == nil {
+		return nil, nil
+	}
+	if err := os.MkdirAll(route.Record.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &recorder{dir: route.Record.Dir, port: route.LocalPort, logger: logger}, nil
+}
+
+// open starts recording a connection from clientAddr. A recording that
+// can't be written is logged and skipped; the connection carries on.
+func (r *recorder) open(clientAddr string) *recording {
+	start := time.Now()
+	name := fmt.Sprintf("%s-%d-%06d%s", start.UTC().Format("20060102T150405.000000000"), r.port, r.seq.Add(1), config.RecordingExt)
+	path := filepath.Join(r.dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		r.logger.Error("failed to start recording",
+			"address", clientAddr,
+			"file", path,
+			"error", err,
+			"hint", "check that record.dir is writable and its disk isn't full")
+		return nil
+	}
+	r.logger.Debug("recording connection", "address", clientAddr, "file", path)
+	return &recording{f: f, enc: json.NewEncoder(f), start: start, path: path, clientAddr: clientAddr, logger: r.logger}
+}
+
+// recording is one connection's recording in progress. Both directions
+// write to it, so chunks are appended in the order they were read.
+type recording struct {
+	mu         sync.Mutex
+	f          *os.File
+	enc        *json.Encoder
+	start      time.Time
+	failed     bool
+	path       string
+	clientAddr string
+	logger     *slog.Logger
+}
+
+// reader returns src with every chunk read from it recorded as coming from
+// side from.
+func (c *recording) reader(src io.Reader, from string) io.Reader {
+	return &recordingReader{src: src, rec: c, from: from}
+}
+
+func (c *recording) add(from string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed {
+		return
+	}
+	chunk := recordedChunk{From: from, Ms: time.Since(c.start).Milliseconds(), Data: data}
+	if err := c.enc.Encode(chunk); err != nil {
+		c.failed = true
+		c.logger.Error("failed to write recording, abandoning it",
+			"address", c.clientAddr,
+			"file", c.path,
+			"error", err,
+			"hint", "check that record.dir's disk isn't full; the connection is unaffected")
+	}
+}
+
+func (c *recording) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.f.Close()
+}
+
+type recordingReader struct {
+	src  io.Reader
+	rec  *recording
+	from string
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		r.rec.add(r.from, p[:n])
+	}
+	return n, err
+}
+
+// loadRecordings reads every recording in dir, in file name order.
+func loadRecordings(dir string) ([][]recordedChunk, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+config.RecordingExt))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no %s files in %s", config.RecordingExt, dir)
+	}
+	recordings := make([][]recordedChunk, 0, len(paths))
+	for _, path := range paths {
+		chunks, err := loadRecording(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		recordings = append(recordings, chunks)
+	}
+	return recordings, nil
+}
+
+func loadRecording(path string) ([]recordedChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var chunks []recordedChunk
+	dec := json.NewDecoder(f)
+	for {
+		var chunk recordedChunk
+		if err := dec.Decode(&chunk); err == io.EOF {
+			return chunks, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if chunk.From != fromClient && chunk.From != fromUpstream {
+			return nil, fmt.Errorf("chunk from %q, want %q or %q", chunk.From, fromClient, fromUpstream)
+		}
+		chunks = append(chunks, chunk)
+	}
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// writeRecording writes chunks to a recording in dir.
+func writeRecording(t *testing.T, dir, name string, chunks ...recordedChunk) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, name+config.RecordingExt))
+	if err != nil {
+		t.Fatalf("failed to create recording: %v", err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, chunk := range chunks {
+		if err := enc.Encode(chunk); err != nil {
+			t.Fatalf("failed to write recording: %v", err)
+		}
+	}
+}
+
+// exchange sends each message on conn and reads a reply of the same length.
+func exchange(t *testing.T, conn net.Conn, messages ...string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	for _, msg := range messages {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatalf("failed to send %q: %v", msg, err)
+		}
+		reply := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != msg {
+			t.Fatalf("reply = %q, %v; want %q", reply, err, msg)
+		}
+	}
+}
+
+// TestRecordReplay_TCP tests that a recorded connection replays without its
+// upstream
+func TestRecordReplay_TCP(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+	dir := t.TempDir()
+
+	addr := startRoute(t, config.RouteConfig{Upstream: upstream.Addr().String(), Record: &config.RecordConfig{Dir: dir}})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	exchange(t, conn, "hello", "world")
+	conn.Close()
+
+	var recording []recordedChunk
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*"+config.RecordingExt))
+		if len(paths) != 1 {
+			continue
+		}
+		if recording, err = loadRecording(paths[0]); err == nil && len(recording) == 4 {
+			break
+		}
+	}
+	want := []string{fromClient + " hello", fromUpstream + " hello", fromClient + " world", fromUpstream + " world"}
+	if len(recording) != len(want) {
+		t.Fatalf("recorded %d chunks, want %d", len(recording), len(want))
+	}
+	for i, chunk := range recording {
+		if got := chunk.From + " " + string(chunk.Data); got != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	upstream.Close()
+	addr = startRoute(t, config.RouteConfig{Replay: &config.ReplayConfig{Dir: dir}})
+	for range 2 {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial replay: %v", err)
+		}
+		exchange(t, conn, "hello", "world")
+		if n, err := conn.Read(make([]byte, 1)); n != 0 || err == nil {
+			t.Errorf("replay kept going after the recording ended: %d, %v", n, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestReplay_Timing tests that replay keeps the upstream's recorded pauses
+// only when asked to
+func TestReplay_Timing(t *testing.T) {
+	dir := t.TempDir()
+	writeRecording(t, dir, "slow",
+		recordedChunk{From: fromClient, Ms: 0, Data: []byte("ping")},
+		recordedChunk{From: fromUpstream, Ms: 300, Data: []byte("pong")})
+
+	for _, timing := range []bool{false, true} {
+		addr := startRoute(t, config.RouteConfig{Replay: &config.ReplayConfig{Dir: dir, Timing: timing}})
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial replay: %v", err)
+		}
+		start := time.Now()
+		conn.SetDeadline(start.Add(2 * time.Second))
+		conn.Write([]byte("ping"))
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "pong" {
+			t.Fatalf("reply = %q, %v; want pong", reply, err)
+		}
+		if slow := time.Since(start) >= 300*time.Millisecond; slow != timing {
+			t.Errorf("timing %v: answered after %v", timing, time.Since(start))
+		}
+		conn.Close()
+	}
+}
+
+// TestReplay_HTTP tests that an http mode replay answers each request with
+// the next recorded response, however the upstream's bytes were chunked
+func TestReplay_HTTP(t *testing.T) {
+	dir := t.TempDir()
+	first := "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst"
+	second := "HTTP/1.1 503 Service Unavailable\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n"
+	writeRecording(t, dir, "conn",
+		recordedChunk{From: fromClient, Data: []byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n")},
+		recordedChunk{From: fromUpstream, Data: []byte(first + second[:10])},
+		recordedChunk{From: fromUpstream, Data: []byte(second[10:])})
+
+	addr := startRoute(t, config.RouteConfig{Mode: config.ModeHTTP, Replay: &config.ReplayConfig{Dir: dir}})
+	client := &http.Client{Timeout: 2 * time.Second}
+	want := []struct {
+		status int
+		body   string
+	}{{200, "first"}, {503, "second"}, {200, "first"}}
+	for i, w := range want {
+		resp, err := client.Get("http://" + addr + "/anything")
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != w.status || string(body) != w.body {
+			t.Errorf("request %d = %d %q, want %d %q", i, resp.StatusCode, body, w.status, w.body)
+		}
+	}
+}
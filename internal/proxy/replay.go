@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// replay stands in for the upstream of a route with replay, answering each
+// connection with the next recording. Like a stub, it serves on a loopback
+// port of its own that only the route dials.
+type replay struct {
+	listener   net.Listener
+	recordings [][]recordedChunk
+	next       atomic.Int64
+	http       bool
+	timing     bool
+	logger     *slog.Logger
+}
+
+// startReplay loads route's recordings and starts serving them, or returns
+// nil if the route replays nothing. Routes in http mode get the recorded
+// responses one per request, others the recorded bytes paced by how much
+// the client has sent.
+func startReplay(route config.RouteConfig, logger *slog.Logger) (*replay, error) {
+	if route.Replay == nil {
+		return nil, nil
+	}
+	recordings, err := loadRecordings(route.Replay.Dir)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &replay{
+		listener:   listener,
+		recordings: recordings,
+		http:       route.Mode == config.ModeHTTP,
+		timing:     route.Replay.Timing,
+		logger:     logger,
+	}
+	go r.serve()
+	return r, nil
+}
+
+// addr is where the replay serves, and so the upstream of its route's
+// connections.
+func (r *replay) addr() string {
+	return r.listener.Addr().String()
+}
+
+// Close stops the replay. Connections already open are left to finish.
+func (r *replay) Close() error {
+	return r.listener.Close()
+}
+
+func (r *replay) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		i := int(r.next.Add(1)-1) % len(r.recordings)
+		r.logger.Debug("replaying recording", "recording", i)
+		go func() {
+			defer conn.Close()
+			if r.http {
+				r.replayHTTP(conn, r.recordings[i])
+			} else {
+				r.replayTCP(conn, r.recordings[i])
+			}
+		}()
+	}
+}
+
+// replayTCP sends the upstream's recorded chunks, each once the client has
+// sent as many bytes as it had when the chunk arrived. What the client
+// sends is otherwise ignored.
+func (r *replay) replayTCP(conn net.Conn, chunks []recordedChunk) {
+	client := bufio.NewReader(conn)
+	var want, got int64
+	var last int64
+	for _, chunk := range chunks {
+		if chunk.From == fromClient {
+			want += int64(len(chunk.Data))
+			last = chunk.Ms
+			continue
+		}
+		if got < want {
+			n, err := io.CopyN(io.Discard, client, want-got)
+			got += n
+			if err != nil {
+				return
+			}
+		}
+		if r.timing {
+			time.Sleep(time.Duration(chunk.Ms-last) * time.Millisecond)
+		}
+		last = chunk.Ms
+		if _, err := conn.Write(chunk.Data); err != nil {
+			return
+		}
+	}
+	// The upstream had nothing more to say; the client may still finish.
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+	io.Copy(io.Discard, client)
+}
+
+// recordedResponse is one HTTP response cut from a recording, and how long
+// the upstream took to start sending it after the client last sent
+// anything.
+type recordedResponse struct {
+	raw   []byte
+	delay time.Duration
+}
+
+// replayHTTP answers each request read from conn with the next recorded
+// response, whatever the request. Once they run out, the connection is
+// closed, as the upstream's was.
+func (r *replay) replayHTTP(conn net.Conn, chunks []recordedChunk) {
+	responses := splitResponses(chunks)
+	client := bufio.NewReader(conn)
+	for _, resp := range responses {
+		req, err := http.ReadRequest(client)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		if r.timing {
+			time.Sleep(resp.delay)
+		}
+		if _, err := conn.Write(resp.raw); err != nil {
+			return
+		}
+	}
+}
+
+// splitResponses cuts the upstream's side of an HTTP/1.x recording into
+// its responses. Bytes that don't parse as a response end the list.
+func splitResponses(chunks []recordedChunk) []recordedResponse {
+	var stream []byte
+	// starts[i] and delays[i] are where the upstream's i-th chunk begins in
+	// stream and how long after the client's last chunk it arrived.
+	var starts []int
+	var delays []time.Duration
+	var lastClient int64
+	for _, chunk := range chunks {
+		if chunk.From == fromClient {
+			lastClient = chunk.Ms
+			continue
+		}
+		starts = append(starts, len(stream))
+		delays = append(delays, time.Duration(max(chunk.Ms-lastClient, 0))*time.Millisecond)
+		stream = append(stream, chunk.Data...)
+	}
+
+	var responses []recordedResponse
+	src := bytes.NewReader(stream)
+	br := bufio.NewReader(src)
+	offset, chunk := 0, 0
+	for offset < len(stream) {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			break
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			break
+		}
+		end := len(stream) - src.Len() - br.Buffered()
+		for chunk+1 < len(starts) && starts[chunk+1] <= offset {
+			chunk++
+		}
+		responses = append(responses, recordedResponse{raw: stream[offset:end], delay: delays[chunk]})
+		offset = end
+	}
+	return responses
+}
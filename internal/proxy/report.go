@@ -0,0 +1,83 @@
+package proxy
+
+import "time"
+
+// Report summarizes a run of the proxy, route by route, for the end of an
+// experiment.
+type Report struct {
+	Started    time.Time     `json:"started"`
+	DurationMs int64         `json:"durationMs"`
+	Routes     []RouteReport `json:"routes"`
+}
+
+// RouteReport summarizes what a route did over the run.
+type RouteReport struct {
+	Port     int    `json:"port"`
+	Name     string `json:"name,omitempty"`
+	Upstream string `json:"upstream"`
+
+	Connections int64 `json:"connections"`
+	Failures    int64 `json:"failures"`
+	// Failovers counts connections that went to the fallback upstream.
+	Failovers     int64 `json:"failovers"`
+	BytesToClient int64 `json:"bytesToClient"`
+	BytesToServer int64 `json:"bytesToServer"`
+	// Faults counts the faults injected, by name, as in fault events.
+	Faults map[string]int64 `json:"faults"`
+	// CloseReasons counts finished connections by why they closed, as in
+	// their ConnRecord.
+	CloseReasons map[string]int64 `json:"closeReasons"`
+	// InjectedDelay summarizes the delays injected, by fault name.
+	InjectedDelay map[string]DelayReport `json:"injectedDelay"`
+}
+
+// DelayReport gives the percentiles of the delays a fault injected, in
+// milliseconds.
+type DelayReport struct {
+	Count int64   `json:"count"`
+	P50Ms float64 `json:"p50Ms"`
+	P90Ms float64 `json:"p90Ms"`
+	P99Ms float64 `json:"p99Ms"`
+	MaxMs float64 `json:"maxMs"`
+}
+
+// NewRouteReport summarizes the route of control from its stats so far.
+func NewRouteReport(control *RouteControl, stats *RouteStats) RouteReport {
+	snapshot := stats.Snapshot()
+	traffic := control.Traffic()
+	route := control.Route()
+	report := RouteReport{
+		Port:          control.Port(),
+		Name:          route.Name,
+		Upstream:      route.Upstream,
+		Connections:   snapshot.Connections,
+		Failures:      snapshot.Failures,
+		Failovers:     snapshot.Failovers,
+		BytesToClient: traffic.BytesToClient,
+		BytesToServer: traffic.BytesToServer,
+		Faults:        orEmpty(snapshot.Faults),
+		CloseReasons:  orEmpty(snapshot.Closes),
+		InjectedDelay: make(map[string]DelayReport, len(snapshot.Delays)),
+	}
+	for fault, delays := range snapshot.Delays {
+		report.InjectedDelay[fault] = DelayReport{
+			Count: delays.Count,
+			P50Ms: milliseconds(delays.P50),
+			P90Ms: milliseconds(delays.P90),
+			P99Ms: milliseconds(delays.P99),
+			MaxMs: milliseconds(delays.Max),
+		}
+	}
+	return report
+}
+
+func orEmpty(counts map[string]int64) map[string]int64 {
+	if counts == nil {
+		return map[string]int64{}
+	}
+	return counts
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
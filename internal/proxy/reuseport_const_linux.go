@@ -0,0 +1,7 @@
+//go:build 386 || amd64 || arm
+
+package proxy
+
+// soReusePort is SO_REUSEPORT, from <asm-generic/socket.h>. Package
+// syscall's constants for these architectures predate it.
+const soReusePort = 15
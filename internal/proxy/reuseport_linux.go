@@ -0,0 +1,25 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listenReusePort listens on addr with SO_REUSEPORT set, so several
+// listeners can share the port and the kernel spreads new connections
+// across them.
+func listenReusePort(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var optErr error
+		if err := c.Control(func(fd uintptr) {
+			optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}); err != nil {
+			return err
+		}
+		return optErr
+	}}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// TestAcceptListeners tests that a route bound several times with
+// SO_REUSEPORT serves connections on all of them, and closes all of them
+// when it stops
+func TestAcceptListeners(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{Upstream: upstream.Addr().String(), AcceptListeners: 4}
+	ctx, cancel := context.WithCancel(context.Background())
+	bound := make(chan net.Addr, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+	}()
+	addr := (<-bound).String()
+
+	for range 20 {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+			t.Fatalf("failed to read the echo: %v", err)
+		}
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeRoute() = %v, want nil after cancelling", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeRoute() didn't return after cancelling")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("dialed the route after it stopped, want every listener closed")
+	}
+}
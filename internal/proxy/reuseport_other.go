@@ -0,0 +1,12 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(addr string) (net.Listener, error) {
+	return nil, errors.New("acceptListeners requires Linux")
+}
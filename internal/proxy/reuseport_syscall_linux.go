@@ -0,0 +1,8 @@
+//go:build !(386 || amd64 || arm)
+
+package proxy
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT, whose value varies between architectures.
+const soReusePort = syscall.SO_REUSEPORT
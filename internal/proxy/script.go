@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/script"
+)
+
+// scriptPayloadWait is how long a connection waits for the client's first
+// bytes when the route's script reads payload. Clients of protocols where
+// the server speaks first send nothing, and get an empty payload after it.
+const scriptPayloadWait = time.Second
+
+// peekedConn is a client connection whose first bytes were read for the
+// route's script. It reads them again ahead of the rest.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite and SetLinger reach the connection underneath, so half-closes
+// and resets work as for any other client.
+func (c *peekedConn) CloseWrite() error {
+	if hc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return hc.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+func (c *peekedConn) SetLinger(sec int) error {
+	if l, ok := c.Conn.(interface{ SetLinger(int) error }); ok {
+		return l.SetLinger(sec)
+	}
+	return nil
+}
+
+// peekPayload reads the first bytes client sends, waiting at most
+// scriptPayloadWait, and returns them with a connection that reads them
+// again.
+func peekPayload(client net.Conn) ([]byte, net.Conn) {
+	buf := make([]byte, script.PayloadBytes)
+	client.SetReadDeadline(time.Now().Add(scriptPayloadWait))
+	n, _ := client.Read(buf)
+	client.SetReadDeadline(time.Time{})
+	buf = buf[:n]
+	return buf, &peekedConn{Conn: client, r: io.MultiReader(bytes.NewReader(buf), client)}
+}
+
+// scriptConnection runs the route's script for a connection about to be
+// forwarded and reports whether it carries on, along with the client to
+// forward, which reads the payload again if the script peeked at it.
+func (s *routeServer) scriptConnection(client net.Conn, tracked *openConn, route config.RouteConfig, logger *slog.Logger) (net.Conn, bool) {
+	opts := s.opts
+	clientAddr := client.RemoteAddr().String()
+	in := script.Input{ClientAddr: clientAddr}
+	if s.script.UsesPayload() {
+		in.Payload, client = peekPayload(client)
+	}
+	decision := s.script.Connection(in)
+	attrs := []any{"address", clientAddr, "upstream", route.Upstream, "script", route.Script, "line", decision.Line}
+	switch decision.Action {
+	case script.Drop:
+		if opts.decide(logger, "script_drop", "script dropping connection", attrs...) {
+			opts.Stats.recordFailure()
+			opts.publishFault(route, clientAddr, "script_drop", scriptDetail(decision))
+			tracked.end("script_drop")
+			return client, false
+		}
+	case script.Delay:
+		if opts.decide(logger, "script_delay", "script delaying connection", append(attrs, "delay", decision.Delay)...) {
+			opts.publishDelay(route, clientAddr, "script_delay", decision.Delay)
+			time.Sleep(decision.Delay)
+		}
+	}
+	return client, true
+}
+
+// scriptRequest runs the route's script for an HTTP/1.x request, answering
+// it if the script says to. It returns errConnectionDone for a dropped
+// request, and reports whether req was answered.
+func (f *httpForwarder) scriptRequest(req *http.Request, attrs []any) (bool, error) {
+	route, opts := f.route, f.opts
+	decision := f.script.Request(script.Input{
+		ClientAddr: f.clientAddr,
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Host:       req.Host,
+		Header:     req.Header,
+	})
+	attrs = append(attrs, "script", route.Script, "line", decision.Line)
+	switch decision.Action {
+	case script.Drop:
+		if opts.decide(f.logger, "script_drop", "script dropping request", attrs...) {
+			opts.Stats.recordFailure()
+			opts.publishFault(route, f.clientAddr, "script_drop", scriptDetail(decision))
+			return true, errConnectionDone
+		}
+	case script.Error:
+		status := decision.Status
+		if status == 0 {
+			status = route.HTTP.ErrorStatusOrDefault()
+		}
+		if opts.decide(f.logger, "script_error", "script answering request with an error", append(attrs, "status", status)...) {
+			opts.publishFault(route, f.clientAddr, "script_error", strconv.Itoa(status))
+			return true, f.writeError(req, status)
+		}
+	case script.Delay:
+		if opts.decide(f.logger, "script_delay", "script delaying request", append(attrs, "delay", decision.Delay)...) {
+			opts.publishDelay(route, f.clientAddr, "script_delay", decision.Delay)
+			time.Sleep(decision.Delay)
+		}
+	}
+	return false, nil
+}
+
+// scriptStream runs the route's script for an HTTP/2 stream or RPC,
+// answering it through w if the script says to. It reports whether the
+// stream was answered; a dropped stream is reset.
+func (f *h2Forwarder) scriptStream(w http.ResponseWriter, r *http.Request, attrs []any) bool {
+	route, opts := f.route, f.opts
+	decision := f.script.Request(script.Input{
+		ClientAddr: f.clientAddr,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Host:       r.Host,
+		Header:     r.Header,
+	})
+	attrs = append(attrs, "script", route.Script, "line", decision.Line)
+	switch decision.Action {
+	case script.Drop:
+		if opts.decide(f.logger, "script_drop", "script resetting stream", attrs...) {
+			opts.Stats.recordFailure()
+			opts.publishFault(route, f.clientAddr, "script_drop", scriptDetail(decision))
+			panic(http.ErrAbortHandler)
+		}
+	case script.Error:
+		if route.Mode == config.ModeGRPC {
+			code := decision.Status
+			if code == 0 {
+				codes := route.GRPC.ErrorCodesOrDefault()
+				code, _ = config.GRPCCode(codes[f.ritual.Rand().Intn(len(codes))])
+			}
+			if opts.decide(f.logger, "script_error", "script answering RPC with an error", append(attrs, "code", code)...) {
+				opts.publishFault(route, f.clientAddr, "script_error", strconv.Itoa(code))
+				writeGRPCStatus(w, code, "chaos-proxy: injected error")
+				return true
+			}
+			break
+		}
+		status := decision.Status
+		if status == 0 {
+			status = config.DefaultHTTPErrorStatus
+		}
+		if opts.decide(f.logger, "script_error", "script answering stream with an error", append(attrs, "status", status)...) {
+			opts.publishFault(route, f.clientAddr, "script_error", strconv.Itoa(status))
+			http.Error(w, fmt.Sprintf("chaos-proxy: injected %d %s", status, http.StatusText(status)), status)
+			return true
+		}
+	case script.Delay:
+		if opts.decide(f.logger, "script_delay", "script delaying stream", append(attrs, "delay", decision.Delay)...) {
+			opts.publishDelay(route, f.clientAddr, "script_delay", decision.Delay)
+			time.Sleep(decision.Delay)
+		}
+	}
+	return false
+}
+
+// scriptDetail names the rule behind a fault, for its event.
+func scriptDetail(decision script.Decision) string {
+	return "line " + strconv.Itoa(decision.Line)
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.chaos")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return path
+}
+
+// TestScript_Payload tests that a script can drop connections by their first
+// bytes, and that connections it lets through still forward those bytes
+func TestScript_Payload(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+	addr := startRoute(t, config.RouteConfig{
+		ListenAddress: "127.0.0.1",
+		Upstream:      upstream.Addr().String(),
+		Script:        writeScript(t, `if startsWith(payload, "QUIT"): drop`),
+	})
+
+	tests := []struct {
+		send     string
+		wantEcho bool
+	}{
+		{send: "PING", wantEcho: true},
+		{send: "QUIT", wantEcho: false},
+	}
+	for _, tt := range tests {
+		client, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect to proxy: %v", err)
+		}
+		client.Write([]byte(tt.send))
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, len(tt.send))
+		_, err = io.ReadFull(client, buf)
+		client.Close()
+		if echoed := err == nil && string(buf) == tt.send; echoed != tt.wantEcho {
+			t.Errorf("%s: echoed = %v (err %v), want %v", tt.send, echoed, err, tt.wantEcho)
+		}
+	}
+}
+
+// TestScript_HTTP tests that a script decides per request, counting each
+// client's requests across its connection
+func TestScript_HTTP(t *testing.T) {
+	url, _ := startHTTPMode(t, config.RouteConfig{Script: writeScript(t, `
+if request.path == "/health": pass
+if client.requests > 2: error 429
+`)}, http.HandlerFunc(echoPath))
+	client := keepAliveClient(t)
+
+	var got []string
+	for _, path := range []string{"/a", "/b", "/health", "/c"} {
+		resp, err := client.Get(url + path)
+		if err != nil {
+			t.Fatalf("request %s failed: %v", path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		got = append(got, fmt.Sprintf("%s %d", path, resp.StatusCode))
+	}
+	want := "/a 200, /b 200, /health 200, /c 429"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("responses = %s, want %s", strings.Join(got, ", "), want)
+	}
+}
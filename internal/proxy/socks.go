@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// socksHandshakeTimeout bounds how long a client may take to ask for a
+// destination.
+const socksHandshakeTimeout = 10 * time.Second
+
+// SOCKS5 protocol constants (RFC 1928).
+const (
+	socksVersion = 0x05
+
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xFF
+
+	socksConnect = 0x01
+
+	socksIPv4   = 0x01
+	socksDomain = 0x03
+	socksIPv6   = 0x04
+
+	socksSucceeded           = 0x00
+	socksGeneralFailure      = 0x01
+	socksNetworkUnreachable  = 0x03
+	socksHostUnreachable     = 0x04
+	socksConnectionRefused   = 0x05
+	socksCommandNotSupported = 0x07
+	socksAddrNotSupported    = 0x08
+)
+
+// socksHandshake negotiates with a SOCKS5 client and returns the host:port
+// of the destination it asks to connect to. The client's request is left
+// unanswered until the outcome of dialling it is known; see socksReply.
+// Requests the proxy can't serve are answered here.
+func socksHandshake(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Greeting: version, then the authentication methods the client offers.
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS greeting: %w", err)
+	}
+	if bytes.IndexByte(methods, socksNoAuth) < 0 {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", errors.New("client requires SOCKS authentication, which the proxy doesn't support")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return "", err
+	}
+
+	// Request: version, command, reserved, then the destination.
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksCommandNotSupported, nil)
+		return "", fmt.Errorf("unsupported SOCKS command %d, only CONNECT is supported", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		size := 4
+		if request[3] == socksIPv6 {
+			size = 16
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+		}
+		addr, _ := netip.AddrFromSlice(ip)
+		host = addr.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksAddrNotSupported, nil)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply answers a client's CONNECT request with rep and the address
+// the proxy connected to the destination from, if any.
+func socksReply(conn net.Conn, rep byte, bound net.Addr) error {
+	reply := []byte{socksVersion, rep, 0}
+	addrPort := netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	if bound != nil {
+		if ap, err := netip.ParseAddrPort(bound.String()); err == nil {
+			addrPort = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+		}
+	}
+	if addrPort.Addr().Is4() {
+		reply = append(reply, socksIPv4)
+	} else {
+		reply = append(reply, socksIPv6)
+	}
+	reply = append(reply, addrPort.Addr().AsSlice()...)
+	reply = binary.BigEndian.AppendUint16(reply, addrPort.Port())
+	_, err := conn.Write(reply)
+	return err
+}
+
+// socksReplyFor picks the reply for a failed dial, so the client reports
+// it as it would a direct connection failing the same way.
+func socksReplyFor(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socksConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socksNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return socksHostUnreachable
+	default:
+		return socksGeneralFailure
+	}
+}
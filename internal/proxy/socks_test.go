@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// socksRequest builds a CONNECT request for a domain name destination.
+func socksRequest(command byte, host string, port int) []byte {
+	req := []byte{socksVersion, command, 0, socksDomain, byte(len(host))}
+	req = append(req, host...)
+	return binary.BigEndian.AppendUint16(req, uint16(port))
+}
+
+// socksRequestIP builds a CONNECT request for an IP address destination.
+func socksRequestIP(addr *net.TCPAddr) []byte {
+	req := []byte{socksVersion, socksConnect, 0, socksIPv4}
+	if addr.IP.To4() == nil {
+		req[3] = socksIPv6
+		req = append(req, addr.IP.To16()...)
+	} else {
+		req = append(req, addr.IP.To4()...)
+	}
+	return binary.BigEndian.AppendUint16(req, uint16(addr.Port))
+}
+
+// TestSOCKS5 tests that the client's destination is dialled and reported
+// back in the SOCKS5 reply
+func TestSOCKS5(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+	upstreamAddr := upstream.Addr().(*net.TCPAddr)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve a port: %v", err)
+	}
+	closedAddr := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		route      config.RouteConfig
+		methods    []byte
+		request    []byte
+		wantMethod byte
+		wantReply  byte
+		wantEcho   bool
+	}{
+		{
+			name:       "connect by IP",
+			request:    socksRequestIP(upstreamAddr),
+			wantMethod: socksNoAuth,
+			wantReply:  socksSucceeded,
+			wantEcho:   true,
+		},
+		{
+			name:       "connect by name",
+			request:    socksRequest(socksConnect, "localhost", upstreamAddr.Port),
+			wantMethod: socksNoAuth,
+			wantReply:  socksSucceeded,
+			wantEcho:   true,
+		},
+		{
+			name:       "refused destination",
+			request:    socksRequestIP(closedAddr),
+			wantMethod: socksNoAuth,
+			wantReply:  socksConnectionRefused,
+		},
+		{
+			name:       "injected dial failure",
+			route:      config.RouteConfig{DialFailureRate: 1},
+			request:    socksRequestIP(upstreamAddr),
+			wantMethod: socksNoAuth,
+			wantReply:  socksConnectionRefused,
+		},
+		{
+			name:       "bind not supported",
+			request:    socksRequest(0x02, "localhost", upstreamAddr.Port),
+			wantMethod: socksNoAuth,
+			wantReply:  socksCommandNotSupported,
+		},
+		{
+			name:       "authentication required",
+			methods:    []byte{0x02},
+			wantMethod: socksNoAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := tt.route
+			route.Mode = config.ModeSOCKS5
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bound := make(chan net.Addr, 1)
+			go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+
+			conn, err := net.Dial("tcp", (<-bound).String())
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			methods := tt.methods
+			if methods == nil {
+				methods = []byte{socksNoAuth}
+			}
+			conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...))
+			choice := make([]byte, 2)
+			if _, err := io.ReadFull(conn, choice); err != nil {
+				t.Fatalf("failed to read method choice: %v", err)
+			}
+			if choice[1] != tt.wantMethod {
+				t.Fatalf("method = %#x, want %#x", choice[1], tt.wantMethod)
+			}
+			if tt.request == nil {
+				return
+			}
+
+			conn.Write(tt.request)
+			reply := make([]byte, 10)
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				t.Fatalf("failed to read reply: %v", err)
+			}
+			if reply[1] != tt.wantReply {
+				t.Fatalf("reply = %#x, want %#x", reply[1], tt.wantReply)
+			}
+			if !tt.wantEcho {
+				return
+			}
+			if port := binary.BigEndian.Uint16(reply[8:]); port == 0 {
+				t.Error("reply has no bound port")
+			}
+
+			conn.Write([]byte("ping"))
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(conn, echo); err != nil || !bytes.Equal(echo, []byte("ping")) {
+				t.Errorf("echo = %q, %v; want %q", echo, err, "ping")
+			}
+		})
+	}
+}
+
+// TestSOCKS5_CleanRoute tests that clients outside chaosClients still get
+// a SOCKS5 proxy
+func TestSOCKS5_CleanRoute(t *testing.T) {
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	route := config.RouteConfig{Mode: config.ModeSOCKS5, DropRate: 1, ChaosClients: []string{"10.0.0.0/8"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+
+	conn, err := net.Dial("tcp", (<-bound).String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, port, _ := net.SplitHostPort(upstream.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	conn.Write([]byte{socksVersion, 1, socksNoAuth})
+	conn.Write(socksRequest(socksConnect, "127.0.0.1", portNum))
+	conn.Write([]byte("ping"))
+
+	got := make([]byte, 2+10+4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("failed to read through proxy: %v", err)
+	}
+	if got[3] != socksSucceeded || string(got[12:]) != "ping" {
+		t.Errorf("got reply %#x and echo %q, want success and %q", got[3], got[12:], "ping")
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"io"
+	"net"
+)
+
+// spliceChunk is how much a spliced copy moves between updates of its
+// connection's traffic counters.
+const spliceChunk = 1 << 20
+
+// spliceConns returns the TCP connections underneath dst and src, if both
+// are plain TCP connections the kernel can copy between.
+func spliceConns(dst io.Writer, src io.Reader) (*net.TCPConn, *net.TCPConn, bool) {
+	if !spliceSupported {
+		return nil, nil, false
+	}
+	d, ok := dst.(*net.TCPConn)
+	if !ok {
+		return nil, nil, false
+	}
+	s, ok := src.(*net.TCPConn)
+	return d, s, ok
+}
+
+// spliceCopy copies src to dst like io.Copy, except that the bytes after
+// the first read move in the kernel, with splice on Linux, instead of
+// through the proxy. The first read goes to first, which writes it on to
+// dst, so a first-byte timer there still sees it. counted is called with
+// each chunk moved.
+func spliceCopy(dst, src *net.TCPConn, first io.Writer, bufferBytes int, counted func(int64)) (int64, error) {
+	pool := bufferPool(bufferBytes)
+	buf := pool.Get().(*[]byte)
+	n, err := src.Read(*buf)
+	if n > 0 {
+		counted(int64(n))
+		_, werr := first.Write((*buf)[:n])
+		if err == nil {
+			err = werr
+		}
+	}
+	pool.Put(buf)
+	written := int64(n)
+	if err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return written, err
+	}
+
+	for {
+		// ReadFrom stops at the chunk's end or the end of src, and splices
+		// from a TCP connection behind a LimitedReader.
+		n, err := dst.ReadFrom(&io.LimitedReader{R: src, N: spliceChunk})
+		written += n
+		counted(n)
+		if err != nil || n < spliceChunk {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build linux
+
+package proxy
+
+// spliceSupported reports whether TCPConn.ReadFrom splices between TCP
+// connections in the kernel.
+const spliceSupported = true
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package proxy
+
+// spliceSupported reports whether TCPConn.ReadFrom splices between TCP
+// connections in the kernel. Elsewhere it copies through a buffer, so the
+// proxy copies through its own pooled ones instead.
+const spliceSupported = false
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+func TestSpliceCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "one read", size: 100},
+		{name: "several chunks", size: 2*spliceChunk + 12345},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client writes into src; spliceCopy moves it from srcEnd
+			// to dst, which the test reads from dstEnd.
+			client, srcEnd := tcpPair(t)
+			dst, dstEnd := tcpPair(t)
+
+			data := bytes.Repeat([]byte("chaos"), tt.size/5+1)[:tt.size]
+			go func() {
+				client.Write(data)
+				client.Close()
+			}()
+			got := make(chan []byte, 1)
+			go func() {
+				b, _ := io.ReadAll(dstEnd)
+				got <- b
+			}()
+
+			var counted atomic.Int64
+			n, err := spliceCopy(dst, srcEnd, dst, 4096, func(n int64) { counted.Add(n) })
+			dst.CloseWrite()
+			if err != nil {
+				t.Fatalf("spliceCopy() error = %v", err)
+			}
+			if n != int64(tt.size) || counted.Load() != int64(tt.size) {
+				t.Errorf("spliceCopy() = %d, counted %d, want %d", n, counted.Load(), tt.size)
+			}
+			if b := <-got; !bytes.Equal(b, data) {
+				t.Errorf("copied %d bytes that differ from the %d written", len(b), len(data))
+			}
+		})
+	}
+}
+
+func TestSpliceConns(t *testing.T) {
+	a, b := tcpPair(t)
+	if _, _, ok := spliceConns(a, b); ok != spliceSupported {
+		t.Errorf("spliceConns(TCP, TCP) = %v, want %v", ok, spliceSupported)
+	}
+	if _, _, ok := spliceConns(io.Discard, b); ok {
+		t.Error("spliceConns(io.Discard, TCP) = true, want false")
+	}
+	if _, _, ok := spliceConns(a, &peekedConn{Conn: b}); ok {
+		t.Error("spliceConns(TCP, wrapped TCP) = true, want false")
+	}
+}
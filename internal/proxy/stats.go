@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/clienthello"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// RouteStats collects per-route connection outcomes. A nil *RouteStats is
+// valid and records nothing, so callers that don't need stats can skip it.
+type RouteStats struct {
+	connections    atomic.Int64
+	failures       atomic.Int64
+	failovers      atomic.Int64
+	firstByteTotal atomic.Int64
+	firstByteCount atomic.Int64
+	maxConnBytes   atomic.Int64
+
+	tlsMu sync.Mutex
+	tls   TLSStats
+
+	faultsMu sync.Mutex
+	faults   map[string]int64
+	closes   map[string]int64
+	delays   map[string]*delaySamples
+}
+
+// maxDelaySamples bounds the delays kept per fault, so a long run's
+// percentiles come from a uniform sample of its delays.
+const maxDelaySamples = 10000
+
+// delaySamples is a reservoir sample of the delays a fault injected.
+type delaySamples struct {
+	count   int64
+	max     time.Duration
+	samples []time.Duration
+}
+
+func (d *delaySamples) add(delay time.Duration) {
+	d.count++
+	d.max = max(d.max, delay)
+	if len(d.samples) < maxDelaySamples {
+		d.samples = append(d.samples, delay)
+	} else if i := rand.Int64N(d.count); i < maxDelaySamples {
+		d.samples[i] = delay
+	}
+}
+
+func (d *delaySamples) stats() DelayStats {
+	sorted := slices.Sorted(slices.Values(d.samples))
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return DelayStats{Count: d.count, P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: d.max}
+}
+
+// DelayStats summarizes the delays a fault injected.
+type DelayStats struct {
+	Count         int64
+	P50, P90, P99 time.Duration
+	Max           time.Duration
+}
+
+// TLSStats counts what clients offered in their TLS ClientHello, for routes
+// that capture it. Clients without SNI are counted under an empty name.
+type TLSStats struct {
+	ClientHellos int64
+	// NotTLS counts connections whose first bytes were not a ClientHello.
+	NotTLS       int64
+	Versions     map[string]int64
+	ServerNames  map[string]int64
+	ALPN         map[string]int64
+	CipherSuites map[string]int64
+}
+
+// StatsSnapshot is a point-in-time copy of RouteStats.
+type StatsSnapshot struct {
+	Connections int64
+	Failures    int64
+	// Failovers counts connections sent to the route's fallbackUpstream
+	// because their dial of its upstream failed.
+	Failovers    int64
+	AvgFirstByte time.Duration
+	// MaxConnectionBytes is the largest total (both directions) any single
+	// connection transferred.
+	MaxConnectionBytes int64
+	TLS                TLSStats
+	// Faults counts injected faults by name (drop, latency, a toxic's name,
+	// ...), matching the fault field of fault events.
+	Faults map[string]int64
+	// Closes counts finished connections by why they closed, as in their
+	// ConnRecord.
+	Closes map[string]int64
+	// Delays summarizes the delays injected, by fault name, for the faults
+	// that delay: latency, connect_latency, tarpit and the like.
+	Delays map[string]DelayStats
+}
+
+func (s *RouteStats) recordConnection() {
+	if s == nil {
+		return
+	}
+	s.connections.Add(1)
+}
+
+func (s *RouteStats) recordFailure() {
+	if s == nil {
+		return
+	}
+	s.failures.Add(1)
+}
+
+func (s *RouteStats) recordFailover() {
+	if s == nil {
+		return
+	}
+	s.failovers.Add(1)
+}
+
+func (s *RouteStats) recordFirstByte(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.firstByteTotal.Add(int64(d))
+	s.firstByteCount.Add(1)
+}
+
+func (s *RouteStats) recordBytes(total int64) {
+	if s == nil {
+		return
+	}
+	for {
+		current := s.maxConnBytes.Load()
+		if total <= current || s.maxConnBytes.CompareAndSwap(current, total) {
+			return
+		}
+	}
+}
+
+func (s *RouteStats) recordClientHello(hello clienthello.ClientHello) {
+	if s == nil {
+		return
+	}
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+
+	s.tls.ClientHellos++
+	count(&s.tls.Versions, hello.VersionName())
+	count(&s.tls.ServerNames, hello.ServerName)
+	for _, proto := range hello.ALPN {
+		count(&s.tls.ALPN, proto)
+	}
+	for _, suite := range hello.CipherSuiteNames() {
+		count(&s.tls.CipherSuites, suite)
+	}
+}
+
+func (s *RouteStats) recordNotTLS() {
+	if s == nil {
+		return
+	}
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+	s.tls.NotTLS++
+}
+
+func (s *RouteStats) recordFault(fault string) {
+	if s == nil {
+		return
+	}
+	s.faultsMu.Lock()
+	defer s.faultsMu.Unlock()
+	count(&s.faults, fault)
+}
+
+func (s *RouteStats) recordDelay(fault string, delay time.Duration) {
+	if s == nil {
+		return
+	}
+	s.faultsMu.Lock()
+	defer s.faultsMu.Unlock()
+	if s.delays == nil {
+		s.delays = make(map[string]*delaySamples)
+	}
+	if s.delays[fault] == nil {
+		s.delays[fault] = &delaySamples{}
+	}
+	s.delays[fault].add(delay)
+}
+
+func (s *RouteStats) recordClose(reason string) {
+	if s == nil {
+		return
+	}
+	s.faultsMu.Lock()
+	defer s.faultsMu.Unlock()
+	count(&s.closes, reason)
+}
+
+// count increments key in *m, allocating the map on first use.
+func count(m *map[string]int64, key string) {
+	if *m == nil {
+		*m = make(map[string]int64)
+	}
+	(*m)[key]++
+}
+
+func (s *RouteStats) Snapshot() StatsSnapshot {
+	if s == nil {
+		return StatsSnapshot{}
+	}
+
+	snapshot := StatsSnapshot{
+		Connections:        s.connections.Load(),
+		Failures:           s.failures.Load(),
+		Failovers:          s.failovers.Load(),
+		MaxConnectionBytes: s.maxConnBytes.Load(),
+	}
+	if count := s.firstByteCount.Load(); count > 0 {
+		snapshot.AvgFirstByte = time.Duration(s.firstByteTotal.Load() / count)
+	}
+
+	s.tlsMu.Lock()
+	snapshot.TLS = TLSStats{
+		ClientHellos: s.tls.ClientHellos,
+		NotTLS:       s.tls.NotTLS,
+		Versions:     maps.Clone(s.tls.Versions),
+		ServerNames:  maps.Clone(s.tls.ServerNames),
+		ALPN:         maps.Clone(s.tls.ALPN),
+		CipherSuites: maps.Clone(s.tls.CipherSuites),
+	}
+	s.tlsMu.Unlock()
+
+	s.faultsMu.Lock()
+	snapshot.Faults = maps.Clone(s.faults)
+	snapshot.Closes = maps.Clone(s.closes)
+	if len(s.delays) > 0 {
+		snapshot.Delays = make(map[string]DelayStats, len(s.delays))
+		for fault, delays := range s.delays {
+			snapshot.Delays[fault] = delays.stats()
+		}
+	}
+	s.faultsMu.Unlock()
+	return snapshot
+}
+
+// firstByteWriter reports how long after start the first byte was written.
+type firstByteWriter struct {
+	io.Writer
+	start time.Time
+	once  sync.Once
+	stats *RouteStats
+}
+
+func (w *firstByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.once.Do(func() {
+			w.stats.recordFirstByte(time.Since(w.start))
+		})
+	}
+	return w.Writer.Write(p)
+}
+
+// CheckExpectations returns a description of every expectation the snapshot
+// violates. An empty result means all expectations held.
+func CheckExpectations(expect config.Expectations, snapshot StatsSnapshot) []string {
+	var failures []string
+
+	if expect.MinConnections != nil && snapshot.Connections < *expect.MinConnections {
+		failures = append(failures, fmt.Sprintf("expected at least %d connections, got %d", *expect.MinConnections, snapshot.Connections))
+	}
+	if expect.MaxConnections != nil && snapshot.Connections > *expect.MaxConnections {
+		failures = append(failures, fmt.Sprintf("expected at most %d connections, got %d", *expect.MaxConnections, snapshot.Connections))
+	}
+	if expect.MaxFailures != nil && snapshot.Failures > *expect.MaxFailures {
+		failures = append(failures, fmt.Sprintf("expected at most %d failed connections, got %d", *expect.MaxFailures, snapshot.Failures))
+	}
+	if expect.MaxConnectionBytes != nil && snapshot.MaxConnectionBytes > *expect.MaxConnectionBytes {
+		failures = append(failures, fmt.Sprintf("expected no connection to exceed %d bytes, largest was %d", *expect.MaxConnectionBytes, snapshot.MaxConnectionBytes))
+	}
+
+	return failures
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestCheckExpectations(t *testing.T) {
+	n := func(v int64) *int64 { return &v }
+
+	snapshot := StatsSnapshot{
+		Connections:        10,
+		Failures:           2,
+		MaxConnectionBytes: 4096,
+	}
+
+	tests := []struct {
+		name        string
+		expect      config.Expectations
+		wantFailure string
+	}{
+		{
+			name:   "no expectations",
+			expect: config.Expectations{},
+		},
+		{
+			name: "all expectations hold",
+			expect: config.Expectations{
+				MinConnections:     n(10),
+				MaxConnections:     n(10),
+				MaxFailures:        n(2),
+				MaxConnectionBytes: n(4096),
+			},
+		},
+		{
+			name:        "too few connections",
+			expect:      config.Expectations{MinConnections: n(11)},
+			wantFailure: "at least 11 connections",
+		},
+		{
+			name:        "too many connections",
+			expect:      config.Expectations{MaxConnections: n(9)},
+			wantFailure: "at most 9 connections",
+		},
+		{
+			name:        "too many failures",
+			expect:      config.Expectations{MaxFailures: n(1)},
+			wantFailure: "at most 1 failed connections",
+		},
+		{
+			name:        "connection too large",
+			expect:      config.Expectations{MaxConnectionBytes: n(1024)},
+			wantFailure: "exceed 1024 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := CheckExpectations(tt.expect, snapshot)
+
+			if tt.wantFailure == "" {
+				if len(failures) != 0 {
+					t.Errorf("CheckExpectations() = %v, want no failures", failures)
+				}
+				return
+			}
+
+			if len(failures) != 1 || !strings.Contains(failures[0], tt.wantFailure) {
+				t.Errorf("CheckExpectations() = %v, want one failure containing %q", failures, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestRouteStats_RecordBytes(t *testing.T) {
+	stats := &RouteStats{}
+	stats.recordBytes(100)
+	stats.recordBytes(50)
+	stats.recordBytes(300)
+
+	if got := stats.Snapshot().MaxConnectionBytes; got != 300 {
+		t.Errorf("MaxConnectionBytes = %d, want 300", got)
+	}
+}
+
+func TestRouteStats_RecordDelay(t *testing.T) {
+	stats := &RouteStats{}
+	for i := 1; i <= 100; i++ {
+		stats.recordDelay("latency", time.Duration(i)*time.Millisecond)
+	}
+	stats.recordClose(CloseCompleted)
+	stats.recordClose("drop")
+	stats.recordClose("drop")
+
+	snapshot := stats.Snapshot()
+	got := snapshot.Delays["latency"]
+	want := DelayStats{Count: 100, P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got != want {
+		t.Errorf("Delays[latency] = %+v, want %+v", got, want)
+	}
+	if snapshot.Closes["drop"] != 2 || snapshot.Closes[CloseCompleted] != 1 {
+		t.Errorf("Closes = %v, want 2 drop and 1 completed", snapshot.Closes)
+	}
+}
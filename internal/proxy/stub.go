@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// stub stands in for the upstream of a route with a stub. It serves on a
+// loopback port of its own that only the route dials, so the route's chaos
+// sits between the client and the stub as it would in front of a real
+// upstream, resets and half-closes included.
+type stub struct {
+	listener net.Listener
+	body     []byte
+	status   int
+	header   http.Header
+	logger   *slog.Logger
+}
+
+// startStub starts route's stub, reading its body file, or returns nil if
+// the route has none. Routes in http and http2 modes get a response per
+// request, others one per chunk the client sends.
+func startStub(route config.RouteConfig, logger *slog.Logger) (*stub, error) {
+	st := route.Stub
+	if st == nil {
+		return nil, nil
+	}
+	body := []byte(st.Body)
+	if st.BodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(st.BodyFile); err != nil {
+			return nil, err
+		}
+	}
+	header := make(http.Header, len(st.Headers)+1)
+	for name, value := range st.Headers {
+		header.Set(name, value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &stub{
+		listener: listener,
+		body:     body,
+		status:   st.StatusOrDefault(),
+		header:   header,
+		logger:   logger,
+	}
+	if route.Mode == config.ModeHTTP || route.Mode == config.ModeHTTP2 {
+		go s.serveHTTP()
+	} else {
+		go s.serveTCP()
+	}
+	return s, nil
+}
+
+// addr is where the stub serves, and so the upstream of its route's
+// connections.
+func (s *stub) addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the stub. Connections already open are left to finish.
+func (s *stub) Close() error {
+	return s.listener.Close()
+}
+
+// serveTCP answers every chunk read from a connection with the body.
+func (s *stub) serveTCP() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 32*1024)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				if len(s.body) == 0 {
+					continue
+				}
+				if _, err := conn.Write(s.body); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// serveHTTP answers every request, over HTTP/1.x or cleartext HTTP/2 with
+// prior knowledge, whichever the client speaks.
+func (s *stub) serveHTTP() {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Handler:   s,
+		Protocols: protocols,
+		ErrorLog:  slog.NewLogLogger(s.logger.Handler(), slog.LevelDebug),
+	}
+	if err := srv.Serve(s.listener); !errors.Is(err, net.ErrClosed) {
+		s.logger.Debug("stub stopped serving", "error", err)
+	}
+}
+
+// ServeHTTP answers one request with the stub's response.
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	for name, values := range s.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(s.status)
+	if r.Method != http.MethodHead {
+		w.Write(s.body)
+	}
+}
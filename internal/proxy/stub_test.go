@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// startRoute serves route on a free port and returns the proxy's address.
+func startRoute(t *testing.T, route config.RouteConfig) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bound := make(chan net.Addr, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+	}()
+	select {
+	case addr := <-bound:
+		return addr.String()
+	case err := <-served:
+		t.Fatalf("route failed to start: %v", err)
+		return ""
+	}
+}
+
+// TestStub_TCP tests that a tcp stub answers every chunk the client sends,
+// with the route's chaos applied on top
+func TestStub_TCP(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "reply")
+	if err := os.WriteFile(bodyFile, []byte{0x00, 0xff, '\n'}, 0o600); err != nil {
+		t.Fatalf("failed to write body file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		route    config.RouteConfig
+		wantBody string
+		wantDrop bool
+	}{
+		{
+			name:     "inline body",
+			route:    config.RouteConfig{Stub: &config.StubConfig{Body: "+PONG\r\n"}},
+			wantBody: "+PONG\r\n",
+		},
+		{
+			name:     "body file",
+			route:    config.RouteConfig{Stub: &config.StubConfig{BodyFile: bodyFile}},
+			wantBody: "\x00\xff\n",
+		},
+		{
+			name:     "dropped",
+			route:    config.RouteConfig{DropRate: 1, Stub: &config.StubConfig{Body: "+PONG\r\n"}},
+			wantBody: "+PONG\r\n",
+			wantDrop: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", startRoute(t, tt.route))
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			for range 2 {
+				conn.Write([]byte("PING\r\n"))
+				got := make([]byte, len(tt.wantBody))
+				_, err := io.ReadFull(conn, got)
+				if tt.wantDrop {
+					if err == nil {
+						t.Error("got a reply, want the connection dropped")
+					}
+					return
+				}
+				if err != nil || string(got) != tt.wantBody {
+					t.Fatalf("reply = %q, %v; want %q", got, err, tt.wantBody)
+				}
+			}
+		})
+	}
+}
+
+// TestStub_HTTP tests that an http or http2 stub answers every request
+// with its status, headers and body
+func TestStub_HTTP(t *testing.T) {
+	stub := &config.StubConfig{
+		Body:    `{"ok":false}`,
+		Status:  http.StatusServiceUnavailable,
+		Headers: map[string]string{"Content-Type": "application/json", "Retry-After": "1"},
+	}
+	h2c := new(http.Protocols)
+	h2c.SetUnencryptedHTTP2(true)
+
+	tests := []struct {
+		name      string
+		mode      string
+		http      *config.HTTPConfig
+		protocols *http.Protocols
+		wantProto int
+		// wantStatus is expected for every request when set, and the stub's
+		// status otherwise.
+		wantStatus int
+	}{
+		{name: "http mode", mode: config.ModeHTTP, wantProto: 1},
+		{name: "http2 mode", mode: config.ModeHTTP2, protocols: h2c, wantProto: 2},
+		{
+			name:       "http mode with injected errors",
+			mode:       config.ModeHTTP,
+			http:       &config.HTTPConfig{ErrorRate: 1, ErrorStatus: http.StatusTooManyRequests},
+			wantProto:  1,
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startRoute(t, config.RouteConfig{Mode: tt.mode, HTTP: tt.http, Stub: stub})
+			transport := &http.Transport{Protocols: tt.protocols}
+			defer transport.CloseIdleConnections()
+			client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+			for range 2 {
+				resp, err := client.Post("http://"+addr+"/health", "text/plain", strings.NewReader("ping"))
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.ProtoMajor != tt.wantProto {
+					t.Errorf("protocol = %s, want HTTP/%d", resp.Proto, tt.wantProto)
+				}
+				if tt.wantStatus != 0 {
+					if resp.StatusCode != tt.wantStatus {
+						t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+					}
+					continue
+				}
+				if resp.StatusCode != stub.Status || string(body) != stub.Body {
+					t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, stub.Status, stub.Body)
+				}
+				if got := resp.Header.Get("Retry-After"); got != "1" {
+					t.Errorf("Retry-After = %q, want 1", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+)
+
+// teardown sequences the end of a proxied connection. Each direction,
+// copying one side to the other, runs under it and reports how it ended: a
+// clean end passes the FIN on as a write shutdown of the other side, so the
+// reply can still flow back, and anything else closes both sides, so the
+// other direction fails instead of waiting on a peer that will never hear
+// back. wait returns once every direction has ended, with both sides closed.
+type teardown struct {
+	client, server net.Conn
+	// beforeHalfClose runs before a FIN is passed on, for the route's FIN
+	// delay. It may be nil.
+	beforeHalfClose func()
+
+	wg    sync.WaitGroup
+	close func()
+}
+
+func newTeardown(client, server net.Conn, beforeHalfClose func()) *teardown {
+	t := &teardown{client: client, server: server, beforeHalfClose: beforeHalfClose}
+	t.close = sync.OnceFunc(func() {
+		client.Close()
+		server.Close()
+	})
+	return t
+}
+
+// run runs a direction in its own goroutine.
+func (t *teardown) run(direction func()) {
+	t.wg.Go(direction)
+}
+
+// end ends the direction writing to dst, which err ended. A connection that
+// can't half-close, or fails to, is closed outright, since its peer would
+// otherwise never learn the direction ended.
+func (t *teardown) end(dst net.Conn, err error) {
+	halfCloser, ok := dst.(interface{ CloseWrite() error })
+	if err != nil || !ok {
+		t.close()
+		return
+	}
+	if t.beforeHalfClose != nil {
+		t.beforeHalfClose()
+	}
+	if halfCloser.CloseWrite() != nil {
+		t.close()
+	}
+}
+
+// wait waits for every direction to end, then closes both sides.
+func (t *teardown) wait() {
+	t.wg.Wait()
+	t.close()
+}
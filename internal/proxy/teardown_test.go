@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTeardown_End(t *testing.T) {
+	tests := []struct {
+		name string
+		// dst picks the side end is given, from the proxy's end of the
+		// upstream connection.
+		dst       func(server net.Conn) net.Conn
+		err       error
+		wantClose bool
+	}{
+		{"clean end half-closes", func(c net.Conn) net.Conn { return c }, nil, false},
+		{"error closes both", func(c net.Conn) net.Conn { return c }, errors.New("boom"), true},
+		{"no CloseWrite closes both", func(c net.Conn) net.Conn { return struct{ net.Conn }{c} }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, peer := tcpPair(t)
+			server, upstream := tcpPair(t)
+			delayed := false
+			td := newTeardown(client, server, func() { delayed = true })
+
+			td.end(tt.dst(server), tt.err)
+
+			upstream.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := upstream.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("upstream Read() = %v, want EOF", err)
+			}
+			peer.SetDeadline(time.Now().Add(200 * time.Millisecond))
+			_, err := peer.Read(make([]byte, 1))
+			if closed := err == io.EOF; closed != tt.wantClose {
+				t.Errorf("client side closed = %v (Read() = %v), want %v", closed, err, tt.wantClose)
+			}
+			if delayed == tt.wantClose {
+				t.Errorf("beforeHalfClose ran = %v, want %v", delayed, !tt.wantClose)
+			}
+			if tt.wantClose {
+				return
+			}
+			// The reply still flows back after the half-close.
+			if _, err := upstream.Write([]byte("pong")); err != nil {
+				t.Fatalf("upstream Write() after half-close = %v", err)
+			}
+			server.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := io.ReadFull(server, make([]byte, 4)); err != nil {
+				t.Errorf("reading the reply after half-close = %v", err)
+			}
+		})
+	}
+}
+
+func TestTeardown_WaitAwaitsEveryDirection(t *testing.T) {
+	client, peer := tcpPair(t)
+	server, _ := tcpPair(t)
+	td := newTeardown(client, server, nil)
+
+	release := make(chan struct{})
+	td.run(func() { td.end(server, nil) })
+	td.run(func() { <-release })
+
+	waited := make(chan struct{})
+	go func() {
+		td.wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait() returned with a direction still running")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait() didn't return once every direction ended")
+	}
+	peer.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client side Read() after wait() = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// tlsHandshakeTimeout bounds each TLS handshake, so a client or upstream
+// that stalls mid-handshake doesn't hold its connection open forever.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// newServerTLS returns the config the route terminates client TLS with, or
+// nil when it doesn't. Files are checked when the config is loaded, but may
+// have changed since.
+func newServerTLS(route config.RouteConfig) (*tls.Config, error) {
+	if route.TLS == nil {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(route.TLS.CertFile, route.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: alpn(route.Mode)}, nil
+}
+
+// newBadCert returns the certificate the route presents in place of its own
+// when its handshake chaos asks for one, or nil when it never does. It has
+// the same names as the route's certificate, but a new key and no issuer
+// but itself, so it fails verification as self-signed, or, if expired, as
+// both expired and self-signed.
+func newBadCert(route config.RouteConfig, serverTLS *tls.Config) (*tls.Certificate, error) {
+	h := route.TLS.Handshake
+	if h == nil || h.BadCertRate <= 0 {
+		return nil, nil
+	}
+	leaf := serverTLS.Certificates[0].Leaf
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      leaf.Subject,
+		DNSNames:     leaf.DNSNames,
+		IPAddresses:  leaf.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if h.BadCertOrDefault() == config.BadCertExpired {
+		template.NotBefore = time.Now().Add(-48 * time.Hour)
+		template.NotAfter = time.Now().Add(-24 * time.Hour)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// errHandshakeAborted fails a client handshake aborted by chaos.
+var errHandshakeAborted = errors.New("tls: handshake aborted by chaos")
+
+// clientTLS returns the config to terminate one client's TLS with, rolling
+// route's handshake chaos once its ClientHello arrives. Aborts close
+// rawClient, the connection underneath, so the client gets no alert.
+func (s *routeServer) clientTLS(route config.RouteConfig, rawClient net.Conn, logger *slog.Logger) *tls.Config {
+	h := route.TLS.Handshake
+	if h == nil {
+		return s.serverTLS
+	}
+	clientAddr := rawClient.RemoteAddr().String()
+	attrs := []any{"address", clientAddr, "upstream", route.Upstream}
+
+	cfg := s.serverTLS.Clone()
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		attrs := append(attrs, "sni", hello.ServerName)
+		if s.ritual.Rand().Float64() < h.AbortRate && s.opts.decide(logger, "tls_abort", "aborting TLS handshake", attrs...) {
+			s.opts.publishFault(route, clientAddr, "tls_abort", "")
+			rawClient.Close()
+			return nil, errHandshakeAborted
+		}
+		if h.DelayMs > 0 && s.ritual.Rand().Float64() < h.DelayRateOrDefault() {
+			delay := time.Duration(h.DelayMs) * time.Millisecond
+			if s.opts.decide(logger, "tls_delay", "delaying TLS handshake", append(attrs, "delay", delay)...) {
+				s.opts.publishDelay(route, clientAddr, "tls_delay", delay)
+				time.Sleep(delay)
+				// The delay doesn't count against the handshake's own timeout.
+				rawClient.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+			}
+		}
+		if s.badCert != nil && s.ritual.Rand().Float64() < h.BadCertRate {
+			kind := h.BadCertOrDefault()
+			if s.opts.decide(logger, "tls_bad_cert", "presenting bad TLS certificate", append(attrs, "certificate", kind)...) {
+				s.opts.publishFault(route, clientAddr, "tls_bad_cert", kind)
+				bad := s.serverTLS.Clone()
+				bad.Certificates = []tls.Certificate{*s.badCert}
+				return bad, nil
+			}
+		}
+		return nil, nil
+	}
+	return cfg
+}
+
+// newUpstreamTLS returns the config the route dials its upstream with, or
+// nil when it dials plain TCP. Like newServerTLS's, its files may have
+// changed since the config was loaded.
+func newUpstreamTLS(route config.RouteConfig) (*tls.Config, error) {
+	u := route.UpstreamTLS
+	if u == nil {
+		return nil, nil
+	}
+	host, _, _ := net.SplitHostPort(route.Upstream)
+	cfg := &tls.Config{
+		ServerName:         cmp.Or(u.ServerName, host),
+		InsecureSkipVerify: u.InsecureSkipVerify,
+		NextProtos:         alpn(route.Mode),
+	}
+	if u.CAFile != "" {
+		bundle, err := os.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("no PEM certificates found")
+		}
+	}
+	if u.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(u.CertFile, u.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// alpn returns the application protocols a route in mode negotiates on
+// either side. Routes in tcp mode don't understand the protocol inside, so
+// they negotiate none.
+func alpn(mode string) []string {
+	switch mode {
+	case config.ModeHTTP:
+		return []string{"http/1.1"}
+	case config.ModeHTTP2, config.ModeGRPC:
+		return []string{"h2"}
+	default:
+		return nil
+	}
+}
+
+// handshake runs conn's TLS handshake within tlsHandshakeTimeout.
+func handshake(conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	return conn.Handshake()
+}
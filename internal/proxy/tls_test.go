@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// testCert is a self-signed certificate for 127.0.0.1, written to disk for
+// route configs.
+type testCert struct {
+	certFile, keyFile string
+	cert              tls.Certificate
+	pool              *x509.CertPool
+}
+
+func newTestCert(t *testing.T, name string) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	c := testCert{certFile: filepath.Join(dir, name+".crt"), keyFile: filepath.Join(dir, name+".key")}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	os.WriteFile(c.certFile, certPEM, 0600)
+	os.WriteFile(c.keyFile, keyPEM, 0600)
+	if c.cert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	c.pool = x509.NewCertPool()
+	c.pool.AppendCertsFromPEM(certPEM)
+	return c
+}
+
+// startTLSEchoServer starts an echo server that terminates TLS with cert.
+func startTLSEchoServer(t *testing.T, cert testCert) net.Listener {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert.cert}})
+	if err != nil {
+		t.Fatalf("failed to start TLS echo server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleEcho(conn)
+		}
+	}()
+	return listener
+}
+
+// TestTLS_TCPMode tests that a route terminating TLS applies its chaos to
+// the plaintext, and only re-encrypts to upstreams it trusts
+func TestTLS_TCPMode(t *testing.T) {
+	proxyCert := newTestCert(t, "proxy")
+	upstreamCert := newTestCert(t, "upstream")
+	upstream := startTLSEchoServer(t, upstreamCert)
+
+	tests := []struct {
+		name        string
+		upstreamTLS *config.UpstreamTLSConfig
+		want        string
+	}{
+		{
+			name:        "trusted upstream",
+			upstreamTLS: &config.UpstreamTLSConfig{CAFile: upstreamCert.certFile},
+			want:        "AAAA",
+		},
+		{
+			name:        "untrusted upstream",
+			upstreamTLS: &config.UpstreamTLSConfig{},
+			want:        "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{
+				LocalPort:   findFreePort(t),
+				Upstream:    upstream.Addr().String(),
+				TLS:         &config.TLSConfig{CertFile: proxyCert.certFile, KeyFile: proxyCert.keyFile},
+				UpstreamTLS: tt.upstreamTLS,
+				Toxics: []config.ToxicConfig{
+					{Type: config.ToxicTruncate, Bytes: 4, Stream: config.StreamDownstream},
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, route, ServeOptions{})
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort), &tls.Config{RootCAs: proxyCert.pool})
+			if err != nil {
+				t.Fatalf("failed to connect to proxy over TLS: %v", err)
+			}
+			defer client.Close()
+
+			client.Write([]byte("AAAABBBB"))
+			client.SetReadDeadline(time.Now().Add(1 * time.Second))
+			got, _ := io.ReadAll(client)
+			if string(got) != tt.want {
+				t.Errorf("received %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTLS_UpstreamClientCert tests that a plaintext route presents its
+// client certificate to an upstream that requires one
+func TestTLS_UpstreamClientCert(t *testing.T) {
+	upstreamCert := newTestCert(t, "upstream")
+	clientCert := newTestCert(t, "client")
+	upstream, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{upstreamCert.cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCert.pool,
+	})
+	if err != nil {
+		t.Fatalf("failed to start TLS echo server: %v", err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go handleEcho(conn)
+		}
+	}()
+
+	tests := []struct {
+		name        string
+		upstreamTLS *config.UpstreamTLSConfig
+		want        string
+	}{
+		{
+			name:        "with client certificate",
+			upstreamTLS: &config.UpstreamTLSConfig{CAFile: upstreamCert.certFile, CertFile: clientCert.certFile, KeyFile: clientCert.keyFile},
+			want:        "ping",
+		},
+		{
+			name:        "without client certificate",
+			upstreamTLS: &config.UpstreamTLSConfig{CAFile: upstreamCert.certFile},
+			want:        "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{
+				LocalPort:   findFreePort(t),
+				Upstream:    upstream.Addr().String(),
+				UpstreamTLS: tt.upstreamTLS,
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, route, ServeOptions{})
+			time.Sleep(50 * time.Millisecond)
+
+			client, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort))
+			if err != nil {
+				t.Fatalf("failed to connect to proxy: %v", err)
+			}
+			defer client.Close()
+
+			client.Write([]byte("ping"))
+			client.SetReadDeadline(time.Now().Add(1 * time.Second))
+			got := make([]byte, 4)
+			n, _ := io.ReadFull(client, got)
+			if string(got[:n]) != tt.want {
+				t.Errorf("received %q, want %q", got[:n], tt.want)
+			}
+		})
+	}
+}
+
+// TestTLS_HTTP2Mode tests that a route in http2 mode negotiates h2 with
+// clients over TLS
+func TestTLS_HTTP2Mode(t *testing.T) {
+	proxyCert := newTestCert(t, "proxy")
+	url, _ := startH2Mode(t, config.RouteConfig{
+		Mode: config.ModeHTTP2,
+		TLS:  &config.TLSConfig{CertFile: proxyCert.certFile, KeyFile: proxyCert.keyFile},
+	}, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: proxyCert.pool}, ForceAttemptHTTP2: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 2 * time.Second}
+
+	resp, err := client.Get("https" + url[len("http"):])
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 2 || string(body) != "hello" {
+		t.Errorf("got %s %q, want HTTP/2.0 %q", resp.Proto, body, "hello")
+	}
+}
+
+// TestTLS_HandshakeChaos tests that handshake chaos delays, aborts or
+// presents a bad certificate to clients before any data flows
+func TestTLS_HandshakeChaos(t *testing.T) {
+	proxyCert := newTestCert(t, "proxy")
+	upstream := startTestEchoServer(t)
+	defer upstream.Close()
+
+	tests := []struct {
+		name      string
+		handshake config.TLSHandshakeConfig
+		check     func(t *testing.T, err error, elapsed time.Duration)
+	}{
+		{
+			name:      "delay",
+			handshake: config.TLSHandshakeConfig{DelayMs: 150},
+			check: func(t *testing.T, err error, elapsed time.Duration) {
+				if err != nil || elapsed < 150*time.Millisecond {
+					t.Errorf("handshake took %v with error %v, want at least 150ms and success", elapsed, err)
+				}
+			},
+		},
+		{
+			name:      "abort",
+			handshake: config.TLSHandshakeConfig{AbortRate: 1},
+			check: func(t *testing.T, err error, _ time.Duration) {
+				var alert tls.AlertError
+				if err == nil || errors.As(err, &alert) {
+					t.Errorf("handshake error = %v, want the connection closed without an alert", err)
+				}
+			},
+		},
+		{
+			name:      "self-signed certificate",
+			handshake: config.TLSHandshakeConfig{BadCertRate: 1},
+			check: func(t *testing.T, err error, _ time.Duration) {
+				var unknown x509.UnknownAuthorityError
+				if !errors.As(err, &unknown) {
+					t.Errorf("handshake error = %v, want an unknown authority", err)
+				}
+			},
+		},
+		{
+			name:      "expired certificate",
+			handshake: config.TLSHandshakeConfig{BadCertRate: 1, BadCert: config.BadCertExpired},
+			check: func(t *testing.T, err error, _ time.Duration) {
+				var invalid x509.CertificateInvalidError
+				if !errors.As(err, &invalid) || invalid.Reason != x509.Expired {
+					t.Errorf("handshake error = %v, want an expired certificate", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := config.RouteConfig{
+				LocalPort: findFreePort(t),
+				Upstream:  upstream.Addr().String(),
+				TLS:       &config.TLSConfig{CertFile: proxyCert.certFile, KeyFile: proxyCert.keyFile, Handshake: &tt.handshake},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go ServeRoute(ctx, route, ServeOptions{})
+			time.Sleep(50 * time.Millisecond)
+
+			dialer := &net.Dialer{Timeout: 2 * time.Second}
+			start := time.Now()
+			client, err := tls.DialWithDialer(dialer, "tcp", fmt.Sprintf("127.0.0.1:%d", route.LocalPort), &tls.Config{RootCAs: proxyCert.pool})
+			elapsed := time.Since(start)
+			if err == nil {
+				client.Close()
+			}
+			tt.check(t, err, elapsed)
+		})
+	}
+}
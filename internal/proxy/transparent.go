@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// errNotRedirected is returned for a connection to a transparent route that
+// was dialled directly instead of being redirected to it.
+var errNotRedirected = errors.New("connection was not redirected to the route: its original destination is the route's own listener")
+
+// originalDestination returns the address a client redirected to a
+// transparent route's listener originally dialled. Connections delivered by
+// TPROXY keep their destination as the local address, so that stands in when
+// the kernel has no NAT record of the connection. port is the listener's own
+// port: a destination on this host and that port is the listener itself,
+// which the route would otherwise dial in a loop. The destination's port is
+// returned too, for the route's port rules.
+func originalDestination(client net.Conn, port int) (string, int, error) {
+	dst, err := lookupOriginalDst(client)
+	if err != nil {
+		local, parseErr := netip.ParseAddrPort(client.LocalAddr().String())
+		if parseErr != nil {
+			return "", 0, err
+		}
+		dst = local
+	}
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+	if int(dst.Port()) == port && isHostAddr(dst.Addr()) {
+		return "", 0, errNotRedirected
+	}
+	return dst.String(), int(dst.Port()), nil
+}
+
+// lookupOriginalDst is originalDst, replaced in tests, which have no NAT
+// records to read.
+var lookupOriginalDst = originalDst
+
+// isHostAddr reports whether addr belongs to this host, unlike the foreign
+// addresses TPROXY delivers connections for.
+func isHostAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return true
+	}
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ifaceAddr := range ifaceAddrs {
+		if prefix, err := netip.ParsePrefix(ifaceAddr.String()); err == nil && prefix.Addr().Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// Socket options for transparent proxying, from <linux/netfilter_ipv4.h>,
+// <linux/netfilter_ipv6/ip6_tables.h> and <linux/in6.h>.
+const (
+	soOriginalDst     = 80
+	ip6tSOOriginalDst = 80
+	ipv6Transparent   = 75
+)
+
+// listenTransparent listens on addr with IP_TRANSPARENT set, so TPROXY rules
+// can deliver connections addressed to other hosts to it. Setting it needs
+// CAP_NET_ADMIN; without that the listener is opened anyway, since
+// connections redirected with REDIRECT or DNAT don't need it.
+func listenTransparent(addr string, logger *slog.Logger) (net.Listener, error) {
+	var optErr error
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+			if optErr == nil && network == "tcp6" {
+				optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+			}
+		})
+	}}
+	listener, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if optErr != nil {
+		logger.Warn("transparent listener can't accept TPROXY connections",
+			"error", optErr,
+			"hint", "TPROXY needs CAP_NET_ADMIN; connections redirected with REDIRECT or DNAT are still served")
+	}
+	return listener, nil
+}
+
+// originalDst asks netfilter for the destination a NATed connection was
+// addressed to before it was redirected.
+func originalDst(conn net.Conn) (netip.AddrPort, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("%T has no socket to look up the original destination of", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	local, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	var dst netip.AddrPort
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		// The kernel fills in a sockaddr_in or sockaddr_in6; these getters
+		// are the stdlib's only ones with a buffer big enough for each.
+		if local.Addr().Unmap().Is4() {
+			var mreq *syscall.IPv6Mreq
+			if mreq, optErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst); optErr == nil {
+				sa := mreq.Multiaddr
+				ip := netip.AddrFrom4([4]byte(sa[4:8]))
+				dst = netip.AddrPortFrom(ip, binary.BigEndian.Uint16(sa[2:4]))
+			}
+			return
+		}
+		var info *syscall.IPv6MTUInfo
+		if info, optErr = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSOOriginalDst); optErr == nil {
+			port := binary.BigEndian.Uint16(binary.NativeEndian.AppendUint16(nil, info.Addr.Port))
+			dst = netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr), port)
+		}
+	})
+	if err == nil {
+		err = optErr
+	}
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to look up original destination: %w", err)
+	}
+	return dst, nil
+}
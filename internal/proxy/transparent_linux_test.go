@@ -0,0 +1,49 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// TestTransparent_NotRedirected tests that a transparent route closes
+// connections dialled straight to it instead of dialling itself
+func TestTransparent_NotRedirected(t *testing.T) {
+	route := config.RouteConfig{Mode: config.ModeTransparent}
+	bus := events.NewBus()
+	sub, unsubscribe := bus.Subscribe(16)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{Events: bus, OnListen: func(addr net.Addr) { bound <- addr }})
+
+	conn, err := net.Dial("tcp", (<-bound).String())
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("ping"))
+	if n, err := conn.Read(make([]byte, 4)); err == nil {
+		t.Fatalf("read %d bytes, want the connection closed", n)
+	}
+
+	for _, want := range []events.Type{events.ConnectionOpen, events.ConnectionError} {
+		select {
+		case e := <-sub:
+			if e.Type != want {
+				t.Fatalf("event type = %q, want %q", e.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q event", want)
+		}
+	}
+}
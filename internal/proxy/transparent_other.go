@@ -0,0 +1,20 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+)
+
+var errTransparentUnsupported = errors.New("transparent mode requires Linux")
+
+func listenTransparent(addr string, logger *slog.Logger) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(conn net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, errTransparentUnsupported
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// redirectedConn is a connection whose local address is whatever it was
+// delivered to, as for a connection delivered by TPROXY.
+type redirectedConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c redirectedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// TestOriginalDestination tests that a connection's local address stands in
+// for its original destination, and that connections dialled straight to
+// the listener are refused
+func TestOriginalDestination(t *testing.T) {
+	const listenerPort = 15001
+
+	tests := []struct {
+		name    string
+		local   string
+		want    string
+		wantErr error
+	}{
+		{name: "ipv4 destination", local: "203.0.113.5:5432", want: "203.0.113.5:5432"},
+		{name: "ipv6 destination", local: "[2001:db8::5]:443", want: "[2001:db8::5]:443"},
+		{name: "v4-mapped destination", local: "[::ffff:203.0.113.5]:6379", want: "203.0.113.5:6379"},
+		{name: "another host on the listener's port", local: "203.0.113.5:15001", want: "203.0.113.5:15001"},
+		{name: "dialled directly", local: "127.0.0.1:15001", wantErr: errNotRedirected},
+		{name: "dialled directly over v4-mapped", local: "[::ffff:127.0.0.1]:15001", wantErr: errNotRedirected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, err := net.ResolveTCPAddr("tcp", tt.local)
+			if err != nil {
+				t.Fatalf("bad test address %s: %v", tt.local, err)
+			}
+			got, _, err := originalDestination(redirectedConn{local: local}, listenerPort)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("originalDestination() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("originalDestination() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTransparent_PortRules tests that a transparent route's connections
+// get the chaos of the port rule for the port they were originally headed
+// to, and the route's own without one.
+func TestTransparent_PortRules(t *testing.T) {
+	var dst atomic.Pointer[netip.AddrPort]
+	lookupOriginalDst = func(net.Conn) (netip.AddrPort, error) { return *dst.Load(), nil }
+	t.Cleanup(func() { lookupOriginalDst = originalDst })
+
+	dropped, delayed, clean := startTestEchoServer(t), startTestEchoServer(t), startTestEchoServer(t)
+	defer dropped.Close()
+	defer delayed.Close()
+	defer clean.Close()
+	port := func(l net.Listener) int { return l.Addr().(*net.TCPAddr).Port }
+	always := 1.0
+	delay := config.Milliseconds(300)
+	route := config.RouteConfig{
+		Mode: config.ModeTransparent,
+		PortRules: []config.PortRule{
+			{Ports: config.PortRange{First: port(dropped), Last: port(dropped)}, DropRate: &always},
+			{Ports: config.PortRange{First: port(delayed), Last: port(delayed)}, LatencyMs: &delay},
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ServeRoute(ctx, route, ServeOptions{Listener: listener})
+
+	tests := []struct {
+		name     string
+		upstream net.Listener
+		wantEcho bool
+		minDelay time.Duration
+	}{
+		{name: "dropped port", upstream: dropped},
+		{name: "delayed port", upstream: delayed, wantEcho: true, minDelay: 250 * time.Millisecond},
+		{name: "port without a rule", upstream: clean, wantEcho: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := netip.MustParseAddrPort(tt.upstream.Addr().String())
+			dst.Store(&addr)
+
+			start := time.Now()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			elapsed := time.Since(start)
+
+			if !tt.wantEcho {
+				if err == nil {
+					t.Errorf("got echo %q, want the connection dropped", buf)
+				}
+				return
+			}
+			if err != nil || string(buf) != "ping" {
+				t.Fatalf("echo = %q, %v, want ping", buf, err)
+			}
+			if elapsed < tt.minDelay {
+				t.Errorf("echo took %v, want at least %v", elapsed, tt.minDelay)
+			}
+			if tt.minDelay == 0 && elapsed >= 250*time.Millisecond {
+				t.Errorf("echo took %v, want it without the delayed rule's latency", elapsed)
+			}
+		})
+	}
+}
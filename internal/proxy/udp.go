@@ -0,0 +1,368 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/events"
+)
+
+// udpSessionTimeout is how long a client's flow is kept without a datagram
+// in either direction, like a NAT's UDP mapping, before its upstream socket
+// is closed.
+const udpSessionTimeout = time.Minute
+
+// maxDatagram is the largest UDP payload.
+const maxDatagram = 65535
+
+// serveUDP is ServeRoute for routes relaying UDP datagrams.
+func serveUDP(ctx context.Context, route config.RouteConfig, opts ServeOptions, routeLogger *slog.Logger, addr string) error {
+	upstream, err := net.ResolveUDPAddr("udp", route.Upstream)
+	if err != nil {
+		routeLogger.Error("failed to resolve upstream", "upstream", route.Upstream, "error", err, "hint", "upstream must be a host:port UDP address")
+		return fmt.Errorf("failed to resolve upstream: %w", err)
+	}
+
+	routeLogger.Info("starting UDP listener", "address", addr)
+	listener, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		routeLogger.Error("failed to start listener", "error", err, "hint", "port may be in use or you may need elevated permissions")
+		return fmt.Errorf("failed to start listener: %w", err)
+	}
+	defer listener.Close()
+	opts.listening(route, listener.LocalAddr(), listener.LocalAddr().(*net.UDPAddr).Port, routeLogger)
+
+	relay := &udpRelay{
+		listener:     listener,
+		upstream:     upstream,
+		route:        route,
+		cleanRoute:   route.WithoutChaos(),
+		ritual:       newRitual(route, opts.intensity(route), opts.Memory),
+		chaosClients: route.ChaosPrefixes(),
+		logger:       routeLogger,
+		opts:         opts,
+		sessions:     make(map[string]*udpSession),
+	}
+	routeLogger.Debug("rolling route chaos", "seed", relay.ritual.Rand().Seed(), "hint", "set the route's seed to this to roll the same way again")
+
+	go func() {
+		<-ctx.Done()
+		routeLogger.Debug("context cancelled, closing listener", "address", addr)
+		listener.Close()
+	}()
+	return relay.serve()
+}
+
+// udpRelay is a UDP route's state: its listening socket and a session per
+// client address.
+type udpRelay struct {
+	listener     net.PacketConn
+	upstream     *net.UDPAddr
+	route        config.RouteConfig
+	cleanRoute   config.RouteConfig
+	ritual       chaos.Ritual
+	chaosClients []netip.Prefix
+	logger       *slog.Logger
+	opts         ServeOptions
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	wg       sync.WaitGroup
+}
+
+// serve relays datagrams from clients until the listener is closed, then
+// closes every session.
+func (r *udpRelay) serve() error {
+	defer r.closeSessions()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := r.listener.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				r.logger.Debug("listener closed")
+				return nil
+			}
+			r.logger.Error("failed to read datagram", "error", err, "hint", "listener may have been closed unexpectedly")
+			return fmt.Errorf("failed to read datagram: %w", err)
+		}
+
+		if !r.opts.Control.Enabled() {
+			r.logger.Debug("route disabled, discarding datagram", "address", client)
+			continue
+		}
+		session := r.session(client)
+		if session == nil {
+			continue
+		}
+		r.opts.Control.bytesToServer.Add(int64(n))
+		session.tracked.bytesToServer.Add(int64(n))
+		session.send(session.toServer, chaos.Upstream, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// session returns client's session, opening one and its upstream socket
+// on the client's first datagram. It returns nil if the upstream socket
+// can't be opened.
+func (r *udpRelay) session(client net.Addr) *udpSession {
+	clientAddr := client.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.sessions[clientAddr]; ok {
+		return s
+	}
+
+	stats := r.opts.Stats
+	stats.recordConnection()
+	id := connIDs.Add(1)
+	logger := r.logger.With("conn", id)
+
+	server, err := net.DialUDP("udp", nil, r.upstream)
+	if err != nil {
+		logger.Error("failed to connect to upstream", "error", err, "hint", fmt.Sprintf("check that %s is a reachable UDP address", r.route.Upstream))
+		stats.recordFailure()
+		tracked := r.opts.Control.track(id, clientAddr, r.route.Upstream, func() {})
+		r.opts.publish(r.route, events.ConnectionOpen, clientAddr, nil)
+		r.opts.publish(r.route, events.ConnectionError, clientAddr, func(e *events.Event) { e.Detail = err.Error() })
+		r.opts.closeConn(r.route, tracked)
+		return nil
+	}
+	// Closing the upstream socket ends the session as going quiet would.
+	tracked := r.opts.Control.track(id, clientAddr, r.route.Upstream, func() { server.Close() })
+	r.opts.publish(r.route, events.ConnectionOpen, clientAddr, nil)
+
+	route, ritual := r.route, r.ritual
+	if !r.opts.Control.ChaosEnabled() {
+		logger.Debug("route chaos switched off, relaying without chaos", "address", clientAddr)
+		route, ritual = r.cleanRoute, chaos.Ritual{}
+	} else if !targetsClient(r.chaosClients, client) {
+		logger.Debug("client outside chaosClients, relaying without chaos", "address", clientAddr)
+		route, ritual = r.cleanRoute, chaos.Ritual{}
+	}
+
+	s := &udpSession{
+		relay:      r,
+		route:      route,
+		ritual:     ritual,
+		clientAddr: clientAddr,
+		server:     server,
+		logger:     logger,
+		dns:        newDNSChaos(route, ritual, clientAddr, logger, r.opts),
+		tracked:    tracked,
+	}
+	s.lastSeen.Store(time.Now().UnixNano())
+	var toClient, toServer io.Writer = datagramWriter{conn: r.listener, addr: client}, server
+	attrs := []any{"address", clientAddr, "upstream", route.Upstream}
+	toClient, toServer = ritual.Pipeline().Wrap(ritual.Rand(), toClient, toServer, func(stage chaos.Stage) bool {
+		if !r.opts.decide(logger, stage.Toxic.Name(), "applying toxic", append(attrs, "toxic", stage.Toxic.Name(), "stream", stage.Stream)...) {
+			return false
+		}
+		r.opts.publishFault(route, clientAddr, stage.Toxic.Name(), stage.Stream.String())
+		return true
+	})
+	s.toClient = &countingWriter{dst: toClient}
+	s.toServer = &countingWriter{dst: toServer}
+
+	// Latency applies to every reply of the session, so it is decided and
+	// reported once.
+	if route.LatencyMs > 0 {
+		delay := newCurse(route, ritual, clientAddr).StartDelay
+		if r.opts.decide(logger, "latency", "delaying datagrams", append(attrs, "delay", delay)...) {
+			r.opts.publishDelay(route, clientAddr, "latency", delay)
+			s.delayed = true
+		}
+	}
+
+	logger.Info("opened UDP session", attrs...)
+	r.opts.Control.opened()
+	r.sessions[clientAddr] = s
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		s.relayReplies()
+	}()
+	return s
+}
+
+// closeSessions closes every session and waits for them to finish.
+func (r *udpRelay) closeSessions() {
+	r.mu.Lock()
+	for _, s := range r.sessions {
+		s.server.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// udpSession is one client address's flow through the route. It has an
+// upstream socket of its own, so replies find their way back to the client.
+type udpSession struct {
+	relay      *udpRelay
+	route      config.RouteConfig
+	ritual     chaos.Ritual
+	clientAddr string
+	server     *net.UDPConn
+	logger     *slog.Logger
+	// delayed is set when the session's datagrams get the route's latency.
+	delayed bool
+	// dns is the session's DNS chaos, or nil if the route isn't in dns mode.
+	dns *dnsChaos
+
+	// toClient and toServer count the bytes of datagrams that weren't
+	// dropped, before any duplicates.
+	toClient, toServer *countingWriter
+	lastSeen           atomic.Int64
+	// held is the bytes of delayed datagrams waiting to be sent.
+	held atomic.Int64
+	// tracked lists the session among the route's open connections.
+	tracked *openConn
+}
+
+// send rolls the route's per-datagram chaos for packet and writes it to dst,
+// after the route's latency if the session is delayed and packet is a reply.
+// In dns mode a query may instead be answered with an injected error, and a
+// reply may have its TTLs rewritten or be held back further.
+func (s *udpSession) send(dst io.Writer, stream chaos.Stream, packet []byte) {
+	s.lastSeen.Store(time.Now().UnixNano())
+	opts := s.relay.opts
+
+	curse := newCurse(s.route, s.ritual, s.clientAddr)
+	if curse.DropConnections && opts.decide(s.logger, "drop", "dropping datagram", "address", s.clientAddr, "upstream", s.route.Upstream, "stream", stream, "bytes", len(packet)) {
+		opts.Stats.recordFailure()
+		opts.publishFault(s.route, s.clientAddr, "drop", stream.String())
+		return
+	}
+	// Like a TCP route's latency, the delay holds back the upstream's side,
+	// so it is added to every round trip once.
+	var delay time.Duration
+	if s.delayed && stream == chaos.Downstream {
+		delay = curse.StartDelay
+	}
+	if s.dns != nil {
+		if stream == chaos.Upstream {
+			if resp := s.dns.fail(packet); resp != nil {
+				s.toClient.Write(resp)
+				return
+			}
+		} else {
+			delay += s.dns.respond(packet)
+		}
+	}
+	if delay > 0 {
+		if !s.hold(len(packet)) {
+			s.logger.Debug("dropping delayed datagram over the buffering limit",
+				"address", s.clientAddr,
+				"upstream", s.route.Upstream,
+				"bytes", len(packet),
+				"hint", "raise maxBufferedBytes or -max-buffered-memory, or lower the latency, to hold more datagrams back")
+			return
+		}
+		time.AfterFunc(delay, func() {
+			dst.Write(packet)
+			s.release(len(packet))
+		})
+		return
+	}
+	dst.Write(packet)
+}
+
+// hold reserves n bytes for a delayed datagram, if they fit under the
+// route's maxBufferedBytes and the memory budget. Past them the datagram is
+// dropped, as by a router whose queue is full.
+func (s *udpSession) hold(n int) bool {
+	if s.held.Add(int64(n)) > int64(s.route.MaxBufferedBytesOrDefault()) {
+		s.held.Add(-int64(n))
+		return false
+	}
+	if !s.relay.opts.Memory.Reserve(n) {
+		s.held.Add(-int64(n))
+		return false
+	}
+	return true
+}
+
+// release gives back n bytes held for a delayed datagram once it is sent.
+func (s *udpSession) release(n int) {
+	s.held.Add(-int64(n))
+	s.relay.opts.Memory.Release(n)
+}
+
+// relayReplies passes the upstream's datagrams back to the client until
+// the session goes quiet for udpSessionTimeout or is closed, then closes
+// it.
+func (s *udpSession) relayReplies() {
+	defer s.close()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		last := time.Unix(0, s.lastSeen.Load())
+		s.server.SetReadDeadline(last.Add(udpSessionTimeout))
+		n, err := s.server.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, net.ErrClosed):
+				return
+			case errors.As(err, &netErr) && netErr.Timeout():
+				// The client may have sent something since the deadline was set.
+				if time.Since(time.Unix(0, s.lastSeen.Load())) >= udpSessionTimeout {
+					s.logger.Debug("UDP session idle, closing it", "address", s.clientAddr, "upstream", s.route.Upstream, "timeout", udpSessionTimeout)
+					return
+				}
+			default:
+				// Typically an ICMP port unreachable from an upstream that
+				// isn't listening; the next datagram may still get through.
+				s.logger.Debug("failed to read from upstream", "address", s.clientAddr, "upstream", s.route.Upstream, "error", err)
+			}
+			continue
+		}
+		s.relay.opts.Control.bytesToClient.Add(int64(n))
+		s.tracked.bytesToClient.Add(int64(n))
+		s.send(s.toClient, chaos.Downstream, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// close releases anything the session's toxics still hold, closes its
+// upstream socket and reports it like a closed connection.
+func (s *udpSession) close() {
+	r := s.relay
+	r.mu.Lock()
+	delete(r.sessions, s.clientAddr)
+	r.mu.Unlock()
+	r.opts.Control.closed()
+
+	chaos.Flush(s.toServer)
+	chaos.Flush(s.toClient)
+	s.server.Close()
+
+	bytesToClient, bytesToServer := s.toClient.n.Load(), s.toServer.n.Load()
+	r.opts.Stats.recordBytes(bytesToClient + bytesToServer)
+	s.logger.Info(fmt.Sprintf("bytes transferred: %d", bytesToClient+bytesToServer),
+		"bytes_to_client", bytesToClient,
+		"bytes_to_server", bytesToServer)
+	r.opts.publish(r.route, events.ConnectionClose, s.clientAddr, func(e *events.Event) {
+		e.BytesToClient = bytesToClient
+		e.BytesToServer = bytesToServer
+	})
+	r.opts.closeConn(s.route, s.tracked)
+}
+
+// datagramWriter sends each write to addr as one datagram.
+type datagramWriter struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (w datagramWriter) Write(p []byte) (int, error) {
+	return w.conn.WriteTo(p, w.addr)
+}
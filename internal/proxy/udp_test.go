@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+// startUDPEchoServer starts a UDP server that sends every datagram back to
+// its sender.
+func startUDPEchoServer(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start UDP echo server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+// startUDPRoute serves route in front of a UDP echo server and returns the
+// proxy's address.
+func startUDPRoute(t *testing.T, route config.RouteConfig) string {
+	t.Helper()
+	upstream := startUDPEchoServer(t)
+	route.Protocol = config.ProtocolUDP
+	route.Upstream = upstream.LocalAddr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bound := make(chan net.Addr, 1)
+	go ServeRoute(ctx, route, ServeOptions{OnListen: func(addr net.Addr) { bound <- addr }})
+	select {
+	case addr := <-bound:
+		return addr.String()
+	case <-time.After(1 * time.Second):
+		t.Fatal("UDP route did not start listening")
+		return ""
+	}
+}
+
+// readDatagrams reads datagrams from conn until none arrives for wait.
+func readDatagrams(conn net.Conn, wait time.Duration) [][]byte {
+	var got [][]byte
+	buf := make([]byte, maxDatagram)
+	for {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		n, err := conn.Read(buf)
+		if err != nil {
+			return got
+		}
+		got = append(got, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// TestUDP_Relay tests that each client's datagrams reach the upstream and
+// its replies come back to that client only
+func TestUDP_Relay(t *testing.T) {
+	addr := startUDPRoute(t, config.RouteConfig{})
+
+	clients := make([]net.Conn, 3)
+	for i := range clients {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial proxy: %v", err)
+		}
+		defer conn.Close()
+		clients[i] = conn
+	}
+
+	for round := range 5 {
+		for i, conn := range clients {
+			msg := fmt.Appendf(nil, "client %d round %d", i, round)
+			conn.Write(msg)
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			buf := make([]byte, 64)
+			n, err := conn.Read(buf)
+			if err != nil || !bytes.Equal(buf[:n], msg) {
+				t.Fatalf("client %d round %d got %q, error %v, want %q", i, round, buf[:n], err, msg)
+			}
+		}
+	}
+}
+
+// TestUDP_Chaos tests that drops, latency and duplicates apply per datagram
+func TestUDP_Chaos(t *testing.T) {
+	tests := []struct {
+		name  string
+		route config.RouteConfig
+		check func(t *testing.T, replies [][]byte, elapsed time.Duration)
+	}{
+		{
+			name:  "every datagram dropped",
+			route: config.RouteConfig{DropRate: 1},
+			check: func(t *testing.T, replies [][]byte, _ time.Duration) {
+				if len(replies) != 0 {
+					t.Errorf("got %d replies, want none", len(replies))
+				}
+			},
+		},
+		{
+			name:  "replies delayed",
+			route: config.RouteConfig{LatencyMs: 100},
+			check: func(t *testing.T, replies [][]byte, elapsed time.Duration) {
+				if len(replies) != 1 || elapsed < 100*time.Millisecond {
+					t.Errorf("got %d replies after %v, want 1 after at least 100ms", len(replies), elapsed)
+				}
+			},
+		},
+		{
+			name:  "duplicated both ways",
+			route: config.RouteConfig{DuplicateRate: 1},
+			check: func(t *testing.T, replies [][]byte, _ time.Duration) {
+				if len(replies) != 4 {
+					t.Errorf("got %d replies, want 4 (each copy of the request echoed and duplicated)", len(replies))
+				}
+			},
+		},
+		{
+			name:  "clients outside chaosClients relayed cleanly",
+			route: config.RouteConfig{DropRate: 1, ChaosClients: []string{"10.0.0.0/8"}},
+			check: func(t *testing.T, replies [][]byte, _ time.Duration) {
+				if len(replies) != 1 {
+					t.Errorf("got %d replies, want 1", len(replies))
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("udp", startUDPRoute(t, tt.route))
+			if err != nil {
+				t.Fatalf("failed to dial proxy: %v", err)
+			}
+			defer conn.Close()
+
+			start := time.Now()
+			conn.Write([]byte("ping"))
+			conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+			buf := make([]byte, maxDatagram)
+			var replies [][]byte
+			var elapsed time.Duration
+			if n, err := conn.Read(buf); err == nil {
+				elapsed = time.Since(start)
+				replies = append(replies, buf[:n])
+				replies = append(replies, readDatagrams(conn, 100*time.Millisecond)...)
+			}
+			tt.check(t, replies, elapsed)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// valueType is the type of an expression, checked when a script compiles
+// so a running script never meets a type error.
+type valueType int
+
+const (
+	typeNumber valueType = iota
+	typeString
+	typeBool
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	default:
+		return "bool"
+	}
+}
+
+// value holds the result of an expression in the field of its type.
+type value struct {
+	n float64
+	s string
+	b bool
+}
+
+type expr struct {
+	typ  valueType
+	eval func(*env) value
+}
+
+func constant(v value, typ valueType) expr {
+	return expr{typ: typ, eval: func(*env) value { return v }}
+}
+
+// env is what one decision's expressions read.
+type env struct {
+	in                Input
+	clientConnections int
+	clientRequests    int
+	routeConnections  int
+	routeRequests     int
+	elapsed           float64
+	clientIP          string
+	clientPort        float64
+	payload           string
+}
+
+type variable struct {
+	typ  valueType
+	eval func(*env) value
+}
+
+// variables are the names a script can read, documented in the README's
+// Scripting section.
+var variables = map[string]variable{
+	"client.ip":          {typeString, func(e *env) value { return value{s: e.clientIP} }},
+	"client.port":        {typeNumber, func(e *env) value { return value{n: e.clientPort} }},
+	"client.connections": {typeNumber, func(e *env) value { return value{n: float64(e.clientConnections)} }},
+	"client.requests":    {typeNumber, func(e *env) value { return value{n: float64(e.clientRequests)} }},
+	"route.connections":  {typeNumber, func(e *env) value { return value{n: float64(e.routeConnections)} }},
+	"route.requests":     {typeNumber, func(e *env) value { return value{n: float64(e.routeRequests)} }},
+	"request.method":     {typeString, func(e *env) value { return value{s: e.in.Method} }},
+	"request.path":       {typeString, func(e *env) value { return value{s: e.in.Path} }},
+	"request.host":       {typeString, func(e *env) value { return value{s: e.in.Host} }},
+	"payload":            {typeString, func(e *env) value { return value{s: e.payload} }},
+	"elapsed":            {typeNumber, func(e *env) value { return value{n: e.elapsed} }},
+	"time.unix":          {typeNumber, func(e *env) value { return value{n: float64(e.in.Now.Unix())} }},
+	"time.hour":          {typeNumber, func(e *env) value { return value{n: float64(e.in.Now.UTC().Hour())} }},
+	"time.minute":        {typeNumber, func(e *env) value { return value{n: float64(e.in.Now.UTC().Minute())} }},
+	"time.second":        {typeNumber, func(e *env) value { return value{n: float64(e.in.Now.UTC().Second())} }},
+	"rand":               {typeNumber, func(*env) value { return value{n: rand.Float64()} }},
+}
+
+type function struct {
+	params []valueType
+	result valueType
+	call   func(*env, []value) value
+}
+
+var functions = map[string]function{
+	"header": {[]valueType{typeString}, typeString, func(e *env, args []value) value {
+		return value{s: e.in.Header.Get(args[0].s)}
+	}},
+	"contains": {[]valueType{typeString, typeString}, typeBool, func(_ *env, args []value) value {
+		return value{b: strings.Contains(args[0].s, args[1].s)}
+	}},
+	"startsWith": {[]valueType{typeString, typeString}, typeBool, func(_ *env, args []value) value {
+		return value{b: strings.HasPrefix(args[0].s, args[1].s)}
+	}},
+	"endsWith": {[]valueType{typeString, typeString}, typeBool, func(_ *env, args []value) value {
+		return value{b: strings.HasSuffix(args[0].s, args[1].s)}
+	}},
+	"len": {[]valueType{typeString}, typeNumber, func(_ *env, args []value) value {
+		return value{n: float64(len(args[0].s))}
+	}},
+}
+
+// binary type-checks the operator op applied to left and right, and
+// returns the expression for it.
+func binary(op string, left, right expr) (expr, error) {
+	l, r := left.eval, right.eval
+	switch op {
+	case "&&", "||":
+		if left.typ != typeBool || right.typ != typeBool {
+			return expr{}, fmt.Errorf("%s needs true/false values on both sides, got a %s and a %s", op, left.typ, right.typ)
+		}
+		if op == "&&" {
+			return expr{typ: typeBool, eval: func(e *env) value { return value{b: l(e).b && r(e).b} }}, nil
+		}
+		return expr{typ: typeBool, eval: func(e *env) value { return value{b: l(e).b || r(e).b} }}, nil
+	case "==", "!=":
+		if left.typ != right.typ {
+			return expr{}, fmt.Errorf("%s compares a %s with a %s", op, left.typ, right.typ)
+		}
+		negate := op == "!="
+		return expr{typ: typeBool, eval: func(e *env) value { return value{b: (l(e) == r(e)) != negate} }}, nil
+	case "<", "<=", ">", ">=":
+		if left.typ != right.typ || left.typ == typeBool {
+			return expr{}, fmt.Errorf("%s compares two numbers or two strings, got a %s and a %s", op, left.typ, right.typ)
+		}
+		less := func(a, b value) bool { return a.n < b.n }
+		if left.typ == typeString {
+			less = func(a, b value) bool { return a.s < b.s }
+		}
+		compare := map[string]func(a, b value) bool{
+			"<":  less,
+			"<=": func(a, b value) bool { return !less(b, a) },
+			">":  func(a, b value) bool { return less(b, a) },
+			">=": func(a, b value) bool { return !less(a, b) },
+		}[op]
+		return expr{typ: typeBool, eval: func(e *env) value { return value{b: compare(l(e), r(e))} }}, nil
+	default:
+		if left.typ != typeNumber || right.typ != typeNumber {
+			return expr{}, fmt.Errorf("%s needs numbers on both sides, got a %s and a %s", op, left.typ, right.typ)
+		}
+		arith := map[string]func(a, b float64) float64{
+			"+": func(a, b float64) float64 { return a + b },
+			"-": func(a, b float64) float64 { return a - b },
+			"*": func(a, b float64) float64 { return a * b },
+			"/": func(a, b float64) float64 { return a / b },
+			"%": math.Mod,
+		}[op]
+		return expr{typ: typeNumber, eval: func(e *env) value { return value{n: arith(l(e).n, r(e).n)} }}, nil
+	}
+}
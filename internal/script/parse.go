@@ -0,0 +1,340 @@
+package script
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// tokenKind is the lexical class of a token.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokDuration
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	col  int
+}
+
+// lex splits one line of a script into tokens, dropping a trailing comment.
+func lex(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := rune(line[i])
+		switch {
+		case c == '#':
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("column %d: unterminated string", i+1)
+			}
+			text, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: invalid string %s", i+1, line[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, col: i + 1})
+			i = end + 1
+		case unicode.IsDigit(c):
+			end := i
+			for end < len(line) && (unicode.IsDigit(rune(line[end])) || line[end] == '.') {
+				end++
+			}
+			kind := tokNumber
+			// A unit straight after the digits makes a duration: 200ms, 1.5s.
+			for end < len(line) && unicode.IsLetter(rune(line[end])) {
+				kind = tokDuration
+				end++
+			}
+			tokens = append(tokens, token{kind: kind, text: line[i:end], col: i + 1})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(line) && (unicode.IsLetter(rune(line[end])) || unicode.IsDigit(rune(line[end])) || line[end] == '_' || line[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: line[i:end], col: i + 1})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ",", ":"} {
+				if strings.HasPrefix(line[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("column %d: unexpected %q", i+1, c)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, col: i + 1})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// parser builds one rule from a line's tokens.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokEOF, col: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if t.kind == tokEOF {
+		return fmt.Errorf("end of line: %s", msg)
+	}
+	return fmt.Errorf("column %d: %s", t.col, msg)
+}
+
+// parseRule parses `[if <condition>:] <action>`.
+func (p *parser) parseRule() (rule, error) {
+	var r rule
+	if p.accept("if") {
+		start := p.peek()
+		cond, err := p.parseExpr()
+		if err != nil {
+			return r, err
+		}
+		if cond.typ != typeBool {
+			return r, p.errorf(start, "condition is a %s, want a comparison or other true/false value", cond.typ)
+		}
+		if !p.accept(":") {
+			return r, p.errorf(p.peek(), "expected ':' after the condition")
+		}
+		r.cond = cond
+	}
+
+	t := p.next()
+	if t.kind != tokIdent {
+		return r, p.errorf(t, "expected an action: drop, error, delay or pass")
+	}
+	switch t.text {
+	case "drop":
+		r.decision.Action = Drop
+	case "pass":
+		r.decision.Action = Pass
+	case "error":
+		r.decision.Action = Error
+		if status := p.peek(); status.kind == tokNumber {
+			p.pos++
+			n, err := strconv.Atoi(status.text)
+			if err != nil || n <= 0 {
+				return r, p.errorf(status, "error status %s must be a positive whole number", status.text)
+			}
+			r.decision.Status = n
+		}
+	case "delay":
+		r.decision.Action = Delay
+		d := p.next()
+		if d.kind != tokDuration {
+			return r, p.errorf(d, "delay needs a duration, such as 200ms or 1.5s")
+		}
+		delay, err := time.ParseDuration(d.text)
+		if err != nil || delay <= 0 {
+			return r, p.errorf(d, "invalid delay %q: want a positive duration such as 200ms or 1.5s", d.text)
+		}
+		r.decision.Delay = delay
+	default:
+		return r, p.errorf(t, "unknown action %q: want drop, error, delay or pass", t.text)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return r, p.errorf(t, "unexpected %q after the action", t.text)
+	}
+	return r, nil
+}
+
+// Binary operators by precedence, loosest first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || !slices.Contains(precedence[level], t.text) {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return expr{}, err
+		}
+		if left, err = binary(t.text, left, right); err != nil {
+			return expr{}, p.errorf(t, "%v", err)
+		}
+		// Comparisons don't chain: a < b < c compares a bool with a number.
+		if level == 2 {
+			if t := p.peek(); t.kind == tokOp && slices.Contains(precedence[level], t.text) {
+				return expr{}, p.errorf(t, "comparisons can't be chained; join them with && instead")
+			}
+			return left, nil
+		}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	t := p.peek()
+	switch {
+	case p.accept("!"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return expr{}, err
+		}
+		if operand.typ != typeBool {
+			return expr{}, p.errorf(t, "! needs a true/false value, got a %s", operand.typ)
+		}
+		return expr{typ: typeBool, eval: func(e *env) value { return value{b: !operand.eval(e).b} }}, nil
+	case p.accept("-"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return expr{}, err
+		}
+		if operand.typ != typeNumber {
+			return expr{}, p.errorf(t, "- needs a number, got a %s", operand.typ)
+		}
+		return expr{typ: typeNumber, eval: func(e *env) value { return value{n: -operand.eval(e).n} }}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return expr{}, p.errorf(t, "invalid number %q", t.text)
+		}
+		return constant(value{n: n}, typeNumber), nil
+	case tokString:
+		return constant(value{s: t.text}, typeString), nil
+	case tokDuration:
+		return expr{}, p.errorf(t, "durations such as %s only follow delay; compare elapsed and time.* as numbers of seconds", t.text)
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return constant(value{b: t.text == "true"}, typeBool), nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		v, ok := variables[t.text]
+		if !ok {
+			return expr{}, p.errorf(t, "unknown variable %q", t.text)
+		}
+		return expr{typ: v.typ, eval: v.eval}, nil
+	case tokOp:
+		if t.text == "(" {
+			inner, err := p.parseExpr()
+			if err != nil {
+				return expr{}, err
+			}
+			if !p.accept(")") {
+				return expr{}, p.errorf(p.peek(), "expected ')'")
+			}
+			return inner, nil
+		}
+	}
+	if t.kind == tokEOF {
+		return expr{}, p.errorf(t, "expected a value")
+	}
+	return expr{}, p.errorf(t, "expected a value, got %q", t.text)
+}
+
+// parseCall parses the arguments of a call to the function name, whose
+// opening parenthesis has been consumed.
+func (p *parser) parseCall(name token) (expr, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return expr{}, p.errorf(name, "unknown function %q", name.text)
+	}
+	var args []expr
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return expr{}, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return expr{}, p.errorf(p.peek(), "expected ',' or ')' in the arguments of %s", name.text)
+			}
+		}
+	}
+	if len(args) != len(fn.params) {
+		return expr{}, p.errorf(name, "%s takes %d arguments, got %d", name.text, len(fn.params), len(args))
+	}
+	for i, arg := range args {
+		if arg.typ != fn.params[i] {
+			return expr{}, p.errorf(name, "argument %d of %s must be a %s, got a %s", i+1, name.text, fn.params[i], arg.typ)
+		}
+	}
+	return expr{typ: fn.result, eval: func(e *env) value {
+		values := make([]value, len(args))
+		for i, arg := range args {
+			values[i] = arg.eval(e)
+		}
+		return fn.call(e, values)
+	}}, nil
+}
@@ -0,0 +1,217 @@
+// Package script runs chaos scripts: a route's own rules for which
+// connections or requests get a fault, for conditions its declarative
+// settings can't express. A script is a list of rules, one per line, tried
+// in order until one matches:
+//
+//	# Fail every request after a client's 100th.
+//	if client.requests > 100: error 503
+//	if startsWith(payload, "DELETE"): drop
+//	if time.second < 10: delay 2s
+//
+// Rules are type-checked when the script compiles, so a running script
+// can't fail.
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action is what a matching rule does.
+type Action int
+
+const (
+	// Pass injects nothing, and stops later rules from matching.
+	Pass Action = iota
+	// Drop closes the connection, or resets the HTTP/2 stream or RPC.
+	Drop
+	// Error answers the request with an injected error.
+	Error
+	// Delay holds the connection or request before forwarding it.
+	Delay
+)
+
+func (a Action) String() string {
+	switch a {
+	case Drop:
+		return "drop"
+	case Error:
+		return "error"
+	case Delay:
+		return "delay"
+	default:
+		return "pass"
+	}
+}
+
+// Decision is what a script decided for a connection or request.
+type Decision struct {
+	Action Action
+	// Status is the status error answers with, or 0 for the route's
+	// default.
+	Status int
+	Delay  time.Duration
+	// Line is the line of the rule that matched, or 0 if none did.
+	Line int
+}
+
+// Input is what a script knows about the connection or request it decides.
+type Input struct {
+	// ClientAddr is the client's host:port.
+	ClientAddr string
+	// Payload is the first bytes the client sent on the connection, if the
+	// script reads them.
+	Payload []byte
+	// Method, Path, Host and Header describe an HTTP request or RPC.
+	Method string
+	Path   string
+	Host   string
+	Header http.Header
+	Now    time.Time
+}
+
+// PayloadBytes is how many of a connection's first bytes a script sees as
+// payload.
+const PayloadBytes = 256
+
+type rule struct {
+	// cond.eval is nil for a rule without a condition.
+	cond     expr
+	decision Decision
+}
+
+// clientCounts is what a script has counted of one client IP.
+type clientCounts struct {
+	connections int
+	requests    int
+}
+
+// Script is a compiled chaos script and its counters. Its methods may be
+// called concurrently.
+type Script struct {
+	rules       []rule
+	usesPayload bool
+	started     time.Time
+
+	mu          sync.Mutex
+	clients     map[string]*clientCounts
+	connections int
+	requests    int
+}
+
+// Compile compiles a script's source. The error names the line of the
+// first mistake.
+func Compile(src string) (*Script, error) {
+	s := &Script{started: time.Now(), clients: make(map[string]*clientCounts)}
+	for i, line := range strings.Split(src, "\n") {
+		tokens, err := lex(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d, %w", i+1, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		p := &parser{tokens: tokens}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, fmt.Errorf("line %d, %w", i+1, err)
+		}
+		r.decision.Line = i + 1
+		s.rules = append(s.rules, r)
+		for _, t := range tokens {
+			if t.kind == tokIdent && t.text == "payload" {
+				s.usesPayload = true
+			}
+		}
+	}
+	if len(s.rules) == 0 {
+		return nil, errors.New("script has no rules")
+	}
+	return s, nil
+}
+
+// Load reads and compiles the script at path.
+func Load(path string) (*Script, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(string(src))
+}
+
+// UsesPayload reports whether the script reads payload, so the proxy has
+// to wait for the client's first bytes before deciding.
+func (s *Script) UsesPayload() bool {
+	return s.usesPayload
+}
+
+// Rules returns what each rule does when it matches, in order.
+func (s *Script) Rules() []Decision {
+	decisions := make([]Decision, len(s.rules))
+	for i, r := range s.rules {
+		decisions[i] = r.decision
+	}
+	return decisions
+}
+
+// Connection counts a new connection from in's client and decides what
+// happens to it.
+func (s *Script) Connection(in Input) Decision {
+	return s.decide(in, func(c *clientCounts) {
+		c.connections++
+		s.connections++
+	})
+}
+
+// Request counts a new HTTP request or RPC from in's client and decides
+// what happens to it.
+func (s *Script) Request(in Input) Decision {
+	return s.decide(in, func(c *clientCounts) {
+		c.requests++
+		s.requests++
+	})
+}
+
+func (s *Script) decide(in Input, count func(*clientCounts)) Decision {
+	host, port, err := net.SplitHostPort(in.ClientAddr)
+	if err != nil {
+		host = in.ClientAddr
+	}
+	if in.Now.IsZero() {
+		in.Now = time.Now()
+	}
+
+	s.mu.Lock()
+	c, ok := s.clients[host]
+	if !ok {
+		c = &clientCounts{}
+		s.clients[host] = c
+	}
+	count(c)
+	e := &env{
+		in:                in,
+		clientConnections: c.connections,
+		clientRequests:    c.requests,
+		routeConnections:  s.connections,
+		routeRequests:     s.requests,
+		clientIP:          host,
+		payload:           string(in.Payload),
+		elapsed:           in.Now.Sub(s.started).Seconds(),
+	}
+	s.mu.Unlock()
+	e.clientPort, _ = strconv.ParseFloat(port, 64)
+
+	for _, r := range s.rules {
+		if r.cond.eval == nil || r.cond.eval(e).b {
+			return r.decision
+		}
+	}
+	return Decision{}
+}
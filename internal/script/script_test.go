@@ -0,0 +1,111 @@
+package script
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"empty", "# nothing yet\n\n", "no rules"},
+		{"unknown action", "explode", `unknown action "explode"`},
+		{"missing colon", "if client.requests > 1 drop", "expected ':'"},
+		{"unknown variable", "if clinet.requests > 1: drop", `line 1, column 4: unknown variable "clinet.requests"`},
+		{"unknown function", "if matches(payload, \"x\"): drop", `unknown function "matches"`},
+		{"condition not bool", "if client.requests: drop", "condition is a number"},
+		{"mixed comparison", "if client.ip == 1: drop", "compares a string with a number"},
+		{"chained comparison", "if 1 < client.requests < 5: drop", "can't be chained"},
+		{"arithmetic on strings", "if client.ip + 1 > 2: drop", "needs numbers"},
+		{"wrong argument type", "if startsWith(payload, 1): drop", "argument 2 of startsWith must be a string"},
+		{"wrong argument count", "if contains(payload): drop", "contains takes 2 arguments, got 1"},
+		{"delay without duration", "delay 200", "delay needs a duration"},
+		{"duration in condition", "if elapsed > 10s: drop", "only follow delay"},
+		{"trailing tokens", "drop now", `unexpected "now"`},
+		{"unterminated string", `if payload == "GET: drop`, "unterminated string"},
+		{"error on second line", "pass\nif: drop", "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Compile() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScript_Request(t *testing.T) {
+	s, err := Compile(`
+# Health checks always get through.
+if request.path == "/health": pass
+if client.requests > 2: error 503  # after the second request
+if header("X-Debug") != "" && request.method == "POST": delay 1.5s
+if route.requests % 2 == 0: drop
+`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		in   Input
+		want Decision
+	}{
+		{"first request matches nothing", Input{ClientAddr: "10.0.0.1:4000", Method: "GET", Path: "/"}, Decision{}},
+		{"second request on the route", Input{ClientAddr: "10.0.0.2:4000", Method: "GET", Path: "/"}, Decision{Action: Drop, Line: 6}},
+		{"passed before the count", Input{ClientAddr: "10.0.0.1:4001", Path: "/health"}, Decision{Action: Pass, Line: 3}},
+		{"third from one client", Input{ClientAddr: "10.0.0.1:4002", Path: "/"}, Decision{Action: Error, Status: 503, Line: 4}},
+		{"header and method", Input{ClientAddr: "10.0.0.3:4000", Method: "POST", Path: "/", Header: http.Header{"X-Debug": {"1"}}}, Decision{Action: Delay, Delay: 1500 * time.Millisecond, Line: 5}},
+	}
+	for _, tt := range tests {
+		if got := s.Request(tt.in); got != tt.want {
+			t.Errorf("%s: Request() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScript_Connection(t *testing.T) {
+	s, err := Compile(`
+if startsWith(payload, "QUIT") || len(payload) > 10: drop
+if client.connections == 3 && client.port > 1000: delay 50ms
+`)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if !s.UsesPayload() {
+		t.Error("UsesPayload() = false for a script reading payload")
+	}
+
+	in := Input{ClientAddr: "[::1]:5000", Payload: []byte("HELO")}
+	for i := 1; i <= 3; i++ {
+		got := s.Connection(in)
+		if i < 3 && got.Action != Pass {
+			t.Errorf("connection %d = %+v, want no match", i, got)
+		}
+		if i == 3 && got != (Decision{Action: Delay, Delay: 50 * time.Millisecond, Line: 3}) {
+			t.Errorf("connection 3 = %+v, want the delay", got)
+		}
+	}
+	if got := s.Connection(Input{ClientAddr: "[::1]:5001", Payload: []byte("QUIT\r\n")}); got.Action != Drop {
+		t.Errorf("QUIT connection = %+v, want a drop", got)
+	}
+}
+
+func TestScript_Time(t *testing.T) {
+	s, err := Compile("if time.hour == 13 && time.minute >= 30 && -time.second <= 0: drop")
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	if got := s.Connection(Input{Now: time.Date(2026, 1, 2, 13, 45, 0, 0, time.UTC)}); got.Action != Drop {
+		t.Errorf("Connection() at 13:45 = %+v, want a drop", got)
+	}
+	if got := s.Connection(Input{Now: time.Date(2026, 1, 2, 14, 45, 0, 0, time.UTC)}); got.Action != Pass {
+		t.Errorf("Connection() at 14:45 = %+v, want no match", got)
+	}
+}
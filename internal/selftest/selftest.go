@@ -0,0 +1,894 @@
+// Package selftest runs a route's chaos against a built-in echo upstream and
+// synthetic traffic, and reports which of the configured faults fired. It
+// catches configs whose faults can never trigger before a real experiment
+// depends on them.
+package selftest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+const (
+	// workers is how many clients send traffic at once.
+	workers = 4
+	// payloadSize is how much each synthetic request sends and expects back.
+	payloadSize = 1024
+	// requestTimeout bounds each synthetic request, so faults that stall a
+	// connection don't stall the test.
+	requestTimeout = 2 * time.Second
+	// datagramTimeout is how long a UDP request waits for its echo before
+	// counting it as lost. Lost datagrams are never resent, so it is short.
+	datagramTimeout = 250 * time.Millisecond
+	// dbRows is how many rows the postgres and mysql upstreams answer every
+	// query with, so cut-off results can be told from whole ones.
+	dbRows = 3
+)
+
+// Report is the outcome of self-testing one route.
+type Report struct {
+	Route config.RouteConfig
+	// Requests counts synthetic requests; Failed those that didn't get their
+	// payload back intact.
+	Requests int64
+	Failed   int64
+	// Faults counts the faults the proxy injected, by name.
+	Faults map[string]int64
+	// Expected lists the faults the route's config should produce; Missing
+	// those that never fired.
+	Expected []string
+	Missing  []string
+}
+
+// OK reports whether every expected fault fired.
+func (r Report) OK() bool {
+	return len(r.Missing) == 0
+}
+
+// Run serves route on a loopback port in front of a built-in echo upstream
+// and sends traffic through it for duration. UDP routes get a UDP echo
+// upstream and one datagram per request. Routes in dns mode get an upstream
+// answering every A query, and one query per request. Routes in http mode
+// get an HTTP echo upstream and keep-alive HTTP requests, routes in http2
+// mode the same over one HTTP/2 connection per client, and routes in grpc
+// mode unary RPCs. Routes in postgres and mysql mode get an upstream
+// answering every query with dbRows rows, and one connection and query per
+// request. Clients of routes in socks5 mode ask the route for the echo
+// upstream. Routes in transparent mode are run as tcp routes, since nothing
+// redirects the test traffic. Clients of routes that terminate TLS connect
+// over TLS without verifying the route's certificate. The route's
+// localPort, upstream, stub, record, replay, upstreamTLS, proxyProtocol,
+// enabled, chaosClients, handoffSocket and baselinePort are ignored.
+func Run(ctx context.Context, route config.RouteConfig, duration time.Duration) (Report, error) {
+	start := startEcho
+	switch {
+	case route.Mode == config.ModeDNS && route.IsUDP():
+		start = startUDPDNS
+	case route.Mode == config.ModeDNS:
+		start = startDNS
+	case route.IsUDP():
+		start = startUDPEcho
+	case route.Mode == config.ModeHTTP:
+		start = startHTTPEcho
+	case route.Mode == config.ModeHTTP2, route.Mode == config.ModeGRPC:
+		start = startH2Echo
+	case route.Mode == config.ModePostgres:
+		start = startPostgres
+	case route.Mode == config.ModeMySQL:
+		start = startMySQL
+	}
+	upstream, err := start()
+	if err != nil {
+		return Report{}, err
+	}
+	defer upstream.Close()
+
+	route.LocalPort = 0
+	route.ListenAddress = ""
+	if route.Mode == config.ModeTransparent {
+		route.Mode = config.ModeTCP
+	}
+	route.Upstream = upstream.Addr().String()
+	if route.Mode == config.ModeSOCKS5 {
+		route.Upstream = ""
+	}
+	route.Stub = nil
+	route.Record = nil
+	route.Replay = nil
+	route.UpstreamTLS = nil
+	route.ProxyProtocol = nil
+	route.Enabled = nil
+	route.ChaosClients = nil
+	route.HandoffSocket = ""
+	route.BaselinePort = 0
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stats := &proxy.RouteStats{}
+	listening := make(chan net.Addr, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- proxy.ServeRoute(ctx, route, proxy.ServeOptions{
+			Stats:    stats,
+			OnListen: func(addr net.Addr) { listening <- addr },
+		})
+	}()
+
+	var addr string
+	select {
+	case a := <-listening:
+		addr = a.String()
+	case err := <-served:
+		return Report{}, err
+	}
+
+	dial := func() (net.Conn, error) { return net.DialTimeout("tcp", addr, requestTimeout) }
+	scheme, protocols := "http://", h2c()
+	var clientTLS *tls.Config
+	if route.TLS != nil {
+		clientTLS = &tls.Config{InsecureSkipVerify: true}
+		dial = func() (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: requestTimeout}, "tcp", addr, clientTLS)
+		}
+		scheme, protocols = "https://", new(http.Protocols)
+		protocols.SetHTTP2(true)
+	}
+	if route.Mode == config.ModeSOCKS5 {
+		// Clients ask the route for the echo upstream themselves.
+		direct := dial
+		dial = func() (net.Conn, error) {
+			conn, err := direct()
+			if err != nil {
+				return nil, err
+			}
+			if err := socksConnect(conn, upstream.Addr()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+
+	var requests, failed atomic.Int64
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send := func() bool { return request(dial) }
+			switch {
+			case route.Mode == config.ModeDNS && route.IsUDP():
+				conn, err := net.Dial("udp", addr)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				send = func() bool { return requestDNS(conn, false) }
+			case route.Mode == config.ModeDNS:
+				send = func() bool {
+					conn, err := dial()
+					if err != nil {
+						return false
+					}
+					defer conn.Close()
+					return requestDNS(conn, true)
+				}
+			case route.IsUDP():
+				// One socket per client keeps it to one session on the route.
+				conn, err := net.Dial("udp", addr)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				send = func() bool { return requestUDP(conn) }
+			case route.Mode == config.ModeHTTP:
+				transport := &http.Transport{MaxConnsPerHost: 1, TLSClientConfig: clientTLS.Clone()}
+				defer transport.CloseIdleConnections()
+				client := &http.Client{Transport: transport, Timeout: requestTimeout}
+				send = func() bool { return requestHTTP(client, scheme+addr) }
+			case route.Mode == config.ModeHTTP2:
+				transport := &http.Transport{Protocols: protocols, TLSClientConfig: clientTLS.Clone()}
+				defer transport.CloseIdleConnections()
+				client := &http.Client{Transport: transport, Timeout: requestTimeout}
+				send = func() bool { return requestHTTP(client, scheme+addr) }
+			case route.Mode == config.ModeGRPC:
+				transport := &http.Transport{Protocols: protocols, TLSClientConfig: clientTLS.Clone()}
+				defer transport.CloseIdleConnections()
+				client := &http.Client{Transport: transport, Timeout: requestTimeout}
+				send = func() bool { return requestGRPC(client, scheme+addr) }
+			case route.Mode == config.ModePostgres:
+				send = func() bool { return requestPostgres(dial) }
+			case route.Mode == config.ModeMySQL:
+				send = func() bool { return requestMySQL(dial) }
+			}
+			for time.Now().Before(deadline) && ctx.Err() == nil {
+				requests.Add(1)
+				if !send() {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+
+	// One silent connection held open for the whole run gives idle and
+	// lifetime faults a chance to fire.
+	if !route.IsUDP() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			holdOpen(ctx, dial, deadline)
+		}()
+	}
+	wg.Wait()
+
+	// Let connections that just ended finish reporting their faults.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-served
+
+	report := Report{
+		Route:    route,
+		Requests: requests.Load(),
+		Failed:   failed.Load(),
+		Faults:   stats.Snapshot().Faults,
+		Expected: ExpectedFaults(route),
+	}
+	for _, fault := range report.Expected {
+		if report.Faults[fault] == 0 {
+			report.Missing = append(report.Missing, fault)
+		}
+	}
+	return report, nil
+}
+
+// ExpectedFaults lists the fault names route's chaos settings produce when
+// they fire, as reported in fault events.
+func ExpectedFaults(route config.RouteConfig) []string {
+	var faults []string
+	add := func(enabled bool, fault string) {
+		if enabled && !slices.Contains(faults, fault) {
+			faults = append(faults, fault)
+		}
+	}
+
+	// Synthetic requests may not match an http mode route's http.match,
+	// which all of its per-request chaos is limited to.
+	perRequest := route.HTTP == nil || route.HTTP.Match == nil
+	add(route.HTTP2 != nil && route.HTTP2.ResetRate > 0, "http2_reset")
+	add(route.GRPC != nil && route.GRPC.ErrorRate > 0, "grpc_error")
+	add(route.GRPC != nil && route.GRPC.MessageDelayMs > 0 && route.GRPC.MessageDelayRateOrDefault() > 0, "grpc_delay")
+	if route.DNS != nil {
+		add(route.DNS.ErrorRate > 0, "dns_error")
+		add(route.DNS.DelayMs > 0 && route.DNS.DelayRateOrDefault() > 0, "dns_delay")
+		add(route.DNS.TTLRate > 0, "dns_ttl")
+	}
+	if route.Database != nil {
+		add(route.Database.KillQueryRate > 0, "db_kill_query")
+		add(route.Database.PartialResultRate > 0, "db_partial_result")
+	}
+	add(perRequest && (route.DropRate > 0 || route.BurstLoss != nil), "drop")
+	add(perRequest && route.LatencyMs > 0, "latency")
+	if route.TLS != nil && route.TLS.Handshake != nil {
+		h := route.TLS.Handshake
+		add(h.AbortRate > 0, "tls_abort")
+		add(h.DelayMs > 0 && h.DelayRateOrDefault() > 0, "tls_delay")
+		add(h.BadCertRate > 0, "tls_bad_cert")
+	}
+	add(route.DialFailureRate > 0, "dial_failure")
+	add(route.ConnectLatencyMs > 0, "connect_latency")
+	add(route.FirstByteLatencyMs > 0, "first_byte_latency")
+	add(route.FinDelayMs > 0, "fin_delay")
+	add(perRequest && route.HTTP != nil && route.HTTP.ErrorRate > 0, "http_error")
+	// Synthetic payloads are random bytes, never JSON, so their bodies can
+	// be truncated but not corrupted.
+	add(perRequest && route.HTTP != nil && route.HTTP.Body != nil && route.HTTP.Body.TruncateRate > 0, "http_truncate")
+	if perRequest && route.HTTP != nil {
+		// Removing or corrupting a header needs traffic that carries it,
+		// which synthetic requests may not.
+		for _, rule := range route.HTTP.Headers {
+			add(rule.Action == config.HeaderAdd || rule.Action == config.HeaderSet, "header")
+		}
+	}
+	add(len(route.LoadLatency) > 0, "load_latency")
+	add(route.FlapIntervalMs > 0, "flap")
+	add(route.Tarpit != nil, "tarpit")
+	add(route.BandwidthCap != nil, "bandwidth_cap")
+	add(route.MaxConnectionLifetimeMs > 0, "lifetime")
+	add(route.IdleTimeoutMs > 0, "idle")
+	add(route.DuplicateRate > 0, "duplicate")
+	add(route.CoalesceMs > 0, "coalesce")
+	add(route.ReorderRate > 0, "reorder")
+	for _, toxic := range route.Toxics {
+		add(true, toxic.Type)
+	}
+	return faults
+}
+
+// request sends one payload through a connection from dial and reports
+// whether it came back intact.
+func request(dial func() (net.Conn, error)) bool {
+	conn, err := dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	if _, err := conn.Write(payload); err != nil {
+		return false
+	}
+	echo := make([]byte, payloadSize)
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		return false
+	}
+	return bytes.Equal(payload, echo)
+}
+
+// socksConnect asks the SOCKS5 route conn is connected to for a connection
+// to target, sending its greeting and request in one go.
+func socksConnect(conn net.Conn, target net.Addr) error {
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	addr := target.(*net.TCPAddr)
+	req := []byte{5, 1, 0, 5, 1, 0, 1}
+	req = append(req, addr.IP.To4()...)
+	req = binary.BigEndian.AppendUint16(req, uint16(addr.Port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 || reply[3] != 0 {
+		return fmt.Errorf("SOCKS5 connect failed with reply %d", reply[3])
+	}
+	return nil
+}
+
+// requestUDP sends one payload through the proxy as a datagram on conn and
+// reports whether it came back intact. Duplicates and late echoes of
+// earlier requests are skipped.
+func requestUDP(conn net.Conn) bool {
+	conn.SetDeadline(time.Now().Add(datagramTimeout))
+
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	if _, err := conn.Write(payload); err != nil {
+		return false
+	}
+	echo := make([]byte, payloadSize)
+	for {
+		n, err := conn.Read(echo)
+		if err != nil {
+			return false
+		}
+		if bytes.Equal(payload, echo[:n]) {
+			return true
+		}
+	}
+}
+
+// requestDNS sends one A query through the proxy on conn, length-prefixed
+// when framed as over TCP, and reports whether it was answered without an
+// error. Answers to earlier queries are skipped.
+func requestDNS(conn net.Conn, framed bool) bool {
+	timeout := requestTimeout
+	if !framed {
+		timeout = datagramTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	query := dnsQuery()
+	msg := query
+	if framed {
+		msg = binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		msg = append(msg, query...)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return false
+	}
+	buf := make([]byte, 65535)
+	for {
+		var resp []byte
+		if framed {
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return false
+			}
+			resp = buf[:binary.BigEndian.Uint16(buf)]
+			if _, err := io.ReadFull(conn, resp); err != nil {
+				return false
+			}
+		} else {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return false
+			}
+			resp = buf[:n]
+		}
+		if len(resp) >= 12 && bytes.Equal(resp[:2], query[:2]) {
+			return resp[3]&0x0F == 0
+		}
+	}
+}
+
+// requestPostgres logs in through a connection from dial, runs one query,
+// and reports whether its whole result came back.
+func requestPostgres(dial func() (net.Conn, error)) bool {
+	conn, err := dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	startup := binary.BigEndian.AppendUint32(nil, 0)
+	startup = binary.BigEndian.AppendUint32(startup, 196608) // protocol 3.0
+	startup = append(startup, "user\x00selftest\x00\x00"...)
+	binary.BigEndian.PutUint32(startup, uint32(len(startup)))
+	if _, err := conn.Write(startup); err != nil {
+		return false
+	}
+	r := bufio.NewReader(conn)
+	if _, err := readPostgresReply(r); err != nil {
+		return false
+	}
+	if _, err := conn.Write(postgresMessage('Q', "SELECT 1\x00")); err != nil {
+		return false
+	}
+	rows, err := readPostgresReply(r)
+	return err == nil && rows == dbRows
+}
+
+// readPostgresReply reads messages up to ReadyForQuery and returns how many
+// rows they held, or an error if the reply carried one.
+func readPostgresReply(r *bufio.Reader) (int, error) {
+	rows := 0
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return rows, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[1:])) - 4
+		if _, err := io.CopyN(io.Discard, r, length); err != nil {
+			return rows, err
+		}
+		switch header[0] {
+		case 'D':
+			rows++
+		case 'E':
+			return rows, errors.New("postgres error response")
+		case 'Z':
+			return rows, nil
+		}
+	}
+}
+
+// postgresMessage builds a typed Postgres message.
+func postgresMessage(typ byte, body string) []byte {
+	msg := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))
+	return append(msg, body...)
+}
+
+// requestMySQL logs in through a connection from dial, runs one query, and
+// reports whether its whole result came back.
+func requestMySQL(dial func() (net.Conn, error)) bool {
+	conn, err := dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	r := bufio.NewReader(conn)
+	if _, err := readMySQLPacket(r); err != nil {
+		return false
+	}
+	// CLIENT_PROTOCOL_41, max packet size, charset, filler, user and an
+	// empty auth response.
+	login := binary.LittleEndian.AppendUint32(nil, 0x00000200)
+	login = append(login, make([]byte, 28)...)
+	login = append(login, "selftest\x00\x00"...)
+	if _, err := conn.Write(mysqlPacket(1, login)); err != nil {
+		return false
+	}
+	if ok, err := readMySQLPacket(r); err != nil || ok[0] != 0x00 {
+		return false
+	}
+	if _, err := conn.Write(mysqlPacket(0, append([]byte{0x03}, "SELECT 1"...))); err != nil {
+		return false
+	}
+	// Column count, column definition and EOF, then rows up to an EOF.
+	for range 3 {
+		if _, err := readMySQLPacket(r); err != nil {
+			return false
+		}
+	}
+	rows := 0
+	for {
+		packet, err := readMySQLPacket(r)
+		if err != nil || packet[0] == 0xFF {
+			return false
+		}
+		if packet[0] == 0xFE && len(packet) < 9 {
+			return rows == dbRows
+		}
+		rows++
+	}
+}
+
+// readMySQLPacket reads one MySQL packet and returns its payload.
+func readMySQLPacket(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("empty MySQL packet")
+	}
+	return payload, nil
+}
+
+// mysqlPacket builds a MySQL packet with sequence number seq.
+func mysqlPacket(seq byte, payload []byte) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+// dnsQuery builds a recursive A query for selftest. with a random ID.
+func dnsQuery() []byte {
+	query := make([]byte, 2, 27)
+	rand.Read(query)
+	query = append(query, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+	query = append(query, 8)
+	query = append(query, "selftest"...)
+	return append(query, 0, 0, 1, 0, 1)
+}
+
+// dnsAnswer answers query with 127.0.0.1 for the name it asks about and a
+// TTL of 300 seconds, so TTL rewrites have a record to rewrite.
+func dnsAnswer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	resp := append([]byte(nil), query...)
+	resp[2] |= 0x80
+	resp[3] = 0x80
+	binary.BigEndian.PutUint16(resp[6:], 1)
+	clear(resp[8:12])
+	return append(resp, 0xC0, 12, 0, 1, 0, 1, 0, 0, 0x01, 0x2C, 0, 4, 127, 0, 0, 1)
+}
+
+// requestHTTP posts one payload through the proxy and reports whether the
+// upstream echoed it back intact.
+func requestHTTP(client *http.Client, url string) bool {
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	resp, err := client.Post(url, "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	echo, err := io.ReadAll(resp.Body)
+	return err == nil && resp.StatusCode == http.StatusOK && bytes.Equal(payload, echo)
+}
+
+// requestGRPC sends one payload through the proxy as a unary RPC and
+// reports whether the upstream echoed it back intact with an OK status.
+func requestGRPC(client *http.Client, url string) bool {
+	payload := make([]byte, payloadSize)
+	rand.Read(payload)
+	req, err := http.NewRequest(http.MethodPost, url+"/selftest.Echo/Echo", bytes.NewReader(grpcFrame(payload)))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	echo, err := io.ReadAll(resp.Body)
+	return err == nil && resp.Trailer.Get("Grpc-Status") == "0" && bytes.Equal(grpcFrame(payload), echo)
+}
+
+// grpcFrame prefixes msg with the gRPC message header: an uncompressed
+// flag and its length.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// h2c returns protocols for cleartext HTTP/2 with prior knowledge, which
+// is how gRPC clients talk to plaintext servers.
+func h2c() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// holdOpen keeps a connection from dial open without sending anything until
+// deadline, reconnecting if the proxy closes it.
+func holdOpen(ctx context.Context, dial func() (net.Conn, error), deadline time.Time) {
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		conn, err := dial()
+		if err != nil {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		conn.SetReadDeadline(deadline)
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}
+}
+
+// startEcho starts a loopback upstream that echoes everything it reads.
+func startEcho() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// startUDPEcho starts a loopback UDP upstream that sends every datagram back
+// to its sender.
+func startUDPEcho() (echo, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return packetEcho{conn}, nil
+}
+
+// startDNS starts a loopback DNS upstream over TCP that answers every query
+// with dnsAnswer.
+func startDNS() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var length [2]byte
+					if _, err := io.ReadFull(conn, length[:]); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					resp := dnsAnswer(query)
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+				}
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// startUDPDNS starts a loopback DNS upstream over UDP that answers every
+// query with dnsAnswer.
+func startUDPDNS() (echo, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dnsAnswer(buf[:n]); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return packetEcho{conn}, nil
+}
+
+// startPostgres starts a loopback Postgres upstream that lets every client
+// in and answers every simple query with dbRows rows.
+func startPostgres() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [4]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(length[:]))-4); err != nil {
+					return
+				}
+				// AuthenticationOk, then ReadyForQuery.
+				conn.Write(append(postgresMessage('R', "\x00\x00\x00\x00"), postgresMessage('Z', "I")...))
+
+				r := bufio.NewReader(conn)
+				for {
+					var header [5]byte
+					if _, err := io.ReadFull(r, header[:]); err != nil {
+						return
+					}
+					if _, err := io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint32(header[1:]))-4); err != nil {
+						return
+					}
+					if header[0] != 'Q' {
+						return
+					}
+					reply := postgresMessage('T', "\x00\x00")
+					for range dbRows {
+						reply = append(reply, postgresMessage('D', "\x00\x01\x00\x00\x00\x011")...)
+					}
+					reply = append(reply, postgresMessage('C', "SELECT 3\x00")...)
+					conn.Write(append(reply, postgresMessage('Z', "I")...))
+				}
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// startMySQL starts a loopback MySQL upstream that lets every client in and
+// answers every query with dbRows rows of one column, with EOF packets as
+// clients that don't set CLIENT_DEPRECATE_EOF expect.
+func startMySQL() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write(mysqlPacket(0, append([]byte{10}, "8.0.0-selftest\x00"...)))
+				r := bufio.NewReader(conn)
+				if _, err := readMySQLPacket(r); err != nil {
+					return
+				}
+				conn.Write(mysqlPacket(2, []byte{0x00, 0, 0, 2, 0, 0, 0}))
+
+				eof := []byte{0xFE, 0, 0, 2, 0}
+				for {
+					if _, err := readMySQLPacket(r); err != nil {
+						return
+					}
+					reply := mysqlPacket(1, []byte{1})
+					reply = append(reply, mysqlPacket(2, []byte("\x03def\x00\x00\x00\x01n\x00\x0c\x3f\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00"))...)
+					reply = append(reply, mysqlPacket(3, eof)...)
+					seq := byte(4)
+					for range dbRows {
+						reply = append(reply, mysqlPacket(seq, []byte{1, '1'})...)
+						seq++
+					}
+					conn.Write(append(reply, mysqlPacket(seq, eof)...))
+				}
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// echo is a running upstream.
+type echo interface {
+	Addr() net.Addr
+	Close() error
+}
+
+// packetEcho is a UDP upstream as an echo.
+type packetEcho struct {
+	net.PacketConn
+}
+
+func (e packetEcho) Addr() net.Addr {
+	return e.LocalAddr()
+}
+
+// startHTTPEcho starts a loopback HTTP upstream that answers every request
+// with its body.
+func startHTTPEcho() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// An HTTP/1.x handler can't read the request body once it has
+		// started writing the response, so read it all first.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		w.Write(body)
+	}))
+	return listener, nil
+}
+
+// startH2Echo starts a loopback cleartext HTTP/2 upstream that answers
+// every request with its body, and a gRPC OK status in its trailers so
+// RPCs succeed too.
+func startH2Echo() (echo, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Protocols: h2c(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc")
+			io.Copy(w, r.Body)
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}),
+	}
+	go server.Serve(listener)
+	return listener, nil
+}
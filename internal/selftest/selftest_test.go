@@ -0,0 +1,209 @@
+package selftest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/config"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func TestExpectedFaults(t *testing.T) {
+	route := config.RouteConfig{
+		DropRate:    0.1,
+		LatencyMs:   50,
+		ReorderRate: 0.2,
+		Toxics: []config.ToxicConfig{
+			{Type: config.ToxicBandwidth, BytesPerSecond: 1024},
+			{Type: config.ToxicBandwidth, BytesPerSecond: 2048, Stream: config.StreamUpstream},
+		},
+	}
+
+	want := []string{"drop", "latency", "reorder", "bandwidth"}
+	if got := ExpectedFaults(route); !slices.Equal(got, want) {
+		t.Errorf("ExpectedFaults() = %v, want %v", got, want)
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key to a
+// temporary directory for routes that terminate TLS.
+func writeTestCert(t *testing.T) *config.TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "selftest"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	cfg := &config.TLSConfig{CertFile: filepath.Join(dir, "selftest.crt"), KeyFile: filepath.Join(dir, "selftest.key")}
+	os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return cfg
+}
+
+func TestRun(t *testing.T) {
+	never := 0.0
+	handshakeChaos := writeTestCert(t)
+	handshakeChaos.Handshake = &config.TLSHandshakeConfig{AbortRate: 0.2, DelayMs: 5, BadCertRate: 0.2}
+
+	tests := []struct {
+		name        string
+		route       config.RouteConfig
+		wantMissing []string
+	}{
+		{
+			name:  "every fault fires",
+			route: config.RouteConfig{DropRate: 0.5, LatencyMs: 10},
+		},
+		{
+			name: "http mode",
+			route: config.RouteConfig{
+				Mode:      config.ModeHTTP,
+				DropRate:  0.2,
+				LatencyMs: 10,
+				HTTP:      &config.HTTPConfig{ErrorRate: 0.2},
+			},
+		},
+		{
+			name: "http2 mode",
+			route: config.RouteConfig{
+				Mode:      config.ModeHTTP2,
+				DropRate:  0.2,
+				LatencyMs: 10,
+				HTTP2:     &config.HTTP2Config{ResetRate: 0.2},
+			},
+		},
+		{
+			name: "grpc mode",
+			route: config.RouteConfig{
+				Mode:      config.ModeGRPC,
+				DropRate:  0.2,
+				LatencyMs: 10,
+				GRPC:      &config.GRPCConfig{ErrorRate: 0.2, MessageDelayMs: 5},
+			},
+		},
+		{
+			name: "udp",
+			route: config.RouteConfig{
+				Protocol:      config.ProtocolUDP,
+				DropRate:      0.2,
+				LatencyMs:     10,
+				DuplicateRate: 0.2,
+				ReorderRate:   0.2,
+			},
+		},
+		{
+			name: "dns mode",
+			route: config.RouteConfig{
+				Mode:      config.ModeDNS,
+				LatencyMs: 10,
+				DNS:       &config.DNSConfig{ErrorRate: 0.2, DelayMs: 5, TTLRate: 0.2, TTL: 1},
+			},
+		},
+		{
+			name: "dns mode over udp",
+			route: config.RouteConfig{
+				Protocol: config.ProtocolUDP,
+				Mode:     config.ModeDNS,
+				DropRate: 0.1,
+				DNS:      &config.DNSConfig{ErrorRate: 0.3, ErrorCodes: []string{"NXDOMAIN", "REFUSED"}, TTLRate: 0.3, TTL: 86400},
+			},
+		},
+		{
+			name: "postgres mode",
+			route: config.RouteConfig{
+				Mode:      config.ModePostgres,
+				LatencyMs: 10,
+				Database:  &config.DatabaseConfig{KillQueryRate: 0.2, PartialResultRate: 0.2, PartialRows: 2},
+			},
+		},
+		{
+			name: "mysql mode",
+			route: config.RouteConfig{
+				Mode:     config.ModeMySQL,
+				DropRate: 0.1,
+				Database: &config.DatabaseConfig{KillQueryRate: 0.2, PartialResultRate: 0.2},
+			},
+		},
+		{
+			name:  "socks5 mode",
+			route: config.RouteConfig{Mode: config.ModeSOCKS5, DropRate: 0.2, LatencyMs: 10, DialFailureRate: 0.1},
+		},
+		{
+			name:  "transparent mode",
+			route: config.RouteConfig{Mode: config.ModeTransparent, DropRate: 0.2, LatencyMs: 10},
+		},
+		{
+			name:  "tls handshake chaos",
+			route: config.RouteConfig{TLS: handshakeChaos, LatencyMs: 10},
+		},
+		{
+			name: "grpc mode over tls",
+			route: config.RouteConfig{
+				Mode: config.ModeGRPC,
+				TLS:  writeTestCert(t),
+				GRPC: &config.GRPCConfig{ErrorRate: 0.2},
+			},
+		},
+		{
+			name: "fault that can never fire",
+			route: config.RouteConfig{
+				LatencyMs: 10,
+				Toxics:    []config.ToxicConfig{{Type: config.ToxicTruncate, Bytes: 10, Toxicity: &never}},
+			},
+			wantMissing: []string{"truncate"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Run(context.Background(), tt.route, 500*time.Millisecond)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if report.Requests == 0 {
+				t.Error("Run() sent no requests")
+			}
+			if report.Failed == report.Requests {
+				t.Errorf("all %d requests failed", report.Requests)
+			}
+			if !slices.Equal(report.Missing, tt.wantMissing) {
+				t.Errorf("Missing = %v, want %v (faults seen: %v)", report.Missing, tt.wantMissing, report.Faults)
+			}
+			if report.OK() != (len(tt.wantMissing) == 0) {
+				t.Errorf("OK() = %v, want %v", report.OK(), len(tt.wantMissing) == 0)
+			}
+		})
+	}
+}
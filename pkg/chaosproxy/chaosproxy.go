@@ -0,0 +1,340 @@
+// Package chaosproxy embeds a chaos proxy in a Go program, so integration
+// tests can put chaos between the code under test and its dependencies
+// in-process instead of running the chaos-proxy binary:
+//
+//	p, err := chaosproxy.New(chaosproxy.Config{Routes: []chaosproxy.Route{
+//		{Upstream: db.Addr().String(), LatencyMs: 200, DropRate: 0.1},
+//	}})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := p.Start(ctx); err != nil {
+//		t.Fatal(err)
+//	}
+//	defer p.Close()
+//	connect(p.Addr().String())
+//
+// Routes are written as in a config file and validated the same way, except
+// that localPort may be 0 for the OS to pick a free port. The proxy logs
+// through slog's default logger, as the binary does.
+package chaosproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/internal/chaos"
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// Config is the routes a Proxy serves, and how it reaches them.
+type Config struct {
+	Routes []Route
+	// Listeners serve the routes of the same index in place of listeners on
+	// their addresses, such as ones bound ahead of time or in memory. A nil
+	// entry, or a route past the end, listens as usual. Each is closed when
+	// its route stops. TCP routes only.
+	Listeners []net.Listener
+	// Dial connects every route to its upstream in place of net.Dial, such
+	// as over in-memory pipes or a custom transport. It is given the route's
+	// upstream, which must still be an IP address and port. TCP routes only.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Hooks are called as every route's connections progress.
+	Hooks Hooks
+}
+
+// Hooks are callbacks on a Proxy's connections, for a test to assert on
+// what happens to them and to veto chaos decisions. Any may be nil. They
+// are called on the connections' own goroutines, so they must be safe for
+// concurrent use, and a slow one holds up the connection it was called for.
+type Hooks struct {
+	// OnAccept is called when a client connects, before any chaos. TCP
+	// routes only.
+	OnAccept func(ConnInfo)
+	// OnUpstreamConnect is called once a connection's upstream is dialled.
+	// TCP routes only.
+	OnUpstreamConnect func(ConnInfo)
+	// OnDecision is called with every chaos decision before it is carried
+	// out. Returning false vetoes it: the fault is skipped and left out of
+	// Report.
+	OnDecision func(Decision) bool
+	// OnData is called with each chunk read from either side of a
+	// connection, before chaos touches it. TCP routes only.
+	OnData func(Chunk)
+	// OnClose is called with the record of every connection, or UDP
+	// session, once it has closed.
+	OnClose func(ConnRecord)
+}
+
+// LoadConfig loads a config file, as given to the binary's -config, for
+// New.
+func LoadConfig(path string) (Config, error) {
+	routes, err := config.LoadConfigWithOptions(path, config.LoadOptions{AllowAutoPort: true})
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{Routes: routes}, nil
+}
+
+// Proxy serves the routes of a Config. Its methods may be called
+// concurrently.
+type Proxy struct {
+	routes    []Route
+	listeners []net.Listener
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	hooks     Hooks
+	controls  []*proxy.RouteControl
+	stats     []*proxy.RouteStats
+	intensity *chaos.Intensity
+
+	mu      sync.Mutex
+	addrs   []net.Addr
+	started time.Time
+	cancel  context.CancelFunc
+	// done is closed once every route has stopped listening.
+	done chan struct{}
+}
+
+// New validates cfg and returns a Proxy for it, ready to Start. A route
+// that fails validation is reported in the error.
+func New(cfg Config) (*Proxy, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, errors.New("config has no routes")
+	}
+	if len(cfg.Listeners) > len(cfg.Routes) {
+		return nil, fmt.Errorf("config has %d listeners for %d routes", len(cfg.Listeners), len(cfg.Routes))
+	}
+	routes := make([]Route, len(cfg.Routes))
+	for i, route := range cfg.Routes {
+		if err := prepare(&route); err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		routes[i] = route
+	}
+	if err := validate(routes); err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		routes:    routes,
+		listeners: make([]net.Listener, len(routes)),
+		dial:      cfg.Dial,
+		hooks:     cfg.Hooks,
+		controls:  make([]*proxy.RouteControl, len(routes)),
+		stats:     make([]*proxy.RouteStats, len(routes)),
+		intensity: chaos.NewIntensity(1),
+		addrs:     make([]net.Addr, len(routes)),
+	}
+	copy(p.listeners, cfg.Listeners)
+	for i, route := range routes {
+		p.controls[i] = proxy.NewRouteControl(route)
+		p.stats[i] = &proxy.RouteStats{}
+	}
+	return p, nil
+}
+
+// prepare expands route's profile and rejects what only the binary runs.
+func prepare(route *Route) error {
+	if err := route.ExpandProfile(); err != nil {
+		return err
+	}
+	// The binary compares baselines and checks assertions at shutdown; an
+	// embedding program has Report for that.
+	if route.BaselinePort != 0 || route.Expect != nil {
+		return errors.New("baselinePort and expect are only run by the chaos-proxy binary; compare Report instead")
+	}
+	return nil
+}
+
+// validate validates routes as a config file's, returning the validation
+// messages in the error.
+func validate(routes []Route) error {
+	var messages bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&messages, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	if err := config.ValidateRoutes(routes, config.LoadOptions{AllowAutoPort: true}, logger); err != nil {
+		return fmt.Errorf("invalid config:\n%s", strings.TrimSpace(messages.String()))
+	}
+	return nil
+}
+
+// Start starts every route's listener and returns once they are all bound.
+// The routes serve until ctx is done or Close is called. If a route fails
+// to listen, the others are stopped and its error returned.
+func (p *Proxy) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil {
+		return errors.New("proxy already started")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	p.started = time.Now()
+
+	type bound struct {
+		route int
+		addr  net.Addr
+		err   error
+	}
+	results := make(chan bound, len(p.routes))
+	var wg sync.WaitGroup
+	for i, route := range p.routes {
+		wg.Go(func() {
+			listening := false
+			err := proxy.ServeRoute(ctx, route, proxy.ServeOptions{
+				Stats:     p.stats[i],
+				Intensity: p.intensity,
+				Control:   p.controls[i],
+				Listener:  p.listeners[i],
+				Dial:      p.dial,
+				Hooks: proxy.Hooks{
+					OnAccept:          p.hooks.OnAccept,
+					OnUpstreamConnect: p.hooks.OnUpstreamConnect,
+					OnDecision:        p.hooks.OnDecision,
+					OnData:            p.hooks.OnData,
+				},
+				OnConnClose: p.hooks.OnClose,
+				OnListen: func(addr net.Addr) {
+					listening = true
+					results <- bound{route: i, addr: addr}
+				},
+			})
+			if !listening {
+				if err == nil {
+					err = ctx.Err()
+				}
+				results <- bound{route: i, err: err}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(p.done)
+	}()
+
+	var firstErr error
+	for range p.routes {
+		result := <-results
+		if result.err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("route %d: %w", result.route, result.err)
+			cancel()
+		}
+		p.addrs[result.route] = result.addr
+	}
+	if firstErr != nil {
+		<-p.done
+		return firstErr
+	}
+	return nil
+}
+
+// Addr returns the address the first route listens on, for a Proxy of one
+// route. It is nil until Start has returned.
+func (p *Proxy) Addr() net.Addr {
+	return p.RouteAddr(0)
+}
+
+// RouteAddr returns the address the i-th route of the config listens on.
+// It is nil until Start has returned.
+func (p *Proxy) RouteAddr(i int) net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addrs[i]
+}
+
+// SetChaos switches the chaos of every route on or off. With it off, new
+// connections are proxied cleanly; open ones carry on as they were.
+func (p *Proxy) SetChaos(on bool) {
+	for _, control := range p.controls {
+		control.SetChaosEnabled(on)
+	}
+}
+
+// SetChaosScale multiplies every route's drop rates and latencies by
+// scale, as the binary's -chaos-scale does. 0 turns them off.
+func (p *Proxy) SetChaosScale(scale float64) error {
+	if scale < 0 {
+		return fmt.Errorf("chaos scale %v must be >= 0", scale)
+	}
+	p.intensity.Set(scale)
+	return nil
+}
+
+// Route returns the settings the i-th route of the config serves new
+// connections with. Its profile, if it has one, is given as the settings it
+// expanded into, so the route can be changed and passed to UpdateRoute.
+func (p *Proxy) Route(i int) Route {
+	route := p.controls[i].Route()
+	route.Profile = ""
+	return route
+}
+
+// UpdateRoute moves the i-th route of the config to route's settings
+// without closing its listener, as a config reload would, so route must
+// keep its listen address, port and protocol. New connections get the new
+// settings; open ones finish with what they started with.
+func (p *Proxy) UpdateRoute(i int, route Route) error {
+	if err := prepare(&route); err != nil {
+		return err
+	}
+	routes := make([]Route, len(p.controls))
+	for j, control := range p.controls {
+		routes[j] = control.Route()
+	}
+	routes[i] = route
+	if err := validate(routes); err != nil {
+		return err
+	}
+	return p.controls[i].Update(route)
+}
+
+// Report summarizes what every route has done since Start: connections,
+// faults injected, the delays they added and bytes forwarded.
+func (p *Proxy) Report() Report {
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+	report := Report{
+		Started: started,
+		Routes:  make([]RouteReport, len(p.controls)),
+	}
+	if !started.IsZero() {
+		report.DurationMs = time.Since(started).Milliseconds()
+	}
+	for i, control := range p.controls {
+		report.Routes[i] = proxy.NewRouteReport(control, p.stats[i])
+	}
+	return report
+}
+
+// Close stops every route's listener and resets their open connections.
+// It returns once the listeners are closed.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	for _, control := range p.controls {
+		control.KillAll()
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package chaosproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start echo server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// roundTrip sends a message through addr and reports whether it came back.
+func roundTrip(t *testing.T, addr net.Addr) bool {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return false
+	}
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	return err == nil && string(reply) == "ping"
+}
+
+func TestProxy(t *testing.T) {
+	upstream := startEchoServer(t)
+	p, err := New(Config{Routes: []Route{{Upstream: upstream.Addr().String(), DropRate: 1}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Close()
+
+	if roundTrip(t, p.Addr()) {
+		t.Error("connection got through a route dropping every connection")
+	}
+
+	p.SetChaos(false)
+	if !roundTrip(t, p.Addr()) {
+		t.Error("connection failed with chaos off")
+	}
+
+	p.SetChaos(true)
+	if err := p.UpdateRoute(0, Route{Upstream: upstream.Addr().String(), LatencyMs: 20}); err != nil {
+		t.Fatalf("UpdateRoute() error = %v", err)
+	}
+	if !roundTrip(t, p.Addr()) {
+		t.Error("connection failed after the drop was removed")
+	}
+
+	report := p.Report()
+	if len(report.Routes) != 1 {
+		t.Fatalf("Report() has %d routes, want 1", len(report.Routes))
+	}
+	route := report.Routes[0]
+	if route.Connections != 3 || route.Faults["drop"] != 1 || route.InjectedDelay["latency"].Count != 1 {
+		t.Errorf("Report() = %+v, want 3 connections, 1 drop and 1 latency", route)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := net.DialTimeout("tcp", p.Addr().String(), time.Second); err == nil {
+		t.Error("proxy still accepting after Close()")
+	}
+}
+
+func TestProxy_ListenerAndDial(t *testing.T) {
+	upstream := startEchoServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The route's upstream is only a name for the dialer to resolve.
+	p, err := New(Config{
+		Routes:    []Route{{Upstream: "10.0.0.1:5432"}},
+		Listeners: []net.Listener{listener},
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address != "10.0.0.1:5432" {
+				return nil, fmt.Errorf("dialed %s, want the route's upstream", address)
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, upstream.Addr().String())
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Close()
+
+	if p.Addr().String() != listener.Addr().String() {
+		t.Errorf("Addr() = %v, want the given listener's %v", p.Addr(), listener.Addr())
+	}
+	if !roundTrip(t, p.Addr()) {
+		t.Error("connection failed through the given listener and dialer")
+	}
+}
+
+func TestProxy_BandwidthCap(t *testing.T) {
+	upstream := startEchoServer(t)
+	// 10 KB/s shared by every connection of the route.
+	p, err := New(Config{Routes: []Route{{
+		Upstream:     upstream.Addr().String(),
+		BandwidthCap: &BandwidthCapConfig{UpstreamBytesPerSecond: 10000},
+	}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Close()
+
+	// Two connections of 2000 bytes each are 4000 on the link, which takes
+	// at least 300ms past its first tenth of a second's worth.
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Go(func() {
+			conn, err := net.DialTimeout("tcp", p.Addr().String(), time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(make([]byte, 2000)); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, 2000)); err != nil {
+				errs <- err
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("transfer through the capped route failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("4000 bytes through a 10000 B/s cap took %v, want the connections to share it", elapsed)
+	}
+}
+
+func TestProxy_Hooks(t *testing.T) {
+	upstream := startEchoServer(t)
+	var (
+		mu        sync.Mutex
+		seen      []string
+		decisions []Decision
+		fromBoth  = map[string]string{}
+		closed    = make(chan ConnRecord, 1)
+	)
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, event)
+	}
+	p, err := New(Config{
+		Routes: []Route{{Name: "db", Upstream: upstream.Addr().String(), DropRate: 1}},
+		Hooks: Hooks{
+			OnAccept:          func(ConnInfo) { record("accept") },
+			OnUpstreamConnect: func(ConnInfo) { record("upstream") },
+			OnDecision: func(d Decision) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				decisions = append(decisions, d)
+				return d.Fault != "drop"
+			},
+			OnData: func(c Chunk) {
+				mu.Lock()
+				defer mu.Unlock()
+				fromBoth[c.From] += string(c.Data)
+			},
+			OnClose: func(r ConnRecord) { closed <- r },
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer p.Close()
+
+	if !roundTrip(t, p.Addr()) {
+		t.Error("connection dropped though the drop was vetoed")
+	}
+	var r ConnRecord
+	select {
+	case r = <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(seen, ",") != "accept,upstream" {
+		t.Errorf("hooks called %v, want accept then upstream", seen)
+	}
+	if len(decisions) != 1 || decisions[0].Fault != "drop" || decisions[0].Route != "db" || decisions[0].Conn != r.Conn {
+		t.Errorf("decisions = %+v, want one drop of connection %d on route db", decisions, r.Conn)
+	}
+	if fromBoth["client"] != "ping" || fromBoth["upstream"] != "ping" {
+		t.Errorf("data = %v, want ping from the client and upstream", fromBoth)
+	}
+	if len(r.Chaos) != 0 {
+		t.Errorf("closed connection had chaos %v, want none after the veto", r.Chaos)
+	}
+	if faults := p.Report().Routes[0].Faults; faults["drop"] != 0 {
+		t.Errorf("Report() faults = %v, want no drop", faults)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	route := Route{Upstream: "127.0.0.1:5432"}
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"no routes", Config{}, "no routes"},
+		{"hostname upstream", Config{Routes: []Route{{Upstream: "localhost:5432"}}}, "upstream"},
+		{"unknown profile", Config{Routes: []Route{{Upstream: "127.0.0.1:5432", Profile: "nope"}}}, "unknown chaos profile"},
+		{"baseline", Config{Routes: []Route{{Upstream: "127.0.0.1:5432", BaselinePort: 9000}}}, "baselinePort"},
+		{"too many listeners", Config{Routes: []Route{route}, Listeners: make([]net.Listener, 2)}, "listeners"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStart_PortInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	upstream := startEchoServer(t)
+	p, err := New(Config{Routes: []Route{
+		{Upstream: upstream.Addr().String()},
+		{LocalPort: taken.Addr().(*net.TCPAddr).Port, Upstream: upstream.Addr().String()},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "route 1") {
+		t.Fatalf("Start() error = %v, want route 1 failing to listen", err)
+	}
+	if addr := p.RouteAddr(0); addr != nil {
+		if _, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+			t.Error("route 0 still accepting after route 1 failed to start")
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package chaosproxytest starts chaos proxies for Go tests: on a free port,
+// ready to dial when New returns, and stopped when the test ends.
+//
+//	addr, p := chaosproxytest.New(t, db.Addr().String(), chaosproxytest.WithLatency(200*time.Millisecond))
+//	connect(addr)
+//	p.Set(chaosproxytest.WithDropRate(1))
+package chaosproxytest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chasewilson/chaos-proxy/pkg/chaosproxy"
+)
+
+// Option changes the route a test proxy serves. Any field of the route can
+// be set with an Option of one's own.
+type Option func(*chaosproxy.Route)
+
+// WithLatency delays every connection by d before it is forwarded.
+func WithLatency(d time.Duration) Option {
+	return func(r *chaosproxy.Route) { r.LatencyMs = chaosproxy.Milliseconds(d.Milliseconds()) }
+}
+
+// WithDropRate drops this fraction, from 0 to 1, of new connections.
+func WithDropRate(rate float64) Option {
+	return func(r *chaosproxy.Route) { r.DropRate = rate }
+}
+
+// WithProfile gives the route a built-in chaos profile, such as
+// "flaky-wifi". Settings given by other options win over the profile's.
+func WithProfile(name string) Option {
+	return func(r *chaosproxy.Route) { r.Profile = name }
+}
+
+// WithMode serves the route in mode, such as chaosproxy.ModeHTTP.
+func WithMode(mode string) Option {
+	return func(r *chaosproxy.Route) { r.Mode = mode }
+}
+
+// Proxy is a chaos proxy started for a test, with one route.
+type Proxy struct {
+	*chaosproxy.Proxy
+	t testing.TB
+}
+
+// New starts a chaos proxy forwarding to upstreamAddr, an IP address and
+// port, on a free loopback port, and returns the address to dial along
+// with the proxy. The proxy is closed when the test ends. A proxy that
+// fails to start fails the test.
+func New(t testing.TB, upstreamAddr string, opts ...Option) (string, *Proxy) {
+	t.Helper()
+	route := chaosproxy.Route{ListenAddress: "127.0.0.1", Upstream: upstreamAddr}
+	for _, opt := range opts {
+		opt(&route)
+	}
+	p, err := chaosproxy.New(chaosproxy.Config{Routes: []chaosproxy.Route{route}})
+	if err != nil {
+		t.Fatalf("chaosproxytest: %v", err)
+	}
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("chaosproxytest: failed to start proxy: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p.Addr().String(), &Proxy{Proxy: p, t: t}
+}
+
+// Set changes the route's settings with opts, for new connections; open
+// ones carry on as they were. Settings opts leave alone are kept. A change
+// the proxy rejects fails the test.
+func (p *Proxy) Set(opts ...Option) {
+	p.t.Helper()
+	route := p.Route(0)
+	for _, opt := range opts {
+		opt(&route)
+	}
+	if err := p.UpdateRoute(0, route); err != nil {
+		p.t.Fatalf("chaosproxytest: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package chaosproxytest
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start echo server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// echoes reports whether a message sent through addr comes back.
+func echoes(t *testing.T, addr string) bool {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return false
+	}
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	return err == nil && string(reply) == "ping"
+}
+
+func TestNew(t *testing.T) {
+	upstream := startEchoServer(t)
+	var addr string
+	t.Run("proxy", func(t *testing.T) {
+		var p *Proxy
+		addr, p = New(t, upstream.Addr().String(), WithLatency(50*time.Millisecond))
+
+		start := time.Now()
+		if !echoes(t, addr) {
+			t.Fatal("connection failed through the proxy")
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("round trip took %v, want at least the 50ms latency", elapsed)
+		}
+
+		p.Set(WithDropRate(1))
+		if echoes(t, addr) {
+			t.Error("connection got through after the drop rate was set to 1")
+		}
+		if got := p.Route(0).LatencyMs; got != 50 {
+			t.Errorf("latency after Set(WithDropRate) = %dms, want the 50ms kept", got)
+		}
+
+		p.SetChaos(false)
+		if !echoes(t, addr) {
+			t.Error("connection failed with chaos off")
+		}
+	})
+
+	// The subtest's cleanup closed its proxy.
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("proxy still accepting after its test ended")
+	}
+}
@@ -0,0 +1,72 @@
+package chaosproxy
+
+import (
+	"github.com/chasewilson/chaos-proxy/internal/config"
+	"github.com/chasewilson/chaos-proxy/internal/proxy"
+)
+
+// Route is one route, with the fields of a config file's routes; see the
+// README's Configuration section for what each does.
+type Route = config.RouteConfig
+
+// The settings a Route is built from, named as in the config file.
+type (
+	Milliseconds        = config.Milliseconds
+	ToxicConfig         = config.ToxicConfig
+	BurstLossConfig     = config.BurstLossConfig
+	TarpitConfig        = config.TarpitConfig
+	BandwidthCapConfig  = config.BandwidthCapConfig
+	LoadPoint           = config.LoadPoint
+	HTTPConfig          = config.HTTPConfig
+	HTTPMatch           = config.HTTPMatch
+	HTTPBodyConfig      = config.HTTPBodyConfig
+	HeaderRule          = config.HeaderRule
+	HTTP2Config         = config.HTTP2Config
+	GRPCConfig          = config.GRPCConfig
+	DNSConfig           = config.DNSConfig
+	DatabaseConfig      = config.DatabaseConfig
+	StubConfig          = config.StubConfig
+	RecordConfig        = config.RecordConfig
+	ReplayConfig        = config.ReplayConfig
+	TLSConfig           = config.TLSConfig
+	TLSHandshakeConfig  = config.TLSHandshakeConfig
+	UpstreamTLSConfig   = config.UpstreamTLSConfig
+	ProxyProtocolConfig = config.ProxyProtocolConfig
+	PayloadLogConfig    = config.PayloadLogConfig
+	PortRule            = config.PortRule
+	PortRange           = config.PortRange
+)
+
+// Modes of a Route.
+const (
+	ModeTCP         = config.ModeTCP
+	ModeHTTP        = config.ModeHTTP
+	ModeHTTP2       = config.ModeHTTP2
+	ModeGRPC        = config.ModeGRPC
+	ModeDNS         = config.ModeDNS
+	ModePostgres    = config.ModePostgres
+	ModeMySQL       = config.ModeMySQL
+	ModeSOCKS5      = config.ModeSOCKS5
+	ModeTransparent = config.ModeTransparent
+)
+
+// Protocols of a Route.
+const (
+	ProtocolTCP = config.ProtocolTCP
+	ProtocolUDP = config.ProtocolUDP
+)
+
+// Summaries returned by Proxy.Report.
+type (
+	Report      = proxy.Report
+	RouteReport = proxy.RouteReport
+	DelayReport = proxy.DelayReport
+)
+
+// What Hooks are called with.
+type (
+	ConnInfo   = proxy.ConnInfo
+	Decision   = proxy.Decision
+	Chunk      = proxy.Chunk
+	ConnRecord = proxy.ConnRecord
+)
@@ -0,0 +1,178 @@
+// Package chaosproxy runs chaos-proxy in a container with testcontainers-go,
+// so tests that run their dependencies in containers put the chaos between
+// them under the same lifecycle:
+//
+//	dbIP, err := db.ContainerIP(ctx)
+//	...
+//	proxy, err := chaosproxy.Run(ctx, chaosproxy.DefaultImage,
+//		chaosproxy.WithRoutes(chaosproxy.Route{LocalPort: 5432, Upstream: dbIP + ":5432", LatencyMs: 200}))
+//	testcontainers.CleanupContainer(t, proxy)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	addr, err := proxy.RouteEndpoint(ctx, 5432)
+//	...
+//	err = proxy.SetToxics(ctx, 5432, chaosproxy.ToxicConfig{Type: "bandwidth", BytesPerSecond: 16 << 10})
+//
+// Routes are written as in a config file. Each needs a localPort, which is
+// exposed from the container, and an upstream the container can reach by
+// IP, such as another container's. The image is built from the
+// repository's Dockerfile.
+package chaosproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/chasewilson/chaos-proxy/adminclient"
+)
+
+const (
+	// DefaultImage is the image the repository's Dockerfile builds with
+	// `docker build -t chaos-proxy .`.
+	DefaultImage = "chaos-proxy:latest"
+
+	// AdminPort is the container port of the proxy's admin API.
+	AdminPort = "9900/tcp"
+
+	// adminAddr is where the admin API listens in the container.
+	adminAddr = "0.0.0.0:9900"
+
+	// configPath is where the routes are written in the container.
+	configPath = "/etc/chaos-proxy/config.json"
+)
+
+// Container is a running chaos-proxy container.
+type Container struct {
+	testcontainers.Container
+
+	// ports are the container ports of the routes, by localPort.
+	ports map[int]string
+}
+
+// Run starts a chaos-proxy container from img serving the routes given
+// with WithRoutes, and returns once its admin API answers. Other options
+// customize the container as for any testcontainers-go container, such as
+// testcontainers.WithCmdArgs("-verbose") for more flags.
+func Run(ctx context.Context, img string, opts ...testcontainers.ContainerCustomizer) (*Container, error) {
+	var settings options
+	for _, opt := range opts {
+		if apply, ok := opt.(Option); ok {
+			if err := apply(&settings); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(settings.routes) == 0 {
+		return nil, errors.New("chaosproxy: no routes; give them with WithRoutes")
+	}
+
+	config, err := json.MarshalIndent(settings.routes, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("chaosproxy: marshal routes: %w", err)
+	}
+	ports := make(map[int]string, len(settings.routes))
+	exposed := []string{AdminPort}
+	for _, route := range settings.routes {
+		ports[route.LocalPort] = containerPort(route)
+		exposed = append(exposed, ports[route.LocalPort])
+	}
+
+	moduleOpts := []testcontainers.ContainerCustomizer{
+		testcontainers.WithExposedPorts(exposed...),
+		testcontainers.WithFiles(testcontainers.ContainerFile{
+			Reader:            bytes.NewReader(config),
+			ContainerFilePath: configPath,
+			FileMode:          0o644,
+		}),
+		testcontainers.WithCmd("-config", configPath, "-admin", adminAddr),
+		testcontainers.WithWaitStrategy(wait.ForHTTP("/routes").WithPort(AdminPort).WithStatusCodeMatcher(func(status int) bool {
+			return status == http.StatusOK
+		})),
+	}
+	ctr, err := testcontainers.Run(ctx, img, append(moduleOpts, opts...)...)
+	var c *Container
+	if ctr != nil {
+		c = &Container{Container: ctr, ports: ports}
+	}
+	if err != nil {
+		return c, fmt.Errorf("chaosproxy: run: %w", err)
+	}
+	return c, nil
+}
+
+// containerPort is the container port route listens on, as exposed.
+func containerPort(route Route) string {
+	if route.IsUDP() {
+		return strconv.Itoa(route.LocalPort) + "/udp"
+	}
+	return strconv.Itoa(route.LocalPort) + "/tcp"
+}
+
+// RouteEndpoint returns the host:port the route on localPort is reached at
+// from the host.
+func (c *Container) RouteEndpoint(ctx context.Context, localPort int) (string, error) {
+	port, ok := c.ports[localPort]
+	if !ok {
+		return "", fmt.Errorf("chaosproxy: no route on port %d", localPort)
+	}
+	return c.PortEndpoint(ctx, port, "")
+}
+
+// AdminURL returns the base URL of the proxy's admin API from the host.
+func (c *Container) AdminURL(ctx context.Context) (string, error) {
+	return c.PortEndpoint(ctx, AdminPort, "http")
+}
+
+// Admin returns a client for the proxy's admin API, for anything the
+// helpers below don't cover.
+func (c *Container) Admin(ctx context.Context) (*adminclient.Client, error) {
+	url, err := c.AdminURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return adminclient.New(url), nil
+}
+
+// SetToxics replaces the toxics of the route on localPort. New connections
+// get them; open ones keep the ones they started with. No toxics clears
+// them.
+func (c *Container) SetToxics(ctx context.Context, localPort int, toxics ...ToxicConfig) error {
+	admin, err := c.Admin(ctx)
+	if err != nil {
+		return err
+	}
+	if toxics == nil {
+		toxics = []ToxicConfig{}
+	}
+	_, err = admin.PatchRouteConfig(ctx, localPort, map[string]any{"toxics": toxics})
+	return err
+}
+
+// SetChaosScale multiplies every route's drop rates and latencies by scale,
+// as the binary's -chaos-scale does. 0 turns them off.
+func (c *Container) SetChaosScale(ctx context.Context, scale float64) error {
+	admin, err := c.Admin(ctx)
+	if err != nil {
+		return err
+	}
+	return admin.SetChaosScale(ctx, scale)
+}
+
+// Report returns the run's summary so far: every route's connections,
+// faults injected and bytes forwarded.
+func (c *Container) Report(ctx context.Context) (Report, error) {
+	admin, err := c.Admin(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	return admin.GetReport(ctx)
+}
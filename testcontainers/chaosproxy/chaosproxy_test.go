@@ -0,0 +1,114 @@
+package chaosproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestWithRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		routes     []Route
+		wantErr    string
+		wantListen []string
+	}{
+		{
+			name:       "listens on every interface",
+			routes:     []Route{{LocalPort: 8080, Upstream: "10.0.0.5:5432"}},
+			wantListen: []string{"0.0.0.0"},
+		},
+		{
+			name:       "keeps a listen address",
+			routes:     []Route{{LocalPort: 8080, Upstream: "10.0.0.5:5432", ListenAddress: "127.0.0.1"}},
+			wantListen: []string{"127.0.0.1"},
+		},
+		{
+			name:    "needs a port to expose",
+			routes:  []Route{{Upstream: "10.0.0.5:5432"}},
+			wantErr: "no localPort",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o options
+			err := WithRoutes(tt.routes...)(&o)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("WithRoutes() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WithRoutes() error = %v", err)
+			}
+			for i, route := range o.routes {
+				if route.ListenAddress != tt.wantListen[i] {
+					t.Errorf("route %d listenAddress = %q, want %q", i, route.ListenAddress, tt.wantListen[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRun_NoRoutes(t *testing.T) {
+	if _, err := Run(context.Background(), DefaultImage); err == nil || !strings.Contains(err.Error(), "no routes") {
+		t.Fatalf("Run() error = %v, want no routes", err)
+	}
+}
+
+// TestRun builds the image from the repository's Dockerfile and proxies to
+// the container's own admin API, so it needs nothing else running.
+func TestRun(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	proxy, err := Run(ctx, "",
+		testcontainers.WithDockerfile(testcontainers.FromDockerfile{Context: "../..", KeepImage: true}),
+		WithRoutes(Route{Name: "admin", LocalPort: 8080, Upstream: "127.0.0.1:9900"}))
+	testcontainers.CleanupContainer(t, proxy)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	endpoint, err := proxy.RouteEndpoint(ctx, 8080)
+	if err != nil {
+		t.Fatalf("RouteEndpoint() error = %v", err)
+	}
+	// Each request gets a new connection, so the toxics set in between
+	// apply to it.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() time.Duration {
+		t.Helper()
+		start := time.Now()
+		resp, err := client.Get("http://" + endpoint + "/routes")
+		if err != nil {
+			t.Fatalf("GET through the route: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET through the route = %d, want 200", resp.StatusCode)
+		}
+		return time.Since(start)
+	}
+	get()
+
+	if err := proxy.SetToxics(ctx, 8080, ToxicConfig{Type: "latency", LatencyMs: 300}); err != nil {
+		t.Fatalf("SetToxics() error = %v", err)
+	}
+	if took := get(); took < 300*time.Millisecond {
+		t.Errorf("request took %v with a 300ms latency toxic", took)
+	}
+
+	report, err := proxy.Report(ctx)
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if len(report.Routes) != 1 || report.Routes[0].Faults["latency"] == 0 {
+		t.Errorf("Report() = %+v, want the latency toxic counted", report)
+	}
+}
@@ -0,0 +1,53 @@
+package chaosproxy
+
+import (
+	"fmt"
+
+	"github.com/testcontainers/testcontainers-go"
+
+	"github.com/chasewilson/chaos-proxy/adminclient"
+	"github.com/chasewilson/chaos-proxy/pkg/chaosproxy"
+)
+
+// The settings routes are built from, named as in the config file.
+type (
+	Route       = chaosproxy.Route
+	ToxicConfig = chaosproxy.ToxicConfig
+)
+
+// Report is the run summary returned by Container.Report.
+type Report = adminclient.Report
+
+// options are what Run gathers from its Options.
+type options struct {
+	routes []Route
+}
+
+// Option configures the proxy a container runs. It is a
+// testcontainers.ContainerCustomizer, so it is given to Run with the rest.
+type Option func(*options) error
+
+var _ testcontainers.ContainerCustomizer = Option(nil)
+
+// Customize does nothing: Run applies Options itself.
+func (o Option) Customize(*testcontainers.GenericContainerRequest) error {
+	return nil
+}
+
+// WithRoutes adds routes for the proxy to serve. Each needs a localPort to
+// expose, and listens on every interface of the container unless it sets
+// listenAddress.
+func WithRoutes(routes ...Route) Option {
+	return func(o *options) error {
+		for _, route := range routes {
+			if route.LocalPort == 0 {
+				return fmt.Errorf("chaosproxy: route to %s has no localPort to expose from the container", route.Upstream)
+			}
+			if route.ListenAddress == "" {
+				route.ListenAddress = "0.0.0.0"
+			}
+			o.routes = append(o.routes, route)
+		}
+		return nil
+	}
+}